@@ -0,0 +1,97 @@
+package roadrunner
+
+import (
+	"fmt"
+)
+
+// AffinityStrategy defines how CPU cores are assigned to the worker slots, see CPUAffinity.
+type AffinityStrategy int
+
+const (
+	// AffinityOff keeps workers on any core, default.
+	AffinityOff AffinityStrategy = iota
+
+	// AffinityExclusive pins every worker slot to a core of its own, slot index selects the core.
+	// Workers of the slots above the number of cores are not pinned.
+	AffinityExclusive
+
+	// AffinityRoundRobin pins worker slots to the cores round-robin, cores are shared by several
+	// workers once pool has more workers than cores.
+	AffinityRoundRobin
+)
+
+// CPUAffinity pins worker processes to the CPU cores to reduce scheduling jitter and improve
+// cache locality of the latency critical workers. Core is selected by the worker slot index,
+// replacement worker is pinned to the core of the worker it replaces. Workers spawned by
+// ExecFresh are not pinned. Affinity is applied right after the process start to all threads of
+// the process, threads inherit it afterwards. Supported on Linux only and ignored on other
+// platforms.
+type CPUAffinity struct {
+	// Strategy of the core assignment, AffinityOff disables pinning.
+	Strategy AffinityStrategy
+
+	// Cores lists CPU cores available to the workers, cores the server process is allowed to
+	// run on are used when empty.
+	Cores []int
+}
+
+// valid returns error if affinity can not be applied.
+func (a CPUAffinity) valid() error {
+	if a.Strategy < AffinityOff || a.Strategy > AffinityRoundRobin {
+		return fmt.Errorf("pool.CPUAffinity.Strategy is invalid")
+	}
+
+	for _, core := range a.Cores {
+		if core < 0 || core >= maxCores {
+			return fmt.Errorf("pool.CPUAffinity.Cores must be within [0, %v)", maxCores)
+		}
+	}
+
+	return nil
+}
+
+// core returns core assigned to the worker slot with the given index out of available cores,
+// false if worker must not be pinned.
+func (a CPUAffinity) core(index int, cores []int) (int, bool) {
+	if index < 0 || len(cores) == 0 {
+		return 0, false
+	}
+
+	switch a.Strategy {
+	case AffinityExclusive:
+		if index < len(cores) {
+			return cores[index], true
+		}
+	case AffinityRoundRobin:
+		return cores[index%len(cores)], true
+	}
+
+	return 0, false
+}
+
+// pin pins the worker to the core assigned to its slot, worker is killed if affinity can not be
+// applied.
+func (a CPUAffinity) pin(w *Worker, index int) error {
+	if a.Strategy == AffinityOff {
+		return nil
+	}
+
+	cores := a.Cores
+	if len(cores) == 0 {
+		cores = availableCores()
+	}
+
+	core, ok := a.core(index, cores)
+	if !ok {
+		return nil
+	}
+
+	if err := setAffinity(*w.Pid, core); err != nil {
+		_ = w.Kill()
+		_ = w.Wait()
+
+		return fmt.Errorf("unable to pin worker to core %v: %s", core, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+// +build linux
+
+package roadrunner
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+// maxCores is the number of cores affinity mask can address.
+const maxCores = 1024
+
+// cpuMask is the affinity mask of the sched_setaffinity call.
+type cpuMask [maxCores / 64]uint64
+
+// setAffinity pins all threads of the process with the given pid to the core.
+func setAffinity(pid int, core int) error {
+	var mask cpuMask
+	mask[core/64] |= 1 << uint(core%64)
+
+	tasks, err := ioutil.ReadDir(fmt.Sprintf("/proc/%v/task", pid))
+	if err != nil {
+		return schedSetAffinity(pid, &mask)
+	}
+
+	for _, task := range tasks {
+		tid, err := strconv.Atoi(task.Name())
+		if err != nil {
+			continue
+		}
+
+		// thread might exit in the meantime
+		if err := schedSetAffinity(tid, &mask); err != nil && err != syscall.ESRCH {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// availableCores returns cores the current process is allowed to run on.
+func availableCores() (cores []int) {
+	var mask cpuMask
+	if err := schedGetAffinity(0, &mask); err != nil {
+		return nil
+	}
+
+	for core := 0; core < maxCores; core++ {
+		if mask[core/64]&(1<<uint(core%64)) != 0 {
+			cores = append(cores, core)
+		}
+	}
+
+	return cores
+}
+
+// schedSetAffinity sets affinity mask of the thread.
+func schedSetAffinity(tid int, mask *cpuMask) error {
+	_, _, errno := syscall.RawSyscall(
+		syscall.SYS_SCHED_SETAFFINITY,
+		uintptr(tid),
+		unsafe.Sizeof(*mask),
+		uintptr(unsafe.Pointer(mask)),
+	)
+
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+// schedGetAffinity reads affinity mask of the thread.
+func schedGetAffinity(tid int, mask *cpuMask) error {
+	_, _, errno := syscall.RawSyscall(
+		syscall.SYS_SCHED_GETAFFINITY,
+		uintptr(tid),
+		unsafe.Sizeof(*mask),
+		uintptr(unsafe.Pointer(mask)),
+	)
+
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
@@ -0,0 +1,57 @@
+// +build linux
+
+package roadrunner
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// allowedCores returns Cpus_allowed_list of the given process as listed in /proc/pid/status.
+func allowedCores(t *testing.T, pid int) string {
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%v/status", pid))
+	assert.NoError(t, err)
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "Cpus_allowed_list:") {
+			return strings.TrimSpace(strings.TrimPrefix(line, "Cpus_allowed_list:"))
+		}
+	}
+
+	return ""
+}
+
+func Test_CPUAffinity_Pin(t *testing.T) {
+	cores := availableCores()
+	assert.NotEmpty(t, cores)
+
+	last := cores[len(cores)-1]
+	for index := 0; index < 2; index++ {
+		w, _ := newWorker(exec.Command("sleep", "10"))
+		assert.NoError(t, w.start())
+
+		a := CPUAffinity{Strategy: AffinityRoundRobin, Cores: []int{last}}
+		assert.NoError(t, a.pin(w, index))
+		assert.Equal(t, strconv.Itoa(last), allowedCores(t, *w.Pid))
+
+		assert.NoError(t, w.Kill())
+	}
+}
+
+func Test_CPUAffinity_Pin_Error(t *testing.T) {
+	w, _ := newWorker(exec.Command("sleep", "10"))
+	assert.NoError(t, w.start())
+
+	// core is not available
+	err := CPUAffinity{Strategy: AffinityExclusive, Cores: []int{maxCores - 1}}.pin(w, 0)
+	assert.Error(t, err)
+
+	// worker is killed
+	assert.Equal(t, syscall.ESRCH, syscall.Kill(*w.Pid, 0))
+}
@@ -0,0 +1,16 @@
+// +build !linux
+
+package roadrunner
+
+// maxCores is the number of cores affinity can address.
+const maxCores = 1024
+
+// setAffinity does nothing, CPU affinity is supported on Linux only.
+func setAffinity(pid int, core int) error {
+	return nil
+}
+
+// availableCores returns no cores, CPU affinity is supported on Linux only.
+func availableCores() []int {
+	return nil
+}
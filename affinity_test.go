@@ -0,0 +1,51 @@
+package roadrunner
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func Test_CPUAffinity_Core(t *testing.T) {
+	cores := []int{2, 3}
+
+	cases := []struct {
+		strategy AffinityStrategy
+		index    int
+		core     int
+		pinned   bool
+	}{
+		{strategy: AffinityOff, index: 0},
+		{strategy: AffinityExclusive, index: 0, core: 2, pinned: true},
+		{strategy: AffinityExclusive, index: 1, core: 3, pinned: true},
+		{strategy: AffinityExclusive, index: 2},
+		{strategy: AffinityRoundRobin, index: 2, core: 2, pinned: true},
+		{strategy: AffinityRoundRobin, index: 5, core: 3, pinned: true},
+		{strategy: AffinityRoundRobin, index: FreshWorkerIndex},
+	}
+
+	for _, c := range cases {
+		core, pinned := CPUAffinity{Strategy: c.strategy}.core(c.index, cores)
+		assert.Equal(t, c.pinned, pinned, c)
+		assert.Equal(t, c.core, core, c)
+	}
+}
+
+func Test_CPUAffinity_Valid(t *testing.T) {
+	assert.NoError(t, CPUAffinity{}.valid())
+	assert.NoError(t, CPUAffinity{Strategy: AffinityRoundRobin, Cores: []int{0, 1}}.valid())
+
+	err := CPUAffinity{Strategy: AffinityStrategy(5)}.valid()
+	assert.Error(t, err)
+	assert.Equal(t, "pool.CPUAffinity.Strategy is invalid", err.Error())
+
+	cfg := Config{
+		NumWorkers:      1,
+		AllocateTimeout: time.Second,
+		DestroyTimeout:  time.Second,
+		CPUAffinity:     CPUAffinity{Strategy: AffinityExclusive, Cores: []int{-1}},
+	}
+	err = cfg.Valid()
+	assert.Error(t, err)
+	assert.Equal(t, "pool.CPUAffinity.Cores must be within [0, 1024)", err.Error())
+}
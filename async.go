@@ -0,0 +1,137 @@
+package roadrunner
+
+import (
+	"fmt"
+	json "github.com/json-iterator/go"
+	"github.com/spiral/goridge/v2"
+	"time"
+)
+
+// asyncCommand precedes the context and body frames of the task executed by ExecAsync. Worker must
+// acknowledge receipt of the task with {"ack":true} control frame as soon as the body frame is
+// read and respond to the task as usual once it completes, host discards the response. Worker
+// might reject the task by responding with the error frame instead of the acknowledgement. Worker
+// receives no other frame until the response is sent.
+type asyncCommand struct {
+	Async bool `json:"async"`
+}
+
+// ackCommand acknowledges receipt of the async task, see asyncCommand.
+type ackCommand struct {
+	Ack bool `json:"ack"`
+}
+
+// ExecAsync sends payload to the worker and returns once worker acknowledged receipt of the task,
+// see asyncCommand for the protocol. Worker stays busy (StateWorking) until it completes the task,
+// done receives the task error once worker is ready for the next task or failed, response is
+// discarded. Worker which did not acknowledge the task is marked as errored, rejected task is
+// returned as JobError and keeps the worker ready. Not supported by multiplexed workers.
+func (w *Worker) ExecAsync(rqs *Payload) (done <-chan error, err error) {
+	pending, err := w.execAsync(rqs)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		result <- (<-pending).err
+	}()
+
+	return result, nil
+}
+
+// execAsync executes the task like ExecAsync, pending receives the task response.
+func (w *Worker) execAsync(rqs *Payload) (pending <-chan execResult, err error) {
+	if w.mux != nil {
+		return nil, fmt.Errorf("async tasks are not supported by multiplexed workers")
+	}
+
+	w.mu.Lock()
+
+	if rqs == nil {
+		w.mu.Unlock()
+		return nil, fmt.Errorf("payload can not be empty")
+	}
+
+	if err := w.checkPayload(rqs); err != nil {
+		w.mu.Unlock()
+		return nil, err
+	}
+
+	if w.state.Value() != StateReady {
+		w.mu.Unlock()
+		return nil, fmt.Errorf("worker is not ready (%s)", w.state.String())
+	}
+
+	w.state.set(StateWorking)
+
+	start := time.Now()
+	if err := w.sendAsync(rqs); err != nil {
+		if _, ok := err.(JobError); ok {
+			w.state.set(StateReady)
+		} else {
+			w.state.set(StateErrored)
+		}
+
+		w.state.registerExec()
+		w.mu.Unlock()
+		return nil, err
+	}
+
+	// worker is locked until the task completes
+	done := make(chan execResult, 1)
+	go func() {
+		rsp, err := w.receivePayload()
+		w.latency.observe(time.Since(start))
+
+		if _, ok := err.(JobError); err != nil && !ok {
+			w.state.set(StateErrored)
+		} else {
+			w.state.set(StateReady)
+		}
+
+		w.state.registerExec()
+		w.mu.Unlock()
+
+		done <- execResult{rsp: rsp, err: err}
+	}()
+
+	return done, nil
+}
+
+// sendAsync sends the task preceded by the async command and waits for the acknowledgement.
+func (w *Worker) sendAsync(rqs *Payload) error {
+	if err := sendControl(w.rl, asyncCommand{Async: true}); err != nil {
+		return w.wrapError(err, "header error")
+	}
+
+	if err := w.sendPayload(rqs); err != nil {
+		return err
+	}
+
+	return w.receiveAck("async task")
+}
+
+// receiveAck waits for the acknowledgement of the command, rejection is returned as JobError.
+func (w *Worker) receiveAck(command string) error {
+	data, pr, err := w.receiveFrame()
+	if err != nil {
+		return w.receiveError(err)
+	}
+
+	if pr.HasFlag(goridge.PayloadControl) && pr.HasFlag(goridge.PayloadError) {
+		if err := w.checkStream(); err != nil {
+			return err
+		}
+
+		w.request.Store("")
+		return JobError(data)
+	}
+
+	ack := ackCommand{}
+	if !pr.HasFlag(goridge.PayloadControl) || json.Unmarshal(data, &ack) != nil || !ack.Ack {
+		return w.wrapError(fmt.Errorf("%s is not acknowledged", command), "worker error")
+	}
+
+	return nil
+}
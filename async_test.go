@@ -0,0 +1,92 @@
+package roadrunner
+
+import (
+	"github.com/spiral/goridge/v2"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func Test_Worker_ExecAsync(t *testing.T) {
+	complete := make(chan struct{})
+	w := relayWorker(t, func(rl goridge.Relay) {
+		// relayWorker received the async command and the context, body is the third frame
+		body, _, err := rl.Receive()
+		if err != nil {
+			return
+		}
+		assert.Equal(t, "hello", string(body))
+
+		// acknowledged once the whole task is received
+		_ = rl.Send([]byte(`{"ack":true}`), goridge.PayloadControl)
+
+		<-complete
+		_ = rl.Send([]byte("{}"), goridge.PayloadControl)
+		_ = rl.Send([]byte("done"), goridge.PayloadRaw)
+
+		respondTask(rl, "next")
+	})
+	defer w.Kill()
+
+	done, err := w.ExecAsync(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+
+	// worker is busy until task completes
+	assert.Equal(t, StateWorking, w.State().Value())
+	select {
+	case <-done:
+		t.Fatal("async task must not complete before the worker response")
+	case <-time.After(time.Millisecond * 100):
+	}
+
+	close(complete)
+	assert.NoError(t, <-done)
+	assert.Equal(t, StateReady, w.State().Value())
+	assert.Equal(t, int64(1), w.State().NumExecs())
+
+	res, err := w.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, "next", res.String())
+}
+
+func Test_Worker_ExecAsync_Rejected(t *testing.T) {
+	w := relayWorker(t, func(rl goridge.Relay) {
+		// body, the third frame of the async task
+		if _, _, err := rl.Receive(); err != nil {
+			return
+		}
+
+		_ = rl.Send([]byte("queue is full"), goridge.PayloadControl|goridge.PayloadError)
+		respondTask(rl, "next")
+	})
+	defer w.Kill()
+
+	_, err := w.ExecAsync(&Payload{Body: []byte("hello")})
+	assert.Error(t, err)
+	assert.IsType(t, JobError{}, err)
+	assert.Equal(t, "queue is full", err.Error())
+	assert.Equal(t, StateReady, w.State().Value())
+
+	res, err := w.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, "next", res.String())
+}
+
+func Test_Worker_ExecAsync_NotAcknowledged(t *testing.T) {
+	w := relayWorker(t, func(rl goridge.Relay) {
+		// body, the third frame of the async task
+		if _, _, err := rl.Receive(); err != nil {
+			return
+		}
+
+		// worker does not support async tasks
+		_ = rl.Send([]byte("{}"), goridge.PayloadControl)
+		_ = rl.Send([]byte("done"), goridge.PayloadRaw)
+	})
+	defer w.Kill()
+
+	_, err := w.ExecAsync(&Payload{Body: []byte("hello")})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "async task is not acknowledged")
+	assert.Equal(t, StateErrored, w.State().Value())
+}
@@ -0,0 +1,33 @@
+// +build !windows
+
+package roadrunner
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// setBacklog changes connection backlog of the listening socket, listen call on the listening
+// socket updates the backlog.
+func setBacklog(ls net.Listener, backlog int) error {
+	sc, ok := ls.(syscall.Conn)
+	if !ok {
+		return fmt.Errorf("listener does not support backlog (%s)", ls.Addr().Network())
+	}
+
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var lErr error
+	if err := rc.Control(func(fd uintptr) {
+		lErr = syscall.Listen(int(fd), backlog)
+	}); err != nil {
+		return err
+	}
+
+	return os.NewSyscallError("listen", lErr)
+}
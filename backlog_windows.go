@@ -0,0 +1,13 @@
+// +build windows
+
+package roadrunner
+
+import (
+	"fmt"
+	"net"
+)
+
+// setBacklog is not supported on windows.
+func setBacklog(ls net.Listener, backlog int) error {
+	return fmt.Errorf("listener backlog is not supported on windows")
+}
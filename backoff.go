@@ -0,0 +1,136 @@
+package roadrunner
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Backoff defines delays of the crashed worker respawns, see Config.RespawnBackoff.
+type Backoff interface {
+	// Next returns delay before the given respawn attempt, attempts are counted from 1 since the
+	// worker of the slot has been stable.
+	Next(attempt int) time.Duration
+}
+
+// FixedBackoff delays every respawn by the same duration.
+type FixedBackoff time.Duration
+
+// Next returns the fixed delay.
+func (b FixedBackoff) Next(attempt int) time.Duration {
+	return time.Duration(b)
+}
+
+// ExponentialBackoff multiplies the delay every attempt starting from Initial up to Max.
+type ExponentialBackoff struct {
+	// Initial delay of the first attempt.
+	Initial time.Duration
+
+	// Max caps the delay, 0 for no cap.
+	Max time.Duration
+
+	// Multiplier of the delay between two attempts, 0 for 2.
+	Multiplier float64
+
+	// Jitter shortens the delay by a random fraction up to the given one, so slots crashed at
+	// once do not respawn at once. 0.5 spreads the delay between half and full delay. Set 0 to
+	// disable.
+	Jitter float64
+
+	// Rand returns pseudo-random number in [0.0,1.0) for the jitter, nil for math/rand.
+	Rand func() float64
+}
+
+// Next returns the delay of the attempt.
+func (b ExponentialBackoff) Next(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+
+	multiplier := b.Multiplier
+	if multiplier == 0 {
+		multiplier = 2
+	}
+
+	d := float64(b.Initial) * math.Pow(multiplier, float64(attempt-1))
+	if b.Max != 0 && d > float64(b.Max) {
+		d = float64(b.Max)
+	}
+
+	if jitter := math.Min(b.Jitter, 1); jitter > 0 {
+		random := rand.Float64
+		if b.Rand != nil {
+			random = b.Rand
+		}
+
+		d -= d * jitter * random()
+	}
+
+	if d >= math.MaxInt64 {
+		return math.MaxInt64
+	}
+
+	return time.Duration(d)
+}
+
+// respawnBackoff delays respawns of the crashed worker slots, attempts are counted by slot.
+type respawnBackoff struct {
+	// nil to respawn right away
+	backoff Backoff
+
+	clock Clock
+
+	mu sync.Mutex
+
+	// consecutive respawn attempts by slot index
+	attempts map[int]int
+}
+
+// newRespawnBackoff creates backoff of the slot respawns, nil backoff disables the delays.
+func newRespawnBackoff(backoff Backoff, clock Clock) *respawnBackoff {
+	return &respawnBackoff{
+		backoff:  backoff,
+		clock:    clockOrSystem(clock),
+		attempts: make(map[int]int),
+	}
+}
+
+// wait registers the respawn attempt of the slot and waits for its delay, returns false once
+// done is closed first.
+func (b *respawnBackoff) wait(index int, done chan interface{}) bool {
+	if b.backoff == nil {
+		return true
+	}
+
+	b.mu.Lock()
+	b.attempts[index]++
+	attempt := b.attempts[index]
+	b.mu.Unlock()
+
+	d := b.backoff.Next(attempt)
+	if d <= 0 {
+		return true
+	}
+
+	timer := b.clock.NewTimer(d)
+	select {
+	case <-timer.C():
+		return true
+	case <-done:
+		timer.Stop()
+		return false
+	}
+}
+
+// reset clears attempts of the slot once its worker is stable.
+func (b *respawnBackoff) reset(index int) {
+	if b.backoff == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.attempts, index)
+}
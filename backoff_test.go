@@ -0,0 +1,159 @@
+package roadrunner
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func Test_FixedBackoff(t *testing.T) {
+	b := FixedBackoff(time.Second)
+
+	for attempt := 1; attempt <= 5; attempt++ {
+		assert.Equal(t, time.Second, b.Next(attempt))
+	}
+}
+
+func Test_ExponentialBackoff(t *testing.T) {
+	b := ExponentialBackoff{Initial: time.Millisecond * 100, Max: time.Second}
+
+	var delays []time.Duration
+	for attempt := 1; attempt <= 6; attempt++ {
+		delays = append(delays, b.Next(attempt))
+	}
+
+	assert.Equal(t, []time.Duration{
+		time.Millisecond * 100,
+		time.Millisecond * 200,
+		time.Millisecond * 400,
+		time.Millisecond * 800,
+		time.Second,
+		time.Second,
+	}, delays)
+
+	// custom multiplier without the cap
+	b = ExponentialBackoff{Initial: time.Millisecond, Multiplier: 10}
+	assert.Equal(t, time.Millisecond, b.Next(0))
+	assert.Equal(t, time.Second, b.Next(4))
+	assert.Equal(t, time.Duration(1<<63-1), b.Next(1000))
+}
+
+func Test_ExponentialBackoff_Jitter(t *testing.T) {
+	random := []float64{0, 0.5, 0.75, 0.5}
+	b := ExponentialBackoff{
+		Initial: time.Millisecond * 100,
+		Max:     time.Millisecond * 400,
+		Jitter:  0.5,
+		Rand: func() float64 {
+			r := random[0]
+			random = random[1:]
+			return r
+		},
+	}
+
+	assert.Equal(t, time.Millisecond*100, b.Next(1))
+	assert.Equal(t, time.Millisecond*150, b.Next(2))
+	assert.Equal(t, time.Millisecond*250, b.Next(3))
+	assert.Equal(t, time.Millisecond*300, b.Next(4))
+
+	// delays stay within the jitter bounds
+	b = ExponentialBackoff{Initial: time.Second, Jitter: 0.25}
+	for i := 0; i < 100; i++ {
+		d := b.Next(1)
+		assert.True(t, d > time.Millisecond*750 && d <= time.Second, d)
+	}
+}
+
+func Test_RespawnBackoff_Wait(t *testing.T) {
+	clock := newMockClock()
+	b := newRespawnBackoff(ExponentialBackoff{Initial: time.Second}, clock)
+
+	done := make(chan bool)
+	wait := func(destroy chan interface{}) {
+		go func() {
+			done <- b.wait(0, destroy)
+		}()
+	}
+
+	// first attempt
+	wait(nil)
+	assert.True(t, clock.WaitTimers(1))
+	clock.Advance(time.Second)
+	assert.True(t, <-done)
+
+	// second attempt waits for the doubled delay
+	wait(nil)
+	assert.True(t, clock.WaitTimers(1))
+	clock.Advance(time.Second)
+	select {
+	case <-done:
+		t.Fatal("respawn is not delayed")
+	case <-time.After(time.Millisecond * 50):
+	}
+	clock.Advance(time.Second)
+	assert.True(t, <-done)
+
+	// attempts are counted by slot
+	assert.Equal(t, 2, b.attempts[0])
+	b.reset(0)
+	assert.Empty(t, b.attempts)
+
+	// waiting respawn is released by the pool destroy
+	destroy := make(chan interface{})
+	wait(destroy)
+	assert.True(t, clock.WaitTimers(1))
+	close(destroy)
+	assert.False(t, <-done)
+
+	// no backoff
+	assert.True(t, newRespawnBackoff(nil, clock).wait(0, nil))
+}
+
+func Test_StaticPool_RespawnBackoff(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "echo", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      1,
+			RespawnBackoff:  ExponentialBackoff{Initial: time.Millisecond * 200},
+			AllocateTimeout: time.Second * 5,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer p.Destroy()
+
+	spawned := make(chan time.Time, 10)
+	p.Listen(func(event int, ctx interface{}) {
+		if event == EventWorkerConstruct {
+			spawned <- time.Now()
+		}
+	})
+
+	crash := func() time.Duration {
+		w := p.Workers()[0]
+		start := time.Now()
+		assert.NoError(t, w.Kill())
+		d := (<-spawned).Sub(start)
+
+		for i := 0; i < 100 && (len(p.Workers()) != 1 || p.Workers()[0] == w); i++ {
+			time.Sleep(time.Millisecond * 10)
+		}
+
+		return d
+	}
+
+	// consecutive crashes double the delay
+	assert.True(t, crash() >= time.Millisecond*200)
+	assert.True(t, crash() >= time.Millisecond*400)
+
+	// completed task resets the attempts
+	_, err = p.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+
+	d := crash()
+	assert.True(t, d >= time.Millisecond*200 && d < time.Millisecond*400, d)
+}
@@ -0,0 +1,184 @@
+package roadrunner
+
+import (
+	"fmt"
+	"github.com/spiral/goridge/v2"
+	"time"
+)
+
+// ExecBatch executes multiple small tasks in one exchange with the worker, saving the relay round
+// trip and context switches of every task, see batchCommand for the protocol. Responses and errors
+// are indexed as tasks, job errors are reported per task. Relay or protocol failure fails all tasks
+// with the same error. Batch counts as a single execution, MaxPayloadSize limits size of the packed
+// tasks and responses. Not supported by multiplexed workers.
+func (w *Worker) ExecBatch(rqs []*Payload) (rsp []*Payload, errs []error) {
+	if len(rqs) == 0 {
+		return nil, nil
+	}
+
+	if w.mux != nil {
+		return nil, batchErrors(len(rqs), fmt.Errorf("batches are not supported by multiplexed workers"))
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, rq := range rqs {
+		if rq == nil {
+			return nil, batchErrors(len(rqs), fmt.Errorf("payload can not be empty"))
+		}
+	}
+
+	packed := packBatch(rqs, w.bodyFlags())
+	if err := w.checkPayload(&Payload{Body: packed}); err != nil {
+		return nil, batchErrors(len(rqs), err)
+	}
+
+	if w.state.Value() != StateReady {
+		return nil, batchErrors(len(rqs), fmt.Errorf("worker is not ready (%s)", w.state.String()))
+	}
+
+	w.state.set(StateWorking)
+
+	start := time.Now()
+	rsp, errs, err := w.execBatch(rqs, packed)
+	w.latency.observe(time.Since(start))
+	w.state.registerExec()
+
+	if err != nil {
+		if _, ok := err.(JobError); !ok {
+			w.state.set(StateErrored)
+		} else {
+			w.state.set(StateReady)
+		}
+
+		return nil, batchErrors(len(rqs), err)
+	}
+
+	w.state.set(StateReady)
+	return rsp, errs
+}
+
+// execBatch sends packed tasks preceded by the batch command and unpacks the responses, err is
+// returned when batch failed as a whole.
+func (w *Worker) execBatch(rqs []*Payload, packed []byte) (rsp []*Payload, errs []error, err error) {
+	for _, rq := range rqs {
+		w.execs.push(rq)
+	}
+
+	if err := sendControl(w.rl, batchCommand{Batch: len(rqs)}); err != nil {
+		return nil, nil, w.wrapError(err, "header error")
+	}
+
+	if err := w.rl.Send(packed, goridge.PayloadRaw); err != nil {
+		return nil, nil, w.wrapError(err, "sender error")
+	}
+
+	data, pr, err := w.rl.Receive()
+	if err != nil {
+		return nil, nil, w.receiveError(err)
+	}
+
+	if pr.HasFlag(goridge.PayloadControl) {
+		if !pr.HasFlag(goridge.PayloadError) {
+			return nil, nil, w.wrapError(fmt.Errorf("malformed batch response"), "worker error")
+		}
+
+		if err := w.checkStream(); err != nil {
+			return nil, nil, err
+		}
+
+		return nil, nil, JobError(data)
+	}
+
+	if rsp, errs, err = unpackBatch(data, len(rqs)); err != nil {
+		return nil, nil, w.wrapError(err, "worker error")
+	}
+
+	if err := w.checkStream(); err != nil {
+		return nil, nil, err
+	}
+
+	return rsp, errs, nil
+}
+
+// packBatch encodes tasks as context and body frames, body frames carry given flags.
+func packBatch(rqs []*Payload, flags byte) (packed []byte) {
+	for _, rq := range rqs {
+		packed = packFrame(packed, rq.Context, goridge.PayloadControl)
+		packed = packFrame(packed, rq.Body, flags)
+	}
+
+	return packed
+}
+
+// unpackBatch decodes n packed responses, job errors are reported per response.
+func unpackBatch(packed []byte, n int) (rsp []*Payload, errs []error, err error) {
+	rsp, errs = make([]*Payload, n), make([]error, n)
+	for i := 0; i < n; i++ {
+		var (
+			context, body []byte
+			pr            goridge.Prefix
+		)
+
+		if context, pr, packed, err = unpackFrame(packed); err != nil {
+			return nil, nil, err
+		}
+
+		if !pr.HasFlag(goridge.PayloadControl) {
+			return nil, nil, fmt.Errorf("malformed batch response: context frame of response %v expected", i)
+		}
+
+		if pr.HasFlag(goridge.PayloadError) {
+			errs[i] = JobError(context)
+			continue
+		}
+
+		if body, _, packed, err = unpackFrame(packed); err != nil {
+			return nil, nil, err
+		}
+
+		rsp[i] = &Payload{Context: context, Body: body}
+	}
+
+	if len(packed) != 0 {
+		return nil, nil, fmt.Errorf("malformed batch response: %v bytes after %v responses", len(packed), n)
+	}
+
+	return rsp, errs, nil
+}
+
+// packFrame appends goridge frame of the data to the packed frames.
+func packFrame(packed []byte, data []byte, flags byte) []byte {
+	p := goridge.NewPrefix().WithFlags(flags).WithSize(uint64(len(data)))
+	return append(append(packed, p[:]...), data...)
+}
+
+// unpackFrame decodes first goridge frame of the packed frames, rest contains remaining frames.
+func unpackFrame(packed []byte) (data []byte, p goridge.Prefix, rest []byte, err error) {
+	if len(packed) < len(p) {
+		return nil, p, nil, fmt.Errorf("malformed batch response: truncated prefix")
+	}
+
+	copy(p[:], packed)
+	if !p.Valid() {
+		return nil, p, nil, fmt.Errorf("malformed batch response: invalid prefix")
+	}
+
+	packed = packed[len(p):]
+	if p.Size() > uint64(len(packed)) {
+		return nil, p, nil, fmt.Errorf("malformed batch response: truncated frame")
+	}
+
+	return packed[:p.Size()], p, packed[p.Size():], nil
+}
+
+// batchErrors returns the same error for all n tasks of the failed batch.
+func batchErrors(n int, err error) []error {
+	errs := make([]error, n)
+	for i := range errs {
+		errs[i] = err
+	}
+
+	return errs
+}
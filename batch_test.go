@@ -0,0 +1,107 @@
+package roadrunner
+
+import (
+	"github.com/spiral/goridge/v2"
+	"github.com/stretchr/testify/assert"
+	"os/exec"
+	"testing"
+)
+
+func Test_Batch_PackUnpack(t *testing.T) {
+	rqs := []*Payload{
+		{Context: []byte("a"), Body: []byte("hello")},
+		{Body: []byte("world")},
+	}
+
+	packed := packBatch(rqs, goridge.PayloadRaw)
+
+	rsp, errs, err := unpackBatch(packed, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []error{nil, nil}, errs)
+	assert.Equal(t, "a", string(rsp[0].Context))
+	assert.Equal(t, "hello", rsp[0].String())
+	assert.Len(t, rsp[1].Context, 0)
+	assert.Equal(t, "world", rsp[1].String())
+}
+
+func Test_Batch_Unpack_JobError(t *testing.T) {
+	packed := packFrame(nil, []byte("failed"), goridge.PayloadControl|goridge.PayloadError)
+	packed = append(packed, packBatch([]*Payload{{Body: []byte("hello")}}, goridge.PayloadRaw)...)
+
+	rsp, errs, err := unpackBatch(packed, 2)
+	assert.NoError(t, err)
+	assert.Nil(t, rsp[0])
+	assert.Equal(t, JobError("failed"), errs[0])
+	assert.NoError(t, errs[1])
+	assert.Equal(t, "hello", rsp[1].String())
+}
+
+func Test_Batch_Unpack_Malformed(t *testing.T) {
+	packed := packBatch([]*Payload{{Body: []byte("hello")}}, goridge.PayloadRaw)
+
+	// missing responses
+	_, _, err := unpackBatch(packed, 2)
+	assert.Error(t, err)
+
+	// extra bytes
+	_, _, err = unpackBatch(append(packed, 1), 1)
+	assert.Error(t, err)
+
+	// truncated frame
+	_, _, err = unpackBatch(packed[:len(packed)-1], 1)
+	assert.Error(t, err)
+
+	// body frame instead of context
+	_, _, err = unpackBatch(packFrame(nil, []byte("hello"), goridge.PayloadRaw), 1)
+	assert.Error(t, err)
+
+	// invalid prefix
+	invalid := append([]byte(nil), packed...)
+	invalid[1]++
+	_, _, err = unpackBatch(invalid, 1)
+	assert.Error(t, err)
+}
+
+func Test_Worker_ExecBatch(t *testing.T) {
+	cmd := exec.Command("php", "tests/client.php", "batch", "pipes")
+
+	w, err := NewPipeFactory().SpawnWorker(cmd)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	go w.Wait()
+	defer w.Stop()
+
+	rsp, errs := w.ExecBatch([]*Payload{
+		{Body: []byte("hello")},
+		{Body: []byte("error")},
+		{Body: []byte("world")},
+	})
+
+	assert.NoError(t, errs[0])
+	assert.Equal(t, "hello", rsp[0].String())
+	assert.Equal(t, JobError("item error"), errs[1])
+	assert.Nil(t, rsp[1])
+	assert.NoError(t, errs[2])
+	assert.Equal(t, "world", rsp[2].String())
+
+	assert.Equal(t, int64(1), w.State().NumExecs())
+
+	// worker remains usable for regular tasks
+	res, err := w.Exec(&Payload{Body: []byte("again")})
+	assert.NoError(t, err)
+	assert.Equal(t, "again", res.String())
+}
+
+func Test_Worker_ExecBatch_Empty(t *testing.T) {
+	w := syntheticWorker(1)
+
+	rsp, errs := w.ExecBatch(nil)
+	assert.Nil(t, rsp)
+	assert.Nil(t, errs)
+
+	_, errs = w.ExecBatch([]*Payload{{Body: []byte("hello")}, nil})
+	assert.Len(t, errs, 2)
+	assert.Error(t, errs[0])
+	assert.Equal(t, errs[0], errs[1])
+}
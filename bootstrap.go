@@ -0,0 +1,59 @@
+package roadrunner
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// bootstrapCommand precedes the context and body frames of the one-time worker configuration
+// sent by the SocketFactory once relay is associated (see SocketFactory.BootstrapPayload). Worker
+// must keep the body for itself and acknowledge it with {"ack":true} control frame, no response
+// is sent. Worker might reject the configuration by responding with the error frame instead.
+type bootstrapCommand struct {
+	Bootstrap bool `json:"bootstrap"`
+}
+
+// bootstrap sends configuration to the worker which is not ready yet and waits tout time for the
+// acknowledgement. Bootstrap is not registered in worker state.
+func (w *Worker) bootstrap(body []byte, tout time.Duration) error {
+	// buffered to let bootstrap complete once worker is killed
+	done := make(chan error, 1)
+	go func() {
+		if err := sendControl(w.rl, bootstrapCommand{Bootstrap: true}); err != nil {
+			done <- w.wrapError(err, "header error")
+			return
+		}
+
+		if err := w.sendPayload(&Payload{Body: body}); err != nil {
+			done <- err
+			return
+		}
+
+		done <- w.receiveAck("bootstrap")
+	}()
+
+	timer := time.NewTimer(tout)
+	defer timer.Stop()
+
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		// relay is released once process is killed
+		return fmt.Errorf("bootstrap timeout")
+	}
+}
+
+// commandWorkerID returns logical worker ID passed to the command in RR_WORKER_ID env variable
+// (see WorkerConfig), empty when not set.
+func commandWorkerID(cmd *exec.Cmd) string {
+	for i := len(cmd.Env) - 1; i >= 0; i-- {
+		if strings.HasPrefix(cmd.Env[i], "RR_WORKER_ID=") {
+			return strings.TrimPrefix(cmd.Env[i], "RR_WORKER_ID=")
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,85 @@
+package roadrunner
+
+import (
+	"github.com/spiral/goridge/v2"
+	"github.com/stretchr/testify/assert"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func Test_Worker_Bootstrap(t *testing.T) {
+	received := make(chan []byte, 1)
+	w := relayWorker(t, func(rl goridge.Relay) {
+		// command and context precede the body
+		body, _, err := rl.Receive()
+		if err != nil {
+			return
+		}
+		received <- body
+
+		_ = rl.Send([]byte(`{"ack":true}`), goridge.PayloadControl)
+		respondTask(rl, "next")
+	})
+	defer w.Kill()
+
+	assert.NoError(t, w.bootstrap([]byte("config"), time.Second))
+	assert.Equal(t, "config", string(<-received))
+	assert.Equal(t, int64(0), w.State().NumExecs())
+
+	res, err := w.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, "next", res.String())
+}
+
+func Test_Worker_Bootstrap_Rejected(t *testing.T) {
+	w := relayWorker(t, func(rl goridge.Relay) {
+		if _, _, err := rl.Receive(); err != nil {
+			return
+		}
+
+		_ = rl.Send([]byte("invalid config"), goridge.PayloadControl|goridge.PayloadError)
+	})
+	defer w.Kill()
+
+	err := w.bootstrap([]byte("config"), time.Second)
+	assert.Error(t, err)
+	assert.Equal(t, "invalid config", err.Error())
+}
+
+func Test_Worker_Bootstrap_NotAcknowledged(t *testing.T) {
+	w := relayWorker(t, func(rl goridge.Relay) {
+		if _, _, err := rl.Receive(); err != nil {
+			return
+		}
+
+		// worker does not support bootstrap
+		_ = rl.Send([]byte("{}"), goridge.PayloadControl)
+		_ = rl.Send([]byte("config"), goridge.PayloadRaw)
+	})
+	defer w.Kill()
+
+	err := w.bootstrap([]byte("config"), time.Second)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "bootstrap is not acknowledged")
+}
+
+func Test_Worker_Bootstrap_Timeout(t *testing.T) {
+	w := relayWorker(t, func(rl goridge.Relay) {
+		_, _, _ = rl.Receive()
+		time.Sleep(time.Second)
+	})
+	defer w.Kill()
+
+	err := w.bootstrap([]byte("config"), time.Millisecond*100)
+	assert.Error(t, err)
+	assert.Equal(t, "bootstrap timeout", err.Error())
+}
+
+func Test_CommandWorkerID(t *testing.T) {
+	cmd := exec.Command("php", "tests/client.php", "echo", "pipes")
+	assert.Equal(t, "", commandWorkerID(cmd))
+
+	newWorkerConfig(3, "").Apply(cmd)
+	assert.Equal(t, "worker-3", commandWorkerID(cmd))
+}
@@ -0,0 +1,143 @@
+package roadrunner
+
+import (
+	"sync"
+	"time"
+)
+
+// BreakerState describes state of the pool worker spawn circuit breaker.
+type BreakerState string
+
+const (
+	// BreakerClosed - workers are spawned normally.
+	BreakerClosed BreakerState = "closed"
+
+	// BreakerOpen - too many workers failed to spawn, pool is unavailable until the cooldown passes.
+	BreakerOpen BreakerState = "open"
+
+	// BreakerHalfOpen - cooldown has passed, single trial spawn decides if breaker is closed again.
+	BreakerHalfOpen BreakerState = "half-open"
+)
+
+// breaker pauses worker spawning once too many consecutive spawn attempts fail within the window.
+type breaker struct {
+	// number of consecutive failures which open the breaker, 0 disables the breaker
+	threshold int64
+
+	// failures older than window are forgotten, 0 to count all consecutive failures
+	window time.Duration
+
+	// for how long spawning is paused once breaker is open
+	cooldown time.Duration
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int64
+	first    time.Time
+	opened   time.Time
+	trial    bool
+}
+
+// newBreaker creates closed breaker, zero threshold disables the breaker.
+func newBreaker(threshold int64, window, cooldown time.Duration) *breaker {
+	return &breaker{
+		threshold: threshold,
+		window:    window,
+		cooldown:  cooldown,
+		state:     BreakerClosed,
+	}
+}
+
+// State returns current breaker state.
+func (b *breaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerOpen && time.Since(b.opened) >= b.cooldown {
+		return BreakerHalfOpen
+	}
+
+	return b.state
+}
+
+// allow returns true if worker can be spawned, only one trial spawn is allowed once cooldown passes.
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.opened) < b.cooldown {
+			return false
+		}
+
+		b.state, b.trial = BreakerHalfOpen, true
+		return true
+	case BreakerHalfOpen:
+		if b.trial {
+			return false
+		}
+
+		b.trial = true
+		return true
+	}
+
+	return true
+}
+
+// done registers result of the spawn attempt, returns true if failure opened the breaker.
+func (b *breaker) done(err error) bool {
+	if b.threshold == 0 {
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err == nil {
+		b.state, b.trial, b.failures = BreakerClosed, false, 0
+		return false
+	}
+
+	if b.state == BreakerHalfOpen {
+		b.state, b.trial, b.opened = BreakerOpen, false, time.Now()
+		return true
+	}
+
+	if b.failures == 0 || (b.window != 0 && time.Since(b.first) > b.window) {
+		b.failures, b.first = 0, time.Now()
+	}
+
+	b.failures++
+	if b.state == BreakerClosed && b.failures >= b.threshold {
+		b.state, b.opened = BreakerOpen, time.Now()
+		return true
+	}
+
+	return false
+}
+
+// unavailable returns true if breaker is open and cooldown has not passed yet.
+func (b *breaker) unavailable() bool {
+	return b.State() == BreakerOpen
+}
+
+// retryIn returns for how long spawning must be paused before the next attempt.
+func (b *breaker) retryIn() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if d := b.cooldown - time.Since(b.opened); d > 0 {
+			return d
+		}
+
+		return 0
+	case BreakerHalfOpen:
+		// waiting for the trial spawn result
+		return b.cooldown
+	}
+
+	return 0
+}
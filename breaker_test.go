@@ -0,0 +1,72 @@
+package roadrunner
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func Test_Breaker_Disabled(t *testing.T) {
+	b := newBreaker(0, 0, time.Second)
+
+	for i := 0; i < 10; i++ {
+		assert.True(t, b.allow())
+		assert.False(t, b.done(errors.New("failure")))
+	}
+
+	assert.Equal(t, BreakerClosed, b.State())
+	assert.False(t, b.unavailable())
+}
+
+func Test_Breaker_Open(t *testing.T) {
+	b := newBreaker(3, 0, time.Millisecond*50)
+
+	assert.False(t, b.done(errors.New("failure")))
+	assert.False(t, b.done(errors.New("failure")))
+
+	// success resets failures
+	assert.False(t, b.done(nil))
+	assert.False(t, b.done(errors.New("failure")))
+	assert.False(t, b.done(errors.New("failure")))
+	assert.True(t, b.done(errors.New("failure")))
+
+	assert.Equal(t, BreakerOpen, b.State())
+	assert.True(t, b.unavailable())
+	assert.False(t, b.allow())
+	assert.True(t, b.retryIn() > 0)
+
+	time.Sleep(time.Millisecond * 60)
+	assert.Equal(t, BreakerHalfOpen, b.State())
+	assert.False(t, b.unavailable())
+
+	// single trial only
+	assert.True(t, b.allow())
+	assert.False(t, b.allow())
+
+	// failed trial opens breaker again
+	assert.True(t, b.done(errors.New("failure")))
+	assert.Equal(t, BreakerOpen, b.State())
+
+	time.Sleep(time.Millisecond * 60)
+	assert.True(t, b.allow())
+	assert.False(t, b.done(nil))
+
+	assert.Equal(t, BreakerClosed, b.State())
+	assert.True(t, b.allow())
+	assert.Equal(t, time.Duration(0), b.retryIn())
+}
+
+func Test_Breaker_Window(t *testing.T) {
+	b := newBreaker(2, time.Millisecond*20, time.Second)
+
+	assert.False(t, b.done(errors.New("failure")))
+	time.Sleep(time.Millisecond * 30)
+
+	// first failure is outside of the window
+	assert.False(t, b.done(errors.New("failure")))
+	assert.Equal(t, BreakerClosed, b.State())
+
+	assert.True(t, b.done(errors.New("failure")))
+	assert.Equal(t, BreakerOpen, b.State())
+}
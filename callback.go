@@ -0,0 +1,107 @@
+package roadrunner
+
+import (
+	"bytes"
+	"fmt"
+	json "github.com/json-iterator/go"
+	"github.com/spiral/goridge/v2"
+	"sync"
+)
+
+// callCommand is sent by the worker to call the host handler in the middle of the task (see
+// ManagedPool.RegisterHandler). Worker sends {"call":"<handler>"} control frame followed by the argument
+// frame (no control flag) and waits for the host to respond with {"call":"<handler>"} control
+// frame followed by the result frame, result frame carries the error message and the error flag
+// when handler fails or is not registered. Worker continues the task afterwards and may call
+// again any number of times before it sends the response. Response context must not start with
+// {"call": as it would be taken for the call. Calls are not supported within streamed, batched
+// and multiplexed tasks.
+type callCommand struct {
+	Call string `json:"call"`
+}
+
+// callPrefix starts every call command, response contexts which do not start with it are not
+// decoded.
+var callPrefix = []byte(`{"call":`)
+
+// handlers registry of the host functions workers can call during the task.
+type handlers struct {
+	mu sync.RWMutex
+	m  map[string]func([]byte) ([]byte, error)
+}
+
+// register adds handler with the given name replacing existing one, nil removes the handler.
+func (h *handlers) register(name string, fn func([]byte) ([]byte, error)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if fn == nil {
+		delete(h.m, name)
+		return
+	}
+
+	if h.m == nil {
+		h.m = make(map[string]func([]byte) ([]byte, error))
+	}
+	h.m[name] = fn
+}
+
+// call invokes handler with the given name, error is returned for unknown handlers. Registry
+// can be nil.
+func (h *handlers) call(name string, payload []byte) ([]byte, error) {
+	var fn func([]byte) ([]byte, error)
+	if h != nil {
+		h.mu.RLock()
+		fn = h.m[name]
+		h.mu.RUnlock()
+	}
+
+	if fn == nil {
+		return nil, fmt.Errorf("undefined handler `%s`", name)
+	}
+
+	return fn(payload)
+}
+
+// parseCall returns call command encoded in the control frame, false if frame is not a call.
+func parseCall(data []byte, pr goridge.Prefix) (callCommand, bool) {
+	cmd := callCommand{}
+	if !pr.HasFlag(goridge.PayloadControl) || pr.HasFlag(goridge.PayloadError) || !bytes.HasPrefix(data, callPrefix) {
+		return cmd, false
+	}
+
+	if json.Unmarshal(data, &cmd) != nil || cmd.Call == "" {
+		return cmd, false
+	}
+
+	return cmd, true
+}
+
+// serveCall receives argument of the worker call, invokes the handler and sends the result back.
+// Handler error is passed to the worker, only relay errors are returned.
+func (w *Worker) serveCall(cmd callCommand) error {
+	arg, pr, err := w.receiveFrame()
+	if err != nil {
+		return w.receiveError(err)
+	}
+
+	if pr.HasFlag(goridge.PayloadControl) {
+		return w.wrapError(fmt.Errorf("malformed call argument"), "worker error")
+	}
+
+	flags := byte(goridge.PayloadRaw)
+	result, herr := w.handlers.call(cmd.Call, arg)
+	if herr != nil {
+		result, flags = []byte(herr.Error()), goridge.PayloadRaw|goridge.PayloadError
+	}
+
+	if err := sendControl(w.rl, cmd); err != nil {
+		return w.wrapError(err, "call error")
+	}
+
+	if err := w.rl.Send(result, flags); err != nil {
+		return w.wrapError(err, "call error")
+	}
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package roadrunner
+
+import (
+	"errors"
+	"github.com/spiral/goridge/v2"
+	"github.com/stretchr/testify/assert"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+// callHandler calls host handler with the given argument and returns the result frame.
+func callHandler(rl goridge.Relay, name string, arg []byte) ([]byte, goridge.Prefix, error) {
+	if err := sendControl(rl, callCommand{Call: name}); err != nil {
+		return nil, goridge.Prefix{}, err
+	}
+
+	if err := rl.Send(arg, goridge.PayloadRaw); err != nil {
+		return nil, goridge.Prefix{}, err
+	}
+
+	if _, _, err := rl.Receive(); err != nil {
+		return nil, goridge.Prefix{}, err
+	}
+
+	return rl.Receive()
+}
+
+func Test_Worker_Call(t *testing.T) {
+	w := relayWorker(t, func(rl goridge.Relay) {
+		secret, pr, err := callHandler(rl, "secret", []byte("db"))
+		assert.NoError(t, err)
+		assert.False(t, pr.HasFlag(goridge.PayloadError))
+
+		_ = rl.Send([]byte(`{"call":false}`), goridge.PayloadControl)
+		_ = rl.Send(append([]byte("connected with "), secret...), goridge.PayloadRaw)
+	})
+	defer w.Kill()
+
+	w.handlers = &handlers{}
+	w.handlers.register("secret", func(name []byte) ([]byte, error) {
+		return append([]byte("password of "), name...), nil
+	})
+
+	res, err := w.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"call":false}`, string(res.Context))
+	assert.Equal(t, "connected with password of db", res.String())
+	assert.Equal(t, StateReady, w.State().Value())
+}
+
+func Test_Worker_Call_Error(t *testing.T) {
+	w := relayWorker(t, func(rl goridge.Relay) {
+		msg, pr, err := callHandler(rl, "secret", []byte("db"))
+		assert.NoError(t, err)
+		assert.True(t, pr.HasFlag(goridge.PayloadError))
+		assert.Equal(t, "access denied", string(msg))
+
+		msg, pr, err = callHandler(rl, "undefined", nil)
+		assert.NoError(t, err)
+		assert.True(t, pr.HasFlag(goridge.PayloadError))
+		assert.Equal(t, "undefined handler `undefined`", string(msg))
+
+		_ = rl.Send([]byte("{}"), goridge.PayloadControl)
+		_ = rl.Send([]byte("hello"), goridge.PayloadRaw)
+	})
+	defer w.Kill()
+
+	w.handlers = &handlers{}
+	w.handlers.register("secret", func(name []byte) ([]byte, error) {
+		return nil, errors.New("access denied")
+	})
+
+	res, err := w.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", res.String())
+}
+
+func Test_Handlers_Register(t *testing.T) {
+	var h handlers
+	h.register("echo", func(payload []byte) ([]byte, error) {
+		return payload, nil
+	})
+
+	res, err := h.call("echo", []byte("hello"))
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(res))
+
+	h.register("echo", nil)
+	_, err = h.call("echo", []byte("hello"))
+	assert.Error(t, err)
+
+	// no handlers assigned
+	_, err = (*handlers)(nil).call("echo", nil)
+	assert.Error(t, err)
+}
+
+func Test_StaticPool_RegisterHandler(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "call", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      1,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	// handler is not registered yet
+	_, err = p.Exec(&Payload{Body: []byte("world")})
+	assert.Equal(t, ErrWorkerError("undefined handler `greet`"), err)
+
+	p.RegisterHandler("greet", func(name []byte) ([]byte, error) {
+		return append([]byte("hello "), name...), nil
+	})
+
+	res, err := p.Exec(&Payload{Body: []byte("world")})
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", res.String())
+}
@@ -0,0 +1,299 @@
+package roadrunner
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var _ ManagedPool = (*CanaryPool)(nil)
+
+// CanaryPool routes the given percentage of the tasks to the canary pool, for example running
+// the new version of the worker code, and the rest to the stable pool. Percentage is ramped up
+// using SetCanaryPercent as canary proves to be healthy. Every task is routed independently, split
+// is respected on average only.
+type CanaryPool struct {
+	// receives the rest of the tasks
+	stable ManagedPool
+
+	// receives canary percentage of the tasks
+	canary ManagedPool
+
+	// percentage of the tasks routed to the canary pool, float64 bits accessed atomically
+	percent uint64
+
+	// both pools, stable first
+	set poolSet
+
+	// pools of the allocated workers
+	allocated sync.Map
+
+	// worker events of both pools
+	events mergedEvents
+}
+
+// NewCanaryPool creates pool routing percent (0-100) of the tasks to the canary pool. Canary pool
+// owns both pools, they are destroyed along with it.
+func NewCanaryPool(stable, canary ManagedPool, percent float64) (*CanaryPool, error) {
+	p := &CanaryPool{
+		stable: stable,
+		canary: canary,
+		set:    newPoolSet([]string{"stable pool", "canary pool"}, stable, canary),
+	}
+	if err := p.SetCanaryPercent(percent); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// Stable returns pool which receives the tasks not routed to the canary pool.
+func (p *CanaryPool) Stable() ManagedPool {
+	return p.stable
+}
+
+// Canary returns pool which receives canary percentage of the tasks.
+func (p *CanaryPool) Canary() ManagedPool {
+	return p.canary
+}
+
+// SetCanaryPercent changes percentage (0-100) of the tasks routed to the canary pool, applies to
+// the tasks routed afterwards.
+func (p *CanaryPool) SetCanaryPercent(percent float64) error {
+	if math.IsNaN(percent) || percent < 0 || percent > 100 {
+		return fmt.Errorf("canary percent must be within 0 and 100, got %v", percent)
+	}
+
+	atomic.StoreUint64(&p.percent, math.Float64bits(percent))
+	return nil
+}
+
+// CanaryPercent returns percentage of the tasks routed to the canary pool.
+func (p *CanaryPool) CanaryPercent() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&p.percent))
+}
+
+// Listen attaches event controller to both pools.
+func (p *CanaryPool) Listen(l func(event int, ctx interface{})) {
+	p.set.listen(l)
+}
+
+// Events returns channel receiving state transitions of the workers of both pools, channel is
+// closed by StopEvents or once both pools are destroyed.
+func (p *CanaryPool) Events() <-chan WorkerEvent {
+	return p.events.subscribe(p.stable, p.canary)
+}
+
+// StopEvents closes the channel returned by Events.
+func (p *CanaryPool) StopEvents(c <-chan WorkerEvent) {
+	p.events.unsubscribe(c, p.stable, p.canary)
+}
+
+// Exec one task with given payload and context, returns result or error.
+func (p *CanaryPool) Exec(rqs *Payload) (rsp *Payload, err error) {
+	return p.route().Exec(rqs)
+}
+
+// ExecContext executes the task until context is done, context error is returned for the
+// canceled task.
+func (p *CanaryPool) ExecContext(ctx context.Context, rqs *Payload) (rsp *Payload, err error) {
+	return p.route().ExecContext(ctx, rqs)
+}
+
+// ExecWithMeta executes the task like Exec and describes the worker which executed it.
+func (p *CanaryPool) ExecWithMeta(rqs *Payload) (rsp *Payload, meta ExecMeta, err error) {
+	return p.route().ExecWithMeta(rqs)
+}
+
+// ExecBatch executes tasks on a single worker of the pool selected for the batch.
+func (p *CanaryPool) ExecBatch(rqs []*Payload) (rsp []*Payload, errs []error) {
+	return p.route().ExecBatch(rqs)
+}
+
+// ExecPriority executes the task of the given priority, priority applies to the tasks waiting
+// within the pool task is routed to.
+func (p *CanaryPool) ExecPriority(rqs *Payload, priority int) (rsp *Payload, err error) {
+	return p.route().ExecPriority(rqs, priority)
+}
+
+// ExecSticky executes the task preferring the worker which executed previous tasks of the same
+// key, affinity is kept within the pool task is routed to only.
+func (p *CanaryPool) ExecSticky(key string, rqs *Payload) (rsp *Payload, err error) {
+	return p.route().ExecSticky(key, rqs)
+}
+
+// ExecFresh executes the task on the worker spawned for this task only and destroyed afterwards.
+func (p *CanaryPool) ExecFresh(rqs *Payload) (rsp *Payload, err error) {
+	return p.route().ExecFresh(rqs)
+}
+
+// ExecAsync executes the task without waiting for the result on the worker of the selected pool.
+func (p *CanaryPool) ExecAsync(rqs *Payload) error {
+	return p.route().ExecAsync(rqs)
+}
+
+// Use attaches middleware to both pools, task passes the chain of the pool it's routed to.
+func (p *CanaryPool) Use(m Middleware) {
+	p.set.use(m)
+}
+
+// SetFallback attaches fallback to both pools, task is served by the fallback of the pool it's
+// routed to.
+func (p *CanaryPool) SetFallback(f FallbackFunc) {
+	p.set.setFallback(f)
+}
+
+// RegisterHandler registers handler in both pools.
+func (p *CanaryPool) RegisterHandler(name string, fn func([]byte) ([]byte, error)) {
+	p.set.registerHandler(name, fn)
+}
+
+// TryExec executes the task only if free worker of the pool task is routed to is immediately
+// available, other pool is never tried to keep the split.
+func (p *CanaryPool) TryExec(rqs *Payload) (rsp *Payload, acquired bool, err error) {
+	return p.route().TryExec(rqs)
+}
+
+// Workers returns workers of the stable pool followed by the canary pool workers.
+func (p *CanaryPool) Workers() (workers []*Worker) {
+	return p.set.workers()
+}
+
+// Remove forces pool owning the worker to remove it, false is returned for unknown worker.
+func (p *CanaryPool) Remove(w *Worker, err error) bool {
+	return p.set.remove(w, err)
+}
+
+// recycleIdle recycles idle worker of the owning pool.
+func (p *CanaryPool) recycleIdle(w *Worker, reason RecycleReason, err error) bool {
+	return p.set.recycleIdle(w, reason, err)
+}
+
+// Allocate checks out idle worker of the pool selected the same way as for the task. Worker must
+// be returned using Release.
+func (p *CanaryPool) Allocate(ctx context.Context) (*Worker, error) {
+	pool := p.route()
+
+	w, err := pool.Allocate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.allocated.Store(w, pool)
+	return w, nil
+}
+
+// Release returns allocated worker to the pool it has been allocated from.
+func (p *CanaryPool) Release(w *Worker, broken bool) {
+	pool, ok := p.allocated.Load(w)
+	if !ok {
+		return
+	}
+
+	p.allocated.Delete(w)
+	pool.(ManagedPool).Release(w, broken)
+}
+
+// Detach takes idle worker with the given PID out of the pool owning it.
+func (p *CanaryPool) Detach(pid int) (*Worker, error) {
+	return p.set.detach(pid)
+}
+
+// SetCommand replaces the command used to spawn new workers of both pools, use SetCommand of the
+// Canary pool to roll out the new command to the canary pool only.
+func (p *CanaryPool) SetCommand(cmd func(cfg WorkerConfig) *exec.Cmd) {
+	p.set.setCommand(cmd)
+}
+
+// ReloadWorker replaces the oldest worker of each pool.
+func (p *CanaryPool) ReloadWorker() error {
+	return p.set.reloadWorker()
+}
+
+// ReloadAll replaces workers of the stable pool and then workers of the canary pool.
+func (p *CanaryPool) ReloadAll(pause time.Duration) error {
+	return p.set.reloadAll(pause)
+}
+
+// OnWorkerDeath attaches callback invoked when worker of either pool dies unexpectedly.
+func (p *CanaryPool) OnWorkerDeath(f func(pid int, err error)) {
+	p.set.onWorkerDeath(f)
+}
+
+// OnWorkerReady attaches hook invoked for every new worker of either pool.
+func (p *CanaryPool) OnWorkerReady(f func(w *Worker) error) {
+	p.set.onWorkerReady(f)
+}
+
+// OnWorkerDestroy attaches hook invoked once worker process of either pool exits.
+func (p *CanaryPool) OnWorkerDestroy(f func(w *Worker)) {
+	p.set.onWorkerDestroy(f)
+}
+
+// Stats returns sum of both pool statistics, see StatsBySide to compare the pools. Breaker
+// contains the most restrictive breaker state, pool is paused once both pools are paused.
+func (p *CanaryPool) Stats() PoolStats {
+	return sumStats(p.StatsBySide())
+}
+
+// StatsBySide returns statistics of the stable and the canary pool, for example to compare error
+// rates before ramping the canary up.
+func (p *CanaryPool) StatsBySide() (stable, canary PoolStats) {
+	return p.stable.Stats(), p.canary.Stats()
+}
+
+// Dump returns snapshots of all workers of both pools.
+func (p *CanaryPool) Dump() []WorkerSnapshot {
+	return p.set.dump()
+}
+
+// MarshalState returns binary encoding of the pool stats and worker snapshots of both pools, see
+// UnmarshalState.
+func (p *CanaryPool) MarshalState() ([]byte, error) {
+	return marshalState(p.Stats(), p.Dump())
+}
+
+// Healthy verifies that both pools are healthy.
+func (p *CanaryPool) Healthy() (bool, error) {
+	return p.set.healthy()
+}
+
+// WaitReady waits until both pools are ready.
+func (p *CanaryPool) WaitReady(ctx context.Context) error {
+	return p.set.waitReady(ctx)
+}
+
+// Pause stops dispatching of new tasks in both pools.
+func (p *CanaryPool) Pause() {
+	p.set.pause()
+}
+
+// Resume restarts dispatching of the tasks in both pools.
+func (p *CanaryPool) Resume() {
+	p.set.resume()
+}
+
+// Drain pauses and drains both pools sharing the context, report contains sum of both pool
+// reports.
+func (p *CanaryPool) Drain(ctx context.Context) (DrainReport, error) {
+	return p.set.drain(ctx)
+}
+
+// Destroy both pools.
+func (p *CanaryPool) Destroy() {
+	p.set.destroy()
+}
+
+// route returns pool to receive the next task.
+func (p *CanaryPool) route() ManagedPool {
+	if rand.Float64()*100 < p.CanaryPercent() {
+		return p.canary
+	}
+
+	return p.stable
+}
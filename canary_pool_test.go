@@ -0,0 +1,103 @@
+package roadrunner
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+)
+
+// canaryShare returns share of n tasks executed by the canary pool in percents.
+func canaryShare(t *testing.T, p *CanaryPool, n int) float64 {
+	canary := 0
+	for i := 0; i < n; i++ {
+		res, err := p.Exec(&Payload{Body: []byte("hello")})
+		assert.NoError(t, err)
+
+		if res.String() == "canary" {
+			canary++
+		}
+	}
+
+	return float64(canary) * 100 / float64(n)
+}
+
+func Test_CanaryPool_Split(t *testing.T) {
+	p, err := NewCanaryPool(&stubPool{name: "stable"}, &stubPool{name: "canary"}, 20)
+	assert.NoError(t, err)
+
+	assert.InDelta(t, 20, canaryShare(t, p, 10000), 2)
+
+	// ramp up
+	assert.NoError(t, p.SetCanaryPercent(50))
+	assert.Equal(t, float64(50), p.CanaryPercent())
+	assert.InDelta(t, 50, canaryShare(t, p, 10000), 2)
+
+	assert.NoError(t, p.SetCanaryPercent(0))
+	assert.Equal(t, float64(0), canaryShare(t, p, 1000))
+
+	assert.NoError(t, p.SetCanaryPercent(100))
+	assert.Equal(t, float64(100), canaryShare(t, p, 1000))
+}
+
+func Test_CanaryPool_InvalidPercent(t *testing.T) {
+	p, err := NewCanaryPool(&stubPool{}, &stubPool{}, 101)
+	assert.Nil(t, p)
+	assert.Error(t, err)
+
+	p, err = NewCanaryPool(&stubPool{}, &stubPool{}, 10)
+	assert.NoError(t, err)
+
+	for _, percent := range []float64{-1, 100.5, math.NaN()} {
+		assert.Error(t, p.SetCanaryPercent(percent))
+	}
+	assert.Equal(t, float64(10), p.CanaryPercent())
+}
+
+func Test_CanaryPool_TryExec(t *testing.T) {
+	stable := &stubPool{name: "stable", stats: PoolStats{NumIdle: 1}}
+	canary := &stubPool{name: "canary"}
+
+	p, err := NewCanaryPool(stable, canary, 100)
+	assert.NoError(t, err)
+
+	// stable pool is not tried
+	_, acquired, err := p.TryExec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.False(t, acquired)
+}
+
+func Test_CanaryPool_Allocate(t *testing.T) {
+	ws, wc := &Worker{}, &Worker{}
+	stable := &stubPool{workers: []*Worker{ws}}
+	canary := &stubPool{workers: []*Worker{wc}}
+
+	p, err := NewCanaryPool(stable, canary, 100)
+	assert.NoError(t, err)
+
+	w, err := p.Allocate(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, wc, w)
+
+	p.Release(w, false)
+	assert.Equal(t, []*Worker{wc}, canary.removed)
+	assert.Empty(t, stable.removed)
+
+	assert.True(t, p.Remove(ws, nil))
+	assert.Equal(t, []*Worker{ws}, stable.removed)
+	assert.Equal(t, []*Worker{ws, wc}, p.Workers())
+}
+
+func Test_CanaryPool_Stats(t *testing.T) {
+	stable := &stubPool{stats: PoolStats{NumWorkers: 4, TotalExecs: 90, TotalErrors: 1}}
+	canary := &stubPool{stats: PoolStats{NumWorkers: 1, TotalExecs: 10, TotalErrors: 5}}
+
+	p, err := NewCanaryPool(stable, canary, 10)
+	assert.NoError(t, err)
+
+	ss, cs := p.StatsBySide()
+	assert.Equal(t, stable.stats, ss)
+	assert.Equal(t, canary.stats, cs)
+
+	assert.Equal(t, PoolStats{NumWorkers: 5, TotalExecs: 100, TotalErrors: 6}, p.Stats())
+}
@@ -0,0 +1,42 @@
+package roadrunner
+
+import "sync"
+
+// checkouts counts workers allocated by the pool users (see StaticPool.Allocate), multiplexed
+// worker might be allocated multiple times at once. Zero value is ready to use.
+type checkouts struct {
+	mu      sync.Mutex
+	workers map[*Worker]int
+}
+
+// add registers allocation of the worker.
+func (c *checkouts) add(w *Worker) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.workers == nil {
+		c.workers = make(map[*Worker]int)
+	}
+
+	c.workers[w]++
+}
+
+// remove registers return of the worker, returns false when worker has not been allocated or
+// has been returned already.
+func (c *checkouts) remove(w *Worker) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n, ok := c.workers[w]
+	if !ok {
+		return false
+	}
+
+	if n == 1 {
+		delete(c.workers, w)
+	} else {
+		c.workers[w] = n - 1
+	}
+
+	return true
+}
@@ -0,0 +1,62 @@
+package roadrunner
+
+import "time"
+
+// Clock provides time to the pools and factories, replaced in tests to trigger timeouts
+// without real waiting.
+type Clock interface {
+	// Now returns current time.
+	Now() time.Time
+
+	// NewTimer creates timer firing once d elapses.
+	NewTimer(d time.Duration) Timer
+
+	// After waits for d to elapse and sends current time on the returned channel.
+	After(d time.Duration) <-chan time.Time
+}
+
+// Timer represents single event created by the Clock.
+type Timer interface {
+	// C returns channel receiving time once timer fires.
+	C() <-chan time.Time
+
+	// Stop prevents timer from firing, returns false if timer has already fired or been stopped.
+	Stop() bool
+}
+
+// systemClock is Clock backed by the time package.
+type systemClock struct{}
+
+// Now returns current time.
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// NewTimer creates timer firing once d elapses.
+func (systemClock) NewTimer(d time.Duration) Timer {
+	return systemTimer{time.NewTimer(d)}
+}
+
+// After waits for d to elapse and sends current time on the returned channel.
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// systemTimer is Timer backed by time.Timer.
+type systemTimer struct {
+	*time.Timer
+}
+
+// C returns channel receiving time once timer fires.
+func (t systemTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
+
+// clockOrSystem returns given clock or the system clock when nil.
+func clockOrSystem(c Clock) Clock {
+	if c == nil {
+		return systemClock{}
+	}
+
+	return c
+}
@@ -0,0 +1,135 @@
+package roadrunner
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mockClock is Clock advanced manually by the test.
+type mockClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*mockTimer
+}
+
+// mockTimer fires once mock clock is advanced past its deadline.
+type mockTimer struct {
+	clock *mockClock
+	at    time.Time
+	c     chan time.Time
+}
+
+func newMockClock() *mockClock {
+	return &mockClock{now: time.Now()}
+}
+
+func (c *mockClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *mockClock) NewTimer(d time.Duration) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := &mockTimer{clock: c, at: c.now.Add(d), c: make(chan time.Time, 1)}
+	c.timers = append(c.timers, t)
+
+	return t
+}
+
+func (c *mockClock) After(d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C()
+}
+
+// Advance moves clock forward and fires expired timers.
+func (c *mockClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+
+	active := c.timers[:0]
+	for _, t := range c.timers {
+		if t.at.After(c.now) {
+			active = append(active, t)
+			continue
+		}
+
+		t.c <- c.now
+	}
+	c.timers = active
+}
+
+// WaitTimers waits until at least n timers are active, returns false after a second.
+func (c *mockClock) WaitTimers(n int) bool {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		c.mu.Lock()
+		active := len(c.timers)
+		c.mu.Unlock()
+
+		if active >= n {
+			return true
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	return false
+}
+
+func (t *mockTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *mockTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	for i, at := range t.clock.timers {
+		if at == t {
+			t.clock.timers = append(t.clock.timers[:i], t.clock.timers[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+func Test_MockClock(t *testing.T) {
+	c := newMockClock()
+	start := c.Now()
+
+	timer := c.NewTimer(time.Minute)
+	after := c.After(time.Hour)
+	stopped := c.NewTimer(time.Minute)
+	assert.True(t, stopped.Stop())
+	assert.False(t, stopped.Stop())
+
+	c.Advance(time.Minute)
+	assert.Equal(t, start.Add(time.Minute), <-timer.C())
+	assert.False(t, timer.Stop())
+	assert.Len(t, stopped.C(), 0)
+	assert.Len(t, after, 0)
+
+	c.Advance(time.Hour)
+	assert.Equal(t, start.Add(time.Hour+time.Minute), <-after)
+}
+
+func Test_SystemClock(t *testing.T) {
+	c := clockOrSystem(nil)
+
+	timer := c.NewTimer(time.Millisecond)
+	assert.True(t, (<-timer.C()).After(time.Now().Add(-time.Second)))
+	assert.False(t, timer.Stop())
+
+	<-c.After(time.Millisecond)
+
+	mock := newMockClock()
+	assert.Equal(t, mock, clockOrSystem(mock))
+}
@@ -0,0 +1,107 @@
+package roadrunner
+
+import (
+	"fmt"
+	json "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+	"github.com/spiral/goridge/v2"
+)
+
+// Codec encodes task bodies sent to the workers and decodes bodies of the worker responses.
+// Codec must match the payload encoding used by the worker, contexts are always sent raw.
+//
+// Following goridge convention body frames encoded by the codec are marked with codec flags,
+// worker must respond with the body encoded the same way:
+//
+//	RawCodec  - body is sent as is and marked with PayloadRaw flag;
+//	JSONCodec - body contains JSON document, frame has no PayloadRaw flag.
+//
+// Body frames of the workers spawned by the factory without codec carry no flags.
+type Codec interface {
+	// Flags returns goridge flags of the body frames encoded by the codec.
+	Flags() byte
+
+	// Encode encodes value into the body frame.
+	Encode(v interface{}) ([]byte, error)
+
+	// Decode decodes body frame into the value.
+	Decode(data []byte, v interface{}) error
+}
+
+// RawCodec passes binary bodies as is, values must be []byte or string. Default codec.
+type RawCodec struct{}
+
+// Flags returns PayloadRaw flag.
+func (RawCodec) Flags() byte {
+	return goridge.PayloadRaw
+}
+
+// Encode returns bytes of given []byte or string value.
+func (RawCodec) Encode(v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	case nil:
+		return nil, nil
+	}
+
+	return nil, fmt.Errorf("raw codec can not encode %T", v)
+}
+
+// Decode copies body data into given *[]byte or *string value.
+func (RawCodec) Decode(data []byte, v interface{}) error {
+	switch v := v.(type) {
+	case *[]byte:
+		*v = data
+	case *string:
+		*v = string(data)
+	default:
+		return fmt.Errorf("raw codec can not decode into %T", v)
+	}
+
+	return nil
+}
+
+// JSONCodec marshals values to JSON documents.
+type JSONCodec struct{}
+
+// Flags returns no flags, goridge treats frames without PayloadRaw flag as JSON.
+func (JSONCodec) Flags() byte {
+	return 0
+}
+
+// Encode marshals value to JSON.
+func (JSONCodec) Encode(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Decode unmarshals JSON body into the value, empty body leaves the value untouched.
+func (JSONCodec) Decode(data []byte, v interface{}) error {
+	if len(data) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+// execValue encodes rqs using the codec, executes it and decodes response body into rsp. Response
+// context is returned as is, including the response which body can not be decoded.
+func execValue(c Codec, exec func(rqs *Payload) (*Payload, error), rqs interface{}, rsp interface{}) (context []byte, err error) {
+	body, err := c.Encode(rqs)
+	if err != nil {
+		return nil, errors.Wrap(err, "encode error")
+	}
+
+	res, err := exec(&Payload{Body: body})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.Decode(res.Body, rsp); err != nil {
+		return res.Context, errors.Wrap(err, "decode error")
+	}
+
+	return res.Context, nil
+}
@@ -0,0 +1,100 @@
+package roadrunner
+
+import (
+	"github.com/spiral/goridge/v2"
+	"github.com/stretchr/testify/assert"
+	"os/exec"
+	"testing"
+)
+
+func Test_RawCodec(t *testing.T) {
+	c := RawCodec{}
+	assert.Equal(t, goridge.PayloadRaw, c.Flags())
+
+	data, err := c.Encode("hello")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("hello"), data)
+
+	data, err = c.Encode([]byte("hello"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("hello"), data)
+
+	_, err = c.Encode(100)
+	assert.Error(t, err)
+
+	var s string
+	assert.NoError(t, c.Decode([]byte("hello"), &s))
+	assert.Equal(t, "hello", s)
+
+	var b []byte
+	assert.NoError(t, c.Decode([]byte("hello"), &b))
+	assert.Equal(t, []byte("hello"), b)
+
+	assert.Error(t, c.Decode([]byte("hello"), nil))
+}
+
+func Test_JSONCodec(t *testing.T) {
+	c := JSONCodec{}
+	assert.Equal(t, byte(0), c.Flags())
+
+	data, err := c.Encode(struct {
+		Name string `json:"name"`
+	}{"hello"})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"name":"hello"}`, string(data))
+
+	out := struct {
+		Name string `json:"name"`
+		Pid  int    `json:"pid"`
+	}{}
+	assert.NoError(t, c.Decode([]byte(`{"name":"hello","pid":1}`), &out))
+	assert.Equal(t, "hello", out.Name)
+	assert.Equal(t, 1, out.Pid)
+
+	assert.NoError(t, c.Decode(nil, &out))
+	assert.Equal(t, "hello", out.Name)
+
+	assert.Error(t, c.Decode([]byte("{"), &out))
+}
+
+func Test_Worker_BodyFlags(t *testing.T) {
+	for _, c := range []struct {
+		codec Codec
+		flags byte
+	}{
+		{codec: nil, flags: 0},
+		{codec: RawCodec{}, flags: goridge.PayloadRaw},
+		{codec: JSONCodec{}, flags: 0},
+	} {
+		w, _ := newWorker(exec.Command("sleep", "10"))
+		assert.NoError(t, w.start())
+
+		conn, wConn := connPair(t)
+		w.rl = goridge.NewSocketRelay(conn)
+		w.codec = c.codec
+		w.state.set(StateReady)
+
+		flags := make(chan byte, 1)
+		go func() {
+			rl := goridge.NewSocketRelay(wConn)
+			if _, _, err := rl.Receive(); err != nil {
+				return
+			}
+
+			_, p, err := rl.Receive()
+			if err != nil {
+				return
+			}
+			flags <- p.Flags()
+
+			_ = rl.Send([]byte("{}"), goridge.PayloadControl)
+			_ = rl.Send([]byte("done"), goridge.PayloadRaw)
+		}()
+
+		_, err := w.Exec(&Payload{Body: []byte("hello")})
+		assert.NoError(t, err)
+		assert.Equal(t, c.flags, <-flags)
+
+		_ = w.Kill()
+	}
+}
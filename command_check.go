@@ -0,0 +1,54 @@
+package roadrunner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// CommandCheckTimeout defines for how long worker executable can run during the command check.
+const CommandCheckTimeout = 10 * time.Second
+
+// checkCommand verifies that executable of the worker command exists and can be executed, when
+// args are given executable is run with them and must exit with 0 status. Used to fail fast on
+// broken deploys instead of failing every worker start.
+func checkCommand(cmd *exec.Cmd, args []string) error {
+	fi, err := os.Stat(cmd.Path)
+	if err != nil {
+		return fmt.Errorf("worker executable `%s` not found", cmd.Path)
+	}
+
+	if fi.IsDir() || (runtime.GOOS != "windows" && fi.Mode()&0111 == 0) {
+		return fmt.Errorf("worker executable `%s` is not executable (%s)", cmd.Path, fi.Mode())
+	}
+
+	if len(args) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), CommandCheckTimeout)
+	defer cancel()
+
+	check := exec.CommandContext(ctx, cmd.Path, args...)
+	check.Env, check.Dir = cmd.Env, cmd.Dir
+
+	out := &bytes.Buffer{}
+	check.Stdout, check.Stderr = out, out
+
+	if err := check.Run(); err != nil {
+		msg := fmt.Sprintf("worker executable `%s %s` failed", cmd.Path, strings.Join(args, " "))
+		if output := strings.TrimSpace(out.String()); output != "" {
+			msg = fmt.Sprintf("%s: %s", msg, output)
+		}
+
+		return errors.Wrap(err, msg)
+	}
+
+	return nil
+}
@@ -0,0 +1,75 @@
+package roadrunner
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_CheckCommand(t *testing.T) {
+	assert.NoError(t, checkCommand(exec.Command("sh"), nil))
+	assert.NoError(t, checkCommand(exec.Command("sh"), []string{"-c", "exit 0"}))
+}
+
+func Test_CheckCommand_NotFound(t *testing.T) {
+	err := checkCommand(exec.Command("/not/existing/php", "tests/client.php"), nil)
+	assert.Error(t, err)
+	assert.Equal(t, "worker executable `/not/existing/php` not found", err.Error())
+}
+
+func Test_CheckCommand_NotExecutable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rr")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "php")
+	assert.NoError(t, ioutil.WriteFile(file, []byte("#!/bin/sh"), 0644))
+
+	err = checkCommand(exec.Command(file), nil)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "is not executable")
+
+	err = checkCommand(exec.Command(dir), nil)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "is not executable")
+}
+
+func Test_CheckCommand_Args(t *testing.T) {
+	err := checkCommand(exec.Command("sh"), []string{"-c", "echo broken install; exit 3"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failed: broken install")
+	assert.Contains(t, err.Error(), "exit status 3")
+}
+
+func Test_Pool_CommandCheck(t *testing.T) {
+	cfg := Config{
+		NumWorkers:       1,
+		AllocateTimeout:  time.Second,
+		DestroyTimeout:   time.Second,
+		CommandCheckArgs: []string{"--version"},
+	}
+
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("/not/existing/php", "tests/client.php", "echo", "pipes") },
+		NewPipeFactory(),
+		cfg,
+	)
+	assert.Nil(t, p)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "command check: worker executable `/not/existing/php` not found")
+
+	// failure is reported by the worker start
+	cfg.SkipCommandCheck = true
+	p, err = NewPool(
+		func() *exec.Cmd { return exec.Command("/not/existing/php", "tests/client.php", "echo", "pipes") },
+		NewPipeFactory(),
+		cfg,
+	)
+	assert.Nil(t, p)
+	assert.Error(t, err)
+	assert.NotContains(t, err.Error(), "command check")
+}
@@ -0,0 +1,375 @@
+package roadrunner
+
+import (
+	"context"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var _ ManagedPool = (*CompositePool)(nil)
+
+// CompositePool routes tasks to the primary pool and falls back to the overflow pool only while
+// number of tasks waiting for the primary pool worker keeps exceeding the threshold for the
+// window. Overflow pool absorbs the bursts, for example DynamicPool with MinWorkers set to 0 spawns workers only
+// under the overload and retires them once the burst is over.
+type CompositePool struct {
+	// receives tasks while not overloaded
+	primary ManagedPool
+
+	// receives tasks while primary pool queue exceeds the threshold
+	overflow ManagedPool
+
+	// max number of tasks waiting for the primary pool worker
+	threshold int
+
+	// for how long queue must exceed the threshold before tasks are routed to the overflow pool
+	window time.Duration
+
+	// unix nanoseconds primary pool is overloaded since, 0 while not overloaded
+	overSince int64
+
+	// unix nanoseconds primary pool has been seen overloaded last time
+	lastOver int64
+
+	// both pools, primary first
+	set poolSet
+
+	// pools of the allocated workers
+	allocated sync.Map
+
+	// worker events of both pools
+	events mergedEvents
+}
+
+// NewCompositePool creates pool routing tasks to the overflow pool once more than threshold
+// tasks are waiting for the primary pool worker for at least the window, tasks return to the
+// primary pool once it's not overloaded for the window. Set window 0 to route on the first
+// overloaded task. Composite pool owns both pools, they are destroyed along with it.
+func NewCompositePool(primary, overflow ManagedPool, threshold int, window time.Duration) *CompositePool {
+	return &CompositePool{
+		primary:   primary,
+		overflow:  overflow,
+		threshold: threshold,
+		window:    window,
+		set:       newPoolSet([]string{"primary pool", "overflow pool"}, primary, overflow),
+	}
+}
+
+// Primary returns pool which receives tasks while not overloaded.
+func (p *CompositePool) Primary() ManagedPool {
+	return p.primary
+}
+
+// Overflow returns pool which receives tasks while primary pool is overloaded.
+func (p *CompositePool) Overflow() ManagedPool {
+	return p.overflow
+}
+
+// Listen attaches event controller to both pools.
+func (p *CompositePool) Listen(l func(event int, ctx interface{})) {
+	p.set.listen(l)
+}
+
+// Events returns channel receiving state transitions of the workers of both pools, channel is
+// closed by StopEvents or once both pools are destroyed.
+func (p *CompositePool) Events() <-chan WorkerEvent {
+	return p.events.subscribe(p.primary, p.overflow)
+}
+
+// StopEvents closes the channel returned by Events.
+func (p *CompositePool) StopEvents(c <-chan WorkerEvent) {
+	p.events.unsubscribe(c, p.primary, p.overflow)
+}
+
+// Exec one task with given payload and context, returns result or error.
+func (p *CompositePool) Exec(rqs *Payload) (rsp *Payload, err error) {
+	return p.route().Exec(rqs)
+}
+
+// ExecContext executes the task until context is done, context error is returned for the
+// canceled task.
+func (p *CompositePool) ExecContext(ctx context.Context, rqs *Payload) (rsp *Payload, err error) {
+	return p.route().ExecContext(ctx, rqs)
+}
+
+// ExecWithMeta executes the task like Exec and describes the worker which executed it.
+func (p *CompositePool) ExecWithMeta(rqs *Payload) (rsp *Payload, meta ExecMeta, err error) {
+	return p.route().ExecWithMeta(rqs)
+}
+
+// ExecBatch executes tasks on a single worker of the pool selected for the batch.
+func (p *CompositePool) ExecBatch(rqs []*Payload) (rsp []*Payload, errs []error) {
+	return p.route().ExecBatch(rqs)
+}
+
+// ExecPriority executes the task of the given priority, priority applies to the tasks waiting
+// within the pool task is routed to.
+func (p *CompositePool) ExecPriority(rqs *Payload, priority int) (rsp *Payload, err error) {
+	return p.route().ExecPriority(rqs, priority)
+}
+
+// ExecSticky executes the task preferring the worker which executed previous tasks of the same
+// key. Affinity is lost while tasks are routed to the overflow pool.
+func (p *CompositePool) ExecSticky(key string, rqs *Payload) (rsp *Payload, err error) {
+	return p.route().ExecSticky(key, rqs)
+}
+
+// ExecFresh executes the task on the worker spawned for this task only and destroyed afterwards.
+func (p *CompositePool) ExecFresh(rqs *Payload) (rsp *Payload, err error) {
+	return p.route().ExecFresh(rqs)
+}
+
+// ExecAsync executes the task without waiting for the result on the worker of the selected pool.
+func (p *CompositePool) ExecAsync(rqs *Payload) error {
+	return p.route().ExecAsync(rqs)
+}
+
+// Use attaches middleware to both pools, task passes the chain of the pool it's routed to.
+func (p *CompositePool) Use(m Middleware) {
+	p.set.use(m)
+}
+
+// SetFallback attaches fallback to both pools, task is served by the fallback of the pool it's
+// routed to.
+func (p *CompositePool) SetFallback(f FallbackFunc) {
+	p.set.setFallback(f)
+}
+
+// RegisterHandler registers handler in both pools.
+func (p *CompositePool) RegisterHandler(name string, fn func([]byte) ([]byte, error)) {
+	p.set.registerHandler(name, fn)
+}
+
+// TryExec executes the task only if free worker is immediately available, overflow pool is
+// tried once primary pool has no free worker.
+func (p *CompositePool) TryExec(rqs *Payload) (rsp *Payload, acquired bool, err error) {
+	if rsp, acquired, err = p.primary.TryExec(rqs); acquired {
+		return rsp, acquired, err
+	}
+
+	return p.overflow.TryExec(rqs)
+}
+
+// Workers returns workers of the primary pool followed by the overflow pool workers.
+func (p *CompositePool) Workers() (workers []*Worker) {
+	return p.set.workers()
+}
+
+// Remove forces pool owning the worker to remove it, false is returned for unknown worker.
+func (p *CompositePool) Remove(w *Worker, err error) bool {
+	return p.set.remove(w, err)
+}
+
+// recycleIdle recycles idle worker of the owning pool.
+func (p *CompositePool) recycleIdle(w *Worker, reason RecycleReason, err error) bool {
+	return p.set.recycleIdle(w, reason, err)
+}
+
+// Allocate checks out idle worker for the exclusive use, worker is allocated from the overflow
+// pool while primary pool is overloaded. Worker must be returned using Release.
+func (p *CompositePool) Allocate(ctx context.Context) (*Worker, error) {
+	pool := p.route()
+
+	w, err := pool.Allocate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.allocated.Store(w, pool)
+	return w, nil
+}
+
+// Release returns allocated worker to the pool it has been allocated from.
+func (p *CompositePool) Release(w *Worker, broken bool) {
+	pool, ok := p.allocated.Load(w)
+	if !ok {
+		return
+	}
+
+	p.allocated.Delete(w)
+	pool.(ManagedPool).Release(w, broken)
+}
+
+// Detach takes idle worker with the given PID out of the pool owning it.
+func (p *CompositePool) Detach(pid int) (*Worker, error) {
+	return p.set.detach(pid)
+}
+
+// SetCommand replaces the command used to spawn new workers of both pools.
+func (p *CompositePool) SetCommand(cmd func(cfg WorkerConfig) *exec.Cmd) {
+	p.set.setCommand(cmd)
+}
+
+// ReloadWorker replaces the oldest worker of each pool.
+func (p *CompositePool) ReloadWorker() error {
+	return p.set.reloadWorker()
+}
+
+// ReloadAll replaces workers of the primary pool and then workers of the overflow pool.
+func (p *CompositePool) ReloadAll(pause time.Duration) error {
+	return p.set.reloadAll(pause)
+}
+
+// OnWorkerDeath attaches callback invoked when worker of either pool dies unexpectedly.
+func (p *CompositePool) OnWorkerDeath(f func(pid int, err error)) {
+	p.set.onWorkerDeath(f)
+}
+
+// OnWorkerReady attaches hook invoked for every new worker of either pool.
+func (p *CompositePool) OnWorkerReady(f func(w *Worker) error) {
+	p.set.onWorkerReady(f)
+}
+
+// OnWorkerDestroy attaches hook invoked once worker process of either pool exits.
+func (p *CompositePool) OnWorkerDestroy(f func(w *Worker)) {
+	p.set.onWorkerDestroy(f)
+}
+
+// Stats returns sum of both pool statistics. Breaker contains the most restrictive breaker
+// state, pool is paused once both pools are paused.
+func (p *CompositePool) Stats() PoolStats {
+	return p.set.stats()
+}
+
+// Dump returns snapshots of all workers of both pools.
+func (p *CompositePool) Dump() []WorkerSnapshot {
+	return p.set.dump()
+}
+
+// MarshalState returns binary encoding of the pool stats and worker snapshots of both pools, see
+// UnmarshalState.
+func (p *CompositePool) MarshalState() ([]byte, error) {
+	return marshalState(p.Stats(), p.Dump())
+}
+
+// Healthy verifies that both pools are healthy.
+func (p *CompositePool) Healthy() (bool, error) {
+	return p.set.healthy()
+}
+
+// WaitReady waits until both pools are ready.
+func (p *CompositePool) WaitReady(ctx context.Context) error {
+	return p.set.waitReady(ctx)
+}
+
+// Pause stops dispatching of new tasks in both pools.
+func (p *CompositePool) Pause() {
+	p.set.pause()
+}
+
+// Resume restarts dispatching of the tasks in both pools.
+func (p *CompositePool) Resume() {
+	p.set.resume()
+}
+
+// Drain drains both pools sharing the context, report contains sum of both pool reports. Both
+// pools are paused before drain to keep tasks from being routed to the pool still running.
+func (p *CompositePool) Drain(ctx context.Context) (DrainReport, error) {
+	return p.set.drain(ctx)
+}
+
+// Destroy both pools.
+func (p *CompositePool) Destroy() {
+	p.set.destroy()
+}
+
+// route returns pool to receive the next task. Primary pool queue is barely growing while tasks
+// are routed to the overflow pool, overload is over once it's not seen for the window.
+func (p *CompositePool) route() ManagedPool {
+	now := time.Now().UnixNano()
+
+	if queued(p.primary) > p.threshold {
+		atomic.StoreInt64(&p.lastOver, now)
+		atomic.CompareAndSwapInt64(&p.overSince, 0, now)
+	} else if now-atomic.LoadInt64(&p.lastOver) > int64(p.window) {
+		atomic.StoreInt64(&p.overSince, 0)
+	}
+
+	if since := atomic.LoadInt64(&p.overSince); since != 0 && now-since >= int64(p.window) {
+		return p.overflow
+	}
+
+	return p.primary
+}
+
+// queueReporter is implemented by pools reporting number of the waiting tasks without
+// collecting the full statistics.
+type queueReporter interface {
+	queued() int
+}
+
+// queued returns number of the tasks waiting for the pool worker.
+func queued(p ManagedPool) int {
+	if r, ok := p.(queueReporter); ok {
+		return r.queued()
+	}
+
+	return p.Stats().Queued
+}
+
+// sumStats returns sum of the statistics of two pools, Breaker contains the most restrictive
+// breaker state and pools are paused once both pools are paused.
+func sumStats(a, b PoolStats) PoolStats {
+	stats := PoolStats{
+		NumWorkers:  a.NumWorkers + b.NumWorkers,
+		NumIdle:     a.NumIdle + b.NumIdle,
+		NumBusy:     a.NumBusy + b.NumBusy,
+		TotalExecs:  a.TotalExecs + b.TotalExecs,
+		TotalErrors: a.TotalErrors + b.TotalErrors,
+		Queued:      a.Queued + b.Queued,
+		Breaker:     a.Breaker,
+		Paused:      a.Paused && b.Paused,
+		Quarantined: a.Quarantined + b.Quarantined,
+		RespawnRate: a.RespawnRate + b.RespawnRate,
+		TotalMemory: a.TotalMemory + b.TotalMemory,
+	}
+
+	if stats.PeakMemory = a.PeakMemory; b.PeakMemory > stats.PeakMemory {
+		stats.PeakMemory = b.PeakMemory
+	}
+
+	// replacements of both pools are interleaved
+	switch {
+	case a.RollingReplaceCadence == 0:
+		stats.RollingReplaceCadence = b.RollingReplaceCadence
+	case b.RollingReplaceCadence == 0:
+		stats.RollingReplaceCadence = a.RollingReplaceCadence
+	default:
+		stats.RollingReplaceCadence = a.RollingReplaceCadence * b.RollingReplaceCadence /
+			(a.RollingReplaceCadence + b.RollingReplaceCadence)
+	}
+
+	for i := range stats.QueuedByPriority {
+		stats.QueuedByPriority[i] = a.QueuedByPriority[i] + b.QueuedByPriority[i]
+	}
+
+	if breakerRank(b.Breaker) > breakerRank(stats.Breaker) {
+		stats.Breaker = b.Breaker
+	}
+
+	for _, reasons := range []map[RecycleReason]int64{a.RecycleReasons, b.RecycleReasons} {
+		for reason, n := range reasons {
+			if stats.RecycleReasons == nil {
+				stats.RecycleReasons = make(map[RecycleReason]int64)
+			}
+
+			stats.RecycleReasons[reason] += n
+		}
+	}
+
+	return stats
+}
+
+// breakerRank orders breaker states from the least to the most restrictive.
+func breakerRank(s BreakerState) int {
+	switch s {
+	case BreakerHalfOpen:
+		return 1
+	case BreakerOpen:
+		return 2
+	}
+
+	return 0
+}
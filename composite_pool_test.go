@@ -0,0 +1,190 @@
+package roadrunner
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// stubPool reports given stats and responds with its name.
+type stubPool struct {
+	ManagedPool
+	name    string
+	stats   PoolStats
+	workers []*Worker
+	healthy error
+	removed []*Worker
+
+	// reports pool unhealthy without the reason
+	unhealthy bool
+}
+
+func (p *stubPool) Exec(rqs *Payload) (*Payload, error) {
+	return &Payload{Body: []byte(p.name)}, nil
+}
+
+func (p *stubPool) TryExec(rqs *Payload) (*Payload, bool, error) {
+	if p.stats.NumIdle == 0 {
+		return nil, false, nil
+	}
+
+	return &Payload{Body: []byte(p.name)}, true, nil
+}
+
+func (p *stubPool) Allocate(ctx context.Context) (*Worker, error) {
+	return p.workers[0], nil
+}
+
+func (p *stubPool) Release(w *Worker, broken bool) {
+	p.removed = append(p.removed, w)
+}
+
+func (p *stubPool) Remove(w *Worker, err error) bool {
+	p.removed = append(p.removed, w)
+	return true
+}
+
+func (p *stubPool) Workers() []*Worker {
+	return p.workers
+}
+
+func (p *stubPool) Stats() PoolStats {
+	return p.stats
+}
+
+func (p *stubPool) Healthy() (bool, error) {
+	return p.healthy == nil && !p.unhealthy, p.healthy
+}
+
+func Test_CompositePool_Route(t *testing.T) {
+	primary, overflow := &stubPool{name: "primary"}, &stubPool{name: "overflow"}
+	p := NewCompositePool(primary, overflow, 2, 0)
+
+	for queued, expected := range []string{"primary", "primary", "primary", "overflow"} {
+		primary.stats.Queued = queued
+
+		res, err := p.Exec(&Payload{Body: []byte("hello")})
+		assert.NoError(t, err)
+		assert.Equal(t, expected, res.String())
+	}
+}
+
+func Test_CompositePool_Window(t *testing.T) {
+	primary, overflow := &stubPool{name: "primary"}, &stubPool{name: "overflow"}
+	p := NewCompositePool(primary, overflow, 0, time.Millisecond*50)
+
+	exec := func() string {
+		res, err := p.Exec(&Payload{Body: []byte("hello")})
+		assert.NoError(t, err)
+		return res.String()
+	}
+
+	// short burst stays in the primary pool
+	primary.stats.Queued = 1
+	assert.Equal(t, "primary", exec())
+
+	time.Sleep(time.Millisecond * 60)
+	assert.Equal(t, "overflow", exec())
+
+	// overflow pool keeps receiving tasks until primary pool is not overloaded for the window
+	primary.stats.Queued = 0
+	assert.Equal(t, "overflow", exec())
+
+	time.Sleep(time.Millisecond * 60)
+	assert.Equal(t, "primary", exec())
+}
+
+func Test_CompositePool_TryExec(t *testing.T) {
+	primary, overflow := &stubPool{name: "primary"}, &stubPool{name: "overflow"}
+	p := NewCompositePool(primary, overflow, 10, 0)
+
+	_, acquired, _ := p.TryExec(&Payload{})
+	assert.False(t, acquired)
+
+	overflow.stats.NumIdle = 1
+	res, acquired, _ := p.TryExec(&Payload{})
+	assert.True(t, acquired)
+	assert.Equal(t, "overflow", res.String())
+
+	primary.stats.NumIdle = 1
+	res, acquired, _ = p.TryExec(&Payload{})
+	assert.True(t, acquired)
+	assert.Equal(t, "primary", res.String())
+}
+
+func Test_CompositePool_Allocate(t *testing.T) {
+	wp, wo := &Worker{}, &Worker{}
+	primary := &stubPool{name: "primary", workers: []*Worker{wp}, stats: PoolStats{Queued: 5}}
+	overflow := &stubPool{name: "overflow", workers: []*Worker{wo}}
+	p := NewCompositePool(primary, overflow, 0, 0)
+
+	w, err := p.Allocate(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, wo, w)
+
+	// worker is released to the pool it has been allocated from
+	primary.stats.Queued = 0
+	p.Release(w, false)
+	assert.Equal(t, []*Worker{wo}, overflow.removed)
+	assert.Empty(t, primary.removed)
+
+	assert.True(t, p.Remove(wp, nil))
+	assert.Equal(t, []*Worker{wp}, primary.removed)
+	assert.False(t, p.Remove(&Worker{}, nil))
+
+	assert.Equal(t, []*Worker{wp, wo}, p.Workers())
+}
+
+func Test_CompositePool_Stats(t *testing.T) {
+	primary := &stubPool{stats: PoolStats{
+		NumWorkers:     2,
+		NumIdle:        1,
+		NumBusy:        1,
+		TotalExecs:     10,
+		TotalErrors:    1,
+		Queued:         3,
+		Breaker:        BreakerHalfOpen,
+		RecycleReasons: map[RecycleReason]int64{RecycleMaxJobs: 2},
+	}}
+
+	overflow := &stubPool{stats: PoolStats{
+		NumWorkers:     4,
+		NumBusy:        4,
+		TotalExecs:     5,
+		Breaker:        BreakerClosed,
+		Paused:         true,
+		RecycleReasons: map[RecycleReason]int64{RecycleMaxJobs: 1, RecycleIdle: 3},
+	}}
+
+	p := NewCompositePool(primary, overflow, 0, 0)
+
+	assert.Equal(t, PoolStats{
+		NumWorkers:     6,
+		NumIdle:        1,
+		NumBusy:        5,
+		TotalExecs:     15,
+		TotalErrors:    1,
+		Queued:         3,
+		Breaker:        BreakerHalfOpen,
+		RecycleReasons: map[RecycleReason]int64{RecycleMaxJobs: 3, RecycleIdle: 3},
+	}, p.Stats())
+
+	overflow.stats.Breaker = BreakerOpen
+	assert.Equal(t, BreakerOpen, p.Stats().Breaker)
+}
+
+func Test_CompositePool_Healthy(t *testing.T) {
+	primary, overflow := &stubPool{}, &stubPool{}
+	p := NewCompositePool(primary, overflow, 0, 0)
+
+	ok, err := p.Healthy()
+	assert.True(t, ok)
+	assert.NoError(t, err)
+
+	overflow.healthy = fmt.Errorf("only 0/1 workers ready")
+	ok, err = p.Healthy()
+	assert.False(t, ok)
+	assert.Equal(t, "overflow pool: only 0/1 workers ready", err.Error())
+}
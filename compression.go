@@ -0,0 +1,80 @@
+package roadrunner
+
+import (
+	"compress/flate"
+	"io"
+	"net"
+	"sync"
+)
+
+// CompressionDeflate is the codec offered by the factory in the compress field of the PID
+// handshake (see SocketFactory.SetCompression). Worker supporting the codec echoes it in the
+// compress field of its handshake response, worker omitting the field keeps the connection
+// uncompressed. Once the handshake response has been received both directions of the connection
+// carry single raw deflate stream (RFC 1951) each, every write is completed by the sync flush so
+// frames are never held in the compressor. Goridge framing is applied to the uncompressed data, so
+// exec semantics stay the same.
+const CompressionDeflate = "deflate"
+
+// CompressionMode defines which accepted connections are offered the compression.
+type CompressionMode int
+
+const (
+	// CompressionOff never offers the compression, default.
+	CompressionOff CompressionMode = iota
+
+	// CompressionRemote offers the compression to the workers connected from the non loopback
+	// addresses only, CPU cost outweighs savings of the local connections.
+	CompressionRemote
+
+	// CompressionAlways offers the compression to every TCP or unix socket connection.
+	CompressionAlways
+)
+
+// offers returns true if compression has to be offered to the worker connected over conn.
+func (m CompressionMode) offers(conn net.Conn) bool {
+	switch m {
+	case CompressionAlways:
+		return true
+	case CompressionRemote:
+		addr, ok := conn.RemoteAddr().(*net.TCPAddr)
+		return ok && !addr.IP.IsLoopback()
+	}
+
+	return false
+}
+
+// deflateStream compresses data written to and decompresses data read from the connection.
+type deflateStream struct {
+	r io.ReadCloser
+
+	// protects the writer, flate writer can not be used concurrently
+	mu sync.Mutex
+	w  *flate.Writer
+}
+
+// newDeflateStream creates stream reading from r and writing to w.
+func newDeflateStream(r io.Reader, w io.Writer) *deflateStream {
+	// error is returned for invalid level only
+	zw, _ := flate.NewWriter(w, flate.DefaultCompression)
+
+	return &deflateStream{r: flate.NewReader(r), w: zw}
+}
+
+// Read reads decompressed data.
+func (s *deflateStream) Read(b []byte) (int, error) {
+	return s.r.Read(b)
+}
+
+// Write compresses the data and flushes it to the connection.
+func (s *deflateStream) Write(b []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n, err := s.w.Write(b)
+	if err != nil {
+		return n, err
+	}
+
+	return n, s.w.Flush()
+}
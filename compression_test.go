@@ -0,0 +1,140 @@
+package roadrunner
+
+import (
+	"bytes"
+	"context"
+	json "github.com/json-iterator/go"
+	"github.com/spiral/goridge/v2"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"os/exec"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingConn counts bytes read from the connection.
+type countingConn struct {
+	net.Conn
+	read int64
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	atomic.AddInt64(&c.read, int64(n))
+
+	return n, err
+}
+
+// deflateWorker connects to addr on behalf of worker with given pid, accepts offered compression
+// when accept is true and echoes one task. Offered codec and number of bytes read from the wire
+// during the task are sent to report.
+func deflateWorker(t *testing.T, addr string, pid int, accept bool, report chan<- [2]interface{}) {
+	conn, err := net.Dial("tcp", addr)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+
+	cc := &countingConn{Conn: conn}
+	fc := newFrameConn(cc)
+	rl := goridge.NewSocketRelay(fc)
+
+	data, _, err := rl.Receive()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	offer := pidCommand{}
+	assert.NoError(t, json.Unmarshal(data, &offer))
+
+	link := pidCommand{Pid: pid}
+	if accept {
+		link.Compress = offer.Compress
+	}
+	assert.NoError(t, sendControl(rl, link))
+
+	if link.Compress != "" {
+		fc.compress()
+	}
+	start := atomic.LoadInt64(&cc.read)
+
+	ctx, _, err := rl.Receive()
+	assert.NoError(t, err)
+	body, _, err := rl.Receive()
+	assert.NoError(t, err)
+
+	report <- [2]interface{}{offer.Compress, atomic.LoadInt64(&cc.read) - start}
+
+	assert.NoError(t, rl.Send(ctx, goridge.PayloadControl))
+	assert.NoError(t, rl.Send(body, goridge.PayloadRaw))
+}
+
+func Test_SocketFactory_Compression(t *testing.T) {
+	payload := bytes.Repeat([]byte(`{"name":"roadrunner","tags":["php","golang"]},`), 32*1024)
+
+	cases := []struct {
+		mode       CompressionMode
+		accept     bool
+		offered    string
+		compressed bool
+	}{
+		{mode: CompressionOff, accept: true},
+		{mode: CompressionRemote, accept: true},
+		{mode: CompressionAlways, accept: false, offered: CompressionDeflate},
+		{mode: CompressionAlways, accept: true, offered: CompressionDeflate, compressed: true},
+	}
+
+	for _, c := range cases {
+		ls, err := net.Listen("tcp", "localhost:0")
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		f := NewSocketFactory(ls, time.Minute)
+		f.SetCompression(c.mode)
+
+		w, _ := newWorker(exec.Command("sleep", "10"))
+		assert.NoError(t, w.start())
+
+		report := make(chan [2]interface{}, 1)
+		go deflateWorker(t, ls.Addr().String(), *w.Pid, c.accept, report)
+
+		rl, err := f.findRelay(context.Background(), 0, w, time.Second)
+		if !assert.NoError(t, err) {
+			w.Kill()
+			return
+		}
+
+		ar := f.accepted(0, rl)
+		w.rl, w.conn, w.frames = rl, ar.conn, ar.conn.frames
+		w.state.set(StateReady)
+		assert.Equal(t, c.compressed, w.conn.compressed())
+
+		res, err := w.Exec(&Payload{Context: []byte(`{"json":true}`), Body: payload})
+		assert.NoError(t, err)
+		assert.Equal(t, `{"json":true}`, string(res.Context))
+		assert.Equal(t, payload, res.Body)
+
+		r := <-report
+		assert.Equal(t, c.offered, r[0])
+		if c.compressed {
+			assert.Less(t, r[1], int64(len(payload)/10))
+		} else {
+			assert.Greater(t, r[1], int64(len(payload)))
+		}
+
+		w.Kill()
+		assert.NoError(t, f.Close())
+	}
+}
+
+func Test_CompressionMode_Offers(t *testing.T) {
+	local, remote := connPair(t)
+	defer local.Close()
+	defer remote.Close()
+
+	assert.False(t, CompressionOff.offers(local))
+	assert.False(t, CompressionRemote.offers(local))
+	assert.True(t, CompressionAlways.offers(local))
+}
@@ -2,10 +2,36 @@ package roadrunner
 
 import (
 	"fmt"
+	"io"
 	"runtime"
 	"time"
 )
 
+// SelectionStrategy defines how pool picks free worker for the task.
+type SelectionStrategy string
+
+const (
+	// SelectFIFO picks first available worker (default).
+	SelectFIFO SelectionStrategy = "fifo"
+
+	// SelectRoundRobin cycles through all workers evenly, even under low load.
+	SelectRoundRobin SelectionStrategy = "roundrobin"
+
+	// SelectLeastUsed picks free worker with the fewest number of executions.
+	SelectLeastUsed SelectionStrategy = "leastused"
+)
+
+// Selector picks the worker for the task when multiple workers are free, allows custom
+// scheduling policies such as externally measured load or affinity.
+type Selector interface {
+	// Select returns one of the candidates, candidates contain only ready workers not pending
+	// removal. Returning nil or worker not listed in candidates selects the first candidate.
+	Select(candidates []*Worker) *Worker
+}
+
+// DefaultWatchdogTimeout is the WatchdogTimeout used when not set.
+const DefaultWatchdogTimeout = 5 * time.Minute
+
 // Config defines basic behaviour of worker creation and handling process.
 type Config struct {
 	// NumWorkers defines how many sub-processes can be run at once. This value
@@ -17,13 +43,282 @@ type Config struct {
 	// worker handle as many tasks as it can.
 	MaxJobs int64
 
+	// MaxMemory defines maximum amount of memory (RSS) allowed for worker to consume after
+	// the execution, worker is replaced once limit is reached. In megabytes, 0 to disable. On
+	// Linux memory.current of the cgroup v2 is used instead of RSS for workers placed into the
+	// dedicated cgroup (not shared with the current process), matching the kernel accounting
+	// used by OOM decisions.
+	MaxMemory uint64
+
+	// MaxTotalMemory defines memory budget of the whole pool in megabytes, memory accounted to all
+	// workers (see MaxMemory) is sampled every MemoryCheckInterval and idle workers consuming the
+	// most memory are recycled once total reaches 90% of the budget, until total drops below 90%.
+	// Fresh worker starts smaller, all workers growing at once can not exhaust the host. Trimmed
+	// worker is always stopped before its replacement is spawned, see RecycleKillFirst. Set 0 to
+	// disable.
+	MaxTotalMemory uint64
+
+	// MemoryCheckInterval defines how often total memory of the workers is sampled for the
+	// MaxTotalMemory, every second when not set.
+	MemoryCheckInterval time.Duration
+
+	// MaxAge defines for how long worker can live, worker is replaced once it becomes idle after
+	// reaching the age. Idle workers are checked every quarter of MaxAge, once a millisecond at
+	// most. Set 0 to disable.
+	MaxAge time.Duration
+
+	// RollingReplaceInterval defines the window within which all workers are continuously
+	// replaced, the oldest worker is replaced every RollingReplaceInterval / NumWorkers so
+	// replacements are spread evenly and workers do not restart at once as they would when
+	// reaching MaxAge together. Worker lives for about the interval. Set 0 to disable.
+	RollingReplaceInterval time.Duration
+
 	// AllocateTimeout defines for how long pool will be waiting for a worker to
 	// be freed to handle the task.
 	AllocateTimeout time.Duration
 
+	// MaxWait limits for how long task can wait in the queue for the free worker, task gives
+	// up with ErrAllocTimeout once limit is reached even if it's context allows to wait longer.
+	// Limits AllocateTimeout, set 0 to wait for AllocateTimeout.
+	MaxWait time.Duration
+
 	// DestroyTimeout defines for how long pool should be waiting for worker to
 	// properly stop, if timeout reached worker will be killed.
 	DestroyTimeout time.Duration
+
+	// RecycleKillFirst stops worker recycled due to MaxJobs, MaxAge, MaxMemory or unhealthy report
+	// before its replacement is spawned. By default replacement is spawned first and recycled worker
+	// keeps serving (possibly past its limits) until replacement is ready, pool capacity does not
+	// drop during the recycle. Set on memory-constrained hosts which can not run extra process.
+	RecycleKillFirst bool
+
+	// KillGracePeriod defines for how long recycled worker which failed to stop within
+	// DestroyTimeout is given to exit after SIGTERM before being killed, lets worker run
+	// its shutdown handlers. Broken and timed out workers are killed right away. Set 0 to
+	// kill immediately.
+	KillGracePeriod time.Duration
+
+	// MaxExecRetries defines how many times idempotent task (see Payload.Idempotent) is replayed
+	// on another worker when worker dies or times out during the execution, retries are delayed
+	// with exponential backoff. Retries are unsafe for tasks which are not idempotent and disabled
+	// by default.
+	MaxExecRetries int64
+
+	// SendRequestID passes request ID of every task (see Payload.RequestID) to the worker in the
+	// task context under RequestIDKey, for example to emit it in the worker logs.
+	SendRequestID bool
+
+	// ExecTimeout defines maximum duration of the task execution, worker is killed and
+	// replaced once timeout is reached. Set 0 to disable.
+	ExecTimeout time.Duration
+
+	// SlowLogThreshold defines duration of the task execution by the worker after which task is
+	// logged at Warn level along with worker PID and payload size. Worker allocation is not
+	// included, see SlowLogQueueWait. Set 0 to disable.
+	SlowLogThreshold time.Duration
+
+	// SlowLogQueueWait additionally logs tasks which waited for the free worker longer than
+	// SlowLogThreshold.
+	SlowLogQueueWait bool
+
+	// IdleReadTimeout defines for how long socket relay read or write can wait for the data,
+	// worker is replaced once no bytes are transferred within the timeout. Must exceed the
+	// longest task duration, worker sends no data while processing. Unlike TCP keep-alive
+	// detects connected but stalled workers. Ignored for pipes. Set 0 to disable.
+	IdleReadTimeout time.Duration
+
+	// ResyncTimeout enables resync of the worker which relay stream might be out of sync, worker
+	// which timed out, failed to respond to the cancel command or sent unexpected frame receives
+	// sentinel control frame and stays in the pool if it echoes the sentinel within the timeout
+	// (see Worker.Resync). Worker failing the resync is replaced. Use for workers expensive to
+	// spawn only, worker code must support the sentinel echo. Set 0 to replace such workers
+	// immediately.
+	ResyncTimeout time.Duration
+
+	// HeartbeatInterval defines how often idle workers must be pinged, workers failed
+	// to respond are replaced. Set 0 to disable.
+	HeartbeatInterval time.Duration
+
+	// ProbeInterval defines how often idle workers execute the health probe task, deeper check
+	// than the heartbeat ping proving that the worker runtime is functional. Worker failing the
+	// probe, responding incorrectly or not responding within ProbeTimeout is replaced. Busy
+	// workers are skipped. Set 0 to disable.
+	ProbeInterval time.Duration
+
+	// ProbeTimeout limits execution of the health probe, a second when not set.
+	ProbeTimeout time.Duration
+
+	// ProbePayload is the body of the health probe task.
+	ProbePayload []byte
+
+	// ProbeResponse is the response body expected from the healthy worker, ignored when
+	// ProbeValidator is set.
+	ProbeResponse []byte
+
+	// ProbeValidator verifies the response to the health probe, returned error fails the probe.
+	// Response body is compared to ProbeResponse when not set.
+	ProbeValidator func(rsp *Payload) error
+
+	// Watchdog resets the static pool once it is wedged: tasks are queued but no worker has
+	// completed a task within WatchdogTimeout, as when all workers are stuck. All workers are
+	// replaced and the stuck ones are killed, the reset is logged as the error and reported as
+	// EventPoolError. Paused pool is never reset.
+	Watchdog bool
+
+	// WatchdogTimeout defines how long the queued tasks might wait without any progress before
+	// the pool is reset, DefaultWatchdogTimeout when not set. Must exceed ExecTimeout.
+	WatchdogTimeout time.Duration
+
+	// Clock drives worker heartbeat, MaxAge checks and priority aging, nil for the system clock.
+	Clock Clock
+
+	// Tracer receives spans of the task execution and worker start (see SpanExec), nil to
+	// disable tracing. Trace context is passed to the worker in task context, see
+	// TraceContextKey. Pool passes spawn span to the SocketFactory which reports relay wait to its
+	// own Tracer.
+	Tracer Tracer
+
+	// IdleCheckInterval defines how often idle workers are checked for the unhealthy command
+	// ({"unhealthy":true}) sent by the worker between the tasks, unhealthy workers are replaced.
+	// Workers are always checked when allocated and after the task. Check polls the number of
+	// unread relay bytes (TIOCINQ) instead of running a background reader per worker, hence is
+	// supported on Linux only and frames of other platforms are left unread until the next task.
+	// Set 0 to check only on allocation.
+	IdleCheckInterval time.Duration
+
+	// SpawnConcurrency limits how many workers can be started in parallel when pool is
+	// created. Set 0 to start workers one by one.
+	SpawnConcurrency int64
+
+	// SpawnJitter defines maximum random delay before each initial worker start, used to
+	// avoid all workers hitting shared resources at once. Set 0 to disable.
+	SpawnJitter time.Duration
+
+	// MinReady defines number of started workers pool is ready with, pool constructor returns
+	// once MinReady workers are started and remaining workers are started in background. Lower
+	// values speed up the cold start. Defaults to NumWorkers.
+	MinReady int64
+
+	// StartAsync makes pool constructor return immediately, all workers are started in
+	// background. Use WaitReady to wait for MinReady workers. Workers failing to start in
+	// background are handled like failed worker replacements: slot is respawned only when
+	// circuit breaker is enabled.
+	StartAsync bool
+
+	// RejectWhenNotReady makes tasks fail with ErrPoolNotReady while less than MinReady workers
+	// are started during the cold start, tasks wait for the pool readiness up to AllocateTimeout
+	// (or MaxWait) otherwise. Failed initial workers count towards the circuit breaker, tasks fail
+	// with ErrPoolUnavailable regardless of this option while breaker is open.
+	RejectWhenNotReady bool
+
+	// MaxQueueSize limits how many tasks can wait for a free worker, ErrQueueFull is returned
+	// once limit is reached. Waiting tasks are served by priority (see ExecPriority), tasks of
+	// the same priority in FIFO order. Set 0 for unlimited queue.
+	MaxQueueSize int64
+
+	// PriorityAging promotes task waiting for the free worker one priority band up every given
+	// interval, low priority tasks are starved by the constant flow of the higher priority tasks
+	// otherwise. Set 0 to disable.
+	PriorityAging time.Duration
+
+	// MaxPayloadSize limits size of task context and body in bytes, larger tasks are rejected
+	// with ErrPayloadTooLarge. Worker responding with larger frame is killed. Set 0 for unlimited.
+	MaxPayloadSize int64
+
+	// MaxResponseFrames limits number of frames worker may send in response to a single task,
+	// frames of the worker calls (see RegisterHandler) and frames found right after the response
+	// included. Worker exceeding the limit is killed as violating the protocol and task fails with
+	// ErrTooManyFrames. Chunks of the streamed responses and multiplexed workers are not limited.
+	// Set 0 for unlimited.
+	MaxResponseFrames int64
+
+	// MaxConsecutiveMalformed retires the worker once it has sent given number of malformed
+	// responses in a row (see ErrRelayProtocol) even when each of them has been tolerated, for
+	// example resynced per ResyncTimeout. Catches slowly degrading workers. Well-formed response,
+	// job errors included, resets the count (see Worker.ConsecutiveMalformed). Set 0 to disable.
+	MaxConsecutiveMalformed int64
+
+	// BreakerThreshold defines how many consecutive worker spawn failures open the circuit
+	// breaker, tasks fail with ErrPoolUnavailable and spawning is paused for BreakerCooldown
+	// once breaker is open. Then single trial spawn closes the breaker on success. Set 0 to disable.
+	BreakerThreshold int64
+
+	// BreakerWindow defines time window spawn failures must fit in to open the breaker. Set 0
+	// to count all consecutive failures.
+	BreakerWindow time.Duration
+
+	// BreakerCooldown defines for how long worker spawning is paused once breaker is open.
+	BreakerCooldown time.Duration
+
+	// RespawnRateLimit limits how many dead workers can be replaced per second (token bucket),
+	// replacements wait for the token while tasks wait or fail per AllocateTimeout, MaxWait and
+	// MaxQueueSize. Paces the recovery once all workers crash at once. Initial spawn, reloads and
+	// recycling are not limited. Set 0 for unlimited.
+	RespawnRateLimit float64
+
+	// RespawnBurst defines how many dead workers can be replaced at once before the rate limit
+	// applies, 0 for one.
+	RespawnBurst int64
+
+	// RespawnBackoff delays replacement of the crashed worker, delay is picked by the number of
+	// consecutive respawns of the worker slot which are reset once worker of the slot completes a
+	// task, see FixedBackoff and ExponentialBackoff. Delay is applied before the RespawnRateLimit,
+	// respawns of the slots failing to start are paced by both the backoff and the circuit breaker.
+	// Recycled workers are replaced right away. Set nil to respawn right away.
+	RespawnBackoff Backoff
+
+	// QuarantineThreshold defines how many consecutive failures (unexpected worker death or failed
+	// start) quarantine the worker slot, quarantined slot is not respawned for QuarantineCooldown
+	// and pool runs at reduced capacity meanwhile. Single respawn is attempted once cooldown
+	// expires, successful start clears the failures. Failures are also cleared once worker of the
+	// slot completes a task. Set 0 to disable.
+	QuarantineThreshold int64
+
+	// QuarantineCooldown defines for how long quarantined worker slot is not respawned.
+	QuarantineCooldown time.Duration
+
+	// SelectionStrategy defines how free worker is picked for the task, FIFO by default.
+	// Strategy can not be changed once pool is created.
+	SelectionStrategy SelectionStrategy
+
+	// Selector overrides SelectionStrategy and picks the free worker for the task (see Selector),
+	// selector is only called when more than one worker is free. Selector must not block, other
+	// tasks wait for the free worker meanwhile.
+	Selector Selector
+
+	// WorkerConcurrency defines how many tasks every worker executes at once, worker is checked
+	// out by up to WorkerConcurrency tasks and tasks are multiplexed over the relay (see
+	// Worker.SetConcurrency). For thread-capable workers only, can not be combined with
+	// HeartbeatInterval and ProbeInterval. Set 0 or 1 to execute one task at a time.
+	WorkerConcurrency int64
+
+	// CommandCheckArgs defines arguments worker executable is run with once when pool is created,
+	// for example "--version", executable must exit with 0 status. Pool creation fails with the
+	// executable output otherwise. Empty to only verify that executable exists.
+	CommandCheckArgs []string
+
+	// SkipCommandCheck disables verification of the worker executable on pool creation.
+	SkipCommandCheck bool
+
+	// WorkerIDPrefix prefixes slot index in the logical worker IDs (see Worker.ID), use it to tell
+	// workers of multiple pools apart. Defaults to DefaultWorkerIDPrefix.
+	WorkerIDPrefix string
+
+	// CPUAffinity pins workers to the CPU cores assigned by the worker slot index, Linux only.
+	// Disabled by default.
+	CPUAffinity CPUAffinity
+
+	// StdoutMode defines how stdout of the workers is handled, discarded by default. Ignored for
+	// pipes which use stdout as the relay.
+	StdoutMode StdoutMode
+
+	// StdoutWriter returns writer receiving stdout of the given worker in StdoutForward mode,
+	// output is logged line by line when nil. Write errors are ignored.
+	StdoutWriter func(wc WorkerConfig) io.Writer
+
+	// RejectWhenPaused makes tasks fail with ErrPoolPaused while pool is paused, tasks wait for
+	// the pool to be resumed otherwise.
+	RejectWhenPaused bool
 }
 
 // InitDefaults allows to init blank config with pre-defined set of default values.
@@ -41,13 +336,235 @@ func (cfg *Config) Valid() error {
 		return fmt.Errorf("pool.NumWorkers must be set")
 	}
 
-	if cfg.AllocateTimeout == 0 {
+	if err := cfg.options().valid(); err != nil {
+		return err
+	}
+
+	if cfg.SpawnConcurrency < 0 {
+		return fmt.Errorf("pool.SpawnConcurrency must be positive (0 for sequential)")
+	}
+
+	if cfg.MinReady < 0 || cfg.MinReady > cfg.NumWorkers {
+		return fmt.Errorf("pool.MinReady must be between 0 and pool.NumWorkers (0 for all workers)")
+	}
+
+	if cfg.MaxAge < 0 {
+		return fmt.Errorf("pool.MaxAge must be positive (0 to disable)")
+	}
+
+	if cfg.MemoryCheckInterval < 0 {
+		return fmt.Errorf("pool.MemoryCheckInterval must be positive (0 for a second)")
+	}
+
+	if cfg.RollingReplaceInterval < 0 {
+		return fmt.Errorf("pool.RollingReplaceInterval must be positive (0 to disable)")
+	}
+
+	if cfg.ProbeInterval < 0 {
+		return fmt.Errorf("pool.ProbeInterval must be positive (0 to disable)")
+	}
+
+	if cfg.ProbeTimeout < 0 {
+		return fmt.Errorf("pool.ProbeTimeout must be positive (0 for a second)")
+	}
+
+	if cfg.WatchdogTimeout < 0 {
+		return fmt.Errorf("pool.WatchdogTimeout must be positive (0 for 5 minutes)")
+	}
+
+	if cfg.Watchdog && cfg.ExecTimeout != 0 && cfg.watchdogTimeout() <= cfg.ExecTimeout {
+		return fmt.Errorf("pool.WatchdogTimeout must exceed pool.ExecTimeout")
+	}
+
+	if cfg.WorkerConcurrency > 1 && cfg.HeartbeatInterval != 0 {
+		return fmt.Errorf("pool.HeartbeatInterval can not be combined with pool.WorkerConcurrency")
+	}
+
+	if cfg.WorkerConcurrency > 1 && cfg.ProbeInterval != 0 {
+		return fmt.Errorf("pool.ProbeInterval can not be combined with pool.WorkerConcurrency")
+	}
+
+	if cfg.RespawnRateLimit < 0 {
+		return fmt.Errorf("pool.RespawnRateLimit must be positive (0 for unlimited)")
+	}
+
+	if cfg.RespawnBurst < 0 {
+		return fmt.Errorf("pool.RespawnBurst must be positive (0 for one)")
+	}
+
+	if cfg.QuarantineThreshold < 0 {
+		return fmt.Errorf("pool.QuarantineThreshold must be positive (0 to disable)")
+	}
+
+	if cfg.QuarantineThreshold != 0 && cfg.QuarantineCooldown <= 0 {
+		return fmt.Errorf("pool.QuarantineCooldown must be set")
+	}
+
+	switch cfg.SelectionStrategy {
+	case "", SelectFIFO, SelectRoundRobin, SelectLeastUsed:
+	default:
+		return fmt.Errorf("pool.SelectionStrategy must be one of fifo, roundrobin or leastused")
+	}
+
+	return nil
+}
+
+// options returns options shared with DynamicConfig.
+func (cfg *Config) options() poolOptions {
+	return poolOptions{
+		MaxJobs:                 cfg.MaxJobs,
+		AllocateTimeout:         cfg.AllocateTimeout,
+		MaxWait:                 cfg.MaxWait,
+		DestroyTimeout:          cfg.DestroyTimeout,
+		KillGracePeriod:         cfg.KillGracePeriod,
+		MaxExecRetries:          cfg.MaxExecRetries,
+		SendRequestID:           cfg.SendRequestID,
+		ExecTimeout:             cfg.ExecTimeout,
+		SlowLogThreshold:        cfg.SlowLogThreshold,
+		IdleReadTimeout:         cfg.IdleReadTimeout,
+		ResyncTimeout:           cfg.ResyncTimeout,
+		Tracer:                  cfg.Tracer,
+		IdleCheckInterval:       cfg.IdleCheckInterval,
+		MaxQueueSize:            cfg.MaxQueueSize,
+		PriorityAging:           cfg.PriorityAging,
+		MaxPayloadSize:          cfg.MaxPayloadSize,
+		MaxResponseFrames:       cfg.MaxResponseFrames,
+		MaxConsecutiveMalformed: cfg.MaxConsecutiveMalformed,
+		BreakerThreshold:        cfg.BreakerThreshold,
+		BreakerWindow:           cfg.BreakerWindow,
+		BreakerCooldown:         cfg.BreakerCooldown,
+		WorkerConcurrency:       cfg.WorkerConcurrency,
+		WorkerIDPrefix:          cfg.WorkerIDPrefix,
+		CPUAffinity:             cfg.CPUAffinity,
+		StdoutMode:              cfg.StdoutMode,
+		StdoutWriter:            cfg.StdoutWriter,
+	}
+}
+
+// poolOptions defines worker lifecycle options shared by Config and DynamicConfig, see Config
+// for the meaning of every option. Options missing in DynamicConfig are disabled for the dynamic
+// pool.
+type poolOptions struct {
+	MaxJobs                 int64
+	AllocateTimeout         time.Duration
+	MaxWait                 time.Duration
+	DestroyTimeout          time.Duration
+	KillGracePeriod         time.Duration
+	MaxExecRetries          int64
+	SendRequestID           bool
+	ExecTimeout             time.Duration
+	SlowLogThreshold        time.Duration
+	IdleReadTimeout         time.Duration
+	ResyncTimeout           time.Duration
+	Tracer                  Tracer
+	IdleCheckInterval       time.Duration
+	MaxQueueSize            int64
+	PriorityAging           time.Duration
+	MaxPayloadSize          int64
+	MaxResponseFrames       int64
+	MaxConsecutiveMalformed int64
+	BreakerThreshold        int64
+	BreakerWindow           time.Duration
+	BreakerCooldown         time.Duration
+	WorkerConcurrency       int64
+	WorkerIDPrefix          string
+	CPUAffinity             CPUAffinity
+	StdoutMode              StdoutMode
+	StdoutWriter            func(wc WorkerConfig) io.Writer
+}
+
+// valid returns error if shared options are not valid.
+func (o poolOptions) valid() error {
+	if o.AllocateTimeout == 0 {
 		return fmt.Errorf("pool.AllocateTimeout must be set")
 	}
 
-	if cfg.DestroyTimeout == 0 {
+	if o.DestroyTimeout == 0 {
 		return fmt.Errorf("pool.DestroyTimeout must be set")
 	}
 
+	if o.KillGracePeriod < 0 {
+		return fmt.Errorf("pool.KillGracePeriod must be positive (0 to kill immediately)")
+	}
+
+	if o.MaxJobs < 0 {
+		return fmt.Errorf("pool.MaxJobs must be positive (0 for unlimited)")
+	}
+
+	if o.IdleCheckInterval < 0 {
+		return fmt.Errorf("pool.IdleCheckInterval must be positive (0 to disable)")
+	}
+
+	if o.MaxWait < 0 {
+		return fmt.Errorf("pool.MaxWait must be positive (0 to use AllocateTimeout)")
+	}
+
+	if o.MaxQueueSize < 0 {
+		return fmt.Errorf("pool.MaxQueueSize must be positive (0 for unlimited)")
+	}
+
+	if o.PriorityAging < 0 {
+		return fmt.Errorf("pool.PriorityAging must be positive (0 to disable)")
+	}
+
+	if o.SlowLogThreshold < 0 {
+		return fmt.Errorf("pool.SlowLogThreshold must be positive (0 to disable)")
+	}
+
+	if o.IdleReadTimeout < 0 {
+		return fmt.Errorf("pool.IdleReadTimeout must be positive (0 to disable)")
+	}
+
+	if o.ResyncTimeout < 0 {
+		return fmt.Errorf("pool.ResyncTimeout must be positive (0 to disable)")
+	}
+
+	if err := o.CPUAffinity.valid(); err != nil {
+		return err
+	}
+
+	if o.MaxPayloadSize < 0 {
+		return fmt.Errorf("pool.MaxPayloadSize must be positive (0 for unlimited)")
+	}
+
+	if o.MaxResponseFrames < 0 {
+		return fmt.Errorf("pool.MaxResponseFrames must be positive (0 for unlimited)")
+	}
+
+	if o.MaxConsecutiveMalformed < 0 {
+		return fmt.Errorf("pool.MaxConsecutiveMalformed must be positive (0 to disable)")
+	}
+
+	if o.MaxExecRetries < 0 {
+		return fmt.Errorf("pool.MaxExecRetries must be positive (0 to disable)")
+	}
+
+	if o.WorkerConcurrency < 0 {
+		return fmt.Errorf("pool.WorkerConcurrency must be positive (0 for one task at a time)")
+	}
+
+	if o.BreakerThreshold < 0 {
+		return fmt.Errorf("pool.BreakerThreshold must be positive (0 to disable)")
+	}
+
+	if o.BreakerThreshold != 0 && o.BreakerCooldown <= 0 {
+		return fmt.Errorf("pool.BreakerCooldown must be set")
+	}
+
+	switch o.StdoutMode {
+	case "", StdoutDiscard, StdoutBuffer, StdoutForward:
+	default:
+		return fmt.Errorf("pool.StdoutMode must be one of discard, buffer or forward")
+	}
+
 	return nil
 }
+
+// watchdogTimeout returns WatchdogTimeout or its default.
+func (cfg *Config) watchdogTimeout() time.Duration {
+	if cfg.WatchdogTimeout == 0 {
+		return DefaultWatchdogTimeout
+	}
+
+	return cfg.WatchdogTimeout
+}
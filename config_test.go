@@ -49,3 +49,375 @@ func Test_DestroyTimeout(t *testing.T) {
 	assert.NotNil(t, err)
 	assert.Equal(t, "pool.DestroyTimeout must be set", err.Error())
 }
+
+func Test_MaxJobs(t *testing.T) {
+	cfg := Config{
+		NumWorkers:      10,
+		MaxJobs:         -1,
+		AllocateTimeout: time.Second,
+		DestroyTimeout:  time.Second,
+	}
+	err := cfg.Valid()
+
+	assert.NotNil(t, err)
+	assert.Equal(t, "pool.MaxJobs must be positive (0 for unlimited)", err.Error())
+}
+
+func Test_SpawnConcurrency(t *testing.T) {
+	cfg := Config{
+		NumWorkers:       10,
+		SpawnConcurrency: -1,
+		AllocateTimeout:  time.Second,
+		DestroyTimeout:   time.Second,
+	}
+	err := cfg.Valid()
+
+	assert.NotNil(t, err)
+	assert.Equal(t, "pool.SpawnConcurrency must be positive (0 for sequential)", err.Error())
+}
+
+func Test_SelectionStrategy(t *testing.T) {
+	cfg := Config{
+		NumWorkers:        10,
+		AllocateTimeout:   time.Second,
+		DestroyTimeout:    time.Second,
+		SelectionStrategy: "random",
+	}
+	err := cfg.Valid()
+
+	assert.NotNil(t, err)
+	assert.Equal(t, "pool.SelectionStrategy must be one of fifo, roundrobin or leastused", err.Error())
+
+	cfg.SelectionStrategy = SelectRoundRobin
+	assert.NoError(t, cfg.Valid())
+}
+
+func Test_MaxQueueSize(t *testing.T) {
+	cfg := Config{
+		NumWorkers:      10,
+		MaxQueueSize:    -1,
+		AllocateTimeout: time.Second,
+		DestroyTimeout:  time.Second,
+	}
+	err := cfg.Valid()
+
+	assert.NotNil(t, err)
+	assert.Equal(t, "pool.MaxQueueSize must be positive (0 for unlimited)", err.Error())
+}
+
+func Test_MaxAge(t *testing.T) {
+	cfg := Config{
+		NumWorkers:      10,
+		MaxAge:          -time.Second,
+		AllocateTimeout: time.Second,
+		DestroyTimeout:  time.Second,
+	}
+	err := cfg.Valid()
+
+	assert.NotNil(t, err)
+	assert.Equal(t, "pool.MaxAge must be positive (0 to disable)", err.Error())
+}
+
+func Test_Config_MaxResponseFrames(t *testing.T) {
+	cfg := Config{
+		NumWorkers:        10,
+		MaxResponseFrames: -1,
+		AllocateTimeout:   time.Second,
+		DestroyTimeout:    time.Second,
+	}
+	err := cfg.Valid()
+
+	assert.NotNil(t, err)
+	assert.Equal(t, "pool.MaxResponseFrames must be positive (0 for unlimited)", err.Error())
+}
+
+func Test_Config_MaxConsecutiveMalformed(t *testing.T) {
+	cfg := Config{
+		NumWorkers:              10,
+		MaxConsecutiveMalformed: -1,
+		AllocateTimeout:         time.Second,
+		DestroyTimeout:          time.Second,
+	}
+	err := cfg.Valid()
+
+	assert.NotNil(t, err)
+	assert.Equal(t, "pool.MaxConsecutiveMalformed must be positive (0 to disable)", err.Error())
+}
+
+func Test_MemoryCheckInterval(t *testing.T) {
+	cfg := Config{
+		NumWorkers:          10,
+		MaxTotalMemory:      100,
+		MemoryCheckInterval: -time.Second,
+		AllocateTimeout:     time.Second,
+		DestroyTimeout:      time.Second,
+	}
+	err := cfg.Valid()
+
+	assert.NotNil(t, err)
+	assert.Equal(t, "pool.MemoryCheckInterval must be positive (0 for a second)", err.Error())
+}
+
+func Test_RollingReplaceInterval(t *testing.T) {
+	cfg := Config{
+		NumWorkers:             10,
+		RollingReplaceInterval: -time.Second,
+		AllocateTimeout:        time.Second,
+		DestroyTimeout:         time.Second,
+	}
+	err := cfg.Valid()
+
+	assert.NotNil(t, err)
+	assert.Equal(t, "pool.RollingReplaceInterval must be positive (0 to disable)", err.Error())
+}
+
+func Test_Config_MaxPayloadSize(t *testing.T) {
+	cfg := Config{
+		NumWorkers:      10,
+		MaxPayloadSize:  -1,
+		AllocateTimeout: time.Second,
+		DestroyTimeout:  time.Second,
+	}
+	err := cfg.Valid()
+
+	assert.NotNil(t, err)
+	assert.Equal(t, "pool.MaxPayloadSize must be positive (0 for unlimited)", err.Error())
+}
+
+func Test_IdleReadTimeout(t *testing.T) {
+	cfg := Config{
+		NumWorkers:      10,
+		IdleReadTimeout: -1,
+		AllocateTimeout: time.Second,
+		DestroyTimeout:  time.Second,
+	}
+	err := cfg.Valid()
+
+	assert.NotNil(t, err)
+	assert.Equal(t, "pool.IdleReadTimeout must be positive (0 to disable)", err.Error())
+}
+
+func Test_ResyncTimeout(t *testing.T) {
+	cfg := Config{
+		NumWorkers:      10,
+		ResyncTimeout:   -1,
+		AllocateTimeout: time.Second,
+		DestroyTimeout:  time.Second,
+	}
+	err := cfg.Valid()
+
+	assert.NotNil(t, err)
+	assert.Equal(t, "pool.ResyncTimeout must be positive (0 to disable)", err.Error())
+}
+
+func Test_MaxWait(t *testing.T) {
+	cfg := Config{
+		NumWorkers:      10,
+		MaxWait:         -1,
+		AllocateTimeout: time.Second,
+		DestroyTimeout:  time.Second,
+	}
+	err := cfg.Valid()
+
+	assert.NotNil(t, err)
+	assert.Equal(t, "pool.MaxWait must be positive (0 to use AllocateTimeout)", err.Error())
+}
+
+func Test_BreakerThreshold(t *testing.T) {
+	cfg := Config{
+		NumWorkers:       10,
+		BreakerThreshold: 3,
+		AllocateTimeout:  time.Second,
+		DestroyTimeout:   time.Second,
+	}
+	err := cfg.Valid()
+
+	assert.NotNil(t, err)
+	assert.Equal(t, "pool.BreakerCooldown must be set", err.Error())
+
+	cfg.BreakerCooldown = time.Second
+	assert.NoError(t, cfg.Valid())
+}
+
+func Test_QuarantineThreshold(t *testing.T) {
+	cfg := Config{
+		NumWorkers:          10,
+		QuarantineThreshold: -1,
+		AllocateTimeout:     time.Second,
+		DestroyTimeout:      time.Second,
+	}
+	err := cfg.Valid()
+
+	assert.NotNil(t, err)
+	assert.Equal(t, "pool.QuarantineThreshold must be positive (0 to disable)", err.Error())
+
+	cfg.QuarantineThreshold = 3
+	err = cfg.Valid()
+
+	assert.NotNil(t, err)
+	assert.Equal(t, "pool.QuarantineCooldown must be set", err.Error())
+
+	cfg.QuarantineCooldown = time.Second
+	assert.NoError(t, cfg.Valid())
+}
+
+func Test_MinReady(t *testing.T) {
+	cfg := Config{
+		NumWorkers:      2,
+		MinReady:        3,
+		AllocateTimeout: time.Second,
+		DestroyTimeout:  time.Second,
+	}
+	err := cfg.Valid()
+
+	assert.NotNil(t, err)
+	assert.Equal(t, "pool.MinReady must be between 0 and pool.NumWorkers (0 for all workers)", err.Error())
+
+	cfg.MinReady = 1
+	assert.NoError(t, cfg.Valid())
+}
+
+func Test_MaxExecRetries(t *testing.T) {
+	cfg := Config{
+		NumWorkers:      10,
+		MaxExecRetries:  -1,
+		AllocateTimeout: time.Second,
+		DestroyTimeout:  time.Second,
+	}
+	err := cfg.Valid()
+
+	assert.NotNil(t, err)
+	assert.Equal(t, "pool.MaxExecRetries must be positive (0 to disable)", err.Error())
+}
+
+func Test_Config_WorkerConcurrency(t *testing.T) {
+	cfg := Config{
+		NumWorkers:        10,
+		WorkerConcurrency: -1,
+		AllocateTimeout:   time.Second,
+		DestroyTimeout:    time.Second,
+	}
+	err := cfg.Valid()
+
+	assert.NotNil(t, err)
+	assert.Equal(t, "pool.WorkerConcurrency must be positive (0 for one task at a time)", err.Error())
+
+	cfg.WorkerConcurrency = 4
+	cfg.HeartbeatInterval = time.Second
+	err = cfg.Valid()
+
+	assert.NotNil(t, err)
+	assert.Equal(t, "pool.HeartbeatInterval can not be combined with pool.WorkerConcurrency", err.Error())
+
+	cfg.HeartbeatInterval = 0
+	cfg.ProbeInterval = time.Second
+	err = cfg.Valid()
+
+	assert.NotNil(t, err)
+	assert.Equal(t, "pool.ProbeInterval can not be combined with pool.WorkerConcurrency", err.Error())
+
+	cfg.ProbeInterval = 0
+	assert.NoError(t, cfg.Valid())
+}
+
+func Test_Config_KillGracePeriod(t *testing.T) {
+	cfg := Config{
+		NumWorkers:      10,
+		KillGracePeriod: -time.Second,
+		AllocateTimeout: time.Second,
+		DestroyTimeout:  time.Second,
+	}
+	err := cfg.Valid()
+
+	assert.NotNil(t, err)
+	assert.Equal(t, "pool.KillGracePeriod must be positive (0 to kill immediately)", err.Error())
+}
+
+func Test_Config_IdleCheckInterval(t *testing.T) {
+	cfg := Config{
+		NumWorkers:        10,
+		IdleCheckInterval: -time.Second,
+		AllocateTimeout:   time.Second,
+		DestroyTimeout:    time.Second,
+	}
+	err := cfg.Valid()
+
+	assert.NotNil(t, err)
+	assert.Equal(t, "pool.IdleCheckInterval must be positive (0 to disable)", err.Error())
+}
+
+func Test_Config_SlowLogThreshold(t *testing.T) {
+	cfg := Config{
+		NumWorkers:       10,
+		SlowLogThreshold: -time.Second,
+		AllocateTimeout:  time.Second,
+		DestroyTimeout:   time.Second,
+	}
+	err := cfg.Valid()
+
+	assert.NotNil(t, err)
+	assert.Equal(t, "pool.SlowLogThreshold must be positive (0 to disable)", err.Error())
+}
+
+func Test_Config_StdoutMode(t *testing.T) {
+	cfg := Config{
+		NumWorkers:      10,
+		StdoutMode:      "stderr",
+		AllocateTimeout: time.Second,
+		DestroyTimeout:  time.Second,
+	}
+	err := cfg.Valid()
+
+	assert.NotNil(t, err)
+	assert.Equal(t, "pool.StdoutMode must be one of discard, buffer or forward", err.Error())
+}
+
+func Test_Config_PriorityAging(t *testing.T) {
+	cfg := Config{
+		NumWorkers:      10,
+		PriorityAging:   -time.Second,
+		AllocateTimeout: time.Second,
+		DestroyTimeout:  time.Second,
+	}
+	err := cfg.Valid()
+
+	assert.NotNil(t, err)
+	assert.Equal(t, "pool.PriorityAging must be positive (0 to disable)", err.Error())
+}
+
+func Test_Config_RespawnRateLimit(t *testing.T) {
+	cfg := Config{
+		NumWorkers:       10,
+		RespawnRateLimit: -1,
+		AllocateTimeout:  time.Second,
+		DestroyTimeout:   time.Second,
+	}
+	err := cfg.Valid()
+
+	assert.NotNil(t, err)
+	assert.Equal(t, "pool.RespawnRateLimit must be positive (0 for unlimited)", err.Error())
+}
+
+func Test_Config_WatchdogTimeout(t *testing.T) {
+	cfg := Config{
+		NumWorkers:      10,
+		WatchdogTimeout: -time.Second,
+		AllocateTimeout: time.Second,
+		DestroyTimeout:  time.Second,
+	}
+	err := cfg.Valid()
+
+	assert.NotNil(t, err)
+	assert.Equal(t, "pool.WatchdogTimeout must be positive (0 for 5 minutes)", err.Error())
+
+	cfg.Watchdog = true
+	cfg.WatchdogTimeout = 0
+	cfg.ExecTimeout = 10 * time.Minute
+	err = cfg.Valid()
+
+	assert.NotNil(t, err)
+	assert.Equal(t, "pool.WatchdogTimeout must exceed pool.ExecTimeout", err.Error())
+
+	cfg.WatchdogTimeout = 20 * time.Minute
+	assert.NoError(t, cfg.Valid())
+}
@@ -0,0 +1,124 @@
+package roadrunner
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/spiral/goridge/v2"
+	"strings"
+	"sync/atomic"
+)
+
+// controlCommand precedes the arguments frame of the control command sent by Worker.Control.
+// Worker responds with the single frame: raw frame carrying the command response or error control
+// frame carrying the error message. Worker advertises supported commands during the handshake,
+// see CapabilityControls.
+type controlCommand struct {
+	Control string `json:"control"`
+}
+
+// Control sends control command with given arguments to the idle worker and returns the worker
+// response, for example to start profiling, flush caches or reload configuration without
+// replacing the worker. Command must be advertised by the worker during the handshake (see
+// CapabilityControls), error returned by the worker is returned as JobError and keeps the worker
+// ready. Worker which did not respond before ctx is done is marked as errored and killed, context
+// error is returned. Pool workers must be allocated first, see StaticPool.Allocate. Not supported
+// by multiplexed workers.
+func (w *Worker) Control(ctx context.Context, cmd string, args []byte) (resp []byte, err error) {
+	if !w.supportsControl(cmd) {
+		return nil, fmt.Errorf("control command `%s` is not supported by the worker", cmd)
+	}
+
+	if w.mux != nil {
+		return nil, fmt.Errorf("control commands are not supported by multiplexed workers")
+	}
+
+	w.mu.Lock()
+
+	if w.state.Value() != StateReady {
+		w.mu.Unlock()
+		return nil, fmt.Errorf("worker is not ready (%s)", w.state.String())
+	}
+
+	if err := ctx.Err(); err != nil {
+		w.mu.Unlock()
+		return nil, err
+	}
+
+	w.state.set(StateWorking)
+
+	// buffered to let the command complete once worker is killed
+	done := make(chan execResult, 1)
+	go func() {
+		data, err := w.control(cmd, args)
+		done <- execResult{rsp: &Payload{Body: data}, err: err}
+	}()
+
+	select {
+	case r := <-done:
+		if r.err != nil {
+			if _, ok := r.err.(JobError); !ok {
+				w.state.set(StateErrored)
+				w.mu.Unlock()
+				return nil, r.err
+			}
+		}
+
+		w.state.set(StateReady)
+		w.mu.Unlock()
+		return r.rsp.Body, r.err
+
+	case <-ctx.Done():
+		w.state.set(StateErrored)
+		w.mu.Unlock()
+		atomic.StoreInt32(&w.timedOut, 1)
+
+		// relay is closed once process is dead, releasing pending command
+		if err := w.Kill(); err != nil {
+			return nil, errors.Wrap(err, ctx.Err().Error())
+		}
+
+		return nil, ctx.Err()
+	}
+}
+
+// supportsControl returns true if worker advertised the control command.
+func (w *Worker) supportsControl(cmd string) bool {
+	for _, supported := range strings.Split(w.meta[CapabilityControls], ",") {
+		if supported != "" && supported == cmd {
+			return true
+		}
+	}
+
+	return false
+}
+
+// control exchanges control command with the worker. Must be called under mu.
+func (w *Worker) control(cmd string, args []byte) ([]byte, error) {
+	if err := sendControl(w.rl, controlCommand{Control: cmd}); err != nil {
+		return nil, w.wrapError(err, "header error")
+	}
+
+	if err := w.rl.Send(args, goridge.PayloadRaw); err != nil {
+		return nil, w.wrapError(err, "sender error")
+	}
+
+	data, pr, err := w.receiveFrame()
+	if err != nil {
+		return nil, w.receiveError(err)
+	}
+
+	if err := w.checkStream(); err != nil {
+		return nil, err
+	}
+
+	if pr.HasFlag(goridge.PayloadControl) {
+		if pr.HasFlag(goridge.PayloadError) {
+			return nil, JobError(data)
+		}
+
+		return nil, w.wrapError(fmt.Errorf("unexpected response to control command `%s`", cmd), "worker error")
+	}
+
+	return data, nil
+}
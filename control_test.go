@@ -0,0 +1,141 @@
+package roadrunner
+
+import (
+	"context"
+	"github.com/spiral/goridge/v2"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"os/exec"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_Worker_Control(t *testing.T) {
+	w := relayWorker(t, func(rl goridge.Relay) {
+		_ = rl.Send([]byte("flushed"), goridge.PayloadRaw)
+		respondTask(rl, "next")
+	})
+	defer w.Kill()
+	w.meta = map[string]string{CapabilityControls: "profile,flush"}
+
+	rsp, err := w.Control(context.Background(), "flush", []byte("all"))
+	assert.NoError(t, err)
+	assert.Equal(t, "flushed", string(rsp))
+	assert.Equal(t, StateReady, w.State().Value())
+	assert.Equal(t, int64(0), w.State().NumExecs())
+
+	res, err := w.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, "next", res.String())
+}
+
+func Test_Worker_Control_Unsupported(t *testing.T) {
+	w := relayWorker(t, func(rl goridge.Relay) {
+		respondTask(rl, "next")
+	})
+	defer w.Kill()
+	w.meta = map[string]string{CapabilityControls: "profile"}
+
+	_, err := w.Control(context.Background(), "flush", nil)
+	assert.Error(t, err)
+	assert.Equal(t, "control command `flush` is not supported by the worker", err.Error())
+	assert.Equal(t, StateReady, w.State().Value())
+}
+
+func Test_Worker_Control_Error(t *testing.T) {
+	w := relayWorker(t, func(rl goridge.Relay) {
+		_ = rl.Send([]byte("profiler is not installed"), goridge.PayloadControl|goridge.PayloadError)
+		respondTask(rl, "next")
+	})
+	defer w.Kill()
+	w.meta = map[string]string{CapabilityControls: "profile"}
+
+	_, err := w.Control(context.Background(), "profile", []byte("/tmp/profile"))
+	assert.Error(t, err)
+	assert.IsType(t, JobError{}, err)
+	assert.Equal(t, "profiler is not installed", err.Error())
+	assert.Equal(t, StateReady, w.State().Value())
+
+	res, err := w.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, "next", res.String())
+}
+
+func Test_Worker_Control_UnexpectedResponse(t *testing.T) {
+	w := relayWorker(t, func(rl goridge.Relay) {
+		_ = rl.Send([]byte("{}"), goridge.PayloadControl)
+	})
+	defer w.Kill()
+	w.meta = map[string]string{CapabilityControls: "flush"}
+
+	_, err := w.Control(context.Background(), "flush", nil)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unexpected response to control command `flush`")
+	assert.Equal(t, StateErrored, w.State().Value())
+}
+
+func Test_Pipe_Control(t *testing.T) {
+	w, err := NewPipeFactory().SpawnWorker(exec.Command("php", "tests/client.php", "control", "pipes"))
+	assert.NoError(t, err)
+	defer w.Stop()
+
+	assert.Equal(t, "flush", w.Snapshot().Meta[CapabilityControls])
+
+	rsp, err := w.Control(context.Background(), "flush", []byte("cache"))
+	assert.NoError(t, err)
+	assert.Equal(t, "flushed cache", string(rsp))
+
+	_, err = w.Control(context.Background(), "profile", nil)
+	assert.Error(t, err)
+
+	res, err := w.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, "1", res.String())
+}
+
+func Test_Tcp_Control(t *testing.T) {
+	time.Sleep(time.Millisecond * 10) // to ensure free socket
+
+	ls, err := net.Listen("tcp", "localhost:9007")
+	if err != nil {
+		t.Skip("socket is busy")
+	}
+
+	f := NewSocketFactory(ls, time.Minute)
+	defer f.Close()
+
+	w, err := f.SpawnWorker(exec.Command("php", "tests/client.php", "control", "tcp"))
+	assert.NoError(t, err)
+	defer w.Stop()
+
+	rsp, err := w.Control(context.Background(), "flush", []byte("cache"))
+	assert.NoError(t, err)
+	assert.Equal(t, "flushed cache", string(rsp))
+
+	// worker without controls
+	echo, err := f.SpawnWorker(exec.Command("php", "tests/client.php", "echo", "tcp"))
+	assert.NoError(t, err)
+	defer echo.Stop()
+
+	_, err = echo.Control(context.Background(), "flush", nil)
+	assert.Error(t, err)
+}
+
+func Test_Worker_Control_Timeout(t *testing.T) {
+	w := relayWorker(t, func(rl goridge.Relay) {
+		time.Sleep(time.Second)
+	})
+	defer w.Kill()
+	w.meta = map[string]string{CapabilityControls: "flush"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+
+	_, err := w.Control(ctx, "flush", nil)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&w.timedOut))
+
+	// worker is killed
+	assert.Error(t, w.Wait())
+}
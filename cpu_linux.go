@@ -0,0 +1,42 @@
+// +build linux
+
+package roadrunner
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// clockTicks is the unit of the process times reported by the proc filesystem (USER_HZ).
+const clockTicks = 100
+
+// processCPUTime returns user and system CPU time consumed by the running process so far, utime
+// and stime of /proc/<pid>/stat.
+func processCPUTime(pid int) (time.Duration, error) {
+	data, err := ioutil.ReadFile(filepath.Join(procRoot, strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return 0, err
+	}
+
+	// pid (comm) state ppid ..., comm might contain spaces and parentheses
+	fields := bytes.Fields(data[bytes.LastIndexByte(data, ')')+1:])
+	if len(fields) < 13 {
+		return 0, fmt.Errorf("malformed process stat of %v", pid)
+	}
+
+	var ticks uint64
+	for _, field := range fields[11:13] {
+		n, err := strconv.ParseUint(string(field), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("malformed process stat of %v: %s", pid, err)
+		}
+
+		ticks += n
+	}
+
+	return time.Duration(ticks) * time.Second / clockTicks, nil
+}
@@ -0,0 +1,51 @@
+// +build linux
+
+package roadrunner
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_ProcessCPUTime(t *testing.T) {
+	restore := fakeCgroups(t, "0::/\n")
+	defer restore()
+
+	// comm with spaces and parentheses, utime 150 and stime 50 ticks
+	writeFile(t, filepath.Join(procRoot, "1001", "stat"), "1001 (php (worker) 1) R 1 1001 1001 0 -1 4194560 1 0 0 0 150 50 0 0 20 0 1 0 1 1 1\n")
+
+	d, err := processCPUTime(1001)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Second*2, d)
+
+	writeFile(t, filepath.Join(procRoot, "1002", "stat"), "1002 (php) R 1\n")
+	_, err = processCPUTime(1002)
+	assert.Error(t, err)
+
+	_, err = processCPUTime(1003)
+	assert.Error(t, err)
+}
+
+func Test_Worker_CPUTime(t *testing.T) {
+	w, err := newWorker(exec.Command("sh", "-c", "while :; do :; done"))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.NoError(t, w.start())
+
+	// spinning process
+	time.Sleep(time.Millisecond * 300)
+	running := w.CPUTime()
+	assert.True(t, running >= time.Millisecond*100, "cpu time of the spinning process: %s", running)
+
+	assert.NoError(t, w.Kill())
+	assert.Error(t, w.Wait())
+
+	// exact time of the exited process
+	assert.True(t, w.CPUTime() >= running)
+	assert.True(t, w.Snapshot().CPUTime >= running)
+}
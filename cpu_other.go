@@ -0,0 +1,13 @@
+// +build !linux
+
+package roadrunner
+
+import (
+	"fmt"
+	"time"
+)
+
+// processCPUTime is not available, CPU time of the running process is sampled on Linux only.
+func processCPUTime(pid int) (time.Duration, error) {
+	return 0, fmt.Errorf("process cpu time is not supported on this platform")
+}
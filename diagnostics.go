@@ -0,0 +1,86 @@
+package roadrunner
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// DiagnosticsSize defines how many recent executions are kept in worker diagnostics.
+	DiagnosticsSize = 16
+)
+
+// Diagnostics contains recent worker activity, available after the worker death.
+type Diagnostics struct {
+	// Execs contains most recent executions, oldest first.
+	Execs []ExecRecord
+
+	// Stderr contains tail of worker stderr output (up to StderrTailSize bytes).
+	Stderr []byte
+}
+
+// ExecRecord describes single payload sent to the worker.
+type ExecRecord struct {
+	// Started is time of the execution start.
+	Started time.Time
+
+	// ContextSize contains size of the payload context in bytes.
+	ContextSize int
+
+	// BodySize contains size of the payload body in bytes.
+	BodySize int
+
+	// RequestID contains request ID of the payload, if any.
+	RequestID string
+}
+
+// execRing is fixed size thread safe ring of recent executions.
+type execRing struct {
+	mu    sync.Mutex
+	execs [DiagnosticsSize]ExecRecord
+	next  int
+	count int
+}
+
+// push records payload execution, oldest record is overwritten once ring is full.
+func (r *execRing) push(rqs *Payload) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.execs[r.next] = ExecRecord{
+		Started:     time.Now(),
+		ContextSize: len(rqs.Context),
+		BodySize:    len(rqs.Body),
+		RequestID:   rqs.RequestID,
+	}
+
+	r.next = (r.next + 1) % DiagnosticsSize
+	if r.count < DiagnosticsSize {
+		r.count++
+	}
+}
+
+// last returns the most recent execution, false if nothing recorded yet.
+func (r *execRing) last() (ExecRecord, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.count == 0 {
+		return ExecRecord{}, false
+	}
+
+	return r.execs[(r.next-1+DiagnosticsSize)%DiagnosticsSize], true
+}
+
+// records returns copy of recorded executions, oldest first.
+func (r *execRing) records() []ExecRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	records := make([]ExecRecord, 0, r.count)
+	for i := r.count; i > 0; i-- {
+		records = append(records, r.execs[(r.next-i+DiagnosticsSize)%DiagnosticsSize])
+	}
+
+	return records
+}
@@ -0,0 +1,51 @@
+package roadrunner
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_ExecRing_Empty(t *testing.T) {
+	r := &execRing{}
+	assert.Empty(t, r.records())
+}
+
+func Test_ExecRing_Push(t *testing.T) {
+	r := &execRing{}
+	r.push(&Payload{Context: []byte("ctx"), Body: []byte("hello"), RequestID: "abc"})
+
+	records := r.records()
+	assert.Len(t, records, 1)
+	assert.Equal(t, 3, records[0].ContextSize)
+	assert.Equal(t, 5, records[0].BodySize)
+	assert.Equal(t, "abc", records[0].RequestID)
+	assert.False(t, records[0].Started.IsZero())
+}
+
+func Test_ExecRing_Overwrite(t *testing.T) {
+	r := &execRing{}
+	for i := 0; i < DiagnosticsSize+3; i++ {
+		r.push(&Payload{Body: make([]byte, i)})
+	}
+
+	records := r.records()
+	assert.Len(t, records, DiagnosticsSize)
+	for i, record := range records {
+		assert.Equal(t, i+3, record.BodySize)
+	}
+}
+
+func Test_ExecRing_Last(t *testing.T) {
+	r := &execRing{}
+
+	_, ok := r.last()
+	assert.False(t, ok)
+
+	for i := 0; i < DiagnosticsSize+3; i++ {
+		r.push(&Payload{Body: make([]byte, i)})
+	}
+
+	last, ok := r.last()
+	assert.True(t, ok)
+	assert.Equal(t, DiagnosticsSize+2, last.BodySize)
+}
@@ -0,0 +1,138 @@
+package roadrunner
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DrainReport describes the pool drain, see ManagedPool.Drain.
+type DrainReport struct {
+	// CompletedDuringDrain contains number of tasks completed since drain started, failed tasks
+	// included. Tasks of the killed workers are not counted.
+	CompletedDuringDrain int64
+
+	// ForceKilled contains number of busy workers killed once drain context was done, workers
+	// sacrificed by DrainOldestFirst included.
+	ForceKilled int
+
+	// Sacrificed contains number of busy workers killed ahead of the deadline by DrainOldestFirst.
+	Sacrificed int
+
+	// TotalDrainTime contains duration of the drain including the kill of the busy workers.
+	TotalDrainTime time.Duration
+}
+
+// DrainPolicy defines the order busy workers are killed in once drain runs out of time, see
+// DrainOptions.
+type DrainPolicy int
+
+const (
+	// DrainKillAll waits for all tasks until the context is done and kills all busy workers at
+	// once.
+	DrainKillAll DrainPolicy = iota
+
+	// DrainOldestFirst gives the best chance to finish to the longest running tasks: once the
+	// deadline is close busy workers are killed from the most recently started task, only the
+	// workers running the oldest tasks are left to complete until the deadline. Killing the long
+	// task wastes the most work while short tasks are cheap to retry. Requires context with the
+	// deadline, behaves like DrainKillAll otherwise.
+	DrainOldestFirst
+)
+
+// DrainOptions tune the pool drain, see StaticPool.DrainWithOptions.
+type DrainOptions struct {
+	// Policy of the busy workers kill, DrainKillAll by default.
+	Policy DrainPolicy
+
+	// Window before the context deadline DrainOldestFirst starts to sacrifice the most recent
+	// tasks in, 0 for a quarter of the time left at the drain start.
+	Window time.Duration
+
+	// Keep defines number of the oldest tasks left running through the window, 0 for one.
+	Keep int
+}
+
+// sacrifice returns duration until DrainOldestFirst sacrifices the recent tasks, false when
+// tasks are never sacrificed ahead of the deadline.
+func (o DrainOptions) sacrifice(ctx context.Context) (time.Duration, bool) {
+	if o.Policy != DrainOldestFirst {
+		return 0, false
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return 0, false
+	}
+
+	left := time.Until(deadline)
+	window := o.Window
+	if window == 0 {
+		window = left / 4
+	}
+
+	if left-window < 0 {
+		return 0, true
+	}
+
+	return left - window, true
+}
+
+// keep returns number of the oldest tasks left running through the window.
+func (o DrainOptions) keep() int {
+	if o.Keep < 1 {
+		return 1
+	}
+
+	return o.Keep
+}
+
+// drainTasks waits for the active tasks until context is done, kill stops the workers of the
+// remaining tasks except keep ones running the oldest tasks and returns number of killed workers.
+// Execs is the counter of the executed tasks. Context error is returned when workers had to be
+// killed. Must be called under the task lock.
+func drainTasks(
+	ctx context.Context,
+	tasks *sync.WaitGroup,
+	execs *int64,
+	opts DrainOptions,
+	kill func(keep int) int,
+) (report DrainReport, err error) {
+	start := time.Now()
+	initial := atomic.LoadInt64(execs)
+
+	done := make(chan interface{})
+	go func() {
+		tasks.Wait()
+		close(done)
+	}()
+
+	var window <-chan time.Time
+	if d, ok := opts.sacrifice(ctx); ok {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		window = timer.C
+	}
+
+	for {
+		select {
+		case <-done:
+			// failed tasks of the sacrificed workers are not counted
+			report.CompletedDuringDrain = atomic.LoadInt64(execs) - initial - int64(report.Sacrificed)
+		case <-window:
+			window = nil
+			report.Sacrificed = kill(opts.keep())
+			report.ForceKilled = report.Sacrificed
+			continue
+		case <-ctx.Done():
+			err = ctx.Err()
+			report.CompletedDuringDrain = atomic.LoadInt64(execs) - initial - int64(report.Sacrificed)
+			report.ForceKilled += kill(0)
+			<-done
+		}
+
+		report.TotalDrainTime = time.Since(start)
+		return report, err
+	}
+}
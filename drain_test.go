@@ -0,0 +1,276 @@
+package roadrunner
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_DrainTasks(t *testing.T) {
+	var (
+		tasks sync.WaitGroup
+		execs int64 = 10
+	)
+
+	for _, d := range []time.Duration{10, 30, 50} {
+		tasks.Add(1)
+		go func(d time.Duration) {
+			defer tasks.Done()
+			time.Sleep(d * time.Millisecond)
+			atomic.AddInt64(&execs, 1)
+		}(d)
+	}
+
+	report, err := drainTasks(context.Background(), &tasks, &execs, DrainOptions{}, func(int) int {
+		t.Fatal("tasks killed before context is done")
+		return 0
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), report.CompletedDuringDrain)
+	assert.Equal(t, 0, report.ForceKilled)
+	assert.True(t, report.TotalDrainTime >= time.Millisecond*50)
+}
+
+func Test_DrainTasks_Kill(t *testing.T) {
+	var (
+		tasks sync.WaitGroup
+		execs int64
+	)
+
+	kill := make(chan interface{})
+	for _, d := range []time.Duration{10, 1000} {
+		tasks.Add(1)
+		go func(d time.Duration) {
+			defer tasks.Done()
+			select {
+			case <-time.After(d * time.Millisecond):
+				atomic.AddInt64(&execs, 1)
+			case <-kill:
+			}
+		}(d)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+
+	report, err := drainTasks(ctx, &tasks, &execs, DrainOptions{}, func(int) int {
+		close(kill)
+		return 1
+	})
+
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, int64(1), report.CompletedDuringDrain)
+	assert.Equal(t, 1, report.ForceKilled)
+	assert.True(t, report.TotalDrainTime >= time.Millisecond*100)
+	assert.True(t, report.TotalDrainTime < time.Millisecond*500)
+}
+
+func Test_DrainTasks_OldestFirst(t *testing.T) {
+	var (
+		tasks sync.WaitGroup
+		execs int64
+	)
+
+	// oldest task completes in time, recent one is sacrificed
+	kill := make(chan interface{})
+	tasks.Add(2)
+	go func() {
+		defer tasks.Done()
+		time.Sleep(time.Millisecond * 150)
+		atomic.AddInt64(&execs, 1)
+	}()
+	go func() {
+		defer tasks.Done()
+		select {
+		case <-time.After(time.Second):
+		case <-kill:
+		}
+		atomic.AddInt64(&execs, 1)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+	defer cancel()
+
+	var keep []int
+	start := time.Now()
+	report, err := drainTasks(ctx, &tasks, &execs, DrainOptions{Policy: DrainOldestFirst, Window: time.Millisecond * 150}, func(k int) int {
+		keep = append(keep, k)
+		if k == 0 {
+			t.Fatal("oldest task killed at the deadline")
+		}
+
+		// recent task is sacrificed once the window starts
+		assert.True(t, time.Since(start) >= time.Millisecond*50)
+		close(kill)
+		return 1
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1}, keep)
+	assert.Equal(t, int64(1), report.CompletedDuringDrain)
+	assert.Equal(t, 1, report.ForceKilled)
+	assert.Equal(t, 1, report.Sacrificed)
+	assert.True(t, report.TotalDrainTime < time.Millisecond*200)
+
+	// no deadline, tasks are killed at once
+	ctx, cancel = context.WithCancel(context.Background())
+	tasks.Add(1)
+	go func() {
+		defer tasks.Done()
+		<-ctx.Done()
+	}()
+
+	time.AfterFunc(time.Millisecond*20, cancel)
+	report, err = drainTasks(ctx, &tasks, &execs, DrainOptions{Policy: DrainOldestFirst}, func(k int) int {
+		assert.Equal(t, 0, k)
+		return 1
+	})
+
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 1, report.ForceKilled)
+	assert.Equal(t, 0, report.Sacrificed)
+}
+
+func Test_StaticPool_Drain(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "delay", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      3,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	var wg sync.WaitGroup
+	for _, delay := range []string{"50", "100", "2000"} {
+		wg.Add(1)
+		go func(delay string) {
+			defer wg.Done()
+
+			_, err := p.Exec(&Payload{Body: []byte(delay)})
+			if delay == "2000" {
+				assert.Error(t, err, "task must be killed by drain")
+			} else {
+				assert.NoError(t, err)
+			}
+		}(delay)
+	}
+
+	// to ensure that tasks are running
+	time.Sleep(time.Millisecond * 20)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*500)
+	defer cancel()
+
+	report, err := p.Drain(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, int64(2), report.CompletedDuringDrain)
+	assert.Equal(t, 1, report.ForceKilled)
+	assert.True(t, report.TotalDrainTime >= time.Millisecond*450)
+	assert.True(t, p.Stats().Paused)
+
+	wg.Wait()
+
+	// drained pool is reused
+	p.Resume()
+	_, err = p.Exec(&Payload{Body: []byte("10")})
+	assert.NoError(t, err)
+}
+
+func Test_StaticPool_Drain_OldestFirst(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "delay", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      2,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	var wg sync.WaitGroup
+	run := func(delay string) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			_, err := p.Exec(&Payload{Body: []byte(delay)})
+			if delay == "200" {
+				assert.Error(t, err, "recent task must be sacrificed")
+			} else {
+				assert.NoError(t, err, "oldest task must complete")
+			}
+		}()
+	}
+
+	// long task is followed by the short one which would also complete within the deadline
+	run("500")
+	time.Sleep(time.Millisecond * 100)
+	run("200")
+	time.Sleep(time.Millisecond * 20)
+
+	busy := 0
+	for _, w := range p.Workers() {
+		if !w.TaskStarted().IsZero() {
+			busy++
+		}
+	}
+	assert.Equal(t, 2, busy)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*600)
+	defer cancel()
+
+	report, err := p.DrainWithOptions(ctx, DrainOptions{Policy: DrainOldestFirst, Window: time.Millisecond * 550})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), report.CompletedDuringDrain)
+	assert.Equal(t, 1, report.ForceKilled)
+	assert.Equal(t, 1, report.Sacrificed)
+	assert.True(t, report.TotalDrainTime < time.Millisecond*600)
+
+	wg.Wait()
+}
+
+func Test_StaticPool_Drain_Completed(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "delay", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      2,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	assert.NoError(t, err)
+
+	// one task waits for the worker
+	for _, delay := range []string{"50", "100", "20"} {
+		go func(delay string) {
+			_, err := p.Exec(&Payload{Body: []byte(delay)})
+			assert.NoError(t, err)
+		}(delay)
+
+		// to ensure that tasks are running or queued in order
+		time.Sleep(time.Millisecond * 5)
+	}
+
+	report, err := p.Drain(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), report.CompletedDuringDrain)
+	assert.Equal(t, 0, report.ForceKilled)
+
+	p.Destroy()
+
+	_, err = p.Drain(context.Background())
+	assert.Equal(t, ErrPoolDestroyed, errors.Cause(err))
+}
@@ -0,0 +1,195 @@
+package roadrunner
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"time"
+)
+
+// DynamicConfig defines behaviour of the pool which scales number of workers based on load.
+type DynamicConfig struct {
+	// MinWorkers defines how many workers must be kept alive at any moment.
+	MinWorkers int64
+
+	// MaxWorkers defines maximum number of workers pool can scale to.
+	MaxWorkers int64
+
+	// Lazy starts the pool without workers and spawns the worker for every task which finds no
+	// idle worker (regardless of ScaleUpThreshold) up to MaxWorkers, tasks waiting for the worker
+	// being spawned do not spawn another one. First task pays the spawn cost, combined with
+	// IdleTimeout pool scales down to zero workers. Requires MinWorkers to be 0.
+	Lazy bool
+
+	// IdleTimeout defines for how long worker can stay idle before being destroyed, pool never
+	// scales below MinWorkers. Set 0 to disable scale down.
+	IdleTimeout time.Duration
+
+	// Clock drives idle worker reaping and priority aging, nil for the system clock.
+	Clock Clock
+
+	// Tracer receives spans of the task execution and worker start, see Config.Tracer.
+	Tracer Tracer
+
+	// MaxIdle limits how many workers can stay idle, worker returned to the pool is destroyed
+	// right away once MaxIdle workers are idle already (regardless of IdleTimeout). Pool never
+	// scales below MinWorkers. Set 0 for unlimited.
+	MaxIdle int64
+
+	// ScaleUpThreshold defines how many tasks must be waiting for a worker before pool spawns
+	// an additional worker.
+	ScaleUpThreshold int64
+
+	// MaxJobs limits number of executions of the worker, see Config.MaxJobs.
+	MaxJobs int64
+
+	// MaxQueueSize limits how many tasks can wait for a free worker, see Config.MaxQueueSize.
+	MaxQueueSize int64
+
+	// PriorityAging promotes waiting task one priority band up every given interval, see
+	// Config.PriorityAging.
+	PriorityAging time.Duration
+
+	// IdleReadTimeout replaces the worker which socket relay transfers no data, see
+	// Config.IdleReadTimeout.
+	IdleReadTimeout time.Duration
+
+	// ResyncTimeout enables resync of the worker which relay stream might be out of sync, see
+	// Config.ResyncTimeout.
+	ResyncTimeout time.Duration
+
+	// MaxConsecutiveMalformed retires the worker sending malformed responses in a row, see
+	// Config.MaxConsecutiveMalformed.
+	MaxConsecutiveMalformed int64
+
+	// IdleCheckInterval defines how often idle workers are checked for the unhealthy command,
+	// see Config.IdleCheckInterval.
+	IdleCheckInterval time.Duration
+
+	// MaxExecRetries defines how many times idempotent task is replayed on another worker, see
+	// Config.MaxExecRetries.
+	MaxExecRetries int64
+
+	// SendRequestID passes request ID of every task to the worker, see Config.SendRequestID.
+	SendRequestID bool
+
+	// BreakerThreshold defines how many consecutive worker spawn failures open the circuit
+	// breaker, see Config.BreakerThreshold.
+	BreakerThreshold int64
+
+	// BreakerWindow defines time window spawn failures must fit in, see Config.BreakerWindow.
+	BreakerWindow time.Duration
+
+	// BreakerCooldown defines for how long worker spawning is paused once breaker is open.
+	BreakerCooldown time.Duration
+
+	// CommandCheckArgs defines arguments of the worker executable check, see
+	// Config.CommandCheckArgs.
+	CommandCheckArgs []string
+
+	// SkipCommandCheck disables verification of the worker executable on pool creation.
+	SkipCommandCheck bool
+
+	// WorkerIDPrefix prefixes slot index in the logical worker IDs, see Config.WorkerIDPrefix.
+	WorkerIDPrefix string
+
+	// CPUAffinity pins workers to the CPU cores, see Config.CPUAffinity.
+	CPUAffinity CPUAffinity
+
+	// StdoutMode defines how stdout of the workers is handled, see Config.StdoutMode.
+	StdoutMode StdoutMode
+
+	// StdoutWriter returns writer receiving stdout of the given worker, see Config.StdoutWriter.
+	StdoutWriter func(wc WorkerConfig) io.Writer
+
+	// RejectWhenPaused makes tasks fail with ErrPoolPaused while pool is paused, see
+	// Config.RejectWhenPaused.
+	RejectWhenPaused bool
+
+	// MaxPayloadSize limits size of task context and body in bytes, see Config.MaxPayloadSize.
+	MaxPayloadSize int64
+
+	// MaxResponseFrames limits number of frames worker may send in response to a single task,
+	// see Config.MaxResponseFrames.
+	MaxResponseFrames int64
+
+	// AllocateTimeout defines for how long task waits for the free worker, see
+	// Config.AllocateTimeout.
+	AllocateTimeout time.Duration
+
+	// MaxWait limits for how long task can wait in the queue, see Config.MaxWait.
+	MaxWait time.Duration
+
+	// DestroyTimeout defines for how long worker is given to stop, see Config.DestroyTimeout.
+	DestroyTimeout time.Duration
+
+	// KillGracePeriod defines for how long recycled worker is given to exit after SIGTERM, see
+	// Config.KillGracePeriod.
+	KillGracePeriod time.Duration
+}
+
+// InitDefaults allows to init blank config with pre-defined set of default values.
+func (cfg *DynamicConfig) InitDefaults() error {
+	cfg.MinWorkers = 1
+	cfg.MaxWorkers = int64(runtime.NumCPU())
+	cfg.IdleTimeout = time.Minute
+	cfg.ScaleUpThreshold = 1
+	cfg.AllocateTimeout = time.Minute
+	cfg.DestroyTimeout = time.Minute
+
+	return nil
+}
+
+// Valid returns error if config not valid.
+func (cfg *DynamicConfig) Valid() error {
+	if cfg.MaxWorkers == 0 {
+		return fmt.Errorf("pool.MaxWorkers must be set")
+	}
+
+	if cfg.MinWorkers < 0 || cfg.MinWorkers > cfg.MaxWorkers {
+		return fmt.Errorf("pool.MinWorkers must be within [0, MaxWorkers]")
+	}
+
+	if cfg.Lazy && cfg.MinWorkers != 0 {
+		return fmt.Errorf("pool.Lazy can not be combined with pool.MinWorkers")
+	}
+
+	if cfg.MaxIdle < 0 {
+		return fmt.Errorf("pool.MaxIdle must be positive (0 for unlimited)")
+	}
+
+	if cfg.ScaleUpThreshold < 1 {
+		return fmt.Errorf("pool.ScaleUpThreshold must be set")
+	}
+
+	return cfg.options().valid()
+}
+
+// options returns options shared with Config.
+func (cfg *DynamicConfig) options() poolOptions {
+	return poolOptions{
+		MaxJobs:                 cfg.MaxJobs,
+		AllocateTimeout:         cfg.AllocateTimeout,
+		MaxWait:                 cfg.MaxWait,
+		DestroyTimeout:          cfg.DestroyTimeout,
+		KillGracePeriod:         cfg.KillGracePeriod,
+		MaxExecRetries:          cfg.MaxExecRetries,
+		SendRequestID:           cfg.SendRequestID,
+		IdleReadTimeout:         cfg.IdleReadTimeout,
+		ResyncTimeout:           cfg.ResyncTimeout,
+		Tracer:                  cfg.Tracer,
+		IdleCheckInterval:       cfg.IdleCheckInterval,
+		MaxQueueSize:            cfg.MaxQueueSize,
+		PriorityAging:           cfg.PriorityAging,
+		MaxPayloadSize:          cfg.MaxPayloadSize,
+		MaxResponseFrames:       cfg.MaxResponseFrames,
+		MaxConsecutiveMalformed: cfg.MaxConsecutiveMalformed,
+		BreakerThreshold:        cfg.BreakerThreshold,
+		BreakerWindow:           cfg.BreakerWindow,
+		BreakerCooldown:         cfg.BreakerCooldown,
+		WorkerIDPrefix:          cfg.WorkerIDPrefix,
+		CPUAffinity:             cfg.CPUAffinity,
+		StdoutMode:              cfg.StdoutMode,
+		StdoutWriter:            cfg.StdoutWriter,
+	}
+}
@@ -0,0 +1,112 @@
+package roadrunner
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func Test_DynamicConfig_Default(t *testing.T) {
+	cfg := DynamicConfig{}
+
+	assert.NoError(t, cfg.InitDefaults())
+	assert.NoError(t, cfg.Valid())
+}
+
+func Test_DynamicConfig_MaxWorkers(t *testing.T) {
+	cfg := DynamicConfig{
+		ScaleUpThreshold: 1,
+		AllocateTimeout:  time.Second,
+		DestroyTimeout:   time.Second,
+	}
+	err := cfg.Valid()
+
+	assert.NotNil(t, err)
+	assert.Equal(t, "pool.MaxWorkers must be set", err.Error())
+}
+
+func Test_DynamicConfig_MinWorkers(t *testing.T) {
+	cfg := DynamicConfig{
+		MinWorkers:       4,
+		MaxWorkers:       2,
+		ScaleUpThreshold: 1,
+		AllocateTimeout:  time.Second,
+		DestroyTimeout:   time.Second,
+	}
+	err := cfg.Valid()
+
+	assert.NotNil(t, err)
+	assert.Equal(t, "pool.MinWorkers must be within [0, MaxWorkers]", err.Error())
+}
+
+func Test_DynamicConfig_Lazy(t *testing.T) {
+	cfg := DynamicConfig{
+		MinWorkers:       1,
+		MaxWorkers:       2,
+		Lazy:             true,
+		ScaleUpThreshold: 1,
+		AllocateTimeout:  time.Second,
+		DestroyTimeout:   time.Second,
+	}
+	err := cfg.Valid()
+
+	assert.NotNil(t, err)
+	assert.Equal(t, "pool.Lazy can not be combined with pool.MinWorkers", err.Error())
+
+	cfg.MinWorkers = 0
+	assert.NoError(t, cfg.Valid())
+}
+
+func Test_DynamicConfig_ScaleUpThreshold(t *testing.T) {
+	cfg := DynamicConfig{
+		MaxWorkers:      2,
+		AllocateTimeout: time.Second,
+		DestroyTimeout:  time.Second,
+	}
+	err := cfg.Valid()
+
+	assert.NotNil(t, err)
+	assert.Equal(t, "pool.ScaleUpThreshold must be set", err.Error())
+}
+
+func Test_DynamicConfig_MaxIdle(t *testing.T) {
+	cfg := DynamicConfig{
+		MaxWorkers:       2,
+		MaxIdle:          -1,
+		ScaleUpThreshold: 1,
+		AllocateTimeout:  time.Second,
+		DestroyTimeout:   time.Second,
+	}
+	err := cfg.Valid()
+
+	assert.NotNil(t, err)
+	assert.Equal(t, "pool.MaxIdle must be positive (0 for unlimited)", err.Error())
+}
+
+func Test_DynamicConfig_MaxConsecutiveMalformed(t *testing.T) {
+	cfg := DynamicConfig{
+		MaxWorkers:              2,
+		ScaleUpThreshold:        1,
+		MaxConsecutiveMalformed: -1,
+		AllocateTimeout:         time.Second,
+		DestroyTimeout:          time.Second,
+	}
+	err := cfg.Valid()
+
+	assert.NotNil(t, err)
+	assert.Equal(t, "pool.MaxConsecutiveMalformed must be positive (0 to disable)", err.Error())
+}
+
+func Test_DynamicConfig_IdleCheckInterval(t *testing.T) {
+	cfg := DynamicConfig{
+		MaxWorkers:        2,
+		ScaleUpThreshold:  1,
+		IdleCheckInterval: -1,
+		AllocateTimeout:   time.Second,
+		DestroyTimeout:    time.Second,
+	}
+	err := cfg.Valid()
+
+	assert.NotNil(t, err)
+	assert.Equal(t, "pool.IdleCheckInterval must be positive (0 to disable)", err.Error())
+}
@@ -0,0 +1,1021 @@
+package roadrunner
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var _ ManagedPool = (*DynamicPool)(nil)
+
+// DynamicPool controls worker creation, destruction and task routing. Pool spawns additional workers
+// when tasks are waiting for a worker and destroys idle workers down to the configured minimum.
+type DynamicPool struct {
+	// worker lifecycle shared with StaticPool
+	poolCore
+
+	// pool behaviour
+	cfg DynamicConfig
+
+	// worker command creator, protected by mcmd
+	cmd  func(cfg WorkerConfig) *exec.Cmd
+	mcmd sync.RWMutex
+
+	// idle workers, might contain dead workers, one extra slot is reserved for the worker reload
+	free chan *Worker
+
+	// tasks waiting for the free worker
+	queue waitQueue
+
+	// protects worker list and scaling
+	muw sync.Mutex
+
+	// all registered workers
+	workers []*Worker
+
+	// pool slot index of each registered worker
+	index map[*Worker]int
+
+	// preferred worker slots of the sticky tasks
+	sticky stickyTable
+
+	// number of workers being spawned
+	spawning int64
+
+	// slot indexes reserved by the workers being spawned, protected by muw
+	reserved map[int]bool
+}
+
+// NewDynamicPool creates new worker pool which scales between cfg.MinWorkers and cfg.MaxWorkers.
+func NewDynamicPool(cmd func(cfg WorkerConfig) *exec.Cmd, factory Factory, cfg DynamicConfig) (*DynamicPool, error) {
+	if err := cfg.Valid(); err != nil {
+		return nil, errors.Wrap(err, "config")
+	}
+
+	if !cfg.SkipCommandCheck {
+		if err := checkCommand(cmd(newWorkerConfig(0, cfg.WorkerIDPrefix)), cfg.CommandCheckArgs); err != nil {
+			return nil, errors.Wrap(err, "command check")
+		}
+	}
+
+	p := &DynamicPool{
+		cfg:      cfg,
+		cmd:      cmd,
+		workers:  make([]*Worker, 0, cfg.MaxWorkers),
+		index:    make(map[*Worker]int),
+		reserved: make(map[int]bool),
+		free:     make(chan *Worker, cfg.MaxWorkers+1),
+		queue:    waitQueue{aging: cfg.PriorityAging, clock: cfg.Clock},
+	}
+	p.setup(p, factory, cfg.options())
+
+	for i := int64(0); i < p.cfg.MinWorkers; i++ {
+		p.muw.Lock()
+		index := p.nextIndex()
+		p.spawning++
+		p.muw.Unlock()
+
+		w, err := p.createWorker(index)
+		if err != nil {
+			p.Destroy()
+			return nil, err
+		}
+
+		p.push(w)
+	}
+
+	if p.cfg.IdleTimeout != 0 {
+		go p.reap()
+	}
+
+	if p.cfg.IdleCheckInterval != 0 {
+		go p.inspect()
+	}
+
+	return p, nil
+}
+
+// Config returns associated pool configuration. Immutable.
+func (p *DynamicPool) Config() DynamicConfig {
+	return p.cfg
+}
+
+// Workers returns copy of the worker list associated with the pool. Returned workers are
+// managed by the pool, use them for inspection only (PID, State, Snapshot) and never Exec
+// directly, use Allocate to check out the worker for the exclusive use.
+func (p *DynamicPool) Workers() (workers []*Worker) {
+	p.muw.Lock()
+	defer p.muw.Unlock()
+
+	workers = append(workers, p.workers...)
+
+	return workers
+}
+
+// Stats returns point in time pool statistics. Worker counts are taken under the same lock
+// as worker list, cheap enough to be called on every metrics scrape.
+func (p *DynamicPool) Stats() PoolStats {
+	p.muw.Lock()
+	defer p.muw.Unlock()
+
+	stats := PoolStats{
+		NumWorkers:  len(p.workers),
+		TotalExecs:  atomic.LoadInt64(&p.numExecs),
+		TotalErrors: atomic.LoadInt64(&p.numErrors),
+		PeakMemory:  atomic.LoadUint64(&p.peakMemory),
+		Queued:      int(atomic.LoadInt64(&p.waiting)),
+		Breaker:     p.breaker.State(),
+		Paused:      p.pause.paused(),
+
+		QueuedByPriority: p.queue.depths(),
+
+		RecycleReasons: p.recycles.snapshot(),
+	}
+
+	for _, w := range p.workers {
+		if w.State().Value() == StateReady {
+			stats.NumIdle++
+		}
+	}
+	stats.NumBusy = stats.NumWorkers - stats.NumIdle
+
+	return stats
+}
+
+// Healthy verifies that at least MinWorkers workers are ready or busy and pings one idle worker
+// to confirm the worker side responds. Busy workers are never pinged, ping is skipped when
+// all workers are busy. Worker failed to respond is replaced.
+func (p *DynamicPool) Healthy() (bool, error) {
+	if p.destroyed() {
+		return false, ErrPoolDestroyed
+	}
+
+	p.tmu.Lock()
+	if p.destroyed() {
+		p.tmu.Unlock()
+		return false, ErrPoolDestroyed
+	}
+	p.tasks.Add(1)
+	p.tmu.Unlock()
+
+	defer p.tasks.Done()
+
+	ready, expected := 0, int(p.cfg.MinWorkers)
+	for _, w := range p.Workers() {
+		if s := w.State().Value(); s == StateReady || s == StateWorking {
+			ready++
+		}
+	}
+
+	if ready < expected {
+		return false, fmt.Errorf("only %v/%v workers ready", ready, expected)
+	}
+
+	w, ok := p.tryAllocate()
+	if !ok {
+		// all workers are busy
+		return true, nil
+	}
+
+	if err := w.Ping(); err != nil {
+		p.throw(EventWorkerError, WorkerError{Worker: w, Caused: err})
+		p.discardWorker(w, RecycleError, err)
+		return false, errors.Wrapf(err, "worker %v", *w.Pid)
+	}
+
+	p.release(w)
+	return true, nil
+}
+
+// execTask executes the task using workers provided by the given allocation function, task is
+// replayed on another worker when worker requests termination or retry is allowed.
+func (p *DynamicPool) execTask(ctx context.Context, rqs *Payload, meta *ExecMeta, allocate func(ctx context.Context) (*Worker, error)) (rsp *Payload, err error) {
+	if p.destroyed() {
+		return nil, ErrPoolDestroyed
+	}
+
+	if p.breaker.unavailable() {
+		return p.fallback.serve(rqs, meta, ErrPoolUnavailable)
+	}
+
+	if err := p.pause.wait(ctx, p.cfg.RejectWhenPaused, p.destroy); err != nil {
+		return nil, err
+	}
+
+	p.tmu.Lock()
+	if p.destroyed() {
+		p.tmu.Unlock()
+		return nil, ErrPoolDestroyed
+	}
+	p.tasks.Add(1)
+	p.tmu.Unlock()
+
+	defer p.tasks.Done()
+
+	if err := checkPayload(rqs, p.cfg.MaxPayloadSize); err != nil {
+		return nil, err
+	}
+
+	// retries keep the ID
+	rqs = withRequestID(rqs, p.cfg.SendRequestID)
+
+	tracer := tracerOrNop(p.cfg.Tracer)
+	for attempt := int64(0); ; attempt++ {
+		_, span := tracer.StartSpan(ctx, SpanQueueWait)
+		w, err := allocate(ctx)
+		endSpan(span, err)
+		if err != nil {
+			return p.fallback.serve(rqs, meta, allocateError(ctx, err))
+		}
+
+		ectx, span := tracer.StartSpan(ctx, SpanWorkerExec)
+		rsp, stop, err := p.execWorker(ctx, w, injectTrace(tracer, ectx, rqs), meta)
+		endSpan(span, err)
+		if stop {
+			return p.execTask(ctx, rqs, meta, allocate)
+		}
+
+		if !retryable(rqs, err, attempt, p.cfg.MaxExecRetries) {
+			return rsp, err
+		}
+
+		p.logger().Warn("retrying task", "attempt", attempt+1, "request", rqs.RequestID, "error", err)
+		time.Sleep(retryDelay(attempt))
+		if p.destroyed() {
+			return nil, err
+		}
+	}
+}
+
+// TryExec executes the task only if free worker is immediately available, acquired is false
+// when all workers are busy and task has not been executed. Pool is not scaled up.
+func (p *DynamicPool) TryExec(rqs *Payload) (rsp *Payload, acquired bool, err error) {
+	if p.destroyed() {
+		return nil, false, ErrPoolDestroyed
+	}
+
+	if p.breaker.unavailable() {
+		return nil, false, ErrPoolUnavailable
+	}
+
+	if p.pause.paused() {
+		if p.cfg.RejectWhenPaused {
+			return nil, false, ErrPoolPaused
+		}
+
+		return nil, false, nil
+	}
+
+	p.tmu.Lock()
+	if p.destroyed() {
+		p.tmu.Unlock()
+		return nil, false, ErrPoolDestroyed
+	}
+	p.tasks.Add(1)
+	p.tmu.Unlock()
+
+	defer p.tasks.Done()
+
+	if err := checkPayload(rqs, p.cfg.MaxPayloadSize); err != nil {
+		return nil, false, err
+	}
+
+	w, ok := p.tryAllocate()
+	if !ok {
+		return nil, false, nil
+	}
+
+	rsp, stop, err := p.execWorker(context.Background(), w, rqs, nil)
+	if stop {
+		return p.TryExec(rqs)
+	}
+
+	return rsp, true, err
+}
+
+// Allocate checks out idle worker for the exclusive use, waits for the free worker until
+// context is done or allocate timeout is reached. Pool destruction waits for allocated workers
+// to be released. Caller is responsible for keeping the worker relay stream consistent between
+// the executions and must return the worker using Release.
+func (p *DynamicPool) Allocate(ctx context.Context) (*Worker, error) {
+	if p.destroyed() {
+		return nil, ErrPoolDestroyed
+	}
+
+	if p.breaker.unavailable() {
+		return nil, ErrPoolUnavailable
+	}
+
+	if err := p.pause.wait(ctx, p.cfg.RejectWhenPaused, p.destroy); err != nil {
+		return nil, err
+	}
+
+	p.tmu.Lock()
+	if p.destroyed() {
+		p.tmu.Unlock()
+		return nil, ErrPoolDestroyed
+	}
+	p.tasks.Add(1)
+	p.tmu.Unlock()
+
+	w, err := p.allocateWorker(ctx)
+	if err != nil {
+		p.tasks.Done()
+		return nil, allocateError(ctx, err)
+	}
+
+	p.allocated.add(w)
+	return w, nil
+}
+
+// Release returns allocated worker to the pool, broken worker is destroyed and replaced. Workers
+// which are not allocated or have been released already are ignored.
+func (p *DynamicPool) Release(w *Worker, broken bool) {
+	if !p.allocated.remove(w) {
+		p.logger().Warn("released worker is not allocated", "pid", *w.Pid)
+		return
+	}
+
+	defer p.tasks.Done()
+
+	if w.Hijacked() {
+		// leaves the pool once connection is closed, new workers are spawned on demand
+		p.recycled.Store(w, true)
+		return
+	}
+
+	if broken || w.State().Value() != StateReady {
+		p.recycleWorker(w, RecycleError, fmt.Errorf("worker released as broken"))
+		return
+	}
+
+	p.release(w)
+}
+
+// SetCommand replaces the command used to spawn new workers, including replacements of the
+// recycled, dead and reloaded workers. Running workers keep running the old command until they
+// are recycled, combine with ReloadAll to roll the new command out.
+func (p *DynamicPool) SetCommand(cmd func(cfg WorkerConfig) *exec.Cmd) {
+	p.mcmd.Lock()
+	defer p.mcmd.Unlock()
+
+	p.cmd = cmd
+}
+
+// command returns the command used to spawn new workers.
+func (p *DynamicPool) command() func(cfg WorkerConfig) *exec.Cmd {
+	p.mcmd.RLock()
+	defer p.mcmd.RUnlock()
+
+	return p.cmd
+}
+
+// spawnReplacement creates worker taking the slot of the given one.
+func (p *DynamicPool) spawnReplacement(w *Worker) (*Worker, error) {
+	p.muw.Lock()
+	index := p.index[w]
+	p.spawning++
+	p.muw.Unlock()
+
+	return p.createWorker(index)
+}
+
+// recycleIdle recycles the worker if it's waiting in the free list, returns false when worker
+// is busy or has left the pool.
+func (p *DynamicPool) recycleIdle(w *Worker, reason RecycleReason, err error) bool {
+	if !p.takeIdle(w) {
+		return false
+	}
+
+	p.recycleWorker(w, reason, err)
+	return true
+}
+
+// takeIdle takes the worker out of the free list, returns false when worker is busy or has left
+// the pool.
+func (p *DynamicPool) takeIdle(w *Worker) bool {
+	for i := len(p.free); i > 0; i-- {
+		var wc *Worker
+		select {
+		case wc = <-p.free:
+		default:
+			return false
+		}
+
+		if wc != w {
+			p.push(wc)
+			continue
+		}
+
+		// dead worker is already detached
+		if w.State().Value() != StateReady {
+			return false
+		}
+
+		if w.IsBusy() {
+			p.push(w)
+			return false
+		}
+
+		return true
+	}
+
+	return false
+}
+
+// Detach takes idle worker with the given PID out of the pool and passes it to the caller, for
+// example to debug the misbehaving worker using crafted payloads. Replacement is spawned to keep
+// the pool capacity. Detached worker never receives pool tasks and is not recycled, replaced,
+// reported or destroyed by the pool, caller owns the worker and must Stop or Kill it once done.
+// Busy worker can not be detached.
+func (p *DynamicPool) Detach(pid int) (*Worker, error) {
+	if p.destroyed() {
+		return nil, ErrPoolDestroyed
+	}
+
+	p.reload.Lock()
+	defer p.reload.Unlock()
+
+	var w *Worker
+	for _, wc := range p.Workers() {
+		if *wc.Pid == pid {
+			w = wc
+			break
+		}
+	}
+
+	if w == nil {
+		return nil, fmt.Errorf("no worker with pid %v", pid)
+	}
+
+	if !p.takeIdle(w) {
+		return nil, fmt.Errorf("worker is not idle (%s)", w.State().String())
+	}
+
+	nw, err := p.spawnReplacement(w)
+	if err != nil {
+		p.push(w)
+		return nil, err
+	}
+
+	p.detached.Store(w, true)
+
+	p.muw.Lock()
+	delete(p.index, w)
+	for i, wc := range p.workers {
+		if wc == w {
+			p.workers = append(p.workers[:i], p.workers[i+1:]...)
+			break
+		}
+	}
+	p.muw.Unlock()
+
+	p.events.detach(w)
+	w.state.observe(func(from, to int64) {})
+
+	p.push(nw)
+	return w, nil
+}
+
+// WaitReady returns immediately, pool constructor starts MinWorkers before returning and other
+// workers are started on demand. Returns ErrPoolDestroyed once pool is destroyed.
+func (p *DynamicPool) WaitReady(ctx context.Context) error {
+	if p.destroyed() {
+		return ErrPoolDestroyed
+	}
+
+	return nil
+}
+
+// Drain pauses the pool and waits for the active tasks until context is done, workers still busy
+// once context is done are killed and replaced. Returns context error along with the report when
+// workers had to be killed. Unlike Destroy pool keeps the workers and the factory, Resume restarts
+// dispatching of the tasks held since drain.
+func (p *DynamicPool) Drain(ctx context.Context) (DrainReport, error) {
+	if p.destroyed() {
+		return DrainReport{}, ErrPoolDestroyed
+	}
+
+	p.Pause()
+
+	p.tmu.Lock()
+	defer p.tmu.Unlock()
+
+	return drainTasks(ctx, &p.tasks, &p.numExecs, DrainOptions{}, func(int) int {
+		return p.killBusy()
+	})
+}
+
+// killBusy kills workers executing the task, returns number of killed workers.
+func (p *DynamicPool) killBusy() (killed int) {
+	for _, w := range p.Workers() {
+		if w.State().Value() != StateWorking {
+			continue
+		}
+
+		if err := w.Kill(); err != nil {
+			p.throw(EventWorkerError, WorkerError{Worker: w, Caused: err})
+		}
+
+		p.throw(EventWorkerKill, w)
+		killed++
+	}
+
+	return killed
+}
+
+// Destroy all underlying workers (but let them to complete the task).
+func (p *DynamicPool) Destroy() {
+	atomic.AddInt32(&p.inDestroy, 1)
+
+	p.tmu.Lock()
+	p.tasks.Wait()
+	close(p.destroy)
+	p.tmu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, w := range p.Workers() {
+		wg.Add(1)
+		w.markInvalid()
+		go func(w *Worker) {
+			defer wg.Done()
+			p.destroyWorker(w, nil)
+		}(w)
+	}
+
+	wg.Wait()
+	p.events.close(p.cfg.DestroyTimeout)
+
+	if p.ownsFactory {
+		_ = p.factory.Close()
+	}
+}
+
+// finds free worker in a given time interval for the task of normal priority.
+func (p *DynamicPool) allocateWorker(ctx context.Context) (w *Worker, err error) {
+	return p.allocatePriority(ctx, PriorityNormal)
+}
+
+// finds free worker in a given time interval, spawns new worker when too many tasks are waiting.
+// Waiting tasks receive released workers by priority, see waitQueue.
+func (p *DynamicPool) allocatePriority(ctx context.Context, priority int) (w *Worker, err error) {
+	var (
+		timeout *time.Timer
+		since   time.Time
+	)
+	defer func() {
+		if timeout != nil {
+			timeout.Stop()
+			atomic.AddInt64(&p.waiting, -1)
+		}
+	}()
+
+	for {
+		w, e := p.queue.take(p.free, priority, since)
+		if e != nil {
+			since = e.since
+			if timeout == nil {
+				timeout = time.NewTimer(p.waitTimeout())
+				queued := atomic.AddInt64(&p.waiting, 1)
+
+				p.scaleUp()
+
+				if p.cfg.MaxQueueSize != 0 && queued > p.cfg.MaxQueueSize {
+					p.leave(e)
+					return nil, ErrQueueFull
+				}
+			}
+
+			select {
+			case w = <-wait(e):
+			case <-timeout.C:
+				if w = p.queue.leave(e); w == nil {
+					return nil, ErrAllocTimeout
+				}
+			case <-p.destroy:
+				p.leave(e)
+				return nil, ErrPoolDestroyed
+			case <-ctx.Done():
+				p.leave(e)
+				return nil, ctx.Err()
+			}
+		}
+
+		if w, ok := p.accept(w); ok {
+			return w, nil
+		}
+
+		if timeout != nil {
+			p.scaleUp()
+		}
+	}
+}
+
+// freeChan returns free workers buf.
+func (p *DynamicPool) freeChan() chan *Worker {
+	return p.free
+}
+
+// push passes worker to the next waiting task or returns it to the free workers buf.
+func (p *DynamicPool) push(w *Worker) {
+	p.queue.put(p.free, w)
+}
+
+// leave removes waiter from the queue, worker already passed to the waiter is passed to the next one.
+func (p *DynamicPool) leave(e *waiter) {
+	if w := p.queue.leave(e); w != nil {
+		p.push(w)
+	}
+}
+
+// stickWorker returns free worker preferred by the key instead of the given one if available,
+// given worker is remembered as preferred otherwise. Must be called with accepted worker.
+func (p *DynamicPool) stickWorker(w *Worker, key string) *Worker {
+	p.muw.Lock()
+	preferred := p.sticky.lookup(key, int(p.cfg.MaxWorkers))
+	index := p.index[w]
+	p.muw.Unlock()
+
+	if index == preferred {
+		return w
+	}
+
+	selected := w
+
+	var candidates []*Worker
+	for i := len(p.free); i > 0 && selected == w; i-- {
+		select {
+		case wc := <-p.free:
+			candidates = append(candidates, wc)
+			if wc.State().Value() != StateReady {
+				continue
+			}
+
+			if _, remove := p.remove.Load(wc); remove {
+				continue
+			}
+
+			p.muw.Lock()
+			if p.index[wc] == preferred {
+				selected = wc
+			}
+			p.muw.Unlock()
+		default:
+			i = 0
+		}
+	}
+
+	if selected == w {
+		p.sticky.remember(key, index)
+		return w
+	}
+
+	p.push(w)
+	for _, wc := range candidates {
+		if wc != selected {
+			p.push(wc)
+		}
+	}
+
+	return selected
+}
+
+// tryAllocate returns free worker without waiting, ok is false when no workers are available.
+func (p *DynamicPool) tryAllocate() (w *Worker, ok bool) {
+	for {
+		select {
+		case w = <-p.free:
+			if w, ok := p.accept(w); ok {
+				return w, true
+			}
+		default:
+			return nil, false
+		}
+	}
+}
+
+// accept returns true if worker taken from the free list can be used.
+func (p *DynamicPool) accept(w *Worker) (*Worker, bool) {
+	if w.State().Value() != StateReady {
+		// dead worker, already detached
+		return nil, false
+	}
+
+	if err, remove := p.remove.Load(w); remove {
+		p.recycleWorker(w, RecycleRemoved, err)
+		return nil, false
+	}
+
+	if err := w.checkIdle(); err != nil {
+		p.replaceIdle(w, err)
+		return nil, false
+	}
+
+	return w, true
+}
+
+// scaleUp spawns new worker in background if enough tasks are waiting and pool has not reached
+// the maximum number of workers. Lazy pool spawns the worker for every waiting task not covered
+// by the workers being spawned.
+func (p *DynamicPool) scaleUp() {
+	waiting := atomic.LoadInt64(&p.waiting)
+	if p.destroyed() || (!p.cfg.Lazy && waiting < p.cfg.ScaleUpThreshold) {
+		return
+	}
+
+	p.muw.Lock()
+	if int64(len(p.workers))+p.spawning >= p.cfg.MaxWorkers || (p.cfg.Lazy && p.spawning >= waiting) {
+		p.muw.Unlock()
+		return
+	}
+
+	index := p.nextIndex()
+	p.spawning++
+	p.muw.Unlock()
+
+	go func() {
+		w, err := p.createWorker(index)
+		if err != nil {
+			p.logger().Warn("unable to scale up", "error", err)
+
+			// remaining workers keep serving the tasks
+			if len(p.Workers()) == 0 {
+				p.throw(EventPoolError, err)
+			}
+			return
+		}
+
+		p.push(w)
+	}()
+}
+
+// reap destroys workers idle longer than IdleTimeout until pool is destroyed.
+func (p *DynamicPool) reap() {
+	clock := clockOrSystem(p.cfg.Clock)
+	for {
+		timer := clock.NewTimer(p.cfg.IdleTimeout / 2)
+		select {
+		case <-timer.C():
+			p.reapIdle()
+		case <-p.destroy:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// reapIdle destroys idle workers above MinWorkers.
+func (p *DynamicPool) reapIdle() {
+	// workers being stopped are not counted
+	alive := int64(0)
+	for _, w := range p.Workers() {
+		if w.State().IsActive() {
+			alive++
+		}
+	}
+
+	now := clockOrSystem(p.cfg.Clock).Now()
+	for i := len(p.free); i > 0; i-- {
+		var w *Worker
+		select {
+		case w = <-p.free:
+		default:
+			return
+		}
+
+		if w.State().Value() != StateReady {
+			continue
+		}
+
+		lastUsed := w.State().LastUsed()
+		if lastUsed.IsZero() {
+			lastUsed = w.Created
+		}
+
+		if alive > p.cfg.MinWorkers && now.Sub(lastUsed) >= p.cfg.IdleTimeout {
+			alive--
+			p.recycleWorker(w, RecycleIdle, nil)
+			continue
+		}
+
+		p.push(w)
+	}
+}
+
+// inspectWorkers passes all idle workers through the idle check, unhealthy workers are replaced.
+func (p *DynamicPool) inspectWorkers() {
+	for i := len(p.free); i > 0; i-- {
+		var w *Worker
+		select {
+		case w = <-p.free:
+		default:
+			return
+		}
+
+		if w.State().Value() != StateReady {
+			continue
+		}
+
+		if err := w.checkIdle(); err != nil {
+			p.replaceIdle(w, err)
+			continue
+		}
+
+		p.push(w)
+	}
+}
+
+// succeeded does nothing, slots of the dynamic pool are not quarantined.
+func (p *DynamicPool) succeeded(w *Worker) {}
+
+// release releases or replaces the worker.
+func (p *DynamicPool) release(w *Worker) {
+	if _, detached := p.detached.Load(w); detached {
+		// worker belongs to the caller
+		return
+	}
+
+	if p.cfg.MaxJobs != 0 && w.State().NumExecs() >= p.cfg.MaxJobs {
+		p.recycleWorker(w, RecycleMaxJobs, p.cfg.MaxJobs)
+		return
+	}
+
+	if err, remove := p.remove.Load(w); remove {
+		p.recycleWorker(w, RecycleRemoved, err)
+		return
+	}
+
+	if w.Unhealthy() {
+		p.replaceIdle(w, ErrWorkerUnhealthy)
+		return
+	}
+
+	if p.retireExcess(w) {
+		return
+	}
+
+	p.push(w)
+}
+
+// retireExcess destroys released worker if MaxIdle workers are idle already and no tasks are
+// waiting, pool is kept at MinWorkers at least. Returns true if worker has been destroyed.
+func (p *DynamicPool) retireExcess(w *Worker) bool {
+	if p.cfg.MaxIdle == 0 || atomic.LoadInt64(&p.waiting) != 0 {
+		return false
+	}
+
+	// keeps concurrent releases from retiring too many workers
+	p.muw.Lock()
+	defer p.muw.Unlock()
+
+	idle, alive := int64(0), int64(0)
+	for _, wc := range p.workers {
+		if wc.State().IsActive() {
+			alive++
+		}
+
+		if wc != w && wc.State().Value() == StateReady {
+			idle++
+		}
+	}
+
+	if idle < p.cfg.MaxIdle || alive <= p.cfg.MinWorkers {
+		return false
+	}
+
+	p.recycleWorker(w, RecycleIdle, fmt.Errorf("max idle reached (%v)", p.cfg.MaxIdle))
+	return true
+}
+
+// replaceIdle replaces the worker which reported itself unhealthy or sent unexpected data while
+// being idle.
+func (p *DynamicPool) replaceIdle(w *Worker, err error) {
+	if err == ErrWorkerUnhealthy {
+		p.logger().Warn("worker reported unhealthy, worker is replaced", "pid", *w.Pid)
+		p.recycleWorker(w, RecycleUnhealthy, err)
+		return
+	}
+
+	p.logger().Warn("worker relay is out of sync, worker is replaced", "pid", *w.Pid, "error", err)
+	p.throw(EventWorkerError, WorkerError{Worker: w, Caused: err})
+	p.discardWorker(w, RecycleError, err)
+}
+
+// creates new worker using associated factory for the given slot index, caller must
+// reserve the spawn slot.
+func (p *DynamicPool) createWorker(index int) (*Worker, error) {
+	w, err := p.spawnWorker(p.command(), index)
+	if err != nil {
+		p.muw.Lock()
+		p.spawning--
+		delete(p.reserved, index)
+		p.muw.Unlock()
+
+		return nil, err
+	}
+
+	p.muw.Lock()
+	p.spawning--
+	delete(p.reserved, index)
+	p.workers = append(p.workers, w)
+	p.index[w] = index
+	p.muw.Unlock()
+
+	p.events.attach(w)
+	go p.watchWorker(w)
+	return w, nil
+}
+
+// nextIndex returns lowest slot index not used by any worker and reserves it until worker is
+// created, see createWorker. Must be called under muw.
+func (p *DynamicPool) nextIndex() int {
+	used := make(map[int]bool, len(p.index))
+	for _, index := range p.index {
+		used[index] = true
+	}
+
+	index := 0
+	for used[index] || p.reserved[index] {
+		index++
+	}
+
+	p.reserved[index] = true
+	return index
+}
+
+// watchWorker watches worker state and keeps minimal number of workers alive.
+func (p *DynamicPool) watchWorker(w *Worker) {
+	err := w.Wait()
+	if _, ok := p.detached.Load(w); ok {
+		p.detached.Delete(w)
+		return
+	}
+
+	p.events.detach(w)
+	p.throw(EventWorkerDead, w)
+
+	_, recycled := p.recycled.Load(w)
+	p.recycled.Delete(w)
+
+	// workers stopped by the pool destroy and hijacked workers are not accounted
+	_, retired := p.retired.Load(w)
+	if reason, stopped := p.recycles.exited(w); stopped || (!retired && !p.destroyed()) {
+		p.recycles.add(reason)
+	}
+
+	if !retired && !recycled && !p.destroyed() {
+		p.notifyDeath(w, err)
+	}
+
+	if err != nil {
+		p.logger().Warn("worker died", "pid", *w.Pid, "request", w.RequestID(), "error", err, "diagnostics", w.Diagnostics())
+		p.throw(EventWorkerError, WorkerError{Worker: w, Caused: err})
+	}
+
+	// detaching
+	p.muw.Lock()
+	index := p.index[w]
+	delete(p.index, w)
+	for i, wc := range p.workers {
+		if wc == w {
+			p.workers = append(p.workers[:i], p.workers[i+1:]...)
+			p.remove.Delete(w)
+			break
+		}
+	}
+
+	if _, ok := p.retired.Load(w); ok {
+		// replaced by the reload
+		p.retired.Delete(w)
+		p.muw.Unlock()
+		return
+	}
+
+	if p.destroyed() || int64(len(p.workers))+p.spawning >= p.cfg.MinWorkers {
+		p.muw.Unlock()
+		return
+	}
+
+	// slot of the dead worker is kept for its replacement
+	p.spawning++
+	p.reserved[index] = true
+	p.muw.Unlock()
+
+	nw, err := p.createWorker(index)
+	if err == nil {
+		p.push(nw)
+		return
+	}
+
+	p.logger().Error("unable to replace worker", "pid", *w.Pid, "error", err)
+
+	if len(p.Workers()) == 0 {
+		p.throw(EventPoolError, err)
+	} else {
+		p.throw(EventWorkerError, WorkerError{Worker: w, Caused: err})
+	}
+}
@@ -0,0 +1,440 @@
+package roadrunner
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"os/exec"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+var dynamicCfg = DynamicConfig{
+	MinWorkers:       1,
+	MaxWorkers:       3,
+	IdleTimeout:      time.Millisecond * 200,
+	ScaleUpThreshold: 1,
+	AllocateTimeout:  time.Second * 5,
+	DestroyTimeout:   time.Second,
+}
+
+func Test_NewDynamicPool(t *testing.T) {
+	p, err := NewDynamicPool(
+		func(wc WorkerConfig) *exec.Cmd { return exec.Command("php", "tests/client.php", "echo", "pipes") },
+		NewPipeFactory(),
+		dynamicCfg,
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	assert.Equal(t, dynamicCfg, p.Config())
+	assert.Len(t, p.Workers(), 1)
+
+	res, err := p.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", res.String())
+}
+
+func Test_DynamicPool_ConfigError(t *testing.T) {
+	p, err := NewDynamicPool(
+		func(wc WorkerConfig) *exec.Cmd { return exec.Command("php", "tests/client.php", "echo", "pipes") },
+		NewPipeFactory(),
+		DynamicConfig{},
+	)
+
+	assert.Nil(t, p)
+	assert.Error(t, err)
+}
+
+func Test_DynamicPool_Scale(t *testing.T) {
+	p, err := NewDynamicPool(
+		func(wc WorkerConfig) *exec.Cmd { return exec.Command("php", "tests/client.php", "delay", "pipes") },
+		NewPipeFactory(),
+		dynamicCfg,
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	var (
+		wg  sync.WaitGroup
+		mu  sync.Mutex
+		max int
+	)
+
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			_, err := p.Exec(&Payload{Body: []byte("100")})
+			assert.NoError(t, err)
+
+			mu.Lock()
+			if n := len(p.Workers()); n > max {
+				max = n
+			}
+			mu.Unlock()
+		}()
+	}
+
+	wg.Wait()
+
+	assert.True(t, max > 1)
+	assert.True(t, max <= 3)
+
+	// idle workers are destroyed down to MinWorkers
+	time.Sleep(time.Second * 2)
+	assert.Len(t, p.Workers(), 1)
+	assert.Equal(t, int64(max-1), p.Stats().RecycleReasons[RecycleIdle])
+}
+
+func Test_DynamicPool_Lazy(t *testing.T) {
+	p, err := NewDynamicPool(
+		func(wc WorkerConfig) *exec.Cmd { return exec.Command("php", "tests/client.php", "delay", "pipes") },
+		NewPipeFactory(),
+		DynamicConfig{
+			MaxWorkers:       2,
+			Lazy:             true,
+			IdleTimeout:      time.Minute,
+			ScaleUpThreshold: 4,
+			AllocateTimeout:  time.Second * 5,
+			DestroyTimeout:   time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	assert.Len(t, p.Workers(), 0)
+
+	// first task spawns the worker regardless of ScaleUpThreshold
+	_, err = p.Exec(&Payload{Body: []byte("0")})
+	assert.NoError(t, err)
+	assert.Len(t, p.Workers(), 1)
+	pid := *p.Workers()[0].Pid
+
+	// idle worker is reused
+	_, err = p.Exec(&Payload{Body: []byte("0")})
+	assert.NoError(t, err)
+	assert.Len(t, p.Workers(), 1)
+	assert.Equal(t, pid, *p.Workers()[0].Pid)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			_, err := p.Exec(&Payload{Body: []byte("50")})
+			assert.NoError(t, err)
+			assert.True(t, len(p.Workers()) <= 2)
+		}()
+	}
+	wg.Wait()
+
+	assert.Len(t, p.Workers(), 2)
+}
+
+func Test_DynamicPool_Lazy_DistinctIndex(t *testing.T) {
+	p, err := NewDynamicPool(
+		func(wc WorkerConfig) *exec.Cmd { return exec.Command("php", "tests/client.php", "delay", "pipes") },
+		NewPipeFactory(),
+		DynamicConfig{
+			MaxWorkers:       4,
+			Lazy:             true,
+			IdleTimeout:      time.Minute,
+			ScaleUpThreshold: 1,
+			AllocateTimeout:  time.Second * 5,
+			DestroyTimeout:   time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	// concurrently spawned workers get distinct slots
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			_, err := p.Exec(&Payload{Body: []byte("100")})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	ids := make(map[string]bool)
+	for _, w := range p.Workers() {
+		assert.False(t, ids[w.ID], w.ID)
+		ids[w.ID] = true
+	}
+	assert.True(t, len(ids) > 1)
+}
+
+func Test_DynamicPool_Reap_Clock(t *testing.T) {
+	clock := newMockClock()
+
+	p, err := NewDynamicPool(
+		func(wc WorkerConfig) *exec.Cmd { return exec.Command("php", "tests/client.php", "delay", "pipes") },
+		NewPipeFactory(),
+		DynamicConfig{
+			MinWorkers:       1,
+			MaxWorkers:       3,
+			IdleTimeout:      time.Hour,
+			ScaleUpThreshold: 1,
+			AllocateTimeout:  time.Second * 5,
+			DestroyTimeout:   time.Second,
+			Clock:            clock,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			_, err := p.Exec(&Payload{Body: []byte("50")})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	scaled := len(p.Workers())
+	assert.True(t, scaled > 1)
+
+	// workers are idle for less than IdleTimeout
+	assert.True(t, clock.WaitTimers(1))
+	clock.Advance(time.Minute * 30)
+	assert.True(t, clock.WaitTimers(1))
+	assert.Len(t, p.Workers(), scaled)
+
+	clock.Advance(time.Hour)
+	assert.True(t, clock.WaitTimers(1))
+
+	time.Sleep(time.Millisecond * 100)
+	assert.Len(t, p.Workers(), 1)
+}
+
+func Test_DynamicPool_MaxIdle(t *testing.T) {
+	p, err := NewDynamicPool(
+		func(wc WorkerConfig) *exec.Cmd { return exec.Command("php", "tests/client.php", "delay", "pipes") },
+		NewPipeFactory(),
+		DynamicConfig{
+			MinWorkers:       1,
+			MaxWorkers:       5,
+			MaxIdle:          2,
+			ScaleUpThreshold: 1,
+			AllocateTimeout:  time.Second * 10,
+			DestroyTimeout:   time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			_, err := p.Exec(&Payload{Body: []byte("100")})
+			assert.NoError(t, err)
+		}()
+	}
+
+	wg.Wait()
+
+	// excess workers are destroyed on return without waiting for IdleTimeout
+	time.Sleep(time.Millisecond * 500)
+	assert.Len(t, p.Workers(), 2)
+	assert.Equal(t, 2, p.Stats().NumIdle)
+}
+
+func Test_DynamicPool_Stats(t *testing.T) {
+	p, err := NewDynamicPool(
+		func(wc WorkerConfig) *exec.Cmd { return exec.Command("php", "tests/client.php", "echo", "pipes") },
+		NewPipeFactory(),
+		dynamicCfg,
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	_, err = p.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+
+	stats := p.Stats()
+	assert.Equal(t, len(p.Workers()), stats.NumWorkers)
+	assert.Equal(t, stats.NumWorkers, stats.NumIdle+stats.NumBusy)
+	assert.Equal(t, int64(1), stats.TotalExecs)
+	assert.Equal(t, int64(0), stats.TotalErrors)
+}
+
+func Test_DynamicPool_Allocate(t *testing.T) {
+	p, err := NewDynamicPool(
+		func(wc WorkerConfig) *exec.Cmd { return exec.Command("php", "tests/client.php", "echo", "pipes") },
+		NewPipeFactory(),
+		dynamicCfg,
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	w, err := p.Allocate(context.Background())
+	assert.NoError(t, err)
+
+	res, err := w.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", res.String())
+
+	p.Release(w, false)
+
+	// repeated release is ignored
+	p.Release(w, false)
+	assert.Equal(t, 1, p.Stats().NumIdle)
+}
+
+func Test_DynamicPool_ReloadAll(t *testing.T) {
+	p, err := NewDynamicPool(
+		func(wc WorkerConfig) *exec.Cmd { return exec.Command("php", "tests/client.php", "echo", "pipes") },
+		NewPipeFactory(),
+		dynamicCfg,
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	workers := p.Workers()
+	assert.NoError(t, p.ReloadAll(0))
+
+	for _, w := range workers {
+		<-w.waitDone
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	assert.Len(t, p.Workers(), len(workers))
+	for _, w := range workers {
+		assert.NotContains(t, p.Workers(), w)
+	}
+
+	res, err := p.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", res.String())
+}
+
+func Test_DynamicPool_TryExec(t *testing.T) {
+	p, err := NewDynamicPool(
+		func(wc WorkerConfig) *exec.Cmd { return exec.Command("php", "tests/client.php", "echo", "pipes") },
+		NewPipeFactory(),
+		dynamicCfg,
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	res, acquired, err := p.TryExec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+	assert.Equal(t, "hello", res.String())
+
+	p.Destroy()
+
+	_, acquired, err = p.TryExec(&Payload{Body: []byte("hello")})
+	assert.Equal(t, ErrPoolDestroyed, err)
+	assert.False(t, acquired)
+}
+
+func Test_DynamicPool_OnWorkerDeath(t *testing.T) {
+	p, err := NewDynamicPool(
+		func(wc WorkerConfig) *exec.Cmd { return exec.Command("php", "tests/client.php", "broken", "pipes") },
+		NewPipeFactory(),
+		dynamicCfg,
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	dead := make(chan int, 1)
+	p.OnWorkerDeath(func(pid int, err error) {
+		dead <- pid
+	})
+
+	w := p.Workers()[0]
+
+	_, err = p.Exec(&Payload{Body: []byte("hello")})
+	assert.Error(t, err)
+
+	assert.Equal(t, *w.Pid, <-dead)
+}
+
+func Test_DynamicPool_Healthy(t *testing.T) {
+	p, err := NewDynamicPool(
+		func(wc WorkerConfig) *exec.Cmd { return exec.Command("php", "tests/client.php", "echo", "pipes") },
+		NewPipeFactory(),
+		dynamicCfg,
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	ok, err := p.Healthy()
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func Test_DynamicPool_Destroy(t *testing.T) {
+	p, err := NewDynamicPool(
+		func(wc WorkerConfig) *exec.Cmd { return exec.Command("php", "tests/client.php", "echo", "pipes") },
+		NewPipeFactory(),
+		dynamicCfg,
+	)
+	assert.NoError(t, err)
+
+	p.Destroy()
+
+	_, err = p.Exec(&Payload{Body: []byte("hello")})
+	assert.Equal(t, ErrPoolDestroyed, err)
+}
+
+func Test_DynamicPool_IdleCheckInterval(t *testing.T) {
+	p, err := NewDynamicPool(
+		func(wc WorkerConfig) *exec.Cmd { return exec.Command("php", "tests/client.php", "unhealthy", "pipes") },
+		NewPipeFactory(),
+		DynamicConfig{
+			MinWorkers:        1,
+			MaxWorkers:        1,
+			ScaleUpThreshold:  1,
+			AllocateTimeout:   time.Second,
+			DestroyTimeout:    time.Second,
+			IdleCheckInterval: time.Millisecond * 20,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	res, err := p.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+
+	time.Sleep(time.Millisecond * 500)
+
+	// replaced without being allocated
+	assert.Len(t, p.Workers(), 1)
+	assert.NotEqual(t, res.String(), strconv.Itoa(p.Workers()[0].PID()))
+}
+
+func Test_DynamicPool_ExecFresh(t *testing.T) {
+	p, err := NewDynamicPool(
+		func(wc WorkerConfig) *exec.Cmd { return exec.Command("php", "tests/client.php", "pid", "pipes") },
+		NewPipeFactory(),
+		dynamicCfg,
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	pid := strconv.Itoa(*p.Workers()[0].Pid)
+
+	res, err := p.ExecFresh(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.NotEqual(t, pid, res.String())
+
+	// fresh worker is never added to the pool
+	assert.Len(t, p.Workers(), 1)
+}
@@ -20,6 +20,7 @@ type errBuffer struct {
 	mu     sync.Mutex
 	buf    []byte
 	last   int
+	recent []byte
 	wait   *time.Timer
 	update chan interface{}
 	stop   chan interface{}
@@ -92,12 +93,49 @@ func (eb *errBuffer) Len() int {
 func (eb *errBuffer) Write(p []byte) (int, error) {
 	eb.mu.Lock()
 	eb.buf = append(eb.buf, p...)
+	eb.keep(p)
 	eb.mu.Unlock()
 	eb.update <- nil
 
 	return len(p), nil
 }
 
+// Tail returns copy of the last n bytes of errBuffer data.
+func (eb *errBuffer) Tail(n int) []byte {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+
+	buf := eb.buf
+	if len(buf) > n {
+		buf = buf[len(buf)-n:]
+	}
+
+	return append([]byte(nil), buf...)
+}
+
+// Recent returns copy of the last StderrTailSize bytes written into errBuffer, unlike Tail
+// the data is kept once it has been passed to the listener.
+func (eb *errBuffer) Recent() []byte {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+
+	return append([]byte(nil), eb.recent...)
+}
+
+// keep appends p to the recent output limited by StderrTailSize, must be called under mu.
+func (eb *errBuffer) keep(p []byte) {
+	if len(p) >= StderrTailSize {
+		eb.recent = append(eb.recent[:0], p[len(p)-StderrTailSize:]...)
+		return
+	}
+
+	if overflow := len(eb.recent) + len(p) - StderrTailSize; overflow > 0 {
+		eb.recent = eb.recent[:copy(eb.recent, eb.recent[overflow:])]
+	}
+
+	eb.recent = append(eb.recent, p...)
+}
+
 // Strings fetches all errBuffer data into string.
 func (eb *errBuffer) String() string {
 	eb.mu.Lock()
@@ -129,3 +129,81 @@ func TestErrBuffer_Write_Remaining(t *testing.T) {
 	assert.Equal(t, 3, buf.Len())
 	assert.Equal(t, "hel", buf.String())
 }
+
+func TestErrBuffer_Tail(t *testing.T) {
+	buf := newErrBuffer()
+	defer func() {
+		err := buf.Close()
+		if err != nil {
+			t.Errorf("error during closing the buffer: error %v", err)
+		}
+	}()
+
+	assert.Len(t, buf.Tail(4), 0)
+
+	_, err := buf.Write([]byte("hello"))
+	if err != nil {
+		t.Errorf("fail to write: error %v", err)
+	}
+
+	assert.Equal(t, []byte("ello"), buf.Tail(4))
+	assert.Equal(t, []byte("hello"), buf.Tail(10))
+}
+
+func TestErrBuffer_Recent(t *testing.T) {
+	buf := newErrBuffer()
+	defer func() {
+		err := buf.Close()
+		if err != nil {
+			t.Errorf("error during closing the buffer: error %v", err)
+		}
+	}()
+
+	tr := make(chan interface{})
+	buf.Listen(func(event int, ctx interface{}) {
+		close(tr)
+	})
+
+	_, err := buf.Write([]byte("hello\n"))
+	if err != nil {
+		t.Errorf("fail to write: error %v", err)
+	}
+
+	<-tr
+
+	// output is kept after being passed to the listener
+	assert.Equal(t, 0, buf.Len())
+	assert.Equal(t, "hello\n", string(buf.Recent()))
+}
+
+func TestErrBuffer_Recent_Limit(t *testing.T) {
+	buf := newErrBuffer()
+	defer func() {
+		err := buf.Close()
+		if err != nil {
+			t.Errorf("error during closing the buffer: error %v", err)
+		}
+	}()
+
+	chunk := make([]byte, StderrTailSize/3)
+	for i := 0; i < 10; i++ {
+		for j := range chunk {
+			chunk[j] = byte('a' + i)
+		}
+
+		_, err := buf.Write(chunk)
+		if err != nil {
+			t.Errorf("fail to write: error %v", err)
+		}
+	}
+
+	recent := buf.Recent()
+	assert.Len(t, recent, StderrTailSize)
+	assert.Equal(t, byte('a'+9), recent[len(recent)-1])
+
+	_, err := buf.Write(make([]byte, StderrTailSize*2))
+	if err != nil {
+		t.Errorf("fail to write: error %v", err)
+	}
+	assert.Len(t, buf.Recent(), StderrTailSize)
+}
@@ -1,5 +1,98 @@
 package roadrunner
 
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime/debug"
+)
+
+var (
+	// ErrPoolDestroyed is returned when task is sent to the pool which is being destroyed.
+	ErrPoolDestroyed = errors.New("pool has been destroyed")
+
+	// ErrExecTimeout is returned when worker failed to complete the task in a given time.
+	ErrExecTimeout = errors.New("worker exec timeout")
+
+	// ErrAllocTimeout is returned when task did not receive free worker within allocate timeout or MaxWait.
+	ErrAllocTimeout = errors.New("worker timeout")
+
+	// ErrPoolUnavailable is returned when worker spawn circuit breaker is open.
+	ErrPoolUnavailable = errors.New("pool is unavailable, workers fail to start")
+
+	// ErrPoolPaused is returned when task is sent to the paused pool configured to reject tasks.
+	ErrPoolPaused = errors.New("pool is paused")
+
+	// ErrPoolNotReady is returned when task is sent to the pool which has not started MinReady
+	// workers yet and is configured to reject tasks, or when pool did not become ready within
+	// allocate timeout.
+	ErrPoolNotReady = errors.New("pool is not ready")
+
+	// ErrFactoryClosed is returned when worker is spawned by the closed factory or factory which
+	// listener has been closed and can not accept worker relays anymore.
+	ErrFactoryClosed = errors.New("factory closed")
+
+	// ErrQueueFull is returned when all workers are busy and pool queue reached MaxQueueSize.
+	ErrQueueFull = errors.New("pool queue is full")
+
+	// ErrPayloadTooLarge is returned when payload or worker response exceeds max payload size.
+	ErrPayloadTooLarge = errors.New("payload is too large")
+
+	// ErrUnexpectedFrame is returned when worker sent more data than the expected response, relay
+	// stream is out of sync and worker can not be used anymore.
+	ErrUnexpectedFrame = errors.New("unexpected frame received after the response")
+
+	// ErrTooManyFrames is returned when worker sent more frames in response to the task than
+	// allowed by MaxResponseFrames, worker is killed without reading the rest of the frames.
+	ErrTooManyFrames = errors.New("too many response frames")
+
+	// ErrWorkerUnhealthy is returned when idle worker reported itself unhealthy using the unhealthy
+	// control command ({"unhealthy":true}).
+	ErrWorkerUnhealthy = errors.New("worker reported itself unhealthy")
+
+	// ErrRelayIO is matched (errors.Is) by execution errors caused by the relay transport failure:
+	// goridge Send and Receive errors returned by the connection or pipe (EOF, closed pipe, network
+	// and syscall errors). Worker is most likely dead and must be replaced.
+	ErrRelayIO = errors.New("relay io error")
+
+	// ErrRelayProtocol is matched (errors.Is) by execution errors caused by the malformed or
+	// unexpected frames: goridge prefix validation errors and recovered panics, missing control
+	// flags, oversized, unexpected and excess frames. Relay stream is out of sync and worker must be
+	// replaced, usually indicates a bug in the worker protocol implementation.
+	ErrRelayProtocol = errors.New("relay protocol error")
+)
+
+// ErrWorkerError is application error returned by the worker using the error flag, worker is
+// kept alive. Same type as JobError.
+type ErrWorkerError = JobError
+
+// relayError puts relay failure into ErrRelayIO or ErrRelayProtocol category, message and cause
+// of the original error are kept.
+type relayError struct {
+	kind error
+	err  error
+}
+
+// Error converts error context to string
+func (e relayError) Error() string {
+	return e.err.Error()
+}
+
+// Cause returns original relay error.
+func (e relayError) Cause() error {
+	return e.err
+}
+
+// Unwrap returns original relay error.
+func (e relayError) Unwrap() error {
+	return e.err
+}
+
+// Is reports whether error belongs to the given category.
+func (e relayError) Is(target error) bool {
+	return target == e.kind
+}
+
 // JobError is job level error (no worker halt), wraps at top
 // of error context
 type JobError []byte
@@ -16,9 +109,76 @@ type WorkerError struct {
 
 	// Caused error
 	Caused error
+
+	// Stderr contains captured worker stderr output, if any.
+	Stderr []byte
 }
 
 // Error converts error context to string
 func (e WorkerError) Error() string {
 	return e.Caused.Error()
 }
+
+// PanicError describes panic recovered inside the factory goroutine.
+type PanicError struct {
+	// Value passed to panic.
+	Value interface{}
+
+	// Stack of the panicked goroutine.
+	Stack []byte
+}
+
+// newPanicError captures stack of the recovered panic, must be called by the deferred function.
+func newPanicError(v interface{}) PanicError {
+	return PanicError{Value: v, Stack: debug.Stack()}
+}
+
+// Error converts error context to string
+func (e PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// WaitError describes unsuccessful worker process termination.
+type WaitError struct {
+	// Code contains process exit code, -1 if process was terminated by a signal.
+	Code int
+
+	// Signal which terminated the process, nil if process exited by itself.
+	Signal os.Signal
+
+	// Stderr contains captured worker stderr output, if any.
+	Stderr []byte
+}
+
+// Status returns exit code or signal description of the process termination.
+func (e WaitError) Status() string {
+	if e.Signal != nil {
+		return fmt.Sprintf("signal: %s", e.Signal)
+	}
+
+	return fmt.Sprintf("exit status %v", e.Code)
+}
+
+// Error converts error context to string
+func (e WaitError) Error() string {
+	if len(e.Stderr) == 0 {
+		return e.Status()
+	}
+
+	return fmt.Sprintf("%s: %s", e.Status(), e.Stderr)
+}
+
+// ExitError is returned by the SocketFactory when worker process exits before connecting to the
+// relay, for example once the binary fails right after the start.
+type ExitError struct {
+	WaitError
+}
+
+// Error converts error context to string
+func (e ExitError) Error() string {
+	if e.Signal != nil {
+		return fmt.Sprintf("worker exited before connecting (signal=%s)", e.Signal)
+	}
+
+	return fmt.Sprintf("worker exited before connecting (code=%v)", e.Code)
+}
@@ -2,7 +2,12 @@ package roadrunner
 
 import (
 	"errors"
+	pkgerrors "github.com/pkg/errors"
+	"github.com/spiral/goridge/v2"
 	"github.com/stretchr/testify/assert"
+	"io"
+	"os/exec"
+	"syscall"
 	"testing"
 )
 
@@ -15,3 +20,169 @@ func Test_WorkerError_Error(t *testing.T) {
 	e := WorkerError{Worker: nil, Caused: errors.New("error")}
 	assert.Equal(t, "error", e.Error())
 }
+
+func Test_WaitError_Error(t *testing.T) {
+	e := WaitError{Code: 255}
+	assert.Equal(t, "exit status 255", e.Error())
+
+	e = WaitError{Code: -1, Signal: syscall.SIGKILL, Stderr: []byte("error")}
+	assert.Equal(t, "signal: killed: error", e.Error())
+}
+
+// relayWorker returns worker attached to the relay of the fake worker side, task frames are
+// consumed before respond is called.
+func relayWorker(t *testing.T, respond func(rl goridge.Relay)) *Worker {
+	w, _ := newWorker(exec.Command("sleep", "10"))
+	assert.NoError(t, w.start())
+
+	conn, wConn := connPair(t)
+	w.rl = goridge.NewSocketRelay(conn)
+	w.state.set(StateReady)
+
+	rl := goridge.NewSocketRelay(wConn)
+	go func() {
+		// context and body
+		for i := 0; i < 2; i++ {
+			if _, _, err := rl.Receive(); err != nil {
+				return
+			}
+		}
+
+		respond(rl)
+		_ = wConn.Close()
+	}()
+
+	return w
+}
+
+func Test_RelayError_IO(t *testing.T) {
+	w := relayWorker(t, func(rl goridge.Relay) {})
+	defer w.Kill()
+
+	_, err := w.Exec(&Payload{Body: []byte("hello")})
+	assert.Error(t, err)
+	assert.True(t, errors.Is(err, ErrRelayIO))
+	assert.False(t, errors.Is(err, ErrRelayProtocol))
+	assert.Equal(t, io.EOF, pkgerrors.Cause(err))
+}
+
+func Test_RelayError_Protocol(t *testing.T) {
+	w := relayWorker(t, func(rl goridge.Relay) {
+		_ = rl.Send([]byte("hello"), goridge.PayloadRaw)
+	})
+	defer w.Kill()
+
+	_, err := w.Exec(&Payload{Body: []byte("hello")})
+	assert.Error(t, err)
+	assert.True(t, errors.Is(err, ErrRelayProtocol))
+	assert.False(t, errors.Is(err, ErrRelayIO))
+	assert.Equal(t, "worker error: malformed worker response", err.Error())
+}
+
+func Test_RelayError_ReportedStatus(t *testing.T) {
+	w := relayWorker(t, func(rl goridge.Relay) {
+		_ = rl.Send([]byte(`{"worker_status":"degraded: cache miss rate high"}`), goridge.PayloadControl)
+		_ = rl.Send([]byte("hello"), goridge.PayloadRaw)
+
+		for i := 0; i < 2; i++ {
+			if _, _, err := rl.Receive(); err != nil {
+				return
+			}
+		}
+
+		_ = rl.Send([]byte("hello"), goridge.PayloadRaw)
+	})
+	defer w.Kill()
+
+	_, err := w.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, "degraded: cache miss rate high", w.LastReportedStatus())
+
+	_, err = w.Exec(&Payload{Body: []byte("hello")})
+	assert.Error(t, err)
+	assert.True(t, errors.Is(err, ErrRelayProtocol))
+	assert.Equal(t, "worker error (worker status: degraded: cache miss rate high): malformed worker response", err.Error())
+}
+
+func Test_RelayError_WorkerError(t *testing.T) {
+	w := relayWorker(t, func(rl goridge.Relay) {
+		_ = rl.Send([]byte("job error"), goridge.PayloadControl|goridge.PayloadError)
+	})
+	defer w.Kill()
+
+	_, err := w.Exec(&Payload{Body: []byte("hello")})
+	assert.Equal(t, ErrWorkerError("job error"), err)
+	assert.False(t, errors.Is(err, ErrRelayIO))
+	assert.False(t, errors.Is(err, ErrRelayProtocol))
+	assert.Equal(t, StateReady, w.State().Value())
+}
+
+func Test_RelayError_WorkerError_ReportedStatus(t *testing.T) {
+	w := relayWorker(t, func(rl goridge.Relay) {
+		_ = rl.Send([]byte(`{"worker_status":"degraded"}`), goridge.PayloadControl)
+		_ = rl.Send([]byte("validation failed"), goridge.PayloadRaw|goridge.PayloadError)
+
+		for i := 0; i < 2; i++ {
+			if _, _, err := rl.Receive(); err != nil {
+				return
+			}
+		}
+
+		_ = rl.Send([]byte("job error"), goridge.PayloadControl|goridge.PayloadError)
+	})
+	defer w.Kill()
+
+	// status is reported with the error response
+	_, err := w.Exec(&Payload{Body: []byte("hello")})
+	assert.Equal(t, ErrWorkerError("validation failed (worker status: degraded)"), err)
+	assert.Equal(t, "degraded", w.LastReportedStatus())
+
+	_, err = w.Exec(&Payload{Body: []byte("hello")})
+	assert.Equal(t, JobError("job error (worker status: degraded)"), err)
+	assert.Equal(t, StateReady, w.State().Value())
+}
+
+func Test_RelayError_WorkerErrorBody(t *testing.T) {
+	w := relayWorker(t, func(rl goridge.Relay) {
+		_ = rl.Send([]byte(`{"code":422}`), goridge.PayloadControl)
+		_ = rl.Send([]byte("validation failed"), goridge.PayloadRaw|goridge.PayloadError)
+
+		// worker is reused for the next task
+		for i := 0; i < 2; i++ {
+			if _, _, err := rl.Receive(); err != nil {
+				return
+			}
+		}
+
+		_ = rl.Send([]byte(`{"code":200}`), goridge.PayloadControl)
+		_ = rl.Send([]byte("hello"), goridge.PayloadRaw)
+	})
+	defer w.Kill()
+
+	_, err := w.Exec(&Payload{Body: []byte("hello")})
+	assert.Equal(t, ErrWorkerError("validation failed"), err)
+	assert.False(t, errors.Is(err, ErrRelayIO))
+	assert.False(t, errors.Is(err, ErrRelayProtocol))
+	assert.Equal(t, StateReady, w.State().Value())
+
+	res, err := w.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"code":200}`, string(res.Context))
+	assert.Equal(t, "hello", res.String())
+	assert.Equal(t, int64(2), w.State().NumExecs())
+}
+
+func Test_RelayFailure(t *testing.T) {
+	tooLarge := pkgerrors.Wrap(ErrPayloadTooLarge, "frame of 10 bytes exceeds 5 bytes")
+	assert.True(t, errors.Is(relayFailure(tooLarge), ErrRelayProtocol))
+	assert.Equal(t, ErrPayloadTooLarge, pkgerrors.Cause(requestError(tooLarge, "worker error", "")))
+
+	assert.True(t, errors.Is(relayFailure(io.ErrClosedPipe), ErrRelayIO))
+	assert.True(t, errors.Is(relayFailure(syscall.EPIPE), ErrRelayIO))
+	assert.True(t, errors.Is(relayFailure(errors.New("invalid data found in the buffer (possible echo)")), ErrRelayProtocol))
+
+	// category is kept once error is wrapped again
+	err := requestError(requestError(io.EOF, "worker error", ""), "worker error", "abc")
+	assert.True(t, errors.Is(err, ErrRelayIO))
+	assert.Equal(t, "worker error (request abc): worker error: EOF", err.Error())
+}
@@ -0,0 +1,46 @@
+package roadrunner
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"time"
+)
+
+const (
+	// bounds of the delay between task retries
+	minRetryDelay = 10 * time.Millisecond
+	maxRetryDelay = time.Second
+)
+
+// retryable returns true if failed task can be replayed on another worker. Only idempotent tasks
+// failed due to worker error (worker died or timed out) are retried, job errors are final.
+func retryable(rqs *Payload, err error, attempt, maxRetries int64) bool {
+	if err == nil || !rqs.Idempotent || attempt >= maxRetries {
+		return false
+	}
+
+	if _, jobError := err.(JobError); jobError {
+		return false
+	}
+
+	// caller is no longer waiting for the result
+	if err == context.Canceled || err == context.DeadlineExceeded {
+		return false
+	}
+
+	// another worker would receive the same payload
+	return errors.Cause(err) != ErrPayloadTooLarge
+}
+
+// retryDelay returns exponential backoff delay before the retry of the given attempt.
+func retryDelay(attempt int64) time.Duration {
+	if attempt >= 7 {
+		return maxRetryDelay
+	}
+
+	if d := minRetryDelay << uint(attempt); d < maxRetryDelay {
+		return d
+	}
+
+	return maxRetryDelay
+}
@@ -0,0 +1,34 @@
+package roadrunner
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_Retryable(t *testing.T) {
+	rqs := &Payload{Body: []byte("hello"), Idempotent: true}
+	workerErr := fmt.Errorf("worker error")
+
+	assert.True(t, retryable(rqs, workerErr, 0, 2))
+	assert.True(t, retryable(rqs, ErrExecTimeout, 1, 2))
+
+	assert.False(t, retryable(rqs, nil, 0, 2))
+	assert.False(t, retryable(rqs, workerErr, 2, 2))
+	assert.False(t, retryable(rqs, workerErr, 0, 0))
+	assert.False(t, retryable(rqs, JobError("job error"), 0, 2))
+	assert.False(t, retryable(rqs, errors.Wrap(ErrPayloadTooLarge, "worker error"), 0, 2))
+	assert.False(t, retryable(&Payload{Body: []byte("hello")}, workerErr, 0, 2))
+	assert.False(t, retryable(rqs, context.Canceled, 0, 2))
+	assert.False(t, retryable(rqs, context.DeadlineExceeded, 0, 2))
+}
+
+func Test_RetryDelay(t *testing.T) {
+	assert.Equal(t, minRetryDelay, retryDelay(0))
+	assert.Equal(t, minRetryDelay*2, retryDelay(1))
+	assert.Equal(t, minRetryDelay*4, retryDelay(2))
+	assert.Equal(t, maxRetryDelay, retryDelay(7))
+	assert.Equal(t, maxRetryDelay, retryDelay(100))
+}
@@ -1,13 +1,32 @@
 package roadrunner
 
-import "os/exec"
+import (
+	"context"
+	"os/exec"
+)
 
 // Factory is responsible of wrapping given command into tasks worker.
 type Factory interface {
 	// SpawnWorker creates new worker process based on given command.
-	// Process must not be started.
+	// Process must not be started. Returned worker is connected to its relay
+	// and is in StateReady, on error no worker process is left running.
 	SpawnWorker(cmd *exec.Cmd) (w *Worker, err error)
 
 	// Close the factory and underlying connections.
 	Close() error
 }
+
+// contextFactory is the Factory spawning workers under the context, see
+// SocketFactory.SpawnWorkerContext.
+type contextFactory interface {
+	SpawnWorkerContext(ctx context.Context, cmd *exec.Cmd) (w *Worker, err error)
+}
+
+// spawnContext spawns worker under ctx when factory supports it, ctx carries the spawn span.
+func spawnContext(ctx context.Context, f Factory, cmd *exec.Cmd) (*Worker, error) {
+	if cf, ok := f.(contextFactory); ok {
+		return cf.SpawnWorkerContext(ctx, cmd)
+	}
+
+	return f.SpawnWorker(cmd)
+}
@@ -0,0 +1,77 @@
+package roadrunner
+
+import (
+	"github.com/pkg/errors"
+	"sync"
+)
+
+// FallbackFunc computes response body inline when pool is unable to provide the worker for the
+// task, for example returns cached or default response. See ManagedPool.SetFallback.
+type FallbackFunc func(payload []byte) ([]byte, error)
+
+// ServedBy describes what has produced the task response, see ExecMeta.ServedBy.
+type ServedBy int
+
+const (
+	// ServedByWorker task has been executed by the pool worker.
+	ServedByWorker ServedBy = iota
+
+	// ServedByFallback task has been served by the pool fallback, no worker was available.
+	ServedByFallback
+)
+
+// String returns name of the task response origin.
+func (s ServedBy) String() string {
+	switch s {
+	case ServedByWorker:
+		return "worker"
+	case ServedByFallback:
+		return "fallback"
+	}
+
+	return "undefined"
+}
+
+// fallback serves tasks which can not be allocated the worker using optional FallbackFunc.
+type fallback struct {
+	mu sync.Mutex
+	f  FallbackFunc
+}
+
+// set replaces fallback function, nil disables the fallback.
+func (fb *fallback) set(f FallbackFunc) {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+
+	fb.f = f
+}
+
+// serve executes fallback for the task failed to allocate the worker due to err, err is returned
+// as is when fallback is not set or pool failure is not caused by the lack of workers (circuit
+// breaker is open, queue is full or allocation timed out).
+func (fb *fallback) serve(rqs *Payload, meta *ExecMeta, err error) (*Payload, error) {
+	fb.mu.Lock()
+	f := fb.f
+	fb.mu.Unlock()
+
+	if f == nil || rqs == nil {
+		return nil, err
+	}
+
+	switch errors.Cause(err) {
+	case ErrPoolUnavailable, ErrQueueFull, ErrAllocTimeout:
+	default:
+		return nil, err
+	}
+
+	if meta != nil {
+		*meta = ExecMeta{ServedBy: ServedByFallback}
+	}
+
+	body, ferr := f(rqs.Body)
+	if ferr != nil {
+		return nil, errors.Wrap(ferr, "fallback")
+	}
+
+	return &Payload{Body: body}, nil
+}
@@ -0,0 +1,102 @@
+package roadrunner
+
+import (
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func Test_ServedBy_String(t *testing.T) {
+	assert.Equal(t, "worker", ServedByWorker.String())
+	assert.Equal(t, "fallback", ServedByFallback.String())
+	assert.Equal(t, "undefined", ServedBy(-1).String())
+}
+
+func Test_Fallback_Serve(t *testing.T) {
+	var fb fallback
+
+	// disabled by default
+	_, err := fb.serve(&Payload{Body: []byte("hello")}, nil, ErrAllocTimeout)
+	assert.Equal(t, ErrAllocTimeout, err)
+
+	fb.set(func(payload []byte) ([]byte, error) {
+		return append([]byte("cached "), payload...), nil
+	})
+
+	for _, cause := range []error{ErrPoolUnavailable, ErrQueueFull, errors.Wrap(ErrAllocTimeout, "unable to allocate worker")} {
+		meta := ExecMeta{Pid: 1}
+		rsp, err := fb.serve(&Payload{Body: []byte("hello")}, &meta, cause)
+		assert.NoError(t, err)
+		assert.Equal(t, "cached hello", rsp.String())
+		assert.Equal(t, ExecMeta{ServedBy: ServedByFallback}, meta)
+	}
+
+	// not caused by the lack of workers
+	_, err = fb.serve(&Payload{Body: []byte("hello")}, nil, ErrPoolDestroyed)
+	assert.Equal(t, ErrPoolDestroyed, err)
+}
+
+func Test_Fallback_Error(t *testing.T) {
+	var fb fallback
+	fb.set(func(payload []byte) ([]byte, error) {
+		return nil, errors.New("no cached response")
+	})
+
+	_, err := fb.serve(&Payload{Body: []byte("hello")}, nil, ErrQueueFull)
+	assert.EqualError(t, err, "fallback: no cached response")
+}
+
+func Test_StaticPool_Fallback(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "delay", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      1,
+			AllocateTimeout: time.Millisecond * 50,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	p.SetFallback(func(payload []byte) ([]byte, error) {
+		return []byte("fallback"), nil
+	})
+
+	go func() {
+		_, err := p.Exec(&Payload{Body: []byte("200")})
+		assert.NoError(t, err)
+	}()
+
+	// to ensure that worker is already busy
+	time.Sleep(time.Millisecond * 20)
+
+	res, meta, err := p.ExecWithMeta(&Payload{Body: []byte("10")})
+	assert.NoError(t, err)
+	assert.Equal(t, "fallback", res.String())
+	assert.Equal(t, ServedByFallback, meta.ServedBy)
+	assert.Equal(t, 0, meta.Pid)
+
+	// worker is available again
+	time.Sleep(time.Millisecond * 200)
+
+	res, meta, err = p.ExecWithMeta(&Payload{Body: []byte("10")})
+	assert.NoError(t, err)
+	assert.Equal(t, "", res.String())
+	assert.Equal(t, ServedByWorker, meta.ServedBy)
+	assert.Equal(t, *p.Workers()[0].Pid, meta.Pid)
+
+	// disabled
+	p.SetFallback(nil)
+
+	go func() {
+		_, err := p.Exec(&Payload{Body: []byte("200")})
+		assert.NoError(t, err)
+	}()
+	time.Sleep(time.Millisecond * 20)
+
+	_, err = p.Exec(&Payload{Body: []byte("10")})
+	assert.Equal(t, ErrAllocTimeout, errors.Cause(err))
+}
@@ -0,0 +1,110 @@
+package roadrunner
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/spiral/goridge/v2"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// RelayFDEnv defines environment variable containing number of the file descriptor of the
+// relay socket inherited by the worker spawned using FDFactory.
+const RelayFDEnv = "RR_RELAY_FD"
+
+var _ Factory = (*FDFactory)(nil)
+
+// FDFactory connects to workers over socket pair, worker inherits connected end of the pair as
+// file descriptor (see RelayFDEnv) and does not dial back to the listener. Not supported on Windows.
+type FDFactory struct {
+	// ProtocolConstraint defines worker protocol versions accepted by the factory, example:
+	// ">=2.0.0 <3.0.0". Empty value disables the version check.
+	ProtocolConstraint string
+
+	// ResourceLimits applied to every spawned worker process.
+	ResourceLimits ResourceLimits
+
+	// Codec defines how payload bodies are encoded, must match the worker. Raw when nil.
+	Codec Codec
+}
+
+// NewFDFactory returns new factory instance.
+func NewFDFactory() *FDFactory {
+	return &FDFactory{}
+}
+
+// SpawnWorker creates new worker and connects it to goridge relay over inherited socket,
+// method Wait() must be handled on level above.
+func (f *FDFactory) SpawnWorker(cmd *exec.Cmd) (w *Worker, err error) {
+	if w, err = newWorker(cmd); err != nil {
+		return nil, err
+	}
+
+	local, remote, err := socketPair()
+	if err != nil {
+		return nil, errors.Wrap(err, "socket pair")
+	}
+
+	conn, err := net.FileConn(local)
+	_ = local.Close()
+	if err != nil {
+		_ = remote.Close()
+		return nil, errors.Wrap(err, "socket pair")
+	}
+
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+
+	// extra files start after stdin, stdout and stderr
+	cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", RelayFDEnv, strconv.Itoa(3+len(cmd.ExtraFiles))))
+	cmd.ExtraFiles = append(cmd.ExtraFiles, remote)
+
+	fc := newFrameConn(conn)
+	w.rl = goridge.NewSocketRelay(fc)
+	w.conn, w.frames = fc, fc.frames
+	w.stream, _ = conn.(syscall.Conn)
+
+	w.limits = f.ResourceLimits
+	err = w.start()
+
+	// worker owns remote end from now on
+	_ = remote.Close()
+
+	if err != nil {
+		_ = conn.Close()
+		return nil, errors.Wrap(err, "process error")
+	}
+
+	connected := time.Now()
+	link, err := handshake(w.rl, "", "")
+	if err == nil && link.Pid != *w.Pid {
+		err = fmt.Errorf("unexpected pid %v", link.Pid)
+	}
+
+	if err != nil {
+		return nil, w.failStart(err)
+	}
+	w.meta = link.meta()
+	w.relayWait = time.Since(connected)
+
+	if f.ProtocolConstraint != "" {
+		if err := w.checkProtocol(f.ProtocolConstraint); err != nil {
+			return nil, w.failStart(errors.Wrap(err, "protocol"))
+		}
+	}
+
+	w.Transport = "fd"
+	w.codec = f.Codec
+	w.state.set(StateReady)
+	return w, nil
+}
+
+// Close the factory.
+func (f *FDFactory) Close() error {
+	return nil
+}
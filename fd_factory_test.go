@@ -0,0 +1,80 @@
+// +build !windows
+
+package roadrunner
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func Test_FD_Start(t *testing.T) {
+	cmd := exec.Command("php", "tests/client.php", "echo", "fd")
+
+	w, err := NewFDFactory().SpawnWorker(cmd)
+	assert.NoError(t, err)
+	assert.NotNil(t, w)
+
+	go func() {
+		assert.NoError(t, w.Wait())
+	}()
+
+	assert.Equal(t, "fd", w.Transport)
+	assert.NoError(t, w.Stop())
+}
+
+func Test_FD_Echo(t *testing.T) {
+	cmd := exec.Command("php", "tests/client.php", "echo", "fd")
+
+	w, _ := NewFDFactory().SpawnWorker(cmd)
+	go func() {
+		assert.NoError(t, w.Wait())
+	}()
+	defer w.Stop()
+
+	res, err := w.Exec(&Payload{Body: []byte("hello")})
+
+	assert.NoError(t, err)
+	assert.NotNil(t, res)
+	assert.Nil(t, res.Context)
+	assert.Equal(t, "hello", res.String())
+}
+
+func Test_FD_Failboot(t *testing.T) {
+	cmd := exec.Command("php", "tests/failboot.php")
+
+	w, err := NewFDFactory().SpawnWorker(cmd)
+	assert.Nil(t, w)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failboot")
+}
+
+func Test_FD_Failboot_Stderr(t *testing.T) {
+	cmd := exec.Command("sh", "-c", "echo boot error >&2; exit 1")
+
+	w, err := NewFDFactory().SpawnWorker(cmd)
+	assert.Nil(t, w)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "worker start failed: boot error")
+}
+
+func Test_FD_Pool(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "pid", "fd") },
+		NewFDFactory(),
+		Config{
+			NumWorkers:      2,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	for i := 0; i < 10; i++ {
+		res, err := p.Exec(&Payload{Body: []byte("hello")})
+		assert.NoError(t, err)
+		assert.NotEmpty(t, res.String())
+	}
+}
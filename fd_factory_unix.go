@@ -0,0 +1,26 @@
+// +build !windows
+
+package roadrunner
+
+import (
+	"os"
+	"syscall"
+)
+
+// socketPair creates pair of connected unix sockets, descriptors are not inherited by the child
+// processes unless passed explicitly.
+func socketPair() (local, remote *os.File, err error) {
+	syscall.ForkLock.RLock()
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err == nil {
+		syscall.CloseOnExec(fds[0])
+		syscall.CloseOnExec(fds[1])
+	}
+	syscall.ForkLock.RUnlock()
+
+	if err != nil {
+		return nil, nil, os.NewSyscallError("socketpair", err)
+	}
+
+	return os.NewFile(uintptr(fds[0]), "relay"), os.NewFile(uintptr(fds[1]), "worker-relay"), nil
+}
@@ -0,0 +1,13 @@
+// +build windows
+
+package roadrunner
+
+import (
+	"fmt"
+	"os"
+)
+
+// socketPair is not supported on Windows.
+func socketPair() (local, remote *os.File, err error) {
+	return nil, nil, fmt.Errorf("inherited relay sockets are not supported on windows")
+}
@@ -0,0 +1,189 @@
+package roadrunner
+
+import (
+	"github.com/pkg/errors"
+	"github.com/spiral/goridge/v2"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// frameReader wraps relay transport and inspects goridge frame prefixes, frames declaring
+// payload larger than the limit are rejected before the payload is read.
+type frameReader struct {
+	r io.Reader
+
+	// max allowed frame payload size in bytes, accessed atomically, 0 for unlimited
+	max int64
+
+	// payload bytes left to read in the current frame
+	left uint64
+
+	// prefix bytes which are not consumed by the relay yet
+	prefix []byte
+}
+
+// newFrameReader creates unlimited frame reader over given transport.
+func newFrameReader(r io.Reader) *frameReader {
+	return &frameReader{r: r}
+}
+
+// setLimit changes max frame payload size, 0 for unlimited.
+func (fr *frameReader) setLimit(size int64) {
+	atomic.StoreInt64(&fr.max, size)
+}
+
+// Read reads frame prefix or payload from the underlying transport. Relay stream can not
+// be used once size error is returned.
+func (fr *frameReader) Read(b []byte) (int, error) {
+	if len(fr.prefix) == 0 && fr.left == 0 {
+		var p goridge.Prefix
+		if _, err := io.ReadFull(fr.r, p[:]); err != nil {
+			return 0, err
+		}
+
+		if p.Valid() {
+			max := atomic.LoadInt64(&fr.max)
+			if max != 0 && p.Size() > uint64(max) {
+				return 0, errors.Wrapf(ErrPayloadTooLarge, "frame of %v bytes exceeds %v bytes", p.Size(), max)
+			}
+
+			fr.left = p.Size()
+		}
+
+		fr.prefix = p[:]
+	}
+
+	if len(fr.prefix) != 0 {
+		n := copy(b, fr.prefix)
+		fr.prefix = fr.prefix[n:]
+		return n, nil
+	}
+
+	if uint64(len(b)) > fr.left {
+		b = b[:fr.left]
+	}
+
+	n, err := fr.r.Read(b)
+	fr.left -= uint64(n)
+
+	return n, err
+}
+
+// frameConn inspects frames read from the socket connection and applies idle deadline
+// to every read and write operation.
+type frameConn struct {
+	net.Conn
+	frames *frameReader
+
+	// max duration of read or write operation without any data transferred, accessed
+	// atomically, 0 to disable
+	idle int64
+
+	// compresses the data once negotiated during the handshake, nil for uncompressed connection
+	z *deflateStream
+
+	// invoked once connection is closed, nil to disable
+	onClose   func()
+	closeOnce sync.Once
+}
+
+// newFrameConn wraps connection with unlimited frame reader.
+func newFrameConn(conn net.Conn) *frameConn {
+	c := &frameConn{Conn: conn}
+	c.frames = newFrameReader(readerFunc(c.read))
+
+	return c
+}
+
+// setIdleTimeout changes idle deadline of read and write operations, 0 to disable.
+func (c *frameConn) setIdleTimeout(d time.Duration) {
+	atomic.StoreInt64(&c.idle, int64(d))
+}
+
+// Read reads frame data from the connection.
+func (c *frameConn) Read(b []byte) (int, error) {
+	return c.frames.Read(b)
+}
+
+// Close closes the connection, close hook is invoked once no matter how many times connection
+// is closed.
+func (c *frameConn) Close() error {
+	err := c.Conn.Close()
+	if c.onClose != nil {
+		c.closeOnce.Do(c.onClose)
+	}
+
+	return err
+}
+
+// compress switches connection to the deflate stream in both directions, must be called
+// before the connection is used by more than one goroutine.
+func (c *frameConn) compress() {
+	c.z = newDeflateStream(readerFunc(c.readConn), writerFunc(c.writeConn))
+}
+
+// compressed returns true once connection has been switched to the deflate stream.
+func (c *frameConn) compressed() bool {
+	return c.z != nil
+}
+
+// Write writes data to the connection, write fails if peer does not accept any data within
+// the idle timeout.
+func (c *frameConn) Write(b []byte) (int, error) {
+	if c.z != nil {
+		return c.z.Write(b)
+	}
+
+	return c.writeConn(b)
+}
+
+// writeConn writes to the connection, write fails if peer does not accept any data within the
+// idle timeout.
+func (c *frameConn) writeConn(b []byte) (int, error) {
+	if d := time.Duration(atomic.LoadInt64(&c.idle)); d != 0 {
+		if err := c.Conn.SetWriteDeadline(time.Now().Add(d)); err != nil {
+			return 0, err
+		}
+	}
+
+	return c.Conn.Write(b)
+}
+
+// read reads decompressed data when connection is compressed, raw data otherwise.
+func (c *frameConn) read(b []byte) (int, error) {
+	if c.z != nil {
+		return c.z.Read(b)
+	}
+
+	return c.readConn(b)
+}
+
+// readConn reads from the connection, read fails if no data received within the idle timeout.
+func (c *frameConn) readConn(b []byte) (int, error) {
+	if d := time.Duration(atomic.LoadInt64(&c.idle)); d != 0 {
+		if err := c.Conn.SetReadDeadline(time.Now().Add(d)); err != nil {
+			return 0, err
+		}
+	}
+
+	return c.Conn.Read(b)
+}
+
+// readerFunc implements io.Reader using given function.
+type readerFunc func(b []byte) (int, error)
+
+// Read calls the function.
+func (f readerFunc) Read(b []byte) (int, error) {
+	return f(b)
+}
+
+// writerFunc implements io.Writer using given function.
+type writerFunc func(b []byte) (int, error)
+
+// Write calls the function.
+func (f writerFunc) Write(b []byte) (int, error) {
+	return f(b)
+}
@@ -0,0 +1,118 @@
+package roadrunner
+
+import (
+	"bytes"
+	"github.com/pkg/errors"
+	"github.com/spiral/goridge/v2"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+// frames encodes given frames into goridge stream.
+func frames(data ...[]byte) *bytes.Buffer {
+	buf := &bytes.Buffer{}
+	for _, d := range data {
+		flags := byte(goridge.PayloadRaw)
+		if len(d) == 0 {
+			flags |= goridge.PayloadEmpty
+		}
+
+		p := goridge.NewPrefix().WithFlags(flags).WithSize(uint64(len(d)))
+		buf.Write(p[:])
+		buf.Write(d)
+	}
+
+	return buf
+}
+
+func Test_FrameReader(t *testing.T) {
+	fr := newFrameReader(frames([]byte("hello"), nil, []byte("world")))
+	rl := goridge.NewPipeRelay(ioutil.NopCloser(fr), nil)
+
+	data, _, err := rl.Receive()
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	data, p, err := rl.Receive()
+	assert.NoError(t, err)
+	assert.Nil(t, data)
+	assert.True(t, p.HasFlag(goridge.PayloadEmpty))
+
+	data, _, err = rl.Receive()
+	assert.NoError(t, err)
+	assert.Equal(t, "world", string(data))
+}
+
+func Test_FrameReader_Limit(t *testing.T) {
+	fr := newFrameReader(frames([]byte("hi"), []byte("hello")))
+	fr.setLimit(3)
+
+	rl := goridge.NewPipeRelay(ioutil.NopCloser(fr), nil)
+
+	data, _, err := rl.Receive()
+	assert.NoError(t, err)
+	assert.Equal(t, "hi", string(data))
+
+	data, _, err = rl.Receive()
+	assert.Nil(t, data)
+	assert.Equal(t, ErrPayloadTooLarge, errors.Cause(err))
+}
+
+func Test_FrameReader_PartialRead(t *testing.T) {
+	fr := newFrameReader(frames([]byte("hello")))
+
+	buf := make([]byte, 4)
+	n, err := fr.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, n)
+
+	out, err := ioutil.ReadAll(fr)
+	assert.NoError(t, err)
+	assert.Len(t, out, 13+5)
+	assert.Equal(t, "hello", string(out[13:]))
+}
+
+func Test_CheckPayload(t *testing.T) {
+	assert.NoError(t, checkPayload(&Payload{Body: []byte("hello")}, 0))
+	assert.NoError(t, checkPayload(&Payload{Body: []byte("hello")}, 5))
+	assert.NoError(t, checkPayload(nil, 5))
+
+	assert.Equal(t, ErrPayloadTooLarge, checkPayload(&Payload{Body: []byte("hello")}, 4))
+	assert.Equal(t, ErrPayloadTooLarge, checkPayload(&Payload{Context: []byte("hello")}, 4))
+}
+
+func Test_FrameConn_IdleTimeout(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	fc := newFrameConn(c1)
+	fc.setIdleTimeout(time.Millisecond * 50)
+
+	go func() {
+		p := goridge.NewPrefix().WithFlags(goridge.PayloadRaw).WithSize(2)
+		_, _ = c2.Write(p[:])
+		_, _ = c2.Write([]byte("h"))
+
+		// data keeps flowing within the idle timeout
+		time.Sleep(time.Millisecond * 30)
+		_, _ = c2.Write([]byte("i"))
+	}()
+
+	rl := goridge.NewSocketRelay(fc)
+
+	data, _, err := rl.Receive()
+	assert.NoError(t, err)
+	assert.Equal(t, "hi", string(data))
+
+	_, _, err = rl.Receive()
+	assert.Error(t, err)
+	assert.True(t, err.(net.Error).Timeout())
+
+	_, err = fc.Write([]byte("hello"))
+	assert.Error(t, err)
+	assert.True(t, err.(net.Error).Timeout())
+}
@@ -0,0 +1,30 @@
+package roadrunner
+
+import (
+	"fmt"
+	"time"
+)
+
+// FreshWorkerIndex is the worker slot index passed to the workers spawned by ExecFresh.
+const FreshWorkerIndex = -1
+
+// execFresh executes single task on the dedicated worker, worker is killed once timeout is
+// reached (0 for no timeout). Worker requesting termination fails the task, there is no other
+// worker to replay it on.
+func execFresh(w *Worker, rqs *Payload, timeout time.Duration) (rsp *Payload, err error) {
+	if timeout != 0 {
+		rsp, err = w.ExecWithTimeout(rqs, timeout)
+	} else {
+		rsp, err = w.Exec(rqs)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if rsp.Body == nil && rsp.Context != nil && string(rsp.Context) == StopRequest {
+		return nil, fmt.Errorf("worker has requested termination instead of executing the task")
+	}
+
+	return rsp, nil
+}
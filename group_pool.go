@@ -0,0 +1,361 @@
+package roadrunner
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+var _ ManagedPool = (*GroupPool)(nil)
+
+// WorkerGroup describes the group of the workers running the same command within GroupPool.
+type WorkerGroup struct {
+	// Name identifies the group in the dispatch and the statistics.
+	Name string
+
+	// Command spawns the workers of the group.
+	Command func() *exec.Cmd
+
+	// Config of the group, NumWorkers defines size of the group.
+	Config Config
+}
+
+// DispatchFunc returns name of the group which executes the task of the given tag.
+type DispatchFunc func(tag string) string
+
+// GroupPool manages several groups of the workers, for example CPU heavy and IO heavy workers
+// running different commands, as one logical pool. Tagged tasks (see ExecTagged) are dispatched
+// to the group picked by the dispatch function, tasks executed without the tag are executed by the
+// first group. Every group is the pool on its own, size, limits and queue are not shared.
+type GroupPool struct {
+	// group names in the declaration order
+	names []string
+
+	// pools of the groups by name
+	groups map[string]ManagedPool
+
+	// pools of the groups in the declaration order
+	set poolSet
+
+	// picks the group of the tagged task
+	dispatch DispatchFunc
+
+	// pools of the allocated workers
+	allocated sync.Map
+
+	// worker events of all groups
+	events mergedEvents
+}
+
+// NewGroupPool creates group pool spawning workers of every group using the shared factory,
+// dispatch picks the group of the tagged task, tag is the group name when dispatch is nil. Group
+// pool owns the group pools, factory must be closed by the caller once pool is destroyed.
+func NewGroupPool(factory Factory, groups []WorkerGroup, dispatch DispatchFunc) (*GroupPool, error) {
+	names := make([]string, len(groups))
+	for i, g := range groups {
+		names[i] = g.Name
+	}
+
+	if err := checkGroups(names); err != nil {
+		return nil, err
+	}
+
+	pools := make([]ManagedPool, 0, len(groups))
+	for _, g := range groups {
+		pool, err := NewPool(g.Command, factory, g.Config)
+		if err != nil {
+			for _, p := range pools {
+				p.Destroy()
+			}
+
+			return nil, errors.Wrapf(err, "group `%s`", g.Name)
+		}
+
+		pools = append(pools, pool)
+	}
+
+	return newGroupPool(names, pools, dispatch)
+}
+
+// newGroupPool creates group pool of the given pools, names and pools are matched by index.
+func newGroupPool(names []string, pools []ManagedPool, dispatch DispatchFunc) (*GroupPool, error) {
+	if err := checkGroups(names); err != nil {
+		return nil, err
+	}
+
+	if dispatch == nil {
+		dispatch = func(tag string) string { return tag }
+	}
+
+	p := &GroupPool{names: names, groups: make(map[string]ManagedPool, len(pools)), dispatch: dispatch}
+	labels := make([]string, len(names))
+	for i, name := range names {
+		p.groups[name] = pools[i]
+		labels[i] = fmt.Sprintf("group `%s`", name)
+	}
+	p.set = newPoolSet(labels, pools...)
+
+	return p, nil
+}
+
+// checkGroups verifies that at least one group is given and group names are unique.
+func checkGroups(names []string) error {
+	if len(names) == 0 {
+		return fmt.Errorf("group pool requires at least one group")
+	}
+
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		if name == "" || seen[name] {
+			return fmt.Errorf("group name `%s` is empty or duplicate", name)
+		}
+
+		seen[name] = true
+	}
+
+	return nil
+}
+
+// Group returns pool of the group with the given name, nil for unknown group.
+func (p *GroupPool) Group(name string) ManagedPool {
+	return p.groups[name]
+}
+
+// Groups returns names of the groups in the declaration order.
+func (p *GroupPool) Groups() []string {
+	return append([]string(nil), p.names...)
+}
+
+// ExecTagged executes the task by the group picked by the dispatch function for the tag, error is
+// returned when dispatch picks unknown group.
+func (p *GroupPool) ExecTagged(tag string, rqs *Payload) (rsp *Payload, err error) {
+	name := p.dispatch(tag)
+
+	pool, ok := p.groups[name]
+	if !ok {
+		return nil, fmt.Errorf("undefined group `%s` for tag `%s`", name, tag)
+	}
+
+	return pool.Exec(rqs)
+}
+
+// Listen attaches event controller to all groups.
+func (p *GroupPool) Listen(l func(event int, ctx interface{})) {
+	p.set.listen(l)
+}
+
+// Events returns channel receiving state transitions of the workers of all groups, channel is
+// closed by StopEvents or once all groups are destroyed.
+func (p *GroupPool) Events() <-chan WorkerEvent {
+	return p.events.subscribe(p.set.pools...)
+}
+
+// StopEvents closes the channel returned by Events.
+func (p *GroupPool) StopEvents(c <-chan WorkerEvent) {
+	p.events.unsubscribe(c, p.set.pools...)
+}
+
+// Exec executes the task by the first group.
+func (p *GroupPool) Exec(rqs *Payload) (rsp *Payload, err error) {
+	return p.first().Exec(rqs)
+}
+
+// ExecContext executes the task by the first group until context is done, context error is
+// returned for the canceled task.
+func (p *GroupPool) ExecContext(ctx context.Context, rqs *Payload) (rsp *Payload, err error) {
+	return p.first().ExecContext(ctx, rqs)
+}
+
+// ExecWithMeta executes the task like Exec and describes the worker which executed it.
+func (p *GroupPool) ExecWithMeta(rqs *Payload) (rsp *Payload, meta ExecMeta, err error) {
+	return p.first().ExecWithMeta(rqs)
+}
+
+// ExecBatch executes tasks on a single worker of the first group.
+func (p *GroupPool) ExecBatch(rqs []*Payload) (rsp []*Payload, errs []error) {
+	return p.first().ExecBatch(rqs)
+}
+
+// ExecPriority executes the task of the given priority by the first group.
+func (p *GroupPool) ExecPriority(rqs *Payload, priority int) (rsp *Payload, err error) {
+	return p.first().ExecPriority(rqs, priority)
+}
+
+// ExecSticky executes the task by the first group preferring the worker which executed previous
+// tasks of the same key.
+func (p *GroupPool) ExecSticky(key string, rqs *Payload) (rsp *Payload, err error) {
+	return p.first().ExecSticky(key, rqs)
+}
+
+// ExecFresh executes the task on the worker of the first group spawned for this task only.
+func (p *GroupPool) ExecFresh(rqs *Payload) (rsp *Payload, err error) {
+	return p.first().ExecFresh(rqs)
+}
+
+// ExecAsync executes the task without waiting for the result on the worker of the first group.
+func (p *GroupPool) ExecAsync(rqs *Payload) error {
+	return p.first().ExecAsync(rqs)
+}
+
+// Use attaches middleware to all groups.
+func (p *GroupPool) Use(m Middleware) {
+	p.set.use(m)
+}
+
+// SetFallback attaches fallback to all groups, task is served by the fallback of the group
+// it's dispatched to.
+func (p *GroupPool) SetFallback(f FallbackFunc) {
+	p.set.setFallback(f)
+}
+
+// RegisterHandler registers handler in all groups.
+func (p *GroupPool) RegisterHandler(name string, fn func([]byte) ([]byte, error)) {
+	p.set.registerHandler(name, fn)
+}
+
+// TryExec executes the task by the first group only if its free worker is immediately available.
+func (p *GroupPool) TryExec(rqs *Payload) (rsp *Payload, acquired bool, err error) {
+	return p.first().TryExec(rqs)
+}
+
+// Workers returns workers of all groups in the declaration order of the groups.
+func (p *GroupPool) Workers() (workers []*Worker) {
+	return p.set.workers()
+}
+
+// Remove forces group owning the worker to remove it, false is returned for unknown worker.
+func (p *GroupPool) Remove(w *Worker, err error) bool {
+	return p.set.remove(w, err)
+}
+
+// recycleIdle recycles idle worker of the owning group.
+func (p *GroupPool) recycleIdle(w *Worker, reason RecycleReason, err error) bool {
+	return p.set.recycleIdle(w, reason, err)
+}
+
+// Allocate checks out idle worker of the first group, use Group to allocate the worker of the
+// other group. Worker must be returned using Release.
+func (p *GroupPool) Allocate(ctx context.Context) (*Worker, error) {
+	pool := p.first()
+
+	w, err := pool.Allocate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.allocated.Store(w, pool)
+	return w, nil
+}
+
+// Release returns allocated worker to the group it has been allocated from.
+func (p *GroupPool) Release(w *Worker, broken bool) {
+	pool, ok := p.allocated.Load(w)
+	if !ok {
+		return
+	}
+
+	p.allocated.Delete(w)
+	pool.(ManagedPool).Release(w, broken)
+}
+
+// Detach takes idle worker with the given PID out of the group owning it.
+func (p *GroupPool) Detach(pid int) (*Worker, error) {
+	return p.set.detach(pid)
+}
+
+// SetCommand replaces the command used to spawn new workers of all groups, use SetCommand of
+// the Group to replace the command of the single group.
+func (p *GroupPool) SetCommand(cmd func(cfg WorkerConfig) *exec.Cmd) {
+	p.set.setCommand(cmd)
+}
+
+// ReloadWorker replaces the oldest worker of each group.
+func (p *GroupPool) ReloadWorker() error {
+	return p.set.reloadWorker()
+}
+
+// ReloadAll replaces workers of the groups one group after another.
+func (p *GroupPool) ReloadAll(pause time.Duration) error {
+	return p.set.reloadAll(pause)
+}
+
+// OnWorkerDeath attaches callback invoked when worker of any group dies unexpectedly.
+func (p *GroupPool) OnWorkerDeath(f func(pid int, err error)) {
+	p.set.onWorkerDeath(f)
+}
+
+// OnWorkerReady attaches hook invoked for every new worker of any group.
+func (p *GroupPool) OnWorkerReady(f func(w *Worker) error) {
+	p.set.onWorkerReady(f)
+}
+
+// OnWorkerDestroy attaches hook invoked once worker process of any group exits.
+func (p *GroupPool) OnWorkerDestroy(f func(w *Worker)) {
+	p.set.onWorkerDestroy(f)
+}
+
+// Stats returns sum of the group statistics, see StatsByGroup for the breakdown. Breaker
+// contains the most restrictive breaker state, pool is paused once all groups are paused.
+func (p *GroupPool) Stats() PoolStats {
+	return p.set.stats()
+}
+
+// StatsByGroup returns statistics of every group by the group name.
+func (p *GroupPool) StatsByGroup() map[string]PoolStats {
+	stats := make(map[string]PoolStats, len(p.groups))
+	for name, pool := range p.groups {
+		stats[name] = pool.Stats()
+	}
+
+	return stats
+}
+
+// Dump returns snapshots of all workers of all groups.
+func (p *GroupPool) Dump() (snapshots []WorkerSnapshot) {
+	return p.set.dump()
+}
+
+// MarshalState returns binary encoding of the pool stats and worker snapshots of all groups, see
+// UnmarshalState.
+func (p *GroupPool) MarshalState() ([]byte, error) {
+	return marshalState(p.Stats(), p.Dump())
+}
+
+// Healthy verifies that all groups are healthy.
+func (p *GroupPool) Healthy() (bool, error) {
+	return p.set.healthy()
+}
+
+// WaitReady waits until all groups are ready.
+func (p *GroupPool) WaitReady(ctx context.Context) error {
+	return p.set.waitReady(ctx)
+}
+
+// Pause stops dispatching of new tasks in all groups.
+func (p *GroupPool) Pause() {
+	p.set.pause()
+}
+
+// Resume restarts dispatching of the tasks in all groups.
+func (p *GroupPool) Resume() {
+	p.set.resume()
+}
+
+// Drain drains all groups sharing the context, report contains sum of the group reports. All
+// groups are paused before drain to keep tasks from being dispatched to the group still running.
+func (p *GroupPool) Drain(ctx context.Context) (DrainReport, error) {
+	return p.set.drain(ctx)
+}
+
+// Destroy all groups.
+func (p *GroupPool) Destroy() {
+	p.set.destroy()
+}
+
+// first returns pool of the first group, it executes the tasks without the tag.
+func (p *GroupPool) first() ManagedPool {
+	return p.set.pools[0]
+}
@@ -0,0 +1,153 @@
+package roadrunner
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os/exec"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func Test_GroupPool_ExecTagged(t *testing.T) {
+	p, err := newGroupPool(
+		[]string{"io", "cpu"},
+		[]ManagedPool{&stubPool{name: "io"}, &stubPool{name: "cpu"}},
+		func(tag string) string {
+			if tag == "resize" || tag == "encode" {
+				return "cpu"
+			}
+
+			return "io"
+		},
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"io", "cpu"}, p.Groups())
+
+	for tag, expected := range map[string]string{"resize": "cpu", "encode": "cpu", "fetch": "io", "": "io"} {
+		res, err := p.ExecTagged(tag, &Payload{Body: []byte("hello")})
+		assert.NoError(t, err)
+		assert.Equal(t, expected, res.String(), tag)
+	}
+
+	// untagged tasks are executed by the first group
+	res, err := p.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, "io", res.String())
+}
+
+func Test_GroupPool_UndefinedGroup(t *testing.T) {
+	p, err := newGroupPool([]string{"io"}, []ManagedPool{&stubPool{name: "io"}}, nil)
+	assert.NoError(t, err)
+
+	res, err := p.ExecTagged("io", &Payload{})
+	assert.NoError(t, err)
+	assert.Equal(t, "io", res.String())
+
+	_, err = p.ExecTagged("cpu", &Payload{})
+	assert.Error(t, err)
+	assert.Equal(t, "undefined group `cpu` for tag `cpu`", err.Error())
+	assert.Nil(t, p.Group("cpu"))
+}
+
+func Test_GroupPool_InvalidGroups(t *testing.T) {
+	_, err := newGroupPool(nil, nil, nil)
+	assert.Error(t, err)
+
+	_, err = newGroupPool([]string{"io", "io"}, []ManagedPool{&stubPool{}, &stubPool{}}, nil)
+	assert.Error(t, err)
+
+	_, err = NewGroupPool(NewPipeFactory(), []WorkerGroup{{Name: ""}}, nil)
+	assert.Error(t, err)
+}
+
+func Test_GroupPool_Stats(t *testing.T) {
+	io := &stubPool{stats: PoolStats{NumWorkers: 4, TotalExecs: 10, RecycleReasons: map[RecycleReason]int64{RecycleIdle: 1}}}
+	cpu := &stubPool{stats: PoolStats{NumWorkers: 2, TotalExecs: 5, RecycleReasons: map[RecycleReason]int64{RecycleIdle: 2}}}
+	gc := &stubPool{stats: PoolStats{NumWorkers: 1, Breaker: BreakerOpen}}
+
+	p, err := newGroupPool([]string{"io", "cpu", "gc"}, []ManagedPool{io, cpu, gc}, nil)
+	assert.NoError(t, err)
+
+	stats := p.Stats()
+	assert.Equal(t, 7, stats.NumWorkers)
+	assert.Equal(t, int64(15), stats.TotalExecs)
+	assert.Equal(t, int64(3), stats.RecycleReasons[RecycleIdle])
+	assert.Equal(t, BreakerOpen, stats.Breaker)
+
+	byGroup := p.StatsByGroup()
+	assert.Len(t, byGroup, 3)
+	assert.Equal(t, io.stats, byGroup["io"])
+	assert.Equal(t, cpu.stats, byGroup["cpu"])
+}
+
+func Test_GroupPool_Healthy(t *testing.T) {
+	io, cpu := &stubPool{}, &stubPool{}
+	p, err := newGroupPool([]string{"io", "cpu"}, []ManagedPool{io, cpu}, nil)
+	assert.NoError(t, err)
+
+	ok, err := p.Healthy()
+	assert.True(t, ok)
+	assert.NoError(t, err)
+
+	cpu.healthy = fmt.Errorf("only 0/1 workers ready")
+	ok, err = p.Healthy()
+	assert.False(t, ok)
+	assert.Equal(t, "group `cpu`: only 0/1 workers ready", err.Error())
+
+	// group reported no reason
+	cpu.healthy, cpu.unhealthy = nil, true
+	ok, err = p.Healthy()
+	assert.False(t, ok)
+	if assert.Error(t, err) {
+		assert.Equal(t, "group `cpu` is not healthy", err.Error())
+	}
+}
+
+func Test_NewGroupPool(t *testing.T) {
+	cfg := Config{
+		NumWorkers:      1,
+		AllocateTimeout: time.Second,
+		DestroyTimeout:  time.Second,
+	}
+
+	p, err := NewGroupPool(
+		NewPipeFactory(),
+		[]WorkerGroup{
+			{
+				Name:    "echo",
+				Command: func() *exec.Cmd { return exec.Command("php", "tests/client.php", "echo", "pipes") },
+				Config:  cfg,
+			},
+			{
+				Name:    "pid",
+				Command: func() *exec.Cmd { return exec.Command("php", "tests/client.php", "pid", "pipes") },
+				Config:  Config{NumWorkers: 2, AllocateTimeout: time.Second, DestroyTimeout: time.Second},
+			},
+		},
+		nil,
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	assert.Len(t, p.Workers(), 3)
+	assert.Len(t, p.Group("pid").Workers(), 2)
+
+	res, err := p.ExecTagged("echo", &Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", res.String())
+
+	res, err = p.ExecTagged("pid", &Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+
+	pids := []string{}
+	for _, w := range p.Group("pid").Workers() {
+		pids = append(pids, strconv.Itoa(*w.Pid))
+	}
+	assert.Contains(t, pids, res.String())
+
+	stats := p.StatsByGroup()
+	assert.Equal(t, int64(1), stats["echo"].TotalExecs)
+	assert.Equal(t, int64(1), stats["pid"].TotalExecs)
+	assert.Equal(t, 3, p.Stats().NumWorkers)
+}
@@ -0,0 +1,67 @@
+package roadrunner
+
+import (
+	"github.com/pkg/errors"
+	"sync"
+)
+
+// workerHooks holds pool callbacks invoked synchronously in the worker spawn path and once
+// worker process exits.
+type workerHooks struct {
+	mu sync.Mutex
+
+	// invoked once worker is ready, worker fails to start when error is returned
+	ready func(w *Worker) error
+
+	// invoked once worker process exits, workers failed ready hook are skipped
+	destroy func(w *Worker)
+}
+
+// setReady attaches hook invoked for every new worker.
+func (h *workerHooks) setReady(f func(w *Worker) error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.ready = f
+}
+
+// setDestroy attaches hook invoked for every exited worker.
+func (h *workerHooks) setDestroy(f func(w *Worker)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.destroy = f
+}
+
+// prepare runs ready hook on the spawned worker, worker is killed if hook fails. Destroy hook is
+// invoked once process of the prepared worker exits.
+func (h *workerHooks) prepare(w *Worker) error {
+	h.mu.Lock()
+	ready := h.ready
+	h.mu.Unlock()
+
+	if ready != nil {
+		if err := ready(w); err != nil {
+			_ = w.Kill()
+			_ = w.Wait()
+
+			return errors.Wrap(err, "worker ready hook")
+		}
+	}
+
+	go h.watch(w)
+	return nil
+}
+
+// watch waits for the process exit and invokes destroy hook.
+func (h *workerHooks) watch(w *Worker) {
+	<-w.waitDone
+
+	h.mu.Lock()
+	destroy := h.destroy
+	h.mu.Unlock()
+
+	if destroy != nil {
+		destroy(w)
+	}
+}
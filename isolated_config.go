@@ -0,0 +1,66 @@
+package roadrunner
+
+import (
+	"fmt"
+	"time"
+)
+
+// IsolatedConfig defines behaviour of the pool which executes every task on its own worker.
+type IsolatedConfig struct {
+	// StandbyCount defines how many fresh workers are spawned ahead and wait idle for the next
+	// tasks to hide the spawn latency, used workers are replaced in background. Set 0 to spawn
+	// the worker once task arrives.
+	StandbyCount int64
+
+	// ExecTimeout defines maximum duration of the task execution, worker is killed and
+	// task fails once timeout is reached. Set 0 to disable.
+	ExecTimeout time.Duration
+
+	// MaxPayloadSize limits size of task context and body in bytes, larger tasks are rejected
+	// with ErrPayloadTooLarge. Worker responding with larger frame is killed. Set 0 for unlimited.
+	MaxPayloadSize int64
+
+	// CommandCheckArgs defines arguments worker executable is run with once when pool is created,
+	// see Config.CommandCheckArgs.
+	CommandCheckArgs []string
+
+	// SkipCommandCheck disables verification of the worker executable on pool creation.
+	SkipCommandCheck bool
+
+	// WorkerIDPrefix prefixes the logical worker IDs (see Worker.ID), use it to tell workers of
+	// multiple pools apart. Defaults to DefaultWorkerIDPrefix.
+	WorkerIDPrefix string
+
+	// DestroyTimeout defines for how long pool should be waiting for worker to
+	// properly stop, if timeout reached worker will be killed.
+	DestroyTimeout time.Duration
+}
+
+// InitDefaults allows to init blank config with pre-defined set of default values.
+func (cfg *IsolatedConfig) InitDefaults() error {
+	cfg.StandbyCount = 1
+	cfg.DestroyTimeout = time.Minute
+
+	return nil
+}
+
+// Valid returns error if config not valid.
+func (cfg *IsolatedConfig) Valid() error {
+	if cfg.StandbyCount < 0 {
+		return fmt.Errorf("pool.StandbyCount must be positive (0 to disable)")
+	}
+
+	if cfg.ExecTimeout < 0 {
+		return fmt.Errorf("pool.ExecTimeout must be positive (0 to disable)")
+	}
+
+	if cfg.MaxPayloadSize < 0 {
+		return fmt.Errorf("pool.MaxPayloadSize must be positive (0 for unlimited)")
+	}
+
+	if cfg.DestroyTimeout == 0 {
+		return fmt.Errorf("pool.DestroyTimeout must be set")
+	}
+
+	return nil
+}
@@ -0,0 +1,28 @@
+package roadrunner
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func Test_IsolatedConfig_Default(t *testing.T) {
+	cfg := IsolatedConfig{}
+
+	assert.NoError(t, cfg.InitDefaults())
+	assert.NoError(t, cfg.Valid())
+}
+
+func Test_IsolatedConfig_Invalid(t *testing.T) {
+	for msg, cfg := range map[string]IsolatedConfig{
+		"pool.StandbyCount must be positive (0 to disable)":      {StandbyCount: -1, DestroyTimeout: time.Second},
+		"pool.ExecTimeout must be positive (0 to disable)":       {ExecTimeout: -time.Second, DestroyTimeout: time.Second},
+		"pool.MaxPayloadSize must be positive (0 for unlimited)": {MaxPayloadSize: -1, DestroyTimeout: time.Second},
+		"pool.DestroyTimeout must be set":                        {StandbyCount: 1},
+	} {
+		err := cfg.Valid()
+
+		assert.NotNil(t, err)
+		assert.Equal(t, msg, err.Error())
+	}
+}
@@ -0,0 +1,373 @@
+package roadrunner
+
+import (
+	"github.com/pkg/errors"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// IsolatedPool executes every task on the fresh worker spawned for this task only, worker is
+// destroyed afterwards and never executes other tasks, no state carries between the tasks. Use it
+// to run untrusted code of multiple tenants. Spawn latency is hidden by the standby workers which
+// are spawned ahead and replaced in background, see IsolatedConfig.StandbyCount.
+type IsolatedPool struct {
+	// pool behaviour
+	cfg IsolatedConfig
+
+	// worker command creator
+	cmd func(cfg WorkerConfig) *exec.Cmd
+
+	// creates and connects to workers
+	factory Factory
+
+	// fresh workers spawned ahead
+	standby chan *Worker
+
+	// wakes up the standby replenishment
+	refill chan interface{}
+
+	// stops the standby replenishment
+	destroy chan interface{}
+
+	// replenishment is done
+	replenished chan interface{}
+
+	// protects task registration from the pool destruction
+	tmu sync.Mutex
+
+	// running tasks
+	tasks sync.WaitGroup
+
+	// used workers being destroyed
+	used sync.WaitGroup
+
+	// set once pool destruction has started
+	inDestroy int32
+
+	// executed tasks and failed tasks
+	numExecs, numErrors int64
+
+	// tasks which found no standby worker and waited for the spawn
+	numCold int64
+
+	// tasks being executed
+	numBusy int64
+
+	// protects lsn and log
+	mul sync.Mutex
+
+	// worker and pool events
+	lsn func(event int, ctx interface{})
+
+	// receives pool messages
+	log Logger
+}
+
+// NewIsolatedPool creates pool executing every task on the fresh worker, cfg.StandbyCount workers
+// are spawned before the pool is returned.
+func NewIsolatedPool(cmd func(cfg WorkerConfig) *exec.Cmd, factory Factory, cfg IsolatedConfig) (*IsolatedPool, error) {
+	if err := cfg.Valid(); err != nil {
+		return nil, errors.Wrap(err, "config")
+	}
+
+	if !cfg.SkipCommandCheck {
+		if err := checkCommand(cmd(newWorkerConfig(FreshWorkerIndex, cfg.WorkerIDPrefix)), cfg.CommandCheckArgs); err != nil {
+			return nil, errors.Wrap(err, "command check")
+		}
+	}
+
+	p := &IsolatedPool{
+		cfg:         cfg,
+		cmd:         cmd,
+		factory:     factory,
+		standby:     make(chan *Worker, cfg.StandbyCount),
+		refill:      make(chan interface{}, 1),
+		destroy:     make(chan interface{}),
+		replenished: make(chan interface{}),
+	}
+
+	for i := int64(0); i < cfg.StandbyCount; i++ {
+		w, err := p.spawnWorker()
+		if err != nil {
+			close(p.replenished)
+			p.Destroy()
+			return nil, err
+		}
+
+		p.standby <- w
+	}
+
+	go p.replenish()
+	return p, nil
+}
+
+// Listen attaches pool event controller.
+func (p *IsolatedPool) Listen(l func(event int, ctx interface{})) {
+	p.mul.Lock()
+	defer p.mul.Unlock()
+
+	p.lsn = l
+}
+
+// SetLogger attaches logger receiving pool messages, nil to disable logging.
+func (p *IsolatedPool) SetLogger(l Logger) {
+	p.mul.Lock()
+	defer p.mul.Unlock()
+
+	p.log = l
+}
+
+// Config returns associated pool configuration. Immutable.
+func (p *IsolatedPool) Config() IsolatedConfig {
+	return p.cfg
+}
+
+// Standby returns number of the standby workers waiting for the tasks.
+func (p *IsolatedPool) Standby() int {
+	return len(p.standby)
+}
+
+// ColdStarts returns number of the tasks which found no standby worker and waited for the spawn,
+// growing number means StandbyCount does not keep up with the load.
+func (p *IsolatedPool) ColdStarts() int64 {
+	return atomic.LoadInt64(&p.numCold)
+}
+
+// Stats returns pool statistics, standby workers are reported as idle and workers executing the
+// tasks as busy.
+func (p *IsolatedPool) Stats() PoolStats {
+	idle, busy := len(p.standby), int(atomic.LoadInt64(&p.numBusy))
+
+	return PoolStats{
+		NumWorkers:  idle + busy,
+		NumIdle:     idle,
+		NumBusy:     busy,
+		TotalExecs:  atomic.LoadInt64(&p.numExecs),
+		TotalErrors: atomic.LoadInt64(&p.numErrors),
+	}
+}
+
+// Exec executes the task on the standby worker or on the worker spawned for the task once none
+// is available, worker is destroyed afterwards. ExecTimeout applies, task is never retried.
+func (p *IsolatedPool) Exec(rqs *Payload) (rsp *Payload, err error) {
+	if p.destroyed() {
+		return nil, ErrPoolDestroyed
+	}
+
+	p.tmu.Lock()
+	if p.destroyed() {
+		p.tmu.Unlock()
+		return nil, ErrPoolDestroyed
+	}
+	p.tasks.Add(1)
+	p.tmu.Unlock()
+
+	defer p.tasks.Done()
+
+	if err := checkPayload(rqs, p.cfg.MaxPayloadSize); err != nil {
+		return nil, err
+	}
+
+	w := p.take()
+	if w == nil {
+		atomic.AddInt64(&p.numCold, 1)
+
+		if w, err = p.spawnWorker(); err != nil {
+			return nil, errors.Wrap(err, "unable to spawn worker")
+		}
+	}
+
+	atomic.AddInt64(&p.numBusy, 1)
+	rsp, err = execFresh(w, rqs, p.cfg.ExecTimeout)
+	atomic.AddInt64(&p.numBusy, -1)
+
+	atomic.AddInt64(&p.numExecs, 1)
+	if err != nil {
+		atomic.AddInt64(&p.numErrors, 1)
+	}
+
+	// response is not delayed by the worker shutdown
+	p.used.Add(1)
+	go func(err error) {
+		defer p.used.Done()
+
+		if w.State().Value() == StateReady {
+			p.destroyWorker(w, err)
+		} else if kerr := w.Kill(); kerr != nil {
+			p.throw(EventWorkerError, WorkerError{Worker: w, Caused: kerr})
+		}
+	}(err)
+
+	return rsp, err
+}
+
+// Destroy waits for the running tasks and destroys the standby workers, returns once used workers
+// are destroyed as well.
+func (p *IsolatedPool) Destroy() {
+	if !atomic.CompareAndSwapInt32(&p.inDestroy, 0, 1) {
+		return
+	}
+
+	p.tmu.Lock()
+	p.tasks.Wait()
+	close(p.destroy)
+	p.tmu.Unlock()
+
+	<-p.replenished
+	close(p.standby)
+
+	var wg sync.WaitGroup
+	for w := range p.standby {
+		wg.Add(1)
+		w.markInvalid()
+		go func(w *Worker) {
+			defer wg.Done()
+			p.destroyWorker(w, nil)
+		}(w)
+	}
+
+	wg.Wait()
+	p.used.Wait()
+}
+
+// take returns the standby worker and requests its replacement, nil once no standby worker is
+// available. Standby workers which died while waiting are dropped.
+func (p *IsolatedPool) take() *Worker {
+	for {
+		select {
+		case w := <-p.standby:
+			select {
+			case p.refill <- nil:
+			default:
+			}
+
+			select {
+			case <-w.waitDone:
+				p.logger().Warn("standby worker died", "pid", *w.Pid, "error", w.waitError())
+				p.throw(EventWorkerError, WorkerError{Worker: w, Caused: w.waitError()})
+				continue
+			default:
+			}
+
+			if w.State().Value() != StateReady {
+				go p.destroyWorker(w, nil)
+				continue
+			}
+
+			return w
+		default:
+			return nil
+		}
+	}
+}
+
+// replenish spawns the standby workers in place of the taken ones until pool is destroyed.
+// Failed spawns are retried with growing delay.
+func (p *IsolatedPool) replenish() {
+	defer close(p.replenished)
+
+	for {
+		select {
+		case <-p.refill:
+		case <-p.destroy:
+			return
+		}
+
+		// the only sender, buffer never blocks while below its capacity
+		for attempt := int64(0); len(p.standby) < cap(p.standby); {
+			if p.destroyed() {
+				return
+			}
+
+			w, err := p.spawnWorker()
+			if err != nil {
+				p.throw(EventPoolError, errors.Wrap(err, "unable to spawn standby worker"))
+
+				select {
+				case <-time.After(retryDelay(attempt)):
+				case <-p.destroy:
+					return
+				}
+
+				attempt++
+				continue
+			}
+
+			attempt = 0
+			p.standby <- w
+		}
+	}
+}
+
+// spawnWorker creates new fresh worker.
+func (p *IsolatedPool) spawnWorker() (*Worker, error) {
+	wc := newWorkerConfig(FreshWorkerIndex, p.cfg.WorkerIDPrefix)
+
+	w, err := p.factory.SpawnWorker(p.cmd(wc))
+	if err != nil {
+		return nil, err
+	}
+
+	w.ID = wc.ID
+	w.SetMaxPayloadSize(p.cfg.MaxPayloadSize)
+
+	p.mul.Lock()
+	if p.lsn != nil {
+		w.err.Listen(p.lsn)
+	}
+	p.mul.Unlock()
+
+	p.throw(EventWorkerConstruct, w)
+	return w, nil
+}
+
+// destroyWorker stops the worker, worker is killed once it did not stop within DestroyTimeout.
+func (p *IsolatedPool) destroyWorker(w *Worker, caused interface{}) {
+	go func() {
+		err := w.Stop()
+		if err != nil {
+			p.throw(EventWorkerError, WorkerError{Worker: w, Caused: err})
+		}
+	}()
+
+	if exited, _ := w.WaitTimeout(p.cfg.DestroyTimeout); exited {
+		// worker is dead
+		p.throw(EventWorkerDestruct, w)
+		return
+	}
+
+	// failed to stop process in given time
+	p.logger().Warn("worker killed after destroy timeout", "pid", *w.Pid, "timeout", p.cfg.DestroyTimeout)
+
+	if err := w.Kill(); err != nil {
+		p.throw(EventWorkerError, WorkerError{Worker: w, Caused: err})
+	}
+
+	p.throw(EventWorkerKill, w)
+}
+
+func (p *IsolatedPool) logger() Logger {
+	p.mul.Lock()
+	defer p.mul.Unlock()
+
+	if p.log == nil {
+		return nopLogger{}
+	}
+
+	return p.log
+}
+
+func (p *IsolatedPool) destroyed() bool {
+	return atomic.LoadInt32(&p.inDestroy) != 0
+}
+
+// throw invokes event handler if any.
+func (p *IsolatedPool) throw(event int, ctx interface{}) {
+	p.mul.Lock()
+	if p.lsn != nil {
+		p.lsn(event, ctx)
+	}
+	p.mul.Unlock()
+}
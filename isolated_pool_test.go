@@ -0,0 +1,221 @@
+package roadrunner
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os/exec"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_IsolatedPool_DistinctWorkers(t *testing.T) {
+	p, err := NewIsolatedPool(
+		func(wc WorkerConfig) *exec.Cmd { return exec.Command("php", "tests/client.php", "pid", "pipes") },
+		NewPipeFactory(),
+		IsolatedConfig{
+			StandbyCount:   2,
+			DestroyTimeout: time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	var (
+		mu        sync.Mutex
+		pids      = make(map[string]bool)
+		destroyed = make(map[string]bool)
+	)
+
+	p.Listen(func(event int, ctx interface{}) {
+		if event == EventWorkerDestruct {
+			destroyed[strconv.Itoa(*ctx.(*Worker).Pid)] = true
+		}
+	})
+
+	exec := func() {
+		res, err := p.Exec(&Payload{Body: []byte("hello")})
+		assert.NoError(t, err)
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		assert.False(t, pids[res.String()], "worker %s is reused", res.String())
+		pids[res.String()] = true
+	}
+
+	for i := 0; i < 10; i++ {
+		exec()
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				exec()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Len(t, pids, 30)
+	assert.Equal(t, int64(30), p.Stats().TotalExecs)
+
+	// every worker is destroyed once its task is done
+	count := func() int {
+		p.mul.Lock()
+		defer p.mul.Unlock()
+
+		return len(destroyed)
+	}
+
+	for i := 0; i < 100 && count() != 30; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	assert.Equal(t, 30, count())
+}
+
+func Test_IsolatedPool_StandbyReplenish(t *testing.T) {
+	p, err := NewIsolatedPool(
+		func(wc WorkerConfig) *exec.Cmd { return exec.Command("php", "tests/client.php", "pid", "pipes") },
+		NewPipeFactory(),
+		IsolatedConfig{
+			StandbyCount:   2,
+			DestroyTimeout: time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	assert.Equal(t, 2, p.Standby())
+
+	// concurrent load takes standby workers faster than they are replaced
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				_, err := p.Exec(&Payload{Body: []byte("hello")})
+				assert.NoError(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// standby workers served some of the tasks
+	assert.Equal(t, int64(20), p.Stats().TotalExecs)
+	assert.True(t, p.ColdStarts() <= 18, "cold starts: %v", p.ColdStarts())
+
+	// and are replenished once load is gone
+	for i := 0; i < 100 && p.Standby() != 2; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	assert.Equal(t, 2, p.Standby())
+	assert.Equal(t, 2, p.Stats().NumIdle)
+}
+
+func Test_IsolatedPool_NoStandby(t *testing.T) {
+	p, err := NewIsolatedPool(
+		func(wc WorkerConfig) *exec.Cmd { return exec.Command("php", "tests/client.php", "pid", "pipes") },
+		NewPipeFactory(),
+		IsolatedConfig{DestroyTimeout: time.Second},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	first, err := p.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+
+	second, err := p.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+
+	assert.NotEqual(t, first.String(), second.String())
+	assert.Equal(t, int64(2), p.ColdStarts())
+	assert.Equal(t, 0, p.Standby())
+}
+
+func Test_IsolatedPool_DeadStandby(t *testing.T) {
+	p, err := NewIsolatedPool(
+		func(wc WorkerConfig) *exec.Cmd { return exec.Command("php", "tests/client.php", "pid", "pipes") },
+		NewPipeFactory(),
+		IsolatedConfig{
+			StandbyCount:   1,
+			DestroyTimeout: time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	w := <-p.standby
+	assert.NoError(t, w.Kill())
+	p.standby <- w
+
+	res, err := p.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.NotEqual(t, strconv.Itoa(*w.Pid), res.String())
+}
+
+func Test_IsolatedPool_Destroy(t *testing.T) {
+	p, err := NewIsolatedPool(
+		func(wc WorkerConfig) *exec.Cmd { return exec.Command("php", "tests/client.php", "pid", "pipes") },
+		NewPipeFactory(),
+		IsolatedConfig{
+			StandbyCount:   2,
+			DestroyTimeout: time.Second,
+		},
+	)
+	assert.NoError(t, err)
+
+	destroyed := 0
+	p.Listen(func(event int, ctx interface{}) {
+		if event == EventWorkerDestruct {
+			destroyed++
+		}
+	})
+
+	p.Destroy()
+	assert.Equal(t, 2, destroyed)
+	assert.Equal(t, 0, p.Standby())
+
+	res, err := p.Exec(&Payload{Body: []byte("hello")})
+	assert.Nil(t, res)
+	assert.Equal(t, ErrPoolDestroyed, err)
+}
+
+func Test_IsolatedPool_Destroy_Used(t *testing.T) {
+	p, err := NewIsolatedPool(
+		func(wc WorkerConfig) *exec.Cmd { return exec.Command("php", "tests/client.php", "pid", "pipes") },
+		NewPipeFactory(),
+		IsolatedConfig{
+			StandbyCount:   1,
+			DestroyTimeout: time.Second,
+		},
+	)
+	assert.NoError(t, err)
+
+	var (
+		mu        sync.Mutex
+		destroyed []string
+	)
+	p.Listen(func(event int, ctx interface{}) {
+		if event == EventWorkerDestruct {
+			mu.Lock()
+			destroyed = append(destroyed, strconv.Itoa(*ctx.(*Worker).Pid))
+			mu.Unlock()
+		}
+	})
+
+	res, err := p.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+
+	// used worker is destroyed in background, destroy waits for it
+	p.Destroy()
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Contains(t, destroyed, res.String())
+}
@@ -0,0 +1,100 @@
+package roadrunner
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// LatencyBounds defines upper bounds of the execution latency histogram buckets, the extra last
+// bucket counts executions slower than the last bound.
+var LatencyBounds = [...]time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// LatencyBuckets contains number of executions per latency bucket, bucket i counts executions
+// which took up to LatencyBounds[i].
+type LatencyBuckets [len(LatencyBounds) + 1]int64
+
+// Merge adds execution counts of the other histogram.
+func (b *LatencyBuckets) Merge(other LatencyBuckets) {
+	for i, n := range other {
+		b[i] += n
+	}
+}
+
+// Total returns number of executions in the histogram.
+func (b LatencyBuckets) Total() (total int64) {
+	for _, n := range b {
+		total += n
+	}
+
+	return total
+}
+
+// Percentile returns upper bound of the bucket containing given percentile (0 < q <= 1) of
+// executions, 0 when histogram is empty. Executions slower than the last bound are reported
+// as the last bound.
+func (b LatencyBuckets) Percentile(q float64) time.Duration {
+	total := b.Total()
+	if total == 0 {
+		return 0
+	}
+
+	rank := int64(q*float64(total) + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+
+	var seen int64
+	for i, n := range b {
+		if seen += n; seen >= rank && i < len(LatencyBounds) {
+			return LatencyBounds[i]
+		}
+	}
+
+	return LatencyBounds[len(LatencyBounds)-1]
+}
+
+// MergeLatency combines latency histograms of the worker snapshots into pool wide histogram.
+func MergeLatency(snapshots []WorkerSnapshot) (merged LatencyBuckets) {
+	for _, s := range snapshots {
+		merged.Merge(s.LatencyBuckets)
+	}
+
+	return merged
+}
+
+// latencyHistogram counts worker executions per latency bucket, updated without locks.
+type latencyHistogram struct {
+	buckets LatencyBuckets
+}
+
+// observe registers execution of the given duration.
+func (h *latencyHistogram) observe(d time.Duration) {
+	i := 0
+	for i < len(LatencyBounds) && d > LatencyBounds[i] {
+		i++
+	}
+
+	atomic.AddInt64(&h.buckets[i], 1)
+}
+
+// snapshot returns copy of the execution counts.
+func (h *latencyHistogram) snapshot() (buckets LatencyBuckets) {
+	for i := range h.buckets {
+		buckets[i] = atomic.LoadInt64(&h.buckets[i])
+	}
+
+	return buckets
+}
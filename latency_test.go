@@ -0,0 +1,45 @@
+package roadrunner
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func Test_LatencyHistogram(t *testing.T) {
+	h := &latencyHistogram{}
+	h.observe(0)
+	h.observe(time.Millisecond)
+	h.observe(time.Millisecond * 30)
+	h.observe(time.Minute)
+
+	b := h.snapshot()
+	assert.Equal(t, int64(2), b[0])
+	assert.Equal(t, int64(1), b[4])
+	assert.Equal(t, int64(1), b[len(LatencyBounds)])
+	assert.Equal(t, int64(4), b.Total())
+}
+
+func Test_LatencyBuckets_Percentile(t *testing.T) {
+	var b LatencyBuckets
+	assert.Equal(t, time.Duration(0), b.Percentile(0.5))
+
+	b[0], b[5], b[len(LatencyBounds)] = 90, 9, 1
+
+	assert.Equal(t, time.Millisecond, b.Percentile(0.5))
+	assert.Equal(t, time.Millisecond, b.Percentile(0.9))
+	assert.Equal(t, 100*time.Millisecond, b.Percentile(0.99))
+	assert.Equal(t, 10*time.Second, b.Percentile(1))
+}
+
+func Test_MergeLatency(t *testing.T) {
+	var a, b LatencyBuckets
+	a[0], a[1] = 1, 2
+	b[1], b[2] = 3, 4
+
+	merged := MergeLatency([]WorkerSnapshot{{LatencyBuckets: a}, {LatencyBuckets: b}})
+	assert.Equal(t, int64(1), merged[0])
+	assert.Equal(t, int64(5), merged[1])
+	assert.Equal(t, int64(4), merged[2])
+	assert.Equal(t, int64(10), merged.Total())
+}
@@ -0,0 +1,138 @@
+package roadrunner
+
+import (
+	"net"
+	"sync"
+)
+
+// ListenerMiddleware wraps the relay listener of the SocketFactory, for example to instrument or
+// limit accepted connections before the handshake. Wrapped listener must accept connections using
+// the given listener, see SocketFactory.SetListenerMiddleware.
+type ListenerMiddleware func(ls net.Listener) net.Listener
+
+// innerListener is the listener given to the middleware, connections accepted by the acceptors
+// which waited on the listener before the middleware has been set are returned first.
+type innerListener struct {
+	net.Listener
+
+	mu       sync.Mutex
+	requeued []net.Conn
+}
+
+// Accept returns next requeued connection or waits for the new one.
+func (l *innerListener) Accept() (net.Conn, error) {
+	l.mu.Lock()
+	if len(l.requeued) != 0 {
+		conn := l.requeued[0]
+		l.requeued = l.requeued[1:]
+		l.mu.Unlock()
+
+		return conn, nil
+	}
+	l.mu.Unlock()
+
+	return l.Listener.Accept()
+}
+
+// push requeues connection to be passed through the middleware.
+func (l *innerListener) push(conn net.Conn) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.requeued = append(l.requeued, conn)
+}
+
+// drain returns requeued connections which have not been accepted yet.
+func (l *innerListener) drain() []net.Conn {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	requeued := l.requeued
+	l.requeued = nil
+
+	return requeued
+}
+
+// MaxConnsPerIP returns middleware limiting number of open TCP connections per remote IP, excess
+// connections are closed right after the accept. Connection releases its slot once closed. Other
+// connections (unix sockets) are not limited. Limit of 0 or below disables the middleware, listener
+// is returned unchanged, the same way zero disables other limits.
+func MaxConnsPerIP(n int) ListenerMiddleware {
+	return func(ls net.Listener) net.Listener {
+		if n <= 0 {
+			return ls
+		}
+
+		return &ipLimitListener{Listener: ls, max: n, conns: make(map[string]int)}
+	}
+}
+
+// ipLimitListener closes connections of the remote IPs which reached the limit.
+type ipLimitListener struct {
+	net.Listener
+	max int
+
+	mu    sync.Mutex
+	conns map[string]int
+}
+
+// Accept waits for the connection of the remote IP within the limit.
+func (l *ipLimitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		tcp, ok := conn.(*net.TCPConn)
+		if !ok {
+			return conn, nil
+		}
+
+		ip := tcp.RemoteAddr().(*net.TCPAddr).IP.String()
+		if !l.acquire(ip) {
+			_ = conn.Close()
+			continue
+		}
+
+		return &ipConn{TCPConn: tcp, release: func() { l.release(ip) }}, nil
+	}
+}
+
+// acquire takes the connection slot of the IP, returns false once limit is reached.
+func (l *ipLimitListener) acquire(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conns[ip] >= l.max {
+		return false
+	}
+
+	l.conns[ip]++
+	return true
+}
+
+// release frees the connection slot of the IP.
+func (l *ipLimitListener) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conns[ip]--; l.conns[ip] <= 0 {
+		delete(l.conns, ip)
+	}
+}
+
+// ipConn releases the connection slot once closed, socket options and out of sync detection
+// apply to the embedded connection.
+type ipConn struct {
+	*net.TCPConn
+
+	once    sync.Once
+	release func()
+}
+
+// Close closes the connection and releases its slot.
+func (c *ipConn) Close() error {
+	c.once.Do(c.release)
+	return c.TCPConn.Close()
+}
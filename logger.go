@@ -0,0 +1,25 @@
+package roadrunner
+
+// Logger receives diagnostic messages, context is passed as key-value pairs:
+// logger.Info("relay associated", "pid", 1000, "elapsed", time.Second).
+type Logger interface {
+	// Debug logs message useful for debugging.
+	Debug(msg string, keyvals ...interface{})
+
+	// Info logs informational message.
+	Info(msg string, keyvals ...interface{})
+
+	// Warn logs non critical issue.
+	Warn(msg string, keyvals ...interface{})
+
+	// Error logs error message.
+	Error(msg string, keyvals ...interface{})
+}
+
+// nopLogger discards all messages.
+type nopLogger struct{}
+
+func (nopLogger) Debug(msg string, keyvals ...interface{}) {}
+func (nopLogger) Info(msg string, keyvals ...interface{})  {}
+func (nopLogger) Warn(msg string, keyvals ...interface{})  {}
+func (nopLogger) Error(msg string, keyvals ...interface{}) {}
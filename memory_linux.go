@@ -0,0 +1,65 @@
+// +build linux
+
+package roadrunner
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var (
+	// procRoot is mount point of the proc filesystem.
+	procRoot = "/proc"
+
+	// cgroupRoot is mount point of the cgroup v2 unified hierarchy.
+	cgroupRoot = "/sys/fs/cgroup"
+)
+
+// cgroupMemory returns memory.current of the cgroup v2 the process has been placed into, ok is
+// false when process belongs to cgroup v1 hierarchy only, memory controller is not enabled or
+// process shares the cgroup with the current process. Memory of the shared cgroup accounts all
+// processes of the container and does not describe the worker.
+func cgroupMemory(pid int) (usage uint64, ok bool) {
+	group, ok := cgroupPath(strconv.Itoa(pid))
+	if !ok {
+		return 0, false
+	}
+
+	if own, ok := cgroupPath(strconv.Itoa(os.Getpid())); ok && own == group {
+		return 0, false
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(cgroupRoot, group, "memory.current"))
+	if err != nil {
+		return 0, false
+	}
+
+	if usage, err = strconv.ParseUint(string(bytes.TrimSpace(data)), 10, 64); err != nil {
+		return 0, false
+	}
+
+	return usage, true
+}
+
+// cgroupPath returns cgroup v2 path of the process (0::<path> entry of /proc/<pid>/cgroup).
+func cgroupPath(pid string) (string, bool) {
+	f, err := os.Open(filepath.Join(procRoot, pid, "cgroup"))
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if path := strings.TrimPrefix(s.Text(), "0::"); path != s.Text() {
+			return path, true
+		}
+	}
+
+	return "", false
+}
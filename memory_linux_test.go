@@ -0,0 +1,137 @@
+// +build linux
+
+package roadrunner
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// fakeCgroups replaces proc and cgroup roots with the temporary directory, self describes
+// cgroup file of the current process.
+func fakeCgroups(t *testing.T, self string) func() {
+	dir, err := ioutil.TempDir("", "cgroup")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	proc, group := procRoot, cgroupRoot
+	procRoot, cgroupRoot = filepath.Join(dir, "proc"), filepath.Join(dir, "cgroup")
+
+	writeFile(t, filepath.Join(procRoot, strconv.Itoa(os.Getpid()), "cgroup"), self)
+
+	return func() {
+		procRoot, cgroupRoot = proc, group
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func writeFile(t *testing.T, path, data string) {
+	assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	assert.NoError(t, ioutil.WriteFile(path, []byte(data), 0644))
+}
+
+func Test_CgroupMemory(t *testing.T) {
+	restore := fakeCgroups(t, "0::/rr\n")
+	defer restore()
+
+	writeFile(t, filepath.Join(procRoot, "1001", "cgroup"), "0::/rr/worker-0\n")
+	writeFile(t, filepath.Join(cgroupRoot, "rr", "worker-0", "memory.current"), "1048576\n")
+
+	usage, ok := cgroupMemory(1001)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(1048576), usage)
+
+	// accounted memory prefers cgroup
+	usage, err := syntheticWorker(1001).accountedMemory()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1048576), usage)
+}
+
+func Test_CgroupMemory_Fallback(t *testing.T) {
+	restore := fakeCgroups(t, "0::/rr\n")
+	defer restore()
+
+	// shared with the current process
+	writeFile(t, filepath.Join(procRoot, "1001", "cgroup"), "0::/rr\n")
+	writeFile(t, filepath.Join(cgroupRoot, "rr", "memory.current"), "1048576\n")
+
+	// cgroup v1
+	writeFile(t, filepath.Join(procRoot, "1002", "cgroup"), "4:memory:/rr/worker-1\n")
+
+	// memory controller is not enabled
+	writeFile(t, filepath.Join(procRoot, "1003", "cgroup"), "0::/rr/worker-2\n")
+
+	for _, pid := range []int{1001, 1002, 1003, 1004} {
+		_, ok := cgroupMemory(pid)
+		assert.False(t, ok, pid)
+	}
+
+	// resident memory of the real process is used
+	usage, err := syntheticWorker(os.Getpid()).accountedMemory()
+	assert.NoError(t, err)
+	assert.True(t, usage > 0)
+}
+
+// fakeMemory places worker into the fake cgroup accounting given number of megabytes.
+func fakeMemory(t *testing.T, w *Worker, mb int) {
+	group := "worker-" + strconv.Itoa(*w.Pid)
+	writeFile(t, filepath.Join(procRoot, strconv.Itoa(*w.Pid), "cgroup"), "0::/rr/"+group+"\n")
+	writeFile(t, filepath.Join(cgroupRoot, "rr", group, "memory.current"), strconv.Itoa(mb*1024*1024))
+}
+
+func Test_StaticPool_MaxTotalMemory(t *testing.T) {
+	restore := fakeCgroups(t, "0::/rr\n")
+	defer restore()
+
+	clock := newMockClock()
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "echo", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      3,
+			MaxTotalMemory:  10,
+			Clock:           clock,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	workers := p.Workers()
+	fakeMemory(t, workers[0], 2)
+	fakeMemory(t, workers[1], 3)
+	fakeMemory(t, workers[2], 3)
+
+	// below 90% of the budget
+	assert.True(t, clock.WaitTimers(1))
+	clock.Advance(time.Second)
+	assert.True(t, clock.WaitTimers(1))
+
+	assert.Equal(t, uint64(8*1024*1024), p.Stats().TotalMemory)
+	assert.Equal(t, workers, p.Workers())
+
+	// largest idle worker is trimmed
+	fakeMemory(t, workers[1], 5)
+	clock.Advance(time.Second)
+	assert.True(t, clock.WaitTimers(1))
+
+	assert.Equal(t, uint64(10*1024*1024), p.Stats().TotalMemory)
+
+	<-workers[1].waitDone
+	time.Sleep(time.Millisecond * 100)
+
+	assert.Equal(t, int64(1), p.Stats().RecycleReasons[RecycleMaxTotalMemory])
+
+	assert.Len(t, p.Workers(), 3)
+	assert.NotContains(t, p.Workers(), workers[1])
+	assert.Contains(t, p.Workers(), workers[0])
+	assert.Contains(t, p.Workers(), workers[2])
+}
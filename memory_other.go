@@ -0,0 +1,8 @@
+// +build !linux
+
+package roadrunner
+
+// cgroupMemory is not available, cgroups are supported on Linux only.
+func cgroupMemory(pid int) (usage uint64, ok bool) {
+	return 0, false
+}
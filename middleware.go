@@ -0,0 +1,61 @@
+package roadrunner
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// ExecFunc executes the task until context is done, see ManagedPool.ExecContext.
+type ExecFunc func(ctx context.Context, rqs *Payload) (rsp *Payload, err error)
+
+// Middleware wraps task execution with the cross-cutting behavior such as logging, metrics or
+// retries. Middleware calls next to pass the task down the chain, returning without calling next
+// short-circuits the task and it never reaches the worker.
+type Middleware func(next ExecFunc) ExecFunc
+
+// middlewareChain holds middleware attached to the pool, first attached middleware is outermost.
+type middlewareChain struct {
+	mu    sync.RWMutex
+	chain []Middleware
+}
+
+// use appends middleware to the chain, applies to the tasks started afterwards.
+func (c *middlewareChain) use(m Middleware) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.chain = append(c.chain, m)
+}
+
+// exec passes the task through the chain to the given exec function.
+func (c *middlewareChain) exec(ctx context.Context, rqs *Payload, exec ExecFunc) (*Payload, error) {
+	c.mu.RLock()
+	chain := c.chain
+	c.mu.RUnlock()
+
+	for i := len(chain) - 1; i >= 0; i-- {
+		exec = chain[i](exec)
+	}
+
+	return exec(ctx, rqs)
+}
+
+// LoggingMiddleware logs every task at Debug level along with request ID and duration, failed
+// tasks are logged at Warn level along with the error.
+func LoggingMiddleware(logger Logger) Middleware {
+	return func(next ExecFunc) ExecFunc {
+		return func(ctx context.Context, rqs *Payload) (*Payload, error) {
+			start := time.Now()
+			rsp, err := next(ctx, rqs)
+
+			if err != nil {
+				logger.Warn("task failed", "request", rqs.RequestID, "duration", time.Since(start), "error", err)
+			} else {
+				logger.Debug("task executed", "request", rqs.RequestID, "duration", time.Since(start))
+			}
+
+			return rsp, err
+		}
+	}
+}
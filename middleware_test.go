@@ -0,0 +1,125 @@
+package roadrunner
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+// tracing records the order middleware is entered and left.
+func tracing(name string, trace *[]string) Middleware {
+	return func(next ExecFunc) ExecFunc {
+		return func(ctx context.Context, rqs *Payload) (*Payload, error) {
+			*trace = append(*trace, name+">")
+			rsp, err := next(ctx, rqs)
+			*trace = append(*trace, "<"+name)
+
+			return rsp, err
+		}
+	}
+}
+
+func Test_Middleware_Order(t *testing.T) {
+	var (
+		c     middlewareChain
+		trace []string
+	)
+
+	c.use(tracing("a", &trace))
+	c.use(tracing("b", &trace))
+
+	rsp, err := c.exec(context.Background(), &Payload{Body: []byte("hello")}, func(ctx context.Context, rqs *Payload) (*Payload, error) {
+		trace = append(trace, "exec")
+		return rqs, nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", rsp.String())
+	assert.Equal(t, []string{"a>", "b>", "exec", "<b", "<a"}, trace)
+}
+
+func Test_Middleware_ShortCircuit(t *testing.T) {
+	var c middlewareChain
+	c.use(func(next ExecFunc) ExecFunc {
+		return func(ctx context.Context, rqs *Payload) (*Payload, error) {
+			return &Payload{Body: []byte("cached")}, nil
+		}
+	})
+
+	rsp, err := c.exec(context.Background(), &Payload{Body: []byte("hello")}, func(ctx context.Context, rqs *Payload) (*Payload, error) {
+		t.Fatal("task must not reach the worker")
+		return nil, nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "cached", rsp.String())
+}
+
+func Test_Middleware_Empty(t *testing.T) {
+	var c middlewareChain
+
+	rsp, err := c.exec(context.Background(), &Payload{Body: []byte("hello")}, func(ctx context.Context, rqs *Payload) (*Payload, error) {
+		return rqs, nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", rsp.String())
+}
+
+func Test_LoggingMiddleware(t *testing.T) {
+	var c middlewareChain
+
+	log := &testLogger{}
+	c.use(LoggingMiddleware(log))
+
+	execOK := func(ctx context.Context, rqs *Payload) (*Payload, error) { return rqs, nil }
+	_, err := c.exec(context.Background(), &Payload{RequestID: "1"}, execOK)
+	assert.NoError(t, err)
+
+	execErr := func(ctx context.Context, rqs *Payload) (*Payload, error) { return nil, errors.New("failed") }
+	_, err = c.exec(context.Background(), &Payload{RequestID: "2"}, execErr)
+	assert.Error(t, err)
+
+	assert.Equal(t, []string{"task executed", "task failed"}, log.Messages())
+}
+
+func Test_StaticPool_Middleware(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "echo", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      1,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	var trace []string
+	p.Use(tracing("a", &trace))
+	p.Use(tracing("b", &trace))
+
+	log := &testLogger{}
+	p.Use(LoggingMiddleware(log))
+
+	res, err := p.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", res.String())
+	assert.Equal(t, []string{"a>", "b>", "<b", "<a"}, trace)
+	assert.Equal(t, []string{"task executed"}, log.Messages())
+
+	// short-circuit
+	p.Use(func(next ExecFunc) ExecFunc {
+		return func(ctx context.Context, rqs *Payload) (*Payload, error) {
+			return nil, errors.New("rejected")
+		}
+	})
+
+	_, err = p.Exec(&Payload{Body: []byte("hello")})
+	assert.EqualError(t, err, "rejected")
+	assert.Equal(t, int64(1), p.Workers()[0].State().NumExecs())
+}
@@ -0,0 +1,393 @@
+package roadrunner
+
+import (
+	"context"
+	"fmt"
+	json "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+	"github.com/spiral/goridge/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// muxRelay multiplexes concurrent tasks over the single worker relay. Every task and response is
+// preceded by muxCommand header carrying the task sequence number, responses are passed to the
+// waiting tasks in any order by the relay reader.
+type muxRelay struct {
+	rl goridge.Relay
+
+	// max number of tasks executed at once
+	max int
+
+	// keeps frames of concurrent tasks together
+	wmu sync.Mutex
+
+	// protects fields below
+	mu sync.Mutex
+
+	// signaled once task completes or relay reader fails
+	idle *sync.Cond
+
+	// sequence number of the last sent task
+	seq uint64
+
+	// tasks waiting for the response by sequence number
+	waiting map[uint64]chan execResult
+
+	// number of pool checkouts of the worker
+	acquired int
+
+	// set once worker is being stopped, no tasks are accepted afterwards
+	closed bool
+
+	// set once worker removed from the pool has been claimed for retirement, see claimRemoved
+	retired bool
+
+	// relay reader error, set before failed is closed
+	err error
+
+	// closed once relay reader fails
+	failed chan interface{}
+}
+
+// newMuxRelay creates multiplexer over given relay and starts reading worker responses.
+func newMuxRelay(rl goridge.Relay, max int) *muxRelay {
+	m := &muxRelay{
+		rl:      rl,
+		max:     max,
+		waiting: make(map[uint64]chan execResult),
+		failed:  make(chan interface{}),
+	}
+	m.idle = sync.NewCond(&m.mu)
+
+	go m.serve()
+	return m
+}
+
+// register reserves sequence number for the new task, state must be ready.
+func (m *muxRelay) register(st State) (uint64, chan execResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.err != nil {
+		return 0, nil, errors.Wrap(m.err, "worker error")
+	}
+
+	if m.closed || st.Value() != StateReady {
+		return 0, nil, fmt.Errorf("worker is not ready (%s)", st.String())
+	}
+
+	m.seq++
+
+	// buffered to let reader pass the response without waiting
+	done := make(chan execResult, 1)
+	m.waiting[m.seq] = done
+
+	return m.seq, done, nil
+}
+
+// forget stops waiting for the task response, late response fails the relay reader.
+func (m *muxRelay) forget(seq uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.waiting, seq)
+	m.idle.Broadcast()
+}
+
+// send writes task header, context and body frames.
+func (m *muxRelay) send(seq uint64, rqs *Payload, flags byte) error {
+	m.wmu.Lock()
+	defer m.wmu.Unlock()
+
+	if err := sendControl(m.rl, &muxCommand{Request: seq}); err != nil {
+		return errors.Wrap(err, "header error")
+	}
+
+	if err := sendControl(m.rl, rqs.Context); err != nil {
+		return errors.Wrap(err, "header error")
+	}
+
+	if err := m.rl.Send(rqs.Body, flags); err != nil {
+		return errors.Wrap(err, "sender error")
+	}
+
+	return nil
+}
+
+// sendControl writes control command between the tasks.
+func (m *muxRelay) sendControl(v interface{}) error {
+	m.wmu.Lock()
+	defer m.wmu.Unlock()
+
+	return sendControl(m.rl, v)
+}
+
+// close rejects new tasks and waits for the running ones to complete or relay reader to fail.
+func (m *muxRelay) close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.closed = true
+	for len(m.waiting) != 0 && m.err == nil {
+		m.idle.Wait()
+	}
+}
+
+// busy returns true when worker executes at least one task.
+func (m *muxRelay) busy() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.waiting) != 0
+}
+
+// error returns relay reader error, nil while reader is alive.
+func (m *muxRelay) error() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.err
+}
+
+// serve reads worker responses and passes them to the waiting tasks until relay fails.
+func (m *muxRelay) serve() {
+	for {
+		seq, r, err := m.receive()
+		if err != nil {
+			m.fail(err)
+			return
+		}
+
+		m.mu.Lock()
+		done, ok := m.waiting[seq]
+		delete(m.waiting, seq)
+		m.idle.Broadcast()
+		m.mu.Unlock()
+
+		if !ok {
+			m.fail(fmt.Errorf("unexpected response to request %v", seq))
+			return
+		}
+
+		done <- r
+	}
+}
+
+// receive reads single response, job error is returned as the response error.
+func (m *muxRelay) receive() (uint64, execResult, error) {
+	data, pr, err := m.rl.Receive()
+	if err != nil {
+		return 0, execResult{}, err
+	}
+
+	cmd := muxCommand{}
+	if !pr.HasFlag(goridge.PayloadControl) {
+		return 0, execResult{}, fmt.Errorf("malformed worker response, header is missing")
+	}
+
+	j := json.ConfigCompatibleWithStandardLibrary
+	if err := j.Unmarshal(data, &cmd); err != nil {
+		return 0, execResult{}, fmt.Errorf("malformed worker response: %s", err)
+	}
+
+	rsp := new(Payload)
+	if rsp.Context, pr, err = m.rl.Receive(); err != nil {
+		return 0, execResult{}, err
+	}
+
+	if !pr.HasFlag(goridge.PayloadControl) {
+		return 0, execResult{}, fmt.Errorf("malformed worker response")
+	}
+
+	if pr.HasFlag(goridge.PayloadError) {
+		return cmd.Request, execResult{err: JobError(rsp.Context)}, nil
+	}
+
+	if rsp.Body, pr, err = m.rl.Receive(); err != nil {
+		return 0, execResult{}, err
+	}
+
+	if pr.HasFlag(goridge.PayloadError) {
+		// context of the error response might report the worker status
+		return cmd.Request, execResult{rsp: &Payload{Context: rsp.Context}, err: ErrWorkerError(rsp.Body)}, nil
+	}
+
+	return cmd.Request, execResult{rsp: rsp}, nil
+}
+
+// fail releases all waiting tasks with the given error.
+func (m *muxRelay) fail(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.err = err
+	close(m.failed)
+	m.idle.Broadcast()
+}
+
+// SetConcurrency lets worker execute up to n tasks at once, pool checks out the worker up to n
+// times. Tasks and responses are multiplexed over the relay (see muxCommand), worker must be able
+// to process tasks concurrently and may respond in any order. Must be called once, before the
+// first execution. Multiplexed worker can not be hijacked, canceled tasks are not sent cancel
+// command and worker is killed instead. Value below 2 keeps one task at a time.
+func (w *Worker) SetConcurrency(n int) {
+	if n < 2 || w.mux != nil {
+		return
+	}
+
+	w.mux = newMuxRelay(w.rl, n)
+}
+
+// Concurrency returns max number of tasks worker executes at once.
+func (w *Worker) Concurrency() int {
+	if w.mux == nil {
+		return 1
+	}
+
+	return w.mux.max
+}
+
+// execMux executes the task over the multiplexed relay until context is done, worker is killed
+// once context is done or relay fails.
+func (w *Worker) execMux(ctx context.Context, rqs *Payload) (rsp *Payload, err error) {
+	if rqs == nil {
+		return nil, fmt.Errorf("payload can not be empty")
+	}
+
+	if err := w.checkPayload(rqs); err != nil {
+		return nil, err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	seq, done, err := w.mux.register(w.state)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	defer func() {
+		w.latency.observe(time.Since(start))
+		w.state.registerExec()
+	}()
+
+	// concurrent tasks are not tracked by RequestID, errors carry the task ID instead
+	w.execs.push(rqs)
+
+	if err := w.mux.send(seq, rqs, w.bodyFlags()); err != nil {
+		w.mux.forget(seq)
+		w.state.set(StateErrored)
+		return nil, requestError(err, w.withStatus("worker error"), rqs.RequestID)
+	}
+
+	select {
+	case r := <-done:
+		// only job errors are passed to the tasks
+		w.observeStatus(r.rsp)
+		if je, ok := r.err.(JobError); ok {
+			return nil, w.jobError(je)
+		}
+
+		return r.rsp, r.err
+
+	case <-w.mux.failed:
+		w.state.set(StateErrored)
+		err := requestError(w.mux.error(), w.withStatus("worker error"), rqs.RequestID)
+		if errors.Cause(err) == ErrPayloadTooLarge {
+			_ = w.Kill()
+		}
+
+		return nil, err
+
+	case <-ctx.Done():
+		w.mux.forget(seq)
+		w.state.set(StateErrored)
+
+		if ctx.Err() == context.DeadlineExceeded {
+			atomic.StoreInt32(&w.timedOut, 1)
+		}
+
+		// other tasks are released once process is dead
+		if err := w.Kill(); err != nil {
+			return nil, errors.Wrap(err, ctx.Err().Error())
+		}
+
+		return nil, ctx.Err()
+	}
+}
+
+// checkout registers pool checkout of the worker, returns true when multiplexed worker can
+// be checked out once again.
+func (w *Worker) checkout() bool {
+	if w.mux == nil {
+		return false
+	}
+
+	w.mux.mu.Lock()
+	defer w.mux.mu.Unlock()
+
+	w.mux.acquired++
+	return w.mux.acquired < w.mux.max
+}
+
+// checkin registers worker return to the pool, returns false when multiplexed worker has never
+// left the free workers.
+func (w *Worker) checkin() bool {
+	if w.mux == nil {
+		return true
+	}
+
+	w.mux.mu.Lock()
+	defer w.mux.mu.Unlock()
+
+	w.mux.acquired--
+	return w.mux.acquired == w.mux.max-1
+}
+
+// checkinRemoved registers return of the worker being removed from the pool, returns true when
+// worker must be retired, see claimRemoved.
+func (w *Worker) checkinRemoved() bool {
+	if w.mux == nil {
+		return true
+	}
+
+	w.mux.mu.Lock()
+	w.mux.acquired--
+	w.mux.mu.Unlock()
+
+	return w.claimRemoved()
+}
+
+// claimRemoved returns true when worker being removed from the pool can be retired: regular
+// worker right away, multiplexed worker once none of its slots is checked out. True is returned
+// once only, pool and the last returned slot both try to claim the worker.
+func (w *Worker) claimRemoved() bool {
+	if w.mux == nil {
+		return true
+	}
+
+	w.mux.mu.Lock()
+	defer w.mux.mu.Unlock()
+
+	if w.mux.acquired != 0 || w.mux.retired {
+		return false
+	}
+
+	w.mux.retired = true
+	return true
+}
+
+// IsBusy returns true when worker executes the task (StateWorking) or multiplexed worker has
+// tasks in flight, safe to call concurrently. Result is a snapshot only: idle worker may be
+// allocated and become busy right after the check, and vice versa.
+func (w *Worker) IsBusy() bool {
+	if w.mux != nil && w.mux.busy() {
+		return true
+	}
+
+	return w.state.Value() == StateWorking
+}
@@ -0,0 +1,233 @@
+package roadrunner
+
+import (
+	json "github.com/json-iterator/go"
+	"github.com/spiral/goridge/v2"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"os/exec"
+	"sync"
+	"testing"
+)
+
+// muxTask is the task received by the fake multiplexed worker.
+type muxTask struct {
+	request uint64
+	body    []byte
+}
+
+// receiveMuxTasks reads n tasks sent to the multiplexed worker.
+func receiveMuxTasks(rl goridge.Relay, n int) (tasks []muxTask) {
+	for len(tasks) < n {
+		data, _, err := rl.Receive()
+		if err != nil {
+			return tasks
+		}
+
+		cmd := muxCommand{}
+		_ = json.Unmarshal(data, &cmd)
+
+		if _, _, err := rl.Receive(); err != nil {
+			return tasks
+		}
+
+		body, _, err := rl.Receive()
+		if err != nil {
+			return tasks
+		}
+
+		tasks = append(tasks, muxTask{request: cmd.Request, body: body})
+	}
+
+	return tasks
+}
+
+// sendMuxResponse responds to the task, empty body responds with the job error and "error" body
+// is responded with the body frame flagged as error.
+func sendMuxResponse(rl goridge.Relay, t muxTask) {
+	_ = sendControl(rl, &muxCommand{Request: t.request})
+	if len(t.body) == 0 {
+		_ = rl.Send([]byte("job error"), goridge.PayloadControl|goridge.PayloadError)
+		return
+	}
+
+	if string(t.body) == "error" {
+		_ = rl.Send(nil, goridge.PayloadControl|goridge.PayloadEmpty)
+		_ = rl.Send([]byte("body error"), goridge.PayloadRaw|goridge.PayloadError)
+		return
+	}
+
+	_ = rl.Send(nil, goridge.PayloadControl|goridge.PayloadEmpty)
+	_ = rl.Send(t.body, goridge.PayloadRaw)
+}
+
+func muxWorker(t *testing.T, n int) (*Worker, net.Conn) {
+	w, _ := newWorker(exec.Command("sleep", "10"))
+	assert.NoError(t, w.start())
+
+	conn, wConn := connPair(t)
+
+	w.rl = goridge.NewSocketRelay(conn)
+	w.state.set(StateReady)
+	w.SetConcurrency(n)
+
+	return w, wConn
+}
+
+func Test_Mux_Exec_OutOfOrder(t *testing.T) {
+	w, wConn := muxWorker(t, 3)
+	defer w.Kill()
+
+	assert.Equal(t, 3, w.Concurrency())
+
+	go func() {
+		rl := goridge.NewSocketRelay(wConn)
+
+		// every task is received before the first response
+		tasks := receiveMuxTasks(rl, 3)
+		for i := len(tasks) - 1; i >= 0; i-- {
+			sendMuxResponse(rl, tasks[i])
+		}
+	}()
+
+	wg := sync.WaitGroup{}
+	for _, body := range []string{"a", "b", "c"} {
+		wg.Add(1)
+		go func(body string) {
+			defer wg.Done()
+
+			res, err := w.Exec(&Payload{Body: []byte(body)})
+			assert.NoError(t, err)
+			assert.Equal(t, body, res.String())
+		}(body)
+	}
+	wg.Wait()
+
+	assert.Equal(t, StateReady, w.State().Value())
+	assert.Equal(t, int64(3), w.State().NumExecs())
+	assert.False(t, w.Snapshot().Busy)
+}
+
+func Test_Mux_Exec_JobError(t *testing.T) {
+	w, wConn := muxWorker(t, 2)
+	defer w.Kill()
+
+	go func() {
+		rl := goridge.NewSocketRelay(wConn)
+		for _, task := range receiveMuxTasks(rl, 2) {
+			sendMuxResponse(rl, task)
+		}
+	}()
+
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+
+		res, err := w.Exec(&Payload{Body: []byte("hello")})
+		assert.NoError(t, err)
+		assert.Equal(t, "hello", res.String())
+	}()
+
+	go func() {
+		defer wg.Done()
+
+		res, err := w.Exec(&Payload{})
+		assert.Nil(t, res)
+		assert.IsType(t, JobError{}, err)
+	}()
+	wg.Wait()
+
+	assert.Equal(t, StateReady, w.State().Value())
+}
+
+func Test_Mux_Exec_WorkerErrorBody(t *testing.T) {
+	w, wConn := muxWorker(t, 2)
+	defer w.Kill()
+
+	go func() {
+		rl := goridge.NewSocketRelay(wConn)
+		for i := 0; i < 2; i++ {
+			for _, task := range receiveMuxTasks(rl, 1) {
+				sendMuxResponse(rl, task)
+			}
+		}
+	}()
+
+	res, err := w.Exec(&Payload{Body: []byte("error")})
+	assert.Nil(t, res)
+	assert.Equal(t, ErrWorkerError("body error"), err)
+
+	res, err = w.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", res.String())
+	assert.Equal(t, StateReady, w.State().Value())
+}
+
+func Test_Mux_Exec_UnexpectedResponse(t *testing.T) {
+	w, wConn := muxWorker(t, 2)
+	defer w.Kill()
+
+	go func() {
+		rl := goridge.NewSocketRelay(wConn)
+		for _, task := range receiveMuxTasks(rl, 1) {
+			task.request += 10
+			sendMuxResponse(rl, task)
+		}
+	}()
+
+	res, err := w.Exec(&Payload{Body: []byte("hello"), RequestID: "abc"})
+	assert.Nil(t, res)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "request abc")
+	assert.Equal(t, StateErrored, w.State().Value())
+
+	assert.Error(t, w.Ping())
+}
+
+func Test_Mux_Checkout(t *testing.T) {
+	w, _ := muxWorker(t, 2)
+	defer w.Kill()
+
+	assert.True(t, w.checkout())
+	assert.False(t, w.checkout())
+
+	// worker has left the free workers once fully checked out
+	assert.True(t, w.checkin())
+	assert.False(t, w.checkin())
+}
+
+func Test_Mux_CheckinRemoved(t *testing.T) {
+	w, _ := muxWorker(t, 2)
+	defer w.Kill()
+
+	w.checkout()
+	w.checkout()
+
+	// worker is retired by the last returned slot only
+	assert.False(t, w.claimRemoved())
+	assert.False(t, w.checkinRemoved())
+	assert.True(t, w.checkinRemoved())
+	assert.False(t, w.claimRemoved())
+}
+
+func Test_Mux_ClaimRemoved_Idle(t *testing.T) {
+	w, _ := muxWorker(t, 2)
+	defer w.Kill()
+
+	assert.True(t, w.claimRemoved())
+	assert.False(t, w.claimRemoved())
+
+	// regular worker is retired right away
+	r, _ := newWorker(exec.Command("sleep", "10"))
+	assert.True(t, r.checkinRemoved())
+}
+
+func Test_Mux_Default(t *testing.T) {
+	w, _ := newWorker(exec.Command("sleep", "10"))
+	w.SetConcurrency(1)
+
+	assert.Equal(t, 1, w.Concurrency())
+	assert.False(t, w.checkout())
+	assert.True(t, w.checkin())
+}
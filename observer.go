@@ -0,0 +1,167 @@
+package roadrunner
+
+import (
+	"github.com/spiral/goridge/v2"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultObserverBuffer defines how many bytes of the relay traffic are buffered for the slow
+// observer sink by default.
+const DefaultObserverBuffer = 1 << 20
+
+// Observe mirrors raw relay traffic of the worker (frames sent to and received from the worker,
+// prefix included, in the order they pass the relay) to the sink, for the low-level protocol
+// debugging. Sink is written from the separate goroutine and never blocks the worker, frames
+// which do not fit the buffer of size bytes (DefaultObserverBuffer when 0) are dropped as a
+// whole, captured stream always consists of complete frames. Must be called before the first
+// execution and before SetConcurrency, for example from the pool OnWorkerReady hook. Traffic
+// of the worker handshake is not captured. Observing adds a copy of every frame, keep it off
+// in production.
+func (w *Worker) Observe(sink io.Writer, size int) {
+	if size <= 0 {
+		size = DefaultObserverBuffer
+	}
+
+	o := newRelayObserver(sink, size)
+	w.rl = &observerRelay{Relay: w.rl, observer: o}
+	w.observer = o
+
+	go func() {
+		<-w.waitDone
+		o.close()
+	}()
+}
+
+// ObserverDropped returns number of the frames observer dropped due to the slow sink, 0 when
+// worker is not observed.
+func (w *Worker) ObserverDropped() int64 {
+	if w.observer == nil {
+		return 0
+	}
+
+	return w.observer.droppedFrames()
+}
+
+// observerRelay mirrors frames passing the relay to the observer.
+type observerRelay struct {
+	goridge.Relay
+	observer *relayObserver
+}
+
+// Send sends the frame and mirrors it once sent.
+func (r *observerRelay) Send(data []byte, flags byte) error {
+	if err := r.Relay.Send(data, flags); err != nil {
+		return err
+	}
+
+	r.observer.frame(goridge.NewPrefix().WithFlags(flags).WithSize(uint64(len(data))), data)
+	return nil
+}
+
+// Receive receives the frame and mirrors it, incomplete frames are not mirrored.
+func (r *observerRelay) Receive() (data []byte, p goridge.Prefix, err error) {
+	if data, p, err = r.Relay.Receive(); err != nil {
+		return data, p, err
+	}
+
+	r.observer.frame(p, data)
+	return data, p, nil
+}
+
+// Close closes the relay once observer flushed the buffered frames to the sink.
+func (r *observerRelay) Close() error {
+	r.observer.close()
+	return r.Relay.Close()
+}
+
+// relayObserver buffers mirrored frames and writes them to the sink.
+type relayObserver struct {
+	sink io.Writer
+
+	mu sync.Mutex
+
+	// signaled once frame is buffered or observer is closed
+	cond *sync.Cond
+
+	// max number of buffered bytes
+	size int
+
+	// frames waiting for the sink
+	buf []byte
+
+	closed bool
+
+	// closed once buffered frames are written after close
+	done chan interface{}
+
+	// number of frames dropped due to the full buffer, accessed atomically
+	dropped int64
+}
+
+// newRelayObserver creates observer and starts writing to the sink.
+func newRelayObserver(sink io.Writer, size int) *relayObserver {
+	o := &relayObserver{sink: sink, size: size, done: make(chan interface{})}
+	o.cond = sync.NewCond(&o.mu)
+
+	go o.flush()
+	return o
+}
+
+// frame buffers the frame, frame is dropped when buffer is full.
+func (o *relayObserver) frame(p goridge.Prefix, data []byte) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.closed {
+		return
+	}
+
+	if len(o.buf)+len(p)+len(data) > o.size {
+		atomic.AddInt64(&o.dropped, 1)
+		return
+	}
+
+	o.buf = append(append(o.buf, p[:]...), data...)
+	o.cond.Signal()
+}
+
+// close stops the observer and waits until buffered frames are written to the sink.
+func (o *relayObserver) close() {
+	o.mu.Lock()
+	o.closed = true
+	o.cond.Signal()
+	o.mu.Unlock()
+
+	<-o.done
+}
+
+// droppedFrames returns number of the frames dropped due to the full buffer.
+func (o *relayObserver) droppedFrames() int64 {
+	return atomic.LoadInt64(&o.dropped)
+}
+
+// flush writes buffered frames to the sink until observer is closed, sink errors are ignored.
+func (o *relayObserver) flush() {
+	defer close(o.done)
+
+	var chunk []byte
+	for {
+		o.mu.Lock()
+		for len(o.buf) == 0 && !o.closed {
+			o.cond.Wait()
+		}
+
+		if len(o.buf) == 0 {
+			o.mu.Unlock()
+			return
+		}
+
+		// buffers are swapped to keep writing without holding the lock
+		chunk, o.buf = o.buf, chunk[:0]
+		o.mu.Unlock()
+
+		_, _ = o.sink.Write(chunk)
+	}
+}
@@ -0,0 +1,138 @@
+package roadrunner
+
+import (
+	"bytes"
+	"github.com/spiral/goridge/v2"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os/exec"
+	"sync"
+	"testing"
+)
+
+// lockedBuffer is a concurrency safe observer sink.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]byte(nil), b.buf.Bytes()...)
+}
+
+// blockedWriter blocks every write until released.
+type blockedWriter struct {
+	release chan interface{}
+}
+
+func (b *blockedWriter) Write(p []byte) (int, error) {
+	<-b.release
+	return len(p), nil
+}
+
+func Test_ObserverRelay_Capture(t *testing.T) {
+	a, b := connPair(t)
+	defer b.Close()
+
+	sink := &lockedBuffer{}
+	o := newRelayObserver(sink, DefaultObserverBuffer)
+	rl := &observerRelay{Relay: goridge.NewSocketRelay(a), observer: o}
+	peer := goridge.NewSocketRelay(b)
+
+	// raw bytes written by the peer
+	received := &bytes.Buffer{}
+	p := goridge.NewPrefix().WithFlags(goridge.PayloadRaw).WithSize(5)
+	received.Write(append(p[:], "world"...))
+
+	go func() {
+		assert.NoError(t, peer.Send([]byte("world"), goridge.PayloadRaw))
+	}()
+
+	assert.NoError(t, rl.Send([]byte("hello"), goridge.PayloadControl))
+	data, _, err := rl.Receive()
+	assert.NoError(t, err)
+	assert.Equal(t, "world", string(data))
+
+	// raw bytes read by the peer
+	sent := make([]byte, len(goridge.Prefix{})+5)
+	_, err = io.ReadFull(b, sent)
+	assert.NoError(t, err)
+
+	// buffered frames are flushed on close
+	assert.NoError(t, rl.Close())
+	assert.Equal(t, append(sent, received.Bytes()...), sink.Bytes())
+}
+
+func Test_ObserverRelay_Overflow(t *testing.T) {
+	a, b := connPair(t)
+	defer b.Close()
+
+	sink := &blockedWriter{release: make(chan interface{})}
+	o := newRelayObserver(sink, 100)
+	rl := &observerRelay{Relay: goridge.NewSocketRelay(a), observer: o}
+
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			if _, err := b.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	// slow sink does not block the relay
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, rl.Send(bytes.Repeat([]byte("a"), 40), goridge.PayloadRaw))
+	}
+
+	assert.True(t, o.droppedFrames() > 0)
+
+	close(sink.release)
+	assert.NoError(t, rl.Close())
+}
+
+func Test_Worker_Observe(t *testing.T) {
+	cmd := exec.Command("php", "tests/client.php", "echo", "pipes")
+
+	w, err := NewPipeFactory().SpawnWorker(cmd)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	go w.Wait()
+
+	sink := &lockedBuffer{}
+	w.Observe(sink, 0)
+	o := w.rl.(*observerRelay).observer
+
+	res, err := w.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", res.String())
+
+	assert.NoError(t, w.Stop())
+	<-o.done
+
+	captured := sink.Bytes()
+	assert.Equal(t, 2, bytes.Count(captured, []byte("hello")))
+	assert.Equal(t, int64(0), w.ObserverDropped())
+
+	// captured stream consists of valid frames
+	for len(captured) != 0 {
+		var p goridge.Prefix
+		copy(p[:], captured)
+		if !assert.True(t, p.Valid()) {
+			return
+		}
+
+		captured = captured[len(p)+int(p.Size()):]
+	}
+}
@@ -0,0 +1,76 @@
+package roadrunner
+
+import (
+	"context"
+	"sync"
+)
+
+// pauseGate holds new tasks while pool is paused, workers and running tasks are not affected.
+type pauseGate struct {
+	mu sync.Mutex
+
+	// closed once pool is resumed, nil while pool is not paused
+	resumed chan interface{}
+}
+
+// pause closes the gate, returns false if gate is closed already.
+func (g *pauseGate) pause() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.resumed != nil {
+		return false
+	}
+
+	g.resumed = make(chan interface{})
+	return true
+}
+
+// resume opens the gate and releases waiting tasks, returns false if gate is open already.
+func (g *pauseGate) resume() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.resumed == nil {
+		return false
+	}
+
+	close(g.resumed)
+	g.resumed = nil
+	return true
+}
+
+// paused returns true while gate is closed.
+func (g *pauseGate) paused() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.resumed != nil
+}
+
+// wait waits for the gate to open, ErrPoolPaused is returned without waiting when reject is set.
+// Returns ErrPoolDestroyed once destroy is closed or context error when context is done.
+func (g *pauseGate) wait(ctx context.Context, reject bool, destroy chan interface{}) error {
+	for {
+		g.mu.Lock()
+		resumed := g.resumed
+		g.mu.Unlock()
+
+		if resumed == nil {
+			return nil
+		}
+
+		if reject {
+			return ErrPoolPaused
+		}
+
+		select {
+		case <-resumed:
+			// pool might be paused again before the task passes
+		case <-destroy:
+			return ErrPoolDestroyed
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
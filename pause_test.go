@@ -0,0 +1,51 @@
+package roadrunner
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func Test_PauseGate(t *testing.T) {
+	g := pauseGate{}
+	destroy := make(chan interface{})
+
+	assert.False(t, g.paused())
+	assert.NoError(t, g.wait(context.Background(), false, destroy))
+
+	assert.True(t, g.pause())
+	assert.False(t, g.pause())
+	assert.True(t, g.paused())
+
+	assert.Equal(t, ErrPoolPaused, g.wait(context.Background(), true, destroy))
+
+	done := make(chan error)
+	go func() {
+		done <- g.wait(context.Background(), false, destroy)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("task passed the paused gate")
+	case <-time.After(time.Millisecond * 50):
+	}
+
+	assert.True(t, g.resume())
+	assert.False(t, g.resume())
+	assert.NoError(t, <-done)
+	assert.False(t, g.paused())
+}
+
+func Test_PauseGate_Cancel(t *testing.T) {
+	g := pauseGate{}
+	destroy := make(chan interface{})
+	g.pause()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, g.wait(ctx, false, destroy))
+
+	close(destroy)
+	assert.Equal(t, ErrPoolDestroyed, g.wait(context.Background(), false, destroy))
+}
@@ -1,16 +1,121 @@
 package roadrunner
 
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	json "github.com/json-iterator/go"
+)
+
+// RequestIDKey is the key of the task context frame carrying Payload.RequestID once pool is
+// configured to send it (see Config.SendRequestID), for example {"request_id":"4f2c9e1ab0d3c7e5"}.
+// ID is added to the task context when context is empty or JSON object without such key, other
+// contexts are sent unchanged.
+const RequestIDKey = "request_id"
+
 // Payload carries binary header and body to workers and
-// back to the server.
+// back to the server. Context and body are transferred as two
+// separate frames, context frame is always sent first.
 type Payload struct {
-	// Context represent payload context, might be omitted.
+	// Context represent payload context, might be omitted. Empty context
+	// is sent as empty control frame and received as nil.
 	Context []byte
 
-	// body contains binary payload to be processed by worker.
+	// body contains binary payload to be processed by worker. Empty body
+	// is sent as empty frame and received as nil.
 	Body []byte
+
+	// Idempotent allows pool to replay the task on another worker when worker dies or
+	// times out during the execution, see pool MaxExecRetries. Never set it for tasks
+	// which are not safe to be executed twice. Not sent to the worker.
+	Idempotent bool
+
+	// RequestID correlates the task with the caller side, for example with the trace span. Pool
+	// assigns random ID to the tasks without one (given payload is not modified), retried task
+	// keeps the ID. ID is included into worker errors, diagnostics and logs, pool passes it to the
+	// worker in task context under RequestIDKey once configured to.
+	RequestID string
+}
+
+// withRequestID returns payload carrying the request ID, the copy of the payload without one
+// receives random ID. ID is added to the context of the copy when send is true. Given payload is
+// never modified.
+func withRequestID(rqs *Payload, send bool) *Payload {
+	if rqs.RequestID != "" && !send {
+		return rqs
+	}
+
+	identified := *rqs
+	if identified.RequestID == "" {
+		identified.RequestID = newRequestID()
+	}
+
+	if send {
+		if header, ok := withContextKey(rqs.Context, RequestIDKey, identified.RequestID); ok {
+			identified.Context = header
+		}
+	}
+
+	return &identified
+}
+
+// withContextKey returns task context with the key prepended to the original fields, ok is false
+// when context is neither empty nor JSON object, or already has the key.
+func withContextKey(context []byte, key string, value string) (header []byte, ok bool) {
+	k, _ := json.Marshal(key)
+	v, _ := json.Marshal(value)
+	header = append(append(append(append([]byte{'{'}, k...), ':'), v...), '}')
+
+	body := bytes.TrimSpace(context)
+	if len(body) == 0 {
+		return header, true
+	}
+
+	if body[0] != '{' {
+		return nil, false
+	}
+
+	var present map[string]json.RawMessage
+	if json.Unmarshal(body, &present) != nil {
+		return nil, false
+	}
+
+	if _, exists := present[key]; exists {
+		return nil, false
+	}
+
+	if len(present) == 0 {
+		return header, true
+	}
+
+	// original fields follow the key unchanged
+	return append(append(header[:len(header)-1:len(header)-1], ','), body[1:]...), true
+}
+
+// newRequestID returns random request ID.
+func newRequestID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return ""
+	}
+
+	return hex.EncodeToString(id)
 }
 
 // String returns payload body as string
 func (p *Payload) String() string {
 	return string(p.Body)
 }
+
+// checkPayload returns ErrPayloadTooLarge if payload context or body exceeds max bytes, 0 for unlimited.
+func checkPayload(p *Payload, max int64) error {
+	if p == nil || max == 0 {
+		return nil
+	}
+
+	if int64(len(p.Context)) > max || int64(len(p.Body)) > max {
+		return ErrPayloadTooLarge
+	}
+
+	return nil
+}
@@ -5,12 +5,26 @@ import (
 	"github.com/pkg/errors"
 	"github.com/spiral/goridge/v2"
 	"io"
+	"io/ioutil"
 	"os/exec"
+	"syscall"
+	"time"
 )
 
+var _ Factory = (*PipeFactory)(nil)
+
 // PipeFactory connects to workers using standard
 // streams (STDIN, STDOUT pipes).
 type PipeFactory struct {
+	// ProtocolConstraint defines worker protocol versions accepted by the factory, example:
+	// ">=2.0.0 <3.0.0". Empty value disables the version check.
+	ProtocolConstraint string
+
+	// ResourceLimits applied to every spawned worker process.
+	ResourceLimits ResourceLimits
+
+	// Codec defines how payload bodies are encoded, must match the worker. Raw when nil.
+	Codec Codec
 }
 
 // NewPipeFactory returns new factory instance and starts
@@ -31,6 +45,11 @@ func (f *PipeFactory) SpawnWorker(cmd *exec.Cmd) (w *Worker, err error) {
 		out io.WriteCloser
 	)
 
+	if _, captured := cmd.Stdout.(*stdoutCapture); captured {
+		// stdout is used by the relay
+		cmd.Stdout = nil
+	}
+
 	if in, err = cmd.StdoutPipe(); err != nil {
 		return nil, err
 	}
@@ -39,35 +58,35 @@ func (f *PipeFactory) SpawnWorker(cmd *exec.Cmd) (w *Worker, err error) {
 		return nil, err
 	}
 
-	w.rl = goridge.NewPipeRelay(in, out)
+	w.frames = newFrameReader(in)
+	w.rl = goridge.NewPipeRelay(ioutil.NopCloser(w.frames), out)
+	w.stream, _ = in.(syscall.Conn)
 
+	w.limits = f.ResourceLimits
 	if err := w.start(); err != nil {
 		return nil, errors.Wrap(err, "process error")
 	}
 
-	if pid, err := fetchPID(w.rl); pid != *w.Pid {
-		go func(w *Worker) {
-			err := w.Kill()
-			if err != nil {
-				// there is no logger here, how to handle error in goroutines ?
-				fmt.Println(fmt.Sprintf("error killing the worker with PID number %d, Created: %s", w.Pid, w.Created))
-			}
-		}(w)
-
-		if wErr := w.Wait(); wErr != nil {
-			if _, ok := wErr.(*exec.ExitError); ok {
-				// error might be nil here
-				if err != nil {
-					err = errors.Wrap(wErr, err.Error())
-				}
-			} else {
-				err = wErr
-			}
-		}
+	connected := time.Now()
+	link, err := handshake(w.rl, "", "")
+	if err == nil && link.Pid != *w.Pid {
+		err = fmt.Errorf("unexpected pid %v", link.Pid)
+	}
 
-		return nil, errors.Wrap(err, "unable to connect to worker")
+	if err != nil {
+		return nil, w.failStart(err)
+	}
+	w.meta = link.meta()
+	w.relayWait = time.Since(connected)
+
+	if f.ProtocolConstraint != "" {
+		if err := w.checkProtocol(f.ProtocolConstraint); err != nil {
+			return nil, w.failStart(errors.Wrap(err, "protocol"))
+		}
 	}
 
+	w.Transport = "pipes"
+	w.codec = f.Codec
 	w.state.set(StateReady)
 	return w, nil
 }
@@ -66,6 +66,19 @@ func Test_Pipe_Failboot(t *testing.T) {
 	assert.Contains(t, err.Error(), "failboot")
 }
 
+func Test_Pipe_Failboot_Stderr(t *testing.T) {
+	cmd := exec.Command("sh", "-c", "echo boot error >&2; exit 1")
+	w, err := NewPipeFactory().SpawnWorker(cmd)
+
+	assert.Nil(t, w)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "worker start failed: boot error")
+
+	if wErr, ok := err.(WorkerError); assert.True(t, ok) {
+		assert.Equal(t, "boot error\n", string(wErr.Stderr))
+	}
+}
+
 func Test_Pipe_Invalid(t *testing.T) {
 	cmd := exec.Command("php", "tests/invalid.php")
 
@@ -161,3 +174,24 @@ func Benchmark_Pipe_Worker_ExecEcho(b *testing.B) {
 		}
 	}
 }
+
+func Test_Pipe_ProtocolConstraint(t *testing.T) {
+	cmd := exec.Command("php", "tests/client.php", "echo", "pipes")
+
+	w, err := (&PipeFactory{ProtocolConstraint: ">=2.0.0 <3.0.0"}).SpawnWorker(cmd)
+	assert.NoError(t, err)
+	defer w.Stop()
+
+	res, err := w.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", res.String())
+}
+
+func Test_Pipe_ProtocolConstraint_Mismatch(t *testing.T) {
+	cmd := exec.Command("php", "tests/client.php", "echo", "pipes")
+
+	w, err := (&PipeFactory{ProtocolConstraint: ">=3.0.0"}).SpawnWorker(cmd)
+	assert.Nil(t, w)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "does not satisfy `>=3.0.0`")
+}
@@ -1,5 +1,11 @@
 package roadrunner
 
+import (
+	"context"
+	"os/exec"
+	"time"
+)
+
 const (
 	// EventWorkerConstruct thrown when new worker is spawned.
 	EventWorkerConstruct = iota + 100
@@ -18,6 +24,12 @@ const (
 
 	// EventPoolError caused on pool wide errors
 	EventPoolError
+
+	// EventWorkerReload thrown when worker has been replaced by the reload (passed with WorkerReload).
+	EventWorkerReload
+
+	// EventPoolResize thrown when pool has been resized (passed with ResizeReport).
+	EventPoolResize
 )
 
 // Pool managed set of inner worker processes.
@@ -28,7 +40,8 @@ type Pool interface {
 	// Exec one task with given payload and context, returns result or error.
 	Exec(rqs *Payload) (rsp *Payload, err error)
 
-	// Workers returns worker list associated with the pool.
+	// Workers returns copy of the worker list associated with the pool. Returned workers are
+	// managed by the pool and must never be used to Exec directly.
 	Workers() (workers []*Worker)
 
 	// Remove forces pool to remove specific worker. Return true is this is first remove request on given worker.
@@ -37,3 +50,233 @@ type Pool interface {
 	// Destroy all underlying workers (but let them to complete the task).
 	Destroy()
 }
+
+// ManagedPool is the pool supporting the full set of task execution and worker management
+// operations, implemented by all pools of this package. Pool wrappers (CompositePool, CanaryPool,
+// GroupPool) require their inner pools to implement it, custom Pool implementations may implement
+// the subset they need.
+type ManagedPool interface {
+	Pool
+
+	// Events returns channel receiving state transition of every pool worker, slow consumer
+	// loses events instead of blocking the workers. Channel is closed by StopEvents or once pool
+	// is destroyed.
+	Events() <-chan WorkerEvent
+
+	// StopEvents closes the channel returned by Events.
+	StopEvents(c <-chan WorkerEvent)
+
+	// ExecContext executes the task until context is done, context error is returned for the
+	// canceled task. See Worker.ExecContext for cancellation details.
+	ExecContext(ctx context.Context, rqs *Payload) (rsp *Payload, err error)
+
+	// ExecWithMeta executes the task like Exec and describes the worker which executed it, for
+	// example to correlate the task with the worker logs.
+	ExecWithMeta(rqs *Payload) (rsp *Payload, meta ExecMeta, err error)
+
+	// ExecBatch executes multiple small tasks on a single worker in one exchange, responses and
+	// errors are indexed as tasks.
+	ExecBatch(rqs []*Payload) (rsp []*Payload, errs []error)
+
+	// ExecPriority executes the task like Exec, task waiting for the free worker is served before
+	// the waiting tasks of the lower priority (see PriorityLow, PriorityNormal and PriorityHigh).
+	ExecPriority(rqs *Payload, priority int) (rsp *Payload, err error)
+
+	// ExecSticky executes the task preferring the worker which executed previous tasks of the
+	// same key, affinity is best-effort only.
+	ExecSticky(key string, rqs *Payload) (rsp *Payload, err error)
+
+	// ExecFresh executes the task on the worker spawned for this task only and destroyed
+	// afterwards, task never shares state with other tasks. Expensive (process is spawned on
+	// every call), intended for rare administrative tasks.
+	ExecFresh(rqs *Payload) (rsp *Payload, err error)
+
+	// ExecAsync sends the task to the worker and returns once worker acknowledged receipt of the
+	// task, response is discarded. Worker is not allocated to other tasks until it completes the
+	// task, see Worker.ExecAsync for the protocol.
+	ExecAsync(rqs *Payload) error
+
+	// Use attaches middleware wrapping execution of the tasks, first attached middleware runs
+	// outermost. Middleware can short-circuit the task without passing it to the worker.
+	Use(m Middleware)
+
+	// SetFallback attaches function serving tasks inline when worker can not be allocated (circuit
+	// breaker is open, queue is full or allocation timed out) instead of failing them, nil
+	// disables the fallback. Disabled by default.
+	SetFallback(f FallbackFunc)
+
+	// RegisterHandler registers host function workers can call by name during the task, nil
+	// removes the handler.
+	RegisterHandler(name string, fn func([]byte) ([]byte, error))
+
+	// TryExec executes the task only if free worker is immediately available, acquired is false
+	// when all workers are busy and task has not been executed.
+	TryExec(rqs *Payload) (rsp *Payload, acquired bool, err error)
+
+	// Allocate checks out idle worker for the exclusive use, waits for the free worker until
+	// context is done or allocate timeout is reached. Worker must be returned using Release.
+	Allocate(ctx context.Context) (*Worker, error)
+
+	// Detach takes idle worker with the given PID out of the pool and passes it to the caller
+	// which becomes responsible for stopping it, pool spawns the replacement.
+	Detach(pid int) (*Worker, error)
+
+	// Release returns allocated worker to the pool, broken worker is destroyed and replaced.
+	// Hijacked worker is replaced without being stopped.
+	Release(w *Worker, broken bool)
+
+	// SetCommand replaces the command used to spawn new workers (including recycles and reloads),
+	// running workers keep running the old command until they are recycled. Pool never closes
+	// or rebinds the factory listener, new workers connect to the same socket.
+	SetCommand(cmd func(cfg WorkerConfig) *exec.Cmd)
+
+	// ReloadWorker replaces the oldest worker with the new one.
+	ReloadWorker() error
+
+	// ReloadAll replaces all pool workers one by one waiting pause between the replacements.
+	ReloadAll(pause time.Duration) error
+
+	// OnWorkerDeath attaches callback invoked when worker dies unexpectedly, workers stopped by the
+	// pool (recycle, reload, removal or destroy) are not reported. Error is WaitError with exit code.
+	OnWorkerDeath(f func(pid int, err error))
+
+	// OnWorkerReady attaches hook invoked synchronously in the spawn path of every new worker, once
+	// worker has reached StateReady and before EventWorkerConstruct is thrown and worker receives
+	// any task. Worker is killed and treated as failed to start when hook returns error, failed
+	// start is retried the same way as any other spawn error. Workers running at the time of the
+	// call are not passed to the hook.
+	OnWorkerReady(f func(w *Worker) error)
+
+	// OnWorkerDestroy attaches hook invoked once process of any pool worker exits for any reason,
+	// including pool destroy. Workers failed OnWorkerReady are not passed to the hook. Hook runs
+	// after the process exit, possibly concurrently with the worker replacement.
+	OnWorkerDestroy(f func(w *Worker))
+
+	// Stats returns point in time pool statistics.
+	Stats() PoolStats
+
+	// Dump returns snapshots of all pool workers, heavier than Stats and intended for the
+	// occasional diagnostics.
+	Dump() []WorkerSnapshot
+
+	// MarshalState returns compact binary encoding of the pool stats and snapshots of all pool
+	// workers for the external tooling polling the pool state, see UnmarshalState and
+	// StateVersion.
+	MarshalState() ([]byte, error)
+
+	// Healthy verifies that pool has enough alive workers and pings one idle worker, returns
+	// error describing the problem if pool is unhealthy.
+	Healthy() (bool, error)
+
+	// WaitReady waits until pool has started enough workers to serve the tasks during the cold
+	// start, returns context error when context is done first.
+	WaitReady(ctx context.Context) error
+
+	// Pause stops dispatching of new tasks, tasks wait for Resume or fail with ErrPoolPaused
+	// depending on the pool configuration. Running tasks are completed and workers are kept alive.
+	Pause()
+
+	// Resume restarts dispatching of the tasks held since Pause.
+	Resume()
+
+	// Drain pauses the pool and waits for the active tasks until context is done, workers still
+	// busy once context is done are killed. Pool keeps its workers, Resume restarts dispatching.
+	Drain(ctx context.Context) (DrainReport, error)
+}
+
+// WorkerReload describes worker replacement made by the pool reload.
+type WorkerReload struct {
+	// Old worker being retired.
+	Old *Worker
+
+	// New worker replacing the old one.
+	New *Worker
+}
+
+// ResizeReport describes the pool resize, see StaticPool.Resize.
+type ResizeReport struct {
+	// Size contains number of pool workers after the resize.
+	Size int
+
+	// Added contains number of spawned workers.
+	Added int
+
+	// Removed contains number of retired workers, busy workers included.
+	Removed int
+}
+
+// ExecMeta describes the worker which executed the task, captured before worker is returned to
+// the pool.
+type ExecMeta struct {
+	// Pid of the worker process.
+	Pid int
+
+	// NumExecs contains number of worker executions including the task.
+	NumExecs int64
+
+	// Duration of the task execution by the worker, worker allocation is not included.
+	Duration time.Duration
+
+	// PeakMemory contains peak memory usage in bytes reported by the worker for the task, 0 when
+	// worker does not report it (see PeakMemoryField).
+	PeakMemory uint64
+
+	// ServedBy describes whether task has been executed by the worker or served by the fallback,
+	// other fields are empty for the fallback.
+	ServedBy ServedBy
+}
+
+// PoolStats contains pool worker counts and task statistics.
+type PoolStats struct {
+	// NumWorkers contains number of workers registered in the pool.
+	NumWorkers int
+
+	// NumIdle contains number of workers ready to accept the task.
+	NumIdle int
+
+	// NumBusy contains number of workers executing the task or being stopped.
+	NumBusy int
+
+	// TotalExecs contains number of tasks executed by the pool workers.
+	TotalExecs int64
+
+	// TotalErrors contains number of tasks failed with job or worker error.
+	TotalErrors int64
+
+	// Queued contains number of tasks waiting for the free worker.
+	Queued int
+
+	// QueuedByPriority contains number of tasks waiting for the free worker indexed by requested
+	// priority, promoted tasks are counted by the priority they have been queued with.
+	QueuedByPriority [NumPriorities]int
+
+	// Breaker contains state of the worker spawn circuit breaker.
+	Breaker BreakerState
+
+	// Paused is true when pool does not dispatch new tasks.
+	Paused bool
+
+	// Quarantined contains number of worker slots which are not respawned due to repeated
+	// failures, see Config.QuarantineThreshold.
+	Quarantined int
+
+	// PeakMemory contains the highest task peak memory in bytes reported by the workers since
+	// pool creation, 0 when workers do not report it (see PeakMemoryField).
+	PeakMemory uint64
+
+	// RespawnRate contains number of dead workers replaced within the last second, see
+	// Config.RespawnRateLimit.
+	RespawnRate float64
+
+	// RollingReplaceCadence contains interval between two replacements of the rolling
+	// replacement, 0 when disabled. See Config.RollingReplaceInterval.
+	RollingReplaceCadence time.Duration
+
+	// TotalMemory contains memory in bytes accounted to all workers by the last sample, 0 unless
+	// Config.MaxTotalMemory is set.
+	TotalMemory uint64
+
+	// RecycleReasons contains number of workers stopped by the pool or died since pool creation
+	// by reason, workers stopped by the pool destroy are not included.
+	RecycleReasons map[RecycleReason]int64
+}
@@ -0,0 +1,887 @@
+package roadrunner
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// poolCore implements worker lifecycle shared by StaticPool and DynamicPool: task execution by
+// the allocated worker, worker spawn, reload and destruction, events and callbacks. Worker
+// allocation, release and replacement are provided by the pool embedding the core, see corePool.
+type poolCore struct {
+	// options shared by the pool configs
+	opts poolOptions
+
+	// pool embedding the core
+	pool corePool
+
+	// creates and connects to workers
+	factory Factory
+
+	// indicates that factory is closed along with the pool, see NewPoolFromConfig
+	ownsFactory bool
+
+	// active task executions
+	tmu   sync.Mutex
+	tasks sync.WaitGroup
+
+	// workers allocated by the pool users, see Allocate
+	allocated checkouts
+
+	// pauses worker spawning once workers repeatedly fail to start
+	breaker *breaker
+
+	// number of tasks waiting for a worker
+	waiting int64
+
+	// number of executed and failed tasks
+	numExecs  int64
+	numErrors int64
+
+	// highest task peak memory reported by the workers, accessed atomically
+	peakMemory uint64
+
+	// wraps execution of the tasks, see Use
+	middleware middlewareChain
+
+	// serves tasks when no worker is available, see SetFallback
+	fallback fallback
+
+	// host functions workers can call during the task, see RegisterHandler
+	handlers handlers
+
+	// worker state transitions, see Events
+	events eventHub
+
+	// holds new tasks while pool is paused
+	pause pauseGate
+
+	// invalid declares set of workers to be removed from the pool.
+	remove sync.Map
+
+	// serializes worker reloads and stores retired workers which must not be replaced
+	reload  sync.Mutex
+	retired sync.Map
+
+	// workers stopped by the pool on purpose, their death is not reported to OnWorkerDeath
+	recycled sync.Map
+
+	// workers passed to the caller by Detach, their exit is ignored
+	detached sync.Map
+
+	// reasons of the stopped workers
+	recycles recycleStats
+
+	// callbacks of the worker spawn and exit
+	hooks workerHooks
+
+	// pool is being destroyed
+	inDestroy int32
+	destroy   chan interface{}
+
+	// lsn is optional callback to handle worker create/destruct/error events.
+	mul sync.Mutex
+	lsn func(event int, ctx interface{})
+
+	// death is optional callback to handle unexpected worker exits, protected by mul
+	death func(pid int, err error)
+
+	// receives worker lifecycle messages, protected by mul
+	log Logger
+}
+
+// corePool is implemented by the pools embedding poolCore.
+type corePool interface {
+	// Workers returns copy of the worker list.
+	Workers() []*Worker
+
+	// Stats returns point in time pool statistics.
+	Stats() PoolStats
+
+	// Allocate checks out idle worker for the exclusive use.
+	Allocate(ctx context.Context) (*Worker, error)
+
+	// Release returns allocated worker to the pool.
+	Release(w *Worker, broken bool)
+
+	// allocateWorker finds free worker for the task of normal priority.
+	allocateWorker(ctx context.Context) (*Worker, error)
+
+	// allocatePriority finds free worker for the task of the given priority.
+	allocatePriority(ctx context.Context, priority int) (*Worker, error)
+
+	// stickWorker returns free worker preferred by the key instead of the given one if available.
+	stickWorker(w *Worker, key string) *Worker
+
+	// execTask executes the task using workers provided by the given allocation function.
+	execTask(ctx context.Context, rqs *Payload, meta *ExecMeta, allocate func(ctx context.Context) (*Worker, error)) (*Payload, error)
+
+	// release releases or replaces the worker once task is complete.
+	release(w *Worker)
+
+	// succeeded registers successful task of the worker.
+	succeeded(w *Worker)
+
+	// command returns the command used to spawn new workers.
+	command() func(cfg WorkerConfig) *exec.Cmd
+
+	// spawnReplacement creates worker taking the slot of the given one.
+	spawnReplacement(w *Worker) (*Worker, error)
+
+	// freeChan returns current free workers buf.
+	freeChan() chan *Worker
+
+	// push passes worker to the next waiting task or returns it to the free workers buf.
+	push(w *Worker)
+
+	// inspectWorkers passes all idle workers through the idle check.
+	inspectWorkers()
+}
+
+// setup initializes the core of the given pool, must be called before pool is used.
+func (p *poolCore) setup(pool corePool, factory Factory, opts poolOptions) {
+	p.opts, p.pool, p.factory = opts, pool, factory
+	p.destroy = make(chan interface{})
+	p.breaker = newBreaker(opts.BreakerThreshold, opts.BreakerWindow, opts.BreakerCooldown)
+}
+
+// Events returns channel receiving state transition of every pool worker, transition to
+// StateReady made during the worker start is reported once worker joins the pool. Events are
+// dropped while WorkerEventBuffer events are waiting for the slow consumer, workers are never
+// blocked. Channel is closed by StopEvents or once pool is destroyed, after final transitions
+// of the destroyed workers.
+func (p *poolCore) Events() <-chan WorkerEvent {
+	return p.events.subscribe()
+}
+
+// StopEvents closes the channel returned by Events.
+func (p *poolCore) StopEvents(c <-chan WorkerEvent) {
+	p.events.unsubscribe(c)
+}
+
+// Listen attaches pool event controller.
+func (p *poolCore) Listen(l func(event int, ctx interface{})) {
+	p.mul.Lock()
+	defer p.mul.Unlock()
+
+	p.lsn = l
+
+	for _, w := range p.pool.Workers() {
+		w.err.Listen(p.lsn)
+	}
+}
+
+// OnWorkerDeath attaches callback invoked when worker dies unexpectedly. Workers stopped by
+// the pool on purpose (recycled, reloaded, removed or destroyed) are not reported. Error
+// is always WaitError containing worker exit code.
+func (p *poolCore) OnWorkerDeath(f func(pid int, err error)) {
+	p.mul.Lock()
+	defer p.mul.Unlock()
+
+	p.death = f
+}
+
+// OnWorkerReady attaches hook invoked for every new worker before it enters the pool, worker
+// is killed and treated as failed to start when hook returns error.
+func (p *poolCore) OnWorkerReady(f func(w *Worker) error) {
+	p.hooks.setReady(f)
+}
+
+// OnWorkerDestroy attaches hook invoked once worker process exits.
+func (p *poolCore) OnWorkerDestroy(f func(w *Worker)) {
+	p.hooks.setDestroy(f)
+}
+
+// SetLogger attaches logger to receive worker lifecycle messages.
+func (p *poolCore) SetLogger(l Logger) {
+	p.mul.Lock()
+	defer p.mul.Unlock()
+
+	p.log = l
+}
+
+// Dump returns snapshots of all pool workers. Only worker list copy is taken under the lock,
+// worker snapshots are taken afterwards without blocking the pool.
+func (p *poolCore) Dump() []WorkerSnapshot {
+	workers := p.pool.Workers()
+
+	snapshots := make([]WorkerSnapshot, 0, len(workers))
+	for _, w := range workers {
+		snapshots = append(snapshots, w.Snapshot())
+	}
+
+	return snapshots
+}
+
+// MarshalState returns binary encoding of the pool stats and worker snapshots, see
+// UnmarshalState.
+func (p *poolCore) MarshalState() ([]byte, error) {
+	return marshalState(p.pool.Stats(), p.Dump())
+}
+
+// queued returns number of the tasks waiting for a worker.
+func (p *poolCore) queued() int {
+	return int(atomic.LoadInt64(&p.waiting))
+}
+
+// Remove forces pool to remove specific worker.
+func (p *poolCore) Remove(w *Worker, err error) bool {
+	if w.State().Value() != StateReady && w.State().Value() != StateWorking {
+		// unable to remove inactive worker
+		return false
+	}
+
+	if _, ok := p.remove.Load(w); ok {
+		return false
+	}
+
+	p.remove.Store(w, err)
+	return true
+}
+
+// Exec one task with given payload and context, returns result or error.
+func (p *poolCore) Exec(rqs *Payload) (rsp *Payload, err error) {
+	return p.ExecContext(context.Background(), rqs)
+}
+
+// ExecContext executes the task until context is done, context error is returned for the
+// canceled task. Worker waiting and execution are both canceled.
+func (p *poolCore) ExecContext(ctx context.Context, rqs *Payload) (rsp *Payload, err error) {
+	return p.exec(ctx, rqs, nil, p.pool.allocateWorker)
+}
+
+// ExecWithMeta executes the task like Exec and describes the worker which executed it, meta
+// describes the last attempt when task has been retried. Meta is empty when no worker has been
+// allocated.
+func (p *poolCore) ExecWithMeta(rqs *Payload) (rsp *Payload, meta ExecMeta, err error) {
+	rsp, err = p.exec(context.Background(), rqs, &meta, p.pool.allocateWorker)
+	return rsp, meta, err
+}
+
+// ExecBatch executes tasks on a single worker in one exchange, see Worker.ExecBatch. Responses and
+// errors are indexed as tasks, all tasks fail with the same error when worker can not be allocated
+// or batch fails as a whole. Config.ExecTimeout applies to the whole batch, tasks are never retried.
+func (p *poolCore) ExecBatch(rqs []*Payload) (rsp []*Payload, errs []error) {
+	if len(rqs) == 0 {
+		return nil, nil
+	}
+
+	w, err := p.pool.Allocate(context.Background())
+	if err != nil {
+		return nil, batchErrors(len(rqs), err)
+	}
+
+	var timer *time.Timer
+	if p.opts.ExecTimeout != 0 {
+		timer = time.AfterFunc(p.opts.ExecTimeout, func() {
+			atomic.StoreInt32(&w.timedOut, 1)
+			_ = w.Kill()
+		})
+	}
+
+	rsp, errs = w.ExecBatch(rqs)
+	if timer != nil && !timer.Stop() {
+		rsp, errs = nil, batchErrors(len(rqs), ErrExecTimeout)
+	}
+
+	atomic.AddInt64(&p.numExecs, int64(len(rqs)))
+	for _, err := range errs {
+		if err != nil {
+			atomic.AddInt64(&p.numErrors, 1)
+		}
+	}
+
+	p.pool.Release(w, false)
+	return rsp, errs
+}
+
+// ExecPriority executes the task like Exec, task waiting for the free worker is served before the
+// waiting tasks of the lower priority. Priority is limited to PriorityLow and PriorityHigh, see
+// Config.PriorityAging to prevent starvation of the low priority tasks.
+func (p *poolCore) ExecPriority(rqs *Payload, priority int) (rsp *Payload, err error) {
+	return p.exec(context.Background(), rqs, nil, func(ctx context.Context) (*Worker, error) {
+		return p.pool.allocatePriority(ctx, priority)
+	})
+}
+
+// ExecSticky executes the task preferring the worker which executed previous tasks of the same
+// key, for example to reuse per user state kept by the worker. Affinity is best-effort only: task
+// runs on any free worker when preferred one is busy, dead or recycled and the new worker is
+// remembered for the key. Keys might share the preferred worker.
+func (p *poolCore) ExecSticky(key string, rqs *Payload) (rsp *Payload, err error) {
+	return p.exec(context.Background(), rqs, nil, func(ctx context.Context) (*Worker, error) {
+		w, err := p.pool.allocateWorker(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		return p.pool.stickWorker(w, key), nil
+	})
+}
+
+// Use attaches middleware wrapping Exec, ExecContext, ExecWithMeta, ExecPriority and ExecSticky
+// tasks started afterwards, first attached middleware runs outermost. Middleware wraps the task as
+// a whole, retries of the task within the pool pass the chain once.
+func (p *poolCore) Use(m Middleware) {
+	p.middleware.use(m)
+}
+
+// SetFallback attaches function serving Exec, ExecContext, ExecWithMeta, ExecPriority and
+// ExecSticky tasks which can not be allocated the worker because circuit breaker is open, queue
+// is full or allocation timed out. Fallback receives payload body and returns response body,
+// ExecMeta of the served task reports ServedByFallback. Nil disables the fallback.
+func (p *poolCore) SetFallback(f FallbackFunc) {
+	p.fallback.set(f)
+}
+
+// RegisterHandler registers host function workers can call by name in the middle of the task,
+// for example to fetch a secret, see callCommand for the framing. Handler receives the call
+// argument and returns the result passed back to the worker, handler error is passed to the
+// worker as well and does not fail the task. Handlers run on the goroutine executing the task
+// and must be safe for concurrent use. Applies to running workers, nil removes the handler.
+func (p *poolCore) RegisterHandler(name string, fn func([]byte) ([]byte, error)) {
+	p.handlers.register(name, fn)
+}
+
+// exec passes the task through the middleware chain, see execTask.
+func (p *poolCore) exec(ctx context.Context, rqs *Payload, meta *ExecMeta, allocate func(ctx context.Context) (*Worker, error)) (rsp *Payload, err error) {
+	ctx, span := tracerOrNop(p.opts.Tracer).StartSpan(ctx, SpanExec)
+	defer func() { endSpan(span, err) }()
+
+	return p.middleware.exec(ctx, rqs, func(ctx context.Context, rqs *Payload) (*Payload, error) {
+		return p.pool.execTask(ctx, rqs, meta, allocate)
+	})
+}
+
+// ExecFresh executes the task on the dedicated worker spawned for this task only, worker is
+// destroyed afterwards and never executes other tasks. Spawns the process on every call, intended
+// for rare administrative tasks (migrations, isolated scripts) which must not share the state of
+// warm workers. Fresh worker does not count towards the pool size, Config.ExecTimeout applies and
+// task is never retried.
+func (p *poolCore) ExecFresh(rqs *Payload) (rsp *Payload, err error) {
+	if p.destroyed() {
+		return nil, ErrPoolDestroyed
+	}
+
+	p.tmu.Lock()
+	if p.destroyed() {
+		p.tmu.Unlock()
+		return nil, ErrPoolDestroyed
+	}
+	p.tasks.Add(1)
+	p.tmu.Unlock()
+
+	defer p.tasks.Done()
+
+	if err := checkPayload(rqs, p.opts.MaxPayloadSize); err != nil {
+		return nil, err
+	}
+
+	w, err := p.spawnWorker(p.pool.command(), FreshWorkerIndex)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to spawn worker")
+	}
+
+	rsp, err = execFresh(w, rqs, p.opts.ExecTimeout)
+
+	atomic.AddInt64(&p.numExecs, 1)
+	if err != nil {
+		atomic.AddInt64(&p.numErrors, 1)
+	}
+
+	if w.State().Value() == StateReady {
+		p.destroyWorker(w, err)
+	} else if kerr := w.Kill(); kerr != nil {
+		p.throw(EventWorkerError, WorkerError{Worker: w, Caused: kerr})
+	}
+
+	return rsp, err
+}
+
+// ExecAsync executes the task without waiting for the result, see Worker.ExecAsync. Returns once
+// worker acknowledged receipt of the task, worker stays checked out until it completes the task and
+// is never allocated to other tasks in the meantime. Response is discarded, failed tasks are logged
+// and counted in stats. Config.ExecTimeout applies to the whole task, task is never retried. Drain and
+// Destroy wait for the running async tasks.
+func (p *poolCore) ExecAsync(rqs *Payload) error {
+	if rqs == nil {
+		return fmt.Errorf("payload can not be empty")
+	}
+
+	if err := checkPayload(rqs, p.opts.MaxPayloadSize); err != nil {
+		return err
+	}
+
+	rqs = withRequestID(rqs, p.opts.SendRequestID)
+
+	w, err := p.pool.Allocate(context.Background())
+	if err != nil {
+		return err
+	}
+
+	var timer *time.Timer
+	if p.opts.ExecTimeout != 0 {
+		timer = time.AfterFunc(p.opts.ExecTimeout, func() {
+			atomic.StoreInt32(&w.timedOut, 1)
+			_ = w.Kill()
+		})
+	}
+
+	pending, err := w.execAsync(rqs)
+	if err != nil {
+		p.completeAsync(w, rqs, timer, execResult{err: err})
+		return err
+	}
+
+	go func() {
+		p.completeAsync(w, rqs, timer, <-pending)
+	}()
+
+	return nil
+}
+
+// completeAsync accounts completed async task and returns the worker to the pool.
+func (p *poolCore) completeAsync(w *Worker, rqs *Payload, timer *time.Timer, r execResult) {
+	p.allocated.remove(w)
+	defer p.tasks.Done()
+
+	if timer != nil && !timer.Stop() {
+		r.err = ErrExecTimeout
+	}
+
+	atomic.AddInt64(&p.numExecs, 1)
+	if r.err != nil {
+		atomic.AddInt64(&p.numErrors, 1)
+		p.logger().Warn("async task failed", "pid", *w.Pid, "request", rqs.RequestID, "error", r.err)
+
+		if _, jobError := r.err.(JobError); !jobError {
+			p.discardWorker(w, execReason(r.err), r.err)
+			return
+		}
+	}
+
+	// worker want's to be terminated
+	if r.rsp != nil && r.rsp.Body == nil && string(r.rsp.Context) == StopRequest {
+		p.recycleWorker(w, RecycleStopRequest, nil)
+		return
+	}
+
+	p.pool.release(w)
+}
+
+// execWorker executes the task using allocated worker, releases or discards the worker afterwards.
+// stop is true when worker requested termination and task must be sent to another worker.
+func (p *poolCore) execWorker(ctx context.Context, w *Worker, rqs *Payload, meta *ExecMeta) (rsp *Payload, stop bool, err error) {
+	start := time.Now()
+	switch {
+	case ctx.Done() != nil && p.opts.ExecTimeout != 0:
+		tctx, cancel := context.WithTimeout(ctx, p.opts.ExecTimeout)
+		rsp, err = w.ExecContext(tctx, rqs)
+		cancel()
+
+		if err == context.DeadlineExceeded && ctx.Err() == nil {
+			err = ErrExecTimeout
+		}
+	case ctx.Done() != nil:
+		rsp, err = w.ExecContext(ctx, rqs)
+	case p.opts.ExecTimeout != 0:
+		rsp, err = w.ExecWithTimeout(rqs, p.opts.ExecTimeout)
+	default:
+		rsp, err = w.Exec(rqs)
+	}
+
+	// worker might be reused or recycled once released
+	peak := peakMemory(rsp)
+	if meta != nil {
+		*meta = ExecMeta{Pid: *w.Pid, NumExecs: w.State().NumExecs(), Duration: time.Since(start), PeakMemory: peak}
+	}
+
+	if peak != 0 {
+		observePeak(&p.peakMemory, peak)
+	}
+
+	if p.opts.SlowLogThreshold != 0 {
+		if d := time.Since(start); d >= p.opts.SlowLogThreshold {
+			p.logger().Warn("slow exec", "pid", *w.Pid, "request", rqs.RequestID, "duration", d, "size", len(rqs.Context)+len(rqs.Body))
+		}
+	}
+
+	atomic.AddInt64(&p.numExecs, 1)
+	if err != nil {
+		atomic.AddInt64(&p.numErrors, 1)
+
+		if err == ErrExecTimeout {
+			p.logger().Warn("worker exec timeout", "pid", *w.Pid, "request", rqs.RequestID, "diagnostics", w.Diagnostics())
+		}
+
+		if errors.Cause(err) == ErrUnexpectedFrame {
+			if p.malformedLimit(w) {
+				p.logger().Warn("worker sent too many malformed responses in a row, worker is replaced", "pid", *w.Pid, "malformed", w.ConsecutiveMalformed(), "error", err)
+				p.discardWorker(w, RecycleProtocolViolation, err)
+				return nil, false, err
+			}
+
+			if p.opts.ResyncTimeout != 0 && w.Resync(p.opts.ResyncTimeout) == nil {
+				p.logger().Warn("worker relay is out of sync, worker is resynced", "pid", *w.Pid, "error", err)
+				p.pool.release(w)
+				return nil, false, err
+			}
+
+			p.logger().Warn("worker relay is out of sync, worker is replaced", "pid", *w.Pid, "error", err)
+		}
+
+		if errors.Cause(err) == ErrTooManyFrames {
+			p.logger().Warn("worker exceeded response frame limit, worker is replaced", "pid", *w.Pid, "error", err)
+		}
+
+		// soft job errors are allowed
+		if _, jobError := err.(JobError); jobError {
+			p.pool.release(w)
+			return nil, false, err
+		}
+
+		// worker has responded to the cancel command
+		if (err == ctx.Err() || err == ErrExecTimeout) && w.State().Value() == StateReady {
+			p.pool.release(w)
+			return nil, false, err
+		}
+
+		p.discardWorker(w, execReason(err), err)
+		return nil, false, err
+	}
+
+	// worker want's to be terminated
+	if rsp.Body == nil && rsp.Context != nil && string(rsp.Context) == StopRequest {
+		p.recycleWorker(w, RecycleStopRequest, err)
+		return nil, true, nil
+	}
+
+	p.pool.succeeded(w)
+	p.pool.release(w)
+	return rsp, false, nil
+}
+
+// malformedLimit returns true once worker has sent MaxConsecutiveMalformed malformed responses in
+// a row and must not be kept even if relay could be resynced.
+func (p *poolCore) malformedLimit(w *Worker) bool {
+	return p.opts.MaxConsecutiveMalformed != 0 && w.ConsecutiveMalformed() >= p.opts.MaxConsecutiveMalformed
+}
+
+// ReloadWorker replaces the oldest worker with the new one, idle workers are preferred. Replacement
+// is started before the old worker is retired, worker busy with the task is retired once the task
+// is complete. EventWorkerReload is thrown on every replacement.
+func (p *poolCore) ReloadWorker() error {
+	p.reload.Lock()
+	defer p.reload.Unlock()
+
+	w := p.oldestWorker(p.pool.Workers())
+	if w == nil {
+		return fmt.Errorf("no workers to reload")
+	}
+
+	return p.reloadWorker(w, RecycleReload)
+}
+
+// ReloadAll replaces all pool workers one by one waiting pause between the replacements. Workers
+// spawned during the reload are not replaced.
+func (p *poolCore) ReloadAll(pause time.Duration) error {
+	p.reload.Lock()
+	defer p.reload.Unlock()
+
+	workers := p.pool.Workers()
+	for i := 0; len(workers) != 0; i++ {
+		w := p.oldestWorker(workers)
+		if w == nil {
+			return nil
+		}
+
+		for j, wc := range workers {
+			if wc == w {
+				workers = append(workers[:j], workers[j+1:]...)
+				break
+			}
+		}
+
+		if i != 0 && pause != 0 {
+			time.Sleep(pause)
+		}
+
+		if err := p.reloadWorker(w, RecycleReload); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// oldestWorker returns the oldest active worker from the list, idle workers are preferred.
+func (p *poolCore) oldestWorker(workers []*Worker) (oldest *Worker) {
+	for _, w := range workers {
+		if !w.State().IsActive() {
+			continue
+		}
+
+		if _, ok := p.retired.Load(w); ok {
+			continue
+		}
+
+		if oldest == nil {
+			oldest = w
+			continue
+		}
+
+		idle, oldestIdle := w.State().Value() == StateReady, oldest.State().Value() == StateReady
+		if (idle && !oldestIdle) || (idle == oldestIdle && w.Created.Before(oldest.Created)) {
+			oldest = w
+		}
+	}
+
+	return oldest
+}
+
+// reloadWorker spawns replacement of the given worker and retires it for the given reason, must be
+// called under reload lock.
+func (p *poolCore) reloadWorker(w *Worker, reason RecycleReason) error {
+	if p.destroyed() {
+		return ErrPoolDestroyed
+	}
+
+	// old worker must not be replaced on death
+	p.retired.Store(w, true)
+
+	nw, err := p.pool.spawnReplacement(w)
+	if err != nil {
+		p.retired.Delete(w)
+		return err
+	}
+
+	p.pool.push(nw)
+
+	p.recycles.mark(w, reason)
+	p.Remove(w, fmt.Errorf("worker reloaded"))
+	p.retireIdle(w)
+
+	p.throw(EventWorkerReload, WorkerReload{Old: w, New: nw})
+	return nil
+}
+
+// retireIdle discards worker if it's waiting in the free list, busy worker is discarded on release.
+func (p *poolCore) retireIdle(w *Worker) {
+	free := p.pool.freeChan()
+	for i := len(free); i > 0; i-- {
+		var wc *Worker
+		select {
+		case wc = <-free:
+			if wc == nil {
+				// free buf has been replaced
+				return
+			}
+		default:
+			return
+		}
+
+		if wc == w {
+			p.discardWorker(w, RecycleReload, fmt.Errorf("worker reloaded"))
+			return
+		}
+
+		if err, remove := p.remove.Load(wc); remove && wc.State().Value() == StateReady {
+			// worker replaced meanwhile must not return to the rotation
+			p.recycleWorker(wc, RecycleRemoved, err)
+			continue
+		}
+
+		p.pool.push(wc)
+	}
+}
+
+// Pause stops dispatching of new tasks until Resume is called, tasks wait for Resume or fail with
+// ErrPoolPaused when RejectWhenPaused is set. Running tasks are completed and workers are kept
+// alive. Pause can be called multiple times.
+func (p *poolCore) Pause() {
+	if p.pause.pause() {
+		p.logger().Info("pool paused")
+	}
+}
+
+// Resume restarts dispatching of the tasks held since Pause, does nothing when pool is not paused.
+func (p *poolCore) Resume() {
+	if p.pause.resume() {
+		p.logger().Info("pool resumed")
+	}
+}
+
+// waitTimeout returns for how long task can wait for the free worker.
+func (p *poolCore) waitTimeout() time.Duration {
+	if p.opts.MaxWait != 0 && p.opts.MaxWait < p.opts.AllocateTimeout {
+		return p.opts.MaxWait
+	}
+
+	return p.opts.AllocateTimeout
+}
+
+// inspect periodically checks idle workers for the unhealthy command until pool is destroyed.
+func (p *poolCore) inspect() {
+	ticker := time.NewTicker(p.opts.IdleCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.pool.inspectWorkers()
+		case <-p.destroy:
+			return
+		}
+	}
+}
+
+// spawnWorker creates new worker using given command without adding it to the worker list.
+func (p *poolCore) spawnWorker(cmd func(cfg WorkerConfig) *exec.Cmd, index int) (w *Worker, err error) {
+	if !p.breaker.allow() {
+		return nil, ErrPoolUnavailable
+	}
+
+	wc := newWorkerConfig(index, p.opts.WorkerIDPrefix)
+	c := cmd(wc)
+	p.captureStdout(c, wc)
+
+	ctx, span := tracerOrNop(p.opts.Tracer).StartSpan(context.Background(), SpanSpawn)
+	defer func() { endSpan(span, err) }()
+
+	w, err = spawnContext(ctx, p.factory, c)
+	if err == nil {
+		err = p.opts.CPUAffinity.pin(w, index)
+	}
+
+	if err == nil {
+		err = p.hooks.prepare(w)
+	}
+
+	if p.breaker.done(err) {
+		p.logger().Error("worker spawn paused", "cooldown", p.opts.BreakerCooldown, "error", err)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	w.ID = wc.ID
+	w.SetMaxPayloadSize(p.opts.MaxPayloadSize)
+	w.SetMaxResponseFrames(p.opts.MaxResponseFrames)
+	w.handlers = &p.handlers
+	w.SetIdleTimeout(p.opts.IdleReadTimeout)
+	w.SetResyncTimeout(p.opts.ResyncTimeout)
+	w.SetConcurrency(int(p.opts.WorkerConcurrency))
+
+	p.mul.Lock()
+	if p.lsn != nil {
+		w.err.Listen(p.lsn)
+	}
+	p.mul.Unlock()
+
+	p.throw(EventWorkerConstruct, w)
+	return w, nil
+}
+
+// captureStdout attaches stdout capture to the worker command according to the StdoutMode option.
+func (p *poolCore) captureStdout(cmd *exec.Cmd, wc WorkerConfig) {
+	var out io.Writer
+	if p.opts.StdoutMode == StdoutForward && p.opts.StdoutWriter != nil {
+		out = p.opts.StdoutWriter(wc)
+	}
+
+	captureStdout(cmd, p.opts.StdoutMode, wc, out, p.logger)
+}
+
+// recycleWorker discards worker which is stopped on purpose and must not be reported as dead.
+func (p *poolCore) recycleWorker(w *Worker, reason RecycleReason, caused interface{}) {
+	p.recycled.Store(w, true)
+	p.discardWorker(w, reason, caused)
+}
+
+// gentry remove worker
+func (p *poolCore) discardWorker(w *Worker, reason RecycleReason, caused interface{}) {
+	p.recycles.mark(w, reason)
+	w.markInvalid()
+	go p.destroyWorker(w, caused)
+}
+
+// destroyWorker destroys workers and removes it from the pool.
+func (p *poolCore) destroyWorker(w *Worker, caused interface{}) {
+	go func() {
+		err := w.Stop()
+		if err != nil {
+			p.throw(EventWorkerError, WorkerError{Worker: w, Caused: err})
+		}
+	}()
+
+	if exited, _ := w.WaitTimeout(p.opts.DestroyTimeout); exited {
+		// worker is dead
+		p.throw(EventWorkerDestruct, w)
+		return
+	}
+
+	// failed to stop process in given time
+	p.logger().Warn("worker killed after destroy timeout", "pid", *w.Pid, "timeout", p.opts.DestroyTimeout)
+
+	// broken workers are killed right away
+	grace := time.Duration(0)
+	if _, recycled := p.recycled.Load(w); recycled {
+		grace = p.opts.KillGracePeriod
+	}
+
+	if err := w.KillGraceful(grace); err != nil {
+		p.throw(EventWorkerError, WorkerError{Worker: w, Caused: err})
+	}
+
+	p.throw(EventWorkerKill, w)
+}
+
+// logger returns attached logger or no-op logger.
+func (p *poolCore) logger() Logger {
+	p.mul.Lock()
+	defer p.mul.Unlock()
+
+	if p.log == nil {
+		return nopLogger{}
+	}
+
+	return p.log
+}
+
+func (p *poolCore) destroyed() bool {
+	return atomic.LoadInt32(&p.inDestroy) != 0
+}
+
+// notifyDeath reports unexpected worker death to the attached callback, if any.
+func (p *poolCore) notifyDeath(w *Worker, err error) {
+	p.mul.Lock()
+	f := p.death
+	p.mul.Unlock()
+
+	if f == nil {
+		return
+	}
+
+	wErr, ok := err.(WaitError)
+	if !ok {
+		wErr = w.waitError()
+	}
+
+	f(*w.Pid, wErr)
+}
+
+// throw invokes event handler if any.
+func (p *poolCore) throw(event int, ctx interface{}) {
+	p.mul.Lock()
+	if p.lsn != nil {
+		p.lsn(event, ctx)
+	}
+	p.mul.Unlock()
+}
@@ -0,0 +1,88 @@
+package roadrunner
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"strings"
+	"sync"
+)
+
+// PoolRegistry is the single lifecycle handle of the application running several pools, for example
+// pool serving HTTP requests and composite pool consuming the queue. Pools are destroyed in the
+// reverse registration order, register pools which feed other pools after them.
+type PoolRegistry struct {
+	mu sync.Mutex
+
+	// names and pools in the registration order
+	names []string
+	pools []ManagedPool
+}
+
+// RegistryError aggregates errors of the registered pools, see PoolRegistry.DestroyAll.
+type RegistryError []error
+
+// Error joins errors of all pools.
+func (e RegistryError) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+
+	return strings.Join(msgs, "; ")
+}
+
+// Register adds pool to the registry, name identifies the pool in the errors. Registry does not
+// have to own the pool exclusively, pool must not be destroyed by the caller once registered.
+func (r *PoolRegistry) Register(name string, p ManagedPool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.names = append(r.names, name)
+	r.pools = append(r.pools, p)
+}
+
+// Pools returns registered pools in the registration order.
+func (r *PoolRegistry) Pools() []ManagedPool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]ManagedPool(nil), r.pools...)
+}
+
+// DestroyAll drains and destroys registered pools one by one in the reverse registration order,
+// pool is destroyed before the drain of the next one starts. Pools share the context deadline,
+// once context is done busy workers of the remaining pools are killed by the drain. RegistryError
+// is returned if any pool failed to drain in time, every pool is destroyed anyway. Registry is empty
+// afterwards.
+func (r *PoolRegistry) DestroyAll(ctx context.Context) error {
+	r.mu.Lock()
+	names, pools := r.names, r.pools
+	r.names, r.pools = nil, nil
+	r.mu.Unlock()
+
+	var errs RegistryError
+	for i := len(pools) - 1; i >= 0; i-- {
+		if _, err := pools[i].Drain(ctx); err != nil {
+			errs = append(errs, errors.Wrapf(err, "pool `%s`", names[i]))
+		}
+
+		pools[i].Destroy()
+	}
+
+	if len(errs) != 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// AggregateStats returns sum of the statistics of all registered pools, Breaker contains the most
+// restrictive breaker state and registry is paused once all pools are paused.
+func (r *PoolRegistry) AggregateStats() PoolStats {
+	pools := r.Pools()
+	if len(pools) == 0 {
+		return PoolStats{}
+	}
+
+	return newPoolSet(nil, pools...).stats()
+}
@@ -0,0 +1,132 @@
+package roadrunner
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"os/exec"
+	"sync"
+	"testing"
+	"time"
+)
+
+// orderPool records drain and destroy calls into the shared log.
+type orderPool struct {
+	stubPool
+	log      *[]string
+	drainErr error
+}
+
+func (p *orderPool) Drain(ctx context.Context) (DrainReport, error) {
+	*p.log = append(*p.log, "drain "+p.name)
+	return DrainReport{}, p.drainErr
+}
+
+func (p *orderPool) Destroy() {
+	*p.log = append(*p.log, "destroy "+p.name)
+}
+
+func Test_PoolRegistry_DestroyAll_Order(t *testing.T) {
+	var (
+		r   PoolRegistry
+		log []string
+	)
+
+	r.Register("http", &orderPool{stubPool: stubPool{name: "http"}, log: &log})
+	r.Register("queue", &orderPool{stubPool: stubPool{name: "queue"}, log: &log, drainErr: context.DeadlineExceeded})
+	r.Register("jobs", &orderPool{stubPool: stubPool{name: "jobs"}, log: &log})
+
+	err := r.DestroyAll(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, "pool `queue`: context deadline exceeded", err.Error())
+	assert.Len(t, err.(RegistryError), 1)
+
+	assert.Equal(t, []string{
+		"drain jobs", "destroy jobs",
+		"drain queue", "destroy queue",
+		"drain http", "destroy http",
+	}, log)
+
+	// registry is empty
+	assert.Len(t, r.Pools(), 0)
+	assert.NoError(t, r.DestroyAll(context.Background()))
+}
+
+func Test_PoolRegistry_AggregateStats(t *testing.T) {
+	var r PoolRegistry
+	assert.Equal(t, PoolStats{}, r.AggregateStats())
+
+	r.Register("http", &stubPool{stats: PoolStats{NumWorkers: 4, NumBusy: 1, Paused: true}})
+	r.Register("queue", &stubPool{stats: PoolStats{NumWorkers: 2, NumBusy: 2}})
+
+	stats := r.AggregateStats()
+	assert.Equal(t, 6, stats.NumWorkers)
+	assert.Equal(t, 3, stats.NumBusy)
+	assert.False(t, stats.Paused)
+}
+
+func Test_PoolRegistry_DestroyAll(t *testing.T) {
+	var r PoolRegistry
+
+	var (
+		mu        sync.Mutex
+		destroyed []string
+	)
+	for _, name := range []string{"first", "second"} {
+		p, err := NewPool(
+			func() *exec.Cmd { return exec.Command("php", "tests/client.php", "delay", "pipes") },
+			NewPipeFactory(),
+			Config{
+				NumWorkers:      2,
+				AllocateTimeout: time.Second,
+				DestroyTimeout:  time.Second,
+			},
+		)
+		assert.NoError(t, err)
+
+		name := name
+		p.OnWorkerDestroy(func(w *Worker) {
+			mu.Lock()
+			destroyed = append(destroyed, name)
+			mu.Unlock()
+		})
+		r.Register(name, p)
+	}
+
+	var wg sync.WaitGroup
+	for _, p := range r.Pools() {
+		for _, delay := range []string{"100", "2000"} {
+			wg.Add(1)
+			go func(p Pool, delay string) {
+				defer wg.Done()
+				_, _ = p.Exec(&Payload{Body: []byte(delay)})
+			}(p, delay)
+		}
+	}
+	time.Sleep(time.Millisecond * 50)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*500)
+	defer cancel()
+
+	start := time.Now()
+	err := r.DestroyAll(ctx)
+	wg.Wait()
+
+	// long tasks of both pools exceed the shared deadline
+	assert.Error(t, err)
+	assert.Len(t, err.(RegistryError), 2)
+	assert.True(t, time.Since(start) < time.Millisecond*1500)
+
+	// pools are destroyed in reverse registration order, killed workers included
+	time.Sleep(time.Millisecond * 100)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	order := []string{}
+	for _, name := range destroyed {
+		if len(order) == 0 || order[len(order)-1] != name {
+			order = append(order, name)
+		}
+	}
+	assert.Equal(t, []string{"second", "first"}, order)
+}
@@ -0,0 +1,248 @@
+package roadrunner
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"os/exec"
+	"time"
+)
+
+// poolSet applies operations to every pool of the pool wrapper in the declaration order,
+// errors are prefixed with the name of the failed pool.
+type poolSet struct {
+	// describe the pools in errors, for example "primary pool"
+	names []string
+
+	// pools matched to names by index
+	pools []ManagedPool
+}
+
+// newPoolSet creates set of the given pools, names and pools are matched by index.
+func newPoolSet(names []string, pools ...ManagedPool) poolSet {
+	return poolSet{names: names, pools: pools}
+}
+
+// listen attaches event controller to every pool.
+func (s poolSet) listen(l func(event int, ctx interface{})) {
+	for _, pool := range s.pools {
+		pool.Listen(l)
+	}
+}
+
+// use attaches middleware to every pool.
+func (s poolSet) use(m Middleware) {
+	for _, pool := range s.pools {
+		pool.Use(m)
+	}
+}
+
+// setFallback attaches fallback to every pool.
+func (s poolSet) setFallback(f FallbackFunc) {
+	for _, pool := range s.pools {
+		pool.SetFallback(f)
+	}
+}
+
+// registerHandler registers handler in every pool.
+func (s poolSet) registerHandler(name string, fn func([]byte) ([]byte, error)) {
+	for _, pool := range s.pools {
+		pool.RegisterHandler(name, fn)
+	}
+}
+
+// setCommand replaces the command used to spawn new workers of every pool.
+func (s poolSet) setCommand(cmd func(cfg WorkerConfig) *exec.Cmd) {
+	for _, pool := range s.pools {
+		pool.SetCommand(cmd)
+	}
+}
+
+// onWorkerDeath attaches callback to every pool.
+func (s poolSet) onWorkerDeath(f func(pid int, err error)) {
+	for _, pool := range s.pools {
+		pool.OnWorkerDeath(f)
+	}
+}
+
+// onWorkerReady attaches hook to every pool.
+func (s poolSet) onWorkerReady(f func(w *Worker) error) {
+	for _, pool := range s.pools {
+		pool.OnWorkerReady(f)
+	}
+}
+
+// onWorkerDestroy attaches hook to every pool.
+func (s poolSet) onWorkerDestroy(f func(w *Worker)) {
+	for _, pool := range s.pools {
+		pool.OnWorkerDestroy(f)
+	}
+}
+
+// pause stops dispatching of new tasks in every pool.
+func (s poolSet) pause() {
+	for _, pool := range s.pools {
+		pool.Pause()
+	}
+}
+
+// resume restarts dispatching of the tasks in every pool.
+func (s poolSet) resume() {
+	for _, pool := range s.pools {
+		pool.Resume()
+	}
+}
+
+// destroy destroys every pool.
+func (s poolSet) destroy() {
+	for _, pool := range s.pools {
+		pool.Destroy()
+	}
+}
+
+// workers returns workers of all pools.
+func (s poolSet) workers() (workers []*Worker) {
+	for _, pool := range s.pools {
+		workers = append(workers, pool.Workers()...)
+	}
+
+	return workers
+}
+
+// dump returns snapshots of the workers of all pools.
+func (s poolSet) dump() (snapshots []WorkerSnapshot) {
+	for _, pool := range s.pools {
+		snapshots = append(snapshots, pool.Dump()...)
+	}
+
+	return snapshots
+}
+
+// stats returns sum of the pool statistics, see sumStats.
+func (s poolSet) stats() PoolStats {
+	stats := s.pools[0].Stats()
+	for _, pool := range s.pools[1:] {
+		stats = sumStats(stats, pool.Stats())
+	}
+
+	return stats
+}
+
+// owner returns pool the worker belongs to, nil for unknown worker.
+func (s poolSet) owner(w *Worker) ManagedPool {
+	for _, pool := range s.pools {
+		for _, pw := range pool.Workers() {
+			if pw == w {
+				return pool
+			}
+		}
+	}
+
+	return nil
+}
+
+// remove forces pool owning the worker to remove it, false is returned for unknown worker.
+func (s poolSet) remove(w *Worker, err error) bool {
+	if owner := s.owner(w); owner != nil {
+		return owner.Remove(w, err)
+	}
+
+	return false
+}
+
+// recycleIdle recycles idle worker of the owning pool.
+func (s poolSet) recycleIdle(w *Worker, reason RecycleReason, err error) bool {
+	if r, ok := s.owner(w).(idleRecycler); ok {
+		return r.recycleIdle(w, reason, err)
+	}
+
+	return false
+}
+
+// detach takes idle worker with the given PID out of the pool owning it.
+func (s poolSet) detach(pid int) (*Worker, error) {
+	for _, pool := range s.pools {
+		for _, w := range pool.Workers() {
+			if *w.Pid == pid {
+				return pool.Detach(pid)
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("no worker with pid %v", pid)
+}
+
+// reloadWorker replaces the oldest worker of each pool, stops at the first failed pool.
+func (s poolSet) reloadWorker() error {
+	for i, pool := range s.pools {
+		if err := pool.ReloadWorker(); err != nil {
+			return errors.Wrap(err, s.names[i])
+		}
+	}
+
+	return nil
+}
+
+// reloadAll replaces workers of the pools one pool after another, stops at the first failed pool.
+func (s poolSet) reloadAll(pause time.Duration) error {
+	for i, pool := range s.pools {
+		if err := pool.ReloadAll(pause); err != nil {
+			return errors.Wrap(err, s.names[i])
+		}
+	}
+
+	return nil
+}
+
+// healthy verifies that every pool is healthy, reports the first unhealthy pool. Error is never
+// nil for the unhealthy pool, even if pool reported no reason.
+func (s poolSet) healthy() (bool, error) {
+	for i, pool := range s.pools {
+		if ok, err := pool.Healthy(); !ok {
+			if err == nil {
+				return false, fmt.Errorf("%s is not healthy", s.names[i])
+			}
+
+			return false, errors.Wrap(err, s.names[i])
+		}
+	}
+
+	return true, nil
+}
+
+// waitReady waits until every pool is ready.
+func (s poolSet) waitReady(ctx context.Context) error {
+	for i, pool := range s.pools {
+		if err := pool.WaitReady(ctx); err != nil {
+			return errors.Wrap(err, s.names[i])
+		}
+	}
+
+	return nil
+}
+
+// drain drains every pool sharing the context, report contains sum of the pool reports and the
+// error of the first failed pool. All pools are paused before drain to keep tasks from being
+// routed to the pool still running.
+func (s poolSet) drain(ctx context.Context) (DrainReport, error) {
+	start := time.Now()
+	s.pause()
+
+	var (
+		report DrainReport
+		err    error
+	)
+
+	for i, pool := range s.pools {
+		r, perr := pool.Drain(ctx)
+		report.CompletedDuringDrain += r.CompletedDuringDrain
+		report.ForceKilled += r.ForceKilled
+
+		if perr != nil && err == nil {
+			err = errors.Wrap(perr, s.names[i])
+		}
+	}
+
+	report.TotalDrainTime = time.Since(start)
+	return report, err
+}
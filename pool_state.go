@@ -0,0 +1,577 @@
+package roadrunner
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// StateVersion is the version of the binary pool state written by ManagedPool.MarshalState.
+//
+// Encoding is the magic prefix followed by the version and the sequence of the fields, every
+// field is the key (tag and wire type) followed by the value: varint, 8 bytes of fixed64 or
+// length prefixed bytes carrying the string, packed varints or nested fields. Zero values are
+// omitted. Readers skip fields of unknown tags, so new fields are added under new tags within the
+// version bump without breaking the old readers, and fields missing in the older states decode as
+// zero values. Tags are never reused or retyped.
+const StateVersion = 2
+
+// stateMagic prefixes every encoded pool state.
+const stateMagic = "RRPS"
+
+// wire types of the state fields
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+)
+
+// state field tags, see StateVersion
+const (
+	tagStats  = 1
+	tagWorker = 2
+
+	tagStatsNumWorkers       = 1
+	tagStatsNumIdle          = 2
+	tagStatsNumBusy          = 3
+	tagStatsTotalExecs       = 4
+	tagStatsTotalErrors      = 5
+	tagStatsQueued           = 6
+	tagStatsQueuedByPriority = 7
+	tagStatsBreaker          = 8
+	tagStatsPaused           = 9
+	tagStatsQuarantined      = 10
+	tagStatsPeakMemory       = 11
+	tagStatsRespawnRate      = 12
+	tagStatsRollingCadence   = 13
+	tagStatsTotalMemory      = 14
+	tagStatsRecycleReason    = 15
+
+	tagReasonValue = 1
+	tagReasonCount = 2
+
+	tagWorkerPid                = 1
+	tagWorkerID                 = 2
+	tagWorkerStatus             = 3
+	tagWorkerNumExecs           = 4
+	tagWorkerCreated            = 5
+	tagWorkerLastUsed           = 6
+	tagWorkerAge                = 7
+	tagWorkerLastPayloadSize    = 8
+	tagWorkerBusy               = 9
+	tagWorkerTaskStarted        = 10
+	tagWorkerMeta               = 11
+	tagWorkerLatencyBuckets     = 12
+	tagWorkerStartDuration      = 13
+	tagWorkerRelayWaitDuration  = 14
+	tagWorkerCPUTime            = 15
+	tagWorkerLastReportedStatus = 16
+
+	// since version 2
+	tagWorkerConsecutiveMalformed = 17
+
+	tagMetaKey   = 1
+	tagMetaValue = 2
+)
+
+// PoolState is the pool state decoded by UnmarshalState.
+type PoolState struct {
+	// Version of the encoding the state has been written with, might be newer than StateVersion.
+	Version int
+
+	// Stats of the pool, see ManagedPool.Stats.
+	Stats PoolStats
+
+	// Workers contains snapshots of all pool workers, see ManagedPool.Dump.
+	Workers []WorkerSnapshot
+}
+
+// marshalState encodes pool stats and worker snapshots, see StateVersion.
+func marshalState(stats PoolStats, workers []WorkerSnapshot) ([]byte, error) {
+	w := &stateWriter{buf: []byte(stateMagic)}
+	w.appendUvarint(StateVersion)
+
+	w.message(tagStats, func(w *stateWriter) {
+		w.int(tagStatsNumWorkers, int64(stats.NumWorkers))
+		w.int(tagStatsNumIdle, int64(stats.NumIdle))
+		w.int(tagStatsNumBusy, int64(stats.NumBusy))
+		w.int(tagStatsTotalExecs, stats.TotalExecs)
+		w.int(tagStatsTotalErrors, stats.TotalErrors)
+		w.int(tagStatsQueued, int64(stats.Queued))
+
+		var queued [NumPriorities]int64
+		for i, n := range stats.QueuedByPriority {
+			queued[i] = int64(n)
+		}
+		w.ints(tagStatsQueuedByPriority, queued[:])
+
+		w.string(tagStatsBreaker, string(stats.Breaker))
+		w.bool(tagStatsPaused, stats.Paused)
+		w.int(tagStatsQuarantined, int64(stats.Quarantined))
+		w.uint(tagStatsPeakMemory, stats.PeakMemory)
+		w.float(tagStatsRespawnRate, stats.RespawnRate)
+		w.int(tagStatsRollingCadence, int64(stats.RollingReplaceCadence))
+		w.uint(tagStatsTotalMemory, stats.TotalMemory)
+
+		// sorted to keep the encoding stable
+		reasons := make([]RecycleReason, 0, len(stats.RecycleReasons))
+		for reason := range stats.RecycleReasons {
+			reasons = append(reasons, reason)
+		}
+		sort.Slice(reasons, func(i, j int) bool { return reasons[i] < reasons[j] })
+
+		for _, reason := range reasons {
+			count := stats.RecycleReasons[reason]
+			w.message(tagStatsRecycleReason, func(w *stateWriter) {
+				w.int(tagReasonValue, int64(reason))
+				w.int(tagReasonCount, count)
+			})
+		}
+	})
+
+	for _, s := range workers {
+		s := s
+		w.message(tagWorker, func(w *stateWriter) {
+			w.int(tagWorkerPid, int64(s.Pid))
+			w.string(tagWorkerID, s.ID)
+			w.string(tagWorkerStatus, s.Status)
+			w.int(tagWorkerNumExecs, s.NumExecs)
+			w.time(tagWorkerCreated, s.Created)
+			w.time(tagWorkerLastUsed, s.LastUsed)
+			w.int(tagWorkerAge, int64(s.Age))
+			w.int(tagWorkerLastPayloadSize, int64(s.LastPayloadSize))
+			w.bool(tagWorkerBusy, s.Busy)
+			w.time(tagWorkerTaskStarted, s.TaskStarted)
+
+			keys := make([]string, 0, len(s.Meta))
+			for key := range s.Meta {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+
+			for _, key := range keys {
+				value := s.Meta[key]
+				w.message(tagWorkerMeta, func(w *stateWriter) {
+					w.string(tagMetaKey, key)
+					w.string(tagMetaValue, value)
+				})
+			}
+
+			w.ints(tagWorkerLatencyBuckets, s.LatencyBuckets[:])
+			w.int(tagWorkerStartDuration, int64(s.StartDuration))
+			w.int(tagWorkerRelayWaitDuration, int64(s.RelayWaitDuration))
+			w.int(tagWorkerCPUTime, int64(s.CPUTime))
+			w.string(tagWorkerLastReportedStatus, s.LastReportedStatus)
+			w.int(tagWorkerConsecutiveMalformed, s.ConsecutiveMalformed)
+		})
+	}
+
+	return w.buf, nil
+}
+
+// UnmarshalState decodes pool state encoded by ManagedPool.MarshalState. States written by the newer
+// versions are decoded as well, fields unknown to this version are skipped.
+func UnmarshalState(data []byte) (state PoolState, err error) {
+	if len(data) < len(stateMagic) || string(data[:len(stateMagic)]) != stateMagic {
+		return state, fmt.Errorf("invalid pool state: missing magic prefix")
+	}
+
+	version, n := binary.Uvarint(data[len(stateMagic):])
+	if n <= 0 || version == 0 || version > math.MaxInt32 {
+		return state, fmt.Errorf("invalid pool state: malformed version")
+	}
+	state.Version = int(version)
+
+	err = readState(data[len(stateMagic)+n:], func(f stateField) error {
+		switch f.tag {
+		case tagStats:
+			return f.message(func(f stateField) error { return readStats(f, &state.Stats) })
+		case tagWorker:
+			s := WorkerSnapshot{}
+			if err := f.message(func(f stateField) error { return readWorker(f, &s) }); err != nil {
+				return err
+			}
+
+			state.Workers = append(state.Workers, s)
+		}
+
+		return nil
+	})
+
+	return state, err
+}
+
+// readStats decodes the field of the pool stats.
+func readStats(f stateField, stats *PoolStats) error {
+	switch f.tag {
+	case tagStatsNumWorkers:
+		return f.intTo(&stats.NumWorkers)
+	case tagStatsNumIdle:
+		return f.intTo(&stats.NumIdle)
+	case tagStatsNumBusy:
+		return f.intTo(&stats.NumBusy)
+	case tagStatsTotalExecs:
+		return f.int64To(&stats.TotalExecs)
+	case tagStatsTotalErrors:
+		return f.int64To(&stats.TotalErrors)
+	case tagStatsQueued:
+		return f.intTo(&stats.Queued)
+	case tagStatsQueuedByPriority:
+		var queued [NumPriorities]int64
+		if err := f.ints(queued[:]); err != nil {
+			return err
+		}
+
+		for i, n := range queued {
+			stats.QueuedByPriority[i] = int(n)
+		}
+	case tagStatsBreaker:
+		var breaker string
+		if err := f.stringTo(&breaker); err != nil {
+			return err
+		}
+
+		stats.Breaker = BreakerState(breaker)
+	case tagStatsPaused:
+		return f.boolTo(&stats.Paused)
+	case tagStatsQuarantined:
+		return f.intTo(&stats.Quarantined)
+	case tagStatsPeakMemory:
+		return f.uintTo(&stats.PeakMemory)
+	case tagStatsRespawnRate:
+		return f.floatTo(&stats.RespawnRate)
+	case tagStatsRollingCadence:
+		return f.durationTo(&stats.RollingReplaceCadence)
+	case tagStatsTotalMemory:
+		return f.uintTo(&stats.TotalMemory)
+	case tagStatsRecycleReason:
+		var reason, count int64
+		err := f.message(func(f stateField) error {
+			switch f.tag {
+			case tagReasonValue:
+				return f.int64To(&reason)
+			case tagReasonCount:
+				return f.int64To(&count)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if stats.RecycleReasons == nil {
+			stats.RecycleReasons = make(map[RecycleReason]int64)
+		}
+		stats.RecycleReasons[RecycleReason(reason)] = count
+	}
+
+	return nil
+}
+
+// readWorker decodes the field of the worker snapshot.
+func readWorker(f stateField, s *WorkerSnapshot) error {
+	switch f.tag {
+	case tagWorkerPid:
+		return f.intTo(&s.Pid)
+	case tagWorkerID:
+		return f.stringTo(&s.ID)
+	case tagWorkerStatus:
+		return f.stringTo(&s.Status)
+	case tagWorkerNumExecs:
+		return f.int64To(&s.NumExecs)
+	case tagWorkerCreated:
+		return f.timeTo(&s.Created)
+	case tagWorkerLastUsed:
+		return f.timeTo(&s.LastUsed)
+	case tagWorkerAge:
+		return f.durationTo(&s.Age)
+	case tagWorkerLastPayloadSize:
+		return f.intTo(&s.LastPayloadSize)
+	case tagWorkerBusy:
+		return f.boolTo(&s.Busy)
+	case tagWorkerTaskStarted:
+		return f.timeTo(&s.TaskStarted)
+	case tagWorkerMeta:
+		var key, value string
+		err := f.message(func(f stateField) error {
+			switch f.tag {
+			case tagMetaKey:
+				return f.stringTo(&key)
+			case tagMetaValue:
+				return f.stringTo(&value)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if s.Meta == nil {
+			s.Meta = make(map[string]string)
+		}
+		s.Meta[key] = value
+	case tagWorkerLatencyBuckets:
+		return f.ints(s.LatencyBuckets[:])
+	case tagWorkerStartDuration:
+		return f.durationTo(&s.StartDuration)
+	case tagWorkerRelayWaitDuration:
+		return f.durationTo(&s.RelayWaitDuration)
+	case tagWorkerCPUTime:
+		return f.durationTo(&s.CPUTime)
+	case tagWorkerLastReportedStatus:
+		return f.stringTo(&s.LastReportedStatus)
+	case tagWorkerConsecutiveMalformed:
+		return f.int64To(&s.ConsecutiveMalformed)
+	}
+
+	return nil
+}
+
+// stateWriter appends the state fields to the buffer, zero values are omitted.
+type stateWriter struct {
+	buf []byte
+}
+
+func (w *stateWriter) appendUvarint(v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	w.buf = append(w.buf, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+func (w *stateWriter) appendVarint(v int64) {
+	var buf [binary.MaxVarintLen64]byte
+	w.buf = append(w.buf, buf[:binary.PutVarint(buf[:], v)]...)
+}
+
+func (w *stateWriter) key(tag, wire int) {
+	w.appendUvarint(uint64(tag)<<3 | uint64(wire))
+}
+
+func (w *stateWriter) int(tag int, v int64) {
+	if v != 0 {
+		w.key(tag, wireVarint)
+		w.appendVarint(v)
+	}
+}
+
+func (w *stateWriter) uint(tag int, v uint64) {
+	if v != 0 {
+		w.key(tag, wireVarint)
+		w.appendUvarint(v)
+	}
+}
+
+func (w *stateWriter) bool(tag int, v bool) {
+	if v {
+		w.uint(tag, 1)
+	}
+}
+
+func (w *stateWriter) float(tag int, v float64) {
+	if v != 0 {
+		var buf [8]byte
+		binary.LittleEndian.PutUint64(buf[:], math.Float64bits(v))
+
+		w.key(tag, wireFixed64)
+		w.buf = append(w.buf, buf[:]...)
+	}
+}
+
+func (w *stateWriter) time(tag int, v time.Time) {
+	if !v.IsZero() {
+		w.int(tag, v.UnixNano())
+	}
+}
+
+func (w *stateWriter) string(tag int, v string) {
+	if v != "" {
+		w.bytes(tag, []byte(v))
+	}
+}
+
+func (w *stateWriter) bytes(tag int, v []byte) {
+	w.key(tag, wireBytes)
+	w.appendUvarint(uint64(len(v)))
+	w.buf = append(w.buf, v...)
+}
+
+// ints writes packed varints, omitted when all values are zero.
+func (w *stateWriter) ints(tag int, v []int64) {
+	packed := &stateWriter{}
+	zero := true
+	for _, n := range v {
+		zero = zero && n == 0
+		packed.appendVarint(n)
+	}
+
+	if !zero {
+		w.bytes(tag, packed.buf)
+	}
+}
+
+// message writes nested fields, always written even when empty.
+func (w *stateWriter) message(tag int, fields func(w *stateWriter)) {
+	nested := &stateWriter{}
+	fields(nested)
+	w.bytes(tag, nested.buf)
+}
+
+// stateField is the decoded state field, num carries varint and fixed64 values, data the bytes.
+type stateField struct {
+	tag  int
+	wire int
+	num  uint64
+	data []byte
+}
+
+// readState decodes the sequence of the fields, fields of unknown wire types fail the decoding
+// since their length is unknown.
+func readState(data []byte, f func(f stateField) error) error {
+	for len(data) != 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return fmt.Errorf("invalid pool state: malformed field key")
+		}
+		data = data[n:]
+
+		field := stateField{tag: int(key >> 3), wire: int(key & 7)}
+		switch field.wire {
+		case wireVarint:
+			if field.num, n = binary.Uvarint(data); n <= 0 {
+				return fmt.Errorf("invalid pool state: malformed value of field %v", field.tag)
+			}
+			data = data[n:]
+		case wireFixed64:
+			if len(data) < 8 {
+				return fmt.Errorf("invalid pool state: truncated value of field %v", field.tag)
+			}
+			field.num, data = binary.LittleEndian.Uint64(data), data[8:]
+		case wireBytes:
+			size, n := binary.Uvarint(data)
+			if n <= 0 || size > uint64(len(data)-n) {
+				return fmt.Errorf("invalid pool state: truncated value of field %v", field.tag)
+			}
+			field.data, data = data[n:n+int(size)], data[n+int(size):]
+		default:
+			return fmt.Errorf("invalid pool state: unknown wire type %v of field %v", field.wire, field.tag)
+		}
+
+		if err := f(field); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// expect returns error when field has other wire type than the known field of the tag.
+func (f stateField) expect(wire int) error {
+	if f.wire != wire {
+		return fmt.Errorf("invalid pool state: field %v has wire type %v, %v expected", f.tag, f.wire, wire)
+	}
+
+	return nil
+}
+
+func (f stateField) int64To(v *int64) error {
+	if err := f.expect(wireVarint); err != nil {
+		return err
+	}
+
+	// zig-zag encoding of binary.AppendVarint
+	*v = int64(f.num>>1) ^ -int64(f.num&1)
+	return nil
+}
+
+func (f stateField) intTo(v *int) error {
+	var n int64
+	if err := f.int64To(&n); err != nil {
+		return err
+	}
+
+	*v = int(n)
+	return nil
+}
+
+func (f stateField) durationTo(v *time.Duration) error {
+	return f.int64To((*int64)(v))
+}
+
+func (f stateField) timeTo(v *time.Time) error {
+	var n int64
+	if err := f.int64To(&n); err != nil {
+		return err
+	}
+
+	*v = time.Unix(0, n)
+	return nil
+}
+
+func (f stateField) uintTo(v *uint64) error {
+	if err := f.expect(wireVarint); err != nil {
+		return err
+	}
+
+	*v = f.num
+	return nil
+}
+
+func (f stateField) boolTo(v *bool) error {
+	if err := f.expect(wireVarint); err != nil {
+		return err
+	}
+
+	*v = f.num != 0
+	return nil
+}
+
+func (f stateField) floatTo(v *float64) error {
+	if err := f.expect(wireFixed64); err != nil {
+		return err
+	}
+
+	*v = math.Float64frombits(f.num)
+	return nil
+}
+
+func (f stateField) stringTo(v *string) error {
+	if err := f.expect(wireBytes); err != nil {
+		return err
+	}
+
+	*v = string(f.data)
+	return nil
+}
+
+// ints decodes packed varints into v, values beyond its length are skipped.
+func (f stateField) ints(v []int64) error {
+	if err := f.expect(wireBytes); err != nil {
+		return err
+	}
+
+	for i, data := 0, f.data; len(data) != 0; i++ {
+		n, size := binary.Varint(data)
+		if size <= 0 {
+			return fmt.Errorf("invalid pool state: malformed value of field %v", f.tag)
+		}
+		data = data[size:]
+
+		if i < len(v) {
+			v[i] = n
+		}
+	}
+
+	return nil
+}
+
+// message decodes nested fields.
+func (f stateField) message(fields func(f stateField) error) error {
+	if err := f.expect(wireBytes); err != nil {
+		return err
+	}
+
+	return readState(f.data, fields)
+}
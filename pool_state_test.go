@@ -0,0 +1,177 @@
+package roadrunner
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func Test_PoolState_RoundTrip(t *testing.T) {
+	stats := PoolStats{
+		NumWorkers:            3,
+		NumIdle:               1,
+		NumBusy:               2,
+		TotalExecs:            1 << 40,
+		TotalErrors:           7,
+		Queued:                4,
+		QueuedByPriority:      [NumPriorities]int{1, 0, 3},
+		Breaker:               BreakerHalfOpen,
+		Paused:                true,
+		Quarantined:           1,
+		PeakMemory:            1 << 63,
+		RespawnRate:           0.25,
+		RollingReplaceCadence: time.Minute,
+		TotalMemory:           1 << 30,
+		RecycleReasons:        map[RecycleReason]int64{RecycleMaxJobs: 10, RecycleCrash: 2},
+	}
+
+	workers := []WorkerSnapshot{
+		{
+			Pid:                  1234,
+			ID:                   "worker-0",
+			Status:               "working",
+			NumExecs:             42,
+			Created:              time.Unix(0, 1600000000000000000),
+			LastUsed:             time.Unix(0, 1600000001000000000),
+			Age:                  -time.Second,
+			LastPayloadSize:      512,
+			Busy:                 true,
+			TaskStarted:          time.Unix(0, 1600000002000000000),
+			Meta:                 map[string]string{"version": "1.0", "empty": ""},
+			LatencyBuckets:       LatencyBuckets{1, 0, 2},
+			StartDuration:        time.Millisecond * 15,
+			RelayWaitDuration:    time.Millisecond * 5,
+			CPUTime:              time.Second,
+			LastReportedStatus:   "warming up",
+			ConsecutiveMalformed: 2,
+		},
+		{},
+	}
+
+	data, err := marshalState(stats, workers)
+	assert.NoError(t, err)
+
+	state, err := UnmarshalState(data)
+	assert.NoError(t, err)
+	assert.Equal(t, PoolState{Version: StateVersion, Stats: stats, Workers: workers}, state)
+
+	// encoding is stable
+	again, err := marshalState(stats, workers)
+	assert.NoError(t, err)
+	assert.Equal(t, data, again)
+
+	// empty pool
+	data, err = marshalState(PoolStats{}, nil)
+	assert.NoError(t, err)
+
+	state, err = UnmarshalState(data)
+	assert.NoError(t, err)
+	assert.Equal(t, PoolState{Version: StateVersion}, state)
+}
+
+func Test_PoolState_VersionBump(t *testing.T) {
+	// state of the next version extends stats and snapshots with new fields and adds new section
+	next := &stateWriter{buf: []byte(stateMagic)}
+	next.appendUvarint(StateVersion + 1)
+	next.message(tagStats, func(w *stateWriter) {
+		w.int(tagStatsNumWorkers, 2)
+		w.float(100, 1.5)
+		w.int(tagStatsTotalExecs, 10)
+	})
+	next.message(tagWorker, func(w *stateWriter) {
+		w.int(tagWorkerPid, 1)
+		w.message(100, func(w *stateWriter) {
+			w.string(1, "nested")
+		})
+		w.string(101, "new field")
+		w.ints(tagWorkerLatencyBuckets, make([]int64, len(LatencyBuckets{})+2))
+		w.string(tagWorkerStatus, "ready")
+	})
+	next.message(100, func(w *stateWriter) {
+		w.int(1, 1)
+	})
+	next.message(tagWorker, func(w *stateWriter) {
+		w.int(tagWorkerPid, 2)
+	})
+
+	state, err := UnmarshalState(next.buf)
+	assert.NoError(t, err)
+	assert.Equal(t, StateVersion+1, state.Version)
+	assert.Equal(t, PoolStats{NumWorkers: 2, TotalExecs: 10}, state.Stats)
+	assert.Equal(t, []WorkerSnapshot{{Pid: 1, Status: "ready"}, {Pid: 2}}, state.Workers)
+
+	// state of the previous version does not carry the recent fields
+	prev := &stateWriter{buf: []byte(stateMagic)}
+	prev.appendUvarint(StateVersion)
+	prev.message(tagWorker, func(w *stateWriter) {
+		w.int(tagWorkerPid, 1)
+		w.int(tagWorkerNumExecs, 5)
+	})
+
+	state, err = UnmarshalState(prev.buf)
+	assert.NoError(t, err)
+	assert.Equal(t, StateVersion, state.Version)
+	assert.Equal(t, PoolStats{}, state.Stats)
+	assert.Equal(t, []WorkerSnapshot{{Pid: 1, NumExecs: 5}}, state.Workers)
+}
+
+func Test_PoolState_Invalid(t *testing.T) {
+	data, err := marshalState(PoolStats{NumWorkers: 1}, []WorkerSnapshot{{Pid: 1, ID: "worker"}})
+	assert.NoError(t, err)
+
+	for name, invalid := range map[string][]byte{
+		"empty":        nil,
+		"magic":        []byte("JSON{}"),
+		"version":      []byte(stateMagic),
+		"zero version": append([]byte(stateMagic), 0),
+		"truncated":    data[:len(data)-1],
+		"wire type":    append(append([]byte(stateMagic), 1), tagStats<<3|5),
+	} {
+		_, err := UnmarshalState(invalid)
+		assert.Error(t, err, name)
+	}
+
+	// known field of unexpected type
+	w := &stateWriter{buf: []byte(stateMagic)}
+	w.appendUvarint(StateVersion)
+	w.message(tagWorker, func(w *stateWriter) {
+		w.string(tagWorkerPid, "1")
+	})
+
+	_, err = UnmarshalState(w.buf)
+	assert.Error(t, err)
+}
+
+func Test_StaticPool_MarshalState(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "echo", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      2,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	_, err = p.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+
+	data, err := p.MarshalState()
+	assert.NoError(t, err)
+
+	state, err := UnmarshalState(data)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, state.Stats.NumWorkers)
+	assert.Equal(t, int64(1), state.Stats.TotalExecs)
+	assert.Len(t, state.Workers, 2)
+
+	for i, w := range p.Workers() {
+		assert.Equal(t, *w.Pid, state.Workers[i].Pid)
+		assert.Equal(t, w.ID, state.Workers[i].ID)
+		assert.Equal(t, "ready", state.Workers[i].Status)
+		assert.True(t, w.Created.Equal(state.Workers[i].Created))
+	}
+}
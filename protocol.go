@@ -1,18 +1,183 @@
 package roadrunner
 
 import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	json "github.com/json-iterator/go"
 	"github.com/spiral/goridge/v2"
 	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 )
 
+// CapabilityCancel is the handshake metadata key (see HandshakeFunc) of the workers able to abort
+// the task. Worker reporting "true" value receives cancel control command ({"cancel":true}) once
+// task is canceled and must respond to the task as soon as possible, usually with an error. Cancel
+// command received after the task completion must be ignored.
+const CapabilityCancel = "cancel"
+
+// CapabilityControls is the handshake metadata key listing control commands supported by the
+// worker (see Worker.Control) separated by comma. Default handshake fills it from the controls
+// field of the worker pid command.
+const CapabilityControls = "controls"
+
+// muxCommand precedes context and body frames of every task sent to the multiplexed worker (see
+// Worker.SetConcurrency), worker must precede the response frames with the same command.
+type muxCommand struct {
+	Request uint64 `json:"request"`
+}
+
+// unhealthyCommand is sent by the idle worker which can not serve tasks anymore (for example lost
+// database connection), pool replaces such worker. Command is honored only while worker is idle
+// (after the response), command sent during the execution is taken for the response and breaks
+// the task. Detected on Linux only, see Config.IdleCheckInterval and DynamicConfig.IdleCheckInterval.
+type unhealthyCommand struct {
+	Unhealthy bool `json:"unhealthy"`
+}
+
+// streamCommand precedes context and body frames of the task which response is streamed (see
+// Worker.ExecStream). Worker responds with the context control frame followed by any number of
+// body chunk frames (no control flag) and ends the stream with {"end":true} control frame. Job error
+// sent as control frame with error flag ends the stream at any point.
+type streamCommand struct {
+	Stream bool `json:"stream"`
+	End    bool `json:"end,omitempty"`
+}
+
+// batchCommand precedes the frame of tasks executed by the worker in one exchange (see
+// Worker.ExecBatch). Packed frame (raw flag) contains Batch tasks, every task is encoded as goridge
+// context frame (control flag) followed by body frame, prefixes included. Worker responds with
+// single packed frame (raw flag) of the responses in the task order, every response is encoded as
+// context frame (control flag) followed by body frame, or as single context frame with control and
+// error flags carrying job error of the task. Control frame with error flag sent instead of the
+// packed responses fails all tasks.
+type batchCommand struct {
+	Batch int `json:"batch"`
+}
+
+// PeakMemoryField is the field of the response context (JSON object) worker can use to report its
+// peak memory usage in bytes during the task, for example {"peak_memory":4194304} sent by
+// memory_get_peak_usage() after memory_reset_peak_usage() at the task start. Reported value is
+// available as ExecMeta.PeakMemory and PoolStats.PeakMemory, field is optional.
+const PeakMemoryField = "peak_memory"
+
+// peakMemoryReport is the optional part of the response context reporting task peak memory.
+type peakMemoryReport struct {
+	PeakMemory uint64 `json:"peak_memory"`
+}
+
+// peakMemory returns peak memory reported in the response context, 0 when not reported.
+func peakMemory(rsp *Payload) uint64 {
+	if rsp == nil || len(rsp.Context) == 0 || rsp.Context[0] != '{' ||
+		!bytes.Contains(rsp.Context, []byte(`"`+PeakMemoryField+`"`)) {
+		return 0
+	}
+
+	var report peakMemoryReport
+	if json.Unmarshal(rsp.Context, &report) != nil {
+		return 0
+	}
+
+	return report.PeakMemory
+}
+
+// observePeak raises the peak stored at addr to the given value, accessed atomically.
+func observePeak(addr *uint64, peak uint64) {
+	for {
+		old := atomic.LoadUint64(addr)
+		if peak <= old || atomic.CompareAndSwapUint64(addr, old, peak) {
+			return
+		}
+	}
+}
+
+// StatusField is the field of the response context (JSON object) worker can use to report its
+// internal state, for example {"worker_status":"degraded: cache miss rate high"}. Last reported
+// status is available as Worker.LastReportedStatus and in the worker snapshot, relay and protocol
+// errors of the worker mention it as well as application errors (JobError) sent by the worker,
+// status reported with the error response is taken into account. Field is optional, responses
+// without the field keep the status and empty value clears it. Status longer than MaxStatusLength
+// bytes is truncated at the character boundary.
+const StatusField = "worker_status"
+
+// MaxStatusLength limits size of the status reported by the worker in bytes, see StatusField.
+const MaxStatusLength = 256
+
+// statusReport is the optional part of the response context reporting worker status.
+type statusReport struct {
+	Status *string `json:"worker_status"`
+}
+
+// reportedStatus returns status reported in the response context, ok is false when not reported.
+func reportedStatus(rsp *Payload) (status string, ok bool) {
+	if rsp == nil || len(rsp.Context) == 0 || rsp.Context[0] != '{' ||
+		!bytes.Contains(rsp.Context, []byte(`"`+StatusField+`"`)) {
+		return "", false
+	}
+
+	var report statusReport
+	if json.Unmarshal(rsp.Context, &report) != nil || report.Status == nil {
+		return "", false
+	}
+
+	return truncateStatus(*report.Status), true
+}
+
+// truncateStatus cuts status to MaxStatusLength bytes, multibyte characters are never split.
+func truncateStatus(status string) string {
+	if len(status) <= MaxStatusLength {
+		return status
+	}
+
+	cut := MaxStatusLength
+	for cut > 0 && !utf8.RuneStart(status[cut]) {
+		cut--
+	}
+
+	return status[:cut]
+}
+
+type cancelCommand struct {
+	Cancel bool `json:"cancel"`
+}
+
 type stopCommand struct {
 	Stop bool `json:"stop"`
 }
 
+type pingCommand struct {
+	Ping bool `json:"ping"`
+}
+
+type versionCommand struct {
+	Version string `json:"version"`
+}
+
 type pidCommand struct {
-	Pid int `json:"pid"`
+	Pid   int    `json:"pid"`
+	Hmac  string `json:"hmac,omitempty"`
+	Token string `json:"token,omitempty"`
+
+	// compression codec offered by the factory and accepted by the worker, see CompressionDeflate
+	Compress string `json:"compress,omitempty"`
+
+	// control commands supported by the worker, see Worker.Control
+	Controls []string `json:"controls,omitempty"`
+}
+
+// meta returns handshake metadata reported by the worker, see CapabilityControls.
+func (c *pidCommand) meta() map[string]string {
+	if len(c.Controls) == 0 {
+		return nil
+	}
+
+	return map[string]string{CapabilityControls: strings.Join(c.Controls, ",")}
 }
 
 func sendControl(rl goridge.Relay, v interface{}) error {
@@ -30,22 +195,180 @@ func sendControl(rl goridge.Relay, v interface{}) error {
 }
 
 func fetchPID(rl goridge.Relay) (pid int, err error) {
-	if err := sendControl(rl, pidCommand{Pid: os.Getpid()}); err != nil {
+	link, err := handshake(rl, "", "")
+	if err != nil {
 		return 0, err
 	}
 
+	return link.Pid, nil
+}
+
+// fetchSignedPID fetches worker PID and verifies that it has been signed using given secret and
+// that worker echoes given pool token. Empty secret or token disables the verification. Claimed PID
+// is returned along with the verification error, 0 if PID is unknown.
+func fetchSignedPID(rl goridge.Relay, secret []byte, token string) (pid int, err error) {
+	pid, _, err = negotiatePID(rl, secret, token, "")
+	return pid, err
+}
+
+// negotiatePID verifies the worker like fetchSignedPID offering the given compression codec,
+// empty to offer none. Returns codec accepted by the worker, empty when worker keeps the relay
+// uncompressed.
+func negotiatePID(rl goridge.Relay, secret []byte, token string, compress string) (pid int, codec string, err error) {
+	link, err := negotiate(rl, secret, token, compress)
+	if link == nil {
+		return 0, "", err
+	}
+
+	if err != nil {
+		return link.Pid, "", err
+	}
+
+	return link.Pid, link.Compress, nil
+}
+
+// negotiate performs the handshake like negotiatePID, returns nil when worker did not respond
+// with the pid command and the claimed command along with the verification error otherwise.
+func negotiate(rl goridge.Relay, secret []byte, token string, compress string) (*pidCommand, error) {
+	link, err := handshake(rl, token, compress)
+	if err != nil {
+		return nil, err
+	}
+
+	if link.Compress != "" && link.Compress != compress {
+		return link, fmt.Errorf("compression `%s` has not been offered", link.Compress)
+	}
+
+	if token != "" && link.Token != token {
+		return link, fmt.Errorf("pool token mismatch")
+	}
+
+	if len(secret) == 0 {
+		return link, nil
+	}
+
+	sign, err := hex.DecodeString(link.Hmac)
+	if err != nil || !hmac.Equal(sign, signPID(link.Pid, secret)) {
+		return link, fmt.Errorf("invalid pid signature")
+	}
+
+	return link, nil
+}
+
+// signPID creates HMAC-SHA256 signature of the PID using given secret.
+func signPID(pid int, secret []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strconv.Itoa(pid)))
+
+	return mac.Sum(nil)
+}
+
+// pingRelay sends ping command to the worker and expects control frame in response. Relay is closed
+// when worker does not respond in a given time.
+func pingRelay(rl goridge.Relay, tout time.Duration) error {
+	done := make(chan error, 1)
+	go func() {
+		if err := sendControl(rl, pingCommand{Ping: true}); err != nil {
+			done <- err
+			return
+		}
+
+		_, p, err := rl.Receive()
+		if err == nil && !p.HasFlag(goridge.PayloadControl) {
+			err = fmt.Errorf("unexpected response, header is missing")
+		}
+
+		done <- err
+	}()
+
+	timer := time.NewTimer(tout)
+	defer timer.Stop()
+
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		_ = rl.Close()
+		return fmt.Errorf("ping timeout")
+	}
+}
+
+// fetchVersion requests worker protocol version. Relay is closed when worker does not respond in a
+// given time.
+func fetchVersion(rl goridge.Relay, tout time.Duration) (string, error) {
+	type result struct {
+		version string
+		err     error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		if err := sendControl(rl, versionCommand{Version: "?"}); err != nil {
+			done <- result{err: err}
+			return
+		}
+
+		body, p, err := rl.Receive()
+		if err != nil {
+			done <- result{err: err}
+			return
+		}
+
+		if !p.HasFlag(goridge.PayloadControl) {
+			done <- result{err: fmt.Errorf("unexpected response, header is missing")}
+			return
+		}
+
+		cmd := &versionCommand{}
+		if err := json.Unmarshal(body, cmd); err != nil {
+			done <- result{err: err}
+			return
+		}
+
+		done <- result{version: cmd.Version}
+	}()
+
+	timer := time.NewTimer(tout)
+	defer timer.Stop()
+
+	select {
+	case r := <-done:
+		return r.version, r.err
+	case <-timer.C:
+		_ = rl.Close()
+		return "", fmt.Errorf("protocol version timeout")
+	}
+}
+
+// handshake exchanges pid commands with the worker:
+//
+//  1. factory sends control frame {"pid":<factory pid>,"token":"<pool token>","compress":"<codec>"}, token
+//     and compress are omitted when empty;
+//  2. worker replies with control frame {"pid":<worker pid>,"hmac":"<pid signature>","token":"<RR_POOL_TOKEN>",
+//     "compress":"<codec>","controls":["<command>"]}, hmac and token are omitted when worker has no
+//     RR_RELAY_SECRET or RR_POOL_TOKEN configured, compress is omitted unless worker supports the offered
+//     codec, controls lists supported control commands (see Worker.Control) and might be omitted;
+//  3. socket factory closes the connection when token does not match or pid signature is invalid;
+//  4. relay is compressed in both directions once worker accepted the codec, see CompressionDeflate.
+//
+// Worker must echo the token it has been configured with, not the one received from the factory.
+func handshake(rl goridge.Relay, token string, compress string) (*pidCommand, error) {
+	if err := sendControl(rl, pidCommand{Pid: os.Getpid(), Token: token, Compress: compress}); err != nil {
+		return nil, err
+	}
+
 	body, p, err := rl.Receive()
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 	if !p.HasFlag(goridge.PayloadControl) {
-		return 0, fmt.Errorf("unexpected response, header is missing")
+		return nil, fmt.Errorf("unexpected response, header is missing")
 	}
 
 	link := &pidCommand{}
 	if err := json.Unmarshal(body, link); err != nil {
-		return 0, err
+		return nil, err
 	}
 
-	return link.Pid, nil
+	return link, nil
 }
@@ -1,10 +1,13 @@
 package roadrunner
 
 import (
+	"encoding/hex"
 	"github.com/pkg/errors"
 	"github.com/spiral/goridge/v2"
 	"github.com/stretchr/testify/assert"
+	"strings"
 	"testing"
+	"unicode/utf8"
 )
 
 type relayMock struct {
@@ -44,3 +47,108 @@ func Test_Protocol_FetchPID(t *testing.T) {
 	_, err = fetchPID(&relayMock{error: false, payload: "{\"pid:100"})
 	assert.Error(t, err)
 }
+
+func Test_Protocol_FetchSignedPID(t *testing.T) {
+	secret := []byte("secret")
+	sign := hex.EncodeToString(signPID(100, secret))
+
+	pid, err := fetchSignedPID(&relayMock{payload: "{\"pid\":100,\"hmac\":\"" + sign + "\"}"}, secret, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 100, pid)
+
+	_, err = fetchSignedPID(&relayMock{payload: "{\"pid\":101,\"hmac\":\"" + sign + "\"}"}, secret, "")
+	assert.Error(t, err)
+
+	_, err = fetchSignedPID(&relayMock{payload: "{\"pid\":100}"}, secret, "")
+	assert.Error(t, err)
+
+	pid, err = fetchSignedPID(&relayMock{payload: "{\"pid\":100}"}, nil, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 100, pid)
+}
+
+func Test_Protocol_FetchPID_Token(t *testing.T) {
+	pid, err := fetchSignedPID(&relayMock{payload: "{\"pid\":100,\"token\":\"pool\"}"}, nil, "pool")
+	assert.NoError(t, err)
+	assert.Equal(t, 100, pid)
+
+	_, err = fetchSignedPID(&relayMock{payload: "{\"pid\":100,\"token\":\"other\"}"}, nil, "pool")
+	assert.Error(t, err)
+
+	_, err = fetchSignedPID(&relayMock{payload: "{\"pid\":100}"}, nil, "pool")
+	assert.Error(t, err)
+
+	// token is not verified when pool has none
+	pid, err = fetchSignedPID(&relayMock{payload: "{\"pid\":100,\"token\":\"pool\"}"}, nil, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 100, pid)
+}
+
+func Test_Protocol_NegotiatePID(t *testing.T) {
+	pid, codec, err := negotiatePID(&relayMock{payload: "{\"pid\":100,\"compress\":\"deflate\"}"}, nil, "", CompressionDeflate)
+	assert.NoError(t, err)
+	assert.Equal(t, 100, pid)
+	assert.Equal(t, CompressionDeflate, codec)
+
+	// offer declined
+	_, codec, err = negotiatePID(&relayMock{payload: "{\"pid\":100}"}, nil, "", CompressionDeflate)
+	assert.NoError(t, err)
+	assert.Equal(t, "", codec)
+
+	_, _, err = negotiatePID(&relayMock{payload: "{\"pid\":100,\"compress\":\"lz4\"}"}, nil, "", CompressionDeflate)
+	assert.Error(t, err)
+
+	_, _, err = negotiatePID(&relayMock{payload: "{\"pid\":100,\"compress\":\"deflate\"}"}, nil, "", "")
+	assert.Error(t, err)
+}
+
+func Test_Protocol_PeakMemory(t *testing.T) {
+	assert.Equal(t, uint64(0), peakMemory(nil))
+	assert.Equal(t, uint64(0), peakMemory(&Payload{}))
+	assert.Equal(t, uint64(0), peakMemory(&Payload{Context: []byte(`{"status":200}`)}))
+	assert.Equal(t, uint64(0), peakMemory(&Payload{Context: []byte(`{"peak_memory":"invalid"}`)}))
+	assert.Equal(t, uint64(0), peakMemory(&Payload{Context: []byte(StopRequest)}))
+	assert.Equal(t, uint64(2048), peakMemory(&Payload{Context: []byte(`{"status":200,"peak_memory":2048}`)}))
+}
+
+func Test_Protocol_ReportedStatus(t *testing.T) {
+	for _, rsp := range []*Payload{
+		nil,
+		{},
+		{Context: []byte(`{"status":200}`)},
+		{Context: []byte(`{"worker_status":503}`)},
+		{Context: []byte(`{"worker_status":null}`)},
+		{Context: []byte(StopRequest)},
+	} {
+		_, ok := reportedStatus(rsp)
+		assert.False(t, ok)
+	}
+
+	status, ok := reportedStatus(&Payload{Context: []byte(`{"status":200,"worker_status":"degraded"}`)})
+	assert.True(t, ok)
+	assert.Equal(t, "degraded", status)
+
+	// empty status is reported
+	status, ok = reportedStatus(&Payload{Context: []byte(`{"worker_status":""}`)})
+	assert.True(t, ok)
+	assert.Equal(t, "", status)
+
+	status, _ = reportedStatus(&Payload{Context: []byte(`{"worker_status":"` + strings.Repeat("x", 300) + `"}`)})
+	assert.Len(t, status, MaxStatusLength)
+
+	// multibyte character crossing the limit is dropped
+	status, _ = reportedStatus(&Payload{Context: []byte(`{"worker_status":"x` + strings.Repeat("é", 200) + `"}`)})
+	assert.Len(t, status, MaxStatusLength-1)
+	assert.True(t, utf8.ValidString(status))
+}
+
+func Test_Protocol_ObservePeak(t *testing.T) {
+	var peak uint64
+
+	observePeak(&peak, 10)
+	observePeak(&peak, 5)
+	assert.Equal(t, uint64(10), peak)
+
+	observePeak(&peak, 20)
+	assert.Equal(t, uint64(20), peak)
+}
@@ -0,0 +1,60 @@
+package roadrunner
+
+import "sync"
+
+// slotQuarantine counts consecutive failures of the pool worker slots, slot which failed
+// threshold times in a row is quarantined until worker of the slot starts again.
+type slotQuarantine struct {
+	// number of consecutive failures quarantining the slot, 0 to disable
+	threshold int64
+
+	mu sync.Mutex
+
+	// consecutive failures by slot index
+	failures map[int]int64
+}
+
+// newSlotQuarantine creates quarantine of the slots failing threshold times in a row.
+func newSlotQuarantine(threshold int64) *slotQuarantine {
+	return &slotQuarantine{threshold: threshold, failures: make(map[int]int64)}
+}
+
+// failed registers failure of the slot worker (unexpected death or failed start), returns
+// true when slot is quarantined.
+func (q *slotQuarantine) failed(index int) bool {
+	if q.threshold == 0 {
+		return false
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.failures[index]++
+	return q.failures[index] >= q.threshold
+}
+
+// succeeded clears failures of the slot, slot leaves the quarantine.
+func (q *slotQuarantine) succeeded(index int) {
+	if q.threshold == 0 {
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	delete(q.failures, index)
+}
+
+// count returns number of quarantined slots.
+func (q *slotQuarantine) count() (n int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, failures := range q.failures {
+		if failures >= q.threshold {
+			n++
+		}
+	}
+
+	return n
+}
@@ -0,0 +1,99 @@
+package roadrunner
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"sync"
+	"time"
+)
+
+// readyGate holds new tasks until pool starts minimal number of workers during the cold start,
+// gate stays open once opened.
+type readyGate struct {
+	// number of started workers gate opens at
+	min int
+
+	once sync.Once
+
+	// closed once min workers are started
+	ready chan interface{}
+
+	mu sync.Mutex
+
+	// last worker start error while gate is closed
+	err error
+}
+
+// newReadyGate creates gate which opens once min workers are started.
+func newReadyGate(min int) *readyGate {
+	return &readyGate{min: min, ready: make(chan interface{})}
+}
+
+// started opens the gate once at least min workers are started.
+func (g *readyGate) started(n int) {
+	if n >= g.min {
+		g.once.Do(func() { close(g.ready) })
+	}
+}
+
+// failed remembers worker start error to be reported while gate is closed.
+func (g *readyGate) failed(err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.err = err
+}
+
+// isReady returns true once gate is open.
+func (g *readyGate) isReady() bool {
+	select {
+	case <-g.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// wait waits for the gate to open, ErrPoolNotReady is returned without waiting when reject is
+// set or once timeout expires (0 to wait until context is done). Returns ErrPoolDestroyed once
+// destroy is closed or context error when context is done.
+func (g *readyGate) wait(ctx context.Context, reject bool, timeout time.Duration, destroy chan interface{}) error {
+	if g.isReady() {
+		return nil
+	}
+
+	if reject {
+		return g.notReady()
+	}
+
+	var expired <-chan time.Time
+	if timeout != 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+
+		expired = timer.C
+	}
+
+	select {
+	case <-g.ready:
+		return nil
+	case <-expired:
+		return g.notReady()
+	case <-destroy:
+		return ErrPoolDestroyed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// notReady returns ErrPoolNotReady describing the last worker start error.
+func (g *readyGate) notReady() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.err != nil {
+		return errors.Wrapf(ErrPoolNotReady, "%v", g.err)
+	}
+
+	return ErrPoolNotReady
+}
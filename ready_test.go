@@ -0,0 +1,61 @@
+package roadrunner
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func Test_ReadyGate(t *testing.T) {
+	g := newReadyGate(2)
+	destroy := make(chan interface{})
+
+	assert.False(t, g.isReady())
+	assert.Equal(t, ErrPoolNotReady, g.wait(context.Background(), true, 0, destroy))
+
+	done := make(chan error)
+	go func() {
+		done <- g.wait(context.Background(), false, 0, destroy)
+	}()
+
+	g.started(1)
+
+	select {
+	case <-done:
+		t.Fatal("task passed the closed gate")
+	case <-time.After(time.Millisecond * 50):
+	}
+
+	g.started(2)
+	assert.NoError(t, <-done)
+	assert.True(t, g.isReady())
+
+	// gate stays open
+	g.started(1)
+	assert.NoError(t, g.wait(context.Background(), true, 0, destroy))
+}
+
+func Test_ReadyGate_Timeout(t *testing.T) {
+	g := newReadyGate(1)
+	g.failed(fmt.Errorf("spawn error"))
+
+	err := g.wait(context.Background(), false, time.Millisecond*10, make(chan interface{}))
+	assert.True(t, errors.Is(err, ErrPoolNotReady))
+	assert.Equal(t, "spawn error: pool is not ready", err.Error())
+}
+
+func Test_ReadyGate_Destroy(t *testing.T) {
+	g := newReadyGate(1)
+	destroy := make(chan interface{})
+	close(destroy)
+
+	assert.Equal(t, ErrPoolDestroyed, g.wait(context.Background(), false, 0, destroy))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	assert.Equal(t, context.Canceled, g.wait(ctx, false, 0, make(chan interface{})))
+}
@@ -0,0 +1,125 @@
+// +build linux
+
+package roadrunner
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// zombies returns PIDs of the child processes which exited and have not been reaped, waits up
+// to a second for the pending reaps.
+func zombies(t *testing.T) (pids []int) {
+	deadline := time.Now().Add(time.Second)
+	for {
+		pids = pids[:0]
+
+		stats, err := filepath.Glob("/proc/[0-9]*/stat")
+		assert.NoError(t, err)
+
+		for _, stat := range stats {
+			data, err := ioutil.ReadFile(stat)
+			if err != nil {
+				// process is gone
+				continue
+			}
+
+			// pid (comm) state ppid ...
+			fields := bytes.Fields(data[bytes.LastIndexByte(data, ')')+1:])
+			if len(fields) < 2 || string(fields[0]) != "Z" || string(fields[1]) != strconv.Itoa(os.Getpid()) {
+				continue
+			}
+
+			pid, _ := strconv.Atoi(filepath.Base(filepath.Dir(stat)))
+			pids = append(pids, pid)
+		}
+
+		if len(pids) == 0 || time.Now().After(deadline) {
+			return pids
+		}
+
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func Test_Reap_FailedStart(t *testing.T) {
+	f := NewPipeFactory()
+	for i := 0; i < 50; i++ {
+		// process exits before the relay is associated
+		_, err := f.SpawnWorker(exec.Command("false"))
+		assert.Error(t, err)
+	}
+
+	assert.Empty(t, zombies(t))
+}
+
+func Test_Reap_Unclaimed(t *testing.T) {
+	time.Sleep(time.Millisecond * 10) // to ensure free socket
+
+	ls, err := net.Listen("tcp", "localhost:9007")
+	if assert.NoError(t, err) {
+		defer ls.Close()
+	} else {
+		t.Skip("socket is busy")
+	}
+
+	f := NewSocketFactory(ls, time.Millisecond*20)
+	for i := 0; i < 20; i++ {
+		// process never connects and is killed once relay wait times out
+		_, err := f.SpawnWorker(exec.Command("sleep", "10"))
+		assert.Error(t, err)
+	}
+
+	assert.Empty(t, zombies(t))
+}
+
+func Test_Reap_Killed(t *testing.T) {
+	f := NewPipeFactory()
+	for i := 0; i < 20; i++ {
+		w, err := f.SpawnWorker(exec.Command("php", "tests/client.php", "echo", "pipes"))
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		// worker crashes while idle, nobody waits for it
+		assert.NoError(t, w.Kill())
+	}
+
+	assert.Empty(t, zombies(t))
+}
+
+func Test_Reap_Pool(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "echo", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      4,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	assert.NoError(t, err)
+
+	// idle workers crash and are replaced
+	for i := 0; i < 5; i++ {
+		for _, w := range p.Workers() {
+			assert.NoError(t, w.Kill())
+		}
+
+		time.Sleep(time.Millisecond * 50)
+	}
+
+	_, err = p.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+
+	p.Destroy()
+	assert.Empty(t, zombies(t))
+}
@@ -0,0 +1,172 @@
+package roadrunner
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"sync"
+	"sync/atomic"
+)
+
+// RecycleReason describes why pool has stopped the worker, see PoolStats.RecycleReasons.
+type RecycleReason int
+
+const (
+	// RecycleCrash worker process died unexpectedly.
+	RecycleCrash RecycleReason = iota
+
+	// RecycleError worker failed the task or did not respond to the pool (broken relay, failed
+	// ping, released as broken).
+	RecycleError
+
+	// RecycleTimeout worker did not complete the task within ExecTimeout.
+	RecycleTimeout
+
+	// RecycleMaxJobs worker executed MaxJobs tasks.
+	RecycleMaxJobs
+
+	// RecycleMaxMemory worker exceeded MaxMemory.
+	RecycleMaxMemory
+
+	// RecycleMaxAge worker exceeded MaxAge.
+	RecycleMaxAge
+
+	// RecycleIdle worker has been idle for too long or too many workers were idle (dynamic pool).
+	RecycleIdle
+
+	// RecycleUnhealthy worker reported itself unhealthy.
+	RecycleUnhealthy
+
+	// RecycleStopRequest worker requested termination.
+	RecycleStopRequest
+
+	// RecycleReload worker has been replaced by the reload or reset.
+	RecycleReload
+
+	// RecycleRemoved worker has been removed using Pool.Remove, for example by the limit service.
+	RecycleRemoved
+
+	// RecycleRolling worker has been replaced by the rolling replacement, see
+	// Config.RollingReplaceInterval.
+	RecycleRolling
+
+	// RecycleMaxTotalMemory idle worker has been trimmed to keep the pool within MaxTotalMemory.
+	RecycleMaxTotalMemory
+
+	// RecycleProtocolViolation worker sent more response frames than MaxResponseFrames allows or
+	// too many malformed responses in a row, see Config.MaxConsecutiveMalformed.
+	RecycleProtocolViolation
+
+	// RecycleProbe worker failed the health probe, see Config.ProbeInterval.
+	RecycleProbe
+
+	// RecycleResize worker has been retired by shrinking the pool, see StaticPool.Resize.
+	RecycleResize
+
+	numRecycleReasons
+)
+
+// String returns reason name.
+func (r RecycleReason) String() string {
+	switch r {
+	case RecycleCrash:
+		return "crash"
+	case RecycleError:
+		return "error"
+	case RecycleTimeout:
+		return "timeout"
+	case RecycleMaxJobs:
+		return "max_jobs"
+	case RecycleMaxMemory:
+		return "max_memory"
+	case RecycleMaxAge:
+		return "max_age"
+	case RecycleIdle:
+		return "idle"
+	case RecycleUnhealthy:
+		return "unhealthy"
+	case RecycleStopRequest:
+		return "stop_request"
+	case RecycleReload:
+		return "reload"
+	case RecycleRemoved:
+		return "removed"
+	case RecycleRolling:
+		return "rolling"
+	case RecycleMaxTotalMemory:
+		return "max_total_memory"
+	case RecycleProtocolViolation:
+		return "protocol_violation"
+	case RecycleProbe:
+		return "probe"
+	case RecycleResize:
+		return "resize"
+	}
+
+	return "undefined"
+}
+
+// execReason returns reason of stopping the worker which failed to execute the task.
+func execReason(err error) RecycleReason {
+	if err == ErrExecTimeout || err == context.DeadlineExceeded {
+		return RecycleTimeout
+	}
+
+	if errors.Cause(err) == ErrTooManyFrames {
+		return RecycleProtocolViolation
+	}
+
+	return RecycleError
+}
+
+// recycleStats counts stopped workers by reason, worker is accounted once its process exits.
+type recycleStats struct {
+	// reasons of the workers being stopped by the pool, first given reason is kept
+	reasons sync.Map
+
+	// number of workers by reason, accessed atomically
+	counts [numRecycleReasons]int64
+}
+
+// mark remembers why worker is being stopped, reason given first wins.
+func (s *recycleStats) mark(w *Worker, reason RecycleReason) {
+	s.reasons.LoadOrStore(w, reason)
+}
+
+// exited forgets the worker and returns its reason, stopped is false when worker has not been
+// stopped by the pool. Worker killed by the execution timeout is accounted as stopped even if
+// pool has not marked it yet.
+func (s *recycleStats) exited(w *Worker) (reason RecycleReason, stopped bool) {
+	r, stopped := s.reasons.Load(w)
+	s.reasons.Delete(w)
+
+	if !stopped && atomic.LoadInt32(&w.timedOut) == 1 {
+		return RecycleTimeout, true
+	}
+
+	if !stopped {
+		return RecycleCrash, false
+	}
+
+	return r.(RecycleReason), true
+}
+
+// add accounts stopped worker.
+func (s *recycleStats) add(reason RecycleReason) {
+	atomic.AddInt64(&s.counts[reason], 1)
+}
+
+// snapshot returns number of stopped workers by reason, reasons without workers are omitted.
+// Nil is returned until first worker is stopped.
+func (s *recycleStats) snapshot() (counts map[RecycleReason]int64) {
+	for reason := range s.counts {
+		if n := atomic.LoadInt64(&s.counts[reason]); n != 0 {
+			if counts == nil {
+				counts = make(map[RecycleReason]int64)
+			}
+
+			counts[RecycleReason(reason)] = n
+		}
+	}
+
+	return counts
+}
@@ -0,0 +1,45 @@
+package roadrunner
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os/exec"
+	"sync"
+	"testing"
+)
+
+func Test_RecycleReason_String(t *testing.T) {
+	assert.Equal(t, "crash", RecycleCrash.String())
+	assert.Equal(t, "max_memory", RecycleMaxMemory.String())
+	assert.Equal(t, "removed", RecycleRemoved.String())
+	assert.Equal(t, "rolling", RecycleRolling.String())
+	assert.Equal(t, "max_total_memory", RecycleMaxTotalMemory.String())
+	assert.Equal(t, "protocol_violation", RecycleProtocolViolation.String())
+	assert.Equal(t, "probe", RecycleProbe.String())
+	assert.Equal(t, "resize", RecycleResize.String())
+	assert.Equal(t, "undefined", numRecycleReasons.String())
+}
+
+func Test_RecycleStats_Concurrent(t *testing.T) {
+	s := &recycleStats{}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			w, _ := newWorker(exec.Command("php", "tests/client.php", "echo", "pipes"))
+			if i%2 == 0 {
+				// first reason is kept
+				s.mark(w, RecycleMaxJobs)
+				s.mark(w, RecycleRemoved)
+			}
+
+			reason, _ := s.exited(w)
+			s.add(reason)
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, map[RecycleReason]int64{RecycleCrash: 50, RecycleMaxJobs: 50}, s.snapshot())
+}
@@ -0,0 +1,27 @@
+package roadrunner
+
+import (
+	"time"
+)
+
+// ResourceLimits defines operating system limits applied to every worker process spawned by
+// the factory. Limits are applied before the worker program runs. Worker killed due to the
+// CPU time limit reports SIGXCPU ("CPU time limit exceeded") in WaitError. Limits are
+// supported on Linux only and ignored on other platforms. Zero values disable the limit.
+type ResourceLimits struct {
+	// MaxMemory limits virtual address space of the process (RLIMIT_AS) in megabytes, memory
+	// allocations fail once limit is reached.
+	MaxMemory uint64
+
+	// MaxCPUTime limits CPU time consumed by the process (RLIMIT_CPU), rounded up to seconds.
+	// Process receives SIGXCPU once limit is reached and SIGKILL one second later.
+	MaxCPUTime time.Duration
+
+	// MaxOpenFiles limits number of file descriptors the process can open (RLIMIT_NOFILE).
+	MaxOpenFiles uint64
+}
+
+// cpuSeconds returns CPU time limit rounded up to seconds.
+func (l ResourceLimits) cpuSeconds() uint64 {
+	return uint64((l.MaxCPUTime + time.Second - 1) / time.Second)
+}
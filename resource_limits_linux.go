@@ -0,0 +1,116 @@
+// +build linux
+
+package roadrunner
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// start starts the command with limits applied before the worker program runs: process is traced
+// from the fork, stops once it has executed the program, receives the limits and is detached.
+func (l ResourceLimits) start(cmd *exec.Cmd) error {
+	if l == (ResourceLimits{}) {
+		return cmd.Start()
+	}
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Ptrace = true
+
+	// ptrace requests must come from the thread which has started the process
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	pid := cmd.Process.Pid
+	if err := waitExec(pid); err != nil {
+		_ = cmd.Process.Kill()
+		return fmt.Errorf("unable to trace process: %s", err)
+	}
+
+	err := l.apply(pid)
+	if derr := syscall.PtraceDetach(pid); err == nil && derr != nil {
+		err = fmt.Errorf("unable to detach process: %s", derr)
+	}
+
+	if err != nil {
+		_ = cmd.Process.Kill()
+		return fmt.Errorf("resource limits: %s", err)
+	}
+
+	return nil
+}
+
+// waitExec waits for the traced process to stop right after the exec, signals received before
+// the exec are passed through.
+func waitExec(pid int) error {
+	for {
+		var ws syscall.WaitStatus
+		if _, err := syscall.Wait4(pid, &ws, 0, nil); err != nil {
+			return err
+		}
+
+		if !ws.Stopped() {
+			return fmt.Errorf("process exited before exec")
+		}
+
+		if ws.StopSignal() == syscall.SIGTRAP {
+			return nil
+		}
+
+		if err := syscall.PtraceCont(pid, int(ws.StopSignal())); err != nil {
+			return err
+		}
+	}
+}
+
+// apply sets limits of the running process with the given pid.
+func (l ResourceLimits) apply(pid int) error {
+	if l.MaxMemory != 0 {
+		if err := prlimit(pid, syscall.RLIMIT_AS, l.MaxMemory*1024*1024, l.MaxMemory*1024*1024); err != nil {
+			return fmt.Errorf("unable to limit memory: %s", err)
+		}
+	}
+
+	if l.MaxCPUTime != 0 {
+		// process receives SIGXCPU once soft limit is reached and SIGKILL one second later
+		if err := prlimit(pid, syscall.RLIMIT_CPU, l.cpuSeconds(), l.cpuSeconds()+1); err != nil {
+			return fmt.Errorf("unable to limit cpu time: %s", err)
+		}
+	}
+
+	if l.MaxOpenFiles != 0 {
+		if err := prlimit(pid, syscall.RLIMIT_NOFILE, l.MaxOpenFiles, l.MaxOpenFiles); err != nil {
+			return fmt.Errorf("unable to limit open files: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// prlimit sets soft and hard limit of the resource for the given process.
+func prlimit(pid int, resource int, soft, hard uint64) error {
+	rlim := syscall.Rlimit{Cur: soft, Max: hard}
+
+	_, _, errno := syscall.Syscall6(
+		syscall.SYS_PRLIMIT64,
+		uintptr(pid),
+		uintptr(resource),
+		uintptr(unsafe.Pointer(&rlim)),
+		0, 0, 0,
+	)
+
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
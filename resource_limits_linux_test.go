@@ -0,0 +1,95 @@
+// +build linux
+
+package roadrunner
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// limit returns soft limit of the given process resource as listed in /proc/pid/limits.
+func limit(t *testing.T, pid int, name string) string {
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%v/limits", pid))
+	assert.NoError(t, err)
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, name) {
+			return strings.Fields(strings.TrimPrefix(line, name))[0]
+		}
+	}
+
+	return ""
+}
+
+func Test_ResourceLimits_Apply(t *testing.T) {
+	w, _ := newWorker(exec.Command("sleep", "10"))
+	assert.NoError(t, w.start())
+	defer w.Kill()
+
+	err := ResourceLimits{
+		MaxMemory:    512,
+		MaxCPUTime:   time.Millisecond * 1500,
+		MaxOpenFiles: 64,
+	}.apply(*w.Pid)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "536870912", limit(t, *w.Pid, "Max address space"))
+	assert.Equal(t, "2", limit(t, *w.Pid, "Max cpu time"))
+	assert.Equal(t, "64", limit(t, *w.Pid, "Max open files"))
+}
+
+func Test_ResourceLimits_Start(t *testing.T) {
+	// program observes the limits from its first instruction
+	cmd := exec.Command("sh", "-c", "ulimit -n")
+	out := &bytes.Buffer{}
+	cmd.Stdout = out
+
+	assert.NoError(t, ResourceLimits{MaxOpenFiles: 64}.start(cmd))
+	assert.NoError(t, cmd.Wait())
+	assert.Equal(t, "64\n", out.String())
+
+	// no limits
+	cmd = exec.Command("sh", "-c", "exit 0")
+	assert.NoError(t, ResourceLimits{}.start(cmd))
+	assert.Nil(t, cmd.SysProcAttr)
+	assert.NoError(t, cmd.Wait())
+}
+
+func Test_ResourceLimits_CPUTime(t *testing.T) {
+	w, _ := newWorker(exec.Command("sh", "-c", "while :; do :; done"))
+	assert.NoError(t, w.start())
+	defer w.Kill()
+
+	assert.NoError(t, ResourceLimits{MaxCPUTime: time.Second}.apply(*w.Pid))
+
+	wErr, ok := w.Wait().(WaitError)
+	assert.True(t, ok)
+	assert.Equal(t, syscall.SIGXCPU, wErr.Signal)
+	assert.Equal(t, "signal: CPU time limit exceeded", wErr.Error())
+}
+
+func Test_ResourceLimits_Error(t *testing.T) {
+	w, _ := newWorker(exec.Command("sleep", "10"))
+	assert.NoError(t, w.start())
+	w.Kill()
+
+	assert.Error(t, ResourceLimits{MaxOpenFiles: 64}.apply(*w.Pid))
+}
+
+func Test_PipeFactory_ResourceLimits(t *testing.T) {
+	f := NewPipeFactory()
+	f.ResourceLimits = ResourceLimits{MaxOpenFiles: 64}
+
+	w, err := f.SpawnWorker(exec.Command("php", "tests/client.php", "echo", "pipes"))
+	assert.NoError(t, err)
+	defer w.Stop()
+
+	assert.Equal(t, "64", limit(t, *w.Pid, "Max open files"))
+}
@@ -0,0 +1,15 @@
+// +build !linux
+
+package roadrunner
+
+import "os/exec"
+
+// start starts the command, resource limits are supported on Linux only.
+func (l ResourceLimits) start(cmd *exec.Cmd) error {
+	return cmd.Start()
+}
+
+// apply does nothing, resource limits are supported on Linux only.
+func (l ResourceLimits) apply(pid int) error {
+	return nil
+}
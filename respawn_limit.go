@@ -0,0 +1,102 @@
+package roadrunner
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// respawnLimiter paces replacements of the dead workers using the token bucket, so a crash storm
+// turns into a controlled ramp instead of hammering a recovering dependency.
+type respawnLimiter struct {
+	// respawns per second, 0 for unlimited
+	rate float64
+
+	// max number of respawns allowed at once
+	burst float64
+
+	clock Clock
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+
+	// times of the respawns within the last second
+	recent []time.Time
+}
+
+// newRespawnLimiter creates limiter allowing rate respawns per second, zero rate disables the
+// limit. Burst lower than 1 allows single respawn at once.
+func newRespawnLimiter(rate float64, burst int64, clock Clock) *respawnLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+
+	clock = clockOrSystem(clock)
+
+	return &respawnLimiter{
+		rate:   rate,
+		burst:  float64(burst),
+		clock:  clock,
+		tokens: float64(burst),
+		last:   clock.Now(),
+	}
+}
+
+// wait waits for the respawn token, returns false once done is closed first.
+func (l *respawnLimiter) wait(done chan interface{}) bool {
+	for {
+		d := l.take()
+		if d == 0 {
+			return true
+		}
+
+		timer := l.clock.NewTimer(d)
+		select {
+		case <-timer.C():
+		case <-done:
+			timer.Stop()
+			return false
+		}
+	}
+}
+
+// take takes the token and registers the respawn, returns time until the next token otherwise.
+func (l *respawnLimiter) take() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+	if l.rate != 0 {
+		l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+		l.last = now
+
+		if l.tokens < 1 {
+			return time.Duration(math.Ceil((1 - l.tokens) / l.rate * float64(time.Second)))
+		}
+
+		l.tokens--
+	}
+
+	l.recent = append(l.trim(now), now)
+	return 0
+}
+
+// current returns number of respawns within the last second.
+func (l *respawnLimiter) current() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.recent = l.trim(l.clock.Now())
+	return float64(len(l.recent))
+}
+
+// trim drops respawns older than a second.
+func (l *respawnLimiter) trim(now time.Time) []time.Time {
+	i := 0
+	for i < len(l.recent) && now.Sub(l.recent[i]) >= time.Second {
+		i++
+	}
+
+	return l.recent[i:]
+}
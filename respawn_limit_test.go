@@ -0,0 +1,130 @@
+package roadrunner
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os/exec"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_RespawnLimiter_Unlimited(t *testing.T) {
+	clock := newMockClock()
+	l := newRespawnLimiter(0, 0, clock)
+
+	for i := 0; i < 100; i++ {
+		assert.True(t, l.wait(nil))
+	}
+	assert.Equal(t, float64(100), l.current())
+
+	clock.Advance(time.Second)
+	assert.Equal(t, float64(0), l.current())
+}
+
+func Test_RespawnLimiter_Rate(t *testing.T) {
+	clock := newMockClock()
+	l := newRespawnLimiter(2, 3, clock)
+
+	// burst is available at once
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, time.Duration(0), l.take())
+	}
+	assert.Equal(t, time.Millisecond*500, l.take())
+
+	clock.Advance(time.Millisecond * 250)
+	assert.Equal(t, time.Millisecond*250, l.take())
+
+	clock.Advance(time.Millisecond * 250)
+	assert.Equal(t, time.Duration(0), l.take())
+	assert.Equal(t, time.Millisecond*500, l.take())
+
+	// 4 respawns during the last second
+	assert.Equal(t, float64(4), l.current())
+	clock.Advance(time.Millisecond * 500)
+	assert.Equal(t, float64(1), l.current())
+}
+
+func Test_RespawnLimiter_Wait(t *testing.T) {
+	clock := newMockClock()
+	l := newRespawnLimiter(1, 0, clock)
+	assert.True(t, l.wait(nil))
+
+	done := make(chan bool)
+	go func() {
+		done <- l.wait(nil)
+	}()
+
+	assert.True(t, clock.WaitTimers(1))
+	select {
+	case <-done:
+		t.Fatal("token taken before it's available")
+	default:
+	}
+
+	clock.Advance(time.Second)
+	assert.True(t, <-done)
+
+	// waiting respawn is released by the pool destroy
+	destroy := make(chan interface{})
+	go func() {
+		done <- l.wait(destroy)
+	}()
+
+	assert.True(t, clock.WaitTimers(1))
+	close(destroy)
+	assert.False(t, <-done)
+}
+
+func Test_StaticPool_RespawnRateLimit(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "echo", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:       6,
+			RespawnRateLimit: 10,
+			RespawnBurst:     2,
+			AllocateTimeout:  time.Second * 5,
+			DestroyTimeout:   time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	var (
+		mu      sync.Mutex
+		spawned []time.Time
+	)
+
+	p.Listen(func(event int, ctx interface{}) {
+		if event == EventWorkerConstruct {
+			mu.Lock()
+			spawned = append(spawned, time.Now())
+			mu.Unlock()
+		}
+	})
+
+	// all workers crash at once
+	start := time.Now()
+	for _, w := range p.Workers() {
+		assert.NoError(t, w.Kill())
+	}
+
+	// tasks wait for the paced replacements
+	_, err = p.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+
+	deadline := time.Now().Add(time.Second * 5)
+	for len(p.Workers()) < 6 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+	}
+	assert.Len(t, p.Workers(), 6)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	// burst of 2 and 4 more replacements paced at 10 per second
+	assert.Len(t, spawned, 6)
+	assert.True(t, spawned[len(spawned)-1].Sub(start) >= time.Millisecond*350, "rate limit is not applied")
+	assert.True(t, p.Stats().RespawnRate > 0)
+	assert.True(t, p.Stats().RespawnRate <= 6)
+}
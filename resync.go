@@ -0,0 +1,117 @@
+package roadrunner
+
+import (
+	"bytes"
+	"fmt"
+	json "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+	"github.com/spiral/goridge/v2"
+	"sync/atomic"
+	"time"
+)
+
+// resyncCommand is the sentinel sent to the worker which relay stream might be out of sync (see
+// Worker.Resync). Worker must echo the {"resync":"<token>"} control frame back unchanged once it
+// reads it, host discards every frame received before the echo. Workers not supporting the echo
+// are killed once resync times out.
+type resyncCommand struct {
+	Resync string `json:"resync"`
+}
+
+// SetResyncTimeout enables resync of the worker which did not complete the task in time or did
+// not respond to the cancel command, abandoned execution is followed by the sentinel handshake
+// instead of the kill and worker stays ready if the sentinel is echoed within d. Worker is killed
+// as before otherwise, 0 to disable.
+func (w *Worker) SetResyncTimeout(d time.Duration) {
+	atomic.StoreInt64(&w.resyncTimeout, int64(d))
+}
+
+// Resync brings relay stream of the worker back to the known position after protocol failure,
+// for example once ErrUnexpectedFrame is returned. Worker receives sentinel control frame and
+// frames received until the worker echoes it back are discarded. Worker must support the sentinel
+// echo (see resyncCommand), worker is ready once it responds within timeout. Worker is marked as
+// errored and must be replaced when resync fails. Multiplexed and hijacked workers can not be
+// resynced, busy workers are refused.
+func (w *Worker) Resync(timeout time.Duration) error {
+	if w.mux != nil || w.Hijacked() {
+		return fmt.Errorf("worker relay can not be resynced")
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if s := w.state.Value(); s != StateReady && s != StateErrored {
+		return fmt.Errorf("worker is not ready (%s)", w.state.String())
+	}
+
+	if err := w.resync(nil, timeout); err != nil {
+		w.state.set(StateErrored)
+		return err
+	}
+
+	w.state.set(StateReady)
+	return nil
+}
+
+// resyncAfter resyncs the worker once pending execution timed out, returns true if worker is
+// ready again. Must be called under mu.
+func (w *Worker) resyncAfter(pending <-chan execResult) bool {
+	d := time.Duration(atomic.LoadInt64(&w.resyncTimeout))
+	if d == 0 || w.resync(pending, d) != nil {
+		return false
+	}
+
+	w.state.set(StateReady)
+	return true
+}
+
+// resync waits for the pending execution, if any, and performs the sentinel handshake within
+// timeout. Relay is left blocked on timeout, worker must be killed. Must be called under mu.
+func (w *Worker) resync(pending <-chan execResult, timeout time.Duration) error {
+	sentinel, err := json.Marshal(resyncCommand{Resync: newRequestID()})
+	if err != nil {
+		return err
+	}
+
+	// buffered to let handshake complete once worker is killed
+	done := make(chan error, 1)
+	go func() {
+		if pending != nil {
+			<-pending
+		}
+
+		if err := w.rl.Send(sentinel, goridge.PayloadControl); err != nil {
+			done <- err
+			return
+		}
+
+		for {
+			data, pr, err := w.rl.Receive()
+			if err != nil {
+				done <- err
+				return
+			}
+
+			if pr.HasFlag(goridge.PayloadControl) && bytes.Equal(data, sentinel) {
+				done <- nil
+				return
+			}
+		}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return errors.Wrap(relayFailure(err), "resync error")
+		}
+
+		w.received = 0
+		w.request.Store("")
+		return nil
+	case <-timer.C:
+		return fmt.Errorf("resync timeout")
+	}
+}
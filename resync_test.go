@@ -0,0 +1,111 @@
+package roadrunner
+
+import (
+	"bytes"
+	"github.com/spiral/goridge/v2"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// echoSentinel reads frames until resync sentinel is received and echoes it back, garbage is
+// sent ahead of the echo.
+func echoSentinel(rl goridge.Relay, garbage ...[]byte) bool {
+	for {
+		data, pr, err := rl.Receive()
+		if err != nil {
+			return false
+		}
+
+		if pr.HasFlag(goridge.PayloadControl) && bytes.HasPrefix(data, []byte(`{"resync":`)) {
+			for _, g := range garbage {
+				_ = rl.Send(g, goridge.PayloadRaw)
+			}
+
+			return rl.Send(data, goridge.PayloadControl) == nil
+		}
+	}
+}
+
+// respondTask consumes the task frames and responds with given body.
+func respondTask(rl goridge.Relay, body string) {
+	for i := 0; i < 2; i++ {
+		if _, _, err := rl.Receive(); err != nil {
+			return
+		}
+	}
+
+	_ = rl.Send([]byte("{}"), goridge.PayloadControl)
+	_ = rl.Send([]byte(body), goridge.PayloadRaw)
+}
+
+func Test_Worker_Resync(t *testing.T) {
+	w := relayWorker(t, func(rl goridge.Relay) {
+		_ = rl.Send([]byte("{}"), goridge.PayloadControl)
+		_ = rl.Send([]byte("hello"), goridge.PayloadRaw)
+
+		// leftovers of the previous response
+		_ = rl.Send([]byte("{}"), goridge.PayloadControl)
+		_ = rl.Send([]byte("stale"), goridge.PayloadRaw)
+
+		if echoSentinel(rl, []byte("garbage")) {
+			respondTask(rl, "after resync")
+		}
+	})
+	defer w.Kill()
+
+	res, err := w.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", res.String())
+
+	assert.NoError(t, w.Resync(time.Second))
+	assert.Equal(t, StateReady, w.State().Value())
+
+	res, err = w.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, "after resync", res.String())
+}
+
+func Test_Worker_Resync_Timeout(t *testing.T) {
+	w := relayWorker(t, func(rl goridge.Relay) {
+		_ = rl.Send([]byte("{}"), goridge.PayloadControl)
+		_ = rl.Send([]byte("hello"), goridge.PayloadRaw)
+
+		// sentinel is never echoed
+		_, _, _ = rl.Receive()
+		time.Sleep(time.Millisecond * 300)
+	})
+	defer w.Kill()
+
+	_, err := w.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+
+	err = w.Resync(time.Millisecond * 100)
+	assert.Error(t, err)
+	assert.Equal(t, "resync timeout", err.Error())
+	assert.Equal(t, StateErrored, w.State().Value())
+}
+
+func Test_Worker_ExecWithTimeout_Resync(t *testing.T) {
+	w := relayWorker(t, func(rl goridge.Relay) {
+		// response arrives after the timeout
+		time.Sleep(time.Millisecond * 100)
+		_ = rl.Send([]byte("{}"), goridge.PayloadControl)
+		_ = rl.Send([]byte("late"), goridge.PayloadRaw)
+
+		if echoSentinel(rl) {
+			respondTask(rl, "after resync")
+		}
+	})
+	defer w.Kill()
+
+	w.SetResyncTimeout(time.Second)
+
+	_, err := w.ExecWithTimeout(&Payload{Body: []byte("hello")}, time.Millisecond*20)
+	assert.Equal(t, ErrExecTimeout, err)
+	assert.Equal(t, StateReady, w.State().Value())
+
+	res, err := w.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, "after resync", res.String())
+}
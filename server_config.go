@@ -4,12 +4,10 @@ import (
 	"errors"
 	"fmt"
 	"github.com/spiral/roadrunner/osutil"
-	"net"
 	"os"
 	"os/exec"
 	"strings"
 	"sync"
-	"syscall"
 	"time"
 )
 
@@ -21,6 +19,13 @@ type ServerConfig struct {
 	// Command includes command strings with all the parameters, example: "php worker.php pipes".
 	Command string
 
+	// Args are passed to the Command as is when set, Command must name the executable only then.
+	// Use to pass arguments containing spaces.
+	Args []string
+
+	// Env defines variables added to the worker environment, names are upper-cased like in SetEnv.
+	Env map[string]string
+
 	// User under which process will be started
 	User string
 
@@ -36,6 +41,22 @@ type ServerConfig struct {
 	// must not change on re-configuration.
 	RelayTimeout time.Duration
 
+	// RelaySecret enables verification of worker PID signature for socket relays, worker receives the secret
+	// via RR_RELAY_SECRET env variable. This config section must not change on re-configuration.
+	RelaySecret string
+
+	// PoolToken prevents workers of other pools sharing the socket from being associated with this pool, worker
+	// receives the token via RR_POOL_TOKEN env variable. This config section must not change on re-configuration.
+	PoolToken string
+
+	// KeepAlive enables TCP keep-alive with the given period on relay connections, tcp relays
+	// only. Set 0 to disable. This config section must not change on re-configuration.
+	KeepAlive time.Duration
+
+	// ProtocolConstraint defines accepted worker protocol versions, example: ">=2.0.0 <3.0.0".
+	// Empty value disables the check.
+	ProtocolConstraint string
+
 	// Pool defines worker pool configuration, number of workers, timeouts and etc. This config section might change
 	// while server is running.
 	Pool *Config
@@ -70,11 +91,194 @@ func (cfg *ServerConfig) UpscaleDurations() {
 	if cfg.Pool.DestroyTimeout < time.Microsecond {
 		cfg.Pool.DestroyTimeout = time.Second * time.Duration(cfg.Pool.DestroyTimeout.Nanoseconds())
 	}
+
+	if cfg.Pool.MaxWait < time.Microsecond {
+		cfg.Pool.MaxWait = time.Second * time.Duration(cfg.Pool.MaxWait.Nanoseconds())
+	}
+
+	if cfg.Pool.BreakerWindow < time.Microsecond {
+		cfg.Pool.BreakerWindow = time.Second * time.Duration(cfg.Pool.BreakerWindow.Nanoseconds())
+	}
+
+	if cfg.Pool.BreakerCooldown < time.Microsecond {
+		cfg.Pool.BreakerCooldown = time.Second * time.Duration(cfg.Pool.BreakerCooldown.Nanoseconds())
+	}
+
+	if cfg.Pool.ExecTimeout < time.Microsecond {
+		cfg.Pool.ExecTimeout = time.Second * time.Duration(cfg.Pool.ExecTimeout.Nanoseconds())
+	}
+
+	if cfg.Pool.HeartbeatInterval < time.Microsecond {
+		cfg.Pool.HeartbeatInterval = time.Second * time.Duration(cfg.Pool.HeartbeatInterval.Nanoseconds())
+	}
+
+	if cfg.Pool.ProbeInterval < time.Microsecond {
+		cfg.Pool.ProbeInterval = time.Second * time.Duration(cfg.Pool.ProbeInterval.Nanoseconds())
+	}
+
+	if cfg.Pool.ProbeTimeout < time.Microsecond {
+		cfg.Pool.ProbeTimeout = time.Second * time.Duration(cfg.Pool.ProbeTimeout.Nanoseconds())
+	}
+
+	if cfg.Pool.WatchdogTimeout < time.Microsecond {
+		cfg.Pool.WatchdogTimeout = time.Second * time.Duration(cfg.Pool.WatchdogTimeout.Nanoseconds())
+	}
+
+	if cfg.Pool.IdleReadTimeout < time.Microsecond {
+		cfg.Pool.IdleReadTimeout = time.Second * time.Duration(cfg.Pool.IdleReadTimeout.Nanoseconds())
+	}
+
+	if cfg.Pool.ResyncTimeout < time.Microsecond {
+		cfg.Pool.ResyncTimeout = time.Second * time.Duration(cfg.Pool.ResyncTimeout.Nanoseconds())
+	}
+
+	if cfg.Pool.MaxAge < time.Microsecond {
+		cfg.Pool.MaxAge = time.Second * time.Duration(cfg.Pool.MaxAge.Nanoseconds())
+	}
+
+	if cfg.Pool.MemoryCheckInterval < time.Microsecond {
+		cfg.Pool.MemoryCheckInterval = time.Second * time.Duration(cfg.Pool.MemoryCheckInterval.Nanoseconds())
+	}
+
+	if cfg.Pool.RollingReplaceInterval < time.Microsecond {
+		cfg.Pool.RollingReplaceInterval = time.Second * time.Duration(cfg.Pool.RollingReplaceInterval.Nanoseconds())
+	}
+
+	if cfg.Pool.SpawnJitter < time.Microsecond {
+		cfg.Pool.SpawnJitter = time.Second * time.Duration(cfg.Pool.SpawnJitter.Nanoseconds())
+	}
 }
 
 // Differs returns true if configuration has changed but ignores pool or cmd changes.
 func (cfg *ServerConfig) Differs(new *ServerConfig) bool {
-	return cfg.Relay != new.Relay || cfg.RelayTimeout != new.RelayTimeout
+	return cfg.Relay != new.Relay || cfg.RelayTimeout != new.RelayTimeout || cfg.RelaySecret != new.RelaySecret ||
+		cfg.PoolToken != new.PoolToken || cfg.ProtocolConstraint != new.ProtocolConstraint || cfg.KeepAlive != new.KeepAlive
+}
+
+// Valid validates the configuration, relay DSN, combinations of the relay options and pool
+// configuration are verified.
+func (cfg *ServerConfig) Valid() error {
+	if err := cfg.validWorkers(); err != nil {
+		return err
+	}
+
+	if cfg.Pool == nil {
+		return errors.New("server.Pool must be set")
+	}
+
+	return cfg.Pool.Valid()
+}
+
+// validWorkers validates command and relay configuration, pool configuration is not verified.
+func (cfg *ServerConfig) validWorkers() error {
+	if strings.TrimSpace(cfg.Command) == "" && cfg.CommandProducer == nil {
+		return errors.New("server.Command must be set")
+	}
+
+	if len(cfg.Args) != 0 {
+		if cfg.CommandProducer != nil {
+			return errors.New("server.Args can not be combined with CommandProducer")
+		}
+
+		if strings.Contains(strings.TrimSpace(cfg.Command), " ") {
+			return errors.New("server.Command must name the executable only when server.Args are set")
+		}
+	}
+
+	transport, err := cfg.transport()
+	if err != nil {
+		return err
+	}
+
+	if cfg.RelayTimeout < 0 {
+		return errors.New("server.RelayTimeout must be positive")
+	}
+
+	if cfg.KeepAlive < 0 {
+		return errors.New("server.KeepAlive must be positive (0 to disable)")
+	}
+
+	if cfg.KeepAlive != 0 && transport != "tcp" {
+		return fmt.Errorf("server.KeepAlive is not supported by %s relay", transport)
+	}
+
+	if transport == "pipes" && (cfg.RelaySecret != "" || cfg.PoolToken != "") {
+		return errors.New("server.RelaySecret and server.PoolToken require socket relay")
+	}
+
+	return nil
+}
+
+// NewPoolFromConfig validates the configuration and creates static pool of the workers spawned
+// using the configured command over the configured relay, for example:
+//
+//	p, err := NewPoolFromConfig(&ServerConfig{
+//		Command: "php worker.php",
+//		Relay:   "unix://rr.sock",
+//		Pool:    &Config{NumWorkers: 4, AllocateTimeout: time.Minute, DestroyTimeout: time.Minute},
+//	})
+//
+// Zero RelayTimeout waits for socket workers for a minute. Factory is closed along with the
+// pool, use NewPool and factory constructors for setups not covered by the configuration.
+// ServerConfig is the configuration of the pool on purpose: it describes the worker command,
+// environment and relay of the server pools already and shares their validation and reload rules
+// (see Differs), separate struct would duplicate every field of it.
+func NewPoolFromConfig(cfg *ServerConfig) (Pool, error) {
+	if err := cfg.Valid(); err != nil {
+		return nil, err
+	}
+
+	if cfg.RelayTimeout == 0 {
+		cfg.RelayTimeout = time.Minute
+	}
+
+	f, err := cfg.makeFactory()
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := NewPool(cfg.makeCommand(), f, *cfg.Pool)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	p.ownsFactory = true
+	return p, nil
+}
+
+// NewDynamicPoolFromConfig creates dynamic pool of the workers spawned using the command and over
+// the relay of the configuration, see NewPoolFromConfig. Pool section of the configuration is
+// ignored, pool behaviour is defined by the given DynamicConfig. Factory is closed along with
+// the pool.
+func NewDynamicPoolFromConfig(cfg *ServerConfig, pool DynamicConfig) (*DynamicPool, error) {
+	if err := cfg.validWorkers(); err != nil {
+		return nil, err
+	}
+
+	if cfg.RelayTimeout == 0 {
+		cfg.RelayTimeout = time.Minute
+	}
+
+	f, err := cfg.makeFactory()
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := cfg.makeCommand()
+	p, err := NewDynamicPool(func(wc WorkerConfig) *exec.Cmd {
+		c := cmd()
+		wc.Apply(c)
+
+		return c
+	}, f, pool)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	p.ownsFactory = true
+	return p, nil
 }
 
 // SetEnv sets new environment variable. Value is automatically uppercase-d.
@@ -92,6 +296,15 @@ func (cfg *ServerConfig) SetEnv(k, v string) {
 // GetEnv must return list of env variables.
 func (cfg *ServerConfig) GetEnv() (env []string) {
 	env = append(os.Environ(), fmt.Sprintf("RR_RELAY=%s", cfg.Relay))
+	if cfg.RelaySecret != "" {
+		env = append(env, fmt.Sprintf("RR_RELAY_SECRET=%s", cfg.RelaySecret))
+	}
+	if cfg.PoolToken != "" {
+		env = append(env, fmt.Sprintf("RR_POOL_TOKEN=%s", cfg.PoolToken))
+	}
+	for k, v := range cfg.Env {
+		env = append(env, fmt.Sprintf("%s=%s", strings.ToUpper(k), v))
+	}
 	for k, v := range cfg.env {
 		env = append(env, fmt.Sprintf("%s=%s", strings.ToUpper(k), v))
 	}
@@ -110,7 +323,11 @@ func (cfg *ServerConfig) makeCommand() func() *exec.Cmd {
 	}
 
 	var cmdArgs []string
-	cmdArgs = append(cmdArgs, strings.Split(cfg.Command, " ")...)
+	if len(cfg.Args) != 0 {
+		cmdArgs = append(append(cmdArgs, strings.TrimSpace(cfg.Command)), cfg.Args...)
+	} else {
+		cmdArgs = append(cmdArgs, strings.Split(cfg.Command, " ")...)
+	}
 
 	return func() *exec.Cmd {
 		cmd := exec.Command(cmdArgs[0], cmdArgs[1:]...)
@@ -131,38 +348,44 @@ func (cfg *ServerConfig) makeCommand() func() *exec.Cmd {
 	}
 }
 
-// makeFactory creates and connects new factory instance based on given parameters.
-func (cfg *ServerConfig) makeFactory() (Factory, error) {
+// transport returns relay transport (pipes, tcp, unix) defined by the relay DSN.
+func (cfg *ServerConfig) transport() (string, error) {
 	if cfg.Relay == "pipes" || cfg.Relay == "pipe" {
-		return NewPipeFactory(), nil
+		return "pipes", nil
 	}
 
 	dsn := strings.Split(cfg.Relay, "://")
 	if len(dsn) != 2 {
-		return nil, errors.New("invalid relay DSN (pipes, tcp://:6001, unix://rr.sock)")
+		return "", errors.New("invalid relay DSN (pipes, tcp://:6001, unix://rr.sock)")
 	}
 
-	if dsn[0] == "unix" && fileExists(dsn[1]) {
-		err := syscall.Unlink(dsn[1])
-		if err != nil {
-			return nil, err
-		}
+	if dsn[0] != "tcp" && dsn[0] != "unix" {
+		return "", fmt.Errorf("invalid relay transport `%s` (tcp, unix)", dsn[0])
+	}
+
+	return dsn[0], nil
+}
+
+// makeFactory creates and connects new factory instance based on given parameters.
+func (cfg *ServerConfig) makeFactory() (Factory, error) {
+	if cfg.Relay == "pipes" || cfg.Relay == "pipe" {
+		return &PipeFactory{ProtocolConstraint: cfg.ProtocolConstraint}, nil
 	}
 
-	ln, err := net.Listen(dsn[0], dsn[1])
+	if len(strings.Split(cfg.Relay, "://")) != 2 {
+		return nil, errors.New("invalid relay DSN (pipes, tcp://:6001, unix://rr.sock)")
+	}
+
+	ls, sockFile, err := listenAddr(cfg.Relay)
 	if err != nil {
 		return nil, err
 	}
 
-	return NewSocketFactory(ln, cfg.RelayTimeout), nil
-}
+	f := NewSocketFactoryWithSecret(ls, cfg.RelayTimeout, []byte(cfg.RelaySecret))
+	f.ProtocolConstraint = cfg.ProtocolConstraint
+	f.SetPoolToken(cfg.PoolToken)
+	f.SetKeepAlive(cfg.KeepAlive)
+	f.sockFile = sockFile
 
-// fileExists checks if a file exists and is not a directory before we
-// try using it to prevent further errors.
-func fileExists(filename string) bool {
-	info, err := os.Stat(filename)
-	if os.IsNotExist(err) {
-		return false
-	}
-	return !info.IsDir()
+	return f, nil
 }
@@ -2,6 +2,8 @@ package roadrunner
 
 import (
 	"github.com/stretchr/testify/assert"
+	"net"
+	"os/exec"
 	"testing"
 	"time"
 )
@@ -43,8 +45,8 @@ func Test_ServerConfig_SocketFactory(t *testing.T) {
 
 	assert.NoError(t, err)
 	assert.IsType(t, &SocketFactory{}, f1)
-	assert.Equal(t, "tcp", f1.(*SocketFactory).ls.Addr().Network())
-	assert.Equal(t, "[::]:9111", f1.(*SocketFactory).ls.Addr().String())
+	assert.Equal(t, "tcp", f1.(*SocketFactory).sources[0].(*listenerSource).ls.Addr().Network())
+	assert.Equal(t, "[::]:9111", f1.(*SocketFactory).sources[0].(*listenerSource).ls.Addr().String())
 
 	cfg = &ServerConfig{Relay: "tcp://localhost:9112"}
 	f, err := cfg.makeFactory()
@@ -59,8 +61,8 @@ func Test_ServerConfig_SocketFactory(t *testing.T) {
 
 	assert.NoError(t, err)
 	assert.IsType(t, &SocketFactory{}, f)
-	assert.Equal(t, "tcp", f.(*SocketFactory).ls.Addr().Network())
-	assert.Equal(t, "127.0.0.1:9112", f.(*SocketFactory).ls.Addr().String())
+	assert.Equal(t, "tcp", f.(*SocketFactory).sources[0].(*listenerSource).ls.Addr().Network())
+	assert.Equal(t, "127.0.0.1:9112", f.(*SocketFactory).sources[0].(*listenerSource).ls.Addr().String())
 }
 
 func Test_ServerConfig_UnixSocketFactory(t *testing.T) {
@@ -79,8 +81,8 @@ func Test_ServerConfig_UnixSocketFactory(t *testing.T) {
 
 	assert.NoError(t, err)
 	assert.IsType(t, &SocketFactory{}, f)
-	assert.Equal(t, "unix", f.(*SocketFactory).ls.Addr().Network())
-	assert.Equal(t, "unix.sock", f.(*SocketFactory).ls.Addr().String())
+	assert.Equal(t, "unix", f.(*SocketFactory).sources[0].(*listenerSource).ls.Addr().Network())
+	assert.Equal(t, "unix.sock", f.(*SocketFactory).sources[0].(*listenerSource).ls.Addr().String())
 }
 
 func Test_ServerConfig_ErrorFactory(t *testing.T) {
@@ -142,6 +144,38 @@ func Test_ServerConfig_SetEnv_Relay(t *testing.T) {
 	assert.Contains(t, c.Env, "RR_RELAY=unix://rr.sock")
 }
 
+func Test_ServerConfig_SetEnv_RelaySecret(t *testing.T) {
+	cfg := &ServerConfig{
+		Command:     "php tests/client.php pipes",
+		Relay:       "unix://rr.sock",
+		RelaySecret: "secret",
+	}
+
+	cmd := cfg.makeCommand()
+	assert.NotNil(t, cmd)
+
+	c := cmd()
+
+	assert.Contains(t, c.Env, "RR_RELAY_SECRET=secret")
+	assert.True(t, cfg.Differs(&ServerConfig{Relay: "unix://rr.sock"}))
+}
+
+func Test_ServerConfig_SetEnv_PoolToken(t *testing.T) {
+	cfg := &ServerConfig{
+		Command:   "php tests/client.php pipes",
+		Relay:     "unix://rr.sock",
+		PoolToken: "pool",
+	}
+
+	cmd := cfg.makeCommand()
+	assert.NotNil(t, cmd)
+
+	c := cmd()
+
+	assert.Contains(t, c.Env, "RR_POOL_TOKEN=pool")
+	assert.True(t, cfg.Differs(&ServerConfig{Relay: "unix://rr.sock"}))
+}
+
 func Test_ServerConfigDefaults(t *testing.T) {
 	cfg := &ServerConfig{
 		Command: "php tests/client.php pipes",
@@ -172,3 +206,111 @@ func Test_Config_Upscale(t *testing.T) {
 	assert.Equal(t, time.Second, cfg.Pool.AllocateTimeout)
 	assert.Equal(t, time.Second, cfg.Pool.DestroyTimeout)
 }
+
+func Test_ServerConfig_Valid(t *testing.T) {
+	pool := &Config{NumWorkers: 1, AllocateTimeout: time.Second, DestroyTimeout: time.Second}
+
+	valid := []*ServerConfig{
+		{Command: "php tests/client.php echo pipes", Relay: "pipes", Pool: pool},
+		{Command: "php", Args: []string{"tests/client.php", "echo", "tcp"}, Relay: "tcp://:9007", KeepAlive: time.Second, Pool: pool},
+		{Command: "php tests/client.php echo unix", Relay: "unix://sock.unix", RelaySecret: "secret", PoolToken: "token", Pool: pool},
+	}
+
+	for _, cfg := range valid {
+		assert.NoError(t, cfg.Valid())
+	}
+
+	invalid := map[string]*ServerConfig{
+		"server.Command must be set":                                            {Relay: "pipes", Pool: pool},
+		"server.Args can not be combined with CommandProducer":                  {Args: []string{"echo"}, CommandProducer: func(cfg *ServerConfig) func() *exec.Cmd { return nil }, Relay: "pipes", Pool: pool},
+		"server.Command must name the executable only when server.Args are set": {Command: "php tests/client.php", Args: []string{"echo"}, Relay: "pipes", Pool: pool},
+		"invalid relay DSN (pipes, tcp://:6001, unix://rr.sock)":                {Command: "php", Relay: "uni:unix.sock", Pool: pool},
+		"invalid relay transport `udp` (tcp, unix)":                             {Command: "php", Relay: "udp://:9007", Pool: pool},
+		"server.KeepAlive is not supported by unix relay":                       {Command: "php", Relay: "unix://sock.unix", KeepAlive: time.Second, Pool: pool},
+		"server.KeepAlive is not supported by pipes relay":                      {Command: "php", Relay: "pipes", KeepAlive: time.Second, Pool: pool},
+		"server.KeepAlive must be positive (0 to disable)":                      {Command: "php", Relay: "tcp://:9007", KeepAlive: -time.Second, Pool: pool},
+		"server.RelaySecret and server.PoolToken require socket relay":          {Command: "php", Relay: "pipes", PoolToken: "token", Pool: pool},
+		"server.Pool must be set":                                               {Command: "php", Relay: "pipes"},
+		"pool.NumWorkers must be set":                                           {Command: "php", Relay: "pipes", Pool: &Config{}},
+	}
+
+	for msg, cfg := range invalid {
+		err := cfg.Valid()
+		if assert.Error(t, err) {
+			assert.Equal(t, msg, err.Error())
+		}
+	}
+}
+
+func Test_ServerConfig_Args(t *testing.T) {
+	cfg := &ServerConfig{
+		Command: "php",
+		Args:    []string{"tests/client.php", "echo pipes"},
+		Env:     map[string]string{"app_env": "test"},
+		Relay:   "pipes",
+	}
+
+	c := cfg.makeCommand()()
+	assert.Equal(t, []string{"php", "tests/client.php", "echo pipes"}, c.Args)
+	assert.Contains(t, c.Env, "APP_ENV=test")
+}
+
+func Test_NewPoolFromConfig(t *testing.T) {
+	p, err := NewPoolFromConfig(&ServerConfig{
+		Command: "php",
+		Args:    []string{"tests/client.php", "echo", "pipes"},
+		Relay:   "pipes",
+		Pool:    &Config{NumWorkers: 2, AllocateTimeout: time.Second, DestroyTimeout: time.Second},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, p.Workers(), 2)
+
+	res, err := p.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", res.String())
+
+	p.Destroy()
+}
+
+func Test_NewPoolFromConfig_Invalid(t *testing.T) {
+	p, err := NewPoolFromConfig(&ServerConfig{Command: "php", Relay: "unix://sock.unix", KeepAlive: time.Second, Pool: &Config{NumWorkers: 1}})
+	assert.Nil(t, p)
+	assert.Error(t, err)
+}
+
+func Test_NewDynamicPoolFromConfig(t *testing.T) {
+	p, err := NewDynamicPoolFromConfig(&ServerConfig{
+		Command: "php",
+		Args:    []string{"tests/client.php", "echo", "tcp"},
+		Relay:   "tcp://:9007",
+	}, DynamicConfig{
+		MinWorkers:       1,
+		MaxWorkers:       2,
+		ScaleUpThreshold: 1,
+		AllocateTimeout:  time.Second,
+		DestroyTimeout:   time.Second,
+	})
+	assert.NoError(t, err)
+	assert.Len(t, p.Workers(), 1)
+
+	res, err := p.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", res.String())
+
+	// factory is closed along with the pool
+	p.Destroy()
+
+	ls, err := net.Listen("tcp", ":9007")
+	assert.NoError(t, err)
+	ls.Close()
+}
+
+func Test_NewDynamicPoolFromConfig_Invalid(t *testing.T) {
+	p, err := NewDynamicPoolFromConfig(&ServerConfig{Command: "php", Relay: "unix://sock.unix", KeepAlive: time.Second}, DynamicConfig{})
+	assert.Nil(t, p)
+	assert.Error(t, err)
+
+	p, err = NewDynamicPoolFromConfig(&ServerConfig{Command: "php", Relay: "pipes"}, DynamicConfig{})
+	assert.Nil(t, p)
+	assert.Error(t, err)
+}
@@ -239,7 +239,7 @@ func TestServer_ServerFailure(t *testing.T) {
 
 	// emulating potential server failure
 	rr.cfg.Command = "php tests/client.php echo broken-connection"
-	rr.pool.(*StaticPool).cmd = func() *exec.Cmd {
+	rr.pool.(*StaticPool).cmd = func(cfg WorkerConfig) *exec.Cmd {
 		return exec.Command("php", "tests/client.php", "echo", "broken-connection")
 	}
 	// killing random worker and expecting pool to replace it
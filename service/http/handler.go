@@ -132,7 +132,12 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) handleError(w http.ResponseWriter, r *http.Request, err error, start time.Time) {
 	h.throw(EventError, &ErrorEvent{Request: r, Error: err, start: start, elapsed: time.Since(start)})
 
-	w.WriteHeader(500)
+	if errors.Cause(err) == roadrunner.ErrQueueFull {
+		w.WriteHeader(503)
+	} else {
+		w.WriteHeader(500)
+	}
+
 	_, err = w.Write([]byte(err.Error()))
 	if err != nil {
 		h.throw(EventError, &ErrorEvent{Request: r, Error: err, start: start, elapsed: time.Since(start)})
@@ -0,0 +1,121 @@
+package roadrunner
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"os/exec"
+	"sync"
+)
+
+// SingleWorker executes tasks using one persistent worker without the pool, useful for benchmarks
+// and simple scripts. Executions are serialized, worker is respawned on the next call once it
+// dies or fails.
+type SingleWorker struct {
+	// creates and connects to the worker
+	factory Factory
+
+	// worker command creator
+	cmd func() *exec.Cmd
+
+	// serializes executions and protects the worker
+	mu sync.Mutex
+
+	// current worker, nil when worker has to be spawned
+	w *Worker
+
+	// indicates that Destroy has been called, protected by mu
+	destroyed bool
+}
+
+// NewSingleWorker spawns the worker using given factory and returns SingleWorker attached to it.
+func NewSingleWorker(factory Factory, cmd func() *exec.Cmd) (*SingleWorker, error) {
+	s := &SingleWorker{factory: factory, cmd: cmd}
+	if err := s.spawn(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Worker returns current worker, nil when worker is going to be respawned. Never Exec on the
+// returned worker directly.
+func (s *SingleWorker) Worker() *Worker {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.w
+}
+
+// Exec one task with given payload and context, returns result or error. Dead worker is
+// respawned before the execution.
+func (s *SingleWorker) Exec(rqs *Payload) (rsp *Payload, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for {
+		if s.destroyed {
+			return nil, fmt.Errorf("worker has been destroyed")
+		}
+
+		if s.w == nil || s.w.State().Value() != StateReady {
+			s.discard()
+			if err := s.spawn(); err != nil {
+				return nil, err
+			}
+		}
+
+		rsp, err = s.w.Exec(rqs)
+		if err != nil {
+			if _, jobError := err.(JobError); !jobError {
+				s.discard()
+			}
+
+			return nil, err
+		}
+
+		// worker want's to be terminated
+		if rsp.Body == nil && rsp.Context != nil && string(rsp.Context) == StopRequest {
+			_ = s.w.Stop()
+			s.w = nil
+			continue
+		}
+
+		return rsp, nil
+	}
+}
+
+// Destroy stops the worker, following executions fail.
+func (s *SingleWorker) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.destroyed = true
+	if s.w != nil {
+		_ = s.w.Stop()
+		s.w = nil
+	}
+}
+
+// spawn creates new worker, must be called under the lock.
+func (s *SingleWorker) spawn() error {
+	w, err := s.factory.SpawnWorker(s.cmd())
+	if err != nil {
+		return errors.Wrap(err, "unable to spawn worker")
+	}
+
+	go func() {
+		// process state is not used, next call respawns dead worker
+		_ = w.Wait()
+	}()
+
+	s.w = w
+	return nil
+}
+
+// discard kills current worker, if any. Must be called under the lock.
+func (s *SingleWorker) discard() {
+	if s.w != nil {
+		_ = s.w.Kill()
+		s.w = nil
+	}
+}
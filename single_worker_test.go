@@ -0,0 +1,67 @@
+package roadrunner
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os/exec"
+	"strconv"
+	"testing"
+)
+
+func Test_SingleWorker_Echo(t *testing.T) {
+	s, err := NewSingleWorker(NewPipeFactory(), func() *exec.Cmd {
+		return exec.Command("php", "tests/client.php", "echo", "pipes")
+	})
+	assert.NoError(t, err)
+	defer s.Destroy()
+
+	for i := 0; i < 10; i++ {
+		res, err := s.Exec(&Payload{Body: []byte("hello")})
+		assert.NoError(t, err)
+		assert.Equal(t, "hello", res.String())
+	}
+}
+
+func Test_SingleWorker_Respawn(t *testing.T) {
+	s, err := NewSingleWorker(NewPipeFactory(), func() *exec.Cmd {
+		return exec.Command("php", "tests/client.php", "pid", "pipes")
+	})
+	assert.NoError(t, err)
+	defer s.Destroy()
+
+	w := s.Worker()
+	assert.NoError(t, w.Kill())
+
+	res, err := s.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.NotEqual(t, w.PID(), s.Worker().PID())
+	assert.Equal(t, strconv.Itoa(s.Worker().PID()), res.String())
+}
+
+func Test_SingleWorker_JobError(t *testing.T) {
+	s, err := NewSingleWorker(NewPipeFactory(), func() *exec.Cmd {
+		return exec.Command("php", "tests/client.php", "error", "pipes")
+	})
+	assert.NoError(t, err)
+	defer s.Destroy()
+
+	w := s.Worker()
+
+	_, err = s.Exec(&Payload{Body: []byte("hello")})
+	assert.Error(t, err)
+	assert.IsType(t, JobError{}, err)
+
+	// worker is kept
+	assert.Equal(t, w, s.Worker())
+}
+
+func Test_SingleWorker_Destroy(t *testing.T) {
+	s, err := NewSingleWorker(NewPipeFactory(), func() *exec.Cmd {
+		return exec.Command("php", "tests/client.php", "echo", "pipes")
+	})
+	assert.NoError(t, err)
+
+	s.Destroy()
+
+	_, err = s.Exec(&Payload{Body: []byte("hello")})
+	assert.Error(t, err)
+}
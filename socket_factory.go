@@ -1,139 +1,1885 @@
 package roadrunner
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"github.com/pkg/errors"
 	"github.com/spiral/goridge/v2"
+	"io"
 	"net"
+	"os"
 	"os/exec"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
+const (
+	// RelayProbeTimeout defines for how long factory waits for worker to respond to ping probe.
+	RelayProbeTimeout = time.Second
+
+	// DefaultHandshakeTimeout defines for how long factory waits for the accepted connection to
+	// complete the PID handshake, see SetHandshakeTimeout.
+	DefaultHandshakeTimeout = 5 * time.Second
+
+	// RecentHandshakeFailures defines how many last handshake failures are kept for Pending.
+	RecentHandshakeFailures = 16
+
+	// bounds of the retry delay after temporary accept errors
+	minAcceptDelay = 5 * time.Millisecond
+	maxAcceptDelay = time.Second
+)
+
+const (
+	// EventRelayAssociate thrown when worker relay has been associated with the worker.
+	EventRelayAssociate = iota + 300
+
+	// EventRelayTimeout thrown when worker did not connect to the factory in a given time.
+	EventRelayTimeout
+
+	// EventRelayWorkerDead thrown when worker died while waiting for relay association.
+	EventRelayWorkerDead
+
+	// EventPanic thrown when panic inside the factory goroutine has been recovered, error is
+	// PanicError. Worker is set when panic happened during the relay association.
+	EventPanic
+)
+
+// FactoryEvent describes worker lifecycle event occurred inside the factory.
+type FactoryEvent struct {
+	// Event type, see Event* constants.
+	Event int
+
+	// Worker associated with the event.
+	Worker *Worker
+
+	// Error is set for failure events.
+	Error error
+}
+
+// HandshakeFunc identifies worker connected over the relay, returns worker PID and metadata sent
+// by the worker during the handshake. Returned metadata is stored on the worker.
+type HandshakeFunc func(rl *goridge.SocketRelay) (pid int, meta map[string]string, err error)
+
+// RelaySource provides relays of connected workers along with the worker PID.
+type RelaySource interface {
+	// Accept waits for the next worker relay. Returned error stops the factory from
+	// accepting new relays.
+	Accept() (rl *goridge.SocketRelay, pid int, err error)
+}
+
+var _ Factory = (*SocketFactory)(nil)
+
 // SocketFactory connects to external workers using socket server.
 type SocketFactory struct {
-	// listens for incoming connections from underlying processes
-	ls net.Listener
+	// provide relays of underlying processes indexed by listener ID, closed with factory when
+	// implement io.Closer
+	sources []RelaySource
+
+	// transport names (tcp, unix) of the listeners, assigned to every spawned worker
+	transports []string
+
+	// socket file to be removed on Close, empty for non unix transports
+	sockFile string
+
+	// permissions and backlog applied to the socket file once listener is re-bound, see
+	// WatchSocketFile
+	sockPerm    SocketPermissions
+	sockBacklog int
 
 	// relay connection timeout
 	tout time.Duration
 
+	// StartTimeout limits time between process start and relay association, unlike relay
+	// timeout it includes process startup. Zero value disables the limit.
+	StartTimeout time.Duration
+
+	// MaxRelayAttempts defines how many relays can be received for the same worker before association
+	// fails. When set to more than 1 each relay is probed using ping command and discarded on failure.
+	MaxRelayAttempts int
+
+	// BootstrapPayload returns one-time configuration (feature flags, sharding) sent to every
+	// associated worker after the protocol check and before the warm-up, id is the logical worker
+	// ID passed to the command in RR_WORKER_ID env variable (see WorkerConfig), empty when not
+	// set. Worker must acknowledge the configuration within relay timeout (see bootstrapCommand),
+	// otherwise it is killed and spawn fails. Nil function or payload disables bootstrap.
+	BootstrapPayload func(id string) []byte
+
+	// WarmupPayload is sent to every associated worker after the bootstrap and before it's marked
+	// as ready, worker is killed if it fails to respond within relay timeout. Nil value disables
+	// warm-up.
+	WarmupPayload []byte
+
+	// ProtocolConstraint defines worker protocol versions accepted by the factory, example:
+	// ">=2.0.0 <3.0.0". Empty value disables the version check.
+	ProtocolConstraint string
+
+	// Logger receives relay association messages, nil to disable logging.
+	Logger Logger
+
+	// Clock drives relay timeouts, accept retries and pending relay expiration, nil for the
+	// system clock. Set before the factory is used.
+	Clock Clock
+
+	// Tracer receives relay wait spans (see SpanRelayWait) as children of the span carried by the
+	// spawn context, nil to disable tracing.
+	Tracer Tracer
+
+	// ResourceLimits applied to every spawned worker process.
+	ResourceLimits ResourceLimits
+
+	// Codec defines how payload bodies are encoded, must match the worker. Raw when nil.
+	Codec Codec
+
+	// MaxPendingRelays limits number of accepted relays waiting for the worker association, new
+	// connections are closed once limit is reached. Zero value disables the limit.
+	MaxPendingRelays int
+
+	// PausedRelayHold defines for how long relay accepted while accepting is paused waits for
+	// ResumeAccept before it's closed, zero value closes such relays right away. See PauseAccept.
+	PausedRelayHold time.Duration
+
+	// RejectUnknownPIDs closes relays claiming PID of the process which is not being started by
+	// the factory, for example other process connecting to the listener with the guessed PID.
+	// PID is expected from the process start until relay association, relays claiming PID of the
+	// associated worker are rejected as well. Rejections are reported as handshake failures.
+	// AdoptWorker is not affected. Set before the factory is used.
+	RejectUnknownPIDs bool
+
 	// protects socket mapping
 	mu sync.Mutex
 
 	// sockets which are waiting for process association
-	relays map[int]chan *goridge.SocketRelay
+	relays map[relayKey]chan *goridge.SocketRelay
+
+	// last handshake failure of the workers waiting for association, protected by mu
+	failures map[relayKey]error
+
+	// processes started by the factory and not associated yet, protected by mu
+	expected map[relayKey]bool
+
+	// total number of failed handshakes, accessed atomically
+	numFailures int64
+
+	// last RecentHandshakeFailures handshake failures, oldest first, protected by mu
+	recentFailures []HandshakeFailure
+
+	// indicates that factory has been closed, protected by mu
+	closed bool
+
+	// closed by ResumeAccept, nil while relays are handed over to the workers, protected by mu
+	resumed chan interface{}
+
+	// closed once factory is closing to release pending relay delivery
+	done chan interface{}
+
+	// relay deliveries in progress
+	deliveries sync.WaitGroup
+
+	// accepted relays waiting for the worker association, protected by mu
+	pending map[*goridge.SocketRelay]*pendingRelay
+
+	// max duration relay can wait for the association before being closed, accessed atomically
+	pendingTTL int64
+
+	// indicates that pending relay sweeper is running, protected by mu
+	sweeping bool
+
+	// indicates that socket file watcher is running, protected by mu
+	watching bool
+
+	// lifecycle observers, protected by mu
+	listeners []func(event FactoryEvent)
+
+	// number of goroutines accepting relays of every listener, protected by mu
+	acceptors int
+
+	// flags of the listeners which stopped accepting relays after permanent accept error (closed
+	// listener), indexed by listener ID and accessed atomically
+	stopped []int32
+}
+
+// PendingSnapshot describes relay associations in progress, see SocketFactory.Pending.
+type PendingSnapshot struct {
+	// Awaited contains workers waiting for the relay which has not been accepted yet.
+	Awaited []PendingWorker
+
+	// Unclaimed contains accepted relays which are not associated with the worker yet, by PID
+	// sent by the worker during the handshake.
+	Unclaimed []PendingWorker
+
+	// Failures contains last RecentHandshakeFailures handshake failures, oldest first.
+	Failures []HandshakeFailure
+
+	// NumFailures contains total number of failed handshakes.
+	NumFailures int64
+}
+
+// PendingWorker identifies worker (or worker relay) waiting for the association.
+type PendingWorker struct {
+	// ListenerID of the listener worker connects to.
+	ListenerID int
+
+	// PID of the worker.
+	PID int
+
+	// Since contains time when relay has been accepted, zero for awaited workers.
+	Since time.Time
+}
+
+// HandshakeFailure describes failed handshake of the accepted connection.
+type HandshakeFailure struct {
+	// ListenerID of the listener connection has been accepted by.
+	ListenerID int
+
+	// PID claimed by the connection, 0 when handshake failed before PID has been received.
+	PID int
+
+	// Addr of the remote side.
+	Addr string
+
+	// Error describes the failure.
+	Error string
+
+	// Time of the failure.
+	Time time.Time
+}
+
+// relayKey identifies relay of the worker connected to the specific listener.
+type relayKey struct {
+	listener int
+	pid      int
+}
+
+// pendingRelay describes relay waiting for the worker association.
+type pendingRelay struct {
+	key   relayKey
+	since time.Time
+
+	// closed by the sweeper once relay stays unclaimed for too long or once worker stopped
+	// waiting for the relay
+	expired chan interface{}
+}
+
+// expire releases the relay delivery, relay is closed. Must be called under factory mu.
+func (pr *pendingRelay) expire() {
+	select {
+	case <-pr.expired:
+	default:
+		close(pr.expired)
+	}
 }
 
 // NewSocketFactory returns SocketFactory attached to a given socket lsn.
 // tout specifies for how long factory should serve for incoming relay connection
 func NewSocketFactory(ls net.Listener, tout time.Duration) *SocketFactory {
+	return NewSocketFactoryWithSecret(ls, tout, nil)
+}
+
+// NewSocketFactoryWithSecret returns SocketFactory which only accepts relays of workers signing their
+// PID with the given secret (HMAC-SHA256, hex encoded). Empty secret disables the verification.
+func NewSocketFactoryWithSecret(ls net.Listener, tout time.Duration, secret []byte) *SocketFactory {
+	f := NewSocketFactoryWithSource(&listenerSource{ls: ls, tout: tout, secret: secret}, tout)
+	f.transports[0] = ls.Addr().Network()
+
+	return f
+}
+
+// NewSocketFactoryWithTLS returns SocketFactory which accepts TLS connections only, worker PID
+// handshake is performed once TLS handshake completes (limited by tout). Set ClientAuth of the
+// given config to require worker certificates.
+func NewSocketFactoryWithTLS(ls net.Listener, tout time.Duration, cfg *tls.Config) *SocketFactory {
+	f := NewSocketFactoryWithSource(&listenerSource{ls: ls, tout: tout, tls: cfg}, tout)
+	f.transports[0] = ls.Addr().Network()
+
+	return f
+}
+
+// NewSocketFactoryWithSource returns SocketFactory associating workers with relays provided by
+// the given source. Source is closed on factory Close if it implements io.Closer.
+func NewSocketFactoryWithSource(src RelaySource, tout time.Duration) *SocketFactory {
+	return newSocketFactory([]RelaySource{src}, make([]string, 1), tout)
+}
+
+// NewSocketFactoryWithListeners returns SocketFactory serving multiple listeners at once, listener ID
+// is the position of the listener in the given list. Use SpawnWorkerOn to spawn worker connecting
+// to the specific listener, SpawnWorker uses the first one.
+func NewSocketFactoryWithListeners(ls []net.Listener, tout time.Duration) *SocketFactory {
+	sources := make([]RelaySource, 0, len(ls))
+	transports := make([]string, 0, len(ls))
+	for _, l := range ls {
+		sources = append(sources, &listenerSource{ls: l, tout: tout})
+		transports = append(transports, l.Addr().Network())
+	}
+
+	return newSocketFactory(sources, transports, tout)
+}
+
+func newSocketFactory(sources []RelaySource, transports []string, tout time.Duration) *SocketFactory {
 	f := &SocketFactory{
-		ls:     ls,
-		tout:   tout,
-		relays: make(map[int]chan *goridge.SocketRelay),
+		sources:    sources,
+		transports: transports,
+		tout:       tout,
+		relays:     make(map[relayKey]chan *goridge.SocketRelay),
+		failures:   make(map[relayKey]error),
+		pending:    make(map[*goridge.SocketRelay]*pendingRelay),
+		done:       make(chan interface{}),
+		acceptors:  1,
+		stopped:    make([]int32, len(sources)),
 	}
 
-	go f.listen()
+	for id, src := range sources {
+		if s, ok := src.(*listenerSource); ok {
+			listenerID := id
+			s.failed = func(pid int, addr net.Addr, err error) {
+				f.handshakeFailed(relayKey{listener: listenerID, pid: pid}, addr, err)
+			}
+			s.handshakeTimeout = int64(DefaultHandshakeTimeout)
+		}
+
+		go f.listen(id)
+	}
 
 	return f
 }
 
-// SpawnWorker creates worker and connects it to appropriate relay or returns error
-func (f *SocketFactory) SpawnWorker(cmd *exec.Cmd) (w *Worker, err error) {
-	if w, err = newWorker(cmd); err != nil {
+// NewSocketFactoryFromFile returns SocketFactory attached to the listener inherited from the parent
+// process, for example during the binary upgrade. Given file is duplicated and can be closed
+// afterwards.
+func NewSocketFactoryFromFile(file *os.File, tout time.Duration) (*SocketFactory, error) {
+	ls, err := net.FileListener(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "inherited listener")
+	}
+
+	return NewSocketFactory(ls, tout), nil
+}
+
+// NewSocketFactoryFromAddr creates the listener based on given address and returns SocketFactory
+// attached to it. Address must be defined as DSN, example: "tcp://127.0.0.1:9000", "unix:///tmp/rr.sock".
+// Listener is bound before the factory is created, bind errors (for example address already in
+// use) are returned right away.
+func NewSocketFactoryFromAddr(addr string, tout time.Duration) (*SocketFactory, error) {
+	return NewSocketFactoryFromAddrWithBacklog(addr, tout, 0)
+}
+
+// NewSocketFactoryFromAddrWithBacklog creates the listener with the given connection backlog, see
+// NewSocketFactoryFromAddr. Larger backlog keeps connections of many simultaneously restarted
+// workers from being refused, OS limit (somaxconn) still applies. Zero uses the system default.
+func NewSocketFactoryFromAddrWithBacklog(addr string, tout time.Duration, backlog int) (*SocketFactory, error) {
+	ls, sockFile, err := listenAddr(addr)
+	if err != nil {
 		return nil, err
 	}
 
-	if err := w.start(); err != nil {
-		return nil, errors.Wrap(err, "process error")
+	if backlog != 0 {
+		if err := setBacklog(ls, backlog); err != nil {
+			_ = ls.Close()
+			return nil, errors.Wrap(err, "listener backlog")
+		}
+	}
+
+	f := NewSocketFactory(ls, tout)
+	f.sockFile, f.sockBacklog = sockFile, backlog
+
+	return f, nil
+}
+
+// SocketPermissions defines access to the unix socket file created by the factory, for example to
+// let only the worker user connect.
+type SocketPermissions struct {
+	// Mode of the socket file, zero keeps the mode defined by umask.
+	Mode os.FileMode
+
+	// Chown changes owner of the socket file to UID and GID.
+	Chown bool
+
+	// UID and GID of the socket file owner, -1 keeps the current value.
+	UID int
+	GID int
+}
+
+// NewSocketFactoryFromUnixAddr creates unix socket listener and applies given permissions to the
+// socket file, see NewSocketFactoryFromAddr. Address must use unix transport, example:
+// "unix:///var/run/rr/rr.sock".
+func NewSocketFactoryFromUnixAddr(addr string, tout time.Duration, perm SocketPermissions) (*SocketFactory, error) {
+	if !strings.HasPrefix(addr, "unix://") {
+		return nil, fmt.Errorf("invalid unix relay DSN `%s` (unix://rr.sock)", addr)
 	}
 
-	rl, err := f.findRelay(w, f.tout)
+	ls, sockFile, err := listenAddr(addr)
 	if err != nil {
-		go func(w *Worker) {
-			err := w.Kill()
-			if err != nil {
-				fmt.Println(fmt.Errorf("error killing the worker %v", err))
-			}
-		}(w)
+		return nil, err
+	}
 
-		if wErr := w.Wait(); wErr != nil {
-			if _, ok := wErr.(*exec.ExitError); ok {
-				err = errors.Wrap(wErr, err.Error())
-			} else {
-				err = wErr
-			}
+	if err := perm.apply(sockFile); err != nil {
+		// closing unix listener removes the socket file
+		_ = ls.Close()
+		return nil, errors.Wrap(err, "socket permissions")
+	}
+
+	f := NewSocketFactory(ls, tout)
+	f.sockFile, f.sockPerm = sockFile, perm
+
+	return f, nil
+}
+
+// apply changes mode and owner of the socket file.
+func (perm SocketPermissions) apply(sockFile string) error {
+	if perm.Mode != 0 {
+		if err := os.Chmod(sockFile, perm.Mode); err != nil {
+			return err
 		}
+	}
 
-		return nil, errors.Wrap(err, "unable to connect to worker")
+	if perm.Chown {
+		return os.Chown(sockFile, perm.UID, perm.GID)
 	}
 
-	w.rl = rl
-	w.state.set(StateReady)
+	return nil
+}
 
-	return w, nil
+// listenAddr creates listener based on DSN address. Returns name of the socket file for unix sockets.
+func listenAddr(addr string) (ls net.Listener, sockFile string, err error) {
+	dsn := strings.Split(addr, "://")
+	if len(dsn) != 2 {
+		return nil, "", errors.New("invalid relay DSN (tcp://:6001, unix://rr.sock)")
+	}
+
+	switch dsn[0] {
+	case "tcp":
+	case "unix":
+		if err := removeStaleSocket(dsn[1]); err != nil {
+			return nil, "", err
+		}
+		sockFile = dsn[1]
+	default:
+		return nil, "", fmt.Errorf("invalid relay transport `%s` (tcp, unix)", dsn[0])
+	}
+
+	if ls, err = net.Listen(dsn[0], dsn[1]); err != nil {
+		return nil, "", err
+	}
+
+	return ls, sockFile, nil
 }
 
-// Close socket factory and underlying socket connection.
-func (f *SocketFactory) Close() error {
-	return f.ls.Close()
+// removeStaleSocket removes socket file left by the crashed process, socket which accepts
+// connections or file of other type is never removed.
+func removeStaleSocket(sockFile string) error {
+	info, err := os.Stat(sockFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("unable to listen on `%s`, file is not a socket", sockFile)
+	}
+
+	if conn, err := net.DialTimeout("unix", sockFile, time.Second); err == nil {
+		_ = conn.Close()
+		return fmt.Errorf("unable to listen on `%s`, socket is in use", sockFile)
+	}
+
+	return syscall.Unlink(sockFile)
 }
 
-// listens for incoming socket connections
-func (f *SocketFactory) listen() {
-	for {
-		conn, err := f.ls.Accept()
-		if err != nil {
-			return
+// SetKeepAlive enables TCP keep-alive with the given period on accepted relay connections,
+// zero value disables it. System default applies until it's called. Option is ignored for unix
+// sockets and custom relay sources.
+// Keep-alive only detects unreachable peers, use pool IdleReadTimeout to detect workers
+// which are connected but stopped sending data.
+func (f *SocketFactory) SetKeepAlive(d time.Duration) {
+	for _, src := range f.sources {
+		if s, ok := src.(*listenerSource); ok {
+			if d <= 0 {
+				d = keepAliveOff
+			}
+
+			atomic.StoreInt64(&s.keepAlive, int64(d))
 		}
+	}
+}
 
-		rl := goridge.NewSocketRelay(conn)
-		if pid, err := fetchPID(rl); err == nil {
-			f.relayChan(pid) <- rl
+// SetSocketBuffers sets kernel send (SO_SNDBUF) and receive (SO_RCVBUF) buffer sizes in bytes
+// of the accepted relay connections, applied before the handshake. Zero keeps the system default
+// which is recommended for most workloads: explicit size disables TCP buffer auto-tuning on
+// Linux. Larger buffers (256KB-1MB) reduce number of syscalls for payloads of hundreds of
+// kilobytes, small payloads do not benefit. Relay is not buffered in user space, unread frames
+// must stay visible to the out of sync detection. Option is ignored for custom relay sources.
+func (f *SocketFactory) SetSocketBuffers(sendBuffer, recvBuffer int) {
+	for _, src := range f.sources {
+		if s, ok := src.(*listenerSource); ok {
+			atomic.StoreInt64(&s.sendBuffer, int64(sendBuffer))
+			atomic.StoreInt64(&s.recvBuffer, int64(recvBuffer))
 		}
 	}
 }
 
-// waits for worker to connect over socket and returns associated relay of timeout
-func (f *SocketFactory) findRelay(w *Worker, tout time.Duration) (*goridge.SocketRelay, error) {
-	timer := time.NewTimer(tout)
-	for {
-		select {
-		case rl := <-f.relayChan(*w.Pid):
-			timer.Stop()
-			f.cleanChan(*w.Pid)
-			return rl, nil
+// SetCompression defines which accepted connections are offered deflate compression during the
+// PID handshake, workers which do not accept the offer keep the relay uncompressed (see
+// CompressionDeflate). Compression pays off for large text payloads (JSON, HTML) sent over the
+// network, CompressionRemote skips loopback connections. Unread frames sent by the worker after
+// the response are not detected on compressed relays and compressed relays can not be hijacked.
+// Applies to connections accepted afterwards, option is ignored for custom handshakes and custom
+// relay sources.
+func (f *SocketFactory) SetCompression(mode CompressionMode) {
+	for _, src := range f.sources {
+		if s, ok := src.(*listenerSource); ok {
+			atomic.StoreInt64(&s.compression, int64(mode))
+		}
+	}
+}
 
-		case <-timer.C:
-			return nil, fmt.Errorf("relay timeout")
+// SetHandshakeTimeout limits for how long accepted connection may take to complete the handshake,
+// connection is closed once timeout is reached, zero value disables the limit. Connection which
+// never completes the handshake otherwise holds the accepting goroutine, see SetAcceptConcurrency.
+// Option is ignored for custom relay sources.
+func (f *SocketFactory) SetHandshakeTimeout(d time.Duration) {
+	for _, src := range f.sources {
+		if s, ok := src.(*listenerSource); ok {
+			atomic.StoreInt64(&s.handshakeTimeout, int64(d))
+		}
+	}
+}
 
-		case <-w.waitDone:
-			timer.Stop()
-			f.cleanChan(*w.Pid)
-			return nil, fmt.Errorf("worker is gone")
+// SetPoolToken sets token exchanged with workers during the PID handshake, connections of workers
+// configured with different token (RR_POOL_TOKEN) are closed. Empty token disables the check.
+// Option is ignored for custom relay sources.
+func (f *SocketFactory) SetPoolToken(token string) {
+	for _, src := range f.sources {
+		if s, ok := src.(*listenerSource); ok {
+			s.token.Store(token)
 		}
 	}
 }
 
-// chan to store relay associated with specific Pid
-func (f *SocketFactory) relayChan(pid int) chan *goridge.SocketRelay {
+// SetPendingRelayTTL closes relays which are not claimed by any spawned worker within the given
+// duration, for example connections of the processes not started by the factory. Zero value
+// disables the sweeper.
+func (f *SocketFactory) SetPendingRelayTTL(ttl time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	atomic.StoreInt64(&f.pendingTTL, int64(ttl))
+	if ttl != 0 && !f.sweeping && !f.closed {
+		f.sweeping = true
+		go f.sweep()
+	}
+}
+
+// SetAcceptConcurrency sets number of goroutines accepting relays of every listener, handshakes of
+// simultaneously connecting workers proceed in parallel. Number of goroutines can only be raised.
+// Option is ignored for custom relay sources, they are served by single goroutine.
+func (f *SocketFactory) SetAcceptConcurrency(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return
+	}
+
+	for ; f.acceptors < n; f.acceptors++ {
+		for id, src := range f.sources {
+			if _, ok := src.(*listenerSource); ok {
+				go f.listen(id)
+			}
+		}
+	}
+}
+
+// PauseAccept stops handing accepted relays over to the workers while listeners stay bound, so
+// the address can not be taken by other process, for example to freeze number of the workers
+// during an incident. Relays accepted while paused are held for PausedRelayHold and closed if
+// accepting is not resumed in time, holding relay blocks accepting of the next connections of
+// the listener. Workers spawned while paused fail once relay timeout is reached.
+func (f *SocketFactory) PauseAccept() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.resumed == nil {
+		f.resumed = make(chan interface{})
+	}
+}
+
+// ResumeAccept resumes handing relays over to the workers, held relays are delivered right away.
+func (f *SocketFactory) ResumeAccept() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.resumed != nil {
+		close(f.resumed)
+		f.resumed = nil
+	}
+}
+
+// AcceptPaused returns true while accepting is paused, see PauseAccept.
+func (f *SocketFactory) AcceptPaused() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.resumed != nil
+}
+
+// PendingRelays returns number of accepted relays waiting for the worker association.
+func (f *SocketFactory) PendingRelays() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return len(f.pending)
+}
+
+// OpenConns returns number of connections accepted by the factory listeners which are not closed
+// yet: connections in the handshake, relays waiting for the association and relays of the running
+// workers. Connections of the custom relay sources and adopted workers are not counted. Safe for
+// concurrent use, growing count of the factory with stable number of workers indicates leaking
+// connections.
+func (f *SocketFactory) OpenConns() int {
+	n := 0
+	for _, src := range f.sources {
+		if s, ok := src.(*listenerSource); ok {
+			n += s.openConns()
+		}
+	}
+
+	return n
+}
+
+// Pending returns snapshot of the relay associations in progress, for example to diagnose hanging
+// pool start: workers waiting for the relay which never arrived and relays which arrived without
+// a waiting worker (wrong PID, worker of another factory). Read only, snapshot is taken under the
+// factory lock and is cheap enough for admin endpoints. Entries are ordered by listener and PID.
+func (f *SocketFactory) Pending() PendingSnapshot {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
-	rl, ok := f.relays[pid]
+	s := PendingSnapshot{
+		Failures:    append([]HandshakeFailure(nil), f.recentFailures...),
+		NumFailures: atomic.LoadInt64(&f.numFailures),
+	}
+
+	delivered := make(map[relayKey]bool, len(f.pending))
+	for _, pr := range f.pending {
+		delivered[pr.key] = true
+		s.Unclaimed = append(s.Unclaimed, PendingWorker{ListenerID: pr.key.listener, PID: pr.key.pid, Since: pr.since})
+	}
+
+	for key := range f.relays {
+		if !delivered[key] {
+			s.Awaited = append(s.Awaited, PendingWorker{ListenerID: key.listener, PID: key.pid})
+		}
+	}
+
+	sortPending(s.Awaited)
+	sortPending(s.Unclaimed)
+
+	return s
+}
+
+// SetHandshake replaces PID handshake of the accepted connections with the given function, for
+// example to receive worker capabilities along with the PID. Custom handshake is responsible for
+// the worker verification, secret and pool token are not checked. Nil restores PID handshake.
+// Option is ignored for custom relay sources.
+func (f *SocketFactory) SetHandshake(h HandshakeFunc) {
+	for _, src := range f.sources {
+		if s, ok := src.(*listenerSource); ok {
+			s.handshake.Store(h)
+		}
+	}
+}
+
+// SetListenerMiddleware wraps listeners of the factory with the given middleware, for example to
+// measure accept latency, count connections by remote address or cap connections per IP before the
+// handshake (see MaxConnsPerIP). Connections returned by the wrapped listener proceed to the
+// handshake, accept errors are handled the same way as errors of the original listener. Middleware
+// is applied to listeners installed later by SwapListener and WatchSocketFile as well, factory
+// Close closes both wrapped and original listener. Nil removes the middleware. Option is ignored
+// for custom relay sources.
+func (f *SocketFactory) SetListenerMiddleware(mw ListenerMiddleware) {
+	for _, src := range f.sources {
+		if s, ok := src.(*listenerSource); ok {
+			s.setMiddleware(mw)
+		}
+	}
+}
+
+// Addr returns bound address of the relay listener, useful when listening on ephemeral port.
+// Returns nil for custom relay sources.
+func (f *SocketFactory) Addr() net.Addr {
+	return f.ListenerAddr(0)
+}
+
+// ListenerAddr returns bound address of the listener with given ID. Returns nil for unknown
+// listeners and custom relay sources.
+func (f *SocketFactory) ListenerAddr(listenerID int) net.Addr {
+	if listenerID < 0 || listenerID >= len(f.sources) {
+		return nil
+	}
+
+	if s, ok := f.sources[listenerID].(*listenerSource); ok {
+		return s.base().Addr()
+	}
+
+	return nil
+}
+
+// String returns relay address in a form suitable for worker configuration, tcp://host:port
+// or unix://path. Returns empty string for custom relay sources.
+func (f *SocketFactory) String() string {
+	addr := f.Addr()
+	if addr == nil {
+		return ""
+	}
+
+	return fmt.Sprintf("%s://%s", addr.Network(), addr.String())
+}
+
+// SwapListener replaces the listener of the factory with the given one, for example to migrate
+// from the TCP port to the unix socket without restarting the workers. Workers spawned afterwards
+// must connect to the new listener (see String). Previous listener stops accepting connections
+// and is closed when closePrevious is set, listener without deadline support keeps accepting
+// until closed by the caller otherwise. Listener stopped by the permanent accept error starts
+// accepting again.
+//
+// State carried over the swap:
+//   - workers associated before the swap keep their relays,
+//   - workers waiting for the relay are associated with relays of both listeners: connections
+//     accepted or being in the handshake on the previous listener are delivered as usual,
+//   - listener ID and options of the accepted connections (keep-alive, socket buffers,
+//     compression, TLS, handshake timeout, custom handshake, secret and pool token) apply to the
+//     new listener.
+//
+// Transport of the workers associated afterwards is the network of the new listener. Socket file
+// created by the factory is no longer removed on Close, closing the previous unix listener removes
+// it. Not supported by custom relay sources and once socket file is watched, see WatchSocketFile.
+func (f *SocketFactory) SwapListener(ls net.Listener, closePrevious bool) error {
+	s, ok := f.sources[0].(*listenerSource)
 	if !ok {
-		f.relays[pid] = make(chan *goridge.SocketRelay)
-		return f.relays[pid]
+		return fmt.Errorf("listener swap is not supported by custom relay sources")
 	}
 
-	return rl
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return ErrFactoryClosed
+	}
+
+	if f.watching {
+		return fmt.Errorf("listener swap is not supported while socket file is watched")
+	}
+
+	prev, err := s.swap(ls)
+	if err != nil {
+		return err
+	}
+
+	f.transports[0], f.sockFile = ls.Addr().Network(), ""
+
+	// accepting goroutines blocked on the previous listener continue with the new one
+	if closePrevious {
+		err = prev.Close()
+	} else if dl, ok := prev.(interface{ SetDeadline(t time.Time) error }); ok {
+		err = dl.SetDeadline(time.Now())
+	}
+
+	if atomic.SwapInt32(&f.stopped[0], 0) == 1 {
+		for i := 0; i < f.acceptors; i++ {
+			go f.listen(0)
+		}
+	}
+
+	return err
 }
 
-// deletes relay chan associated with specific Pid
-func (f *SocketFactory) cleanChan(pid int) {
+// AddListener attaches observer to be notified about worker lifecycle events.
+func (f *SocketFactory) AddListener(l func(event FactoryEvent)) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
-	delete(f.relays, pid)
+	f.listeners = append(f.listeners, l)
+}
+
+// SpawnWorker creates worker and connects it to appropriate relay or returns error
+func (f *SocketFactory) SpawnWorker(cmd *exec.Cmd) (w *Worker, err error) {
+	return f.SpawnWorkerContext(context.Background(), cmd)
+}
+
+// SpawnWorkerContext creates worker and connects it to appropriate relay or returns error. Worker
+// is killed and ctx.Err() is returned if context is done before relay association.
+func (f *SocketFactory) SpawnWorkerContext(ctx context.Context, cmd *exec.Cmd) (w *Worker, err error) {
+	return f.spawnWorker(ctx, 0, cmd)
+}
+
+// SpawnWorkerOn creates worker and waits for it to connect to the listener with given ID.
+func (f *SocketFactory) SpawnWorkerOn(listenerID int, cmd *exec.Cmd) (w *Worker, err error) {
+	if listenerID < 0 || listenerID >= len(f.sources) {
+		return nil, fmt.Errorf("undefined listener %v", listenerID)
+	}
+
+	return f.spawnWorker(context.Background(), listenerID, cmd)
+}
+
+// spawnWorker creates worker and associates it with relay received from the given listener.
+func (f *SocketFactory) spawnWorker(ctx context.Context, listenerID int, cmd *exec.Cmd) (w *Worker, err error) {
+	if f.isClosed() || atomic.LoadInt32(&f.stopped[listenerID]) == 1 {
+		// process would never connect
+		return nil, ErrFactoryClosed
+	}
+
+	if w, err = newWorker(cmd); err != nil {
+		return nil, err
+	}
+
+	sctx := ctx
+	if f.StartTimeout != 0 {
+		var cancel context.CancelFunc
+		sctx, cancel = context.WithTimeout(ctx, f.StartTimeout)
+		defer cancel()
+	}
+
+	w.limits = f.ResourceLimits
+	if err := w.start(); err != nil {
+		return nil, errors.Wrap(err, "process error")
+	}
+
+	key := relayKey{listener: listenerID, pid: *w.Pid}
+	f.expect(key, true)
+	defer f.expect(key, false)
+
+	f.throw(EventWorkerConstruct, w, nil)
+
+	connected := time.Now()
+	_, span := tracerOrNop(f.Tracer).StartSpan(ctx, SpanRelayWait)
+	rl, err := f.findRelay(sctx, listenerID, w, f.tout)
+	endSpan(span, err)
+	w.relayWait = time.Since(connected)
+	if err != nil {
+		cancelled := err == sctx.Err()
+		err = w.failStart(err)
+
+		if cancelled {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+
+			return nil, fmt.Errorf("worker start deadline exceeded")
+		}
+
+		return nil, err
+	}
+
+	// watcher closes the relay of the exited process unless process exits before the relay is set
+	w.mu.Lock()
+	select {
+	case <-w.waitDone:
+		w.mu.Unlock()
+		_ = f.accepted(listenerID, rl)
+		_ = rl.Close()
+
+		f.logger().Warn("worker gone during relay association", "pid", *w.Pid)
+		return nil, w.failStart(fmt.Errorf("worker is gone"))
+	default:
+		w.rl = rl
+	}
+	w.mu.Unlock()
+
+	if ar := f.accepted(listenerID, rl); ar != nil {
+		w.conn, w.frames, w.meta = ar.conn, ar.conn.frames, ar.meta
+		if !ar.conn.compressed() {
+			// decompressor reads ahead, unread frames are not visible on the socket
+			w.stream, _ = ar.conn.Conn.(syscall.Conn)
+		}
+	}
+	w.Transport = f.transport(listenerID)
+	w.codec = f.Codec
+
+	if f.ProtocolConstraint != "" {
+		if err := w.checkProtocol(f.ProtocolConstraint); err != nil {
+			return nil, w.failStart(errors.Wrap(err, "protocol"))
+		}
+	}
+
+	if f.BootstrapPayload != nil {
+		if body := f.BootstrapPayload(commandWorkerID(cmd)); body != nil {
+			if err := w.bootstrap(body, f.tout); err != nil {
+				return nil, w.failStart(errors.Wrap(err, "bootstrap"))
+			}
+		}
+	}
+
+	if f.WarmupPayload != nil {
+		if err := w.warmup(f.WarmupPayload, f.tout); err != nil {
+			return nil, w.failStart(errors.Wrap(err, "warmup"))
+		}
+	}
+
+	w.state.set(StateReady)
+
+	return w, nil
+}
+
+// AdoptWorker registers already running worker connected over the given connection, for example
+// worker of the previous parent process after the binary upgrade. Worker is expected to be idle
+// and is verified using the PID ping command. Limitations: adopted process must be a child of
+// the current process (which is the case after re-exec of the parent) to be waited for, stderr
+// of the adopted worker is not captured and resource limits, bootstrap, warm-up and protocol
+// checks are not applied. Connections of the workers re-connecting to the listener are not
+// adopted automatically.
+func (f *SocketFactory) AdoptWorker(pid int, conn net.Conn) (*Worker, error) {
+	if f.isClosed() {
+		return nil, ErrFactoryClosed
+	}
+
+	w, err := adoptWorker(pid)
+	if err != nil {
+		return nil, errors.Wrap(err, "process error")
+	}
+
+	fc := newFrameConn(conn)
+	w.rl, w.conn, w.frames = goridge.NewSocketRelay(fc), fc, fc.frames
+	w.stream, _ = conn.(syscall.Conn)
+	w.Transport = conn.LocalAddr().Network()
+	w.codec = f.Codec
+
+	w.state.set(StateReady)
+	if err := w.Ping(); err != nil {
+		w.detach()
+		return nil, errors.Wrap(err, "adopt")
+	}
+
+	select {
+	case <-w.waitDone:
+		w.detach()
+		return nil, fmt.Errorf("worker process is gone or is not a child process")
+	default:
+	}
+
+	f.logger().Info("worker adopted", "pid", pid)
+	f.throw(EventRelayAssociate, w, nil)
+
+	return w, nil
+}
+
+// Close socket factory and underlying socket connection. Socket file is removed
+// when listener was created by the factory. All workers waiting for relay association
+// are released with an error. Close can be called multiple times.
+func (f *SocketFactory) Close() error {
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return nil
+	}
+	f.closed = true
+	close(f.done)
+	sockFile := f.sockFile
+	f.mu.Unlock()
+
+	var err error
+	for _, src := range f.sources {
+		if c, ok := src.(io.Closer); ok {
+			if cErr := c.Close(); cErr != nil && err == nil {
+				err = cErr
+			}
+		}
+	}
+	f.deliveries.Wait()
+
+	// draining pending relays
+	f.mu.Lock()
+	for key, rl := range f.relays {
+		close(rl)
+		delete(f.relays, key)
+	}
+	f.mu.Unlock()
+
+	if sockFile != "" {
+		if rErr := os.Remove(sockFile); rErr != nil && !os.IsNotExist(rErr) && err == nil {
+			err = rErr
+		}
+	}
+
+	return err
+}
+
+// listens for incoming relays of the given listener
+func (f *SocketFactory) listen(listenerID int) {
+	var delay time.Duration
+	for {
+		rl, pid, err := f.accept(listenerID)
+		if err != nil {
+			if temporary(err) && !f.isClosed() {
+				// retry on transient errors (for example fd exhaustion) with backoff
+				if delay == 0 {
+					delay = minAcceptDelay
+				} else if delay *= 2; delay > maxAcceptDelay {
+					delay = maxAcceptDelay
+				}
+
+				f.logger().Warn("relay accept error", "error", err, "retry", delay)
+
+				select {
+				case <-f.clock().After(delay):
+					continue
+				case <-f.done:
+					return
+				}
+			}
+
+			if !f.isClosed() && atomic.SwapInt32(&f.stopped[listenerID], 1) == 0 {
+				f.logger().Error("relay listener stopped", "listener", listenerID, "error", err)
+			}
+
+			return
+		}
+
+		delay = 0
+		if !f.hold() {
+			f.logger().Warn("relay accepted while accepting is paused, connection closed", "pid", pid)
+			_ = f.accepted(listenerID, rl)
+			_ = rl.Close()
+			continue
+		}
+
+		f.deliver(relayKey{listener: listenerID, pid: pid}, rl)
+	}
+}
+
+// hold waits up to PausedRelayHold for ResumeAccept while accepting is paused, returns false when
+// accepted relay must be closed.
+func (f *SocketFactory) hold() bool {
+	f.mu.Lock()
+	resumed := f.resumed
+	f.mu.Unlock()
+
+	if resumed == nil {
+		return true
+	}
+
+	if f.PausedRelayHold == 0 {
+		return false
+	}
+
+	timer := f.clock().NewTimer(f.PausedRelayHold)
+	defer timer.Stop()
+
+	select {
+	case <-resumed:
+		return true
+	case <-timer.C():
+		return false
+	case <-f.done:
+		return false
+	}
+}
+
+// accept waits for the next relay of the given listener, panic of the relay source is recovered
+// and returned as PanicError.
+func (f *SocketFactory) accept(listenerID int) (rl *goridge.SocketRelay, pid int, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = newPanicError(r)
+		}
+
+		if pe, ok := err.(PanicError); ok {
+			f.panicked(nil, pe)
+		}
+	}()
+
+	return f.sources[listenerID].Accept()
+}
+
+// temporary returns true for accept errors listener can recover from, recovered panics included.
+func temporary(err error) bool {
+	if _, ok := err.(PanicError); ok {
+		return true
+	}
+
+	ne, ok := err.(net.Error)
+	return ok && ne.Temporary()
+}
+
+// panicked reports panic recovered inside the factory goroutine.
+func (f *SocketFactory) panicked(w *Worker, err PanicError) {
+	f.logger().Error("factory panic recovered", "error", err, "stack", string(err.Stack))
+	f.throw(EventPanic, w, err)
+}
+
+// deliver registers pending relay and passes it to the worker waiting for it in background,
+// relay is closed if factory is closing or too many relays are pending already.
+func (f *SocketFactory) deliver(key relayKey, rl *goridge.SocketRelay) {
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		_ = f.accepted(key.listener, rl)
+		_ = rl.Close()
+		return
+	}
+
+	if f.RejectUnknownPIDs && !f.expected[key] {
+		f.mu.Unlock()
+
+		var addr net.Addr
+		if ar := f.accepted(key.listener, rl); ar != nil {
+			addr = ar.conn.RemoteAddr()
+		}
+
+		f.handshakeFailed(key, addr, fmt.Errorf("process has not been spawned by the factory"))
+		_ = rl.Close()
+		return
+	}
+
+	if f.MaxPendingRelays != 0 && len(f.pending) >= f.MaxPendingRelays {
+		f.mu.Unlock()
+		f.logger().Warn("too many pending relays, connection closed", "pid", key.pid, "limit", f.MaxPendingRelays)
+		f.closeRelay(key, rl)
+		return
+	}
+	f.deliveries.Add(1)
+
+	ch, ok := f.relays[key]
+	if !ok {
+		ch = make(chan *goridge.SocketRelay)
+		f.relays[key] = ch
+	}
+
+	pr := &pendingRelay{key: key, since: f.clock().Now(), expired: make(chan interface{})}
+	f.pending[rl] = pr
+	f.mu.Unlock()
+
+	go func() {
+		defer f.deliveries.Done()
+		defer func() {
+			if r := recover(); r != nil {
+				f.mu.Lock()
+				delete(f.pending, rl)
+				f.mu.Unlock()
+
+				f.panicked(nil, newPanicError(r))
+			}
+		}()
+
+		select {
+		case ch <- rl:
+			// claimed by the worker
+		case <-pr.expired:
+			f.logger().Warn("relay has not been claimed, connection closed", "pid", key.pid, "since", pr.since)
+			f.closeRelay(key, rl)
+		case <-f.done:
+			f.closeRelay(key, rl)
+		}
+	}()
+}
+
+// closeRelay closes relay which has not been claimed by any worker.
+func (f *SocketFactory) closeRelay(key relayKey, rl *goridge.SocketRelay) {
+	f.mu.Lock()
+	delete(f.pending, rl)
+
+	// forgetting the association slot unless other relays of the same worker are pending
+	shared := false
+	for _, pr := range f.pending {
+		shared = shared || pr.key == key
+	}
+
+	if !shared && !f.closed {
+		delete(f.relays, key)
+	}
+	f.mu.Unlock()
+
+	// releasing connection tracked by the listener
+	_ = f.accepted(key.listener, rl)
+	_ = rl.Close()
+}
+
+// sweep periodically expires relays which are pending for longer than pending relay TTL.
+func (f *SocketFactory) sweep() {
+	for {
+		f.mu.Lock()
+		ttl := time.Duration(atomic.LoadInt64(&f.pendingTTL))
+		if ttl == 0 {
+			f.sweeping = false
+			f.mu.Unlock()
+			return
+		}
+		f.mu.Unlock()
+
+		timer := f.clock().NewTimer(ttl / 2)
+		select {
+		case <-timer.C():
+		case <-f.done:
+			timer.Stop()
+			return
+		}
+
+		f.mu.Lock()
+		for _, pr := range f.pending {
+			if f.clock().Now().Sub(pr.since) >= ttl {
+				pr.expire()
+			}
+		}
+		f.mu.Unlock()
+	}
+}
+
+// waits for worker to connect over socket and returns associated relay of timeout, panic during
+// the association is returned as PanicError
+func (f *SocketFactory) findRelay(ctx context.Context, listenerID int, w *Worker, tout time.Duration) (rl *goridge.SocketRelay, err error) {
+	key := relayKey{listener: listenerID, pid: *w.Pid}
+	defer func() {
+		if r := recover(); r != nil {
+			f.cleanChan(key)
+			if rl != nil {
+				_ = f.accepted(listenerID, rl)
+				_ = rl.Close()
+			}
+
+			pe := newPanicError(r)
+			f.panicked(w, pe)
+			rl, err = nil, pe
+		}
+	}()
+
+	attempts, ok := 0, false
+	start := f.clock().Now()
+	failures := atomic.LoadInt64(&f.numFailures)
+
+	timer := f.clock().NewTimer(tout)
+	for {
+		select {
+		case rl, ok = <-f.relayChan(key):
+			if !ok {
+				timer.Stop()
+				f.cleanChan(key)
+				return nil, ErrFactoryClosed
+			}
+
+			f.mu.Lock()
+			delete(f.pending, rl)
+			f.mu.Unlock()
+
+			if f.MaxRelayAttempts > 1 {
+				if err := pingRelay(rl, RelayProbeTimeout); err != nil {
+					_ = f.accepted(listenerID, rl)
+					_ = rl.Close()
+					f.logger().Warn("relay probe failed", "pid", *w.Pid, "error", err)
+
+					// waiting for worker to reconnect
+					if attempts++; attempts < f.MaxRelayAttempts {
+						continue
+					}
+
+					timer.Stop()
+					f.cleanChan(key)
+					return nil, errors.Wrap(err, "relay probe")
+				}
+			}
+
+			timer.Stop()
+			f.cleanChan(key)
+
+			f.logger().Info("relay associated", "pid", *w.Pid, "elapsed", f.clock().Now().Sub(start))
+			f.throw(EventRelayAssociate, w, nil)
+			return rl, nil
+
+		case <-timer.C():
+			err := f.timeoutError(key, failures)
+			f.cleanChan(key)
+			f.logger().Warn("relay timeout", "pid", *w.Pid, "timeout", tout, "error", err)
+			f.throw(EventRelayTimeout, w, err)
+			return nil, err
+
+		case <-ctx.Done():
+			timer.Stop()
+			f.cleanChan(key)
+			return nil, ctx.Err()
+
+		case <-w.waitDone:
+			timer.Stop()
+			f.cleanChan(key)
+
+			err := ExitError{WaitError: w.waitError()}
+			f.logger().Warn("worker gone during relay association", "pid", *w.Pid, "status", err.Status())
+			f.throw(EventRelayWorkerDead, w, err)
+			return nil, err
+		}
+	}
+}
+
+// handshakeFailed registers failed handshake, failure is remembered when worker with claimed PID is
+// waiting for the association.
+func (f *SocketFactory) handshakeFailed(key relayKey, addr net.Addr, err error) {
+	atomic.AddInt64(&f.numFailures, 1)
+	f.logger().Warn("relay handshake failed", "pid", key.pid, "addr", addr, "error", err)
+
+	failure := HandshakeFailure{ListenerID: key.listener, PID: key.pid, Error: err.Error(), Time: f.clock().Now()}
+	if addr != nil {
+		failure.Addr = addr.String()
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.recentFailures) == RecentHandshakeFailures {
+		f.recentFailures = append(f.recentFailures[:0], f.recentFailures[1:]...)
+	}
+	f.recentFailures = append(f.recentFailures, failure)
+
+	if key.pid == 0 {
+		return
+	}
+
+	if _, waiting := f.relays[key]; waiting {
+		f.failures[key] = err
+	}
+}
+
+// timeoutError describes why relay has not been associated in time, since is number of failed
+// handshakes before the association started.
+func (f *SocketFactory) timeoutError(key relayKey, since int64) error {
+	f.mu.Lock()
+	reason, ok := f.failures[key]
+	delete(f.failures, key)
+	f.mu.Unlock()
+
+	if ok {
+		return fmt.Errorf("worker connected but handshake failed: %s", reason)
+	}
+
+	if n := atomic.LoadInt64(&f.numFailures) - since; n != 0 {
+		return fmt.Errorf("relay timeout (%v failed handshakes)", n)
+	}
+
+	return fmt.Errorf("relay timeout")
+}
+
+// sortPending orders pending workers by listener and PID.
+func sortPending(workers []PendingWorker) {
+	sort.Slice(workers, func(i, j int) bool {
+		if workers[i].ListenerID != workers[j].ListenerID {
+			return workers[i].ListenerID < workers[j].ListenerID
+		}
+
+		return workers[i].PID < workers[j].PID
+	})
+}
+
+// chan to store relay associated with specific Pid
+func (f *SocketFactory) relayChan(key relayKey) chan *goridge.SocketRelay {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		// no relays will be delivered anymore
+		rl := make(chan *goridge.SocketRelay)
+		close(rl)
+		return rl
+	}
+
+	rl, ok := f.relays[key]
+	if !ok {
+		f.relays[key] = make(chan *goridge.SocketRelay)
+		return f.relays[key]
+	}
+
+	return rl
+}
+
+// logger returns attached logger or no-op logger.
+func (f *SocketFactory) logger() Logger {
+	if f.Logger == nil {
+		return nopLogger{}
+	}
+
+	return f.Logger
+}
+
+// transport returns transport name of the listener with the given ID.
+func (f *SocketFactory) transport(listenerID int) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.transports[listenerID]
+}
+
+// clock returns factory clock.
+func (f *SocketFactory) clock() Clock {
+	return clockOrSystem(f.Clock)
+}
+
+// accepted returns connection and handshake metadata of the relay accepted by built-in listener,
+// nil for custom sources.
+func (f *SocketFactory) accepted(listenerID int, rl *goridge.SocketRelay) *acceptedRelay {
+	s, ok := f.sources[listenerID].(*listenerSource)
+	if !ok {
+		return nil
+	}
+
+	ar, ok := s.conns.Load(rl)
+	if !ok {
+		return nil
+	}
+
+	s.conns.Delete(rl)
+	return ar.(*acceptedRelay)
+}
+
+// isClosed returns true if factory has been closed.
+func (f *SocketFactory) isClosed() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.closed
+}
+
+// expect marks process started by the factory as expected to connect, or forgets it.
+func (f *SocketFactory) expect(key relayKey, expected bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !expected {
+		delete(f.expected, key)
+		return
+	}
+
+	if f.expected == nil {
+		f.expected = make(map[relayKey]bool)
+	}
+	f.expected[key] = true
+}
+
+// deletes relay chan associated with specific Pid, relays delivered for the worker which no longer
+// waits for them are never claimed and are closed
+func (f *SocketFactory) cleanChan(key relayKey) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.relays, key)
+	delete(f.failures, key)
+
+	for _, pr := range f.pending {
+		if pr.key == key {
+			pr.expire()
+		}
+	}
+}
+
+// throw invokes all attached listeners, listeners are called outside of the lock.
+func (f *SocketFactory) throw(event int, w *Worker, err error) {
+	f.mu.Lock()
+	listeners := append([]func(event FactoryEvent){}, f.listeners...)
+	f.mu.Unlock()
+
+	for _, l := range listeners {
+		l(FactoryEvent{Event: event, Worker: w, Error: err})
+	}
+}
+
+// listenerSource accepts worker connections on socket listener, workers are identified
+// by the PID sent during the handshake.
+type listenerSource struct {
+	// protects listener replaced once socket file is re-bound
+	mu sync.Mutex
+	ls net.Listener
+
+	// wraps the listener, nil when not set, protected by mu
+	middleware ListenerMiddleware
+
+	// listener wrapped by the middleware and the listener given to it, nil without the
+	// middleware, protected by mu
+	wrapped net.Listener
+	inner   *innerListener
+
+	// indicates that source has been closed, protected by mu
+	closed bool
+
+	// accept TLS connections only when set
+	tls *tls.Config
+
+	// limits TLS handshake duration
+	tout time.Duration
+
+	// TCP keep-alive period of accepted connections, accessed atomically, 0 for the system default
+	// and keepAliveOff to disable
+	keepAlive int64
+
+	// max duration of the PID or custom handshake, accessed atomically, 0 for unlimited
+	handshakeTimeout int64
+
+	// kernel buffer sizes of accepted connections in bytes, accessed atomically, 0 for default
+	sendBuffer int64
+	recvBuffer int64
+
+	// secret used to verify worker PID signature, empty to disable verification
+	secret []byte
+
+	// token worker must echo during the handshake, holds string, empty to disable verification
+	token atomic.Value
+
+	// notified about failed TLS or PID handshakes, pid is 0 when unknown
+	failed func(pid int, addr net.Addr, err error)
+
+	// handshake replacing PID handshake, holds HandshakeFunc, nil to use PID handshake
+	handshake atomic.Value
+
+	// CompressionMode of the accepted connections, accessed atomically
+	compression int64
+
+	// connections and metadata of accepted relays until they are claimed by the workers
+	conns sync.Map
+
+	// connections in the handshake, closed once source is closed
+	handshaking sync.Map
+
+	// number of accepted connections which are not closed yet, accessed atomically
+	open int64
+}
+
+// acceptedRelay describes relay connection which passed the handshake.
+type acceptedRelay struct {
+	conn *frameConn
+	meta map[string]string
+}
+
+// Accept waits for the next connection which passed the handshake.
+func (s *listenerSource) Accept() (*goridge.SocketRelay, int, error) {
+	for {
+		ls := s.listener()
+		conn, err := ls.Accept()
+		if err != nil {
+			if s.listener() != ls {
+				// listener has been re-bound
+				continue
+			}
+
+			return nil, 0, err
+		}
+
+		if s.requeue(ls, conn) {
+			continue
+		}
+
+		rl, pid, err := s.serve(conn)
+		if _, ok := err.(PanicError); ok {
+			return nil, 0, err
+		}
+
+		if err == nil {
+			return rl, pid, nil
+		}
+	}
+}
+
+// serve performs the handshake of the accepted connection, connection is closed on error. Panic
+// of the handshake is returned as PanicError.
+func (s *listenerSource) serve(conn net.Conn) (*goridge.SocketRelay, int, error) {
+	atomic.AddInt64(&s.open, 1)
+
+	// closed by the source Close while the handshake is in progress
+	s.handshaking.Store(conn, true)
+	defer s.handshaking.Delete(conn)
+
+	if s.isClosed() {
+		s.discard(conn)
+		return nil, 0, ErrFactoryClosed
+	}
+
+	if d := time.Duration(atomic.LoadInt64(&s.keepAlive)); d != 0 {
+		if d == keepAliveOff {
+			d = 0
+		}
+
+		if err := setKeepAlive(conn, d); err != nil {
+			s.discard(conn)
+			return nil, 0, err
+		}
+	}
+
+	send, recv := int(atomic.LoadInt64(&s.sendBuffer)), int(atomic.LoadInt64(&s.recvBuffer))
+	if err := setSocketBuffers(conn, send, recv); err != nil {
+		s.discard(conn)
+		return nil, 0, err
+	}
+
+	if s.tls != nil {
+		tc := tls.Server(conn, s.tls)
+
+		_ = tc.SetDeadline(time.Now().Add(s.tout))
+		if err := tc.Handshake(); err != nil {
+			// invalid or untrusted client
+			err = errors.Wrap(err, "tls")
+			s.fail(0, tc, err)
+			s.discard(tc)
+			return nil, 0, err
+		}
+		_ = tc.SetDeadline(time.Time{})
+
+		conn = tc
+	}
+
+	if d := time.Duration(atomic.LoadInt64(&s.handshakeTimeout)); d != 0 {
+		_ = conn.SetDeadline(time.Now().Add(d))
+	}
+
+	var compress string
+	if CompressionMode(atomic.LoadInt64(&s.compression)).offers(conn) {
+		compress = CompressionDeflate
+	}
+
+	fc := newFrameConn(conn)
+	fc.onClose = s.connClosed
+
+	rl := goridge.NewSocketRelay(fc)
+	pid, meta, codec, err := s.identify(rl, compress)
+	if _, ok := err.(PanicError); ok {
+		// connection state is unknown
+		_ = rl.Close()
+		return nil, 0, err
+	}
+
+	if ne, ok := errors.Cause(err).(net.Error); ok && ne.Timeout() {
+		err = errors.Wrap(err, "handshake timeout")
+	}
+
+	if err != nil {
+		// unknown, unauthorized or stalled connection
+		s.fail(pid, conn, err)
+		_ = rl.Close()
+		return nil, 0, err
+	}
+
+	_ = conn.SetDeadline(time.Time{})
+	if codec != "" {
+		fc.compress()
+	}
+
+	s.conns.Store(rl, &acceptedRelay{conn: fc, meta: meta})
+	return rl, pid, nil
+}
+
+// discard closes connection which has not been wrapped into the relay yet.
+func (s *listenerSource) discard(conn net.Conn) {
+	_ = conn.Close()
+	s.connClosed()
+}
+
+// connClosed accounts closed connection.
+func (s *listenerSource) connClosed() {
+	atomic.AddInt64(&s.open, -1)
+}
+
+// openConns returns number of accepted connections which are not closed yet.
+func (s *listenerSource) openConns() int {
+	return int(atomic.LoadInt64(&s.open))
+}
+
+// identify performs worker handshake, PID handshake verifying secret and pool token and offering
+// the given compression codec is used unless custom handshake is set. Returns codec accepted by
+// the worker, custom handshake never enables the compression. Panic of the handshake is returned
+// as PanicError.
+func (s *listenerSource) identify(rl *goridge.SocketRelay, compress string) (pid int, meta map[string]string, codec string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			pid, meta, codec, err = 0, nil, "", newPanicError(r)
+		}
+	}()
+
+	if h, _ := s.handshake.Load().(HandshakeFunc); h != nil {
+		pid, meta, err = h(rl)
+		return pid, meta, "", err
+	}
+
+	token, _ := s.token.Load().(string)
+	link, err := negotiate(rl, s.secret, token, compress)
+	if link == nil {
+		return 0, nil, "", err
+	}
+
+	if err != nil {
+		return link.Pid, nil, "", err
+	}
+
+	return link.Pid, link.meta(), link.Compress, nil
+}
+
+// fail reports failed handshake of the given connection.
+func (s *listenerSource) fail(pid int, conn net.Conn, err error) {
+	if s.failed != nil {
+		s.failed(pid, conn.RemoteAddr(), err)
+	}
+}
+
+// listener returns current listener.
+func (s *listenerSource) listener() net.Listener {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.wrapped != nil {
+		return s.wrapped
+	}
+
+	return s.ls
+}
+
+// base returns the listener before the middleware is applied.
+func (s *listenerSource) base() net.Listener {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.ls
+}
+
+// setMiddleware wraps the listener with the middleware, nil removes the middleware.
+func (s *listenerSource) setMiddleware(mw ListenerMiddleware) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	s.middleware = mw
+	s.wrap()
+}
+
+// wrap applies the middleware to the listener, connections waiting to be passed through the
+// previous middleware are kept. Must be called under mu.
+func (s *listenerSource) wrap() {
+	var requeued []net.Conn
+	if s.inner != nil {
+		requeued = s.inner.drain()
+	}
+
+	s.wrapped, s.inner = nil, nil
+	if s.middleware == nil {
+		for _, conn := range requeued {
+			_ = conn.Close()
+		}
+
+		return
+	}
+
+	s.inner = &innerListener{Listener: s.ls, requeued: requeued}
+	s.wrapped = s.middleware(s.inner)
+}
+
+// requeue passes connection accepted by the listener which has been wrapped by the middleware
+// since the accept started to the middleware, returns false when connection must be served.
+func (s *listenerSource) requeue(ls net.Listener, conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.wrapped == nil || ls != s.ls {
+		return false
+	}
+
+	s.inner.push(conn)
+	return true
+}
+
+// swap replaces the listener, previous listener is returned open and must be closed by the
+// caller. Closed source keeps the listener.
+func (s *listenerSource) swap(ls net.Listener) (net.Listener, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, ErrFactoryClosed
+	}
+
+	prev := s.ls
+	s.ls = ls
+
+	if s.middleware != nil {
+		s.wrap()
+	}
+
+	return prev, nil
+}
+
+// Close closes underlying listener and connections in the handshake.
+func (s *listenerSource) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	err := s.ls.Close()
+	if s.wrapped != nil {
+		// releases resources of the middleware, original listener is closed already
+		_ = s.wrapped.Close()
+		for _, conn := range s.inner.drain() {
+			_ = conn.Close()
+		}
+	}
+	s.mu.Unlock()
+
+	s.handshaking.Range(func(conn, _ interface{}) bool {
+		_ = conn.(net.Conn).Close()
+		return true
+	})
+
+	return err
+}
+
+// isClosed returns true once source has been closed.
+func (s *listenerSource) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.closed
+}
+
+// keepAliveOff marks keep-alive disabled by SetKeepAlive.
+const keepAliveOff = time.Duration(-1)
+
+// setKeepAlive enables keep-alive on TCP connections, including connections of the listener
+// middleware embedding them, zero period disables it. Other connections are ignored.
+func setKeepAlive(conn net.Conn, d time.Duration) error {
+	tcp, ok := conn.(interface {
+		SetKeepAlive(keepalive bool) error
+		SetKeepAlivePeriod(d time.Duration) error
+	})
+	if !ok {
+		return nil
+	}
+
+	if d == 0 {
+		return tcp.SetKeepAlive(false)
+	}
+
+	if err := tcp.SetKeepAlive(true); err != nil {
+		return err
+	}
+
+	return tcp.SetKeepAlivePeriod(d)
+}
+
+// setSocketBuffers sets kernel buffer sizes of TCP and unix socket connections, zero size is
+// not changed. Other connections are ignored.
+func setSocketBuffers(conn net.Conn, send, recv int) error {
+	sc, ok := conn.(interface {
+		SetWriteBuffer(bytes int) error
+		SetReadBuffer(bytes int) error
+	})
+	if !ok {
+		return nil
+	}
+
+	if send != 0 {
+		if err := sc.SetWriteBuffer(send); err != nil {
+			return err
+		}
+	}
+
+	if recv != 0 {
+		return sc.SetReadBuffer(recv)
+	}
+
+	return nil
 }
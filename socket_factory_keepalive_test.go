@@ -0,0 +1,174 @@
+// +build linux darwin freebsd
+
+package roadrunner
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func Test_SetKeepAlive(t *testing.T) {
+	ls, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ls.Close()
+
+	client, err := net.Dial("tcp", ls.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	conn, err := ls.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// listener enables keep-alive by default
+	assert.NoError(t, conn.(*net.TCPConn).SetKeepAlive(false))
+	assert.Equal(t, 0, soKeepAlive(t, conn))
+	assert.NoError(t, setKeepAlive(conn, time.Second*5))
+	assert.NotEqual(t, 0, soKeepAlive(t, conn))
+
+	// zero period disables keep-alive
+	assert.NoError(t, setKeepAlive(conn, 0))
+	assert.Equal(t, 0, soKeepAlive(t, conn))
+}
+
+func Test_SetKeepAlive_Unix(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	// non tcp connections are ignored
+	assert.NoError(t, setKeepAlive(server, time.Second*5))
+}
+
+func Test_Factory_SetKeepAlive(t *testing.T) {
+	ls, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := NewSocketFactory(ls, time.Second)
+	defer f.Close()
+
+	// system default until set
+	assert.Equal(t, int64(0), f.sources[0].(*listenerSource).keepAlive)
+
+	f.SetKeepAlive(0)
+	assert.Equal(t, int64(keepAliveOff), f.sources[0].(*listenerSource).keepAlive)
+
+	f.SetKeepAlive(time.Second * 5)
+	assert.Equal(t, int64(time.Second*5), f.sources[0].(*listenerSource).keepAlive)
+
+	go func() {
+		rl, err := dialRelay("tcp", ls.Addr().String(), pidCommand{Pid: 1001})
+		if assert.NoError(t, err) {
+			time.Sleep(time.Millisecond * 100)
+			assert.NoError(t, rl.Close())
+		}
+	}()
+
+	rl, err := f.findRelay(context.Background(), 0, syntheticWorker(1001), time.Second)
+	assert.NoError(t, err)
+	assert.NotNil(t, rl)
+}
+
+func Test_SetSocketBuffers(t *testing.T) {
+	ls, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ls.Close()
+
+	client, err := net.Dial("tcp", ls.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	conn, err := ls.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	assert.NoError(t, setSocketBuffers(conn, 256*1024, 128*1024))
+
+	// kernel might round or double the requested size
+	assert.True(t, sockoptInt(t, conn, syscall.SO_SNDBUF) >= 256*1024)
+	assert.True(t, sockoptInt(t, conn, syscall.SO_RCVBUF) >= 128*1024)
+
+	server, pipe := net.Pipe()
+	defer server.Close()
+	defer pipe.Close()
+
+	// connections without kernel buffers are ignored
+	assert.NoError(t, setSocketBuffers(server, 1024, 1024))
+}
+
+func Test_Factory_SetSocketBuffers(t *testing.T) {
+	ls, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := NewSocketFactory(ls, time.Second)
+	defer f.Close()
+
+	f.SetSocketBuffers(256*1024, 128*1024)
+	assert.Equal(t, int64(256*1024), f.sources[0].(*listenerSource).sendBuffer)
+	assert.Equal(t, int64(128*1024), f.sources[0].(*listenerSource).recvBuffer)
+
+	go func() {
+		rl, err := dialRelay("tcp", ls.Addr().String(), pidCommand{Pid: 1002})
+		if assert.NoError(t, err) {
+			time.Sleep(time.Millisecond * 100)
+			assert.NoError(t, rl.Close())
+		}
+	}()
+
+	rl, err := f.findRelay(context.Background(), 0, syntheticWorker(1002), time.Second)
+	assert.NoError(t, err)
+	if assert.NotNil(t, rl) {
+		conn := f.accepted(0, rl).conn.Conn
+		assert.True(t, sockoptInt(t, conn, syscall.SO_RCVBUF) >= 128*1024)
+	}
+}
+
+// soKeepAlive returns SO_KEEPALIVE option of the given TCP connection.
+func soKeepAlive(t *testing.T, conn net.Conn) int {
+	return sockoptInt(t, conn, syscall.SO_KEEPALIVE)
+}
+
+// sockoptInt returns integer socket level option of the given TCP connection.
+func sockoptInt(t *testing.T, conn net.Conn, opt int) int {
+	raw, err := conn.(*net.TCPConn).SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		value int
+		oErr  error
+	)
+
+	err = raw.Control(func(fd uintptr) {
+		value, oErr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, opt)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if oErr != nil {
+		t.Fatal(oErr)
+	}
+
+	return value
+}
@@ -1,9 +1,26 @@
 package roadrunner
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/spiral/goridge/v2"
 	"github.com/stretchr/testify/assert"
+	"io"
+	"math/big"
 	"net"
+	"os"
 	"os/exec"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 )
@@ -241,6 +258,32 @@ func Test_Tcp_Echo(t *testing.T) {
 	assert.Equal(t, "hello", res.String())
 }
 
+func Test_Tcp_Addr(t *testing.T) {
+	ls, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	f := NewSocketFactory(ls, time.Minute)
+	defer f.Close()
+
+	addr, ok := f.Addr().(*net.TCPAddr)
+	assert.True(t, ok)
+	assert.NotEqual(t, 0, addr.Port)
+	assert.Equal(t, fmt.Sprintf("tcp://127.0.0.1:%v", addr.Port), f.String())
+}
+
+func Test_Unix_Addr(t *testing.T) {
+	ls, err := net.Listen("unix", "sock.unix")
+	if err != nil {
+		t.Skip("socket is busy")
+	}
+
+	f := NewSocketFactory(ls, time.Minute)
+	defer f.Close()
+
+	assert.Equal(t, "sock.unix", f.Addr().String())
+	assert.Equal(t, "unix://sock.unix", f.String())
+}
+
 func Test_Unix_Start(t *testing.T) {
 	ls, err := net.Listen("unix", "sock.unix")
 	if err == nil {
@@ -402,136 +445,2393 @@ func Test_Unix_Echo(t *testing.T) {
 	assert.Equal(t, "hello", res.String())
 }
 
-func Benchmark_Tcp_SpawnWorker_Stop(b *testing.B) {
+func Test_Tcp_FactoryClose(t *testing.T) {
+	time.Sleep(time.Millisecond * 10) // to ensure free socket
+
 	ls, err := net.Listen("tcp", "localhost:9007")
-	if err == nil {
-		defer func() {
-			err := ls.Close()
-			if err != nil {
-				b.Errorf("error closing the listener: error %v", err)
-			}
-		}()
-	} else {
-		b.Skip("socket is busy")
+	if err != nil {
+		t.Skip("socket is busy")
 	}
 
 	f := NewSocketFactory(ls, time.Minute)
-	for n := 0; n < b.N; n++ {
-		cmd := exec.Command("php", "tests/client.php", "echo", "tcp")
+	assert.NoError(t, f.Close())
+	assert.NoError(t, f.Close())
 
-		w, _ := f.SpawnWorker(cmd)
-		go func() {
-			if w.Wait() != nil {
-				b.Fail()
-			}
-		}()
+	cmd := exec.Command("php", "tests/client.php", "echo", "tcp")
 
-		err = w.Stop()
-		if err != nil {
-			b.Errorf("error stopping the worker: error %v", err)
-		}
-	}
+	w, err := f.SpawnWorker(cmd)
+	assert.Nil(t, w)
+	assert.Error(t, err)
+	assert.Nil(t, cmd.Process)
 }
 
-func Benchmark_Tcp_Worker_ExecEcho(b *testing.B) {
+func Test_Tcp_FactoryClose_Pending(t *testing.T) {
+	time.Sleep(time.Millisecond * 10) // to ensure free socket
+
 	ls, err := net.Listen("tcp", "localhost:9007")
-	if err == nil {
-		defer func() {
-			err := ls.Close()
-			if err != nil {
-				b.Errorf("error closing the listener: error %v", err)
-			}
-		}()
-	} else {
-		b.Skip("socket is busy")
+	if err != nil {
+		t.Skip("socket is busy")
 	}
 
-	cmd := exec.Command("php", "tests/client.php", "echo", "tcp")
+	f := NewSocketFactory(ls, time.Minute)
 
-	w, _ := NewSocketFactory(ls, time.Minute).SpawnWorker(cmd)
+	pid := -1
+	w := &Worker{Pid: &pid, waitDone: make(chan interface{})}
+
+	done := make(chan error)
 	go func() {
-		err := w.Wait()
-		if err != nil {
-			b.Errorf("error waiting: %v", err)
-		}
+		_, err := f.findRelay(context.Background(), 0, w, time.Minute)
+		done <- err
 	}()
+
+	time.Sleep(time.Millisecond * 10)
+	assert.NoError(t, f.Close())
+
+	select {
+	case err := <-done:
+		assert.Error(t, err)
+		assert.Equal(t, "factory closed", err.Error())
+	case <-time.After(time.Second):
+		t.Error("pending relay has not been released")
+	}
+}
+
+func Test_Tcp_ListenerClosed(t *testing.T) {
+	ls, err := net.Listen("tcp", "localhost:9007")
+	if err != nil {
+		t.Skip("socket is busy")
+	}
+
+	f := NewSocketFactory(ls, time.Minute)
+	defer f.Close()
+
+	// listener closed behind the factory
+	assert.NoError(t, ls.Close())
+	for i := 0; i < 100 && atomic.LoadInt32(&f.stopped[0]) == 0; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	cmd := exec.Command("php", "tests/client.php", "echo", "tcp")
+
+	start := time.Now()
+	w, err := f.SpawnWorker(cmd)
+	assert.Nil(t, w)
+	assert.Equal(t, ErrFactoryClosed, err)
+	assert.True(t, time.Since(start) < time.Second)
+
+	// process is not started
+	assert.Nil(t, cmd.Process)
+}
+
+func Test_Tcp_FactoryEvents(t *testing.T) {
+	time.Sleep(time.Millisecond * 10) // to ensure free socket
+
+	ls, err := net.Listen("tcp", "localhost:9007")
+	if err != nil {
+		t.Skip("socket is busy")
+	}
+
+	f := NewSocketFactory(ls, time.Minute)
 	defer func() {
-		err = w.Stop()
+		err := f.Close()
 		if err != nil {
-			b.Errorf("error stopping the worker: error %v", err)
+			t.Errorf("error closing the factory: error %v", err)
 		}
 	}()
 
-	for n := 0; n < b.N; n++ {
-		if _, err := w.Exec(&Payload{Body: []byte("hello")}); err != nil {
-			b.Fail()
+	events := make(chan FactoryEvent, 10)
+	f.AddListener(func(event FactoryEvent) {
+		events <- event
+	})
+
+	pid := -1
+	w := &Worker{Pid: &pid, waitDone: make(chan interface{})}
+
+	go func() {
+		rl, err := dialRelay("tcp", "localhost:9007", pidCommand{Pid: pid})
+		if assert.NoError(t, err) {
+			time.Sleep(time.Millisecond * 100)
+			assert.NoError(t, rl.Close())
 		}
-	}
+	}()
+
+	_, err = f.findRelay(context.Background(), 0, w, time.Second)
+	assert.NoError(t, err)
+
+	e := <-events
+	assert.Equal(t, EventRelayAssociate, e.Event)
+	assert.Equal(t, w, e.Worker)
+	assert.NoError(t, e.Error)
+
+	_, err = f.findRelay(context.Background(), 0, w, time.Millisecond)
+	assert.Error(t, err)
+
+	e = <-events
+	assert.Equal(t, EventRelayTimeout, e.Event)
+	assert.Error(t, e.Error)
+
+	close(w.waitDone)
+	_, err = f.findRelay(context.Background(), 0, w, time.Second)
+	assert.Error(t, err)
+
+	e = <-events
+	assert.Equal(t, EventRelayWorkerDead, e.Event)
+	assert.Error(t, e.Error)
 }
 
-func Benchmark_Unix_SpawnWorker_Stop(b *testing.B) {
-	ls, err := net.Listen("unix", "sock.unix")
-	if err == nil {
-		defer func() {
-			err := ls.Close()
-			if err != nil {
-				b.Errorf("error closing the listener: error %v", err)
-			}
-		}()
-	} else {
-		b.Skip("socket is busy")
+func Test_Tcp_SpawnWorkerContext_Cancel(t *testing.T) {
+	time.Sleep(time.Millisecond * 10) // to ensure free socket
+
+	ls, err := net.Listen("tcp", "localhost:9007")
+	if err != nil {
+		t.Skip("socket is busy")
 	}
 
 	f := NewSocketFactory(ls, time.Minute)
-	for n := 0; n < b.N; n++ {
-		cmd := exec.Command("php", "tests/client.php", "echo", "unix")
+	defer func() {
+		err := f.Close()
+		if err != nil {
+			t.Errorf("error closing the factory: error %v", err)
+		}
+	}()
 
-		w, _ := f.SpawnWorker(cmd)
-		go func() {
-			if w.Wait() != nil {
-				b.Fail()
-			}
-		}()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
 
-		err = w.Stop()
+	// process never connects to the factory
+	cmd := exec.Command("sleep", "10")
+
+	w, err := f.SpawnWorkerContext(ctx, cmd)
+	assert.Nil(t, w)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	// process must be killed and reaped
+	assert.Error(t, cmd.Process.Signal(syscall.Signal(0)))
+}
+
+func Test_Tcp_StartTimeout(t *testing.T) {
+	time.Sleep(time.Millisecond * 10) // to ensure free socket
+
+	ls, err := net.Listen("tcp", "localhost:9007")
+	if err != nil {
+		t.Skip("socket is busy")
+	}
+
+	f := NewSocketFactory(ls, time.Minute)
+	f.StartTimeout = time.Millisecond * 100
+	defer func() {
+		err := f.Close()
 		if err != nil {
-			b.Errorf("error stopping the worker: error %v", err)
+			t.Errorf("error closing the factory: error %v", err)
 		}
+	}()
+
+	// process never connects to the factory
+	cmd := exec.Command("sleep", "10")
+
+	w, err := f.SpawnWorker(cmd)
+	assert.Nil(t, w)
+	if assert.Error(t, err) {
+		assert.Equal(t, "worker start deadline exceeded", err.Error())
 	}
+
+	assert.Error(t, cmd.Process.Signal(syscall.Signal(0)))
 }
 
-func Benchmark_Unix_Worker_ExecEcho(b *testing.B) {
-	ls, err := net.Listen("unix", "sock.unix")
-	if err == nil {
-		defer func() {
-			err := ls.Close()
-			if err != nil {
-				b.Errorf("error closing the listener: error %v", err)
-			}
-		}()
-	} else {
-		b.Skip("socket is busy")
-	}
+func Test_Tcp_Warmup(t *testing.T) {
+	time.Sleep(time.Millisecond * 10) // to ensure free socket
 
-	cmd := exec.Command("php", "tests/client.php", "echo", "unix")
+	ls, err := net.Listen("tcp", "localhost:9007")
+	if err != nil {
+		t.Skip("socket is busy")
+	}
 
-	w, _ := NewSocketFactory(ls, time.Minute).SpawnWorker(cmd)
-	go func() {
-		err := w.Wait()
+	f := NewSocketFactory(ls, time.Minute)
+	f.WarmupPayload = []byte("warmup")
+	defer func() {
+		err := f.Close()
 		if err != nil {
-			b.Errorf("error waiting: %v", err)
+			t.Errorf("error closing the factory: error %v", err)
 		}
 	}()
+
+	w, err := f.SpawnWorker(exec.Command("php", "tests/client.php", "echo", "tcp"))
+	assert.NoError(t, err)
+	defer w.Stop()
+
+	assert.Equal(t, StateReady, w.State().Value())
+	assert.Equal(t, int64(0), w.State().NumExecs())
+
+	res, err := w.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", res.String())
+}
+
+func Test_Tcp_Warmup_Error(t *testing.T) {
+	time.Sleep(time.Millisecond * 10) // to ensure free socket
+
+	ls, err := net.Listen("tcp", "localhost:9007")
+	if err != nil {
+		t.Skip("socket is busy")
+	}
+
+	f := NewSocketFactory(ls, time.Minute)
+	f.WarmupPayload = []byte("warmup")
 	defer func() {
-		err = w.Stop()
+		err := f.Close()
 		if err != nil {
-			b.Errorf("error stopping the worker: error %v", err)
+			t.Errorf("error closing the factory: error %v", err)
 		}
 	}()
 
-	for n := 0; n < b.N; n++ {
-		if _, err := w.Exec(&Payload{Body: []byte("hello")}); err != nil {
-			b.Fail()
-		}
+	cmd := exec.Command("php", "tests/client.php", "error", "tcp")
+
+	w, err := f.SpawnWorker(cmd)
+	assert.Nil(t, w)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "warmup")
+	}
+
+	assert.Error(t, cmd.Process.Signal(syscall.Signal(0)))
+}
+
+func Test_Tcp_Bootstrap(t *testing.T) {
+	time.Sleep(time.Millisecond * 10) // to ensure free socket
+
+	ls, err := net.Listen("tcp", "localhost:9007")
+	if err != nil {
+		t.Skip("socket is busy")
+	}
+
+	f := NewSocketFactory(ls, time.Minute)
+	f.BootstrapPayload = func(id string) []byte { return []byte("config-" + id) }
+	f.WarmupPayload = []byte("warmup")
+	defer f.Close()
+
+	for i := 0; i < 3; i++ {
+		cmd := exec.Command("php", "tests/client.php", "bootstrap", "tcp")
+		newWorkerConfig(i, "").Apply(cmd)
+
+		w, err := f.SpawnWorker(cmd)
+		assert.NoError(t, err)
+
+		// bootstrap is not a task
+		assert.Equal(t, int64(0), w.State().NumExecs())
+
+		res, err := w.Exec(&Payload{Body: []byte("hello")})
+		assert.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("config-worker-%v", i), res.String())
+
+		assert.NoError(t, w.Stop())
+	}
+}
+
+func Test_Tcp_Bootstrap_Pool(t *testing.T) {
+	time.Sleep(time.Millisecond * 10) // to ensure free socket
+
+	ls, err := net.Listen("tcp", "localhost:9007")
+	if err != nil {
+		t.Skip("socket is busy")
+	}
+
+	f := NewSocketFactory(ls, time.Minute)
+	f.BootstrapPayload = func(id string) []byte { return []byte("config-" + id) }
+	defer f.Close()
+
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "bootstrap", "tcp") },
+		f,
+		Config{
+			NumWorkers:      3,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	for _, w := range p.Workers() {
+		res, err := w.Exec(&Payload{Body: []byte("hello")})
+		assert.NoError(t, err)
+		assert.Equal(t, "config-"+w.ID, res.String())
 	}
 }
+
+func Test_Tcp_ExitedBeforeConnecting(t *testing.T) {
+	time.Sleep(time.Millisecond * 10) // to ensure free socket
+
+	ls, err := net.Listen("tcp", "localhost:9007")
+	if err != nil {
+		t.Skip("socket is busy")
+	}
+
+	f := NewSocketFactory(ls, time.Minute)
+	defer f.Close()
+
+	for _, c := range []struct {
+		script, message string
+	}{
+		{"exit 3", "unable to connect to worker: worker exited before connecting (code=3)"},
+		{"echo broken >&2; exit 7", "worker exited before connecting (code=7)"},
+	} {
+		cmd := exec.Command("sh", "-c", c.script)
+
+		// exit is detected without waiting for the relay timeout
+		start := time.Now()
+		w, err := f.SpawnWorker(cmd)
+		assert.Nil(t, w)
+		assert.Less(t, int64(time.Since(start)), int64(time.Second*10))
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), c.message)
+		}
+
+		// process is reaped and no relay is expected anymore
+		assert.Error(t, cmd.Process.Signal(syscall.Signal(0)))
+
+		f.mu.Lock()
+		assert.Len(t, f.relays, 0)
+		assert.Len(t, f.expected, 0)
+		f.mu.Unlock()
+	}
+}
+
+func Test_Tcp_RelayAttempts(t *testing.T) {
+	time.Sleep(time.Millisecond * 10) // to ensure free socket
+
+	ls, err := net.Listen("tcp", "localhost:9007")
+	if err != nil {
+		t.Skip("socket is busy")
+	}
+
+	f := NewSocketFactory(ls, time.Minute)
+	f.MaxRelayAttempts = 2
+	defer func() {
+		err := f.Close()
+		if err != nil {
+			t.Errorf("error closing the factory: error %v", err)
+		}
+	}()
+
+	pid := -1
+	w := &Worker{Pid: &pid, waitDone: make(chan interface{})}
+
+	go func() {
+		// broken connection
+		rl, err := dialRelay("tcp", "localhost:9007", pidCommand{Pid: pid})
+		if assert.NoError(t, err) {
+			assert.NoError(t, rl.Close())
+		}
+
+		rl, err = dialRelay("tcp", "localhost:9007", pidCommand{Pid: pid})
+		if assert.NoError(t, err) {
+			_, _, err = rl.Receive()
+			assert.NoError(t, err)
+			assert.NoError(t, sendControl(rl, []byte(`{"pong":true}`)))
+		}
+	}()
+
+	rl, err := f.findRelay(context.Background(), 0, w, time.Second)
+	assert.NoError(t, err)
+	assert.NotNil(t, rl)
+}
+
+func Test_Tcp_RelayAttempts_Exceeded(t *testing.T) {
+	time.Sleep(time.Millisecond * 10) // to ensure free socket
+
+	ls, err := net.Listen("tcp", "localhost:9007")
+	if err != nil {
+		t.Skip("socket is busy")
+	}
+
+	f := NewSocketFactory(ls, time.Minute)
+	f.MaxRelayAttempts = 2
+	defer func() {
+		err := f.Close()
+		if err != nil {
+			t.Errorf("error closing the factory: error %v", err)
+		}
+	}()
+
+	pid := -1
+	w := &Worker{Pid: &pid, waitDone: make(chan interface{})}
+
+	go func() {
+		for i := 0; i < 2; i++ {
+			rl, err := dialRelay("tcp", "localhost:9007", pidCommand{Pid: pid})
+			if assert.NoError(t, err) {
+				assert.NoError(t, rl.Close())
+			}
+		}
+	}()
+
+	rl, err := f.findRelay(context.Background(), 0, w, time.Second)
+	assert.Error(t, err)
+	assert.Nil(t, rl)
+}
+
+func Test_Tcp_FactorySecret(t *testing.T) {
+	time.Sleep(time.Millisecond * 10) // to ensure free socket
+
+	ls, err := net.Listen("tcp", "localhost:9007")
+	if err != nil {
+		t.Skip("socket is busy")
+	}
+
+	secret := []byte("secret")
+	f := NewSocketFactoryWithSecret(ls, time.Minute, secret)
+	defer func() {
+		err := f.Close()
+		if err != nil {
+			t.Errorf("error closing the factory: error %v", err)
+		}
+	}()
+
+	pid := -1
+	w := &Worker{Pid: &pid, waitDone: make(chan interface{})}
+
+	go func() {
+		// unsigned connection must be closed by the factory
+		rl, err := dialRelay("tcp", "localhost:9007", pidCommand{Pid: pid})
+		if assert.NoError(t, err) {
+			_, _, err = rl.Receive()
+			assert.Error(t, err)
+		}
+
+		rl, err = dialRelay("tcp", "localhost:9007", pidCommand{
+			Pid:  pid,
+			Hmac: hex.EncodeToString(signPID(pid, secret)),
+		})
+		if assert.NoError(t, err) {
+			time.Sleep(time.Millisecond * 100)
+			assert.NoError(t, rl.Close())
+		}
+	}()
+
+	rl, err := f.findRelay(context.Background(), 0, w, time.Second)
+	assert.NoError(t, err)
+	assert.NotNil(t, rl)
+}
+
+func Test_Tcp_PoolToken(t *testing.T) {
+	time.Sleep(time.Millisecond * 10) // to ensure free socket
+
+	ls, err := net.Listen("tcp", "localhost:9007")
+	if err != nil {
+		t.Skip("socket is busy")
+	}
+
+	f := NewSocketFactory(ls, time.Minute)
+	f.SetPoolToken("pool-a")
+	defer func() {
+		err := f.Close()
+		if err != nil {
+			t.Errorf("error closing the factory: error %v", err)
+		}
+	}()
+
+	pid := -1
+	w := &Worker{Pid: &pid, waitDone: make(chan interface{})}
+
+	go func() {
+		// worker of another pool must be rejected
+		rl, err := dialRelay("tcp", "localhost:9007", pidCommand{Pid: pid, Token: "pool-b"})
+		if assert.NoError(t, err) {
+			_, _, err = rl.Receive()
+			assert.Error(t, err)
+		}
+
+		conn, err := net.Dial("tcp", "localhost:9007")
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		rl = goridge.NewSocketRelay(conn)
+		body, _, err := rl.Receive()
+		assert.NoError(t, err)
+		assert.Contains(t, string(body), `"token":"pool-a"`)
+
+		assert.NoError(t, sendControl(rl, pidCommand{Pid: pid, Token: "pool-a"}))
+		time.Sleep(time.Millisecond * 100)
+		assert.NoError(t, rl.Close())
+	}()
+
+	rl, err := f.findRelay(context.Background(), 0, w, time.Second)
+	assert.NoError(t, err)
+	assert.NotNil(t, rl)
+}
+
+func Test_Tcp_Handshake(t *testing.T) {
+	time.Sleep(time.Millisecond * 10) // to ensure free socket
+
+	ls, err := net.Listen("tcp", "localhost:9007")
+	if err != nil {
+		t.Skip("socket is busy")
+	}
+
+	f := NewSocketFactory(ls, time.Minute)
+	defer f.Close()
+
+	type hello struct {
+		Pid  int               `json:"pid"`
+		Meta map[string]string `json:"meta"`
+	}
+
+	f.SetHandshake(func(rl *goridge.SocketRelay) (int, map[string]string, error) {
+		data, _, err := rl.Receive()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		h := hello{}
+		if err := json.Unmarshal(data, &h); err != nil {
+			return 0, nil, err
+		}
+
+		if h.Meta["version"] == "" {
+			return h.Pid, nil, fmt.Errorf("version is missing")
+		}
+
+		return h.Pid, h.Meta, nil
+	})
+
+	pid := -1
+	w := &Worker{Pid: &pid, waitDone: make(chan interface{})}
+
+	go func() {
+		// handshake rejected
+		conn, err := net.Dial("tcp", "localhost:9007")
+		if !assert.NoError(t, err) {
+			return
+		}
+		rl := goridge.NewSocketRelay(conn)
+		assert.NoError(t, rl.Send([]byte(`{"pid":-1}`), goridge.PayloadControl))
+		_, _, err = rl.Receive()
+		assert.Error(t, err)
+
+		conn, err = net.Dial("tcp", "localhost:9007")
+		if !assert.NoError(t, err) {
+			return
+		}
+		rl = goridge.NewSocketRelay(conn)
+		assert.NoError(t, rl.Send([]byte(`{"pid":-1,"meta":{"version":"1.0"}}`), goridge.PayloadControl))
+		time.Sleep(time.Millisecond * 100)
+		assert.NoError(t, rl.Close())
+	}()
+
+	rl, err := f.findRelay(context.Background(), 0, w, time.Second)
+	assert.NoError(t, err)
+	assert.NotNil(t, rl)
+
+	ar := f.accepted(0, rl)
+	if assert.NotNil(t, ar) {
+		assert.Equal(t, map[string]string{"version": "1.0"}, ar.meta)
+	}
+}
+
+func Test_Tcp_Handshake_Default(t *testing.T) {
+	time.Sleep(time.Millisecond * 10) // to ensure free socket
+
+	ls, err := net.Listen("tcp", "localhost:9007")
+	if assert.NoError(t, err) {
+		defer ls.Close()
+	} else {
+		t.Skip("socket is busy")
+	}
+
+	f := NewSocketFactory(ls, time.Minute)
+	f.SetHandshake(nil)
+
+	cmd := exec.Command("php", "tests/client.php", "echo", "tcp")
+
+	w, err := f.SpawnWorker(cmd)
+	assert.NoError(t, err)
+	go func() {
+		assert.NoError(t, w.Wait())
+	}()
+	defer w.Stop()
+
+	assert.Nil(t, w.Snapshot().Meta)
+}
+
+func Test_Tcp_HandshakePanic(t *testing.T) {
+	time.Sleep(time.Millisecond * 10) // to ensure free socket
+
+	ls, err := net.Listen("tcp", "localhost:9007")
+	if !assert.NoError(t, err) {
+		t.Skip("socket is busy")
+	}
+
+	f := NewSocketFactory(ls, time.Minute)
+	defer f.Close()
+
+	events := make(chan FactoryEvent, 1)
+	f.AddListener(func(event FactoryEvent) {
+		if event.Event == EventPanic {
+			events <- event
+		}
+	})
+
+	f.SetHandshake(func(rl *goridge.SocketRelay) (int, map[string]string, error) {
+		var meta map[string]string
+		meta["version"] = "1.0"
+
+		return 0, meta, nil
+	})
+
+	conn, err := net.Dial("tcp", "localhost:9007")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+
+	select {
+	case event := <-events:
+		assert.IsType(t, PanicError{}, event.Error)
+	case <-time.After(time.Second):
+		t.Fatal("panic is not reported")
+	}
+
+	// offending connection is closed
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+}
+
+func Test_Tcp_FromFile(t *testing.T) {
+	time.Sleep(time.Millisecond * 10) // to ensure free socket
+
+	ls, err := net.Listen("tcp", "localhost:9007")
+	if !assert.NoError(t, err) {
+		t.Skip("socket is busy")
+	}
+
+	file, err := ls.(*net.TCPListener).File()
+	assert.NoError(t, err)
+	assert.NoError(t, ls.Close())
+
+	f, err := NewSocketFactoryFromFile(file, time.Minute)
+	assert.NoError(t, err)
+	assert.NoError(t, file.Close())
+	defer f.Close()
+
+	assert.Equal(t, "tcp://127.0.0.1:9007", f.String())
+
+	cmd := exec.Command("php", "tests/client.php", "echo", "tcp")
+
+	w, err := f.SpawnWorker(cmd)
+	assert.NoError(t, err)
+	go func() {
+		assert.NoError(t, w.Wait())
+	}()
+	defer w.Stop()
+
+	res, err := w.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", res.String())
+}
+
+func Test_Tcp_AdoptWorker(t *testing.T) {
+	time.Sleep(time.Millisecond * 10) // to ensure free socket
+
+	ls, err := net.Listen("tcp", "localhost:9007")
+	if assert.NoError(t, err) {
+		defer ls.Close()
+	} else {
+		t.Skip("socket is busy")
+	}
+
+	f := NewSocketFactory(ls, time.Minute)
+	defer f.Close()
+
+	// process started by the previous parent
+	cmd := exec.Command("sleep", "10")
+	assert.NoError(t, cmd.Start())
+	pid := cmd.Process.Pid
+
+	conn, wConn := connPair(t)
+	go func() {
+		// echo worker answering the ping
+		rl := goridge.NewSocketRelay(wConn)
+		for {
+			_, p, err := rl.Receive()
+			if err != nil {
+				return
+			}
+
+			// pid command is the only json control frame
+			if !p.HasFlag(goridge.PayloadRaw) && p.Size() != 0 {
+				_ = rl.Send([]byte(fmt.Sprintf(`{"pid":%v}`, pid)), goridge.PayloadControl)
+				continue
+			}
+
+			body, _, err := rl.Receive()
+			if err != nil {
+				return
+			}
+			_ = rl.Send(nil, goridge.PayloadControl|goridge.PayloadEmpty)
+			_ = rl.Send(body, goridge.PayloadRaw)
+		}
+	}()
+
+	w, err := f.AdoptWorker(pid, conn)
+	assert.NoError(t, err)
+	assert.Equal(t, pid, w.PID())
+	assert.Equal(t, StateReady, w.State().Value())
+
+	res, err := w.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", res.String())
+
+	assert.NoError(t, w.Kill())
+	assert.Error(t, w.Wait())
+}
+
+func Test_Tcp_AdoptWorker_WrongPid(t *testing.T) {
+	time.Sleep(time.Millisecond * 10) // to ensure free socket
+
+	ls, err := net.Listen("tcp", "localhost:9007")
+	if assert.NoError(t, err) {
+		defer ls.Close()
+	} else {
+		t.Skip("socket is busy")
+	}
+
+	f := NewSocketFactory(ls, time.Minute)
+	defer f.Close()
+
+	cmd := exec.Command("sleep", "10")
+	assert.NoError(t, cmd.Start())
+	defer cmd.Process.Kill()
+
+	conn, wConn := connPair(t)
+	go func() {
+		rl := goridge.NewSocketRelay(wConn)
+		if _, _, err := rl.Receive(); err == nil {
+			_ = rl.Send([]byte(`{"pid":1}`), goridge.PayloadControl)
+		}
+	}()
+
+	w, err := f.AdoptWorker(cmd.Process.Pid, conn)
+	assert.Error(t, err)
+	assert.Nil(t, w)
+}
+
+// connPair returns both ends of the connected TCP connection, unlike net.Pipe empty writes
+// do not block.
+func connPair(t *testing.T) (net.Conn, net.Conn) {
+	ls, err := net.Listen("tcp", "localhost:0")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer ls.Close()
+
+	conn, err := net.Dial("tcp", ls.Addr().String())
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	accepted, err := ls.Accept()
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	return accepted, conn
+}
+
+// temporaryError mimics transient accept errors such as EMFILE.
+type temporaryError struct{}
+
+func (temporaryError) Error() string   { return "too many open files" }
+func (temporaryError) Timeout() bool   { return false }
+func (temporaryError) Temporary() bool { return true }
+
+// flakyListener fails given number of accepts with temporary error.
+type flakyListener struct {
+	net.Listener
+	fails int32
+}
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	if atomic.AddInt32(&l.fails, -1) >= 0 {
+		return nil, temporaryError{}
+	}
+
+	return l.Listener.Accept()
+}
+
+func Test_Tcp_AcceptTemporaryError(t *testing.T) {
+	time.Sleep(time.Millisecond * 10) // to ensure free socket
+
+	ls, err := net.Listen("tcp", "localhost:9007")
+	if err != nil {
+		t.Skip("socket is busy")
+	}
+
+	log := &testLogger{}
+	f := NewSocketFactory(&flakyListener{Listener: ls, fails: 3}, time.Minute)
+	f.Logger = log
+	defer func() {
+		err := f.Close()
+		if err != nil {
+			t.Errorf("error closing the factory: error %v", err)
+		}
+	}()
+
+	pid := -1
+	w := &Worker{Pid: &pid, waitDone: make(chan interface{})}
+
+	go func() {
+		rl, err := dialRelay("tcp", "localhost:9007", pidCommand{Pid: pid})
+		if assert.NoError(t, err) {
+			time.Sleep(time.Millisecond * 100)
+			assert.NoError(t, rl.Close())
+		}
+	}()
+
+	rl, err := f.findRelay(context.Background(), 0, w, time.Second)
+	assert.NoError(t, err)
+	assert.NotNil(t, rl)
+	assert.Contains(t, log.Messages(), "relay accept error")
+}
+
+func Test_Tcp_MultipleListeners(t *testing.T) {
+	ls0, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	ls1, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	f := NewSocketFactoryWithListeners([]net.Listener{ls0, ls1}, time.Minute)
+	defer func() {
+		err := f.Close()
+		if err != nil {
+			t.Errorf("error closing the factory: error %v", err)
+		}
+	}()
+
+	assert.Equal(t, ls0.Addr(), f.Addr())
+	assert.Equal(t, ls1.Addr(), f.ListenerAddr(1))
+	assert.Nil(t, f.ListenerAddr(2))
+
+	pid := -1
+	w := &Worker{Pid: &pid, waitDone: make(chan interface{})}
+
+	go func() {
+		// relay of another listener must not be associated
+		rl0, err := dialRelay("tcp", ls0.Addr().String(), pidCommand{Pid: pid})
+		assert.NoError(t, err)
+		defer rl0.Close()
+
+		time.Sleep(time.Millisecond * 50)
+
+		rl1, err := dialRelay("tcp", ls1.Addr().String(), pidCommand{Pid: pid})
+		if assert.NoError(t, err) {
+			time.Sleep(time.Millisecond * 100)
+			assert.NoError(t, rl1.Close())
+		}
+	}()
+
+	rl, err := f.findRelay(context.Background(), 1, w, time.Millisecond*30)
+	assert.Error(t, err)
+	assert.Nil(t, rl)
+
+	rl, err = f.findRelay(context.Background(), 1, w, time.Second)
+	assert.NoError(t, err)
+	assert.NotNil(t, rl)
+
+	rl, err = f.findRelay(context.Background(), 0, w, time.Second)
+	assert.NoError(t, err)
+	assert.NotNil(t, rl)
+}
+
+func Test_SpawnWorkerOn_Undefined(t *testing.T) {
+	ls, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	f := NewSocketFactoryWithListeners([]net.Listener{ls}, time.Minute)
+	defer f.Close()
+
+	_, err = f.SpawnWorkerOn(1, exec.Command("php", "tests/client.php", "echo", "tcp"))
+	assert.Error(t, err)
+	assert.Equal(t, "undefined listener 1", err.Error())
+}
+
+func Test_Tcp_HandshakeFailed(t *testing.T) {
+	ls, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	log := &testLogger{}
+	f := NewSocketFactoryWithSecret(ls, time.Minute, []byte("secret"))
+	f.Logger = log
+	defer f.Close()
+
+	pid := -1
+	w := &Worker{Pid: &pid, waitDone: make(chan interface{})}
+
+	go func() {
+		// unsigned pid
+		rl, err := dialRelay("tcp", ls.Addr().String(), pidCommand{Pid: pid})
+		if assert.NoError(t, err) {
+			_, _, err = rl.Receive()
+			assert.Error(t, err)
+		}
+	}()
+
+	rl, err := f.findRelay(context.Background(), 0, w, time.Millisecond*200)
+	assert.Nil(t, rl)
+	assert.Error(t, err)
+	assert.Equal(t, "worker connected but handshake failed: invalid pid signature", err.Error())
+	assert.Contains(t, log.Messages(), "relay handshake failed")
+}
+
+func Test_Tcp_HandshakeFailed_Unknown(t *testing.T) {
+	ls, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	f := NewSocketFactory(ls, time.Minute)
+	defer f.Close()
+
+	pid := -1
+	w := &Worker{Pid: &pid, waitDone: make(chan interface{})}
+
+	go func() {
+		conn, err := net.Dial("tcp", ls.Addr().String())
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		rl := goridge.NewSocketRelay(conn)
+		_, _, err = rl.Receive()
+		assert.NoError(t, err)
+
+		// malformed handshake response
+		assert.NoError(t, rl.Send([]byte("{pid"), goridge.PayloadControl))
+	}()
+
+	rl, err := f.findRelay(context.Background(), 0, w, time.Millisecond*200)
+	assert.Nil(t, rl)
+	assert.Error(t, err)
+	assert.Equal(t, "relay timeout (1 failed handshakes)", err.Error())
+}
+
+func Test_Tcp_FactoryTLS(t *testing.T) {
+	time.Sleep(time.Millisecond * 10) // to ensure free socket
+
+	ls, err := net.Listen("tcp", "localhost:9007")
+	if err != nil {
+		t.Skip("socket is busy")
+	}
+
+	cert, pool, err := selfSignedCert()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := NewSocketFactoryWithTLS(ls, time.Millisecond*200, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})
+	defer func() {
+		err := f.Close()
+		if err != nil {
+			t.Errorf("error closing the factory: error %v", err)
+		}
+	}()
+
+	pid := -1
+	w := &Worker{Pid: &pid, waitDone: make(chan interface{})}
+
+	go func() {
+		// plain connection must be rejected once TLS handshake times out
+		rl, err := dialRelay("tcp", "localhost:9007", pidCommand{Pid: pid})
+		if err == nil {
+			_, _, err = rl.Receive()
+		}
+		assert.Error(t, err)
+
+		// client certificate is required
+		conn, err := tls.Dial("tcp", "localhost:9007", &tls.Config{RootCAs: pool})
+		if err == nil {
+			_, err = handshakeRelay(conn, pidCommand{Pid: pid})
+		}
+		assert.Error(t, err)
+
+		conn, err = tls.Dial("tcp", "localhost:9007", &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			RootCAs:      pool,
+		})
+		if assert.NoError(t, err) {
+			rl, err := handshakeRelay(conn, pidCommand{Pid: pid})
+			if assert.NoError(t, err) {
+				time.Sleep(time.Millisecond * 100)
+				assert.NoError(t, rl.Close())
+			}
+		}
+	}()
+
+	rl, err := f.findRelay(context.Background(), 0, w, time.Second*5)
+	assert.NoError(t, err)
+	assert.NotNil(t, rl)
+}
+
+// selfSignedCert generates certificate for localhost which is used both as server and client
+// certificate, returned pool trusts the certificate.
+func selfSignedCert() (tls.Certificate, *x509.CertPool, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+
+	tpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("::1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool, nil
+}
+
+// dialRelay connects to the factory and passes handshake on behalf of worker using given pid command.
+func dialRelay(network, addr string, cmd pidCommand) (*goridge.SocketRelay, error) {
+	conn, err := net.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return handshakeRelay(conn, cmd)
+}
+
+// handshakeRelay passes handshake over given connection on behalf of worker using given pid command.
+func handshakeRelay(conn net.Conn, cmd pidCommand) (*goridge.SocketRelay, error) {
+	rl := goridge.NewSocketRelay(conn)
+	if _, _, err := rl.Receive(); err != nil {
+		return nil, err
+	}
+
+	if err := sendControl(rl, cmd); err != nil {
+		return nil, err
+	}
+
+	return rl, nil
+}
+
+func Test_FromAddr_Tcp_Start(t *testing.T) {
+	time.Sleep(time.Millisecond * 10) // to ensure free socket
+
+	f, err := NewSocketFactoryFromAddr("tcp://localhost:9007", time.Minute)
+	if err != nil {
+		t.Skip("socket is busy")
+	}
+	defer func() {
+		err := f.Close()
+		if err != nil {
+			t.Errorf("error closing the factory: error %v", err)
+		}
+	}()
+
+	cmd := exec.Command("php", "tests/client.php", "echo", "tcp")
+
+	w, err := f.SpawnWorker(cmd)
+	assert.NoError(t, err)
+	assert.NotNil(t, w)
+	assert.Equal(t, "tcp", w.Transport)
+
+	go func() {
+		assert.NoError(t, w.Wait())
+	}()
+
+	err = w.Stop()
+	if err != nil {
+		t.Errorf("error stopping the worker: error %v", err)
+	}
+}
+
+func Test_FromAddr_Unix_Start(t *testing.T) {
+	f, err := NewSocketFactoryFromAddr("unix://sock.unix", time.Minute)
+	if err != nil {
+		t.Skip("socket is busy")
+	}
+
+	cmd := exec.Command("php", "tests/client.php", "echo", "unix")
+
+	w, err := f.SpawnWorker(cmd)
+	assert.NoError(t, err)
+	assert.NotNil(t, w)
+	assert.Equal(t, "unix", w.Transport)
+
+	go func() {
+		assert.NoError(t, w.Wait())
+	}()
+
+	err = w.Stop()
+	if err != nil {
+		t.Errorf("error stopping the worker: error %v", err)
+	}
+
+	assert.NoError(t, f.Close())
+	_, err = os.Stat("sock.unix")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func Test_FromAddr_Invalid(t *testing.T) {
+	f, err := NewSocketFactoryFromAddr("sock.unix", time.Minute)
+	assert.Nil(t, f)
+	assert.Error(t, err)
+
+	f, err = NewSocketFactoryFromAddr("udp://localhost:9007", time.Minute)
+	assert.Nil(t, f)
+	assert.Error(t, err)
+}
+
+func Test_FromAddr_AddrInUse(t *testing.T) {
+	time.Sleep(time.Millisecond * 10) // to ensure free socket
+
+	ls, err := net.Listen("tcp", "localhost:9007")
+	if assert.NoError(t, err) {
+		defer ls.Close()
+	} else {
+		t.Skip("socket is busy")
+	}
+
+	f, err := NewSocketFactoryFromAddr("tcp://localhost:9007", time.Minute)
+	assert.Nil(t, f)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "address already in use")
+}
+
+func Test_FromAddr_Backlog(t *testing.T) {
+	time.Sleep(time.Millisecond * 10) // to ensure free socket
+
+	f, err := NewSocketFactoryFromAddrWithBacklog("tcp://localhost:9007", time.Minute, 1024)
+	if assert.NoError(t, err) {
+		assert.NoError(t, f.Close())
+	}
+}
+
+// slowHandshake connects to the factory as worker with the given PID, handshake is responded
+// after the given delay.
+func slowHandshake(t *testing.T, addr string, pid int, delay time.Duration) net.Conn {
+	conn, err := net.Dial("tcp", addr)
+	if !assert.NoError(t, err) {
+		return nil
+	}
+
+	go func() {
+		rl := goridge.NewSocketRelay(conn)
+		if _, _, err := rl.Receive(); err != nil {
+			return
+		}
+
+		time.Sleep(delay)
+		_ = sendControl(rl, &pidCommand{Pid: pid})
+	}()
+
+	return conn
+}
+
+func Test_Tcp_AcceptConcurrency(t *testing.T) {
+	ls, err := net.Listen("tcp", "localhost:0")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	f := NewSocketFactory(ls, time.Second)
+	defer f.Close()
+
+	f.SetAcceptConcurrency(20)
+
+	// handshakes take 2s when accepted one by one
+	start := time.Now()
+	for pid := 2000; pid < 2020; pid++ {
+		if conn := slowHandshake(t, ls.Addr().String(), pid, time.Millisecond*100); conn != nil {
+			defer conn.Close()
+		}
+	}
+
+	for pid := 2000; pid < 2020; pid++ {
+		rl, err := f.findRelay(context.Background(), 0, syntheticWorker(pid), time.Second)
+		assert.NoError(t, err)
+		assert.NotNil(t, rl)
+	}
+
+	assert.True(t, time.Since(start) < time.Second)
+	assert.Equal(t, 0, f.PendingRelays())
+}
+
+func Test_Tcp_HandshakeTimeout(t *testing.T) {
+	ls, err := net.Listen("tcp", "localhost:0")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	log := &testLogger{}
+	f := NewSocketFactory(ls, time.Second)
+	f.Logger = log
+	defer f.Close()
+
+	f.SetHandshakeTimeout(time.Millisecond * 100)
+
+	// connection never responds to the handshake
+	silent, err := net.Dial("tcp", ls.Addr().String())
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer silent.Close()
+
+	if conn := slowHandshake(t, ls.Addr().String(), 2000, 0); conn != nil {
+		defer conn.Close()
+	}
+
+	rl, err := f.findRelay(context.Background(), 0, syntheticWorker(2000), time.Second)
+	assert.NoError(t, err)
+	assert.NotNil(t, rl)
+
+	// handshake command is followed by the connection close
+	srl := goridge.NewSocketRelay(silent)
+	_, _, err = srl.Receive()
+	assert.NoError(t, err)
+	_, _, err = srl.Receive()
+	assert.Error(t, err)
+
+	assert.Contains(t, log.Messages(), "relay handshake failed")
+}
+
+func Benchmark_Tcp_SpawnWorker_Stop(b *testing.B) {
+	ls, err := net.Listen("tcp", "localhost:9007")
+	if err == nil {
+		defer func() {
+			err := ls.Close()
+			if err != nil {
+				b.Errorf("error closing the listener: error %v", err)
+			}
+		}()
+	} else {
+		b.Skip("socket is busy")
+	}
+
+	f := NewSocketFactory(ls, time.Minute)
+	for n := 0; n < b.N; n++ {
+		cmd := exec.Command("php", "tests/client.php", "echo", "tcp")
+
+		w, _ := f.SpawnWorker(cmd)
+		go func() {
+			if w.Wait() != nil {
+				b.Fail()
+			}
+		}()
+
+		err = w.Stop()
+		if err != nil {
+			b.Errorf("error stopping the worker: error %v", err)
+		}
+	}
+}
+
+func Benchmark_Tcp_Worker_ExecEcho(b *testing.B) {
+	ls, err := net.Listen("tcp", "localhost:9007")
+	if err == nil {
+		defer func() {
+			err := ls.Close()
+			if err != nil {
+				b.Errorf("error closing the listener: error %v", err)
+			}
+		}()
+	} else {
+		b.Skip("socket is busy")
+	}
+
+	cmd := exec.Command("php", "tests/client.php", "echo", "tcp")
+
+	w, _ := NewSocketFactory(ls, time.Minute).SpawnWorker(cmd)
+	go func() {
+		err := w.Wait()
+		if err != nil {
+			b.Errorf("error waiting: %v", err)
+		}
+	}()
+	defer func() {
+		err = w.Stop()
+		if err != nil {
+			b.Errorf("error stopping the worker: error %v", err)
+		}
+	}()
+
+	for n := 0; n < b.N; n++ {
+		if _, err := w.Exec(&Payload{Body: []byte("hello")}); err != nil {
+			b.Fail()
+		}
+	}
+}
+
+// Benchmark_Tcp_Worker_ExecEcho_Buffers compares echo of the large payload using system default
+// and explicit socket buffer sizes.
+func Benchmark_Tcp_Worker_ExecEcho_Buffers(b *testing.B) {
+	body := make([]byte, 512*1024)
+
+	for _, size := range []int{0, 64 * 1024, 1024 * 1024} {
+		b.Run(fmt.Sprintf("buffer=%v", size), func(b *testing.B) {
+			ls, err := net.Listen("tcp", "localhost:9007")
+			if err != nil {
+				b.Skip("socket is busy")
+			}
+			defer ls.Close()
+
+			f := NewSocketFactory(ls, time.Minute)
+			f.SetSocketBuffers(size, size)
+
+			w, err := f.SpawnWorker(exec.Command("php", "tests/client.php", "echo", "tcp"))
+			if err != nil {
+				b.Fatal(err)
+			}
+			go w.Wait()
+			defer w.Stop()
+
+			b.SetBytes(int64(len(body)))
+			b.ResetTimer()
+
+			for n := 0; n < b.N; n++ {
+				if _, err := w.Exec(&Payload{Body: body}); err != nil {
+					b.Fail()
+				}
+			}
+		})
+	}
+}
+
+func Benchmark_Unix_SpawnWorker_Stop(b *testing.B) {
+	ls, err := net.Listen("unix", "sock.unix")
+	if err == nil {
+		defer func() {
+			err := ls.Close()
+			if err != nil {
+				b.Errorf("error closing the listener: error %v", err)
+			}
+		}()
+	} else {
+		b.Skip("socket is busy")
+	}
+
+	f := NewSocketFactory(ls, time.Minute)
+	for n := 0; n < b.N; n++ {
+		cmd := exec.Command("php", "tests/client.php", "echo", "unix")
+
+		w, _ := f.SpawnWorker(cmd)
+		go func() {
+			if w.Wait() != nil {
+				b.Fail()
+			}
+		}()
+
+		err = w.Stop()
+		if err != nil {
+			b.Errorf("error stopping the worker: error %v", err)
+		}
+	}
+}
+
+func Benchmark_Unix_Worker_ExecEcho(b *testing.B) {
+	ls, err := net.Listen("unix", "sock.unix")
+	if err == nil {
+		defer func() {
+			err := ls.Close()
+			if err != nil {
+				b.Errorf("error closing the listener: error %v", err)
+			}
+		}()
+	} else {
+		b.Skip("socket is busy")
+	}
+
+	cmd := exec.Command("php", "tests/client.php", "echo", "unix")
+
+	w, _ := NewSocketFactory(ls, time.Minute).SpawnWorker(cmd)
+	go func() {
+		err := w.Wait()
+		if err != nil {
+			b.Errorf("error waiting: %v", err)
+		}
+	}()
+	defer func() {
+		err = w.Stop()
+		if err != nil {
+			b.Errorf("error stopping the worker: error %v", err)
+		}
+	}()
+
+	for n := 0; n < b.N; n++ {
+		if _, err := w.Exec(&Payload{Body: []byte("hello")}); err != nil {
+			b.Fail()
+		}
+	}
+}
+
+// chanSource feeds synthetic relays to the factory.
+type chanSource struct {
+	relays chan *goridge.SocketRelay
+	pids   chan int
+	closed chan interface{}
+}
+
+func newChanSource() *chanSource {
+	return &chanSource{
+		relays: make(chan *goridge.SocketRelay),
+		pids:   make(chan int),
+		closed: make(chan interface{}),
+	}
+}
+
+func (s *chanSource) push(pid int) *goridge.SocketRelay {
+	conn, _ := net.Pipe()
+	rl := goridge.NewSocketRelay(conn)
+
+	s.relays <- rl
+	s.pids <- pid
+
+	return rl
+}
+
+func (s *chanSource) Accept() (*goridge.SocketRelay, int, error) {
+	select {
+	case rl := <-s.relays:
+		return rl, <-s.pids, nil
+	case <-s.closed:
+		return nil, 0, fmt.Errorf("source closed")
+	}
+}
+
+func (s *chanSource) Close() error {
+	close(s.closed)
+	return nil
+}
+
+// syntheticWorker creates worker with given PID without starting the process.
+func syntheticWorker(pid int) *Worker {
+	w, _ := newWorker(exec.Command("php", "tests/client.php", "echo", "tcp"))
+	w.Pid = &pid
+
+	return w
+}
+
+// testLogger records logged messages.
+type testLogger struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (l *testLogger) log(msg string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.messages = append(l.messages, msg)
+}
+
+func (l *testLogger) Messages() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return append([]string{}, l.messages...)
+}
+
+func (l *testLogger) Debug(msg string, keyvals ...interface{}) { l.log(msg) }
+func (l *testLogger) Info(msg string, keyvals ...interface{})  { l.log(msg) }
+func (l *testLogger) Warn(msg string, keyvals ...interface{})  { l.log(msg) }
+func (l *testLogger) Error(msg string, keyvals ...interface{}) { l.log(msg) }
+
+func Test_Source_Associate(t *testing.T) {
+	src := newChanSource()
+	f := NewSocketFactoryWithSource(src, time.Second)
+	defer f.Close()
+
+	log := &testLogger{}
+	f.Logger = log
+
+	w := syntheticWorker(1001)
+	go src.push(1001)
+
+	rl, err := f.findRelay(context.Background(), 0, w, time.Second)
+	assert.NoError(t, err)
+	assert.NotNil(t, rl)
+	assert.Equal(t, []string{"relay associated"}, log.Messages())
+}
+
+func Test_Source_Timeout(t *testing.T) {
+	src := newChanSource()
+	f := NewSocketFactoryWithSource(src, time.Second)
+	defer f.Close()
+
+	log := &testLogger{}
+	f.Logger = log
+
+	rl, err := f.findRelay(context.Background(), 0, syntheticWorker(1001), time.Millisecond*10)
+	assert.Nil(t, rl)
+	assert.Error(t, err)
+	assert.Equal(t, "relay timeout", err.Error())
+	assert.Equal(t, []string{"relay timeout"}, log.Messages())
+}
+
+func Test_Source_Timeout_Clock(t *testing.T) {
+	clock := newMockClock()
+
+	src := newChanSource()
+	f := NewSocketFactoryWithSource(src, time.Second)
+	f.Clock = clock
+	defer f.Close()
+
+	done := make(chan error)
+	go func() {
+		_, err := f.findRelay(context.Background(), 0, syntheticWorker(1001), time.Hour)
+		done <- err
+	}()
+
+	assert.True(t, clock.WaitTimers(1))
+	clock.Advance(time.Minute)
+
+	select {
+	case <-done:
+		t.Fatal("relay timeout before deadline")
+	case <-time.After(time.Millisecond * 10):
+	}
+
+	clock.Advance(time.Hour)
+	assert.Equal(t, "relay timeout", (<-done).Error())
+}
+
+func Test_Source_WorkerGone(t *testing.T) {
+	src := newChanSource()
+	f := NewSocketFactoryWithSource(src, time.Second)
+	defer f.Close()
+
+	log := &testLogger{}
+	f.Logger = log
+
+	w := syntheticWorker(1001)
+	close(w.waitDone)
+
+	rl, err := f.findRelay(context.Background(), 0, w, time.Second)
+	assert.Nil(t, rl)
+	assert.Error(t, err)
+	assert.IsType(t, ExitError{}, err)
+	assert.Equal(t, "worker exited before connecting (code=-1)", err.Error())
+	assert.Equal(t, []string{"worker gone during relay association"}, log.Messages())
+}
+
+func Test_Source_Close(t *testing.T) {
+	src := newChanSource()
+	f := NewSocketFactoryWithSource(src, time.Second)
+
+	assert.NoError(t, f.Close())
+
+	select {
+	case <-src.closed:
+	default:
+		t.Fatal("source is not closed")
+	}
+
+	_, err := f.SpawnWorker(exec.Command("php", "tests/client.php", "echo", "tcp"))
+	assert.Error(t, err)
+}
+
+// panicSource panics once value is sent to panics, relays are accepted from the underlying
+// chanSource otherwise.
+type panicSource struct {
+	*chanSource
+	panics chan interface{}
+}
+
+func (s *panicSource) Accept() (*goridge.SocketRelay, int, error) {
+	select {
+	case v := <-s.panics:
+		panic(v)
+	case rl := <-s.relays:
+		return rl, <-s.pids, nil
+	case <-s.closed:
+		return nil, 0, fmt.Errorf("source closed")
+	}
+}
+
+func Test_Source_Panic(t *testing.T) {
+	src := &panicSource{chanSource: newChanSource(), panics: make(chan interface{})}
+	f := NewSocketFactoryWithSource(src, time.Second)
+	defer f.Close()
+
+	log := &testLogger{}
+	f.Logger = log
+
+	events := make(chan FactoryEvent, 1)
+	f.AddListener(func(event FactoryEvent) {
+		if event.Event == EventPanic {
+			events <- event
+		}
+	})
+
+	src.panics <- "boom"
+
+	select {
+	case event := <-events:
+		assert.Nil(t, event.Worker)
+		assert.IsType(t, PanicError{}, event.Error)
+		assert.Equal(t, "panic: boom", event.Error.Error())
+		assert.NotEmpty(t, event.Error.(PanicError).Stack)
+	case <-time.After(time.Second):
+		t.Fatal("panic is not reported")
+	}
+
+	// factory keeps accepting relays
+	go src.push(1001)
+
+	rl, err := f.findRelay(context.Background(), 0, syntheticWorker(1001), time.Second)
+	assert.NoError(t, err)
+	assert.NotNil(t, rl)
+	assert.Contains(t, log.Messages(), "factory panic recovered")
+}
+
+func Test_Source_PanicAssociate(t *testing.T) {
+	src := newChanSource()
+	f := NewSocketFactoryWithSource(src, time.Second)
+	defer f.Close()
+
+	var panicked *Worker
+	f.AddListener(func(event FactoryEvent) {
+		switch event.Event {
+		case EventRelayAssociate:
+			panic("observer failure")
+		case EventPanic:
+			panicked = event.Worker
+		}
+	})
+
+	w := syntheticWorker(1001)
+	go src.push(1001)
+
+	rl, err := f.findRelay(context.Background(), 0, w, time.Second)
+	assert.Nil(t, rl)
+	assert.Error(t, err)
+	assert.Equal(t, "panic: observer failure", err.Error())
+	assert.Equal(t, w, panicked)
+}
+
+func Test_Source_Addr(t *testing.T) {
+	f := NewSocketFactoryWithSource(newChanSource(), time.Second)
+	defer f.Close()
+
+	assert.Nil(t, f.Addr())
+	assert.Equal(t, "", f.String())
+}
+
+func Test_Source_MaxPendingRelays(t *testing.T) {
+	src := newChanSource()
+	f := NewSocketFactoryWithSource(src, time.Second)
+	defer f.Close()
+
+	log := &testLogger{}
+	f.Logger = log
+	f.MaxPendingRelays = 2
+
+	src.push(1001)
+	src.push(1002)
+	src.push(1003)
+
+	time.Sleep(time.Millisecond * 10)
+	assert.Equal(t, 2, f.PendingRelays())
+	assert.Equal(t, []string{"too many pending relays, connection closed"}, log.Messages())
+
+	rl, err := f.findRelay(context.Background(), 0, syntheticWorker(1002), time.Second)
+	assert.NoError(t, err)
+	assert.NotNil(t, rl)
+	assert.Equal(t, 1, f.PendingRelays())
+
+	_, err = f.findRelay(context.Background(), 0, syntheticWorker(1003), time.Millisecond*10)
+	assert.Error(t, err)
+}
+
+func Test_Source_PendingRelayTTL(t *testing.T) {
+	src := newChanSource()
+	f := NewSocketFactoryWithSource(src, time.Second)
+	defer f.Close()
+
+	log := &testLogger{}
+	f.Logger = log
+	f.SetPendingRelayTTL(time.Millisecond * 20)
+
+	src.push(1001)
+	time.Sleep(time.Millisecond * 10)
+	assert.Equal(t, 1, f.PendingRelays())
+
+	time.Sleep(time.Millisecond * 50)
+	assert.Equal(t, 0, f.PendingRelays())
+	assert.Equal(t, []string{"relay has not been claimed, connection closed"}, log.Messages())
+
+	// relay is claimed before TTL
+	w := syntheticWorker(1002)
+	go src.push(1002)
+
+	rl, err := f.findRelay(context.Background(), 0, w, time.Second)
+	assert.NoError(t, err)
+	assert.NotNil(t, rl)
+	assert.Equal(t, 0, f.PendingRelays())
+}
+
+func Test_Source_PendingRelayTTL_Clock(t *testing.T) {
+	clock := newMockClock()
+
+	src := newChanSource()
+	f := NewSocketFactoryWithSource(src, time.Second)
+	f.Clock = clock
+	defer f.Close()
+
+	f.SetPendingRelayTTL(time.Minute)
+	assert.True(t, clock.WaitTimers(1))
+
+	src.push(1001)
+	for f.PendingRelays() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// relay is not expired until TTL elapses
+	clock.Advance(time.Second * 30)
+	assert.True(t, clock.WaitTimers(1))
+	assert.Equal(t, 1, f.PendingRelays())
+
+	clock.Advance(time.Second * 30)
+	for f.PendingRelays() != 0 {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func Test_Tcp_Hijack(t *testing.T) {
+	time.Sleep(time.Millisecond * 10) // to ensure free socket
+
+	ls, err := net.Listen("tcp", "localhost:9007")
+	if assert.NoError(t, err) {
+		defer ls.Close()
+	} else {
+		t.Skip("socket is busy")
+	}
+
+	cmd := exec.Command("php", "tests/client.php", "echo", "tcp")
+
+	w, err := NewSocketFactory(ls, time.Minute).SpawnWorker(cmd)
+	assert.NoError(t, err)
+
+	conn, err := w.Hijack()
+	assert.NoError(t, err)
+	assert.True(t, w.Hijacked())
+
+	_, err = w.Exec(&Payload{Body: []byte("hello")})
+	assert.Error(t, err)
+
+	_, err = w.Hijack()
+	assert.Error(t, err)
+
+	// connection is owned by the caller
+	rl := goridge.NewSocketRelay(conn)
+	assert.NoError(t, sendControl(rl, []byte(nil)))
+	assert.NoError(t, rl.Send([]byte("hello"), goridge.PayloadRaw))
+
+	_, _, err = rl.Receive()
+	assert.NoError(t, err)
+
+	data, _, err := rl.Receive()
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	assert.NoError(t, conn.Close())
+	_ = w.Wait()
+	assert.NoError(t, w.Stop())
+}
+
+func Test_Source_Pending(t *testing.T) {
+	src := newChanSource()
+	f := NewSocketFactoryWithSource(src, time.Second)
+	defer f.Close()
+
+	assert.Equal(t, PendingSnapshot{}, f.Pending())
+
+	// relay without the waiting worker
+	src.push(1002)
+
+	// worker waiting for the relay
+	done := make(chan interface{})
+	go func() {
+		defer close(done)
+		_, err := f.findRelay(context.Background(), 0, syntheticWorker(1001), time.Millisecond*100)
+		assert.Error(t, err)
+	}()
+
+	time.Sleep(time.Millisecond * 20)
+	s := f.Pending()
+	assert.Equal(t, []PendingWorker{{ListenerID: 0, PID: 1001}}, s.Awaited)
+	if assert.Len(t, s.Unclaimed, 1) {
+		assert.Equal(t, 1002, s.Unclaimed[0].PID)
+		assert.False(t, s.Unclaimed[0].Since.IsZero())
+	}
+
+	<-done
+	assert.Empty(t, f.Pending().Awaited)
+	assert.Len(t, f.Pending().Unclaimed, 1)
+}
+
+func Test_Tcp_Pending_Failures(t *testing.T) {
+	ls, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	f := NewSocketFactoryWithSecret(ls, time.Minute, []byte("secret"))
+	defer f.Close()
+
+	for i := 0; i < RecentHandshakeFailures+2; i++ {
+		// unsigned pid
+		rl, err := dialRelay("tcp", ls.Addr().String(), pidCommand{Pid: 1000 + i})
+		if assert.NoError(t, err) {
+			_, _, err = rl.Receive()
+			assert.Error(t, err)
+		}
+	}
+
+	s := f.Pending()
+	assert.Equal(t, int64(RecentHandshakeFailures+2), s.NumFailures)
+	if assert.Len(t, s.Failures, RecentHandshakeFailures) {
+		// oldest failures are dropped
+		assert.Equal(t, 1002, s.Failures[0].PID)
+		assert.Equal(t, 1000+RecentHandshakeFailures+1, s.Failures[RecentHandshakeFailures-1].PID)
+		assert.Equal(t, "invalid pid signature", s.Failures[0].Error)
+		assert.NotEmpty(t, s.Failures[0].Addr)
+	}
+}
+
+func Test_Tcp_RejectUnknownPIDs(t *testing.T) {
+	ls, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	f := NewSocketFactory(ls, time.Minute)
+	f.RejectUnknownPIDs = true
+	defer f.Close()
+
+	// process has never been spawned by the factory
+	rl, err := dialRelay("tcp", ls.Addr().String(), pidCommand{Pid: 4242})
+	if assert.NoError(t, err) {
+		_, _, err = rl.Receive()
+		assert.Error(t, err)
+	}
+
+	assert.Equal(t, 0, f.PendingRelays())
+
+	s := f.Pending()
+	if assert.Len(t, s.Failures, 1) {
+		assert.Equal(t, 4242, s.Failures[0].PID)
+		assert.Equal(t, "process has not been spawned by the factory", s.Failures[0].Error)
+		assert.NotEmpty(t, s.Failures[0].Addr)
+	}
+}
+
+func Test_Tcp_RejectUnknownPIDs_Spawned(t *testing.T) {
+	time.Sleep(time.Millisecond * 10) // to ensure free socket
+
+	ls, err := net.Listen("tcp", "localhost:9007")
+	if !assert.NoError(t, err) {
+		t.Skip("socket is busy")
+	}
+
+	f := NewSocketFactory(ls, time.Minute)
+	f.RejectUnknownPIDs = true
+	defer f.Close()
+
+	w, err := f.SpawnWorker(exec.Command("php", "tests/client.php", "echo", "tcp"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer w.Kill()
+
+	res, err := w.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", res.String())
+
+	// worker is associated already
+	rl, err := dialRelay("tcp", "localhost:9007", pidCommand{Pid: *w.Pid})
+	if assert.NoError(t, err) {
+		_, _, err = rl.Receive()
+		assert.Error(t, err)
+	}
+
+	assert.Equal(t, int64(1), f.Pending().NumFailures)
+}
+
+func Test_Tcp_PauseAccept(t *testing.T) {
+	ls, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	f := NewSocketFactory(ls, time.Minute)
+	f.PausedRelayHold = time.Second
+	defer f.Close()
+
+	f.PauseAccept()
+	assert.True(t, f.AcceptPaused())
+
+	go func() {
+		rl, err := dialRelay("tcp", ls.Addr().String(), pidCommand{Pid: 1001})
+		if assert.NoError(t, err) {
+			time.Sleep(time.Millisecond * 200)
+			assert.NoError(t, rl.Close())
+		}
+	}()
+
+	// relay is held
+	_, err = f.findRelay(context.Background(), 0, syntheticWorker(1001), time.Millisecond*50)
+	assert.Error(t, err)
+
+	f.ResumeAccept()
+	assert.False(t, f.AcceptPaused())
+
+	rl, err := f.findRelay(context.Background(), 0, syntheticWorker(1001), time.Second)
+	assert.NoError(t, err)
+	assert.NotNil(t, rl)
+}
+
+func Test_Tcp_PauseAccept_Close(t *testing.T) {
+	ls, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	f := NewSocketFactory(ls, time.Minute)
+	defer f.Close()
+
+	log := &testLogger{}
+	f.Logger = log
+	f.PauseAccept()
+
+	// relay is closed right away
+	rl, err := dialRelay("tcp", ls.Addr().String(), pidCommand{Pid: 1001})
+	if assert.NoError(t, err) {
+		_, _, err = rl.Receive()
+		assert.Error(t, err)
+	}
+	assert.Equal(t, []string{"relay accepted while accepting is paused, connection closed"}, log.Messages())
+	assert.Equal(t, 0, f.PendingRelays())
+
+	// listener is still bound
+	f.ResumeAccept()
+	go func() {
+		rl, err := dialRelay("tcp", ls.Addr().String(), pidCommand{Pid: 1001})
+		if assert.NoError(t, err) {
+			time.Sleep(time.Millisecond * 100)
+			assert.NoError(t, rl.Close())
+		}
+	}()
+
+	rl, err = f.findRelay(context.Background(), 0, syntheticWorker(1001), time.Second)
+	assert.NoError(t, err)
+	assert.NotNil(t, rl)
+}
+
+// waitOpenConns waits up to 5 seconds until factory has n open connections, returns the last
+// count.
+func waitOpenConns(f *SocketFactory, n int) int {
+	deadline := time.Now().Add(time.Second * 5)
+	for f.OpenConns() != n && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	return f.OpenConns()
+}
+
+func Test_Tcp_OpenConns(t *testing.T) {
+	ls, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	f := NewSocketFactory(ls, time.Second)
+	defer f.Close()
+
+	f.SetAcceptConcurrency(20)
+	f.SetHandshakeTimeout(time.Millisecond * 100)
+	assert.Equal(t, 0, f.OpenConns())
+
+	// failed and stalled handshakes
+	var conns []net.Conn
+	for i := 0; i < 10; i++ {
+		conn, err := net.Dial("tcp", ls.Addr().String())
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+
+		rl := goridge.NewSocketRelay(conn)
+		_, _, err = rl.Receive()
+		assert.NoError(t, err)
+		assert.NoError(t, rl.Send([]byte("{pid"), goridge.PayloadControl))
+
+		silent, err := net.Dial("tcp", ls.Addr().String())
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+
+		conns = append(conns, conn, silent)
+	}
+	defer func() {
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+	}()
+
+	deadline := time.Now().Add(time.Second * 5)
+	for f.Pending().NumFailures != 20 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+	}
+	assert.Equal(t, int64(20), f.Pending().NumFailures)
+	assert.Equal(t, 0, waitOpenConns(f, 0))
+
+	// relays which are never claimed
+	f.SetPendingRelayTTL(time.Millisecond * 300)
+	for i := 0; i < 10; i++ {
+		rl, err := dialRelay("tcp", ls.Addr().String(), pidCommand{Pid: 3000 + i})
+		if assert.NoError(t, err) {
+			defer rl.Close()
+		}
+	}
+
+	assert.Equal(t, 10, waitOpenConns(f, 10))
+	assert.Equal(t, 0, waitOpenConns(f, 0))
+	assert.Equal(t, 0, f.PendingRelays())
+}
+
+func Test_Tcp_OpenConns_Duplicate(t *testing.T) {
+	ls, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	f := NewSocketFactory(ls, time.Second)
+	defer f.Close()
+
+	// second relay of the worker is closed once worker is associated
+	for i := 0; i < 2; i++ {
+		rl, err := dialRelay("tcp", ls.Addr().String(), pidCommand{Pid: 4000})
+		if assert.NoError(t, err) {
+			defer rl.Close()
+		}
+	}
+
+	deadline := time.Now().Add(time.Second * 5)
+	for f.PendingRelays() != 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+	}
+	assert.Equal(t, 2, f.PendingRelays())
+
+	rl, err := f.findRelay(context.Background(), 0, syntheticWorker(4000), time.Second)
+	assert.NoError(t, err)
+	assert.NotNil(t, rl)
+
+	assert.Equal(t, 1, waitOpenConns(f, 1))
+	assert.Equal(t, 0, f.PendingRelays())
+
+	_ = f.accepted(0, rl)
+	assert.NoError(t, rl.Close())
+	assert.Equal(t, 0, f.OpenConns())
+}
+
+func Test_Tcp_OpenConns_Close(t *testing.T) {
+	ls, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	f := NewSocketFactory(ls, time.Second)
+	f.SetAcceptConcurrency(2)
+	f.SetHandshakeTimeout(0)
+
+	// handshake never completes
+	silent, err := net.Dial("tcp", ls.Addr().String())
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer silent.Close()
+
+	// relay waiting for the association
+	rl, err := dialRelay("tcp", ls.Addr().String(), pidCommand{Pid: 5000})
+	if assert.NoError(t, err) {
+		defer rl.Close()
+	}
+
+	assert.Equal(t, 2, waitOpenConns(f, 2))
+
+	assert.NoError(t, f.Close())
+	assert.Equal(t, 0, waitOpenConns(f, 0))
+}
+
+func Test_Tcp_OpenConns_Workers(t *testing.T) {
+	time.Sleep(time.Millisecond * 10) // to ensure free socket
+
+	ls, err := net.Listen("tcp", "localhost:9007")
+	if err != nil {
+		t.Skip("socket is busy")
+	}
+
+	f := NewSocketFactory(ls, time.Second)
+	defer f.Close()
+
+	for i := 0; i < 10; i++ {
+		// never connects
+		_, err := f.SpawnWorker(exec.Command("php", "tests/failboot.php"))
+		assert.Error(t, err)
+
+		// dies during the task
+		w, err := f.SpawnWorker(exec.Command("php", "tests/client.php", "broken", "tcp"))
+		if assert.NoError(t, err) {
+			_, err = w.Exec(&Payload{Body: []byte("hello")})
+			assert.Error(t, err)
+			_ = w.Wait()
+		}
+
+		// stopped
+		w, err = f.SpawnWorker(exec.Command("php", "tests/client.php", "echo", "tcp"))
+		if assert.NoError(t, err) {
+			assert.NoError(t, w.Stop())
+		}
+	}
+
+	assert.Equal(t, 0, waitOpenConns(f, 0))
+}
+
+// countingListener counts accepted connections.
+type countingListener struct {
+	net.Listener
+	accepted int64
+	closed   int32
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		atomic.AddInt64(&l.accepted, 1)
+	}
+
+	return conn, err
+}
+
+func (l *countingListener) Close() error {
+	atomic.StoreInt32(&l.closed, 1)
+	return l.Listener.Close()
+}
+
+func Test_Tcp_ListenerMiddleware(t *testing.T) {
+	time.Sleep(time.Millisecond * 10) // to ensure free socket
+
+	ls, err := net.Listen("tcp", "localhost:9007")
+	if err != nil {
+		t.Skip("socket is busy")
+	}
+
+	f := NewSocketFactory(ls, time.Minute)
+	defer f.Close()
+
+	var wrapped []*countingListener
+	f.SetListenerMiddleware(func(ls net.Listener) net.Listener {
+		cl := &countingListener{Listener: ls}
+		wrapped = append(wrapped, cl)
+		return cl
+	})
+	assert.Len(t, wrapped, 1)
+
+	w, err := f.SpawnWorker(exec.Command("php", "tests/client.php", "echo", "tcp"))
+	assert.NoError(t, err)
+	defer w.Stop()
+
+	res, err := w.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", res.String())
+	assert.Equal(t, int64(1), atomic.LoadInt64(&wrapped[0].accepted))
+
+	// swapped listener is wrapped as well
+	nls, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	assert.NoError(t, f.SwapListener(nls, true))
+	assert.Len(t, wrapped, 2)
+	assert.Equal(t, nls.Addr(), f.Addr())
+
+	rl, err := dialRelay("tcp", nls.Addr().String(), pidCommand{Pid: 3000})
+	assert.NoError(t, err)
+	defer rl.Close()
+
+	assert.Equal(t, int64(1), atomic.LoadInt64(&wrapped[1].accepted))
+
+	assert.NoError(t, f.Close())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&wrapped[1].closed))
+}
+
+func Test_MaxConnsPerIP_Disabled(t *testing.T) {
+	ls, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ls.Close()
+
+	assert.Equal(t, ls, MaxConnsPerIP(0)(ls))
+	assert.Equal(t, ls, MaxConnsPerIP(-1)(ls))
+}
+
+func Test_Tcp_MaxConnsPerIP(t *testing.T) {
+	ls, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	f := NewSocketFactory(ls, time.Second)
+	defer f.Close()
+
+	// acceptors waiting on the listener pass connections through the middleware set afterwards
+	f.SetAcceptConcurrency(4)
+	f.SetListenerMiddleware(MaxConnsPerIP(2))
+
+	// accepted connections receive the handshake request, excess ones are closed
+	dial := func() (net.Conn, error) {
+		conn, err := net.Dial("tcp", ls.Addr().String())
+		if err != nil {
+			return nil, err
+		}
+
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+		if _, _, err := goridge.NewSocketRelay(conn).Receive(); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+
+		return conn, nil
+	}
+
+	first, err := dial()
+	assert.NoError(t, err)
+	second, err := dial()
+	assert.NoError(t, err)
+	defer second.Close()
+
+	_, err = dial()
+	assert.Error(t, err, "excess connection must be closed")
+	assert.Equal(t, 2, f.OpenConns())
+
+	// closed connection releases the slot once handshake fails
+	assert.NoError(t, first.Close())
+	assert.Equal(t, 1, waitOpenConns(f, 1))
+
+	third, err := dial()
+	assert.NoError(t, err)
+	defer third.Close()
+
+	assert.Equal(t, 2, f.OpenConns())
+}
+
+func Test_Tcp_SwapListener(t *testing.T) {
+	time.Sleep(time.Millisecond * 10) // to ensure free socket
+
+	ls, err := net.Listen("tcp", "localhost:9007")
+	if err != nil {
+		t.Skip("socket is busy")
+	}
+
+	f := NewSocketFactory(ls, time.Minute)
+	defer f.Close()
+
+	w, err := f.SpawnWorker(exec.Command("php", "tests/client.php", "echo", "tcp"))
+	assert.NoError(t, err)
+	defer w.Stop()
+
+	uls, err := net.Listen("unix", "sock.unix")
+	if err != nil {
+		t.Skip("socket is busy")
+	}
+
+	assert.NoError(t, f.SwapListener(uls, true))
+	assert.Equal(t, "unix://sock.unix", f.String())
+
+	// previous listener is closed
+	_, err = net.Dial("tcp", "localhost:9007")
+	assert.Error(t, err)
+
+	// worker associated before the swap keeps serving
+	res, err := w.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", res.String())
+	assert.Equal(t, "tcp", w.Transport)
+
+	// new workers connect to the new listener
+	nw, err := f.SpawnWorker(exec.Command("php", "tests/client.php", "echo", "unix"))
+	assert.NoError(t, err)
+	defer nw.Stop()
+
+	res, err = nw.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", res.String())
+	assert.Equal(t, "unix", nw.Transport)
+
+	res, err = w.Exec(&Payload{Body: []byte("world")})
+	assert.NoError(t, err)
+	assert.Equal(t, "world", res.String())
+}
+
+func Test_Tcp_SwapListener_KeepPrevious(t *testing.T) {
+	time.Sleep(time.Millisecond * 10) // to ensure free socket
+
+	uls, err := net.Listen("unix", "sock.unix")
+	if err != nil {
+		t.Skip("socket is busy")
+	}
+
+	f := NewSocketFactory(uls, time.Millisecond*500)
+	defer f.Close()
+
+	ls, err := net.Listen("tcp", "localhost:9007")
+	if err != nil {
+		t.Skip("socket is busy")
+	}
+
+	assert.NoError(t, f.SwapListener(ls, false))
+	defer uls.Close()
+
+	// previous listener stays open and does not accept relays anymore
+	_, err = f.SpawnWorker(exec.Command("php", "tests/client.php", "echo", "unix"))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "relay timeout")
+
+	w, err := f.SpawnWorker(exec.Command("php", "tests/client.php", "echo", "tcp"))
+	assert.NoError(t, err)
+	defer w.Stop()
+
+	res, err := w.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", res.String())
+
+	// socket file belongs to the previous listener
+	assert.NoError(t, f.Close())
+	_, err = os.Stat("sock.unix")
+	assert.NoError(t, err)
+}
+
+func Test_Tcp_SwapListener_Errors(t *testing.T) {
+	f := NewSocketFactoryWithSource(newChanSource(), time.Second)
+	defer f.Close()
+
+	ls, err := net.Listen("tcp", "localhost:0")
+	assert.NoError(t, err)
+	defer ls.Close()
+
+	err = f.SwapListener(ls, false)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "not supported by custom relay sources")
+
+	f = NewSocketFactory(ls, time.Second)
+	assert.NoError(t, f.Close())
+
+	nls, err := net.Listen("tcp", "localhost:0")
+	assert.NoError(t, err)
+	defer nls.Close()
+
+	assert.Equal(t, ErrFactoryClosed, f.SwapListener(nls, false))
+}
@@ -0,0 +1,183 @@
+// +build !windows
+
+package roadrunner
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_FromUnixAddr_Permissions(t *testing.T) {
+	f, err := NewSocketFactoryFromUnixAddr("unix://perm.sock", time.Minute, SocketPermissions{
+		Mode:  0600,
+		Chown: true,
+		UID:   os.Getuid(),
+		GID:   -1,
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	info, err := os.Stat("perm.sock")
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	assert.NoError(t, f.Close())
+	_, err = os.Stat("perm.sock")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func Test_FromUnixAddr_Invalid(t *testing.T) {
+	f, err := NewSocketFactoryFromUnixAddr("tcp://localhost:9007", time.Minute, SocketPermissions{})
+	assert.Nil(t, f)
+	assert.Error(t, err)
+}
+
+func Test_FromAddr_Unix_StaleSocket(t *testing.T) {
+	ls, err := net.Listen("unix", "stale.sock")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// socket file of the crashed process
+	ls.(*net.UnixListener).SetUnlinkOnClose(false)
+	assert.NoError(t, ls.Close())
+
+	f, err := NewSocketFactoryFromAddr("unix://stale.sock", time.Minute)
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+}
+
+func Test_FromAddr_Unix_InUse(t *testing.T) {
+	ls, err := net.Listen("unix", "busy.sock")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer ls.Close()
+
+	f, err := NewSocketFactoryFromAddr("unix://busy.sock", time.Minute)
+	assert.Nil(t, f)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "socket is in use")
+
+	_, err = os.Stat("busy.sock")
+	assert.NoError(t, err)
+}
+
+func Test_FromAddr_Unix_NotSocket(t *testing.T) {
+	assert.NoError(t, ioutil.WriteFile("file.sock", []byte("data"), 0644))
+	defer os.Remove("file.sock")
+
+	f, err := NewSocketFactoryFromAddr("unix://file.sock", time.Minute)
+	assert.Nil(t, f)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "file is not a socket")
+}
+
+// waitFile waits up to a second for the file to exist.
+func waitFile(name string) error {
+	deadline := time.Now().Add(time.Second)
+	for {
+		_, err := os.Stat(name)
+		if err == nil || time.Now().After(deadline) {
+			return err
+		}
+
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+// waitMessage waits up to a second for the logger to receive given message.
+func waitMessage(log *testLogger, msg string) bool {
+	deadline := time.Now().Add(time.Second)
+	for {
+		for _, m := range log.Messages() {
+			if m == msg {
+				return true
+			}
+		}
+
+		if time.Now().After(deadline) {
+			return false
+		}
+
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func Test_WatchSocketFile(t *testing.T) {
+	f, err := NewSocketFactoryFromUnixAddr("unix://watch.sock", time.Minute, SocketPermissions{Mode: 0600})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	log := &testLogger{}
+	f.Logger = log
+	assert.NoError(t, f.WatchSocketFile(time.Millisecond*10))
+
+	// removed by the tmp cleaner
+	assert.NoError(t, os.Remove("watch.sock"))
+	if !assert.NoError(t, waitFile("watch.sock")) {
+		return
+	}
+	assert.True(t, waitMessage(log, "relay socket file removed, listener re-bound"))
+
+	info, err := os.Stat("watch.sock")
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	go func() {
+		rl, err := dialRelay("unix", "watch.sock", pidCommand{Pid: 1001})
+		if assert.NoError(t, err) {
+			time.Sleep(time.Millisecond * 100)
+			assert.NoError(t, rl.Close())
+		}
+	}()
+
+	rl, err := f.findRelay(context.Background(), 0, syntheticWorker(1001), time.Second)
+	assert.NoError(t, err)
+	assert.NotNil(t, rl)
+
+	assert.NoError(t, f.Close())
+	_, err = os.Stat("watch.sock")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func Test_WatchSocketFile_Unbindable(t *testing.T) {
+	assert.NoError(t, os.MkdirAll("watch-dir", 0755))
+	defer os.RemoveAll("watch-dir")
+
+	f, err := NewSocketFactoryFromAddr("unix://watch-dir/rr.sock", time.Minute)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer f.Close()
+
+	log := &testLogger{}
+	f.Logger = log
+	assert.NoError(t, f.WatchSocketFile(time.Millisecond*10))
+
+	// directory is gone, listener can not be bound
+	assert.NoError(t, os.RemoveAll("watch-dir"))
+	assert.True(t, waitMessage(log, "relay socket file removed, unable to re-bind listener, workers can not connect"))
+
+	// listener is restored along with the directory
+	assert.NoError(t, os.MkdirAll("watch-dir", 0755))
+	assert.NoError(t, waitFile("watch-dir/rr.sock"))
+	assert.True(t, waitMessage(log, "relay socket file removed, listener re-bound"))
+}
+
+func Test_WatchSocketFile_NoSocketFile(t *testing.T) {
+	f, err := NewSocketFactoryFromAddr("tcp://localhost:9007", time.Minute)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer f.Close()
+
+	assert.Error(t, f.WatchSocketFile(time.Millisecond*10))
+}
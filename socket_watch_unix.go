@@ -0,0 +1,115 @@
+// +build !windows
+
+package roadrunner
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"net"
+	"os"
+	"time"
+)
+
+// WatchSocketFile checks every interval that the unix socket file created by the factory still
+// exists. Once the file is removed (for example by the tmp cleaner) listener is bound again at the
+// same path with the original permissions and backlog, connected workers are not affected. Failure
+// to bind is logged as error and retried on the next check, new workers can not connect until the
+// listener is restored. Returns error for factories which did not create the socket file. Watcher
+// stops on Close.
+func (f *SocketFactory) WatchSocketFile(interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("socket watch interval must be positive")
+	}
+
+	if f.sockFile == "" {
+		return fmt.Errorf("factory has no socket file to watch")
+	}
+
+	if _, ok := f.sources[0].(*listenerSource); !ok {
+		return fmt.Errorf("socket file watch is not supported by custom relay sources")
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return ErrFactoryClosed
+	}
+
+	if !f.watching {
+		f.watching = true
+		go f.watchSocket(interval)
+	}
+
+	return nil
+}
+
+// watchSocket re-binds the listener every time socket file disappears, until factory is closed.
+func (f *SocketFactory) watchSocket(interval time.Duration) {
+	failing := false
+	for {
+		select {
+		case <-f.clock().After(interval):
+		case <-f.done:
+			return
+		}
+
+		if _, err := os.Stat(f.sockFile); !os.IsNotExist(err) {
+			continue
+		}
+
+		if err := f.rebindSocket(); err != nil {
+			if err == ErrFactoryClosed {
+				return
+			}
+
+			if !failing {
+				f.logger().Error(
+					"relay socket file removed, unable to re-bind listener, workers can not connect",
+					"path", f.sockFile,
+					"error", err,
+				)
+			}
+
+			failing = true
+			continue
+		}
+
+		failing = false
+		f.logger().Warn("relay socket file removed, listener re-bound", "path", f.sockFile)
+	}
+}
+
+// rebindSocket binds new listener at the socket file path and replaces the listener of the factory
+// with it.
+func (f *SocketFactory) rebindSocket() error {
+	ls, err := net.Listen("unix", f.sockFile)
+	if err != nil {
+		return err
+	}
+
+	if f.sockBacklog != 0 {
+		if err := setBacklog(ls, f.sockBacklog); err != nil {
+			_ = ls.Close()
+			return errors.Wrap(err, "listener backlog")
+		}
+	}
+
+	if err := f.sockPerm.apply(f.sockFile); err != nil {
+		_ = ls.Close()
+		return errors.Wrap(err, "socket permissions")
+	}
+
+	prev, err := f.sources[0].(*listenerSource).swap(ls)
+	if err != nil {
+		_ = ls.Close()
+		return err
+	}
+
+	// path belongs to the new listener now
+	if ul, ok := prev.(*net.UnixListener); ok {
+		ul.SetUnlinkOnClose(false)
+	}
+
+	return prev.Close()
+}
@@ -0,0 +1,13 @@
+// +build windows
+
+package roadrunner
+
+import (
+	"fmt"
+	"time"
+)
+
+// WatchSocketFile is not supported on windows.
+func (f *SocketFactory) WatchSocketFile(interval time.Duration) error {
+	return fmt.Errorf("socket file watch is not supported on windows")
+}
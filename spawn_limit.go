@@ -0,0 +1,85 @@
+package roadrunner
+
+import "sync"
+
+// spawnLimit bounds number of worker processes being started by all factories of the process.
+var spawnLimit = newSpawnLimiter()
+
+// SetGlobalSpawnLimit limits number of worker processes being started (forked) at the same time
+// by all factories and pools of the process, 0 for unlimited (default). Prevents correlated
+// mass respawns of multiple pools from overloading the host. Limit applies to the process start
+// only, worker boot and handshake are not limited.
+func SetGlobalSpawnLimit(n int) {
+	spawnLimit.setLimit(n)
+}
+
+// spawnLimiter is a resizable semaphore of the process starts.
+type spawnLimiter struct {
+	mu sync.Mutex
+
+	// signaled once process start completes or limit changes
+	cond *sync.Cond
+
+	// max number of processes started at once, 0 for unlimited
+	limit int
+
+	// number of processes being started
+	active int
+
+	// max number of processes started at once since the last limit change
+	peak int
+}
+
+// newSpawnLimiter creates unlimited spawn limiter.
+func newSpawnLimiter() *spawnLimiter {
+	l := &spawnLimiter{}
+	l.cond = sync.NewCond(&l.mu)
+
+	return l
+}
+
+// setLimit changes the limit, waiting starts are released if the limit has increased.
+func (l *spawnLimiter) setLimit(n int) {
+	if n < 0 {
+		n = 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.limit = n
+	l.peak = l.active
+	l.cond.Broadcast()
+}
+
+// acquire waits until process can be started, must be followed by release.
+func (l *spawnLimiter) acquire() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for l.limit != 0 && l.active >= l.limit {
+		l.cond.Wait()
+	}
+
+	l.active++
+	if l.active > l.peak {
+		l.peak = l.active
+	}
+}
+
+// release completes the process start.
+func (l *spawnLimiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.active--
+	l.cond.Signal()
+}
+
+// maxActive returns max number of processes started at once since the last limit change.
+func (l *spawnLimiter) maxActive() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.peak
+}
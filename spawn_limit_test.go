@@ -0,0 +1,83 @@
+package roadrunner
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os/exec"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_SpawnLimit_Factories(t *testing.T) {
+	SetGlobalSpawnLimit(2)
+	defer SetGlobalSpawnLimit(0)
+
+	factories := []Factory{NewPipeFactory(), NewPipeFactory()}
+
+	mu := sync.Mutex{}
+	workers := make([]*Worker, 0)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(f Factory) {
+			defer wg.Done()
+
+			w, err := f.SpawnWorker(exec.Command("php", "tests/client.php", "echo", "pipes"))
+			assert.NoError(t, err)
+			if err != nil {
+				return
+			}
+
+			mu.Lock()
+			workers = append(workers, w)
+			mu.Unlock()
+		}(factories[i%2])
+	}
+	wg.Wait()
+
+	assert.Len(t, workers, 10)
+	assert.LessOrEqual(t, spawnLimit.maxActive(), 2)
+
+	for _, w := range workers {
+		go w.Wait()
+		assert.NoError(t, w.Stop())
+	}
+}
+
+func Test_SpawnLimiter_Wait(t *testing.T) {
+	l := newSpawnLimiter()
+	l.setLimit(1)
+	l.acquire()
+
+	acquired := make(chan interface{})
+	go func() {
+		l.acquire()
+		close(acquired)
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("limit exceeded")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	l.release()
+	<-acquired
+	assert.Equal(t, 1, l.maxActive())
+
+	// waiting start is released once limit is removed
+	released := make(chan interface{})
+	go func() {
+		l.acquire()
+		close(released)
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	l.setLimit(0)
+	<-released
+
+	l.release()
+	l.release()
+	assert.Equal(t, 0, l.active)
+}
@@ -29,9 +29,24 @@ class Worker
     // Send as response context to request worker termination
     public const STOP = '{"stop":true}';
 
+    // Version of the worker protocol, reported to the server on request
+    public const PROTOCOL_VERSION = '2.0.0';
+
     /** @var Relay */
     private $relay;
 
+    /** @var bool */
+    private $async = false;
+
+    /** @var bool */
+    private $bootstrapping = false;
+
+    /** @var string|null */
+    private $bootstrap;
+
+    /** @var callable[] */
+    private $controls = [];
+
     /**
      * @param Relay $relay
      */
@@ -70,9 +85,50 @@ class Worker
             return new \Error((string)$body);
         }
 
+        if ($this->bootstrapping) {
+            // one-time worker configuration, acknowledged and kept for the worker
+            $this->bootstrapping = false;
+            $this->bootstrap = $body;
+            $this->relay->send('{"ack":true}', Relay::PAYLOAD_CONTROL);
+
+            return $this->receive($header);
+        }
+
+        if ($this->async) {
+            // async task is acknowledged once received, response is sent as usual
+            $this->async = false;
+            $this->relay->send('{"ack":true}', Relay::PAYLOAD_CONTROL);
+        }
+
         return $body;
     }
 
+    /**
+     * Register handler of the control command sent by the server (profiling, cache flush), handler
+     * receives command arguments and returns the response. Thrown exception is sent as error.
+     * Commands must be registered before the first receive call to be advertised to the server.
+     *
+     * Example:
+     * $worker->registerControl('flush', function (string $args): string { return 'flushed'; });
+     *
+     * @param string   $command
+     * @param callable $handler
+     */
+    public function registerControl(string $command, callable $handler): void
+    {
+        $this->controls[$command] = $handler;
+    }
+
+    /**
+     * Configuration sent by the server once worker is connected, null when server sent none.
+     *
+     * @return string|null
+     */
+    public function getBootstrap(): ?string
+    {
+        return $this->bootstrap;
+    }
+
     /**
      * Respond to the server with result of task execution and execution context.
      *
@@ -159,8 +215,38 @@ class Worker
 
         // PID negotiation (socket connections only)
         if (!empty($p['pid'])) {
+            $this->relay->send($this->pidCommand(), Relay::PAYLOAD_CONTROL);
+        }
+
+        // relay probe (socket connections only)
+        if (!empty($p['ping'])) {
+            $this->relay->send('{"pong":true}', Relay::PAYLOAD_CONTROL);
+        }
+
+        // relay resync, sentinel is echoed back unchanged
+        if (!empty($p['resync'])) {
+            $this->relay->send($body, Relay::PAYLOAD_CONTROL);
+        }
+
+        // async task, acknowledged once task body is received
+        if (!empty($p['async'])) {
+            $this->async = true;
+        }
+
+        // control command, arguments follow as the separate frame
+        if (!empty($p['control'])) {
+            $this->handleCommand((string)$p['control']);
+        }
+
+        // worker configuration, acknowledged once configuration body is received
+        if (!empty($p['bootstrap'])) {
+            $this->bootstrapping = true;
+        }
+
+        // protocol version negotiation
+        if (!empty($p['version'])) {
             $this->relay->send(
-                sprintf('{"pid":%s}', getmypid()),
+                json_encode(['version' => self::PROTOCOL_VERSION]),
                 Relay::PAYLOAD_CONTROL
             );
         }
@@ -175,4 +261,57 @@ class Worker
 
         return true;
     }
+
+    /**
+     * Executes registered control command and sends the response.
+     *
+     * @param string $command
+     *
+     * @throws GoridgeException
+     */
+    private function handleCommand(string $command): void
+    {
+        $args = (string)$this->relay->receiveSync($flags);
+
+        if (!isset($this->controls[$command])) {
+            $this->error(sprintf('undefined control command `%s`', $command));
+            return;
+        }
+
+        try {
+            $result = (string)call_user_func($this->controls[$command], $args);
+        } catch (\Throwable $e) {
+            $this->error((string)$e->getMessage());
+            return;
+        }
+
+        $this->relay->send($result, Relay::PAYLOAD_RAW);
+    }
+
+    /**
+     * Creates PID negotiation response, PID is signed when RR_RELAY_SECRET is provided. Pool token
+     * configured via RR_POOL_TOKEN is echoed back to let server reject connections meant for other pools.
+     *
+     * @return string
+     */
+    private function pidCommand(): string
+    {
+        $command = ['pid' => getmypid()];
+
+        $secret = getenv('RR_RELAY_SECRET');
+        if (!empty($secret)) {
+            $command['hmac'] = hash_hmac('sha256', (string)getmypid(), $secret);
+        }
+
+        $token = getenv('RR_POOL_TOKEN');
+        if (!empty($token)) {
+            $command['token'] = $token;
+        }
+
+        if (!empty($this->controls)) {
+            $command['controls'] = array_keys($this->controls);
+        }
+
+        return json_encode($command);
+    }
 }
@@ -3,6 +3,7 @@ package roadrunner
 import (
 	"fmt"
 	"sync/atomic"
+	"time"
 )
 
 // State represents worker status and updated time.
@@ -15,6 +16,9 @@ type State interface {
 	// NumJobs shows how many times worker was invoked
 	NumExecs() int64
 
+	// LastUsed returns time of the last worker execution, zero time if worker was never invoked.
+	LastUsed() time.Time
+
 	// IsActive returns true if worker not Inactive or Stopped
 	IsActive() bool
 }
@@ -45,6 +49,13 @@ const (
 type state struct {
 	value    int64
 	numExecs int64
+	lastUsed int64
+
+	// start of the current task in unix nanoseconds, 0 when not working
+	taskStarted int64
+
+	// notified about every state change, holds func(from, to int64)
+	observer atomic.Value
 }
 
 func newState(value int64) *state {
@@ -76,6 +87,26 @@ func (s *state) NumExecs() int64 {
 	return atomic.LoadInt64(&s.numExecs)
 }
 
+// LastUsed returns time of the last registered worker exec.
+func (s *state) LastUsed() time.Time {
+	lastUsed := atomic.LoadInt64(&s.lastUsed)
+	if lastUsed == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, lastUsed)
+}
+
+// TaskStarted returns start time of the current task, zero time when not working.
+func (s *state) TaskStarted() time.Time {
+	started := atomic.LoadInt64(&s.taskStarted)
+	if started == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, started)
+}
+
 // Value state returns state value
 func (s *state) Value() int64 {
 	return atomic.LoadInt64(&s.value)
@@ -89,10 +120,25 @@ func (s *state) IsActive() bool {
 
 // change state value (status)
 func (s *state) set(value int64) {
-	atomic.StoreInt64(&s.value, value)
+	if value == StateWorking {
+		atomic.CompareAndSwapInt64(&s.taskStarted, 0, time.Now().UnixNano())
+	} else {
+		atomic.StoreInt64(&s.taskStarted, 0)
+	}
+
+	prev := atomic.SwapInt64(&s.value, value)
+	if f, _ := s.observer.Load().(func(from, to int64)); f != nil && prev != value {
+		f(prev, value)
+	}
+}
+
+// observe attaches function invoked synchronously on every state change.
+func (s *state) observe(f func(from, to int64)) {
+	s.observer.Store(f)
 }
 
 // register new execution atomically
 func (s *state) registerExec() {
 	atomic.AddInt64(&s.numExecs, 1)
+	atomic.StoreInt64(&s.lastUsed, time.Now().UnixNano())
 }
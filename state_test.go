@@ -24,3 +24,28 @@ func Test_IsActive(t *testing.T) {
 	assert.False(t, newState(StateStopped).IsActive())
 	assert.False(t, newState(StateErrored).IsActive())
 }
+
+func Test_LastUsed(t *testing.T) {
+	st := newState(StateReady)
+	assert.True(t, st.LastUsed().IsZero())
+
+	st.registerExec()
+	assert.Equal(t, int64(1), st.NumExecs())
+	assert.False(t, st.LastUsed().IsZero())
+}
+
+func Test_TaskStarted(t *testing.T) {
+	st := newState(StateReady)
+	assert.True(t, st.TaskStarted().IsZero())
+
+	st.set(StateWorking)
+	started := st.TaskStarted()
+	assert.False(t, started.IsZero())
+
+	// repeated state keeps the start of the task
+	st.set(StateWorking)
+	assert.Equal(t, started, st.TaskStarted())
+
+	st.set(StateReady)
+	assert.True(t, st.TaskStarted().IsZero())
+}
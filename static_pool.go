@@ -1,9 +1,13 @@
 package roadrunner
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"github.com/pkg/errors"
+	"math/rand"
 	"os/exec"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -16,96 +20,284 @@ const (
 
 // StaticPool controls worker creation, destruction and task routing. Pool uses fixed amount of workers.
 type StaticPool struct {
+	// worker lifecycle shared with DynamicPool
+	poolCore
+
 	// pool behaviour
 	cfg Config
 
 	// worker command creator
-	cmd func() *exec.Cmd
+	cmd func(cfg WorkerConfig) *exec.Cmd
+
+	// workers circular allocation buf, one extra slot is reserved for the worker reload
+	free chan *Worker
 
-	// creates and connects to workers
-	factory Factory
+	// tasks waiting for the free worker
+	queue waitQueue
 
-	// active task executions
-	tmu   *sync.Mutex
-	tasks sync.WaitGroup
+	// holds back respawn of the repeatedly failing worker slots
+	quarantine *slotQuarantine
 
-	// workers circular allocation buf
-	free chan *Worker
+	// paces replacements of the dead workers
+	respawns *respawnLimiter
+
+	// delays replacements of the crashed workers
+	backoff *respawnBackoff
+
+	// holds new tasks until MinReady workers are started
+	ready *readyGate
+
+	// initial workers being started
+	starting sync.WaitGroup
+
+	// protects free buf, worker command and number of workers which are replaced on Reset
+	muf sync.RWMutex
 
 	// number of workers expected to be dead in a buf.
 	numDead int64
 
+	// routes tasks to the first slot worker when set, see SetDebugSingleWorker
+	single int32
+
+	// one slot semaphore serializing tasks in debug single worker mode
+	serial chan interface{}
+
+	// time of the last worker release in unix nanoseconds, watched by the watchdog
+	progress int64
+
+	// memory accounted to all workers by the last sample, accessed atomically
+	totalMemory uint64
+
 	// protects state of worker list, does not affect allocation
 	muw *sync.RWMutex
 
 	// all registered workers
 	workers []*Worker
 
-	// invalid declares set of workers to be removed from the pool.
-	remove *sync.Map
+	// pool slot index of each registered worker
+	index map[*Worker]int
 
-	// pool is being destroyed
-	inDestroy int32
-	destroy   chan interface{}
+	// incremented every time worker set is replaced by Reset, protected by muw
+	gen int
 
-	// lsn is optional callback to handle worker create/destruct/error events.
-	mul sync.Mutex
-	lsn func(event int, ctx interface{})
+	// serializes worker selection and holds next round robin slot index
+	mus  sync.Mutex
+	next int
+
+	// preferred worker slots of the sticky tasks
+	sticky stickyTable
+
+	// recycled workers serving until their replacements are ready
+	swapping sync.Map
 }
 
 // NewPool creates new worker pool and task multiplexer. StaticPool will initiate with one worker.
 func NewPool(cmd func() *exec.Cmd, factory Factory, cfg Config) (*StaticPool, error) {
+	return NewPoolWithConfig(func(wc WorkerConfig) *exec.Cmd {
+		c := cmd()
+		wc.Apply(c)
+
+		return c
+	}, factory, cfg)
+}
+
+// NewPoolWithConfig creates new worker pool using command creator which receives per worker
+// configuration, including worker index within the pool.
+func NewPoolWithConfig(cmd func(cfg WorkerConfig) *exec.Cmd, factory Factory, cfg Config) (*StaticPool, error) {
 	if err := cfg.Valid(); err != nil {
 		return nil, errors.Wrap(err, "config")
 	}
 
+	if !cfg.SkipCommandCheck {
+		if err := checkCommand(cmd(newWorkerConfig(0, cfg.WorkerIDPrefix)), cfg.CommandCheckArgs); err != nil {
+			return nil, errors.Wrap(err, "command check")
+		}
+	}
+
+	minReady := cfg.MinReady
+	if minReady == 0 {
+		minReady = cfg.NumWorkers
+	}
+
 	p := &StaticPool{
 		cfg:     cfg,
 		cmd:     cmd,
-		factory: factory,
 		workers: make([]*Worker, 0, cfg.NumWorkers),
-		free:    make(chan *Worker, cfg.NumWorkers),
-		destroy: make(chan interface{}),
-		tmu:     &sync.Mutex{},
-		remove:  &sync.Map{},
+		index:   make(map[*Worker]int),
+		free:    make(chan *Worker, cfg.NumWorkers+1),
+		serial:  make(chan interface{}, 1),
 		muw:     &sync.RWMutex{},
+
+		quarantine: newSlotQuarantine(cfg.QuarantineThreshold),
+		respawns:   newRespawnLimiter(cfg.RespawnRateLimit, cfg.RespawnBurst, cfg.Clock),
+		backoff:    newRespawnBackoff(cfg.RespawnBackoff, cfg.Clock),
+		ready:      newReadyGate(int(minReady)),
+		queue:      waitQueue{aging: cfg.PriorityAging, clock: cfg.Clock},
 	}
+	p.setup(p, factory, cfg.options())
 
 	// constant number of workers simplify logic
-	for i := int64(0); i < p.cfg.NumWorkers; i++ {
-		// to test if worker ready
-		w, err := p.createWorker()
-		if err != nil {
-			p.Destroy()
-			return nil, err
-		}
+	if err := p.spawnWorkers(!cfg.StartAsync); err != nil {
+		p.Destroy()
+		return nil, err
+	}
+
+	if p.cfg.HeartbeatInterval != 0 {
+		go p.heartbeat()
+	}
+
+	if p.cfg.ProbeInterval != 0 {
+		go p.probe()
+	}
+
+	if p.cfg.MaxAge != 0 {
+		go p.sweep()
+	}
+
+	if p.cfg.RollingReplaceInterval != 0 {
+		go p.roll()
+	}
+
+	if p.cfg.MaxTotalMemory != 0 {
+		go p.trim()
+	}
+
+	if p.cfg.IdleCheckInterval != 0 {
+		go p.inspect()
+	}
 
-		p.free <- w
+	if p.cfg.Watchdog {
+		go p.watchdog()
 	}
 
 	return p, nil
 }
 
-// Listen attaches pool event controller.
-func (p *StaticPool) Listen(l func(event int, ctx interface{})) {
-	p.mul.Lock()
-	defer p.mul.Unlock()
+// NewStaticPool creates pool with given number of workers using default timeouts.
+func NewStaticPool(cmd func() *exec.Cmd, factory Factory, numWorkers int) (*StaticPool, error) {
+	cfg := Config{}
+	if err := cfg.InitDefaults(); err != nil {
+		return nil, err
+	}
+	cfg.NumWorkers = int64(numWorkers)
+
+	return NewPool(cmd, factory, cfg)
+}
 
-	p.lsn = l
+// spawnWorkers starts initial set of workers in background, waits until pool is ready when wait
+// is set. Error is returned when worker fails to start before pool is ready, once workers which
+// are being started are done.
+func (p *StaticPool) spawnWorkers(wait bool) error {
+	done := make(chan error, 1)
 
-	p.muw.Lock()
-	for _, w := range p.workers {
-		w.err.Listen(p.lsn)
+	p.starting.Add(1)
+	go func() {
+		defer p.starting.Done()
+		done <- p.startWorkers(wait)
+	}()
+
+	if !wait {
+		return nil
 	}
-	p.muw.Unlock()
+
+	select {
+	case <-p.ready.ready:
+		return nil
+	case err := <-done:
+		return err
+	}
+}
+
+// startWorkers starts initial set of workers respecting spawn concurrency and jitter. When strict,
+// spawning stops on the first error occurred before pool is ready and the error is returned once
+// workers which are being started are done. Other start failures are handled as failed worker
+// replacements.
+func (p *StaticPool) startWorkers(strict bool) error {
+	concurrency := p.cfg.SpawnConcurrency
+	if concurrency == 0 {
+		concurrency = 1
+	}
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		fail    error
+		started int
+		sem     = make(chan struct{}, concurrency)
+	)
+
+	p.muw.RLock()
+	gen := p.gen
+	p.muw.RUnlock()
+
+	for i := int64(0); i < p.cfg.NumWorkers; i++ {
+		sem <- struct{}{}
+
+		mu.Lock()
+		err := fail
+		mu.Unlock()
+
+		if err != nil || p.destroyed() {
+			<-sem
+			break
+		}
+
+		wg.Add(1)
+		go func(index int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			if p.cfg.SpawnJitter != 0 {
+				time.Sleep(time.Duration(rand.Int63n(int64(p.cfg.SpawnJitter))))
+			}
+
+			// to test if worker ready
+			w, err := p.createWorker(index)
+			if err == nil {
+				p.push(w)
+
+				mu.Lock()
+				if fail == nil {
+					started++
+					p.ready.started(started)
+				}
+				mu.Unlock()
+				return
+			}
+
+			mu.Lock()
+			if strict && !p.ready.isReady() {
+				if fail == nil {
+					fail = err
+				}
+				mu.Unlock()
+				return
+			}
+			mu.Unlock()
+
+			p.startFailed(index, gen, err)
+		}(int(i))
+	}
+
+	wg.Wait()
+
+	return fail
 }
 
-// Config returns associated pool configuration. Immutable.
+// Config returns associated pool configuration. Immutable except NumWorkers which is changed by
+// Reset and Resize.
 func (p *StaticPool) Config() Config {
+	p.muf.RLock()
+	defer p.muf.RUnlock()
+
 	return p.cfg
 }
 
-// Workers returns worker list associated with the pool.
+// Workers returns copy of the worker list associated with the pool. Returned workers are
+// managed by the pool, use them for inspection only (PID, State, Snapshot) and never Exec
+// directly, use Allocate to check out the worker for the exclusive use.
 func (p *StaticPool) Workers() (workers []*Worker) {
 	p.muw.RLock()
 	defer p.muw.RUnlock()
@@ -115,256 +307,2078 @@ func (p *StaticPool) Workers() (workers []*Worker) {
 	return workers
 }
 
-// Remove forces pool to remove specific worker.
-func (p *StaticPool) Remove(w *Worker, err error) bool {
-	if w.State().Value() != StateReady && w.State().Value() != StateWorking {
-		// unable to remove inactive worker
-		return false
+// Stats returns point in time pool statistics. Worker counts are taken under the same lock
+// as worker list, cheap enough to be called on every metrics scrape.
+func (p *StaticPool) Stats() PoolStats {
+	cadence := p.rollingCadence()
+
+	p.muw.RLock()
+	defer p.muw.RUnlock()
+
+	stats := PoolStats{
+		NumWorkers:  len(p.workers),
+		TotalExecs:  atomic.LoadInt64(&p.numExecs),
+		TotalErrors: atomic.LoadInt64(&p.numErrors),
+		PeakMemory:  atomic.LoadUint64(&p.peakMemory),
+		TotalMemory: atomic.LoadUint64(&p.totalMemory),
+		Queued:      int(atomic.LoadInt64(&p.waiting)),
+		Breaker:     p.breaker.State(),
+		Paused:      p.pause.paused(),
+		Quarantined: p.quarantine.count(),
+
+		QueuedByPriority: p.queue.depths(),
+		RespawnRate:      p.respawns.current(),
+
+		RollingReplaceCadence: cadence,
+
+		RecycleReasons: p.recycles.snapshot(),
 	}
 
-	if _, ok := p.remove.Load(w); ok {
-		return false
+	for _, w := range p.workers {
+		if w.State().Value() == StateReady && !w.IsBusy() {
+			stats.NumIdle++
+		}
 	}
+	stats.NumBusy = stats.NumWorkers - stats.NumIdle
 
-	p.remove.Store(w, err)
-	return true
+	return stats
 }
 
-// Exec one task with given payload and context, returns result or error.
-func (p *StaticPool) Exec(rqs *Payload) (rsp *Payload, err error) {
+// Healthy verifies that at least NumWorkers workers are ready or busy and pings one idle worker
+// to confirm the worker side responds. Busy workers are never pinged, ping is skipped when
+// all workers are busy. Worker failed to respond is replaced.
+func (p *StaticPool) Healthy() (bool, error) {
+	if p.destroyed() {
+		return false, ErrPoolDestroyed
+	}
+
 	p.tmu.Lock()
+	if p.destroyed() {
+		p.tmu.Unlock()
+		return false, ErrPoolDestroyed
+	}
 	p.tasks.Add(1)
 	p.tmu.Unlock()
 
 	defer p.tasks.Done()
 
-	w, err := p.allocateWorker()
-	if err != nil {
-		return nil, errors.Wrap(err, "unable to allocate worker")
+	ready, expected := 0, int(p.Config().NumWorkers)
+	for _, w := range p.Workers() {
+		if s := w.State().Value(); s == StateReady || s == StateWorking {
+			ready++
+		}
 	}
 
-	rsp, err = w.Exec(rqs)
-
-	if err != nil {
-		// soft job errors are allowed
-		if _, jobError := err.(JobError); jobError {
-			p.release(w)
-			return nil, err
-		}
+	if ready < expected {
+		return false, fmt.Errorf("only %v/%v workers ready", ready, expected)
+	}
 
-		p.discardWorker(w, err)
-		return nil, err
+	w, ok := p.tryAllocate()
+	if !ok {
+		// all workers are busy
+		return true, nil
 	}
+	p.share(w)
 
-	// worker want's to be terminated
-	if rsp.Body == nil && rsp.Context != nil && string(rsp.Context) == StopRequest {
-		p.discardWorker(w, err)
-		return p.Exec(rqs)
+	if err := w.Ping(); err != nil {
+		p.throw(EventWorkerError, WorkerError{Worker: w, Caused: err})
+		p.discardWorker(w, RecycleError, err)
+		return false, errors.Wrapf(err, "worker %v", *w.Pid)
 	}
 
 	p.release(w)
-	return rsp, nil
+	return true, nil
 }
 
-// Destroy all underlying workers (but let them to complete the task).
-func (p *StaticPool) Destroy() {
-	atomic.AddInt32(&p.inDestroy, 1)
+// execTask executes the task using workers provided by the given allocation function, task is
+// replayed on another worker when worker requests termination or retry is allowed.
+func (p *StaticPool) execTask(ctx context.Context, rqs *Payload, meta *ExecMeta, allocate func(ctx context.Context) (*Worker, error)) (rsp *Payload, err error) {
+	if p.destroyed() {
+		return nil, ErrPoolDestroyed
+	}
+
+	if p.breaker.unavailable() {
+		return p.fallback.serve(rqs, meta, ErrPoolUnavailable)
+	}
+
+	if err := p.ready.wait(ctx, p.cfg.RejectWhenNotReady, p.waitTimeout(), p.destroy); err != nil {
+		return nil, err
+	}
+
+	if err := p.pause.wait(ctx, p.cfg.RejectWhenPaused, p.destroy); err != nil {
+		return nil, err
+	}
 
 	p.tmu.Lock()
-	p.tasks.Wait()
-	close(p.destroy)
+	if p.destroyed() {
+		p.tmu.Unlock()
+		return nil, ErrPoolDestroyed
+	}
+	p.tasks.Add(1)
 	p.tmu.Unlock()
 
-	var wg sync.WaitGroup
-	for _, w := range p.Workers() {
-		wg.Add(1)
-		w.markInvalid()
-		go func(w *Worker) {
-			defer wg.Done()
-			p.destroyWorker(w, nil)
-		}(w)
+	defer p.tasks.Done()
+
+	if err := checkPayload(rqs, p.cfg.MaxPayloadSize); err != nil {
+		return nil, err
 	}
 
-	wg.Wait()
-}
+	// retries keep the ID
+	rqs = withRequestID(rqs, p.cfg.SendRequestID)
 
-// finds free worker in a given time interval. Skips dead workers.
-func (p *StaticPool) allocateWorker() (w *Worker, err error) {
-	for i := atomic.LoadInt64(&p.numDead); i >= 0; i++ {
-		// this loop is required to skip issues with dead workers still being in a ring
-		// (we know how many workers).
-		select {
-		case w = <-p.free:
-			if w.State().Value() != StateReady {
-				// found expected dead worker
-				atomic.AddInt64(&p.numDead, ^int64(0))
-				continue
-			}
+	var serial bool
+	if atomic.LoadInt32(&p.single) == 1 {
+		// tasks are executed one at a time by the first slot worker
+		if err := p.acquireSerial(ctx); err != nil {
+			return p.fallback.serve(rqs, meta, allocateError(ctx, err))
+		}
 
-			if err, remove := p.remove.Load(w); remove {
-				p.discardWorker(w, err)
+		serial = true
+		defer func() {
+			if serial {
+				<-p.serial
+			}
+		}()
 
-				// get next worker
-				i++
-				continue
+		allocateAny := allocate
+		allocate = func(ctx context.Context) (*Worker, error) {
+			w, err := allocateAny(ctx)
+			if err != nil {
+				return nil, err
 			}
 
-			return w, nil
-		case <-p.destroy:
-			return nil, fmt.Errorf("pool has been stopped")
-		default:
-			// enable timeout handler
+			return p.singleWorker(w), nil
+		}
+	}
+
+	tracer := tracerOrNop(p.cfg.Tracer)
+	for attempt := int64(0); ; attempt++ {
+		var queued time.Time
+		if p.cfg.SlowLogThreshold != 0 && p.cfg.SlowLogQueueWait {
+			queued = time.Now()
 		}
 
-		timeout := time.NewTimer(p.cfg.AllocateTimeout)
-		select {
-		case <-timeout.C:
-			return nil, fmt.Errorf("worker timeout (%s)", p.cfg.AllocateTimeout)
-		case w = <-p.free:
-			timeout.Stop()
+		_, span := tracer.StartSpan(ctx, SpanQueueWait)
+		w, err := allocate(ctx)
+		endSpan(span, err)
+		if err != nil {
+			return p.fallback.serve(rqs, meta, allocateError(ctx, err))
+		}
+		p.share(w)
 
-			if w.State().Value() != StateReady {
-				atomic.AddInt64(&p.numDead, ^int64(0))
-				continue
+		if !queued.IsZero() {
+			if wait := time.Since(queued); wait >= p.cfg.SlowLogThreshold {
+				p.logger().Warn("slow queue wait", "pid", *w.Pid, "request", rqs.RequestID, "wait", wait)
 			}
+		}
 
-			if err, remove := p.remove.Load(w); remove {
-				p.discardWorker(w, err)
-
-				// get next worker
-				i++
-				continue
+		ectx, span := tracer.StartSpan(ctx, SpanWorkerExec)
+		rsp, stop, err := p.execWorker(ctx, w, injectTrace(tracer, ectx, rqs), meta)
+		endSpan(span, err)
+		if stop {
+			if serial {
+				// replayed task waits for its turn again
+				serial = false
+				<-p.serial
 			}
 
-			return w, nil
-		case <-p.destroy:
-			timeout.Stop()
+			return p.execTask(ctx, rqs, meta, allocate)
+		}
 
-			return nil, fmt.Errorf("pool has been stopped")
+		if !retryable(rqs, err, attempt, p.cfg.MaxExecRetries) {
+			return rsp, err
 		}
-	}
 
-	return nil, fmt.Errorf("all workers are dead (%v)", p.cfg.NumWorkers)
+		p.logger().Warn("retrying task", "attempt", attempt+1, "request", rqs.RequestID, "error", err)
+		time.Sleep(retryDelay(attempt))
+		if p.destroyed() {
+			return nil, err
+		}
+	}
 }
 
-// release releases or replaces the worker.
-func (p *StaticPool) release(w *Worker) {
-	if p.cfg.MaxJobs != 0 && w.State().NumExecs() >= p.cfg.MaxJobs {
-		p.discardWorker(w, p.cfg.MaxJobs)
-		return
+// TryExec executes the task only if free worker is immediately available, acquired is false
+// when all workers are busy and task has not been executed.
+func (p *StaticPool) TryExec(rqs *Payload) (rsp *Payload, acquired bool, err error) {
+	if p.destroyed() {
+		return nil, false, ErrPoolDestroyed
 	}
 
-	if err, remove := p.remove.Load(w); remove {
-		p.discardWorker(w, err)
-		return
+	if p.breaker.unavailable() {
+		return nil, false, ErrPoolUnavailable
 	}
 
-	p.free <- w
-}
+	if !p.ready.isReady() && p.cfg.RejectWhenNotReady {
+		return nil, false, p.ready.notReady()
+	}
 
-// creates new worker using associated factory. automatically
-// adds worker to the worker list (background)
-func (p *StaticPool) createWorker() (*Worker, error) {
-	w, err := p.factory.SpawnWorker(p.cmd())
-	if err != nil {
-		return nil, err
+	if p.pause.paused() {
+		if p.cfg.RejectWhenPaused {
+			return nil, false, ErrPoolPaused
+		}
+
+		return nil, false, nil
 	}
 
-	p.mul.Lock()
-	if p.lsn != nil {
-		w.err.Listen(p.lsn)
+	p.tmu.Lock()
+	if p.destroyed() {
+		p.tmu.Unlock()
+		return nil, false, ErrPoolDestroyed
 	}
-	p.mul.Unlock()
+	p.tasks.Add(1)
+	p.tmu.Unlock()
 
-	p.throw(EventWorkerConstruct, w)
+	defer p.tasks.Done()
 
-	p.muw.Lock()
-	p.workers = append(p.workers, w)
-	p.muw.Unlock()
+	if err := checkPayload(rqs, p.cfg.MaxPayloadSize); err != nil {
+		return nil, false, err
+	}
 
-	go p.watchWorker(w)
-	return w, nil
-}
+	w, ok := p.tryAllocate()
+	if !ok {
+		return nil, false, nil
+	}
+	p.share(w)
 
-// gentry remove worker
-func (p *StaticPool) discardWorker(w *Worker, caused interface{}) {
-	w.markInvalid()
-	go p.destroyWorker(w, caused)
-}
+	rsp, stop, err := p.execWorker(context.Background(), w, rqs, nil)
+	if stop {
+		return p.TryExec(rqs)
+	}
 
-// destroyWorker destroys workers and removes it from the pool.
-func (p *StaticPool) destroyWorker(w *Worker, caused interface{}) {
-	go func() {
-		err := w.Stop()
-		if err != nil {
-			p.throw(EventWorkerError, WorkerError{Worker: w, Caused: err})
-		}
-	}()
+	return rsp, true, err
+}
 
-	select {
-	case <-w.waitDone:
-		// worker is dead
-		p.throw(EventWorkerDestruct, w)
+// succeeded clears consecutive failures and respawn attempts of the worker slot once worker has
+// completed the task.
+func (p *StaticPool) succeeded(w *Worker) {
+	if p.cfg.QuarantineThreshold == 0 && p.cfg.RespawnBackoff == nil {
+		return
+	}
 
-	case <-time.NewTimer(p.cfg.DestroyTimeout).C:
-		// failed to stop process in given time
-		if err := w.Kill(); err != nil {
-			p.throw(EventWorkerError, WorkerError{Worker: w, Caused: err})
-		}
+	p.muw.RLock()
+	index, ok := p.index[w]
+	p.muw.RUnlock()
 
-		p.throw(EventWorkerKill, w)
+	if ok {
+		p.quarantine.succeeded(index)
+		p.backoff.reset(index)
 	}
 }
 
-// watchWorker watches worker state and replaces it if worker fails.
-func (p *StaticPool) watchWorker(w *Worker) {
-	err := w.Wait()
-	p.throw(EventWorkerDead, w)
+// Allocate checks out idle worker for the exclusive use, waits for the free worker until
+// context is done or allocate timeout is reached. Pool destruction waits for allocated workers
+// to be released. Caller is responsible for keeping the worker relay stream consistent between
+// the executions and must return the worker using Release.
+func (p *StaticPool) Allocate(ctx context.Context) (*Worker, error) {
+	if p.destroyed() {
+		return nil, ErrPoolDestroyed
+	}
 
-	// detaching
-	p.muw.Lock()
-	for i, wc := range p.workers {
-		if wc == w {
-			p.workers = append(p.workers[:i], p.workers[i+1:]...)
-			p.remove.Delete(w)
-			break
-		}
+	if p.breaker.unavailable() {
+		return nil, ErrPoolUnavailable
 	}
-	p.muw.Unlock()
 
-	// registering a dead worker
-	atomic.AddInt64(&p.numDead, 1)
+	if err := p.ready.wait(ctx, p.cfg.RejectWhenNotReady, p.waitTimeout(), p.destroy); err != nil {
+		return nil, err
+	}
 
-	// worker have died unexpectedly, pool should attempt to replace it with alive version safely
-	if err != nil {
-		p.throw(EventWorkerError, WorkerError{Worker: w, Caused: err})
+	if err := p.pause.wait(ctx, p.cfg.RejectWhenPaused, p.destroy); err != nil {
+		return nil, err
 	}
 
-	if !p.destroyed() {
-		nw, err := p.createWorker()
-		if err == nil {
-			p.free <- nw
-			return
-		}
+	p.tmu.Lock()
+	if p.destroyed() {
+		p.tmu.Unlock()
+		return nil, ErrPoolDestroyed
+	}
+	p.tasks.Add(1)
+	p.tmu.Unlock()
 
-		// possible situation when major error causes all PHP scripts to die (for example dead DB)
-		if len(p.Workers()) == 0 {
-			p.throw(EventPoolError, err)
-		} else {
-			p.throw(EventWorkerError, WorkerError{Worker: w, Caused: err})
-		}
+	w, err := p.allocateWorker(ctx)
+	if err != nil {
+		p.tasks.Done()
+		return nil, allocateError(ctx, err)
 	}
+
+	p.allocated.add(w)
+	return p.share(w), nil
 }
 
-func (p *StaticPool) destroyed() bool {
-	return atomic.LoadInt32(&p.inDestroy) != 0
+// allocateError describes failed worker allocation, context error is returned as is to let the
+// callers compare it with context.Canceled and context.DeadlineExceeded.
+func allocateError(ctx context.Context, err error) error {
+	if err == ctx.Err() {
+		return err
+	}
+
+	return errors.Wrap(err, "unable to allocate worker")
 }
 
-// throw invokes event handler if any.
-func (p *StaticPool) throw(event int, ctx interface{}) {
-	p.mul.Lock()
-	if p.lsn != nil {
-		p.lsn(event, ctx)
+// Release returns allocated worker to the pool, broken worker is destroyed and replaced. Workers
+// which are not allocated or have been released already are ignored.
+func (p *StaticPool) Release(w *Worker, broken bool) {
+	if !p.allocated.remove(w) {
+		p.logger().Warn("released worker is not allocated", "pid", *w.Pid)
+		return
+	}
+
+	defer p.tasks.Done()
+
+	if w.Hijacked() {
+		// hijacked worker must not be replaced on death
+		p.retired.Store(w, true)
+		go p.replaceHijacked(w)
+		return
+	}
+
+	if broken || w.State().Value() != StateReady {
+		p.recycleWorker(w, RecycleError, fmt.Errorf("worker released as broken"))
+		return
+	}
+
+	p.release(w)
+}
+
+// SetCommand replaces the command used to spawn new workers, including replacements of the
+// recycled, dead and reloaded workers. Running workers keep running the old command until they
+// are recycled, combine with ReloadAll to roll the new command out.
+func (p *StaticPool) SetCommand(cmd func(cfg WorkerConfig) *exec.Cmd) {
+	p.muf.Lock()
+	defer p.muf.Unlock()
+
+	p.cmd = cmd
+}
+
+// command returns the command used to spawn new workers.
+func (p *StaticPool) command() func(cfg WorkerConfig) *exec.Cmd {
+	p.muf.RLock()
+	defer p.muf.RUnlock()
+
+	return p.cmd
+}
+
+// spawnReplacement creates worker taking the slot of the given one.
+func (p *StaticPool) spawnReplacement(w *Worker) (*Worker, error) {
+	p.muw.RLock()
+	index := p.index[w]
+	p.muw.RUnlock()
+
+	return p.createWorker(index)
+}
+
+// replaceHijacked spawns replacement of the hijacked worker, hijacked worker is not stopped and
+// leaves the pool once its process completes.
+func (p *StaticPool) replaceHijacked(w *Worker) {
+	if p.destroyed() {
+		return
+	}
+
+	nw, err := p.spawnReplacement(w)
+	if err != nil {
+		// replaced once hijacked worker dies
+		p.retired.Delete(w)
+		p.logger().Error("unable to replace hijacked worker", "pid", *w.Pid, "error", err)
+		p.throw(EventWorkerError, WorkerError{Worker: w, Caused: err})
+		return
+	}
+
+	p.push(nw)
+}
+
+// recycleIdle recycles the worker if it's waiting in the free list, returns false when worker
+// is busy or has left the pool.
+func (p *StaticPool) recycleIdle(w *Worker, reason RecycleReason, err error) bool {
+	if !p.takeIdle(w) {
+		return false
+	}
+
+	p.recycleReleased(p.share(w), reason, err)
+	return true
+}
+
+// takeIdle takes the worker out of the free list, returns false when worker is busy (multiplexed
+// worker with tasks in flight included) or has left the pool.
+func (p *StaticPool) takeIdle(w *Worker) bool {
+	if !p.takeFree(w) {
+		return false
+	}
+
+	if w.IsBusy() {
+		// multiplexed worker with tasks in flight
+		p.push(w)
+		return false
+	}
+
+	return true
+}
+
+// takeFree takes the worker out of the free list, returns false when worker is not in the free
+// list or has left the pool.
+func (p *StaticPool) takeFree(w *Worker) bool {
+	free := p.freeChan()
+	for i := len(free); i > 0; i-- {
+		var wc *Worker
+		select {
+		case wc = <-free:
+			if wc == nil {
+				// free buf has been replaced
+				return false
+			}
+		default:
+			return false
+		}
+
+		if wc != w {
+			p.push(wc)
+			continue
+		}
+
+		if w.State().Value() != StateReady {
+			// found expected dead worker
+			atomic.AddInt64(&p.numDead, ^int64(0))
+			return false
+		}
+
+		return true
+	}
+
+	return false
+}
+
+// Detach takes idle worker with the given PID out of the pool and passes it to the caller, for
+// example to debug the misbehaving worker using crafted payloads. Replacement is spawned to keep
+// the pool capacity. Detached worker never receives pool tasks and is not recycled, replaced,
+// reported or destroyed by the pool, caller owns the worker and must Stop or Kill it once done.
+// Busy worker can not be detached.
+func (p *StaticPool) Detach(pid int) (*Worker, error) {
+	if p.destroyed() {
+		return nil, ErrPoolDestroyed
+	}
+
+	p.reload.Lock()
+	defer p.reload.Unlock()
+
+	var w *Worker
+	for _, wc := range p.Workers() {
+		if *wc.Pid == pid {
+			w = wc
+			break
+		}
+	}
+
+	if w == nil {
+		return nil, fmt.Errorf("no worker with pid %v", pid)
+	}
+
+	if !p.takeIdle(w) {
+		return nil, fmt.Errorf("worker is not idle (%s)", w.State().String())
+	}
+
+	nw, err := p.spawnReplacement(w)
+	if err != nil {
+		p.push(w)
+		return nil, err
+	}
+
+	p.detached.Store(w, true)
+
+	p.muw.Lock()
+	delete(p.index, w)
+	for i, wc := range p.workers {
+		if wc == w {
+			p.workers = append(p.workers[:i], p.workers[i+1:]...)
+			break
+		}
+	}
+	p.muw.Unlock()
+
+	p.events.detach(w)
+	w.state.observe(func(from, to int64) {})
+
+	p.push(nw)
+	return w, nil
+}
+
+// Reset replaces all pool workers with the new set of given size created using the new command.
+// New workers start serving once all of them are ready, old workers complete their tasks and
+// are destroyed. Pool keeps serving with the old workers when new set fails to start.
+func (p *StaticPool) Reset(cmd func(cfg WorkerConfig) *exec.Cmd, numWorkers int) error {
+	if numWorkers <= 0 {
+		return fmt.Errorf("pool.NumWorkers must be set")
+	}
+
+	p.reload.Lock()
+	defer p.reload.Unlock()
+
+	// pool is not destroyed until reset is complete
+	p.tmu.Lock()
+	if p.destroyed() {
+		p.tmu.Unlock()
+		return ErrPoolDestroyed
+	}
+	p.tasks.Add(1)
+	p.tmu.Unlock()
+
+	defer p.tasks.Done()
+
+	// workers being started in background are replaced along with the others
+	p.starting.Wait()
+
+	workers := make([]*Worker, 0, numWorkers)
+	for i := 0; i < numWorkers; i++ {
+		w, err := p.spawnWorker(cmd, i)
+		if err != nil {
+			// workers are not registered yet
+			for _, w := range workers {
+				w.markInvalid()
+				go p.destroyWorker(w, err)
+			}
+
+			return errors.Wrap(err, "reset")
+		}
+
+		workers = append(workers, w)
+	}
+
+	// previous workers must be destroyed once released and not replaced on death
+	p.muw.Lock()
+	previous := append([]*Worker{}, p.workers...)
+	for _, w := range previous {
+		p.retired.Store(w, true)
+		p.recycles.mark(w, RecycleReload)
+		p.remove.Store(w, fmt.Errorf("pool reset"))
+	}
+	p.gen++
+	p.muw.Unlock()
+
+	// previous workers might still be returned to the new buf while being destroyed
+	free := make(chan *Worker, numWorkers+len(previous)+1)
+	for i, w := range workers {
+		p.register(w, i)
+	}
+
+	p.mus.Lock()
+	p.muf.Lock()
+	old := p.free
+	p.free, p.cmd = free, cmd
+	p.cfg.NumWorkers = int64(numWorkers)
+	p.next = 0
+	p.muf.Unlock()
+	p.mus.Unlock()
+
+	// waiting tasks are served by the new workers
+	for _, w := range workers {
+		p.push(w)
+	}
+
+	// destroying idle workers
+	for drained := false; !drained; {
+		select {
+		case w := <-old:
+			if w.State().Value() != StateReady {
+				// found expected dead worker
+				atomic.AddInt64(&p.numDead, ^int64(0))
+				continue
+			}
+
+			p.discardWorker(w, RecycleReload, fmt.Errorf("pool reset"))
+		default:
+			drained = true
+		}
+	}
+	close(old)
+
+	return nil
+}
+
+// Resize grows or shrinks the pool to the given number of workers, remaining workers keep
+// serving. Added workers join the rotation once all of them are ready, pool keeps its size when
+// they fail to start. Workers of the removed slots are retired: idle workers right away and busy
+// workers once they complete their tasks, in-flight tasks are never killed. Resize waits up to
+// DestroyTimeout for the retired workers to exit and returns an error when some of them are still
+// busy. EventPoolResize is thrown with the ResizeReport once pool is resized.
+func (p *StaticPool) Resize(newSize int) error {
+	if newSize <= 0 {
+		return fmt.Errorf("pool.NumWorkers must be set")
+	}
+
+	p.reload.Lock()
+	defer p.reload.Unlock()
+
+	// pool is not destroyed until resize is complete
+	p.tmu.Lock()
+	if p.destroyed() {
+		p.tmu.Unlock()
+		return ErrPoolDestroyed
+	}
+	p.tasks.Add(1)
+	p.tmu.Unlock()
+
+	defer p.tasks.Done()
+
+	// workers being started in background belong to the current size
+	p.starting.Wait()
+
+	size := int(p.Config().NumWorkers)
+	if newSize == size {
+		return nil
+	}
+
+	report := ResizeReport{Size: newSize}
+	running := 0
+	if newSize > size {
+		if err := p.grow(size, newSize); err != nil {
+			return errors.Wrap(err, "resize")
+		}
+
+		report.Added = newSize - size
+	} else {
+		retired := p.shrink(newSize)
+		report.Removed = len(retired)
+		running = p.awaitRetired(retired)
+	}
+
+	p.logger().Info("pool resized", "size", report.Size, "added", report.Added, "removed", report.Removed)
+	p.throw(EventPoolResize, report)
+
+	if running != 0 {
+		return fmt.Errorf("resize: %v retired workers are still busy after %s", running, p.cfg.DestroyTimeout)
+	}
+
+	return nil
+}
+
+// grow spawns workers of the slots from size to newSize and adds them to the rotation once all of
+// them are ready. Must be called under reload lock.
+func (p *StaticPool) grow(size, newSize int) error {
+	cmd := p.command()
+
+	workers := make([]*Worker, 0, newSize-size)
+	for i := size; i < newSize; i++ {
+		w, err := p.spawnWorker(cmd, i)
+		if err != nil {
+			// workers are not registered yet
+			for _, w := range workers {
+				w.markInvalid()
+				go p.destroyWorker(w, err)
+			}
+
+			return err
+		}
+
+		workers = append(workers, w)
+	}
+
+	// free workers are moved to the larger buf
+	p.mus.Lock()
+	p.muf.Lock()
+	old := p.free
+	p.free = make(chan *Worker, cap(old)+len(workers))
+	for drained := false; !drained; {
+		select {
+		case w := <-old:
+			p.free <- w
+		default:
+			drained = true
+		}
+	}
+	p.cfg.NumWorkers = int64(newSize)
+	p.muf.Unlock()
+	p.mus.Unlock()
+	close(old)
+
+	for i, w := range workers {
+		p.register(w, size+i)
+		p.push(w)
+	}
+
+	return nil
+}
+
+// shrink retires workers of the slots from newSize on, idle workers are stopped right away and
+// busy workers once released. Returns retired workers. Must be called under reload lock.
+func (p *StaticPool) shrink(newSize int) (retired []*Worker) {
+	// removed slots are not respawned from now on
+	p.mus.Lock()
+	p.muf.Lock()
+	p.cfg.NumWorkers = int64(newSize)
+	if p.next >= newSize {
+		p.next = 0
+	}
+	p.muf.Unlock()
+	p.mus.Unlock()
+
+	err := fmt.Errorf("pool resized")
+
+	p.muw.Lock()
+	for _, w := range p.workers {
+		if p.index[w] < newSize {
+			continue
+		}
+
+		p.retired.Store(w, true)
+		p.recycles.mark(w, RecycleResize)
+		p.remove.Store(w, err)
+		retired = append(retired, w)
+	}
+	p.muw.Unlock()
+
+	for _, w := range retired {
+		if w.mux == nil {
+			if p.takeIdle(w) {
+				p.recycleWorker(w, RecycleResize, err)
+			}
+
+			continue
+		}
+
+		// multiplexed worker leaves the rotation, the last released task retires it once busy
+		if p.takeFree(w) && w.claimRemoved() {
+			p.recycleWorker(w, RecycleResize, err)
+		}
+	}
+
+	return retired
+}
+
+// awaitRetired waits up to DestroyTimeout for the given workers to exit, returns number of
+// workers still running.
+func (p *StaticPool) awaitRetired(workers []*Worker) (running int) {
+	timer := time.NewTimer(p.cfg.DestroyTimeout)
+	defer timer.Stop()
+
+	expired := false
+	for _, w := range workers {
+		if !expired {
+			select {
+			case <-w.waitDone:
+				continue
+			case <-timer.C:
+				expired = true
+			}
+		}
+
+		select {
+		case <-w.waitDone:
+		default:
+			running++
+		}
+	}
+
+	return running
+}
+
+// resized returns true when the slot has been removed by Resize.
+func (p *StaticPool) resized(index int) bool {
+	p.muf.RLock()
+	defer p.muf.RUnlock()
+
+	return int64(index) >= p.cfg.NumWorkers
+}
+
+// discardResized discards worker created for the slot removed by Resize, returns false when slot
+// is still in use.
+func (p *StaticPool) discardResized(w *Worker, index int) bool {
+	if !p.resized(index) {
+		return false
+	}
+
+	p.retired.Store(w, true)
+	p.discardWorker(w, RecycleResize, fmt.Errorf("pool resized"))
+	return true
+}
+
+// stale returns true if worker set has been replaced since given generation.
+func (p *StaticPool) stale(gen int) bool {
+	p.muw.RLock()
+	defer p.muw.RUnlock()
+
+	return p.gen != gen
+}
+
+// WaitReady waits until pool has started MinReady workers, returns immediately once pool has been
+// ready. Needed when pool is created with StartAsync or Exec must not wait for the workers being
+// started. Keeps waiting while circuit breaker is open (workers fail to start): slots are respawned
+// once breaker cooldown expires, use context to limit the wait. Returns ErrPoolDestroyed once pool
+// is destroyed.
+func (p *StaticPool) WaitReady(ctx context.Context) error {
+	if p.destroyed() {
+		return ErrPoolDestroyed
+	}
+
+	return p.ready.wait(ctx, false, 0, p.destroy)
+}
+
+// Drain pauses the pool and waits for the active tasks until context is done, workers still busy
+// once context is done are killed and replaced. Returns context error along with the report when
+// workers had to be killed. Unlike Destroy pool keeps the workers and the factory, Resume restarts
+// dispatching of the tasks held since drain.
+func (p *StaticPool) Drain(ctx context.Context) (DrainReport, error) {
+	return p.DrainWithOptions(ctx, DrainOptions{})
+}
+
+// DrainWithOptions drains the pool like Drain, opts define which busy workers are killed first
+// once drain runs out of time, see DrainOldestFirst. Workers sacrificed ahead of the deadline do
+// not fail the drain when remaining tasks complete in time.
+func (p *StaticPool) DrainWithOptions(ctx context.Context, opts DrainOptions) (DrainReport, error) {
+	if p.destroyed() {
+		return DrainReport{}, ErrPoolDestroyed
+	}
+
+	p.Pause()
+
+	p.tmu.Lock()
+	defer p.tmu.Unlock()
+
+	return drainTasks(ctx, &p.tasks, &p.numExecs, opts, func(keep int) int {
+		return len(p.killRecent(keep))
+	})
+}
+
+// Destroy all underlying workers (but let them to complete the task).
+func (p *StaticPool) Destroy() {
+	p.DestroyWithTimeout(0)
+}
+
+// DestroyWithTimeout destroys all underlying workers, active tasks are given d time to complete
+// and workers still busy after that are killed. Zero d waits for all tasks. Returns PIDs of
+// killed workers. New tasks are rejected with ErrPoolDestroyed.
+func (p *StaticPool) DestroyWithTimeout(d time.Duration) (killed []int) {
+	atomic.AddInt32(&p.inDestroy, 1)
+
+	p.tmu.Lock()
+	if d == 0 {
+		p.tasks.Wait()
+		close(p.destroy)
+	} else {
+		killed = p.drain(d)
+	}
+	p.tmu.Unlock()
+
+	// workers started in background are destroyed along with the others
+	p.starting.Wait()
+
+	var wg sync.WaitGroup
+	for _, w := range p.Workers() {
+		wg.Add(1)
+		w.markInvalid()
+		go func(w *Worker) {
+			defer wg.Done()
+			p.destroyWorker(w, nil)
+		}(w)
+	}
+
+	wg.Wait()
+	p.events.close(p.cfg.DestroyTimeout)
+
+	if p.ownsFactory {
+		_ = p.factory.Close()
+	}
+
+	return killed
+}
+
+// drain waits d time for active tasks to complete and kills workers which are still busy.
+func (p *StaticPool) drain(d time.Duration) (killed []int) {
+	done := make(chan interface{})
+	go func() {
+		p.tasks.Wait()
+		close(done)
+	}()
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+		close(p.destroy)
+		return nil
+	case <-timer.C:
+		// releasing tasks waiting for the worker
+		close(p.destroy)
+	}
+
+	killed = p.killBusy()
+
+	<-done
+	return killed
+}
+
+// killBusy kills workers executing the task, returns PIDs of killed workers.
+func (p *StaticPool) killBusy() (killed []int) {
+	return p.killRecent(0)
+}
+
+// killRecent kills workers executing the task except keep ones running the oldest tasks, returns
+// PIDs of killed workers.
+func (p *StaticPool) killRecent(keep int) (killed []int) {
+	var busy []*Worker
+	for _, w := range p.Workers() {
+		if w.State().Value() == StateWorking {
+			busy = append(busy, w)
+		}
+	}
+
+	if keep > 0 {
+		sort.SliceStable(busy, func(i, j int) bool {
+			return busy[i].TaskStarted().Before(busy[j].TaskStarted())
+		})
+
+		if keep >= len(busy) {
+			return nil
+		}
+
+		busy = busy[keep:]
+	}
+
+	for _, w := range busy {
+		if err := w.Kill(); err != nil {
+			p.throw(EventWorkerError, WorkerError{Worker: w, Caused: err})
+		}
+
+		p.throw(EventWorkerKill, w)
+		killed = append(killed, *w.Pid)
+	}
+
+	return killed
+}
+
+// finds free worker in a given time interval for the task of normal priority.
+func (p *StaticPool) allocateWorker(ctx context.Context) (w *Worker, err error) {
+	return p.allocatePriority(ctx, PriorityNormal)
+}
+
+// finds free worker in a given time interval. Skips dead workers and waits for their
+// replacements, pool of dead workers fails once wait timeout is reached. Waiting tasks receive
+// released workers by priority, see waitQueue.
+func (p *StaticPool) allocatePriority(ctx context.Context, priority int) (w *Worker, err error) {
+	var (
+		timeout *time.Timer
+		since   time.Time
+	)
+	defer func() {
+		if timeout != nil {
+			timeout.Stop()
+			atomic.AddInt64(&p.waiting, -1)
+		}
+	}()
+
+	for {
+		w, e := p.take(priority, since)
+		if e != nil {
+			since = e.since
+			if timeout == nil {
+				timeout = time.NewTimer(p.waitTimeout())
+				if atomic.AddInt64(&p.waiting, 1) > p.cfg.MaxQueueSize && p.cfg.MaxQueueSize != 0 {
+					p.leave(e)
+					return nil, ErrQueueFull
+				}
+			}
+
+			select {
+			case w = <-wait(e):
+			case <-timeout.C:
+				if w = p.queue.leave(e); w == nil {
+					return nil, ErrAllocTimeout
+				}
+			case <-p.destroy:
+				p.leave(e)
+				return nil, ErrPoolDestroyed
+			case <-ctx.Done():
+				p.leave(e)
+				return nil, ctx.Err()
+			}
+		}
+
+		if w.State().Value() != StateReady {
+			// found expected dead worker
+			atomic.AddInt64(&p.numDead, ^int64(0))
+			continue
+		}
+
+		if err, remove := p.remove.Load(w); remove {
+			p.recycleWorker(w, RecycleRemoved, err)
+			continue
+		}
+
+		if err := p.checkIdle(w); err != nil {
+			p.replaceIdle(w, err)
+			continue
+		}
+
+		return p.selectWorker(w), nil
+	}
+}
+
+// tryAllocate returns free worker without waiting, ok is false when no workers are available.
+func (p *StaticPool) tryAllocate() (w *Worker, ok bool) {
+	for {
+		select {
+		case w = <-p.freeChan():
+			if w == nil {
+				// free buf has been replaced by Reset
+				continue
+			}
+
+			if w.State().Value() != StateReady {
+				// found expected dead worker
+				atomic.AddInt64(&p.numDead, ^int64(0))
+				continue
+			}
+
+			if err, remove := p.remove.Load(w); remove {
+				p.recycleWorker(w, RecycleRemoved, err)
+				continue
+			}
+
+			if err := p.checkIdle(w); err != nil {
+				p.replaceIdle(w, err)
+				continue
+			}
+
+			return p.selectWorker(w), true
+		default:
+			return nil, false
+		}
+	}
+}
+
+// selectWorker picks the worker according to the selector or selection strategy, given worker
+// is used as a candidate along with all other free workers. Must be called with ready worker.
+func (p *StaticPool) selectWorker(w *Worker) *Worker {
+	if p.cfg.Selector == nil && p.cfg.SelectionStrategy != SelectRoundRobin && p.cfg.SelectionStrategy != SelectLeastUsed {
+		return w
+	}
+
+	p.mus.Lock()
+	defer p.mus.Unlock()
+
+	taken := []*Worker{w}
+	free := p.freeChan()
+	for i := len(free); i > 0; i-- {
+		select {
+		case wc := <-free:
+			if wc == nil {
+				// free buf has been replaced
+				i = 0
+				continue
+			}
+
+			taken = append(taken, wc)
+		default:
+			i = 0
+		}
+	}
+
+	candidates := taken[:1:1]
+	for _, wc := range taken[1:] {
+		if wc.State().Value() != StateReady {
+			continue
+		}
+
+		if _, remove := p.remove.Load(wc); remove {
+			continue
+		}
+
+		candidates = append(candidates, wc)
+	}
+
+	selected := w
+	if p.cfg.Selector != nil {
+		if len(candidates) > 1 {
+			selected = p.selectCandidate(candidates)
+		}
+	} else {
+		p.muw.RLock()
+		for _, wc := range candidates[1:] {
+			switch p.cfg.SelectionStrategy {
+			case SelectRoundRobin:
+				if p.distance(p.index[wc]) < p.distance(p.index[selected]) {
+					selected = wc
+				}
+			case SelectLeastUsed:
+				if wc.State().NumExecs() < selected.State().NumExecs() {
+					selected = wc
+				}
+			}
+		}
+
+		if p.cfg.SelectionStrategy == SelectRoundRobin {
+			p.next = (p.index[selected] + 1) % int(p.cfg.NumWorkers)
+		}
+		p.muw.RUnlock()
+	}
+
+	for _, wc := range taken {
+		if wc != selected {
+			p.push(wc)
+		}
+	}
+
+	return selected
+}
+
+// selectCandidate returns worker picked by the selector, first candidate is used when selector
+// returns unknown worker.
+func (p *StaticPool) selectCandidate(candidates []*Worker) *Worker {
+	selected := p.cfg.Selector.Select(append([]*Worker(nil), candidates...))
+	for _, wc := range candidates {
+		if wc == selected {
+			return selected
+		}
+	}
+
+	return candidates[0]
+}
+
+// stickWorker returns free worker preferred by the key instead of the given one if available,
+// given worker is remembered as preferred otherwise. Must be called with ready worker.
+func (p *StaticPool) stickWorker(w *Worker, key string) *Worker {
+	p.mus.Lock()
+	defer p.mus.Unlock()
+
+	p.muw.RLock()
+	preferred := p.sticky.lookup(key, int(p.cfg.NumWorkers))
+	index := p.index[w]
+	p.muw.RUnlock()
+
+	if index == preferred {
+		return w
+	}
+
+	selected := p.swapFor(w, preferred)
+	if selected == w {
+		p.sticky.remember(key, index)
+	}
+
+	return selected
+}
+
+// swapFor returns free worker of the preferred slot instead of the given one if available, given
+// worker is returned to the free buf then. Must be called under mus with ready worker.
+func (p *StaticPool) swapFor(w *Worker, preferred int) *Worker {
+	selected := w
+	free := p.freeChan()
+
+	var candidates []*Worker
+	for i := len(free); i > 0 && selected == w; i-- {
+		select {
+		case wc := <-free:
+			if wc == nil {
+				// free buf has been replaced
+				i = 0
+				continue
+			}
+
+			candidates = append(candidates, wc)
+			if wc.State().Value() != StateReady {
+				continue
+			}
+
+			if _, remove := p.remove.Load(wc); remove {
+				continue
+			}
+
+			p.muw.RLock()
+			if p.index[wc] == preferred {
+				selected = wc
+			}
+			p.muw.RUnlock()
+		default:
+			i = 0
+		}
+	}
+
+	if selected == w {
+		return w
+	}
+
+	p.push(w)
+	for _, wc := range candidates {
+		if wc != selected {
+			p.push(wc)
+		}
+	}
+
+	return selected
+}
+
+// SetDebugSingleWorker routes all Exec, ExecContext, ExecWithMeta, ExecPriority and ExecSticky
+// tasks to the worker of the first slot one at a time while enabled, other workers stay alive but
+// idle. Debugging aid to rule out concurrency, tasks already executed by other workers complete
+// as usual. Task runs on any free worker when the first slot worker is not free, for example
+// while being replaced.
+func (p *StaticPool) SetDebugSingleWorker(enabled bool) {
+	if enabled {
+		atomic.StoreInt32(&p.single, 1)
+	} else {
+		atomic.StoreInt32(&p.single, 0)
+	}
+
+	p.logger().Info("debug single worker mode", "enabled", enabled)
+}
+
+// acquireSerial waits for the turn of the task in debug single worker mode, waiting is limited
+// the same way as the worker allocation.
+func (p *StaticPool) acquireSerial(ctx context.Context) error {
+	timer := time.NewTimer(p.waitTimeout())
+	defer timer.Stop()
+
+	select {
+	case p.serial <- nil:
+		return nil
+	case <-timer.C:
+		return ErrAllocTimeout
+	case <-p.destroy:
+		return ErrPoolDestroyed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// singleWorker returns free worker of the first slot instead of the given one if available. Must
+// be called with ready worker.
+func (p *StaticPool) singleWorker(w *Worker) *Worker {
+	p.mus.Lock()
+	defer p.mus.Unlock()
+
+	p.muw.RLock()
+	index := p.index[w]
+	p.muw.RUnlock()
+
+	if index == 0 {
+		return w
+	}
+
+	return p.swapFor(w, 0)
+}
+
+// distance returns how far worker index is located from the next round robin position.
+func (p *StaticPool) distance(index int) int {
+	return (index - p.next + int(p.cfg.NumWorkers)) % int(p.cfg.NumWorkers)
+}
+
+// release releases or replaces the worker.
+func (p *StaticPool) release(w *Worker) {
+	p.progressed()
+
+	if _, detached := p.detached.Load(w); detached {
+		// worker belongs to the caller
+		return
+	}
+
+	if p.cfg.MaxJobs != 0 && w.State().NumExecs() >= p.cfg.MaxJobs {
+		p.recycleReleased(w, RecycleMaxJobs, p.cfg.MaxJobs)
+		return
+	}
+
+	if p.cfg.MaxAge != 0 && time.Since(w.Created) >= p.cfg.MaxAge {
+		p.recycleReleased(w, RecycleMaxAge, fmt.Errorf("max age reached (%s)", p.cfg.MaxAge))
+		return
+	}
+
+	if p.cfg.MaxMemory != 0 {
+		usage, err := w.accountedMemory()
+		if err != nil {
+			// process is gone
+			p.discardWorker(w, RecycleCrash, err)
+			return
+		}
+
+		if usage >= p.cfg.MaxMemory*1024*1024 {
+			p.recycleReleased(w, RecycleMaxMemory, fmt.Errorf("max memory reached (%vMB)", p.cfg.MaxMemory))
+			return
+		}
+	}
+
+	if err, remove := p.remove.Load(w); remove {
+		// multiplexed worker is retired once its last task is released
+		if w.checkinRemoved() {
+			p.recycleWorker(w, RecycleRemoved, err)
+		}
+
+		return
+	}
+
+	if w.Unhealthy() && !p.isSwapping(w) {
+		p.logger().Warn("worker reported unhealthy, worker is replaced", "pid", *w.Pid)
+		p.recycleReleased(w, RecycleUnhealthy, ErrWorkerUnhealthy)
+		return
+	}
+
+	// multiplexed worker with spare slots has been kept in the free workers
+	if w.checkin() {
+		p.push(w)
+	}
+}
+
+// recycleReleased recycles released worker, worker keeps serving while its replacement is
+// spawned unless Config.RecycleKillFirst is set.
+func (p *StaticPool) recycleReleased(w *Worker, reason RecycleReason, caused interface{}) {
+	if !p.swapWorker(w, reason, caused) {
+		p.recycleWorker(w, reason, caused)
+		return
+	}
+
+	if err, remove := p.remove.Load(w); remove {
+		// replacement is serving already
+		if w.checkinRemoved() {
+			p.recycleWorker(w, RecycleRemoved, err)
+		}
+
+		return
+	}
+
+	if w.checkin() {
+		p.pushSwapped(w)
+	}
+}
+
+// pushSwapped returns worker being swapped to the free workers, free buf has no room for both
+// the worker and its replacement, so worker replaced meanwhile is retired instead.
+func (p *StaticPool) pushSwapped(w *Worker) {
+	if err, remove := p.remove.Load(w); remove {
+		p.recycleWorker(w, RecycleRemoved, err)
+		return
+	}
+
+	p.push(w)
+
+	// replacement might have joined the rotation while worker was being returned
+	if _, remove := p.remove.Load(w); remove {
+		p.retireIdle(w)
+	}
+}
+
+// replaceIdle replaces the worker which reported itself unhealthy or sent unexpected data while
+// being idle.
+func (p *StaticPool) replaceIdle(w *Worker, err error) {
+	if err == ErrWorkerUnhealthy {
+		p.logger().Warn("worker reported unhealthy, worker is replaced", "pid", *w.Pid)
+		if p.swapWorker(w, RecycleUnhealthy, err) {
+			p.pushSwapped(w)
+			return
+		}
+
+		p.recycleWorker(w, RecycleUnhealthy, err)
+		return
+	}
+
+	p.logger().Warn("worker relay is out of sync, worker is replaced", "pid", *w.Pid, "error", err)
+	p.throw(EventWorkerError, WorkerError{Worker: w, Caused: err})
+	p.discardWorker(w, RecycleError, err)
+}
+
+// checkIdle checks frames sent by the idle worker, unhealthy worker keeps serving while its
+// replacement is spawned.
+func (p *StaticPool) checkIdle(w *Worker) error {
+	err := w.checkIdle()
+	if err == ErrWorkerUnhealthy && p.isSwapping(w) {
+		return nil
+	}
+
+	return err
+}
+
+// swapWorker starts spawning replacement of the recycled worker, worker keeps serving until
+// replacement is ready and is recycled afterwards. Returns false when worker must be recycled
+// right away, see Config.RecycleKillFirst.
+func (p *StaticPool) swapWorker(w *Worker, reason RecycleReason, caused interface{}) bool {
+	if p.cfg.RecycleKillFirst || p.destroyed() {
+		return false
+	}
+
+	if _, swapping := p.swapping.LoadOrStore(w, true); swapping {
+		// replacement is being spawned
+		return true
+	}
+
+	// old worker must not be replaced on death
+	p.retired.Store(w, true)
+
+	p.muw.RLock()
+	index, gen := p.index[w], p.gen
+	p.muw.RUnlock()
+
+	go p.swap(w, index, gen, reason, caused)
+	return true
+}
+
+// swap spawns replacement of the worker and recycles the worker once replacement is ready,
+// worker is recycled first and replaced on death when replacement can not be spawned.
+func (p *StaticPool) swap(w *Worker, index, gen int, reason RecycleReason, caused interface{}) {
+	nw, err := p.createWorker(index)
+	if err == nil && (p.stale(gen) || p.destroyed()) {
+		// worker set has been replaced or destroyed while worker was being created
+		p.retired.Store(nw, true)
+		p.discardWorker(nw, RecycleReload, fmt.Errorf("pool reset"))
+		return
+	}
+
+	if err == nil && p.discardResized(nw, index) {
+		return
+	}
+
+	if err != nil {
+		p.retired.Delete(w)
+		p.logger().Error("unable to spawn replacement of the recycled worker", "pid", *w.Pid, "error", err)
+	}
+
+	// worker leaves the rotation before its replacement joins it, free buf has no room for both
+	p.recycles.mark(w, reason)
+	p.recycled.Store(w, true)
+	p.Remove(w, fmt.Errorf("worker recycled (%v)", caused))
+	p.retireIdle(w)
+
+	if err == nil {
+		p.push(nw)
+	}
+}
+
+// isSwapping returns true when worker replacement is being spawned.
+func (p *StaticPool) isSwapping(w *Worker) bool {
+	_, swapping := p.swapping.Load(w)
+	return swapping
+}
+
+// share returns checked out multiplexed worker with spare slots back to the free workers, see
+// Config.WorkerConcurrency. Every checkout must be followed by release, recycle or discard.
+func (p *StaticPool) share(w *Worker) *Worker {
+	if w.checkout() {
+		p.push(w)
+	}
+
+	return w
+}
+
+// creates new worker using associated factory. automatically
+// adds worker to the worker list (background)
+func (p *StaticPool) createWorker(index int) (*Worker, error) {
+	w, err := p.spawnWorker(p.command(), index)
+	if err != nil {
+		return nil, err
+	}
+
+	p.register(w, index)
+	return w, nil
+}
+
+// respawnWorker creates worker replacing the dead one once respawn rate limit allows, replacement
+// of the crashed worker waits for the respawn backoff first. ErrPoolDestroyed is returned when
+// pool is destroyed while waiting.
+func (p *StaticPool) respawnWorker(index int, crashed bool) (*Worker, error) {
+	if crashed && !p.backoff.wait(index, p.destroy) {
+		return nil, ErrPoolDestroyed
+	}
+
+	if !p.respawns.wait(p.destroy) {
+		return nil, ErrPoolDestroyed
+	}
+
+	return p.createWorker(index)
+}
+
+// register adds worker to the worker list and starts watching it.
+func (p *StaticPool) register(w *Worker, index int) {
+	p.muw.Lock()
+	p.workers = append(p.workers, w)
+	p.index[w] = index
+	p.muw.Unlock()
+
+	p.events.attach(w)
+	go p.watchWorker(w)
+}
+
+// freeChan returns current free workers buf.
+func (p *StaticPool) freeChan() chan *Worker {
+	p.muf.RLock()
+	defer p.muf.RUnlock()
+
+	return p.free
+}
+
+// push passes worker to the next waiting task or returns it to the current free workers buf.
+func (p *StaticPool) push(w *Worker) {
+	p.muf.RLock()
+	defer p.muf.RUnlock()
+
+	p.queue.put(p.free, w)
+}
+
+// take returns worker from the current free workers buf or registers new waiter.
+func (p *StaticPool) take(priority int, since time.Time) (*Worker, *waiter) {
+	p.muf.RLock()
+	defer p.muf.RUnlock()
+
+	return p.queue.take(p.free, priority, since)
+}
+
+// leave removes waiter from the queue, worker already passed to the waiter is passed to the next one.
+func (p *StaticPool) leave(e *waiter) {
+	if w := p.queue.leave(e); w != nil {
+		p.push(w)
+	}
+}
+
+// watchWorker watches worker state and replaces it if worker fails.
+func (p *StaticPool) watchWorker(w *Worker) {
+	err := w.Wait()
+	if _, ok := p.detached.Load(w); ok {
+		p.detached.Delete(w)
+		return
+	}
+
+	p.events.detach(w)
+	p.throw(EventWorkerDead, w)
+
+	// detaching
+	p.muw.Lock()
+	index, gen := p.index[w], p.gen
+	delete(p.index, w)
+	for i, wc := range p.workers {
+		if wc == w {
+			p.workers = append(p.workers[:i], p.workers[i+1:]...)
+			p.remove.Delete(w)
+			break
+		}
+	}
+	p.muw.Unlock()
+
+	_, recycled := p.recycled.Load(w)
+	p.recycled.Delete(w)
+	p.swapping.Delete(w)
+
+	// workers stopped by the pool destroy and hijacked workers are not accounted
+	_, retired := p.retired.Load(w)
+	if reason, stopped := p.recycles.exited(w); stopped || (!retired && !p.destroyed()) {
+		p.recycles.add(reason)
+	}
+
+	if retired {
+		// replaced by the reload
+		p.retired.Delete(w)
+		return
+	}
+
+	if !recycled && !p.destroyed() {
+		p.notifyDeath(w, err)
+	}
+
+	// registering a dead worker
+	atomic.AddInt64(&p.numDead, 1)
+
+	// worker have died unexpectedly, pool should attempt to replace it with alive version safely
+	if err != nil {
+		p.logger().Warn("worker died", "pid", *w.Pid, "request", w.RequestID(), "error", err, "diagnostics", w.Diagnostics())
+		p.throw(EventWorkerError, WorkerError{Worker: w, Caused: err})
+	}
+
+	if p.resized(index) {
+		// slot has been removed by Resize
+		return
+	}
+
+	if !recycled && !p.destroyed() && p.quarantine.failed(index) {
+		p.quarantined(index, gen, err)
+		return
+	}
+
+	if !p.destroyed() {
+		nw, err := p.respawnWorker(index, !recycled)
+		if err == ErrPoolDestroyed {
+			return
+		}
+
+		if err == nil && p.stale(gen) {
+			// worker set has been replaced while worker was being created
+			p.retired.Store(nw, true)
+			p.discardWorker(nw, RecycleReload, fmt.Errorf("pool reset"))
+			return
+		}
+
+		if err == nil && p.discardResized(nw, index) {
+			return
+		}
+
+		if err == nil {
+			p.push(nw)
+			return
+		}
+
+		p.logger().Error("unable to replace worker", "pid", *w.Pid, "error", err)
+		if p.quarantine.failed(index) {
+			p.quarantined(index, gen, err)
+			return
+		}
+
+		// possible situation when major error causes all PHP scripts to die (for example dead DB)
+		if len(p.Workers()) == 0 {
+			p.throw(EventPoolError, err)
+		} else {
+			p.throw(EventWorkerError, WorkerError{Worker: w, Caused: err})
+		}
+
+		if p.cfg.BreakerThreshold != 0 {
+			go p.respawn(index, gen)
+		}
+	}
+}
+
+// startFailed handles failed start of the initial worker like failed worker replacement, slot
+// is respawned when circuit breaker is enabled.
+func (p *StaticPool) startFailed(index, gen int, err error) {
+	p.logger().Error("unable to start worker", "index", index, "error", err)
+	p.ready.failed(err)
+	p.throw(EventPoolError, err)
+
+	if p.quarantine.failed(index) {
+		p.quarantined(index, gen, err)
+		return
+	}
+
+	if p.cfg.BreakerThreshold != 0 {
+		go p.respawn(index, gen)
+	}
+}
+
+// quarantined holds back respawn of the failing worker slot until quarantine cooldown expires.
+func (p *StaticPool) quarantined(index, gen int, err error) {
+	p.logger().Warn("worker slot quarantined", "index", index, "cooldown", p.cfg.QuarantineCooldown, "error", err)
+	go p.retryQuarantined(index, gen)
+}
+
+// respawn keeps replacing worker in the given slot until new worker starts, attempts are
+// paused by the circuit breaker.
+func (p *StaticPool) respawn(index, gen int) {
+	for {
+		timer := time.NewTimer(p.breaker.retryIn())
+		select {
+		case <-timer.C:
+		case <-p.destroy:
+			timer.Stop()
+			return
+		}
+
+		if p.destroyed() || p.stale(gen) || p.resized(index) {
+			return
+		}
+
+		nw, err := p.respawnWorker(index, true)
+		if err != nil {
+			continue
+		}
+
+		if p.stale(gen) {
+			p.retired.Store(nw, true)
+			p.discardWorker(nw, RecycleReload, fmt.Errorf("pool reset"))
+			return
+		}
+
+		if p.discardResized(nw, index) {
+			return
+		}
+
+		p.push(nw)
+		p.ready.started(len(p.Workers()))
+		return
+	}
+}
+
+// retryQuarantined attempts single respawn of the quarantined worker slot once cooldown expires,
+// slot stays quarantined for another cooldown when worker fails to start.
+func (p *StaticPool) retryQuarantined(index, gen int) {
+	for {
+		timer := time.NewTimer(p.cfg.QuarantineCooldown)
+		select {
+		case <-timer.C:
+		case <-p.destroy:
+			timer.Stop()
+			return
+		}
+
+		if p.destroyed() || p.stale(gen) || p.resized(index) {
+			// slots are renumbered by the reset or removed by the resize
+			p.quarantine.succeeded(index)
+			return
+		}
+
+		nw, err := p.respawnWorker(index, false)
+		if err == ErrPoolDestroyed {
+			return
+		}
+
+		if err != nil {
+			p.quarantine.failed(index)
+			p.logger().Warn("worker slot quarantined", "index", index, "cooldown", p.cfg.QuarantineCooldown, "error", err)
+			continue
+		}
+
+		p.quarantine.succeeded(index)
+		p.logger().Info("worker slot released from quarantine", "index", index, "pid", *nw.Pid)
+
+		if p.stale(gen) {
+			p.retired.Store(nw, true)
+			p.discardWorker(nw, RecycleReload, fmt.Errorf("pool reset"))
+			return
+		}
+
+		if p.discardResized(nw, index) {
+			return
+		}
+
+		p.push(nw)
+		p.ready.started(len(p.Workers()))
+		return
+	}
+}
+
+// minSweepInterval limits how often idle workers are checked for MaxAge.
+const minSweepInterval = time.Millisecond
+
+// sweep periodically replaces idle workers which reached MaxAge.
+func (p *StaticPool) sweep() {
+	interval := p.cfg.MaxAge / 4
+	if interval < minSweepInterval {
+		interval = minSweepInterval
+	}
+
+	clock := clockOrSystem(p.cfg.Clock)
+	for {
+		timer := clock.NewTimer(interval)
+		select {
+		case <-timer.C():
+			p.sweepWorkers()
+		case <-p.destroy:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// roll replaces the oldest worker every rolling replacement cadence until pool is destroyed.
+func (p *StaticPool) roll() {
+	clock := clockOrSystem(p.cfg.Clock)
+	for {
+		timer := clock.NewTimer(p.rollingCadence())
+		select {
+		case <-timer.C():
+			p.rollWorker()
+		case <-p.destroy:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// rollWorker replaces the oldest worker, failed replacement is retried on the next tick.
+func (p *StaticPool) rollWorker() {
+	p.reload.Lock()
+	defer p.reload.Unlock()
+
+	w := p.oldestWorker(p.Workers())
+	if w == nil {
+		return
+	}
+
+	if err := p.reloadWorker(w, RecycleRolling); err != nil && err != ErrPoolDestroyed {
+		p.logger().Error("unable to replace worker of the rolling replacement", "pid", *w.Pid, "error", err)
+		p.throw(EventWorkerError, WorkerError{Worker: w, Caused: err})
+	}
+}
+
+// trim periodically samples memory of the workers and trims the pool to MaxTotalMemory until
+// pool is destroyed.
+func (p *StaticPool) trim() {
+	interval := p.cfg.MemoryCheckInterval
+	if interval == 0 {
+		interval = time.Second
+	}
+
+	clock := clockOrSystem(p.cfg.Clock)
+	for {
+		timer := clock.NewTimer(interval)
+		select {
+		case <-timer.C():
+			p.trimWorkers()
+		case <-p.destroy:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// trimWorkers sums memory accounted to the workers and recycles idle workers starting from the
+// largest one while total is above 90% of MaxTotalMemory. Busy workers are never trimmed, trimmed
+// worker is destroyed before its replacement is spawned.
+func (p *StaticPool) trimWorkers() {
+	var (
+		workers = p.Workers()
+		usage   = make(map[*Worker]uint64, len(workers))
+		total   uint64
+	)
+
+	for _, w := range workers {
+		if !w.State().IsActive() {
+			continue
+		}
+
+		m, err := w.accountedMemory()
+		if err != nil {
+			// process is gone
+			continue
+		}
+
+		usage[w] = m
+		total += m
+	}
+	atomic.StoreUint64(&p.totalMemory, total)
+
+	watermark := p.cfg.MaxTotalMemory * 1024 * 1024 / 10 * 9
+	if total < watermark {
+		return
+	}
+
+	sort.Slice(workers, func(i, j int) bool {
+		return usage[workers[i]] > usage[workers[j]]
+	})
+
+	for _, w := range workers {
+		if total < watermark {
+			return
+		}
+
+		if usage[w] == 0 || p.isSwapping(w) {
+			continue
+		}
+
+		// replacement is never spawned ahead, it would add to the total memory
+		err := fmt.Errorf("max total memory reached (%vMB)", p.cfg.MaxTotalMemory)
+		if p.takeIdle(w) {
+			p.recycleWorker(w, RecycleMaxTotalMemory, err)
+			p.logger().Warn("pool memory is close to the limit, worker is trimmed", "pid", *w.Pid, "memory", usage[w], "total", total)
+			total -= usage[w]
+		}
+	}
+}
+
+// rollingCadence returns interval between two rolling replacements which spreads replacements of
+// all workers over the RollingReplaceInterval, 0 when rolling replacement is disabled.
+func (p *StaticPool) rollingCadence() time.Duration {
+	cfg := p.Config()
+	if cfg.RollingReplaceInterval == 0 || cfg.NumWorkers == 0 {
+		return cfg.RollingReplaceInterval
+	}
+
+	return cfg.RollingReplaceInterval / time.Duration(cfg.NumWorkers)
+}
+
+// inspectWorkers passes all idle workers through the idle check, unhealthy workers are replaced.
+func (p *StaticPool) inspectWorkers() {
+	free := p.freeChan()
+	for i := len(free); i > 0; i-- {
+		var w *Worker
+		select {
+		case w = <-free:
+			if w == nil {
+				// free buf has been replaced
+				return
+			}
+		default:
+			return
+		}
+
+		if w.State().Value() != StateReady {
+			// found expected dead worker
+			atomic.AddInt64(&p.numDead, ^int64(0))
+			continue
+		}
+		p.share(w)
+
+		if err := p.checkIdle(w); err != nil {
+			p.replaceIdle(w, err)
+			continue
+		}
+
+		p.release(w)
+	}
+}
+
+// sweepWorkers passes all idle workers through the release to replace the expired ones.
+func (p *StaticPool) sweepWorkers() {
+	free := p.freeChan()
+	for i := len(free); i > 0; i-- {
+		var w *Worker
+		select {
+		case w = <-free:
+			if w == nil {
+				// free buf has been replaced
+				return
+			}
+		default:
+			return
+		}
+
+		if w.State().Value() != StateReady {
+			// found expected dead worker
+			atomic.AddInt64(&p.numDead, ^int64(0))
+			continue
+		}
+
+		p.release(p.share(w))
+	}
+}
+
+// heartbeat periodically pings idle workers until pool is destroyed.
+func (p *StaticPool) heartbeat() {
+	clock := clockOrSystem(p.cfg.Clock)
+	for {
+		timer := clock.NewTimer(p.cfg.HeartbeatInterval)
+		select {
+		case <-timer.C():
+			p.checkWorkers((*Worker).Ping, RecycleError)
+		case <-p.destroy:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// checkWorkers runs the check on all currently idle workers and replaces workers which failed it.
+func (p *StaticPool) checkWorkers(check func(w *Worker) error, reason RecycleReason) {
+	free := p.freeChan()
+	for i := len(free); i > 0; i-- {
+		var w *Worker
+		select {
+		case w = <-free:
+			if w == nil {
+				// free buf has been replaced
+				return
+			}
+		default:
+			return
+		}
+
+		if w.State().Value() != StateReady {
+			// found expected dead worker
+			atomic.AddInt64(&p.numDead, ^int64(0))
+			continue
+		}
+		p.share(w)
+
+		if err := check(w); err != nil {
+			p.throw(EventWorkerError, WorkerError{Worker: w, Caused: err})
+			p.discardWorker(w, reason, err)
+			continue
+		}
+
+		p.release(w)
+	}
+}
+
+// probe periodically executes health probe on idle workers until pool is destroyed.
+func (p *StaticPool) probe() {
+	clock := clockOrSystem(p.cfg.Clock)
+	for {
+		timer := clock.NewTimer(p.cfg.ProbeInterval)
+		select {
+		case <-timer.C():
+			p.checkWorkers(p.probeIdle, RecycleProbe)
+		case <-p.destroy:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// probeIdle executes health probe on the idle worker, failure is logged.
+func (p *StaticPool) probeIdle(w *Worker) error {
+	err := p.probeWorker(w)
+	if err != nil {
+		p.logger().Warn("worker failed health probe, worker is replaced", "pid", *w.Pid, "error", err)
+	}
+
+	return err
+}
+
+// probeWorker executes health probe on the worker and verifies the response.
+func (p *StaticPool) probeWorker(w *Worker) error {
+	timeout := p.cfg.ProbeTimeout
+	if timeout == 0 {
+		timeout = time.Second
+	}
+
+	rsp, err := w.ExecWithTimeout(&Payload{Body: p.cfg.ProbePayload}, timeout)
+	if err != nil {
+		return errors.Wrap(err, "probe")
+	}
+
+	if p.cfg.ProbeValidator != nil {
+		return errors.Wrap(p.cfg.ProbeValidator(rsp), "probe")
+	}
+
+	if !bytes.Equal(rsp.Body, p.cfg.ProbeResponse) {
+		return fmt.Errorf("probe: unexpected response `%s`", rsp.Body)
+	}
+
+	return nil
+}
+
+// watchdog resets the pool once queued tasks are not served within WatchdogTimeout, until pool
+// is destroyed.
+func (p *StaticPool) watchdog() {
+	clock := clockOrSystem(p.cfg.Clock)
+	timeout := p.cfg.watchdogTimeout()
+
+	p.progressed()
+	for {
+		timer := clock.NewTimer(timeout / 4)
+		select {
+		case <-timer.C():
+			if atomic.LoadInt64(&p.waiting) == 0 || p.pause.paused() {
+				// nothing is waiting for the workers
+				p.progressed()
+				continue
+			}
+
+			since := clock.Now().Sub(time.Unix(0, atomic.LoadInt64(&p.progress)))
+			if since < timeout {
+				continue
+			}
+
+			err := fmt.Errorf("pool is wedged, no task completed in %s", since)
+			p.logger().Error("pool is wedged, resetting all workers", "queued", atomic.LoadInt64(&p.waiting), "since", since)
+			p.throw(EventPoolError, err)
+
+			p.progressed()
+			p.resetWedged()
+		case <-p.destroy:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// progressed marks the pool as serving the tasks.
+func (p *StaticPool) progressed() {
+	atomic.StoreInt64(&p.progress, clockOrSystem(p.cfg.Clock).Now().UnixNano())
+}
+
+// resetWedged replaces all workers of the wedged pool, previous workers still executing the tasks
+// are killed since they would never be released.
+func (p *StaticPool) resetWedged() {
+	p.muf.RLock()
+	cmd, numWorkers := p.cmd, int(p.cfg.NumWorkers)
+	p.muf.RUnlock()
+
+	previous := p.Workers()
+	if err := p.Reset(cmd, numWorkers); err != nil {
+		p.logger().Error("unable to reset wedged pool", "error", err)
+		return
+	}
+
+	for _, w := range previous {
+		if w.State().Value() == StateWorking {
+			_ = w.Kill()
+		}
 	}
-	p.mul.Unlock()
 }
@@ -1,13 +1,18 @@
 package roadrunner
 
 import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"log"
+	"net"
 	"os/exec"
 	"runtime"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -33,6 +38,262 @@ func Test_NewPool(t *testing.T) {
 	assert.NotNil(t, p)
 }
 
+func Test_NewPoolWithConfig_Index(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		indexes []int
+	)
+
+	p, err := NewPoolWithConfig(
+		func(wc WorkerConfig) *exec.Cmd {
+			mu.Lock()
+			indexes = append(indexes, wc.Index)
+			mu.Unlock()
+
+			cmd := exec.Command("php", "tests/client.php", "echo", "pipes")
+			wc.Apply(cmd)
+
+			assert.Contains(t, cmd.Env, "RR_WORKER_INDEX="+strconv.Itoa(wc.Index))
+			return cmd
+		},
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      2,
+			MaxJobs:         1,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	_, err = p.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+
+	// replacement worker inherits the index
+	time.Sleep(time.Millisecond * 100)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	assert.Len(t, indexes, 3)
+	assert.Equal(t, []int{0, 1}, indexes[:2])
+	assert.Contains(t, []int{0, 1}, indexes[2])
+}
+
+func Test_StaticPool_WorkerID(t *testing.T) {
+	p, err := NewPoolWithConfig(
+		func(wc WorkerConfig) *exec.Cmd {
+			cmd := exec.Command("php", "tests/client.php", "pid", "pipes")
+			wc.Apply(cmd)
+
+			assert.Contains(t, cmd.Env, "RR_WORKER_ID="+wc.ID)
+			return cmd
+		},
+		NewPipeFactory(),
+		Config{
+			NumWorkers:       1,
+			MaxJobs:          1,
+			AllocateTimeout:  time.Second,
+			DestroyTimeout:   time.Second,
+			WorkerIDPrefix:   "http-",
+			RecycleKillFirst: true,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	first := p.Workers()[0]
+	assert.Equal(t, "http-0", first.ID)
+
+	_, err = p.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+
+	// replacement worker inherits the slot identity
+	time.Sleep(time.Millisecond * 100)
+
+	second := p.Workers()[0]
+	assert.NotEqual(t, *first.Pid, *second.Pid)
+	assert.Equal(t, "http-0", second.ID)
+	assert.Equal(t, "http-0", p.Dump()[0].ID)
+}
+
+// spawnFactory tracks number of workers being spawned in parallel and fails after given
+// number of spawned workers.
+type spawnFactory struct {
+	Factory
+	mu       sync.Mutex
+	active   int
+	max      int
+	spawned  []*Worker
+	failFrom int
+}
+
+func (f *spawnFactory) SpawnWorker(cmd *exec.Cmd) (*Worker, error) {
+	f.mu.Lock()
+	f.active++
+	if f.active > f.max {
+		f.max = f.active
+	}
+	fail := f.failFrom != 0 && len(f.spawned) >= f.failFrom
+	f.mu.Unlock()
+
+	defer func() {
+		f.mu.Lock()
+		f.active--
+		f.mu.Unlock()
+	}()
+
+	// let other spawns to overlap
+	time.Sleep(time.Millisecond * 50)
+	if fail {
+		return nil, fmt.Errorf("spawn error")
+	}
+
+	w, err := f.Factory.SpawnWorker(cmd)
+	if err == nil {
+		f.mu.Lock()
+		f.spawned = append(f.spawned, w)
+		f.mu.Unlock()
+	}
+
+	return w, err
+}
+
+func Test_StaticPool_MinReady(t *testing.T) {
+	f := &spawnFactory{Factory: NewPipeFactory()}
+
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "echo", "pipes") },
+		f,
+		Config{
+			NumWorkers:      3,
+			MinReady:        1,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	// remaining workers are started in background
+	assert.True(t, len(p.Workers()) < 3)
+	assert.NoError(t, p.WaitReady(context.Background()))
+
+	res, err := p.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", res.String())
+
+	time.Sleep(time.Millisecond * 300)
+	assert.Len(t, p.Workers(), 3)
+}
+
+func Test_StaticPool_StartAsync(t *testing.T) {
+	f := &spawnFactory{Factory: NewPipeFactory()}
+
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "echo", "pipes") },
+		f,
+		Config{
+			NumWorkers:         2,
+			StartAsync:         true,
+			RejectWhenNotReady: true,
+			AllocateTimeout:    time.Second,
+			DestroyTimeout:     time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	_, err = p.Exec(&Payload{Body: []byte("hello")})
+	assert.Equal(t, ErrPoolNotReady, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	assert.NoError(t, p.WaitReady(ctx))
+	assert.Len(t, p.Workers(), 2)
+
+	res, err := p.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", res.String())
+}
+
+func Test_StaticPool_StartAsync_Wait(t *testing.T) {
+	f := &spawnFactory{Factory: NewPipeFactory()}
+
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "echo", "pipes") },
+		f,
+		Config{
+			NumWorkers:      2,
+			MinReady:        1,
+			StartAsync:      true,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	// task waits for the pool readiness
+	res, err := p.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", res.String())
+}
+
+func Test_StaticPool_SpawnConcurrency(t *testing.T) {
+	f := &spawnFactory{Factory: NewPipeFactory()}
+
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "echo", "pipes") },
+		f,
+		Config{
+			NumWorkers:       6,
+			SpawnConcurrency: 2,
+			SpawnJitter:      time.Millisecond * 10,
+			AllocateTimeout:  time.Second,
+			DestroyTimeout:   time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	assert.Len(t, p.Workers(), 6)
+	assert.Equal(t, 2, f.max)
+
+	for _, w := range p.Workers() {
+		assert.Equal(t, StateReady, w.State().Value())
+	}
+}
+
+func Test_StaticPool_SpawnConcurrency_Error(t *testing.T) {
+	f := &spawnFactory{Factory: NewPipeFactory(), failFrom: 2}
+
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "echo", "pipes") },
+		f,
+		Config{
+			NumWorkers:       6,
+			SpawnConcurrency: 2,
+			AllocateTimeout:  time.Second,
+			DestroyTimeout:   time.Second,
+		},
+	)
+	assert.Nil(t, p)
+	assert.Error(t, err)
+
+	// already spawned workers are destroyed
+	assert.NotEmpty(t, f.spawned)
+	for _, w := range f.spawned {
+		select {
+		case <-w.waitDone:
+		default:
+			t.Errorf("worker %v is running", *w.Pid)
+		}
+	}
+}
+
 func Test_StaticPool_Invalid(t *testing.T) {
 	p, err := NewPool(
 		func() *exec.Cmd { return exec.Command("php", "tests/invalid.php") },
@@ -80,6 +341,34 @@ func Test_StaticPool_Echo(t *testing.T) {
 	assert.Equal(t, "hello", res.String())
 }
 
+func Test_NewStaticPool_Concurrent(t *testing.T) {
+	p, err := NewStaticPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "echo", "pipes") },
+		NewPipeFactory(),
+		2,
+	)
+	assert.NoError(t, err)
+
+	defer p.Destroy()
+
+	assert.Equal(t, int64(2), p.Config().NumWorkers)
+	assert.Len(t, p.Workers(), 2)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			res, err := p.Exec(&Payload{Body: []byte("hello")})
+			assert.NoError(t, err)
+			assert.Equal(t, "hello", res.String())
+		}()
+	}
+
+	wg.Wait()
+}
+
 func Test_StaticPool_Echo_NilContext(t *testing.T) {
 	p, err := NewPool(
 		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "echo", "pipes") },
@@ -144,121 +433,112 @@ func Test_StaticPool_JobError(t *testing.T) {
 	assert.Equal(t, "hello", err.Error())
 }
 
-func Test_StaticPool_Broken_Replace(t *testing.T) {
+func Test_StaticPool_Stats(t *testing.T) {
 	p, err := NewPool(
-		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "broken", "pipes") },
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "error", "pipes") },
 		NewPipeFactory(),
-		cfg,
+		Config{
+			NumWorkers:      2,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+		},
 	)
 	assert.NoError(t, err)
-	assert.NotNil(t, p)
-
-	done := make(chan interface{})
-
-	p.Listen(func(e int, ctx interface{}) {
-		if err, ok := ctx.(error); ok {
-			if strings.Contains(err.Error(), "undefined_function()") {
-				close(done)
-			}
-		}
-	})
+	defer p.Destroy()
 
-	res, err := p.Exec(&Payload{Body: []byte("hello")})
+	assert.Equal(t, PoolStats{NumWorkers: 2, NumIdle: 2, Breaker: BreakerClosed}, p.Stats())
 
+	_, err = p.Exec(&Payload{Body: []byte("hello")})
 	assert.Error(t, err)
-	assert.Nil(t, res)
 
-	<-done
-	p.Destroy()
+	stats := p.Stats()
+	assert.Equal(t, 2, stats.NumWorkers)
+	assert.Equal(t, stats.NumWorkers, stats.NumIdle+stats.NumBusy)
+	assert.Equal(t, int64(1), stats.TotalExecs)
+	assert.Equal(t, int64(1), stats.TotalErrors)
+	assert.Equal(t, 0, stats.Queued)
 }
 
-
-func Test_StaticPool_Broken_FromOutside(t *testing.T) {
+func Test_StaticPool_Allocate(t *testing.T) {
 	p, err := NewPool(
 		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "echo", "pipes") },
 		NewPipeFactory(),
-		cfg,
+		Config{
+			NumWorkers:      1,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+		},
 	)
 	assert.NoError(t, err)
 	defer p.Destroy()
 
-	assert.NotNil(t, p)
-
-	res, err := p.Exec(&Payload{Body: []byte("hello")})
-
+	w, err := p.Allocate(context.Background())
 	assert.NoError(t, err)
-	assert.NotNil(t, res)
-	assert.NotNil(t, res.Body)
-	assert.Nil(t, res.Context)
 
-	assert.Equal(t, "hello", res.String())
-	assert.Equal(t, runtime.NumCPU(), len(p.Workers()))
+	for i := 0; i < 2; i++ {
+		res, err := w.Exec(&Payload{Body: []byte("hello")})
+		assert.NoError(t, err)
+		assert.Equal(t, "hello", res.String())
+	}
 
-	destructed := make(chan interface{})
-	p.Listen(func(e int, ctx interface{}) {
-		if e == EventWorkerConstruct {
-			destructed <- nil
-		}
-	})
+	// no free workers
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
 
-	// killing random worker and expecting pool to replace it
-	err = p.Workers()[0].cmd.Process.Kill()
-	if err != nil {
-		t.Errorf("error killing the process: error %v", err)
-	}
-	<-destructed
+	_, err = p.Allocate(ctx)
+	assert.Error(t, err)
+	assert.Equal(t, context.DeadlineExceeded, err)
 
-	for _, w := range p.Workers() {
-		assert.Equal(t, StateReady, w.state.Value())
-	}
+	p.Release(w, false)
+
+	w2, err := p.Allocate(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, w, w2)
+	p.Release(w2, false)
 }
 
-func Test_StaticPool_AllocateTimeout(t *testing.T) {
+func Test_StaticPool_Release_Twice(t *testing.T) {
 	p, err := NewPool(
-		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "delay", "pipes") },
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "echo", "pipes") },
 		NewPipeFactory(),
 		Config{
 			NumWorkers:      1,
-			AllocateTimeout: time.Nanosecond * 1,
-			DestroyTimeout:  time.Second * 2,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
 		},
 	)
-	if err != nil {
-		t.Fatal(err)
-	}
+	assert.NoError(t, err)
+	defer p.Destroy()
 
-	done := make(chan interface{})
-	go func() {
-		if p != nil {
-			_, err := p.Exec(&Payload{Body: []byte("100")})
-			assert.NoError(t, err)
-			close(done)
-		} else {
-			panic("Pool is nil")
-		}
-	}()
+	w, err := p.Allocate(context.Background())
+	assert.NoError(t, err)
 
+	// repeated release is ignored, worker is returned once
+	p.Release(w, false)
+	p.Release(w, false)
+	assert.Equal(t, 1, p.Stats().NumIdle)
 
-	// to ensure that worker is already busy
-	time.Sleep(time.Millisecond * 10)
+	w, err = p.Allocate(context.Background())
+	assert.NoError(t, err)
 
-	_, err = p.Exec(&Payload{Body: []byte("10")})
-	if err == nil {
-		t.Fatal("Test_StaticPool_AllocateTimeout exec should raise error")
-	}
-	assert.Contains(t, err.Error(), "worker timeout")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
 
-	<-done
-	p.Destroy()
+	_, err = p.Allocate(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	p.Release(w, false)
+
+	// worker which has not been allocated is ignored
+	p.Release(p.Workers()[0], false)
+	assert.Equal(t, 1, p.Stats().NumIdle)
 }
 
-func Test_StaticPool_Replace_Worker(t *testing.T) {
+func Test_StaticPool_ReloadWorker(t *testing.T) {
 	p, err := NewPool(
 		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "pid", "pipes") },
 		NewPipeFactory(),
 		Config{
-			NumWorkers:      1,
-			MaxJobs:         1,
+			NumWorkers:      2,
 			AllocateTimeout: time.Second,
 			DestroyTimeout:  time.Second,
 		},
@@ -266,34 +546,2573 @@ func Test_StaticPool_Replace_Worker(t *testing.T) {
 	assert.NoError(t, err)
 	defer p.Destroy()
 
-	assert.NotNil(t, p)
+	reloaded := make(chan WorkerReload, 1)
+	p.Listen(func(event int, ctx interface{}) {
+		if event == EventWorkerReload {
+			reloaded <- ctx.(WorkerReload)
+		}
+	})
 
-	var lastPID string
-	lastPID = strconv.Itoa(*p.Workers()[0].Pid)
+	oldest := p.Workers()[0]
+	assert.NoError(t, p.ReloadWorker())
 
-	res, _ := p.Exec(&Payload{Body: []byte("hello")})
+	r := <-reloaded
+	assert.Equal(t, oldest, r.Old)
+	assert.NotEqual(t, oldest, r.New)
+
+	<-oldest.waitDone
+	time.Sleep(time.Millisecond * 100)
+
+	assert.Len(t, p.Workers(), 2)
+	assert.NotContains(t, p.Workers(), oldest)
+	assert.Contains(t, p.Workers(), r.New)
+}
+
+func Test_StaticPool_ReloadWorker_Busy(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "delay", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      1,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	w := p.Workers()[0]
+
+	done := make(chan interface{})
+	go func() {
+		_, err := p.Exec(&Payload{Body: []byte("100")})
+		assert.NoError(t, err)
+		close(done)
+	}()
+
+	// to ensure that worker is already busy
+	time.Sleep(time.Millisecond * 10)
+	assert.NoError(t, p.ReloadWorker())
+
+	// replacement is serving while old worker completes the task
+	_, err = p.Exec(&Payload{Body: []byte("10")})
+	assert.NoError(t, err)
+
+	<-done
+	<-w.waitDone
+	time.Sleep(time.Millisecond * 100)
+
+	assert.Len(t, p.Workers(), 1)
+	assert.NotEqual(t, w, p.Workers()[0])
+}
+
+func Test_StaticPool_ReloadAll(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "echo", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      3,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	var mu sync.Mutex
+	var retired []*Worker
+	p.Listen(func(event int, ctx interface{}) {
+		if event == EventWorkerReload {
+			mu.Lock()
+			retired = append(retired, ctx.(WorkerReload).Old)
+			mu.Unlock()
+		}
+	})
+
+	workers := p.Workers()
+	assert.NoError(t, p.ReloadAll(time.Millisecond*10))
+
+	mu.Lock()
+	assert.ElementsMatch(t, workers, retired)
+	mu.Unlock()
+
+	for _, w := range workers {
+		<-w.waitDone
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	assert.Len(t, p.Workers(), 3)
+	for _, w := range workers {
+		assert.NotContains(t, p.Workers(), w)
+	}
+
+	res, err := p.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", res.String())
+}
+
+func Test_StaticPool_RoundRobin(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "pid", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:        4,
+			AllocateTimeout:   time.Second,
+			DestroyTimeout:    time.Second,
+			SelectionStrategy: SelectRoundRobin,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	served := make(map[string]int)
+	for i := 0; i < 20; i++ {
+		res, err := p.Exec(&Payload{Body: []byte("hello")})
+		assert.NoError(t, err)
+		served[res.String()]++
+	}
+
+	assert.Len(t, served, 4)
+	for _, n := range served {
+		assert.Equal(t, 5, n)
+	}
+}
+
+func Test_StaticPool_LeastUsed(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "pid", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:        3,
+			AllocateTimeout:   time.Second,
+			DestroyTimeout:    time.Second,
+			SelectionStrategy: SelectLeastUsed,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	for i := 0; i < 9; i++ {
+		_, err := p.Exec(&Payload{Body: []byte("hello")})
+		assert.NoError(t, err)
+	}
+
+	for _, w := range p.Workers() {
+		assert.Equal(t, int64(3), w.State().NumExecs())
+	}
+}
+
+// lowestPID selects candidate with the lowest PID.
+type lowestPID struct {
+	mu         sync.Mutex
+	candidates []int
+}
+
+func (s *lowestPID) Select(candidates []*Worker) *Worker {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.candidates = append(s.candidates, len(candidates))
+
+	selected := candidates[0]
+	for _, w := range candidates {
+		if *w.Pid < *selected.Pid {
+			selected = w
+		}
+	}
+
+	return selected
+}
+
+// fixedSelector always returns the same worker.
+type fixedSelector struct {
+	w *Worker
+}
+
+func (s fixedSelector) Select(candidates []*Worker) *Worker {
+	return s.w
+}
+
+func Test_StaticPool_Selector(t *testing.T) {
+	selector := &lowestPID{}
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "pid", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:        3,
+			AllocateTimeout:   time.Second,
+			DestroyTimeout:    time.Second,
+			SelectionStrategy: SelectRoundRobin,
+			Selector:          selector,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	lowest := p.Workers()[0]
+	for _, w := range p.Workers() {
+		if *w.Pid < *lowest.Pid {
+			lowest = w
+		}
+	}
+
+	for i := 0; i < 9; i++ {
+		res, err := p.Exec(&Payload{Body: []byte("hello")})
+		assert.NoError(t, err)
+		assert.Equal(t, strconv.Itoa(*lowest.Pid), res.String())
+	}
+
+	assert.Equal(t, int64(9), lowest.State().NumExecs())
+
+	selector.mu.Lock()
+	defer selector.mu.Unlock()
+	assert.Len(t, selector.candidates, 9)
+	for _, n := range selector.candidates {
+		assert.Equal(t, 3, n)
+	}
+}
+
+func Test_StaticPool_Selector_Unknown(t *testing.T) {
+	candidates := []*Worker{syntheticWorker(1), syntheticWorker(2)}
+
+	p := &StaticPool{cfg: Config{Selector: fixedSelector{syntheticWorker(3)}}}
+	assert.Equal(t, candidates[0], p.selectCandidate(candidates))
+
+	p = &StaticPool{cfg: Config{Selector: fixedSelector{}}}
+	assert.Equal(t, candidates[0], p.selectCandidate(candidates))
+
+	p = &StaticPool{cfg: Config{Selector: fixedSelector{candidates[1]}}}
+	assert.Equal(t, candidates[1], p.selectCandidate(candidates))
+}
+
+func Test_StaticPool_MaxQueueSize(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "delay", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      1,
+			MaxQueueSize:    1,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := p.Exec(&Payload{Body: []byte("100")})
+			assert.NoError(t, err)
+		}()
+
+		// to ensure that task is already running or queued
+		time.Sleep(time.Millisecond * 20)
+	}
+
+	assert.Equal(t, 1, p.Stats().Queued)
+
+	_, err = p.Exec(&Payload{Body: []byte("100")})
+	assert.Error(t, err)
+	assert.Equal(t, ErrQueueFull, errors.Cause(err))
+
+	wg.Wait()
+	assert.Equal(t, 0, p.Stats().Queued)
+}
+
+func Test_StaticPool_Fairness(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "delay", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      1,
+			AllocateTimeout: time.Second * 10,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		order []int
+	)
+
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			// short context waiters are interleaved with the long ones
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+			if i%2 == 1 {
+				ctx, cancel = context.WithTimeout(context.Background(), time.Second*2)
+			}
+			defer cancel()
+
+			w, err := p.Allocate(ctx)
+			if !assert.NoError(t, err, "waiter %v starved", i) {
+				return
+			}
+
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+
+			_, err = w.Exec(&Payload{Body: []byte("20")})
+			assert.NoError(t, err)
+			p.Release(w, false)
+		}(i)
+
+		// to ensure that waiters are queued in order
+		time.Sleep(time.Millisecond * 5)
+	}
+
+	wg.Wait()
+
+	assert.Len(t, order, 20)
+	for i, v := range order {
+		assert.Equal(t, i, v)
+	}
+}
+
+func Test_StaticPool_ExecPriority(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "delay", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      1,
+			AllocateTimeout: time.Second * 10,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	go func() {
+		_, err := p.Exec(&Payload{Body: []byte("200")})
+		assert.NoError(t, err)
+	}()
+
+	// to ensure that worker is already busy
+	time.Sleep(time.Millisecond * 20)
+
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		order []int
+	)
+
+	priorities := []int{PriorityLow, PriorityNormal, PriorityHigh, PriorityLow, PriorityHigh}
+	for _, priority := range priorities {
+		wg.Add(1)
+		go func(priority int) {
+			defer wg.Done()
+
+			_, err := p.ExecPriority(&Payload{Body: []byte("10")}, priority)
+			assert.NoError(t, err)
+
+			mu.Lock()
+			order = append(order, priority)
+			mu.Unlock()
+		}(priority)
+
+		// to ensure that tasks are queued in order
+		time.Sleep(time.Millisecond * 5)
+	}
+
+	assert.Equal(t, [NumPriorities]int{2, 1, 2}, p.Stats().QueuedByPriority)
+
+	wg.Wait()
+	assert.Equal(t, []int{PriorityHigh, PriorityHigh, PriorityNormal, PriorityLow, PriorityLow}, order)
+	assert.Equal(t, [NumPriorities]int{}, p.Stats().QueuedByPriority)
+}
+
+func Test_StaticPool_PriorityAging(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "delay", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      1,
+			PriorityAging:   time.Millisecond * 50,
+			AllocateTimeout: time.Second * 10,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	go func() {
+		_, err := p.Exec(&Payload{Body: []byte("20")})
+		assert.NoError(t, err)
+	}()
+
+	// to ensure that worker is already busy
+	time.Sleep(time.Millisecond * 10)
+
+	low := make(chan interface{})
+	go func() {
+		defer close(low)
+
+		_, err := p.ExecPriority(&Payload{Body: []byte("20")}, PriorityLow)
+		assert.NoError(t, err)
+	}()
+
+	// high priority tasks keep the queue busy, low priority task must be promoted
+	var highs int64
+	deadline := time.After(time.Second * 5)
+	for {
+		select {
+		case <-low:
+			assert.True(t, atomic.LoadInt64(&highs) > 1, "low priority task served before promotion")
+			return
+		case <-deadline:
+			t.Fatal("low priority task starved")
+		default:
+		}
+
+		if p.Stats().QueuedByPriority[PriorityHigh] < 2 {
+			go func() {
+				_, err := p.ExecPriority(&Payload{Body: []byte("20")}, PriorityHigh)
+				assert.NoError(t, err)
+				atomic.AddInt64(&highs, 1)
+			}()
+		}
+
+		time.Sleep(time.Millisecond * 5)
+	}
+}
+
+func Test_StaticPool_MaxWait(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "delay", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      1,
+			MaxWait:         time.Millisecond * 50,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	go func() {
+		_, err := p.Exec(&Payload{Body: []byte("200")})
+		assert.NoError(t, err)
+	}()
+
+	// to ensure that worker is already busy
+	time.Sleep(time.Millisecond * 20)
+
+	_, err = p.Allocate(context.Background())
+	assert.Equal(t, ErrAllocTimeout, errors.Cause(err))
+	assert.Equal(t, 0, p.Stats().Queued)
+}
+
+func Test_StaticPool_MaxAge(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "pid", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      1,
+			MaxAge:          time.Millisecond * 200,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	w := p.Workers()[0]
+
+	// idle worker is replaced by the sweeper
+	<-w.waitDone
+	time.Sleep(time.Millisecond * 100)
+
+	assert.Len(t, p.Workers(), 1)
+	assert.NotEqual(t, w, p.Workers()[0])
+
+	res, err := p.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, strconv.Itoa(*p.Workers()[0].Pid), res.String())
+}
+
+func Test_StaticPool_MaxAge_Short(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "echo", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      1,
+			MaxAge:          time.Nanosecond,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	// sweeper interval is clamped instead of the ticker panic
+	res, err := p.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", res.String())
+
+	for i := 0; i < 100 && p.Stats().RecycleReasons[RecycleMaxAge] == 0; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	assert.NotEqual(t, int64(0), p.Stats().RecycleReasons[RecycleMaxAge])
+}
+
+// waitRolled waits until n workers are replaced by the rolling replacement.
+func waitRolled(t *testing.T, p *StaticPool, n int64) {
+	deadline := time.Now().Add(time.Second * 5)
+	for p.Stats().RecycleReasons[RecycleRolling] < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%v workers are not replaced", n)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func Test_StaticPool_RollingReplace(t *testing.T) {
+	clock := newMockClock()
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "echo", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:             4,
+			RollingReplaceInterval: time.Minute * 4,
+			Clock:                  clock,
+			AllocateTimeout:        time.Second,
+			DestroyTimeout:         time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	assert.Equal(t, time.Minute, p.Stats().RollingReplaceCadence)
+
+	original := p.Workers()
+	for i := range original {
+		assert.True(t, clock.WaitTimers(1))
+
+		// no replacement until the cadence elapses
+		clock.Advance(time.Second * 30)
+		time.Sleep(time.Millisecond * 50)
+		assert.Equal(t, int64(i), p.Stats().RecycleReasons[RecycleRolling])
+
+		// single worker is replaced per cadence
+		clock.Advance(time.Second * 30)
+		waitRolled(t, p, int64(i+1))
+		assert.Equal(t, int64(i+1), p.Stats().RecycleReasons[RecycleRolling])
+	}
+
+	// every original worker has been replaced once
+	for _, w := range original {
+		<-w.waitDone
+		assert.NotContains(t, p.Workers(), w)
+	}
+	assert.Len(t, p.Workers(), 4)
+
+	res, err := p.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", res.String())
+}
+
+func Test_StaticPool_Probe(t *testing.T) {
+	clock := newMockClock()
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "probe", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      1,
+			ProbeInterval:   time.Second,
+			ProbePayload:    []byte("ping"),
+			ProbeResponse:   []byte("pong"),
+			Clock:           clock,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	w := p.Workers()[0]
+	for i := 1; i <= 3; i++ {
+		assert.True(t, clock.WaitTimers(1))
+		clock.Advance(time.Second)
+
+		for j := 0; j < 100 && w.State().NumExecs() != int64(i); j++ {
+			time.Sleep(time.Millisecond * 10)
+		}
+		assert.Equal(t, int64(i), w.State().NumExecs())
+	}
+	assert.Equal(t, []*Worker{w}, p.Workers())
+
+	// runtime breaks
+	assert.True(t, clock.WaitTimers(1))
+	clock.Advance(time.Second)
+
+	<-w.waitDone
+	time.Sleep(time.Millisecond * 100)
+
+	assert.NotContains(t, p.Workers(), w)
+	assert.Equal(t, int64(1), p.Stats().RecycleReasons[RecycleProbe])
+
+	res, err := p.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", res.String())
+}
+
+func Test_StaticPool_Probe_Validator(t *testing.T) {
+	clock := newMockClock()
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "echo", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:    1,
+			ProbeInterval: time.Second,
+			ProbePayload:  []byte("ping"),
+			ProbeValidator: func(rsp *Payload) error {
+				return errors.New("unexpected response")
+			},
+			Clock:           clock,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	w := p.Workers()[0]
+	assert.True(t, clock.WaitTimers(1))
+	clock.Advance(time.Second)
+
+	<-w.waitDone
+	time.Sleep(time.Millisecond * 100)
+
+	assert.NotContains(t, p.Workers(), w)
+	assert.Equal(t, int64(1), p.Stats().RecycleReasons[RecycleProbe])
+}
+
+func Test_StaticPool_ResyncTimeout(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "delay", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      1,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+			ExecTimeout:     time.Millisecond * 50,
+			ResyncTimeout:   time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	pid := *p.Workers()[0].Pid
+
+	_, err = p.Exec(&Payload{Body: []byte("200")})
+	assert.Equal(t, ErrExecTimeout, err)
+
+	// worker is resynced instead of the replacement
+	res, err := p.Exec(&Payload{Body: []byte("0")})
+	assert.NoError(t, err)
+	assert.NotNil(t, res)
+	assert.Equal(t, pid, *p.Workers()[0].Pid)
+	assert.Equal(t, int64(0), p.Stats().RecycleReasons[RecycleTimeout])
+}
+
+func Test_StaticPool_ExecAsync(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "delay", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      1,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	start := time.Now()
+	assert.NoError(t, p.ExecAsync(&Payload{Body: []byte("300")}))
+	assert.True(t, time.Since(start) < time.Millisecond*200)
+
+	// worker completing the async task is not allocated
+	assert.Equal(t, 1, p.Stats().NumBusy)
+	_, acquired, err := p.TryExec(&Payload{Body: []byte("0")})
+	assert.NoError(t, err)
+	assert.False(t, acquired)
+
+	res, err := p.Exec(&Payload{Body: []byte("0")})
+	assert.NoError(t, err)
+	assert.NotNil(t, res)
+	assert.True(t, time.Since(start) >= time.Millisecond*300)
+	assert.Equal(t, int64(2), p.Stats().TotalExecs)
+}
+
+func Test_StaticPool_ExecAsync_Timeout(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "delay", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      1,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+			ExecTimeout:     time.Millisecond * 100,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	pid := *p.Workers()[0].Pid
+	assert.NoError(t, p.ExecAsync(&Payload{Body: []byte("1000")}))
+
+	// worker is killed and replaced once task exceeds the timeout
+	res, err := p.Exec(&Payload{Body: []byte("0")})
+	assert.NoError(t, err)
+	assert.NotNil(t, res)
+	assert.NotEqual(t, pid, *p.Workers()[0].Pid)
+	assert.Equal(t, int64(1), p.Stats().TotalErrors)
+}
+
+func Test_StaticPool_Watchdog(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "delay", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      2,
+			AllocateTimeout: time.Second * 10,
+			DestroyTimeout:  time.Second,
+			Watchdog:        true,
+			WatchdogTimeout: time.Millisecond * 400,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	wedged := make(chan interface{}, 10)
+	p.Listen(func(event int, ctx interface{}) {
+		if event == EventPoolError {
+			wedged <- ctx
+		}
+	})
+
+	previous := map[int]bool{}
+	for _, w := range p.Workers() {
+		previous[*w.Pid] = true
+	}
+
+	// all workers are stuck
+	stuck := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := p.Exec(&Payload{Body: []byte("60000")})
+			stuck <- err
+		}()
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	// queued task is served by the new workers
+	res, err := p.Exec(&Payload{Body: []byte("0")})
+	assert.NoError(t, err)
+	assert.NotNil(t, res)
+
+	assert.Error(t, <-stuck)
+	assert.Error(t, <-stuck)
+
+	select {
+	case ctx := <-wedged:
+		assert.Contains(t, ctx.(error).Error(), "pool is wedged")
+	default:
+		t.Fatal("wedged pool must be reported")
+	}
+
+	// killed workers are removed in background
+	for i := 0; i < 100 && len(p.Workers()) != 2; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	assert.Len(t, p.Workers(), 2)
+	for _, w := range p.Workers() {
+		assert.False(t, previous[*w.Pid])
+	}
+}
+
+func Test_StaticPool_Watchdog_Busy(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "delay", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      1,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+			Watchdog:        true,
+			WatchdogTimeout: time.Millisecond * 200,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	pid := *p.Workers()[0].Pid
+
+	// long task without queued tasks does not wedge the pool
+	_, err = p.Exec(&Payload{Body: []byte("600")})
+	assert.NoError(t, err)
+	assert.Equal(t, pid, *p.Workers()[0].Pid)
+}
+
+func Test_StaticPool_DebugSingleWorker(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "pid", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      3,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	p.SetDebugSingleWorker(true)
+
+	var (
+		mu   sync.Mutex
+		pids = map[string]bool{}
+		wg   sync.WaitGroup
+	)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			res, err := p.Exec(&Payload{Body: []byte("hello")})
+			if assert.NoError(t, err) {
+				mu.Lock()
+				pids[res.String()] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Len(t, pids, 1)
+	assert.Len(t, p.Workers(), 3)
+
+	// normal dispatch is resumed
+	p.SetDebugSingleWorker(false)
+
+	w, err := p.Allocate(context.Background())
+	assert.NoError(t, err)
+
+	res, err := p.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.NotEqual(t, strconv.Itoa(*w.Pid), res.String())
+
+	p.Release(w, false)
+}
+
+func Test_StaticPool_DebugSingleWorker_Wait(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "delay", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      2,
+			AllocateTimeout: time.Millisecond * 200,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	p.SetDebugSingleWorker(true)
+
+	done := make(chan interface{})
+	go func() {
+		defer close(done)
+
+		_, err := p.Exec(&Payload{Body: []byte("500")})
+		assert.NoError(t, err)
+	}()
+	time.Sleep(time.Millisecond * 50)
+
+	// waiting for the turn is canceled with the context
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+
+	_, err = p.ExecContext(ctx, &Payload{Body: []byte("10")})
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	// and limited by AllocateTimeout
+	_, err = p.Exec(&Payload{Body: []byte("10")})
+	assert.Error(t, err)
+	assert.True(t, errors.Is(err, ErrAllocTimeout))
+
+	<-done
+}
+
+func Test_StaticPool_TryExec(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "delay", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      1,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	_, acquired, err := p.TryExec(&Payload{Body: []byte("10")})
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+
+	done := make(chan interface{})
+	go func() {
+		_, err := p.Exec(&Payload{Body: []byte("100")})
+		assert.NoError(t, err)
+		close(done)
+	}()
+
+	// to ensure that worker is already busy
+	time.Sleep(time.Millisecond * 10)
+
+	rsp, acquired, err := p.TryExec(&Payload{Body: []byte("10")})
+	assert.NoError(t, err)
+	assert.False(t, acquired)
+	assert.Nil(t, rsp)
+
+	<-done
+	assert.Equal(t, int64(2), p.Stats().TotalExecs)
+}
+
+func Test_StaticPool_Reset(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "echo", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      2,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	previous := p.Workers()
+
+	err = p.Reset(func(wc WorkerConfig) *exec.Cmd {
+		return exec.Command("php", "tests/client.php", "pid", "pipes")
+	}, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), p.Config().NumWorkers)
+
+	for _, w := range previous {
+		<-w.waitDone
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	assert.Len(t, p.Workers(), 3)
+
+	res, err := p.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.NotEqual(t, "hello", res.String())
+}
+
+func Test_StaticPool_Reset_Error(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "echo", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      2,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	previous := p.Workers()
+
+	err = p.Reset(func(wc WorkerConfig) *exec.Cmd {
+		return exec.Command("php", "tests/client.php", "failboot", "pipes")
+	}, 2)
+	assert.Error(t, err)
+
+	assert.ElementsMatch(t, previous, p.Workers())
+	assert.Equal(t, int64(2), p.Config().NumWorkers)
+
+	res, err := p.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", res.String())
+}
+
+func Test_StaticPool_Resize_Grow(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "pid", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      2,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	resized := make(chan ResizeReport, 1)
+	p.Listen(func(event int, ctx interface{}) {
+		if event == EventPoolResize {
+			resized <- ctx.(ResizeReport)
+		}
+	})
+
+	previous := p.Workers()
+
+	assert.NoError(t, p.Resize(4))
+	assert.Equal(t, ResizeReport{Size: 4, Added: 2}, <-resized)
+	assert.Equal(t, int64(4), p.Config().NumWorkers)
+
+	// previous workers keep serving
+	workers := p.Workers()
+	assert.Len(t, workers, 4)
+	assert.Subset(t, workers, previous)
+
+	// all workers are in rotation
+	allocated := make([]*Worker, 0, 4)
+	for i := 0; i < 4; i++ {
+		w, err := p.Allocate(context.Background())
+		assert.NoError(t, err)
+		allocated = append(allocated, w)
+	}
+
+	assert.ElementsMatch(t, workers, allocated)
+	for _, w := range allocated {
+		p.Release(w, false)
+	}
+}
+
+func Test_StaticPool_Resize_ShrinkIdle(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "pid", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      3,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	resized := make(chan ResizeReport, 1)
+	p.Listen(func(event int, ctx interface{}) {
+		if event == EventPoolResize {
+			resized <- ctx.(ResizeReport)
+		}
+	})
+
+	var kept *Worker
+	p.muw.RLock()
+	for _, w := range p.workers {
+		if p.index[w] == 0 {
+			kept = w
+		}
+	}
+	p.muw.RUnlock()
+
+	assert.NoError(t, p.Resize(1))
+	assert.Equal(t, ResizeReport{Size: 1, Removed: 2}, <-resized)
+	assert.Equal(t, int64(1), p.Config().NumWorkers)
+
+	// retired workers are not replaced
+	time.Sleep(time.Millisecond * 100)
+	assert.Equal(t, []*Worker{kept}, p.Workers())
+	assert.Equal(t, int64(2), p.Stats().RecycleReasons[RecycleResize])
+
+	res, err := p.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, strconv.Itoa(*kept.Pid), res.String())
+}
+
+func Test_StaticPool_Resize_ShrinkBusy(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "delay", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      2,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second * 2,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	done := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := p.Exec(&Payload{Body: []byte("500")})
+			done <- err
+		}()
+	}
+
+	for i := 0; i < 100 && p.Stats().NumBusy != 2; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	// resize waits for the busy worker to complete its task
+	start := time.Now()
+	assert.NoError(t, p.Resize(1))
+	assert.True(t, time.Since(start) >= time.Millisecond*300)
+
+	assert.NoError(t, <-done)
+	assert.NoError(t, <-done)
+
+	for i := 0; i < 100 && len(p.Workers()) != 1; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	assert.Len(t, p.Workers(), 1)
+	assert.Equal(t, int64(1), p.Stats().RecycleReasons[RecycleResize])
+}
+
+func Test_StaticPool_Resize_ShrinkBusy_Deadline(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "delay", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      2,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Millisecond * 200,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	done := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := p.Exec(&Payload{Body: []byte("800")})
+			done <- err
+		}()
+	}
+
+	for i := 0; i < 100 && p.Stats().NumBusy != 2; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	err = p.Resize(1)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "1 retired workers are still busy")
+
+	// busy worker is never killed, it's retired once task is complete
+	assert.NoError(t, <-done)
+	assert.NoError(t, <-done)
+
+	for i := 0; i < 100 && len(p.Workers()) != 1; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	assert.Len(t, p.Workers(), 1)
+	assert.Equal(t, int64(1), p.Config().NumWorkers)
+}
+
+func Test_StaticPool_SetCommand(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "echo", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      1,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	p.SetCommand(func(wc WorkerConfig) *exec.Cmd {
+		return exec.Command("php", "tests/client.php", "pid", "pipes")
+	})
+
+	// running worker keeps the old command
+	res, err := p.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", res.String())
+
+	assert.NoError(t, p.ReloadAll(time.Millisecond*10))
+
+	res, err = p.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, strconv.Itoa(*p.Workers()[0].Pid), res.String())
+}
+
+func Test_StaticPool_OnWorkerDeath(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "broken", "pipes") },
+		NewPipeFactory(),
+		cfg,
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	type death struct {
+		pid int
+		err error
+	}
+
+	dead := make(chan death, 1)
+	p.OnWorkerDeath(func(pid int, err error) {
+		dead <- death{pid: pid, err: err}
+	})
+
+	w := p.Workers()[0]
+
+	_, err = p.Exec(&Payload{Body: []byte("hello")})
+	assert.Error(t, err)
+
+	d := <-dead
+	assert.Equal(t, *w.Pid, d.pid)
+
+	wErr, ok := d.err.(WaitError)
+	assert.True(t, ok)
+	assert.NotEqual(t, 0, wErr.Code)
+}
+
+func Test_StaticPool_OnWorkerDeath_Recycle(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "echo", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      1,
+			MaxJobs:         1,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	assert.NoError(t, err)
+
+	dead := make(chan int, 10)
+	p.OnWorkerDeath(func(pid int, err error) {
+		dead <- pid
+	})
+
+	for i := 0; i < 3; i++ {
+		_, err := p.Exec(&Payload{Body: []byte("hello")})
+		assert.NoError(t, err)
+	}
+
+	assert.NoError(t, p.ReloadWorker())
+	p.Destroy()
+
+	assert.Len(t, dead, 0)
+}
+
+func Test_StaticPool_Healthy(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "echo", "pipes") },
+		NewPipeFactory(),
+		cfg,
+	)
+	assert.NoError(t, err)
+
+	ok, err := p.Healthy()
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	assert.Len(t, p.Workers(), int(cfg.NumWorkers))
+
+	p.Destroy()
+
+	ok, err = p.Healthy()
+	assert.Equal(t, ErrPoolDestroyed, err)
+	assert.False(t, ok)
+}
+
+func Test_StaticPool_Release_Broken(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "pid", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      1,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	w, err := p.Allocate(context.Background())
+	assert.NoError(t, err)
+	p.Release(w, true)
+
+	res, err := p.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.NotEqual(t, strconv.Itoa(*w.Pid), res.String())
+}
+
+func Test_StaticPool_Broken_Replace(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "broken", "pipes") },
+		NewPipeFactory(),
+		cfg,
+	)
+	assert.NoError(t, err)
+	assert.NotNil(t, p)
+
+	done := make(chan interface{})
+
+	p.Listen(func(e int, ctx interface{}) {
+		if err, ok := ctx.(error); ok {
+			if strings.Contains(err.Error(), "undefined_function()") {
+				close(done)
+			}
+		}
+	})
+
+	res, err := p.Exec(&Payload{Body: []byte("hello")})
+
+	assert.Error(t, err)
+	assert.Nil(t, res)
+
+	<-done
+	p.Destroy()
+}
+
+
+func Test_StaticPool_Logger(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "echo", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      1,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	log := &testLogger{}
+	p.SetLogger(log)
+
+	replaced := make(chan interface{})
+	p.Listen(func(e int, ctx interface{}) {
+		if e == EventWorkerConstruct {
+			close(replaced)
+		}
+	})
+
+	assert.NoError(t, p.Workers()[0].Kill())
+	<-replaced
+
+	assert.Contains(t, log.Messages(), "worker died")
+}
+
+func Test_StaticPool_SlowLog(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "delay", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:       1,
+			AllocateTimeout:  time.Second,
+			DestroyTimeout:   time.Second,
+			SlowLogThreshold: time.Millisecond * 100,
+			SlowLogQueueWait: true,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	log := &testLogger{}
+	p.SetLogger(log)
+
+	_, err = p.Exec(&Payload{Body: []byte("10")})
+	assert.NoError(t, err)
+	assert.Len(t, log.Messages(), 0)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := p.Exec(&Payload{Body: []byte("200")})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	// second task waits for the first one
+	assert.Equal(t, []string{"slow exec", "slow queue wait", "slow exec"}, log.Messages())
+}
+
+func Test_StaticPool_Broken_FromOutside(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "echo", "pipes") },
+		NewPipeFactory(),
+		cfg,
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	assert.NotNil(t, p)
+
+	res, err := p.Exec(&Payload{Body: []byte("hello")})
+
+	assert.NoError(t, err)
+	assert.NotNil(t, res)
+	assert.NotNil(t, res.Body)
+	assert.Nil(t, res.Context)
+
+	assert.Equal(t, "hello", res.String())
+	assert.Equal(t, runtime.NumCPU(), len(p.Workers()))
+
+	destructed := make(chan interface{})
+	p.Listen(func(e int, ctx interface{}) {
+		if e == EventWorkerConstruct {
+			destructed <- nil
+		}
+	})
+
+	// killing random worker and expecting pool to replace it
+	err = p.Workers()[0].cmd.Process.Kill()
+	if err != nil {
+		t.Errorf("error killing the process: error %v", err)
+	}
+	<-destructed
+
+	for _, w := range p.Workers() {
+		assert.Equal(t, StateReady, w.state.Value())
+	}
+}
+
+func Test_StaticPool_AllocateTimeout(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "delay", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      1,
+			AllocateTimeout: time.Nanosecond * 1,
+			DestroyTimeout:  time.Second * 2,
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan interface{})
+	go func() {
+		if p != nil {
+			_, err := p.Exec(&Payload{Body: []byte("100")})
+			assert.NoError(t, err)
+			close(done)
+		} else {
+			panic("Pool is nil")
+		}
+	}()
+
+
+	// to ensure that worker is already busy
+	time.Sleep(time.Millisecond * 10)
+
+	_, err = p.Exec(&Payload{Body: []byte("10")})
+	if err == nil {
+		t.Fatal("Test_StaticPool_AllocateTimeout exec should raise error")
+	}
+	assert.Contains(t, err.Error(), "worker timeout")
+
+	<-done
+	p.Destroy()
+}
+
+func Test_StaticPool_Replace_Worker(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "pid", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:       1,
+			MaxJobs:          1,
+			AllocateTimeout:  time.Second,
+			DestroyTimeout:   time.Second,
+			RecycleKillFirst: true,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	assert.NotNil(t, p)
+
+	var lastPID string
+	lastPID = strconv.Itoa(*p.Workers()[0].Pid)
+
+	res, _ := p.Exec(&Payload{Body: []byte("hello")})
+	assert.Equal(t, lastPID, string(res.Body))
+
+	for i := 0; i < 10; i++ {
+		res, err := p.Exec(&Payload{Body: []byte("hello")})
+
+		assert.NoError(t, err)
+		assert.NotNil(t, res)
+		assert.NotNil(t, res.Body)
+		assert.Nil(t, res.Context)
+
+		assert.NotEqual(t, lastPID, string(res.Body))
+		lastPID = string(res.Body)
+	}
+}
+
+func Test_StaticPool_MaxJobs_Rotate(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "pid", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:       1,
+			MaxJobs:          3,
+			AllocateTimeout:  time.Second,
+			DestroyTimeout:   time.Second,
+			RecycleKillFirst: true,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	var pids []string
+	for i := 0; i < 9; i++ {
+		res, err := p.Exec(&Payload{Body: []byte("hello")})
+		assert.NoError(t, err)
+		assert.NotNil(t, res)
+
+		pids = append(pids, string(res.Body))
+	}
+
+	for i := 0; i < 9; i += 3 {
+		assert.Equal(t, pids[i], pids[i+1])
+		assert.Equal(t, pids[i], pids[i+2])
+
+		if i > 0 {
+			assert.NotEqual(t, pids[i-1], pids[i])
+		}
+	}
+}
+
+func Test_StaticPool_MaxMemory(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "pid", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:       1,
+			MaxMemory:        1,
+			AllocateTimeout:  time.Second,
+			DestroyTimeout:   time.Second,
+			RecycleKillFirst: true,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	// php process always consumes more than 1MB
+	var lastPID string
+	for i := 0; i < 3; i++ {
+		res, err := p.Exec(&Payload{Body: []byte("hello")})
+		assert.NoError(t, err)
+		assert.NotNil(t, res)
+
+		assert.NotEqual(t, lastPID, string(res.Body))
+		lastPID = string(res.Body)
+	}
+}
+
+func Test_StaticPool_Recycle_Swap(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "pid", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      2,
+			MaxJobs:         1,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	min, done := watchCapacity(p)
+
+	pids := make(map[string]bool)
+	for i := 0; i < 10; i++ {
+		res, err := p.Exec(&Payload{Body: []byte("hello")})
+		assert.NoError(t, err)
+		pids[res.String()] = true
+	}
+
+	// recycled workers are stopped once replacements are ready
+	time.Sleep(time.Millisecond * 500)
+	close(done)
+
+	assert.True(t, len(pids) > 1)
+	assert.Len(t, p.Workers(), 2)
+	assert.True(t, <-min >= 2)
+}
+
+func Test_StaticPool_Recycle_Swap_Concurrent(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "pid", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      4,
+			MaxJobs:         1,
+			AllocateTimeout: time.Second * 5,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 10; j++ {
+				_, err := p.Exec(&Payload{Body: []byte("hello")})
+				assert.NoError(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// recycled workers leave the rotation before their replacements join it
+	for i := 0; i < 100 && len(p.Workers()) != 4; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	assert.Len(t, p.Workers(), 4)
+	assert.True(t, len(p.freeChan()) <= 4)
+
+	destroyed := make(chan interface{})
+	go func() {
+		p.Destroy()
+		close(destroyed)
+	}()
+
+	select {
+	case <-destroyed:
+	case <-time.After(time.Second * 10):
+		t.Fatal("pool destroy is blocked")
+	}
+}
+
+func Test_StaticPool_Recycle_KillFirst(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "pid", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:       2,
+			MaxJobs:          1,
+			AllocateTimeout:  time.Second,
+			DestroyTimeout:   time.Second,
+			RecycleKillFirst: true,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	min, done := watchCapacity(p)
+
+	_, err = p.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+
+	time.Sleep(time.Millisecond * 500)
+	close(done)
+
+	assert.Len(t, p.Workers(), 2)
+	assert.True(t, <-min < 2)
+}
+
+// watchCapacity samples number of pool workers until done is closed, min receives the lowest
+// observed number.
+func watchCapacity(p Pool) (min chan int, done chan interface{}) {
+	min, done = make(chan int, 1), make(chan interface{})
+	go func() {
+		lowest := len(p.Workers())
+		for {
+			select {
+			case <-done:
+				min <- lowest
+				return
+			case <-time.After(time.Millisecond):
+				if n := len(p.Workers()); n < lowest {
+					lowest = n
+				}
+			}
+		}
+	}()
+
+	return min, done
+}
+
+// identical to replace but controlled on worker side
+func Test_StaticPool_Stop_Worker(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "stop", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      1,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	assert.NotNil(t, p)
+
+	var lastPID string
+	lastPID = strconv.Itoa(*p.Workers()[0].Pid)
+
+	res, _ := p.Exec(&Payload{Body: []byte("hello")})
 	assert.Equal(t, lastPID, string(res.Body))
 
-	for i := 0; i < 10; i++ {
-		res, err := p.Exec(&Payload{Body: []byte("hello")})
+	for i := 0; i < 10; i++ {
+		res, err := p.Exec(&Payload{Body: []byte("hello")})
+
+		assert.NoError(t, err)
+		assert.NotNil(t, res)
+		assert.NotNil(t, res.Body)
+		assert.Nil(t, res.Context)
+
+		assert.NotEqual(t, lastPID, string(res.Body))
+		lastPID = string(res.Body)
+	}
+}
+
+// identical to replace but controlled on worker side
+func Test_Static_Pool_Destroy_And_Close(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "delay", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      1,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+		},
+	)
+
+	assert.NotNil(t, p)
+	assert.NoError(t, err)
+
+	p.Destroy()
+	_, err = p.Exec(&Payload{Body: []byte("100")})
+	assert.Error(t, err)
+}
+
+// identical to replace but controlled on worker side
+func Test_Static_Pool_Destroy_And_Close_While_Wait(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "delay", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      1,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+		},
+	)
+
+	assert.NotNil(t, p)
+	assert.NoError(t, err)
+
+	go func() {
+		_, err := p.Exec(&Payload{Body: []byte("100")})
+		if err != nil {
+			t.Errorf("error executing payload: error %v", err)
+		}
+
+	}()
+	time.Sleep(time.Millisecond * 10)
+
+	p.Destroy()
+	_, err = p.Exec(&Payload{Body: []byte("100")})
+	assert.Error(t, err)
+}
+
+func Test_Static_Pool_DestroyWithTimeout(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "delay", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      2,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	assert.NotNil(t, p)
+	assert.NoError(t, err)
+
+	go func() {
+		_, err := p.Exec(&Payload{Body: []byte("50")})
+		assert.NoError(t, err)
+	}()
+
+	go func() {
+		_, err := p.Exec(&Payload{Body: []byte("5000")})
+		assert.Error(t, err)
+	}()
+	time.Sleep(time.Millisecond * 10)
+
+	killed := p.DestroyWithTimeout(time.Millisecond * 500)
+	assert.Len(t, killed, 1)
+
+	_, err = p.Exec(&Payload{Body: []byte("100")})
+	assert.Equal(t, ErrPoolDestroyed, err)
+}
+
+func Test_StaticPool_ExecTimeout(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "delay", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      1,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+			ExecTimeout:     time.Millisecond * 100,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	pid := *p.Workers()[0].Pid
+
+	_, err = p.Exec(&Payload{Body: []byte("1000")})
+	assert.Equal(t, ErrExecTimeout, err)
+
+	// worker must be replaced
+	_, err = p.Exec(&Payload{Body: []byte("10")})
+	assert.NoError(t, err)
+	assert.NotEqual(t, pid, *p.Workers()[0].Pid)
+}
+
+func Test_StaticPool_Heartbeat(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "echo", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:        1,
+			AllocateTimeout:   time.Second,
+			DestroyTimeout:    time.Second,
+			HeartbeatInterval: time.Millisecond * 50,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	pid := *p.Workers()[0].Pid
+	time.Sleep(time.Millisecond * 200)
+
+	// responsive worker must be kept
+	res, err := p.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", res.String())
+	assert.Equal(t, pid, *p.Workers()[0].Pid)
+}
+
+// identical to replace but controlled on worker side
+func Test_Static_Pool_Handle_Dead(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "echo", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      5,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	assert.NotNil(t, p)
+
+	for _, w := range p.workers {
+		w.state.value = StateErrored
+	}
+
+	_, err = p.Exec(&Payload{Body: []byte("hello")})
+	assert.Error(t, err)
+}
+
+// identical to replace but controlled on worker side
+func Test_Static_Pool_Slow_Destroy(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/slow-destroy.php", "echo", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      5,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+		},
+	)
+
+	assert.NoError(t, err)
+	assert.NotNil(t, p)
+
+	p.Destroy()
+}
+
+func Test_StaticPool_ExecBatch(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "batch", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      1,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	rsp, errs := p.ExecBatch([]*Payload{{Body: []byte("hello")}, {Body: []byte("error")}})
+	assert.NoError(t, errs[0])
+	assert.Equal(t, "hello", rsp[0].String())
+	assert.Equal(t, JobError("item error"), errs[1])
+
+	assert.Equal(t, int64(2), p.Stats().TotalExecs)
+	assert.Equal(t, int64(1), p.Stats().TotalErrors)
+}
+
+func Benchmark_Pool_Allocate(b *testing.B) {
+	p, _ := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "echo", "pipes") },
+		NewPipeFactory(),
+		cfg,
+	)
+	defer p.Destroy()
+
+	for n := 0; n < b.N; n++ {
+		w, err := p.allocateWorker(context.Background())
+		if err != nil {
+			b.Fail()
+			log.Println(err)
+		}
+
+		p.free <- w
+	}
+}
+
+func Benchmark_Pool_Echo(b *testing.B) {
+	p, _ := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "echo", "pipes") },
+		NewPipeFactory(),
+		cfg,
+	)
+	defer p.Destroy()
+
+	for n := 0; n < b.N; n++ {
+		if _, err := p.Exec(&Payload{Body: []byte("hello")}); err != nil {
+			b.Fail()
+		}
+	}
+}
+
+func Benchmark_Pool_Echo_Batched(b *testing.B) {
+	p, _ := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "echo", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      int64(runtime.NumCPU()),
+			AllocateTimeout: time.Second * 100,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	defer p.Destroy()
+
+	var wg sync.WaitGroup
+	for i := 0; i < b.N; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := p.Exec(&Payload{Body: []byte("hello")}); err != nil {
+				b.Fail()
+				log.Println(err)
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+func Benchmark_Pool_ExecBatch(b *testing.B) {
+	p, _ := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "batch", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      1,
+			AllocateTimeout: time.Second * 100,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	defer p.Destroy()
+
+	rqs := make([]*Payload, 100)
+	for i := range rqs {
+		rqs[i] = &Payload{Body: []byte("hello")}
+	}
+
+	b.Run("batch", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			if _, errs := p.ExecBatch(rqs); errs[0] != nil {
+				b.Fail()
+			}
+		}
+	})
+
+	b.Run("sequential", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			for _, rq := range rqs {
+				if _, err := p.Exec(rq); err != nil {
+					b.Fail()
+				}
+			}
+		}
+	})
+}
+
+func Benchmark_Pool_Echo_Replaced(b *testing.B) {
+	p, _ := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "echo", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      1,
+			MaxJobs:         1,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	defer p.Destroy()
+
+	for n := 0; n < b.N; n++ {
+		if _, err := p.Exec(&Payload{Body: []byte("hello")}); err != nil {
+			b.Fail()
+			log.Println(err)
+		}
+	}
+}
+
+func Test_StaticPool_Breaker(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "echo", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:       2,
+			AllocateTimeout:  time.Second,
+			DestroyTimeout:   time.Second,
+			BreakerThreshold: 1,
+			BreakerCooldown:  time.Millisecond * 100,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	err = p.Reset(func(wc WorkerConfig) *exec.Cmd {
+		return exec.Command("php", "tests/client.php", "failboot", "pipes")
+	}, 2)
+	assert.Error(t, err)
+	assert.Equal(t, BreakerOpen, p.Stats().Breaker)
+
+	_, err = p.Exec(&Payload{Body: []byte("hello")})
+	assert.Equal(t, ErrPoolUnavailable, err)
+
+	time.Sleep(time.Millisecond * 150)
+	assert.Equal(t, BreakerHalfOpen, p.Stats().Breaker)
+
+	res, err := p.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", res.String())
+}
+
+func Test_StaticPool_ExecRetries(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "delay", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      1,
+			MaxExecRetries:  2,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	// worker dies in the middle of the task
+	kill := func() {
+		time.Sleep(time.Millisecond * 100)
+		assert.NoError(t, p.Workers()[0].Kill())
+	}
+
+	go kill()
+	_, err = p.Exec(&Payload{Body: []byte("500")})
+	assert.Error(t, err)
+
+	go kill()
+	res, err := p.Exec(&Payload{Body: []byte("500"), Idempotent: true})
+	assert.NoError(t, err)
+	assert.NotNil(t, res)
+}
+
+func Test_StaticPool_ExecContext(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "delay", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      1,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	pid := *p.Workers()[0].Pid
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+
+	res, err := p.ExecContext(ctx, &Payload{Body: []byte("1000"), Idempotent: true})
+	assert.Nil(t, res)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	// worker is killed and replaced
+	res, err = p.Exec(&Payload{Body: []byte("10")})
+	assert.NoError(t, err)
+	assert.NotNil(t, res)
+	assert.NotEqual(t, pid, *p.Workers()[0].Pid)
+}
+
+func Test_StaticPool_ExecSticky(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "pid", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      4,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	res, err := p.ExecSticky("user-1", &Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	pid := res.String()
+
+	for i := 0; i < 10; i++ {
+		res, err = p.ExecSticky("user-1", &Payload{Body: []byte("hello")})
+		assert.NoError(t, err)
+		assert.Equal(t, pid, res.String())
+	}
+
+	// preferred worker is replaced
+	for _, w := range p.Workers() {
+		if strconv.Itoa(w.PID()) == pid {
+			assert.NoError(t, w.Kill())
+		}
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	res, err = p.ExecSticky("user-1", &Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.NotEqual(t, pid, res.String())
+	pid = res.String()
+
+	res, err = p.ExecSticky("user-1", &Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, pid, res.String())
+}
+
+func Test_StaticPool_RequestID(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "echo", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      1,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	// reused payload receives new ID every time and is not modified
+	rqs := &Payload{Body: []byte("hello")}
+	for i := 0; i < 2; i++ {
+		_, err = p.Exec(rqs)
+		assert.NoError(t, err)
+		assert.Equal(t, "", rqs.RequestID)
+	}
+
+	rqs = &Payload{Body: []byte("hello"), RequestID: "abc"}
+	_, err = p.Exec(rqs)
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", rqs.RequestID)
+
+	w := p.Workers()[0]
+	execs := w.Diagnostics().Execs
+	assert.Equal(t, "", w.RequestID())
+	assert.Len(t, execs[0].RequestID, 16)
+	assert.Len(t, execs[1].RequestID, 16)
+	assert.NotEqual(t, execs[0].RequestID, execs[1].RequestID)
+	assert.Equal(t, "abc", execs[2].RequestID)
+}
+
+func Test_StaticPool_SendRequestID(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "head", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      1,
+			SendRequestID:   true,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	// worker echoes the task context
+	rqs := &Payload{Context: []byte(`{"a":1}`), RequestID: "abc"}
+	res, err := p.Exec(rqs)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"request_id":"abc","a":1}`, string(res.Context))
+	assert.Equal(t, `{"a":1}`, string(rqs.Context))
+
+	res, err = p.Exec(&Payload{})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"request_id":"`+p.Workers()[0].Diagnostics().Execs[1].RequestID+`"}`, string(res.Context))
+
+	// other contexts are sent unchanged
+	res, err = p.Exec(&Payload{Context: []byte("raw"), RequestID: "abc"})
+	assert.NoError(t, err)
+	assert.Equal(t, "raw", string(res.Context))
+}
+
+func Test_StaticPool_Pause(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "delay", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      1,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	pid := *p.Workers()[0].Pid
+
+	// running task is completed
+	running := make(chan error)
+	go func() {
+		_, err := p.Exec(&Payload{Body: []byte("200")})
+		running <- err
+	}()
+	time.Sleep(time.Millisecond * 50)
+
+	p.Pause()
+	p.Pause()
+	assert.True(t, p.Stats().Paused)
+
+	held := make(chan error)
+	go func() {
+		_, err := p.Exec(&Payload{Body: []byte("10")})
+		held <- err
+	}()
+
+	assert.NoError(t, <-running)
+	select {
+	case <-held:
+		t.Fatal("task executed by the paused pool")
+	case <-time.After(time.Millisecond * 200):
+	}
+
+	_, acquired, err := p.TryExec(&Payload{Body: []byte("10")})
+	assert.False(t, acquired)
+	assert.NoError(t, err)
+
+	p.Resume()
+	p.Resume()
+	assert.NoError(t, <-held)
+	assert.False(t, p.Stats().Paused)
+
+	// same worker keeps serving
+	assert.Equal(t, pid, *p.Workers()[0].Pid)
+}
+
+func Test_StaticPool_Pause_Reject(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "echo", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:       1,
+			AllocateTimeout:  time.Second,
+			DestroyTimeout:   time.Second,
+			RejectWhenPaused: true,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	p.Pause()
+
+	_, err = p.Exec(&Payload{Body: []byte("hello")})
+	assert.Equal(t, ErrPoolPaused, err)
+
+	_, err = p.Allocate(context.Background())
+	assert.Equal(t, ErrPoolPaused, err)
+
+	p.Resume()
+
+	res, err := p.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", res.String())
+}
+
+func Test_StaticPool_Hijack(t *testing.T) {
+	ls, err := net.Listen("tcp", "localhost:9007")
+	if assert.NoError(t, err) {
+		defer ls.Close()
+	} else {
+		t.Skip("socket is busy")
+	}
+
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "echo", "tcp") },
+		NewSocketFactory(ls, time.Minute),
+		Config{
+			NumWorkers:      1,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	w, err := p.Allocate(context.Background())
+	assert.NoError(t, err)
+
+	conn, err := w.Hijack()
+	assert.NoError(t, err)
+	p.Release(w, false)
+
+	// replacement serves the tasks
+	res, err := p.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", res.String())
+	assert.Contains(t, p.Workers(), w)
+
+	assert.NoError(t, conn.Close())
+	<-w.waitDone
+	time.Sleep(time.Millisecond * 100)
+
+	assert.Len(t, p.Workers(), 1)
+	assert.NotContains(t, p.Workers(), w)
+}
+
+func Test_StaticPool_SetCommand_Socket(t *testing.T) {
+	ls, err := net.Listen("tcp", "localhost:9007")
+	if assert.NoError(t, err) {
+		defer ls.Close()
+	} else {
+		t.Skip("socket is busy")
+	}
+
+	f := NewSocketFactory(ls, time.Minute)
+	addr := f.Addr().String()
+
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "echo", "tcp") },
+		f,
+		Config{
+			NumWorkers:      2,
+			AllocateTimeout: time.Second * 5,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	var (
+		wg       sync.WaitGroup
+		stop     = make(chan interface{})
+		execs    int64
+		failures int64
+	)
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				if _, err := p.Exec(&Payload{Body: []byte("hello")}); err != nil {
+					t.Log(err)
+					atomic.AddInt64(&failures, 1)
+				}
+				atomic.AddInt64(&execs, 1)
+			}
+		}()
+	}
+
+	workers := p.Workers()
+
+	p.SetCommand(func(wc WorkerConfig) *exec.Cmd {
+		return exec.Command("php", "tests/client.php", "pid", "tcp")
+	})
+	assert.NoError(t, p.ReloadAll(time.Millisecond*10))
+
+	close(stop)
+	wg.Wait()
+
+	assert.Equal(t, int64(0), atomic.LoadInt64(&failures))
+	assert.True(t, atomic.LoadInt64(&execs) > 0)
+
+	// new workers connected to the same listener
+	assert.Equal(t, addr, f.Addr().String())
+	for _, w := range workers {
+		<-w.waitDone
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	assert.Len(t, p.Workers(), 2)
+	for _, w := range workers {
+		assert.NotContains(t, p.Workers(), w)
+	}
+
+	res, err := p.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.NotEqual(t, "hello", res.String())
+}
+
+func Test_StaticPool_Detach(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "pid", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      2,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	assert.NoError(t, err)
+
+	_, err = p.Detach(-1)
+	assert.Error(t, err)
+
+	w := p.Workers()[0]
+	pid := strconv.Itoa(*w.Pid)
+
+	detached, err := p.Detach(*w.Pid)
+	assert.NoError(t, err)
+	assert.Equal(t, w, detached)
+
+	// replacement keeps the capacity
+	assert.Len(t, p.Workers(), 2)
+	assert.NotContains(t, p.Workers(), w)
+
+	for i := 0; i < 10; i++ {
+		res, err := p.Exec(&Payload{Body: []byte("hello")})
+		assert.NoError(t, err)
+		assert.NotEqual(t, pid, res.String())
+	}
+
+	// detached worker outlives the pool
+	p.Destroy()
+
+	res, err := detached.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, pid, res.String())
+
+	assert.NoError(t, detached.Stop())
+}
+
+func Test_StaticPool_Detach_Busy(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "mux", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:        1,
+			WorkerConcurrency: 2,
+			AllocateTimeout:   time.Second,
+			DestroyTimeout:    time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	// worker responds once both tasks are received
+	done := make(chan interface{})
+	go func() {
+		defer close(done)
+
+		res, err := p.Exec(&Payload{Body: []byte("a")})
+		assert.NoError(t, err)
+		assert.Equal(t, "a", res.String())
+	}()
+	time.Sleep(time.Millisecond * 100)
+
+	// multiplexed worker with the task in flight stays in the pool
+	w := p.Workers()[0]
+	_, err = p.Detach(*w.Pid)
+	assert.Error(t, err)
+
+	res, err := p.Exec(&Payload{Body: []byte("b")})
+	assert.NoError(t, err)
+	assert.Equal(t, "b", res.String())
+	<-done
+
+	assert.Equal(t, []*Worker{w}, p.Workers())
+	assert.Equal(t, 1, p.Stats().NumIdle)
+}
+
+func Test_StaticPool_Workers_Copy(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "echo", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      2,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	workers := p.Workers()
+	assert.Len(t, workers, 2)
 
-		assert.NoError(t, err)
-		assert.NotNil(t, res)
-		assert.NotNil(t, res.Body)
-		assert.Nil(t, res.Context)
+	pid := workers[0].PID()
+	workers[0] = nil
 
-		assert.NotEqual(t, lastPID, string(res.Body))
-		lastPID = string(res.Body)
-	}
+	assert.NotNil(t, p.Workers()[0])
+	assert.Equal(t, pid, p.Workers()[0].PID())
 }
 
-// identical to replace but controlled on worker side
-func Test_StaticPool_Stop_Worker(t *testing.T) {
+func Test_StaticPool_Dump(t *testing.T) {
 	p, err := NewPool(
-		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "stop", "pipes") },
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "delay", "pipes") },
 		NewPipeFactory(),
 		Config{
-			NumWorkers:      1,
+			NumWorkers:      2,
 			AllocateTimeout: time.Second,
 			DestroyTimeout:  time.Second,
 		},
@@ -301,31 +3120,114 @@ func Test_StaticPool_Stop_Worker(t *testing.T) {
 	assert.NoError(t, err)
 	defer p.Destroy()
 
-	assert.NotNil(t, p)
+	go func() {
+		_, _ = p.Exec(&Payload{Body: []byte("200")})
+	}()
+	time.Sleep(time.Millisecond * 100)
 
-	var lastPID string
-	lastPID = strconv.Itoa(*p.Workers()[0].Pid)
+	dump := p.Dump()
+	assert.Len(t, dump, 2)
 
-	res, _ := p.Exec(&Payload{Body: []byte("hello")})
-	assert.Equal(t, lastPID, string(res.Body))
+	busy := 0
+	for _, s := range dump {
+		assert.NotEqual(t, 0, s.Pid)
+		assert.True(t, s.Age > 0)
 
-	for i := 0; i < 10; i++ {
-		res, err := p.Exec(&Payload{Body: []byte("hello")})
+		if s.Busy {
+			busy++
+			assert.Equal(t, "working", s.Status)
+			assert.Equal(t, 3, s.LastPayloadSize)
+		}
+	}
+	assert.Equal(t, 1, busy)
+}
 
-		assert.NoError(t, err)
-		assert.NotNil(t, res)
-		assert.NotNil(t, res.Body)
-		assert.Nil(t, res.Context)
+func Test_StaticPool_WorkerConcurrency(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "mux", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:        1,
+			WorkerConcurrency: 2,
+			AllocateTimeout:   time.Second,
+			DestroyTimeout:    time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
 
-		assert.NotEqual(t, lastPID, string(res.Body))
-		lastPID = string(res.Body)
+	// worker responds once both tasks are received
+	wg := sync.WaitGroup{}
+	for _, body := range []string{"a", "b"} {
+		wg.Add(1)
+		go func(body string) {
+			defer wg.Done()
+
+			res, err := p.Exec(&Payload{Body: []byte(body)})
+			assert.NoError(t, err)
+			assert.Equal(t, body, res.String())
+		}(body)
 	}
+	wg.Wait()
+
+	assert.Len(t, p.Workers(), 1)
+	assert.Equal(t, 2, p.Workers()[0].Concurrency())
+	assert.Equal(t, 1, p.Stats().NumIdle)
 }
 
-// identical to replace but controlled on worker side
-func Test_Static_Pool_Destroy_And_Close(t *testing.T) {
+func Test_StaticPool_Unhealthy(t *testing.T) {
 	p, err := NewPool(
-		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "delay", "pipes") },
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "unhealthy", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:       1,
+			AllocateTimeout:  time.Second,
+			DestroyTimeout:   time.Second,
+			RecycleKillFirst: true,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	res, err := p.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+
+	// to ensure that worker reported itself unhealthy
+	time.Sleep(time.Millisecond * 200)
+
+	// unhealthy worker is replaced once allocated
+	res2, err := p.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.NotEqual(t, res.String(), res2.String())
+}
+
+func Test_StaticPool_IdleCheckInterval(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "unhealthy", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:        1,
+			AllocateTimeout:   time.Second,
+			DestroyTimeout:    time.Second,
+			IdleCheckInterval: time.Millisecond * 20,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	res, err := p.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+
+	time.Sleep(time.Millisecond * 500)
+
+	// replaced without being allocated
+	assert.Len(t, p.Workers(), 1)
+	assert.NotEqual(t, res.String(), strconv.Itoa(p.Workers()[0].PID()))
+}
+
+func Test_StaticPool_ExecFresh(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "pid", "pipes") },
 		NewPipeFactory(),
 		Config{
 			NumWorkers:      1,
@@ -333,51 +3235,57 @@ func Test_Static_Pool_Destroy_And_Close(t *testing.T) {
 			DestroyTimeout:  time.Second,
 		},
 	)
+	assert.NoError(t, err)
+	defer p.Destroy()
 
-	assert.NotNil(t, p)
+	pid := strconv.Itoa(*p.Workers()[0].Pid)
+
+	first, err := p.ExecFresh(&Payload{Body: []byte("hello")})
 	assert.NoError(t, err)
+	assert.NotEqual(t, pid, first.String())
 
-	p.Destroy()
-	_, err = p.Exec(&Payload{Body: []byte("100")})
-	assert.Error(t, err)
+	second, err := p.ExecFresh(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.NotEqual(t, pid, second.String())
+	assert.NotEqual(t, first.String(), second.String())
+
+	// pool workers are not affected
+	assert.Len(t, p.Workers(), 1)
+	assert.Equal(t, pid, strconv.Itoa(*p.Workers()[0].Pid))
+	assert.Equal(t, int64(2), p.Stats().TotalExecs)
 }
 
-// identical to replace but controlled on worker side
-func Test_Static_Pool_Destroy_And_Close_While_Wait(t *testing.T) {
+func Test_StaticPool_ExecFresh_Timeout(t *testing.T) {
 	p, err := NewPool(
 		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "delay", "pipes") },
 		NewPipeFactory(),
 		Config{
 			NumWorkers:      1,
+			ExecTimeout:     time.Millisecond * 100,
 			AllocateTimeout: time.Second,
 			DestroyTimeout:  time.Second,
 		},
 	)
-
-	assert.NotNil(t, p)
 	assert.NoError(t, err)
+	defer p.Destroy()
 
-	go func() {
-		_, err := p.Exec(&Payload{Body: []byte("100")})
-		if err != nil {
-			t.Errorf("error executing payload: error %v", err)
-		}
-
-	}()
-	time.Sleep(time.Millisecond * 10)
-
-	p.Destroy()
-	_, err = p.Exec(&Payload{Body: []byte("100")})
-	assert.Error(t, err)
+	res, err := p.ExecFresh(&Payload{Body: []byte("500")})
+	assert.Nil(t, res)
+	assert.Equal(t, ErrExecTimeout, err)
 }
 
-// identical to replace but controlled on worker side
-func Test_Static_Pool_Handle_Dead(t *testing.T) {
-	p, err := NewPool(
-		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "echo", "pipes") },
+func Test_StaticPool_ExecFresh_SpawnError(t *testing.T) {
+	p, err := NewPoolWithConfig(
+		func(wc WorkerConfig) *exec.Cmd {
+			if wc.Index == FreshWorkerIndex {
+				return exec.Command("php", "tests/failboot.php")
+			}
+
+			return exec.Command("php", "tests/client.php", "echo", "pipes")
+		},
 		NewPipeFactory(),
 		Config{
-			NumWorkers:      5,
+			NumWorkers:      1,
 			AllocateTimeout: time.Second,
 			DestroyTimeout:  time.Second,
 		},
@@ -385,112 +3293,352 @@ func Test_Static_Pool_Handle_Dead(t *testing.T) {
 	assert.NoError(t, err)
 	defer p.Destroy()
 
-	assert.NotNil(t, p)
+	res, err := p.ExecFresh(&Payload{Body: []byte("hello")})
+	assert.Nil(t, res)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unable to spawn worker")
+}
 
-	for _, w := range p.workers {
-		w.state.value = StateErrored
+func Test_StaticPool_RecycleReasons(t *testing.T) {
+	cases := []struct {
+		reason  RecycleReason
+		mode    string
+		cfg     Config
+		trigger func(t *testing.T, p *StaticPool)
+	}{
+		{
+			reason: RecycleCrash,
+			mode:   "echo",
+			trigger: func(t *testing.T, p *StaticPool) {
+				assert.NoError(t, p.Workers()[0].Kill())
+			},
+		},
+		{
+			reason: RecycleError,
+			mode:   "echo",
+			trigger: func(t *testing.T, p *StaticPool) {
+				w, err := p.Allocate(context.Background())
+				assert.NoError(t, err)
+				p.Release(w, true)
+			},
+		},
+		{
+			reason: RecycleTimeout,
+			mode:   "delay",
+			cfg:    Config{ExecTimeout: time.Millisecond * 100},
+			trigger: func(t *testing.T, p *StaticPool) {
+				_, err := p.Exec(&Payload{Body: []byte("500")})
+				assert.Equal(t, ErrExecTimeout, err)
+			},
+		},
+		{
+			reason: RecycleMaxJobs,
+			mode:   "echo",
+			cfg:    Config{MaxJobs: 1},
+			trigger: func(t *testing.T, p *StaticPool) {
+				_, err := p.Exec(&Payload{Body: []byte("hello")})
+				assert.NoError(t, err)
+			},
+		},
+		{
+			reason: RecycleMaxMemory,
+			mode:   "echo",
+			cfg:    Config{MaxMemory: 1},
+			trigger: func(t *testing.T, p *StaticPool) {
+				_, err := p.Exec(&Payload{Body: []byte("hello")})
+				assert.NoError(t, err)
+			},
+		},
+		{
+			reason: RecycleMaxAge,
+			mode:   "echo",
+			cfg:    Config{MaxAge: time.Millisecond * 400},
+			trigger: func(t *testing.T, p *StaticPool) {
+				time.Sleep(time.Millisecond * 450)
+				_, err := p.Exec(&Payload{Body: []byte("hello")})
+				assert.NoError(t, err)
+			},
+		},
+		{
+			reason: RecycleUnhealthy,
+			mode:   "unhealthy",
+			trigger: func(t *testing.T, p *StaticPool) {
+				_, err := p.Exec(&Payload{Body: []byte("hello")})
+				assert.NoError(t, err)
+
+				// to ensure that worker reported itself unhealthy
+				time.Sleep(time.Millisecond * 200)
+
+				_, err = p.Exec(&Payload{Body: []byte("hello")})
+				assert.NoError(t, err)
+			},
+		},
+		{
+			reason: RecycleStopRequest,
+			mode:   "stop",
+			trigger: func(t *testing.T, p *StaticPool) {
+				// worker requests termination on the second task
+				for i := 0; i < 2; i++ {
+					_, err := p.Exec(&Payload{Body: []byte("hello")})
+					assert.NoError(t, err)
+				}
+			},
+		},
+		{
+			reason: RecycleReload,
+			mode:   "echo",
+			trigger: func(t *testing.T, p *StaticPool) {
+				assert.NoError(t, p.ReloadWorker())
+			},
+		},
+		{
+			reason: RecycleRemoved,
+			mode:   "echo",
+			trigger: func(t *testing.T, p *StaticPool) {
+				assert.True(t, p.Remove(p.Workers()[0], fmt.Errorf("removed")))
+
+				_, err := p.Exec(&Payload{Body: []byte("hello")})
+				assert.NoError(t, err)
+			},
+		},
 	}
 
-	_, err = p.Exec(&Payload{Body: []byte("hello")})
-	assert.Error(t, err)
+	for _, c := range cases {
+		t.Run(c.reason.String(), func(t *testing.T) {
+			mode := c.mode
+			cfg := c.cfg
+			cfg.NumWorkers = 1
+			cfg.AllocateTimeout = time.Second
+			cfg.DestroyTimeout = time.Second
+
+			p, err := NewPool(
+				func() *exec.Cmd { return exec.Command("php", "tests/client.php", mode, "pipes") },
+				NewPipeFactory(),
+				cfg,
+			)
+			assert.NoError(t, err)
+			defer p.Destroy()
+
+			c.trigger(t, p)
+
+			// to ensure that worker is stopped
+			time.Sleep(time.Millisecond * 200)
+			assert.Equal(t, map[RecycleReason]int64{c.reason: 1}, p.Stats().RecycleReasons)
+		})
+	}
 }
 
-// identical to replace but controlled on worker side
-func Test_Static_Pool_Slow_Destroy(t *testing.T) {
+func Test_StaticPool_WorkerHooks(t *testing.T) {
 	p, err := NewPool(
-		func() *exec.Cmd { return exec.Command("php", "tests/slow-destroy.php", "echo", "pipes") },
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "echo", "pipes") },
 		NewPipeFactory(),
 		Config{
-			NumWorkers:      5,
+			NumWorkers:      1,
 			AllocateTimeout: time.Second,
 			DestroyTimeout:  time.Second,
 		},
 	)
+	assert.NoError(t, err)
+
+	first := p.Workers()[0]
 
+	ready, destroyed := make(chan *Worker, 10), make(chan *Worker, 10)
+	p.OnWorkerReady(func(w *Worker) error {
+		assert.Equal(t, StateReady, w.State().Value())
+		ready <- w
+
+		if len(ready) == 1 {
+			return fmt.Errorf("registration failed")
+		}
+
+		return nil
+	})
+
+	p.OnWorkerDestroy(func(w *Worker) {
+		destroyed <- w
+	})
+
+	err = p.ReloadWorker()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "worker ready hook: registration failed")
+	assert.Equal(t, []*Worker{first}, p.Workers())
+
+	assert.NoError(t, p.ReloadWorker())
+	<-ready
+	second := <-ready
+	assert.Contains(t, p.Workers(), second)
+	assert.Equal(t, first, <-destroyed)
+
+	res, err := p.Exec(&Payload{Body: []byte("hello")})
 	assert.NoError(t, err)
-	assert.NotNil(t, p)
+	assert.Equal(t, "hello", res.String())
 
 	p.Destroy()
+	assert.Equal(t, second, <-destroyed)
+	assert.Len(t, destroyed, 0)
 }
 
-func Benchmark_Pool_Allocate(b *testing.B) {
-	p, _ := NewPool(
-		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "echo", "pipes") },
+func Test_StaticPool_ExecWithMeta(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "pid", "pipes") },
 		NewPipeFactory(),
-		cfg,
+		Config{
+			NumWorkers:       1,
+			MaxJobs:          1,
+			AllocateTimeout:  time.Second,
+			DestroyTimeout:   time.Second,
+			RecycleKillFirst: true,
+		},
 	)
+	assert.NoError(t, err)
 	defer p.Destroy()
 
-	for n := 0; n < b.N; n++ {
-		w, err := p.allocateWorker()
-		if err != nil {
-			b.Fail()
-			log.Println(err)
-		}
+	w := p.Workers()[0]
 
-		p.free <- w
-	}
+	// worker is recycled right after the task
+	res, meta, err := p.ExecWithMeta(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, strconv.Itoa(*w.Pid), res.String())
+	assert.Equal(t, *w.Pid, meta.Pid)
+	assert.Equal(t, int64(1), meta.NumExecs)
+	assert.NotZero(t, meta.Duration)
+
+	res, meta, err = p.ExecWithMeta(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.NotEqual(t, *w.Pid, meta.Pid)
+	assert.Equal(t, res.String(), strconv.Itoa(meta.Pid))
 }
 
-func Benchmark_Pool_Echo(b *testing.B) {
-	p, _ := NewPool(
-		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "echo", "pipes") },
+func Test_StaticPool_PeakMemory(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "peak", "pipes") },
 		NewPipeFactory(),
-		cfg,
+		Config{
+			NumWorkers:      1,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+		},
 	)
+	assert.NoError(t, err)
 	defer p.Destroy()
 
-	for n := 0; n < b.N; n++ {
-		if _, err := p.Exec(&Payload{Body: []byte("hello")}); err != nil {
-			b.Fail()
-		}
-	}
+	res, meta, err := p.ExecWithMeta(&Payload{Body: []byte("1048576")})
+	assert.NoError(t, err)
+	assert.Equal(t, "1048576", res.String())
+	assert.True(t, meta.PeakMemory >= 1048576, "peak memory %v", meta.PeakMemory)
+	assert.Equal(t, meta.PeakMemory, p.Stats().PeakMemory)
+
+	// lower peak does not change the stats
+	_, meta2, err := p.ExecWithMeta(&Payload{Body: []byte("1")})
+	assert.NoError(t, err)
+	assert.NotZero(t, meta2.PeakMemory)
+	assert.Equal(t, meta.PeakMemory, p.Stats().PeakMemory)
 }
 
-func Benchmark_Pool_Echo_Batched(b *testing.B) {
-	p, _ := NewPool(
+func Test_StaticPool_PeakMemory_Unknown(t *testing.T) {
+	p, err := NewPool(
 		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "echo", "pipes") },
 		NewPipeFactory(),
 		Config{
-			NumWorkers:      int64(runtime.NumCPU()),
-			AllocateTimeout: time.Second * 100,
+			NumWorkers:      1,
+			AllocateTimeout: time.Second,
 			DestroyTimeout:  time.Second,
 		},
 	)
+	assert.NoError(t, err)
 	defer p.Destroy()
 
-	var wg sync.WaitGroup
-	for i := 0; i < b.N; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			if _, err := p.Exec(&Payload{Body: []byte("hello")}); err != nil {
-				b.Fail()
-				log.Println(err)
-			}
-		}()
-	}
-
-	wg.Wait()
+	_, meta, err := p.ExecWithMeta(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Zero(t, meta.PeakMemory)
+	assert.Zero(t, p.Stats().PeakMemory)
 }
 
-func Benchmark_Pool_Echo_Replaced(b *testing.B) {
-	p, _ := NewPool(
-		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "echo", "pipes") },
+func Test_StaticPool_ReportedStatus(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "status", "pipes") },
 		NewPipeFactory(),
 		Config{
 			NumWorkers:      1,
-			MaxJobs:         1,
 			AllocateTimeout: time.Second,
 			DestroyTimeout:  time.Second,
 		},
 	)
+	assert.NoError(t, err)
 	defer p.Destroy()
 
-	for n := 0; n < b.N; n++ {
-		if _, err := p.Exec(&Payload{Body: []byte("hello")}); err != nil {
-			b.Fail()
-			log.Println(err)
+	assert.Equal(t, "", p.Dump()[0].LastReportedStatus)
+
+	_, err = p.Exec(&Payload{Body: []byte("degraded: cache miss rate high")})
+	assert.NoError(t, err)
+	assert.Equal(t, "degraded: cache miss rate high", p.Dump()[0].LastReportedStatus)
+
+	// response without the status keeps the last one
+	res, err := p.Exec(&Payload{Body: []byte("-")})
+	assert.NoError(t, err)
+	assert.Equal(t, "-", res.String())
+	assert.Equal(t, "degraded: cache miss rate high", p.Dump()[0].LastReportedStatus)
+
+	_, err = p.Exec(&Payload{Body: []byte("ready")})
+	assert.NoError(t, err)
+	assert.Equal(t, "ready", p.Dump()[0].LastReportedStatus)
+}
+
+func Test_StaticPool_Quarantine(t *testing.T) {
+	var broken int32
+	spawned := int32(0)
+
+	p, err := NewPoolWithConfig(
+		func(cfg WorkerConfig) *exec.Cmd {
+			// second slot fails to start once first worker of the slot is gone
+			if cfg.Index == 1 && atomic.AddInt32(&spawned, 1) > 1 && atomic.LoadInt32(&broken) == 1 {
+				return exec.Command("php", "tests/failboot.php")
+			}
+
+			return exec.Command("php", "tests/client.php", "echo", "pipes")
+		},
+		NewPipeFactory(),
+		Config{
+			NumWorkers:          2,
+			AllocateTimeout:     time.Second,
+			DestroyTimeout:      time.Second,
+			QuarantineThreshold: 2,
+			QuarantineCooldown:  time.Millisecond * 300,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	atomic.StoreInt32(&broken, 1)
+
+	var w *Worker
+	p.muw.RLock()
+	for wc, index := range p.index {
+		if index == 1 {
+			w = wc
 		}
 	}
+	p.muw.RUnlock()
+
+	// worker death and failed start quarantine the slot
+	assert.NoError(t, w.Kill())
+	time.Sleep(time.Millisecond * 200)
+
+	assert.Equal(t, 1, p.Stats().Quarantined)
+	assert.Len(t, p.Workers(), 1)
+
+	res, err := p.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", res.String())
+
+	// respawn after the cooldown fails again
+	time.Sleep(time.Millisecond * 300)
+	assert.Equal(t, 1, p.Stats().Quarantined)
+	assert.Len(t, p.Workers(), 1)
+
+	atomic.StoreInt32(&broken, 0)
+	time.Sleep(time.Millisecond * 400)
+
+	assert.Equal(t, 0, p.Stats().Quarantined)
+	assert.Len(t, p.Workers(), 2)
 }
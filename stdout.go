@@ -0,0 +1,177 @@
+package roadrunner
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+)
+
+// StdoutMode defines how pool handles stdout of the workers, workers communicating over pipes
+// use stdout as the relay and their stdout is never captured.
+type StdoutMode string
+
+const (
+	// StdoutDiscard discards worker stdout (default).
+	StdoutDiscard StdoutMode = "discard"
+
+	// StdoutBuffer keeps recent StdoutBufferSize bytes of worker stdout, see Worker.Stdout.
+	StdoutBuffer StdoutMode = "buffer"
+
+	// StdoutForward logs every line of worker stdout at Info level along with worker ID and PID,
+	// or copies stdout to the writer of the worker when configured.
+	StdoutForward StdoutMode = "forward"
+)
+
+const (
+	// StdoutBufferSize defines how many bytes of recent worker stdout are kept in StdoutBuffer mode.
+	StdoutBufferSize = 64 * 1024
+
+	// StdoutLineSize limits length of the forwarded stdout line, longer lines are split.
+	StdoutLineSize = 4 * 1024
+)
+
+// stdoutCapture receives stdout of the worker process. Capture owns the pipe process writes to
+// and reads it until EOF, incomplete last line is forwarded once all output is read.
+type stdoutCapture struct {
+	mode StdoutMode
+
+	// logical ID of the worker
+	id string
+
+	// worker process, started before any output is received
+	cmd *exec.Cmd
+
+	// receives forwarded lines when out is nil
+	logger func() Logger
+
+	// receives forwarded output
+	out io.Writer
+
+	mu sync.Mutex
+
+	// incomplete line in forward mode or recent output in buffer mode
+	buf []byte
+
+	// write end of the pipe passed to the process, closed once process is started
+	pw *os.File
+
+	// read end of the pipe
+	pr *os.File
+
+	// closed once output is read and flushed
+	done chan interface{}
+}
+
+// captureStdout attaches stdout capture for the given mode to the command, does nothing in
+// discard mode. Out is used instead of the logger in forward mode when not nil.
+func captureStdout(cmd *exec.Cmd, mode StdoutMode, wc WorkerConfig, out io.Writer, logger func() Logger) {
+	if mode == "" || mode == StdoutDiscard || cmd.Stdout != nil {
+		return
+	}
+
+	cmd.Stdout = &stdoutCapture{
+		mode:   mode,
+		id:     wc.ID,
+		cmd:    cmd,
+		logger: logger,
+		out:    out,
+		done:   make(chan interface{}),
+	}
+}
+
+// open passes the write end of the new pipe to the command as stdout, must be followed by
+// started once command is started.
+func (c *stdoutCapture) open(cmd *exec.Cmd) (err error) {
+	if c.pr, c.pw, err = os.Pipe(); err != nil {
+		return err
+	}
+
+	cmd.Stdout = c.pw
+	return nil
+}
+
+// started closes the write end of the pipe owned by the process now and reads the output until
+// the process and its children close their stdout. Pipe is closed when process failed to start.
+func (c *stdoutCapture) started(ok bool) {
+	_ = c.pw.Close()
+	if !ok {
+		_ = c.pr.Close()
+		close(c.done)
+		return
+	}
+
+	go func() {
+		defer close(c.done)
+
+		_, _ = io.Copy(c, c.pr)
+		_ = c.pr.Close()
+		c.flush()
+	}()
+}
+
+// Write receives worker output.
+func (c *stdoutCapture) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch {
+	case c.mode == StdoutBuffer:
+		c.buf = append(c.buf, p...)
+		if len(c.buf) > StdoutBufferSize {
+			c.buf = append(c.buf[:0], c.buf[len(c.buf)-StdoutBufferSize:]...)
+		}
+	case c.out != nil:
+		// error would close the worker stdout, worker dies on the next write
+		_, _ = c.out.Write(p)
+	default:
+		c.buf = append(c.buf, p...)
+		for {
+			i := bytes.IndexByte(c.buf, '\n')
+			if i == -1 && len(c.buf) < StdoutLineSize {
+				break
+			}
+
+			if i == -1 || i > StdoutLineSize {
+				// long line is split
+				c.log(c.buf[:StdoutLineSize])
+				c.buf = c.buf[StdoutLineSize:]
+				continue
+			}
+
+			c.log(c.buf[:i])
+			c.buf = c.buf[i+1:]
+		}
+	}
+
+	return len(p), nil
+}
+
+// flush forwards incomplete last line once all output is read.
+func (c *stdoutCapture) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.mode == StdoutForward && c.out == nil && len(c.buf) != 0 {
+		c.log(c.buf)
+		c.buf = nil
+	}
+}
+
+// Bytes returns recent output in buffer mode.
+func (c *stdoutCapture) Bytes() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.mode != StdoutBuffer {
+		return nil
+	}
+
+	return append([]byte(nil), c.buf...)
+}
+
+// log forwards the line to the logger.
+func (c *stdoutCapture) log(line []byte) {
+	c.logger().Info("worker stdout", "id", c.id, "pid", c.cmd.Process.Pid, "line", string(bytes.TrimSuffix(line, []byte("\r"))))
+}
@@ -0,0 +1,236 @@
+package roadrunner
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lineLogger records logged stdout lines.
+type lineLogger struct {
+	testLogger
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *lineLogger) Info(msg string, keyvals ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		if keyvals[i] == "line" {
+			l.lines = append(l.lines, keyvals[i+1].(string))
+		}
+	}
+}
+
+func (l *lineLogger) Lines() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return append([]string{}, l.lines...)
+}
+
+func newTestCapture(mode StdoutMode, out io.Writer, log Logger) *stdoutCapture {
+	cmd := &exec.Cmd{Process: &os.Process{Pid: 1}}
+	captureStdout(cmd, mode, WorkerConfig{ID: "worker-0"}, out, func() Logger { return log })
+
+	c, _ := cmd.Stdout.(*stdoutCapture)
+	return c
+}
+
+func Test_Stdout_Discard(t *testing.T) {
+	assert.Nil(t, newTestCapture("", nil, nil))
+	assert.Nil(t, newTestCapture(StdoutDiscard, nil, nil))
+}
+
+func Test_Stdout_Forward(t *testing.T) {
+	log := &lineLogger{}
+	c := newTestCapture(StdoutForward, nil, log)
+
+	c.Write([]byte("hello\nwor"))
+	c.Write([]byte("ld\r\nlast"))
+	assert.Equal(t, []string{"hello", "world"}, log.Lines())
+
+	c.flush()
+	assert.Equal(t, []string{"hello", "world", "last"}, log.Lines())
+	assert.Nil(t, c.Bytes())
+}
+
+func Test_Stdout_Forward_LongLine(t *testing.T) {
+	log := &lineLogger{}
+	c := newTestCapture(StdoutForward, nil, log)
+
+	c.Write(bytes.Repeat([]byte("a"), StdoutLineSize*2+1))
+	assert.Len(t, log.Lines(), 2)
+
+	c.Write([]byte("\n"))
+	assert.Len(t, log.Lines(), 3)
+	assert.Equal(t, "a", log.Lines()[2])
+}
+
+func Test_Stdout_Writer(t *testing.T) {
+	out := &lockedBuffer{}
+	c := newTestCapture(StdoutForward, out, nil)
+
+	c.Write([]byte("hello\nworld"))
+	c.flush()
+	assert.Equal(t, "hello\nworld", string(out.Bytes()))
+}
+
+func Test_Stdout_Buffer(t *testing.T) {
+	c := newTestCapture(StdoutBuffer, nil, nil)
+
+	c.Write([]byte("hello"))
+	assert.Equal(t, "hello", string(c.Bytes()))
+
+	c.Write(bytes.Repeat([]byte("a"), StdoutBufferSize))
+	assert.Len(t, c.Bytes(), StdoutBufferSize)
+	assert.Equal(t, byte('a'), c.Bytes()[0])
+}
+
+func Test_Stdout_Process(t *testing.T) {
+	log := &lineLogger{}
+	cmd := exec.Command("sh", "-c", "printf 'hello\\nlast'")
+	captureStdout(cmd, StdoutForward, WorkerConfig{ID: "worker-0"}, nil, func() Logger { return log })
+
+	w, err := newWorker(cmd)
+	assert.NoError(t, err)
+	assert.NoError(t, w.start())
+	assert.NoError(t, w.Wait())
+
+	// trailing output is forwarded once all of it is read
+	<-w.stdout.done
+	assert.Equal(t, []string{"hello", "last"}, log.Lines())
+}
+
+func Test_StaticPool_Stdout(t *testing.T) {
+	ls, err := net.Listen("tcp", "localhost:9007")
+	if assert.NoError(t, err) {
+		defer ls.Close()
+	} else {
+		t.Skip("socket is busy")
+	}
+
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "stdout", "tcp") },
+		NewSocketFactory(ls, time.Minute),
+		Config{
+			NumWorkers:      1,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+			StdoutMode:      StdoutForward,
+		},
+	)
+	assert.NoError(t, err)
+
+	log := &lineLogger{}
+	p.SetLogger(log)
+
+	res, err := p.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", res.String())
+
+	// incomplete line is forwarded once worker exits
+	w := p.Workers()[0]
+	p.Destroy()
+	<-w.stdout.done
+
+	assert.Equal(t, []string{"debug hello", "partial"}, log.Lines())
+}
+
+func Test_StaticPool_Stdout_Buffer(t *testing.T) {
+	ls, err := net.Listen("tcp", "localhost:9007")
+	if assert.NoError(t, err) {
+		defer ls.Close()
+	} else {
+		t.Skip("socket is busy")
+	}
+
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "stdout", "tcp") },
+		NewSocketFactory(ls, time.Minute),
+		Config{
+			NumWorkers:      1,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+			StdoutMode:      StdoutBuffer,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	_, err = p.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+
+	time.Sleep(time.Millisecond * 10)
+	assert.Equal(t, "debug hello\npartial", string(p.Workers()[0].Stdout()))
+}
+
+func Test_StaticPool_Stdout_Writer(t *testing.T) {
+	ls, err := net.Listen("tcp", "localhost:9007")
+	if assert.NoError(t, err) {
+		defer ls.Close()
+	} else {
+		t.Skip("socket is busy")
+	}
+
+	var mu sync.Mutex
+	outputs := make(map[string]*lockedBuffer)
+
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "stdout", "tcp") },
+		NewSocketFactory(ls, time.Minute),
+		Config{
+			NumWorkers:      1,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+			StdoutMode:      StdoutForward,
+			StdoutWriter: func(wc WorkerConfig) io.Writer {
+				mu.Lock()
+				defer mu.Unlock()
+
+				outputs[wc.ID] = &lockedBuffer{}
+				return outputs[wc.ID]
+			},
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	_, err = p.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+
+	time.Sleep(time.Millisecond * 10)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, "debug hello\npartial", string(outputs["worker-0"].Bytes()))
+}
+
+func Test_StaticPool_Stdout_Pipes(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "echo", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      1,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+			StdoutMode:      StdoutBuffer,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	// stdout is used by the relay
+	res, err := p.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", res.String())
+	assert.Nil(t, p.Workers()[0].Stdout())
+}
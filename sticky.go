@@ -0,0 +1,56 @@
+package roadrunner
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// number of affinity slots, keys sharing the slot share the preferred worker
+const stickySlots = 1024
+
+// stickyTable remembers pool slot index of the worker preferred by the task key. Keys are
+// hashed into fixed number of slots to keep memory bounded, mapping refers to pool slot
+// indexes (not workers) so it stays valid once worker is replaced.
+type stickyTable struct {
+	mu sync.Mutex
+
+	// slot index + 1 of the preferred worker, 0 when key slot is not mapped yet
+	slots [stickySlots]int
+}
+
+// lookup returns slot index of the worker preferred by the key, unmapped keys are hashed
+// over the given number of workers.
+func (t *stickyTable) lookup(key string, numWorkers int) int {
+	h := stickyHash(key)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if index := t.slots[h%stickySlots] - 1; index >= 0 && index < numWorkers {
+		return index
+	}
+
+	if numWorkers <= 0 {
+		return 0
+	}
+
+	return int(h % uint32(numWorkers))
+}
+
+// remember maps the key to the worker slot index.
+func (t *stickyTable) remember(key string, index int) {
+	h := stickyHash(key)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.slots[h%stickySlots] = index + 1
+}
+
+// stickyHash returns FNV-1a hash of the key.
+func stickyHash(key string) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+
+	return h.Sum32()
+}
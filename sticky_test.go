@@ -0,0 +1,21 @@
+package roadrunner
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_StickyTable(t *testing.T) {
+	st := stickyTable{}
+
+	index := st.lookup("user-1", 4)
+	assert.True(t, index >= 0 && index < 4)
+	assert.Equal(t, index, st.lookup("user-1", 4))
+
+	st.remember("user-1", 3)
+	assert.Equal(t, 3, st.lookup("user-1", 4))
+
+	// pool has been shrunk
+	assert.Equal(t, int(stickyHash("user-1")%2), st.lookup("user-1", 2))
+	assert.Equal(t, 0, st.lookup("user-1", 0))
+}
@@ -0,0 +1,156 @@
+package roadrunner
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Policy decides whether worker must be recycled by the Supervisor.
+type Policy interface {
+	// Check returns error describing the violation and the reason reported to the recycle
+	// statistics, nil error is returned when worker complies with the policy.
+	Check(w *Worker) (RecycleReason, error)
+}
+
+// PolicyFunc adapts function to the Policy interface.
+type PolicyFunc func(w *Worker) (RecycleReason, error)
+
+// Check calls f(w).
+func (f PolicyFunc) Check(w *Worker) (RecycleReason, error) {
+	return f(w)
+}
+
+// MaxJobsPolicy recycles worker once it executes given number of tasks.
+func MaxJobsPolicy(maxJobs int64) Policy {
+	return PolicyFunc(func(w *Worker) (RecycleReason, error) {
+		if w.State().NumExecs() < maxJobs {
+			return RecycleMaxJobs, nil
+		}
+
+		return RecycleMaxJobs, fmt.Errorf("max jobs reached (%v)", maxJobs)
+	})
+}
+
+// MaxMemoryPolicy recycles worker once it consumes given amount of memory in megabytes, memory
+// is accounted as for Config.MaxMemory.
+func MaxMemoryPolicy(maxMemory uint64) Policy {
+	return PolicyFunc(func(w *Worker) (RecycleReason, error) {
+		usage, err := w.accountedMemory()
+		if err != nil || usage < maxMemory*1024*1024 {
+			// exited worker is replaced by the pool
+			return RecycleMaxMemory, nil
+		}
+
+		return RecycleMaxMemory, fmt.Errorf("max memory reached (%vMB)", maxMemory)
+	})
+}
+
+// MaxAgePolicy recycles worker once it lives for the given duration.
+func MaxAgePolicy(maxAge time.Duration) Policy {
+	return PolicyFunc(func(w *Worker) (RecycleReason, error) {
+		if time.Since(w.Created) < maxAge {
+			return RecycleMaxAge, nil
+		}
+
+		return RecycleMaxAge, fmt.Errorf("max age reached (%s)", maxAge)
+	})
+}
+
+// idleRecycler is implemented by pools able to recycle idle worker on demand.
+type idleRecycler interface {
+	// recycleIdle recycles the worker if it's waiting in the free list, returns false when
+	// worker is busy or has left the pool.
+	recycleIdle(w *Worker, reason RecycleReason, err error) bool
+}
+
+// Supervisor periodically checks all pool workers against the policies and recycles violators,
+// idle workers are recycled without waiting for the next task. Busy workers are checked on the
+// next tick. Workers are recycled the same way as pool recycles them on release (see
+// Config.RecycleKillFirst). Pools not supporting on demand recycling remove violators once
+// they are allocated.
+type Supervisor struct {
+	pool     Pool
+	interval time.Duration
+	policies []Policy
+
+	// serializes start and stop
+	mu   sync.Mutex
+	stop chan interface{}
+	done chan interface{}
+}
+
+// NewSupervisor creates supervisor checking pool workers every interval, supervisor must be
+// started using Start.
+func NewSupervisor(pool Pool, interval time.Duration, policies ...Policy) *Supervisor {
+	return &Supervisor{pool: pool, interval: interval, policies: policies}
+}
+
+// Start starts checking the workers in background, does nothing if supervisor is running.
+func (s *Supervisor) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stop != nil {
+		return
+	}
+
+	s.stop, s.done = make(chan interface{}), make(chan interface{})
+	go s.serve(s.stop, s.done)
+}
+
+// Stop stops checking the workers and waits for the current check to complete, supervisor can
+// be started again.
+func (s *Supervisor) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stop == nil {
+		return
+	}
+
+	close(s.stop)
+	<-s.done
+	s.stop, s.done = nil, nil
+}
+
+// serve checks the workers every interval until stopped.
+func (s *Supervisor) serve(stop, done chan interface{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.check()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// check recycles idle workers violating any of the policies.
+func (s *Supervisor) check() {
+	for _, w := range s.pool.Workers() {
+		if w.IsBusy() || w.State().Value() != StateReady {
+			continue
+		}
+
+		for _, policy := range s.policies {
+			reason, err := policy.Check(w)
+			if err == nil {
+				continue
+			}
+
+			if r, ok := s.pool.(idleRecycler); ok {
+				r.recycleIdle(w, reason, err)
+			} else {
+				s.pool.Remove(w, err)
+			}
+
+			break
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package roadrunner
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"os/exec"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func Test_MaxJobsPolicy(t *testing.T) {
+	w := syntheticWorker(1)
+	policy := MaxJobsPolicy(2)
+
+	w.state.registerExec()
+	_, err := policy.Check(w)
+	assert.NoError(t, err)
+
+	w.state.registerExec()
+	reason, err := policy.Check(w)
+	assert.Equal(t, RecycleMaxJobs, reason)
+	assert.Error(t, err)
+}
+
+func Test_MaxAgePolicy(t *testing.T) {
+	w := syntheticWorker(1)
+
+	_, err := MaxAgePolicy(time.Hour).Check(w)
+	assert.NoError(t, err)
+
+	w.Created = time.Now().Add(-time.Hour)
+	reason, err := MaxAgePolicy(time.Hour).Check(w)
+	assert.Equal(t, RecycleMaxAge, reason)
+	assert.Error(t, err)
+}
+
+func Test_MaxMemoryPolicy(t *testing.T) {
+	// current process always consumes more than 1MB
+	w := syntheticWorker(os.Getpid())
+
+	_, err := MaxMemoryPolicy(1 << 20).Check(w)
+	assert.NoError(t, err)
+
+	reason, err := MaxMemoryPolicy(1).Check(w)
+	assert.Equal(t, RecycleMaxMemory, reason)
+	assert.Error(t, err)
+
+	// exited worker is not recycled by the policy
+	_, err = MaxMemoryPolicy(1).Check(&Worker{})
+	assert.NoError(t, err)
+}
+
+func Test_Supervisor_StartStop(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "echo", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      1,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	s := NewSupervisor(p, time.Millisecond*10)
+	s.Start()
+	s.Start()
+	s.Stop()
+	s.Stop()
+
+	s.Start()
+	defer s.Stop()
+}
+
+func Test_Supervisor_Policies(t *testing.T) {
+	cases := []struct {
+		name   string
+		policy Policy
+		reason RecycleReason
+		before func(t *testing.T, p Pool)
+	}{
+		{
+			name:   "MaxJobs",
+			policy: MaxJobsPolicy(1),
+			reason: RecycleMaxJobs,
+			before: func(t *testing.T, p Pool) {
+				_, err := p.Exec(&Payload{Body: []byte("hello")})
+				assert.NoError(t, err)
+			},
+		},
+		{
+			name:   "MaxAge",
+			policy: MaxAgePolicy(time.Millisecond * 100),
+			reason: RecycleMaxAge,
+		},
+		{
+			name:   "MaxMemory",
+			policy: MaxMemoryPolicy(1),
+			reason: RecycleMaxMemory,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p, err := NewPool(
+				func() *exec.Cmd { return exec.Command("php", "tests/client.php", "pid", "pipes") },
+				NewPipeFactory(),
+				Config{
+					NumWorkers:       1,
+					AllocateTimeout:  time.Second,
+					DestroyTimeout:   time.Second,
+					RecycleKillFirst: true,
+				},
+			)
+			assert.NoError(t, err)
+			defer p.Destroy()
+
+			w := p.Workers()[0]
+			if c.before != nil {
+				c.before(t, p)
+			}
+
+			s := NewSupervisor(p, time.Millisecond*50, c.policy)
+			s.Start()
+
+			// idle worker is recycled without new tasks
+			<-w.waitDone
+			s.Stop()
+			time.Sleep(time.Millisecond * 200)
+
+			assert.Len(t, p.Workers(), 1)
+			assert.Equal(t, int64(1), p.Stats().RecycleReasons[c.reason])
+
+			res, err := p.Exec(&Payload{Body: []byte("hello")})
+			assert.NoError(t, err)
+			assert.NotEqual(t, strconv.Itoa(*w.Pid), res.String())
+		})
+	}
+}
+
+func Test_Supervisor_Busy(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "delay", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      1,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	w := p.Workers()[0]
+
+	s := NewSupervisor(p, time.Millisecond*10, MaxAgePolicy(0))
+	done := make(chan interface{})
+	go func() {
+		defer close(done)
+
+		// busy worker completes the task
+		_, err := p.Exec(&Payload{Body: []byte("300")})
+		assert.NoError(t, err)
+	}()
+
+	time.Sleep(time.Millisecond * 50)
+	s.Start()
+	defer s.Stop()
+
+	<-done
+	<-w.waitDone
+}
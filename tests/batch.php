@@ -0,0 +1,61 @@
+<?php
+/**
+ * Echoes every task of the batch, tasks with "error" body fail.
+ *
+ * @var Goridge\RelayInterface $relay
+ */
+
+use Spiral\Goridge;
+
+function packFrame(string $data, int $flags): string
+{
+    return pack('CPJ', $flags, strlen($data), strlen($data)) . $data;
+}
+
+function unpackFrame(string $packed, int &$offset): string
+{
+    $prefix = unpack('Cflags/Psize/Jrevs', substr($packed, $offset, 17));
+    $data = substr($packed, $offset + 17, $prefix['size']);
+    $offset += 17 + $prefix['size'];
+
+    return $data;
+}
+
+while (true) {
+    $cmd = json_decode($relay->receiveSync($flags), true);
+    if (!empty($cmd['stop'])) {
+        break;
+    }
+
+    if (!empty($cmd['pid'])) {
+        $relay->send(sprintf('{"pid":%s}', getmypid()), Goridge\Relay::PAYLOAD_CONTROL);
+        continue;
+    }
+
+    if (empty($cmd['batch'])) {
+        // context is not used
+        $body = (string)$relay->receiveSync($flags);
+        $relay->send('', Goridge\Relay::PAYLOAD_CONTROL | Goridge\Relay::PAYLOAD_RAW);
+        $relay->send($body, Goridge\Relay::PAYLOAD_RAW);
+        continue;
+    }
+
+    $packed = (string)$relay->receiveSync($flags);
+    $offset = 0;
+    $responses = '';
+
+    for ($i = 0; $i < $cmd['batch']; $i++) {
+        unpackFrame($packed, $offset);
+        $body = unpackFrame($packed, $offset);
+
+        if ($body === 'error') {
+            $responses .= packFrame('item error', Goridge\Relay::PAYLOAD_CONTROL | Goridge\Relay::PAYLOAD_ERROR);
+            continue;
+        }
+
+        $responses .= packFrame('', Goridge\Relay::PAYLOAD_CONTROL | Goridge\Relay::PAYLOAD_RAW);
+        $responses .= packFrame($body, Goridge\Relay::PAYLOAD_RAW);
+    }
+
+    $relay->send($responses, Goridge\Relay::PAYLOAD_RAW);
+}
@@ -0,0 +1,17 @@
+<?php
+/**
+ * @var Goridge\RelayInterface $relay
+ */
+
+use Spiral\Goridge;
+use Spiral\RoadRunner;
+
+$rr = new RoadRunner\Worker($relay);
+
+while ($in = $rr->receive($ctx)) {
+    try {
+        $rr->send((string)$rr->getBootstrap());
+    } catch (\Throwable $e) {
+        $rr->error((string)$e);
+    }
+}
@@ -0,0 +1,30 @@
+<?php
+/**
+ * Calls the host "greet" handler with the body and responds with the result.
+ *
+ * @var Goridge\RelayInterface $relay
+ */
+
+use Spiral\Goridge;
+use Spiral\RoadRunner;
+
+$rr = new RoadRunner\Worker($relay);
+
+while ($in = $rr->receive($ctx)) {
+    try {
+        $relay->send('{"call":"greet"}', Goridge\Relay::PAYLOAD_CONTROL);
+        $relay->send($in, Goridge\Relay::PAYLOAD_RAW);
+
+        // call command and the result
+        $relay->receiveSync($flags);
+        $result = $relay->receiveSync($flags);
+        if ($flags & Goridge\Relay::PAYLOAD_ERROR) {
+            $rr->error($result);
+            continue;
+        }
+
+        $rr->send($result);
+    } catch (\Throwable $e) {
+        $rr->error((string)$e);
+    }
+}
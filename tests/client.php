@@ -28,6 +28,11 @@ switch ($goridge) {
         );
         break;
 
+    case "fd":
+        $stream = fopen(sprintf("php://fd/%s", getenv("RR_RELAY_FD")), "r+");
+        $relay = new Goridge\StreamRelay($stream, $stream);
+        break;
+
     default:
         die("invalid protocol selection");
 }
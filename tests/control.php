@@ -0,0 +1,23 @@
+<?php
+/**
+ * @var Goridge\RelayInterface $relay
+ */
+
+use Spiral\Goridge;
+use Spiral\RoadRunner;
+
+$rr = new RoadRunner\Worker($relay);
+
+$flushed = 0;
+$rr->registerControl('flush', function (string $args) use (&$flushed): string {
+    $flushed++;
+    return sprintf('flushed %s', $args);
+});
+
+while ($in = $rr->receive($ctx)) {
+    try {
+        $rr->send((string)$flushed);
+    } catch (\Throwable $e) {
+        $rr->error((string)$e);
+    }
+}
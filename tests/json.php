@@ -0,0 +1,20 @@
+<?php
+/**
+ * @var Goridge\RelayInterface $relay
+ */
+
+use Spiral\Goridge;
+use Spiral\RoadRunner;
+
+$rr = new RoadRunner\Worker($relay);
+
+while ($in = $rr->receive($ctx)) {
+    try {
+        $data = json_decode($in, true);
+        $data['pid'] = getmypid();
+
+        $rr->send(json_encode($data));
+    } catch (\Throwable $e) {
+        $rr->error((string)$e);
+    }
+}
@@ -0,0 +1,23 @@
+<?php
+/**
+ * Responds twice to the "malformed" task, relay is resynced afterwards.
+ *
+ * @var Goridge\RelayInterface $relay
+ */
+
+use Spiral\Goridge;
+use Spiral\RoadRunner;
+
+$rr = new RoadRunner\Worker($relay);
+
+while ($in = $rr->receive($ctx)) {
+    try {
+        $rr->send((string)$in);
+
+        if ($in === 'malformed') {
+            $rr->send((string)$in);
+        }
+    } catch (\Throwable $e) {
+        $rr->error((string)$e);
+    }
+}
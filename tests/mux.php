@@ -0,0 +1,36 @@
+<?php
+/**
+ * Multiplexed worker, responds to every two tasks in reverse order.
+ *
+ * @var Goridge\RelayInterface $relay
+ */
+
+use Spiral\Goridge;
+
+$pending = [];
+while (true) {
+    $cmd = json_decode($relay->receiveSync($flags), true);
+    if (!empty($cmd['stop'])) {
+        break;
+    }
+
+    if (!empty($cmd['pid'])) {
+        $relay->send(sprintf('{"pid":%s}', getmypid()), Goridge\Relay::PAYLOAD_CONTROL);
+        continue;
+    }
+
+    // context is not used
+    $relay->receiveSync($flags);
+    $pending[] = [$cmd['request'], $relay->receiveSync($flags)];
+    if (count($pending) < 2) {
+        continue;
+    }
+
+    foreach (array_reverse($pending) as list($request, $body)) {
+        $relay->send(sprintf('{"request":%s}', $request), Goridge\Relay::PAYLOAD_CONTROL);
+        $relay->send('', Goridge\Relay::PAYLOAD_CONTROL | Goridge\Relay::PAYLOAD_RAW);
+        $relay->send((string)$body, Goridge\Relay::PAYLOAD_RAW);
+    }
+
+    $pending = [];
+}
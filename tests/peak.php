@@ -0,0 +1,21 @@
+<?php
+/**
+ * Allocates requested number of bytes and reports peak memory usage in the response context.
+ *
+ * @var Goridge\RelayInterface $relay
+ */
+
+use Spiral\Goridge;
+use Spiral\RoadRunner;
+
+$rr = new RoadRunner\Worker($relay);
+
+while ($in = $rr->receive($ctx)) {
+    try {
+        $data = str_repeat('x', (int)$in);
+        $rr->send((string)strlen($data), json_encode(['peak_memory' => memory_get_peak_usage()]));
+        unset($data);
+    } catch (\Throwable $e) {
+        $rr->error((string)$e);
+    }
+}
@@ -0,0 +1,25 @@
+<?php
+/**
+ * Responds to "ping" with "pong" and echoes other tasks, runtime breaks after 3 tasks.
+ *
+ * @var Goridge\RelayInterface $relay
+ */
+
+use Spiral\Goridge;
+use Spiral\RoadRunner;
+
+$rr = new RoadRunner\Worker($relay);
+$tasks = 0;
+
+while ($in = $rr->receive($ctx)) {
+    try {
+        if (++$tasks > 3) {
+            $rr->error("runtime is broken");
+            continue;
+        }
+
+        $rr->send($in === "ping" ? "pong" : $in);
+    } catch (\Throwable $e) {
+        $rr->error((string)$e);
+    }
+}
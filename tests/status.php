@@ -0,0 +1,24 @@
+<?php
+/**
+ * Reports received body as the worker status in the response context, "-" sends no status.
+ *
+ * @var Goridge\RelayInterface $relay
+ */
+
+use Spiral\Goridge;
+use Spiral\RoadRunner;
+
+$rr = new RoadRunner\Worker($relay);
+
+while ($in = $rr->receive($ctx)) {
+    try {
+        if ($in === '-') {
+            $rr->send($in);
+            continue;
+        }
+
+        $rr->send($in, json_encode(['worker_status' => $in]));
+    } catch (\Throwable $e) {
+        $rr->error((string)$e);
+    }
+}
@@ -0,0 +1,20 @@
+<?php
+/**
+ * Prints debug output to stdout before every response.
+ *
+ * @var Goridge\RelayInterface $relay
+ */
+
+use Spiral\Goridge;
+use Spiral\RoadRunner;
+
+$rr = new RoadRunner\Worker($relay);
+
+while ($in = $rr->receive($ctx)) {
+    try {
+        echo "debug " . $in . "\npartial";
+        $rr->send((string)$in);
+    } catch (\Throwable $e) {
+        $rr->error((string)$e);
+    }
+}
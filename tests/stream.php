@@ -0,0 +1,30 @@
+<?php
+/**
+ * Streams the body back in 3 byte chunks.
+ *
+ * @var Goridge\RelayInterface $relay
+ */
+
+use Spiral\Goridge;
+
+while (true) {
+    $cmd = json_decode($relay->receiveSync($flags), true);
+    if (!empty($cmd['stop'])) {
+        break;
+    }
+
+    if (!empty($cmd['pid'])) {
+        $relay->send(sprintf('{"pid":%s}', getmypid()), Goridge\Relay::PAYLOAD_CONTROL);
+        continue;
+    }
+
+    // context is not used
+    $relay->receiveSync($flags);
+    $body = (string)$relay->receiveSync($flags);
+
+    $relay->send('', Goridge\Relay::PAYLOAD_CONTROL | Goridge\Relay::PAYLOAD_RAW);
+    foreach (str_split($body, 3) as $chunk) {
+        $relay->send($chunk, Goridge\Relay::PAYLOAD_RAW);
+    }
+    $relay->send('{"end":true}', Goridge\Relay::PAYLOAD_CONTROL);
+}
@@ -0,0 +1,23 @@
+<?php
+/**
+ * Reports itself unhealthy shortly after every task.
+ *
+ * @var Goridge\RelayInterface $relay
+ */
+
+use Spiral\Goridge;
+use Spiral\RoadRunner;
+
+$rr = new RoadRunner\Worker($relay);
+
+while ($in = $rr->receive($ctx)) {
+    try {
+        $rr->send((string)getmypid());
+
+        // lost database connection is detected later
+        usleep(100000);
+        $relay->send('{"unhealthy":true}', Goridge\Relay::PAYLOAD_CONTROL);
+    } catch (\Throwable $e) {
+        $rr->error((string)$e);
+    }
+}
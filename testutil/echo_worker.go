@@ -0,0 +1,207 @@
+// Package testutil provides in-process workers to test factories and pools without PHP.
+package testutil
+
+import (
+	"fmt"
+	json "github.com/json-iterator/go"
+	"github.com/spiral/goridge/v2"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Option configures EchoWorker behaviour.
+type Option func(w *EchoWorker)
+
+// WithPID sets PID worker presents during the handshake, defaults to the current process PID.
+// Socket factory associates relay with the spawned process using this PID.
+func WithPID(pid int) Option {
+	return func(w *EchoWorker) {
+		w.pid = pid
+	}
+}
+
+// WithToken sets pool token worker echoes during the handshake.
+func WithToken(token string) Option {
+	return func(w *EchoWorker) {
+		w.token = token
+	}
+}
+
+// WithDelay delays every response by the given duration to simulate slow worker.
+func WithDelay(d time.Duration) Option {
+	return func(w *EchoWorker) {
+		w.delay = d
+	}
+}
+
+// WithDisconnectAfter makes worker to drop the connection without response once it receives
+// n-th payload, simulates abrupt worker disconnect.
+func WithDisconnectAfter(n int) Option {
+	return func(w *EchoWorker) {
+		w.disconnectAfter = n
+	}
+}
+
+// EchoWorker connects to the socket factory and sends every received payload back.
+type EchoWorker struct {
+	pid             int
+	token           string
+	delay           time.Duration
+	disconnectAfter int
+
+	// number of served payloads, accessed atomically
+	execs int64
+
+	rl    *goridge.SocketRelay
+	close sync.Once
+	done  chan interface{}
+}
+
+// StartEchoWorker connects to factory listening on the given address (tcp://host:port or
+// unix://path) and serves it in background until the connection is closed.
+func StartEchoWorker(factoryAddr string, opts ...Option) (*EchoWorker, error) {
+	dsn := strings.Split(factoryAddr, "://")
+	if len(dsn) != 2 {
+		return nil, fmt.Errorf("invalid factory address `%s`", factoryAddr)
+	}
+
+	if dsn[0] != "tcp" && dsn[0] != "unix" {
+		return nil, fmt.Errorf("invalid factory transport `%s` (tcp, unix)", dsn[0])
+	}
+
+	conn, err := net.Dial(dsn[0], dsn[1])
+	if err != nil {
+		return nil, err
+	}
+
+	w := &EchoWorker{
+		pid:  os.Getpid(),
+		rl:   goridge.NewSocketRelay(conn),
+		done: make(chan interface{}),
+	}
+
+	for _, o := range opts {
+		o(w)
+	}
+
+	go w.serve()
+	return w, nil
+}
+
+// Execs returns number of payloads served by the worker.
+func (w *EchoWorker) Execs() int {
+	return int(atomic.LoadInt64(&w.execs))
+}
+
+// Done returns channel which is closed once worker stops serving.
+func (w *EchoWorker) Done() <-chan interface{} {
+	return w.done
+}
+
+// Close drops the connection to the factory.
+func (w *EchoWorker) Close() error {
+	var err error
+	w.close.Do(func() {
+		err = w.rl.Close()
+	})
+
+	return err
+}
+
+// serve handles control commands and payloads until connection is closed or stop command is received.
+func (w *EchoWorker) serve() {
+	defer close(w.done)
+	defer w.Close()
+
+	for {
+		ctx, p, err := w.rl.Receive()
+		if err != nil || !p.HasFlag(goridge.PayloadControl) {
+			return
+		}
+
+		if !p.HasFlag(goridge.PayloadRaw) && len(ctx) != 0 {
+			if !w.handleControl(ctx) {
+				return
+			}
+
+			continue
+		}
+
+		body, _, err := w.rl.Receive()
+		if err != nil {
+			return
+		}
+
+		if atomic.AddInt64(&w.execs, 1) == int64(w.disconnectAfter) {
+			return
+		}
+
+		if w.delay != 0 {
+			time.Sleep(w.delay)
+		}
+
+		if err := w.respond(ctx, body); err != nil {
+			return
+		}
+	}
+}
+
+type command struct {
+	Pid  interface{} `json:"pid"`
+	Ping bool        `json:"ping"`
+	Stop bool        `json:"stop"`
+}
+
+type pidResponse struct {
+	Pid   int    `json:"pid"`
+	Token string `json:"token,omitempty"`
+}
+
+type pongResponse struct {
+	Pong bool `json:"pong"`
+}
+
+// handleControl responds to the control command, returns false when worker must stop.
+func (w *EchoWorker) handleControl(data []byte) bool {
+	cmd := &command{}
+	if err := json.Unmarshal(data, cmd); err != nil {
+		return false
+	}
+
+	switch {
+	case cmd.Pid != nil:
+		return w.sendControl(pidResponse{Pid: w.pid, Token: w.token}) == nil
+	case cmd.Ping:
+		return w.sendControl(pongResponse{Pong: true}) == nil
+	case cmd.Stop:
+		return false
+	}
+
+	return true
+}
+
+func (w *EchoWorker) sendControl(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return w.rl.Send(data, goridge.PayloadControl)
+}
+
+// respond sends context and body back to the factory.
+func (w *EchoWorker) respond(ctx, body []byte) error {
+	if ctx == nil {
+		if err := w.rl.Send(nil, goridge.PayloadControl|goridge.PayloadEmpty); err != nil {
+			return err
+		}
+	} else if err := w.rl.Send(ctx, goridge.PayloadControl|goridge.PayloadRaw); err != nil {
+		return err
+	}
+
+	return w.rl.Send(body, goridge.PayloadRaw)
+}
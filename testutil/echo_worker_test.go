@@ -0,0 +1,104 @@
+package testutil
+
+import (
+	"github.com/spiral/roadrunner"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+// startFactory creates socket factory which connects echo worker to every spawned placeholder process.
+func startFactory(t *testing.T, opts ...Option) *roadrunner.SocketFactory {
+	ls, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := roadrunner.NewSocketFactory(ls, time.Second)
+	f.AddListener(func(e roadrunner.FactoryEvent) {
+		if e.Event != roadrunner.EventWorkerConstruct {
+			return
+		}
+
+		_, err := StartEchoWorker(f.String(), append([]Option{WithPID(*e.Worker.Pid)}, opts...)...)
+		assert.NoError(t, err)
+	})
+
+	return f
+}
+
+func Test_EchoWorker_Exec(t *testing.T) {
+	f := startFactory(t)
+	defer f.Close()
+
+	w, err := f.SpawnWorker(exec.Command("sleep", "10"))
+	assert.NoError(t, err)
+	defer w.Kill()
+
+	res, err := w.Exec(&roadrunner.Payload{Context: []byte("context"), Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, "context", string(res.Context))
+	assert.Equal(t, "hello", res.String())
+
+	assert.NoError(t, w.Ping())
+}
+
+func Test_EchoWorker_Delay(t *testing.T) {
+	f := startFactory(t, WithDelay(time.Millisecond*100))
+	defer f.Close()
+
+	w, err := f.SpawnWorker(exec.Command("sleep", "10"))
+	assert.NoError(t, err)
+	defer w.Kill()
+
+	_, err = w.ExecWithTimeout(&roadrunner.Payload{Body: []byte("hello")}, time.Millisecond*10)
+	assert.Equal(t, roadrunner.ErrExecTimeout, err)
+}
+
+func Test_EchoWorker_Disconnect(t *testing.T) {
+	f := startFactory(t, WithDisconnectAfter(2))
+	defer f.Close()
+
+	w, err := f.SpawnWorker(exec.Command("sleep", "10"))
+	assert.NoError(t, err)
+	defer w.Kill()
+
+	_, err = w.Exec(&roadrunner.Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+
+	_, err = w.Exec(&roadrunner.Payload{Body: []byte("hello")})
+	assert.Error(t, err)
+}
+
+func Test_EchoWorker_InvalidAddr(t *testing.T) {
+	_, err := StartEchoWorker("localhost:9007")
+	assert.Error(t, err)
+
+	_, err = StartEchoWorker("udp://localhost:9007")
+	assert.Error(t, err)
+}
+
+func Test_EchoWorker_Pool(t *testing.T) {
+	f := startFactory(t)
+	defer f.Close()
+
+	p, err := roadrunner.NewPool(
+		func() *exec.Cmd { return exec.Command("sleep", "10") },
+		f,
+		roadrunner.Config{
+			NumWorkers:      2,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Millisecond * 100,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	for i := 0; i < 4; i++ {
+		res, err := p.Exec(&roadrunner.Payload{Body: []byte("hello")})
+		assert.NoError(t, err)
+		assert.Equal(t, "hello", res.String())
+	}
+}
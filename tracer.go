@@ -0,0 +1,100 @@
+package roadrunner
+
+import (
+	"context"
+)
+
+// Span names reported to the Tracer. Hierarchy:
+//
+//	roadrunner.exec              task as a whole, including middleware and retries
+//	├── roadrunner.queue_wait    waiting for the free worker, once per attempt
+//	└── roadrunner.worker_exec   execution by the worker, once per attempt
+//
+//	roadrunner.spawn             worker start by the pool
+//	└── roadrunner.relay_wait    waiting for the worker relay, socket factory only
+const (
+	SpanExec       = "roadrunner.exec"
+	SpanQueueWait  = "roadrunner.queue_wait"
+	SpanWorkerExec = "roadrunner.worker_exec"
+	SpanSpawn      = "roadrunner.spawn"
+	SpanRelayWait  = "roadrunner.relay_wait"
+)
+
+// TraceContextKey is the key of the task context frame carrying the trace context of the
+// worker_exec span, see Tracer.TraceContext.
+const TraceContextKey = "traceparent"
+
+// Tracer starts spans around the phases of the task execution and the worker start, see Span*
+// constants. Adapter might bridge the tracer to OpenTelemetry or other tracing library. Attached
+// to StaticPool and DynamicPool with Config.Tracer and DynamicConfig.Tracer.
+type Tracer interface {
+	// StartSpan starts span with the given name as a child of the span carried by ctx, returned
+	// context carries the new span.
+	StartSpan(ctx context.Context, name string) (context.Context, Span)
+
+	// TraceContext returns propagation value of the span carried by ctx (W3C traceparent for
+	// OpenTelemetry), empty when trace context must not be passed to the worker. Value is added
+	// to the task context under TraceContextKey when context is empty or JSON object without
+	// such key, other contexts are sent unchanged.
+	TraceContext(ctx context.Context) string
+}
+
+// Span is the phase started by the Tracer.
+type Span interface {
+	// SetError marks the span as failed.
+	SetError(err error)
+
+	// End completes the span.
+	End()
+}
+
+// nopTracer starts no spans.
+type nopTracer struct{}
+
+func (nopTracer) StartSpan(ctx context.Context, name string) (context.Context, Span) {
+	return ctx, nopSpan{}
+}
+
+func (nopTracer) TraceContext(ctx context.Context) string { return "" }
+
+// nopSpan discards all calls.
+type nopSpan struct{}
+
+func (nopSpan) SetError(err error) {}
+func (nopSpan) End()               {}
+
+// tracerOrNop returns given tracer or the no-op tracer when nil.
+func tracerOrNop(t Tracer) Tracer {
+	if t == nil {
+		return nopTracer{}
+	}
+
+	return t
+}
+
+// endSpan marks span as failed when err is set and completes it.
+func endSpan(span Span, err error) {
+	if err != nil {
+		span.SetError(err)
+	}
+
+	span.End()
+}
+
+// injectTrace returns payload carrying the trace context of the span in ctx, given payload is
+// returned when no trace context is provided or context can not carry it.
+func injectTrace(t Tracer, ctx context.Context, rqs *Payload) *Payload {
+	value := t.TraceContext(ctx)
+	if value == "" {
+		return rqs
+	}
+
+	header, ok := withContextKey(rqs.Context, TraceContextKey, value)
+	if !ok {
+		return rqs
+	}
+
+	traced := *rqs
+	traced.Context = header
+	return &traced
+}
@@ -0,0 +1,195 @@
+package roadrunner
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"os/exec"
+	"sync"
+	"testing"
+	"time"
+)
+
+type spanKey struct{}
+
+// recordedSpan is the span started by the recordingTracer.
+type recordedSpan struct {
+	t      *recordingTracer
+	name   string
+	parent string
+	err    error
+}
+
+func (s *recordedSpan) SetError(err error) { s.err = err }
+
+func (s *recordedSpan) End() {
+	s.t.mu.Lock()
+	defer s.t.mu.Unlock()
+
+	s.t.ended = append(s.t.ended, s)
+}
+
+// recordingTracer records ended spans, trace context is the name of the current span.
+type recordingTracer struct {
+	mu    sync.Mutex
+	ended []*recordedSpan
+}
+
+func (t *recordingTracer) StartSpan(ctx context.Context, name string) (context.Context, Span) {
+	s := &recordedSpan{t: t, name: name}
+	if parent, ok := ctx.Value(spanKey{}).(*recordedSpan); ok {
+		s.parent = parent.name
+	}
+
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+func (t *recordingTracer) TraceContext(ctx context.Context) string {
+	if s, ok := ctx.Value(spanKey{}).(*recordedSpan); ok {
+		return s.name
+	}
+
+	return ""
+}
+
+// spans returns ended spans as "parent/name" or "name" for the root spans.
+func (t *recordingTracer) spans() (spans []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, s := range t.ended {
+		if s.parent == "" {
+			spans = append(spans, s.name)
+			continue
+		}
+
+		spans = append(spans, s.parent+"/"+s.name)
+	}
+
+	return spans
+}
+
+func Test_InjectTrace(t *testing.T) {
+	tracer := &recordingTracer{}
+	ctx, _ := tracer.StartSpan(context.Background(), SpanWorkerExec)
+
+	cases := map[string]string{
+		``:                           `{"traceparent":"roadrunner.worker_exec"}`,
+		`{}`:                         `{"traceparent":"roadrunner.worker_exec"}`,
+		`{"a":1}`:                    `{"traceparent":"roadrunner.worker_exec","a":1}`,
+		` {"a":{"b":[1]}} `:          `{"traceparent":"roadrunner.worker_exec","a":{"b":[1]}}`,
+		`{"traceparent":"external"}`: `{"traceparent":"external"}`,
+		`raw context`:                `raw context`,
+		`{invalid`:                   `{invalid`,
+		`[1,2]`:                      `[1,2]`,
+	}
+
+	for header, expected := range cases {
+		rqs := &Payload{Context: []byte(header), Body: []byte("body")}
+		traced := injectTrace(tracer, ctx, rqs)
+
+		assert.Equal(t, expected, string(traced.Context), header)
+		assert.Equal(t, "body", traced.String())
+		assert.Equal(t, header, string(rqs.Context))
+	}
+
+	// no trace context
+	rqs := &Payload{Body: []byte("body")}
+	assert.True(t, rqs == injectTrace(tracer, context.Background(), rqs))
+	assert.True(t, rqs == injectTrace(nopTracer{}, ctx, rqs))
+}
+
+func Test_Tracer_Exec(t *testing.T) {
+	tracer := &recordingTracer{}
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "head", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      1,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+			Tracer:          tracer,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	assert.Equal(t, []string{SpanSpawn}, tracer.spans())
+
+	// worker continues the trace of the worker_exec span
+	res, err := p.Exec(&Payload{Context: []byte(`{"key":"value"}`), Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"traceparent":"roadrunner.worker_exec","key":"value"}`, string(res.Context))
+
+	assert.Equal(t, []string{
+		SpanSpawn,
+		SpanExec + "/" + SpanQueueWait,
+		SpanExec + "/" + SpanWorkerExec,
+		SpanExec,
+	}, tracer.spans())
+}
+
+func Test_Tracer_DynamicExec(t *testing.T) {
+	tracer := &recordingTracer{}
+	p, err := NewDynamicPool(
+		func(wc WorkerConfig) *exec.Cmd { return exec.Command("php", "tests/client.php", "head", "pipes") },
+		NewPipeFactory(),
+		DynamicConfig{
+			MinWorkers:       1,
+			MaxWorkers:       1,
+			ScaleUpThreshold: 1,
+			AllocateTimeout:  time.Second,
+			DestroyTimeout:   time.Second,
+			Tracer:           tracer,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	assert.Equal(t, []string{SpanSpawn}, tracer.spans())
+
+	res, err := p.Exec(&Payload{Context: []byte(`{"key":"value"}`), Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"traceparent":"roadrunner.worker_exec","key":"value"}`, string(res.Context))
+
+	assert.Equal(t, []string{
+		SpanSpawn,
+		SpanExec + "/" + SpanQueueWait,
+		SpanExec + "/" + SpanWorkerExec,
+		SpanExec,
+	}, tracer.spans())
+}
+
+func Test_Tracer_Spawn(t *testing.T) {
+	time.Sleep(time.Millisecond * 10) // to ensure free socket
+
+	ls, err := net.Listen("tcp", "localhost:9007")
+	if err != nil {
+		t.Skip("socket is busy")
+	}
+
+	tracer := &recordingTracer{}
+	f := NewSocketFactory(ls, time.Minute)
+	f.Tracer = tracer
+	defer f.Close()
+
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "echo", "tcp") },
+		f,
+		Config{
+			NumWorkers:      2,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+			Tracer:          tracer,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	assert.ElementsMatch(t, []string{
+		SpanSpawn + "/" + SpanRelayWait,
+		SpanSpawn + "/" + SpanRelayWait,
+		SpanSpawn,
+		SpanSpawn,
+	}, tracer.spans())
+}
@@ -0,0 +1,51 @@
+package roadrunner
+
+import (
+	"context"
+	"fmt"
+)
+
+// TypedPool executes tasks of the underlying pool passing values instead of the raw payloads,
+// request values are encoded and response bodies are decoded by the codec, for example to send
+// protobuf or JSON messages without marshaling them around every Exec. Raw Exec methods of the
+// pool stay available. Flags of the body frames are defined by the factory codec, codecs of the
+// typed pool and the factory are expected to match.
+type TypedPool struct {
+	Pool
+
+	// Codec encodes request values and decodes response bodies, RawCodec when nil.
+	Codec Codec
+}
+
+// NewTypedPool wraps the pool encoding and decoding task bodies using the given codec.
+func NewTypedPool(p Pool, codec Codec) *TypedPool {
+	return &TypedPool{Pool: p, Codec: codec}
+}
+
+// ExecValue encodes rqs, executes it and decodes response body into rsp. Response context is
+// returned as is, encode and decode errors are wrapped as "encode error" and "decode error".
+func (p *TypedPool) ExecValue(rqs interface{}, rsp interface{}) (context []byte, err error) {
+	return execValue(p.codec(), p.Pool.Exec, rqs, rsp)
+}
+
+// ExecValueContext executes the value task like ExecValue until context is done, see
+// ManagedPool.ExecContext. Fails when underlying pool does not implement ManagedPool.
+func (p *TypedPool) ExecValueContext(ctx context.Context, rqs interface{}, rsp interface{}) (context []byte, err error) {
+	mp, ok := p.Pool.(ManagedPool)
+	if !ok {
+		return nil, fmt.Errorf("pool does not support context execution")
+	}
+
+	return execValue(p.codec(), func(rqs *Payload) (*Payload, error) {
+		return mp.ExecContext(ctx, rqs)
+	}, rqs, rsp)
+}
+
+// codec returns codec of the pool.
+func (p *TypedPool) codec() Codec {
+	if p.Codec == nil {
+		return RawCodec{}
+	}
+
+	return p.Codec
+}
@@ -0,0 +1,120 @@
+package roadrunner
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+// echoPool responds with the request body and context.
+type echoPool struct {
+	stubPool
+}
+
+func (p *echoPool) Exec(rqs *Payload) (*Payload, error) {
+	if string(rqs.Body) == `"fail"` {
+		return nil, fmt.Errorf("exec error")
+	}
+
+	return &Payload{Context: []byte("ctx"), Body: rqs.Body}, nil
+}
+
+func (p *echoPool) ExecContext(ctx context.Context, rqs *Payload) (*Payload, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return p.Exec(rqs)
+}
+
+type typedTask struct {
+	Name  string   `json:"name"`
+	Tags  []string `json:"tags"`
+	Count int      `json:"count"`
+}
+
+func Test_TypedPool_JSON(t *testing.T) {
+	p := NewTypedPool(&echoPool{}, JSONCodec{})
+
+	res := typedTask{}
+	ctx, err := p.ExecValue(typedTask{Name: "hello", Tags: []string{"a", "b"}, Count: 2}, &res)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("ctx"), ctx)
+	assert.Equal(t, typedTask{Name: "hello", Tags: []string{"a", "b"}, Count: 2}, res)
+
+	cctx, cancel := context.WithCancel(context.Background())
+	res = typedTask{}
+	_, err = p.ExecValueContext(cctx, typedTask{Name: "hello"}, &res)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", res.Name)
+
+	cancel()
+	_, err = p.ExecValueContext(cctx, typedTask{Name: "hello"}, &res)
+	assert.Equal(t, context.Canceled, err)
+}
+
+func Test_TypedPool_Errors(t *testing.T) {
+	p := NewTypedPool(&echoPool{}, JSONCodec{})
+
+	_, err := p.ExecValue(make(chan int), nil)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "encode error")
+
+	_, err = p.ExecValue("fail", nil)
+	assert.Error(t, err)
+	assert.Equal(t, "exec error", err.Error())
+
+	// response context is kept
+	var res int
+	ctx, err := p.ExecValue(typedTask{Name: "hello"}, &res)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "decode error")
+	assert.Equal(t, []byte("ctx"), ctx)
+}
+
+func Test_TypedPool_Raw(t *testing.T) {
+	p := &TypedPool{Pool: &echoPool{}}
+
+	var res string
+	_, err := p.ExecValue("hello", &res)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", res)
+
+	// raw execution is available
+	rsp, err := p.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", rsp.String())
+}
+
+func Test_TypedPool_ExecValue(t *testing.T) {
+	f := NewPipeFactory()
+	f.Codec = JSONCodec{}
+
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "json", "pipes") },
+		f,
+		Config{
+			NumWorkers:      1,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	type task struct {
+		Name string `json:"name"`
+		Pid  int    `json:"pid"`
+	}
+
+	tp := NewTypedPool(p, JSONCodec{})
+
+	res := task{}
+	_, err = tp.ExecValue(task{Name: "hello"}, &res)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", res.Name)
+	assert.Equal(t, *p.Workers()[0].Pid, res.Pid)
+}
@@ -0,0 +1,28 @@
+// +build linux
+
+package roadrunner
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// unreadBytes returns number of bytes received by the transport but not read yet, ok is false
+// when transport does not expose the file descriptor.
+func unreadBytes(c syscall.Conn) (n int, ok bool) {
+	raw, err := c.SyscallConn()
+	if err != nil {
+		return 0, false
+	}
+
+	var errno syscall.Errno
+	err = raw.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TIOCINQ, uintptr(unsafe.Pointer(&n)))
+	})
+
+	if err != nil || errno != 0 {
+		return 0, false
+	}
+
+	return n, true
+}
@@ -0,0 +1,294 @@
+// +build linux
+
+package roadrunner
+
+import (
+	"bytes"
+	"github.com/pkg/errors"
+	"github.com/spiral/goridge/v2"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func Test_UnreadBytes(t *testing.T) {
+	r, w, err := os.Pipe()
+	assert.NoError(t, err)
+	defer r.Close()
+	defer w.Close()
+
+	n, ok := unreadBytes(r)
+	assert.True(t, ok)
+	assert.Equal(t, 0, n)
+
+	_, err = w.Write([]byte("hello"))
+	assert.NoError(t, err)
+
+	n, ok = unreadBytes(r)
+	assert.True(t, ok)
+	assert.Equal(t, 5, n)
+}
+
+func Test_Exec_UnexpectedFrame(t *testing.T) {
+	w, _ := newWorker(exec.Command("sleep", "10"))
+	assert.NoError(t, w.start())
+	defer w.Kill()
+
+	conn, wConn := connPair(t)
+
+	w.rl = goridge.NewSocketRelay(conn)
+	w.stream = conn.(syscall.Conn)
+	w.state.set(StateReady)
+
+	go func() {
+		rl := goridge.NewSocketRelay(wConn)
+		if _, _, err := rl.Receive(); err != nil {
+			return
+		}
+		body, _, err := rl.Receive()
+		if err != nil {
+			return
+		}
+
+		// worker responds twice to the same request, both responses are sent at once
+		buf := &bufferRelay{}
+		frames := goridge.NewSocketRelay(buf)
+		for i := 0; i < 2; i++ {
+			_ = frames.Send(nil, goridge.PayloadControl|goridge.PayloadEmpty)
+			_ = frames.Send(body, goridge.PayloadRaw)
+		}
+
+		_, _ = wConn.Write(buf.Bytes())
+	}()
+
+	res, err := w.Exec(&Payload{Body: []byte("hello")})
+	assert.Nil(t, res)
+	assert.Error(t, err)
+	assert.Equal(t, ErrUnexpectedFrame, errors.Cause(err))
+	assert.Equal(t, StateErrored, w.State().Value())
+}
+
+func Test_Exec_UnhealthyNotice(t *testing.T) {
+	w, _ := newWorker(exec.Command("sleep", "10"))
+	assert.NoError(t, w.start())
+	defer w.Kill()
+
+	conn, wConn := connPair(t)
+
+	w.rl = goridge.NewSocketRelay(conn)
+	w.stream = conn.(syscall.Conn)
+	w.state.set(StateReady)
+
+	go func() {
+		rl := goridge.NewSocketRelay(wConn)
+		if _, _, err := rl.Receive(); err != nil {
+			return
+		}
+		body, _, err := rl.Receive()
+		if err != nil {
+			return
+		}
+
+		// worker reports itself unhealthy right after the response
+		buf := &bufferRelay{}
+		frames := goridge.NewSocketRelay(buf)
+		_ = frames.Send(nil, goridge.PayloadControl|goridge.PayloadEmpty)
+		_ = frames.Send(body, goridge.PayloadRaw)
+		_ = sendControl(frames, &unhealthyCommand{Unhealthy: true})
+
+		_, _ = wConn.Write(buf.Bytes())
+	}()
+
+	res, err := w.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", res.String())
+	assert.Equal(t, StateReady, w.State().Value())
+	assert.True(t, w.Unhealthy())
+}
+
+func Test_CheckIdle(t *testing.T) {
+	w, _ := newWorker(exec.Command("sleep", "10"))
+	assert.NoError(t, w.start())
+	defer w.Kill()
+
+	conn, wConn := connPair(t)
+
+	w.rl = goridge.NewSocketRelay(conn)
+	w.stream = conn.(syscall.Conn)
+	w.state.set(StateReady)
+
+	assert.NoError(t, w.checkIdle())
+
+	rl := goridge.NewSocketRelay(wConn)
+	assert.NoError(t, sendControl(rl, &unhealthyCommand{Unhealthy: true}))
+
+	// to ensure that command is received
+	time.Sleep(time.Millisecond * 10)
+
+	assert.Equal(t, ErrWorkerUnhealthy, w.checkIdle())
+	assert.Equal(t, StateReady, w.State().Value())
+
+	assert.NoError(t, sendControl(rl, []byte("hello")))
+	time.Sleep(time.Millisecond * 10)
+
+	assert.Equal(t, ErrUnexpectedFrame, errors.Cause(w.checkIdle()))
+	assert.Equal(t, StateErrored, w.State().Value())
+}
+
+func Test_Worker_ConsecutiveMalformed(t *testing.T) {
+	w, _ := newWorker(exec.Command("sleep", "10"))
+	assert.NoError(t, w.start())
+	defer w.Kill()
+
+	conn, wConn := connPair(t)
+
+	w.rl = goridge.NewSocketRelay(conn)
+	w.stream = conn.(syscall.Conn)
+	w.state.set(StateReady)
+
+	steps := []string{"malformed", "clean", "malformed", "malformed", "error"}
+	go func() {
+		rl := goridge.NewSocketRelay(wConn)
+		for _, step := range steps {
+			respondTask := func(frames goridge.Relay) {
+				_ = frames.Send(nil, goridge.PayloadControl|goridge.PayloadEmpty)
+				_ = frames.Send([]byte(step), goridge.PayloadRaw)
+			}
+
+			for i := 0; i < 2; i++ {
+				if _, _, err := rl.Receive(); err != nil {
+					return
+				}
+			}
+
+			switch step {
+			case "clean":
+				respondTask(rl)
+			case "error":
+				_ = rl.Send([]byte("job failed"), goridge.PayloadControl|goridge.PayloadError)
+			case "malformed":
+				// second response to the same request is sent along with the first one
+				buf := &bufferRelay{}
+				frames := goridge.NewSocketRelay(buf)
+				respondTask(frames)
+				respondTask(frames)
+				_, _ = wConn.Write(buf.Bytes())
+
+				if !echoSentinel(rl) {
+					return
+				}
+			}
+		}
+	}()
+
+	for i, expected := range []int64{1, 0, 1, 2, 0} {
+		_, err := w.Exec(&Payload{Body: []byte("hello")})
+		assert.Equal(t, steps[i] != "clean", err != nil, steps[i])
+		assert.Equal(t, expected, w.ConsecutiveMalformed(), steps[i])
+		assert.Equal(t, expected, w.Snapshot().ConsecutiveMalformed, steps[i])
+
+		if steps[i] == "malformed" {
+			assert.Equal(t, ErrUnexpectedFrame, errors.Cause(err))
+			assert.NoError(t, w.Resync(time.Second))
+		}
+	}
+}
+
+func Test_StaticPool_MaxConsecutiveMalformed(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "malformed", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:              1,
+			AllocateTimeout:         time.Second,
+			DestroyTimeout:          time.Second,
+			ResyncTimeout:           time.Second,
+			MaxConsecutiveMalformed: 2,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	pid := *p.Workers()[0].Pid
+
+	// alternating malformed responses are resynced
+	for i := 0; i < 3; i++ {
+		_, err = p.Exec(&Payload{Body: []byte("malformed")})
+		assert.Equal(t, ErrUnexpectedFrame, errors.Cause(err))
+		assert.Equal(t, int64(1), p.Workers()[0].ConsecutiveMalformed())
+
+		res, err := p.Exec(&Payload{Body: []byte("clean")})
+		assert.NoError(t, err)
+		assert.Equal(t, "clean", res.String())
+		assert.Equal(t, int64(0), p.Workers()[0].ConsecutiveMalformed())
+	}
+	assert.Equal(t, pid, *p.Workers()[0].Pid)
+
+	// second malformed response in a row retires the worker
+	_, err = p.Exec(&Payload{Body: []byte("malformed")})
+	assert.Equal(t, ErrUnexpectedFrame, errors.Cause(err))
+	assert.Equal(t, pid, *p.Workers()[0].Pid)
+
+	_, err = p.Exec(&Payload{Body: []byte("malformed")})
+	assert.Equal(t, ErrUnexpectedFrame, errors.Cause(err))
+
+	res, err := p.Exec(&Payload{Body: []byte("clean")})
+	assert.NoError(t, err)
+	assert.Equal(t, "clean", res.String())
+	assert.NotEqual(t, pid, *p.Workers()[0].Pid)
+
+	for i := 0; i < 100 && p.Stats().RecycleReasons[RecycleProtocolViolation] == 0; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	assert.Equal(t, int64(1), p.Stats().RecycleReasons[RecycleProtocolViolation])
+}
+
+func Test_DynamicPool_MaxConsecutiveMalformed(t *testing.T) {
+	p, err := NewDynamicPool(
+		func(wc WorkerConfig) *exec.Cmd { return exec.Command("php", "tests/client.php", "malformed", "pipes") },
+		NewPipeFactory(),
+		DynamicConfig{
+			MinWorkers:              1,
+			MaxWorkers:              1,
+			ScaleUpThreshold:        1,
+			AllocateTimeout:         time.Second,
+			DestroyTimeout:          time.Second,
+			ResyncTimeout:           time.Second,
+			MaxConsecutiveMalformed: 2,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	pid := *p.Workers()[0].Pid
+
+	_, err = p.Exec(&Payload{Body: []byte("malformed")})
+	assert.Equal(t, ErrUnexpectedFrame, errors.Cause(err))
+	assert.Equal(t, pid, *p.Workers()[0].Pid)
+
+	// second malformed response in a row retires the worker
+	_, err = p.Exec(&Payload{Body: []byte("malformed")})
+	assert.Equal(t, ErrUnexpectedFrame, errors.Cause(err))
+
+	res, err := p.Exec(&Payload{Body: []byte("clean")})
+	assert.NoError(t, err)
+	assert.Equal(t, "clean", res.String())
+	assert.NotEqual(t, pid, *p.Workers()[0].Pid)
+
+	for i := 0; i < 100 && p.Stats().RecycleReasons[RecycleProtocolViolation] == 0; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	assert.Equal(t, int64(1), p.Stats().RecycleReasons[RecycleProtocolViolation])
+}
+
+// bufferRelay collects frames written by the relay.
+type bufferRelay struct {
+	bytes.Buffer
+}
+
+func (b *bufferRelay) Close() error {
+	return nil
+}
@@ -0,0 +1,10 @@
+// +build !linux
+
+package roadrunner
+
+import "syscall"
+
+// unreadBytes does nothing, unread data detection is supported on Linux only.
+func unreadBytes(c syscall.Conn) (n int, ok bool) {
+	return 0, false
+}
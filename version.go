@@ -0,0 +1,103 @@
+package roadrunner
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// semver contains major, minor and patch version numbers, pre-release and build metadata are ignored.
+type semver [3]int
+
+// parseSemver parses version in form of [v]MAJOR[.MINOR[.PATCH]][-pre][+build].
+func parseSemver(version string) (v semver, err error) {
+	s := strings.TrimPrefix(strings.TrimSpace(version), "v")
+	if i := strings.IndexAny(s, "-+"); i != -1 {
+		s = s[:i]
+	}
+
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 || s == "" {
+		return v, fmt.Errorf("invalid version `%s`", version)
+	}
+
+	for i, p := range parts {
+		if v[i], err = strconv.Atoi(p); err != nil || v[i] < 0 {
+			return v, fmt.Errorf("invalid version `%s`", version)
+		}
+	}
+
+	return v, nil
+}
+
+// compare returns -1, 0 or 1 when version is less, equal or greater than o.
+func (v semver) compare(o semver) int {
+	for i := range v {
+		switch {
+		case v[i] < o[i]:
+			return -1
+		case v[i] > o[i]:
+			return 1
+		}
+	}
+
+	return 0
+}
+
+// matchVersion returns true if version satisfies all space (or comma) separated conditions of the
+// constraint, example: ">=2.0.0 <3.0.0". Supported operators: =, !=, >, >=, <, <=.
+func matchVersion(constraint, version string) (bool, error) {
+	v, err := parseSemver(version)
+	if err != nil {
+		return false, err
+	}
+
+	conditions := strings.FieldsFunc(constraint, func(r rune) bool {
+		return r == ' ' || r == ','
+	})
+
+	if len(conditions) == 0 {
+		return false, fmt.Errorf("empty version constraint")
+	}
+
+	for _, cond := range conditions {
+		i := strings.IndexFunc(cond, func(r rune) bool {
+			return !strings.ContainsRune("=!<>", r)
+		})
+		if i == -1 {
+			return false, fmt.Errorf("invalid version constraint `%s`", constraint)
+		}
+
+		op := cond[:i]
+		c, err := parseSemver(cond[i:])
+		if err != nil {
+			return false, fmt.Errorf("invalid version constraint `%s`", constraint)
+		}
+
+		cmp := v.compare(c)
+
+		var ok bool
+		switch op {
+		case "", "=", "==":
+			ok = cmp == 0
+		case "!=":
+			ok = cmp != 0
+		case ">":
+			ok = cmp > 0
+		case ">=":
+			ok = cmp >= 0
+		case "<":
+			ok = cmp < 0
+		case "<=":
+			ok = cmp <= 0
+		default:
+			return false, fmt.Errorf("invalid version constraint `%s`", constraint)
+		}
+
+		if !ok {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
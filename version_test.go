@@ -0,0 +1,63 @@
+package roadrunner
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_ParseSemver(t *testing.T) {
+	v, err := parseSemver("2.1.3")
+	assert.NoError(t, err)
+	assert.Equal(t, semver{2, 1, 3}, v)
+
+	v, err = parseSemver("v2.1")
+	assert.NoError(t, err)
+	assert.Equal(t, semver{2, 1, 0}, v)
+
+	v, err = parseSemver("2.0.0-beta+build")
+	assert.NoError(t, err)
+	assert.Equal(t, semver{2, 0, 0}, v)
+
+	for _, invalid := range []string{"", "2.a", "1.2.3.4", "-1"} {
+		_, err = parseSemver(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func Test_MatchVersion(t *testing.T) {
+	cases := []struct {
+		constraint string
+		version    string
+		match      bool
+	}{
+		{">=2.0.0 <3.0.0", "2.0.0", true},
+		{">=2.0.0 <3.0.0", "2.9.1", true},
+		{">=2.0.0 <3.0.0", "3.0.0", false},
+		{">=2.0.0, <3.0.0", "1.9.9", false},
+		{"2.1.0", "2.1.0", true},
+		{"=2.1", "2.1.1", false},
+		{"!=2.1.0", "2.1.0", false},
+		{">2", "2.0.1", true},
+		{"<=2", "2.0.0", true},
+	}
+
+	for _, c := range cases {
+		match, err := matchVersion(c.constraint, c.version)
+		assert.NoError(t, err)
+		assert.Equal(t, c.match, match, "%s %s", c.constraint, c.version)
+	}
+}
+
+func Test_MatchVersion_Invalid(t *testing.T) {
+	_, err := matchVersion("", "2.0.0")
+	assert.Error(t, err)
+
+	_, err = matchVersion("~>2.0", "2.0.0")
+	assert.Error(t, err)
+
+	_, err = matchVersion("=>2.0", "2.0.0")
+	assert.Error(t, err)
+
+	_, err = matchVersion(">=2.0.0", "invalid")
+	assert.Error(t, err)
+}
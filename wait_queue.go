@@ -0,0 +1,201 @@
+package roadrunner
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// NumPriorities defines number of the priority bands of the tasks waiting for the free worker.
+const NumPriorities = 3
+
+const (
+	// PriorityLow is the priority of the background tasks, served once no tasks of the higher
+	// priority are waiting.
+	PriorityLow = iota
+
+	// PriorityNormal is the priority of the tasks executed by Exec.
+	PriorityNormal
+
+	// PriorityHigh is the priority of the tasks which must jump ahead of other waiting tasks,
+	// such as health checks or administrative operations.
+	PriorityHigh
+)
+
+// waiter represents task waiting for the free worker.
+type waiter struct {
+	// receives worker passed to the waiter
+	c chan *Worker
+
+	// requested priority band
+	priority int
+
+	// time when task started to wait
+	since time.Time
+
+	// position within the band
+	e *list.Element
+}
+
+// waitQueue hands released workers over to the tasks waiting for them, the oldest waiter of the
+// highest priority band receives the next released worker. Waiters of the lower bands are
+// promoted one band up every aging interval, so low priority tasks are never starved by the
+// constant flow of the higher priority ones.
+type waitQueue struct {
+	mu    sync.Mutex
+	bands [NumPriorities]list.List
+
+	// promotes waiter one band up, 0 disables aging
+	aging time.Duration
+
+	// provides waiting time, nil for the system clock
+	clock Clock
+}
+
+// put passes worker to the next waiter, worker is added to the free buf when nobody is waiting.
+// Free buf might be filled with dead workers, put blocks without holding the queue lock until
+// allocation drains them.
+func (q *waitQueue) put(free chan *Worker, w *Worker) {
+	q.mu.Lock()
+	if wt := q.next(); wt != nil {
+		q.remove(wt)
+		wt.c <- w
+		q.mu.Unlock()
+		return
+	}
+
+	select {
+	case free <- w:
+		q.mu.Unlock()
+		return
+	default:
+		q.mu.Unlock()
+	}
+
+	free <- w
+
+	// waiters could have been registered while buf was drained
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for wt := q.next(); wt != nil; wt = q.next() {
+		select {
+		case fw := <-free:
+			q.remove(wt)
+			wt.c <- fw
+		default:
+			return
+		}
+	}
+}
+
+// take returns worker from the free buf or registers new waiter of the given priority when buf
+// is empty. Since keeps the waiting time of the task registered again after receiving dead
+// worker, zero since starts waiting now.
+func (q *waitQueue) take(free chan *Worker, priority int, since time.Time) (*Worker, *waiter) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	select {
+	case w := <-free:
+		return w, nil
+	default:
+	}
+
+	if since.IsZero() {
+		since = clockOrSystem(q.clock).Now()
+	}
+
+	wt := &waiter{c: make(chan *Worker, 1), priority: clampPriority(priority), since: since}
+	wt.e = q.bands[wt.priority].PushBack(wt)
+
+	return nil, wt
+}
+
+// leave removes waiter from the queue, returns worker if it has been passed to the waiter already.
+func (q *waitQueue) leave(wt *waiter) *Worker {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	select {
+	case w := <-wt.c:
+		return w
+	default:
+		q.remove(wt)
+		return nil
+	}
+}
+
+// depths returns number of waiters by requested priority.
+func (q *waitQueue) depths() (depths [NumPriorities]int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i := range q.bands {
+		depths[i] = q.bands[i].Len()
+	}
+
+	return depths
+}
+
+// next returns waiter which must receive the next worker, nil when nobody is waiting. Only the
+// oldest waiter of every band is considered, the highest aged priority wins and the oldest
+// waiter wins the tie. Must be called under the queue lock.
+func (q *waitQueue) next() (next *waiter) {
+	var now time.Time
+	if q.aging != 0 {
+		now = clockOrSystem(q.clock).Now()
+	}
+
+	rank := 0
+	for i := range q.bands {
+		e := q.bands[i].Front()
+		if e == nil {
+			continue
+		}
+
+		wt := e.Value.(*waiter)
+		r := q.rank(wt, now)
+		if next == nil || r > rank || (r == rank && wt.since.Before(next.since)) {
+			next, rank = wt, r
+		}
+	}
+
+	return next
+}
+
+// rank returns priority of the waiter promoted by the time it waits.
+func (q *waitQueue) rank(wt *waiter, now time.Time) int {
+	if q.aging == 0 {
+		return wt.priority
+	}
+
+	promoted := now.Sub(wt.since) / q.aging
+	if promoted > NumPriorities {
+		promoted = NumPriorities
+	}
+
+	return clampPriority(wt.priority + int(promoted))
+}
+
+// remove removes waiter from its band, must be called under the queue lock.
+func (q *waitQueue) remove(wt *waiter) {
+	q.bands[wt.priority].Remove(wt.e)
+}
+
+// wait returns channel receiving worker passed to the waiter.
+func wait(wt *waiter) <-chan *Worker {
+	return wt.c
+}
+
+// clampPriority limits priority to the available bands.
+func clampPriority(priority int) int {
+	switch {
+	case priority < PriorityLow:
+		return PriorityLow
+	case priority > PriorityHigh:
+		return PriorityHigh
+	default:
+		return priority
+	}
+}
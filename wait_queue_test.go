@@ -0,0 +1,130 @@
+package roadrunner
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func Test_WaitQueue_Free(t *testing.T) {
+	q := &waitQueue{}
+	free := make(chan *Worker, 1)
+
+	w := &Worker{}
+	q.put(free, w)
+
+	wc, e := q.take(free, PriorityNormal, time.Time{})
+	assert.Nil(t, e)
+	assert.Equal(t, w, wc)
+}
+
+func Test_WaitQueue_FIFO(t *testing.T) {
+	q := &waitQueue{}
+	free := make(chan *Worker, 1)
+
+	_, first := q.take(free, PriorityNormal, time.Time{})
+	_, second := q.take(free, PriorityNormal, time.Time{})
+	assert.NotNil(t, first)
+	assert.NotNil(t, second)
+
+	w1, w2 := &Worker{}, &Worker{}
+	q.put(free, w1)
+	q.put(free, w2)
+
+	assert.Equal(t, w1, <-wait(first))
+	assert.Equal(t, w2, <-wait(second))
+	assert.Len(t, free, 0)
+}
+
+func Test_WaitQueue_Leave(t *testing.T) {
+	q := &waitQueue{}
+	free := make(chan *Worker, 1)
+
+	_, first := q.take(free, PriorityNormal, time.Time{})
+	_, second := q.take(free, PriorityNormal, time.Time{})
+
+	// waiter which left the queue is skipped
+	assert.Nil(t, q.leave(first))
+
+	w := &Worker{}
+	q.put(free, w)
+
+	// worker passed before waiter left the queue is returned
+	assert.Equal(t, w, q.leave(second))
+
+	q.put(free, w)
+	assert.Equal(t, w, <-free)
+}
+
+func Test_WaitQueue_Priority(t *testing.T) {
+	q := &waitQueue{}
+	free := make(chan *Worker, 1)
+
+	_, low := q.take(free, PriorityLow, time.Time{})
+	_, normal := q.take(free, PriorityNormal, time.Time{})
+	_, high := q.take(free, PriorityHigh, time.Time{})
+	_, high2 := q.take(free, PriorityHigh+1, time.Time{})
+	assert.Equal(t, [NumPriorities]int{1, 1, 2}, q.depths())
+
+	workers := []*Worker{{}, {}, {}, {}}
+	for _, w := range workers {
+		q.put(free, w)
+	}
+
+	// higher bands first, FIFO within the band
+	assert.Equal(t, workers[0], <-wait(high))
+	assert.Equal(t, workers[1], <-wait(high2))
+	assert.Equal(t, workers[2], <-wait(normal))
+	assert.Equal(t, workers[3], <-wait(low))
+	assert.Equal(t, [NumPriorities]int{}, q.depths())
+}
+
+func Test_WaitQueue_Aging(t *testing.T) {
+	clock := newMockClock()
+	q := &waitQueue{aging: time.Second, clock: clock}
+	free := make(chan *Worker, 1)
+
+	_, low := q.take(free, PriorityLow, time.Time{})
+	waiting := []*waiter{low}
+
+	// constant flow of high priority tasks, each waiting for a second
+	var served []*waiter
+	for i := 0; i < 4; i++ {
+		_, high := q.take(free, PriorityHigh, time.Time{})
+		waiting = append(waiting, high)
+		clock.Advance(time.Second)
+
+		q.put(free, &Worker{})
+		for j, wt := range waiting {
+			if len(wait(wt)) == 1 {
+				served = append(served, wt)
+				waiting = append(waiting[:j], waiting[j+1:]...)
+				break
+			}
+		}
+	}
+
+	// low priority task is promoted to the top band after two seconds and served as the oldest
+	assert.Len(t, served, 4)
+	assert.NotEqual(t, low, served[0])
+	assert.Equal(t, low, served[1])
+	assert.Equal(t, [NumPriorities]int{0, 0, 1}, q.depths())
+}
+
+func Test_WaitQueue_Aging_Since(t *testing.T) {
+	clock := newMockClock()
+	q := &waitQueue{aging: time.Second, clock: clock}
+	free := make(chan *Worker, 1)
+
+	since := clock.Now()
+	clock.Advance(time.Second * 2)
+
+	// registered again keeping the original waiting time
+	_, high := q.take(free, PriorityHigh, time.Time{})
+	_, low := q.take(free, PriorityLow, since)
+	assert.Equal(t, since, low.since)
+
+	q.put(free, &Worker{})
+	assert.Len(t, wait(low), 1)
+	assert.Len(t, wait(high), 0)
+}
@@ -1,17 +1,38 @@
 package roadrunner
 
 import (
+	"context"
 	"fmt"
+	json "github.com/json-iterator/go"
 	"github.com/pkg/errors"
+	"github.com/shirou/gopsutil/process"
 	"github.com/spiral/goridge/v2"
+	"io"
+	"net"
 	"os"
 	"os/exec"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
+const (
+	// PingTimeout defines for how long worker waits for ping response.
+	PingTimeout = time.Second
+
+	// ProtocolTimeout defines for how long factory waits for worker protocol version.
+	ProtocolTimeout = time.Second
+
+	// StderrTailSize defines how many bytes of stderr output are included into worker start error.
+	StderrTailSize = 4 * 1024
+
+	// CancelTimeout defines for how long worker is given to respond to the canceled task.
+	CancelTimeout = time.Second
+)
+
 // Worker - supervised process with api over goridge.Relay.
 type Worker struct {
 	// Pid of the process, points to Pid of underlying process and
@@ -21,6 +42,14 @@ type Worker struct {
 	// Created indicates at what time worker has been created.
 	Created time.Time
 
+	// ID is logical worker identity assigned by the pool per worker slot (for example worker-0),
+	// unlike Pid it is kept by the workers replacing each other in the slot. Empty for workers
+	// not spawned by the pool.
+	ID string
+
+	// Transport indicates the relay type worker is connected over (pipes, tcp, unix).
+	Transport string
+
 	// state holds information about current worker state,
 	// number of worker executions, buf status change time.
 	// publicly this object is receive-only and protected using Mutex
@@ -36,6 +65,9 @@ type Worker struct {
 	// receive only once command is completed and all pipes are closed.
 	err *errBuffer
 
+	// recent executions kept for diagnostics.
+	execs execRing
+
 	// channel is being closed once command is complete.
 	waitDone chan interface{}
 
@@ -47,6 +79,137 @@ type Worker struct {
 
 	// communication bus with underlying process.
 	rl goridge.Relay
+
+	// inspects frames received over the relay, nil when relay transport is not accessible.
+	frames *frameReader
+
+	// mirrors relay traffic to the sink, nil while worker is not observed, see Observe.
+	observer *relayObserver
+
+	// receives stdout of the process, nil when stdout is not captured.
+	stdout *stdoutCapture
+
+	// relay transport checked for unexpected data after every response, nil to skip the check
+	stream syscall.Conn
+
+	// request ID of the task being executed, holds string, kept once execution fails
+	request atomic.Value
+
+	// last status reported by the worker, holds string, see StatusField
+	status atomic.Value
+
+	// socket connection of the relay, nil for pipes and custom relay sources.
+	conn *frameConn
+
+	// max size of payload context and body in bytes, accessed atomically, 0 for unlimited.
+	maxPayload int64
+
+	// max number of frames received in response to the task, accessed atomically, 0 for unlimited.
+	maxFrames int64
+
+	// number of frames received in response to the current task, protected by mu.
+	received int64
+
+	// max duration of the resync following timed out execution, accessed atomically, 0 to kill
+	// the worker instead.
+	resyncTimeout int64
+
+	// encodes and decodes payload bodies, raw when nil.
+	codec Codec
+
+	// host functions worker can call during the task, assigned by the pool, nil when none.
+	handlers *handlers
+
+	// indicates that relay connection has been passed to the caller, accessed atomically.
+	hijacked int32
+
+	// indicates that worker reported itself unhealthy, accessed atomically.
+	unhealthy int32
+
+	// indicates that worker has been killed once task execution timed out, accessed atomically.
+	timedOut int32
+
+	// metadata sent by the worker during the relay handshake.
+	meta map[string]string
+
+	// distribution of the execution durations.
+	latency latencyHistogram
+
+	// multiplexes concurrent tasks over the relay, nil when worker executes one task at a time.
+	mux *muxRelay
+
+	// operating system limits applied by start before the worker program runs, set by the factory.
+	limits ResourceLimits
+
+	// time spent starting the process, set once by start.
+	startDuration time.Duration
+
+	// time spent waiting for the worker to connect and respond to the handshake, set once by
+	// the factory.
+	relayWait time.Duration
+
+	// last sampled CPU time of the running process, accessed atomically
+	cpuTime int64
+
+	// number of consecutive malformed responses, accessed atomically
+	malformed int64
+}
+
+// WorkerSnapshot contains point in time information about the worker.
+type WorkerSnapshot struct {
+	// Pid of the process, 0 if process is not started.
+	Pid int
+
+	// ID is logical worker identity kept across the slot respawns, see Worker.ID.
+	ID string
+
+	// Status of the worker.
+	Status string
+
+	// NumExecs contains number of worker executions.
+	NumExecs int64
+
+	// Created indicates at what time worker has been created.
+	Created time.Time
+
+	// LastUsed indicates time of the last worker execution.
+	LastUsed time.Time
+
+	// Age of the worker since its creation.
+	Age time.Duration
+
+	// LastPayloadSize contains context and body size in bytes of the last executed payload.
+	LastPayloadSize int
+
+	// Busy indicates that worker is executing the task (at least one for multiplexed workers).
+	Busy bool
+
+	// TaskStarted contains start time of the current task, zero time when worker is not working.
+	TaskStarted time.Time
+
+	// Meta contains metadata sent by the worker during the relay handshake, see HandshakeFunc.
+	Meta map[string]string
+
+	// LatencyBuckets contains distribution of the worker execution durations, see LatencyBounds
+	// and MergeLatency.
+	LatencyBuckets LatencyBuckets
+
+	// StartDuration contains time spent starting the process (fork and exec).
+	StartDuration time.Duration
+
+	// RelayWaitDuration contains time spent waiting for the started process to connect back and
+	// respond to the handshake, long waits point to the slow worker boot.
+	RelayWaitDuration time.Duration
+
+	// CPUTime contains user and system CPU time consumed by the process, see Worker.CPUTime.
+	CPUTime time.Duration
+
+	// LastReportedStatus contains last status reported by the worker, see StatusField.
+	LastReportedStatus string
+
+	// ConsecutiveMalformed contains number of malformed responses worker has sent in a row, see
+	// Config.MaxConsecutiveMalformed.
+	ConsecutiveMalformed int64
 }
 
 // newWorker creates new worker over given exec.cmd.
@@ -69,12 +232,138 @@ func newWorker(cmd *exec.Cmd) (*Worker, error) {
 	return w, nil
 }
 
+// adoptWorker attaches to already running process with given PID. Process can only be waited
+// for when it is a child of the current process, otherwise worker is reported dead right away.
+// Stderr of the adopted process is not captured.
+func adoptWorker(pid int) (*Worker, error) {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Worker{
+		Pid:      &pid,
+		Created:  time.Now(),
+		cmd:      &exec.Cmd{Args: []string{"adopted"}, Process: p},
+		err:      newErrBuffer(),
+		waitDone: make(chan interface{}),
+		state:    newState(StateInactive),
+	}
+	w.watch()
+
+	return w, nil
+}
+
+// PID returns process id of the worker or 0 while process is not started.
+func (w *Worker) PID() int {
+	if w.Pid == nil {
+		return 0
+	}
+
+	return *w.Pid
+}
+
 // State return receive-only worker state object, state can be used to safely access
 // worker status, time when status changed and number of worker executions.
 func (w *Worker) State() State {
 	return w.state
 }
 
+// Snapshot returns current worker state information, safe to call while worker is executing.
+func (w *Worker) Snapshot() WorkerSnapshot {
+	snapshot := WorkerSnapshot{
+		ID:       w.ID,
+		Status:   w.state.String(),
+		NumExecs: w.state.NumExecs(),
+		Created:  w.Created,
+		LastUsed: w.state.LastUsed(),
+		Age:      time.Since(w.Created),
+		Busy:     w.IsBusy(),
+		Meta:     w.meta,
+
+		StartDuration:      w.startDuration,
+		RelayWaitDuration:  w.relayWait,
+		CPUTime:            w.CPUTime(),
+		LastReportedStatus: w.LastReportedStatus(),
+		TaskStarted:        w.TaskStarted(),
+
+		ConsecutiveMalformed: w.ConsecutiveMalformed(),
+	}
+	snapshot.LatencyBuckets = w.latency.snapshot()
+
+	if last, ok := w.execs.last(); ok {
+		snapshot.LastPayloadSize = last.ContextSize + last.BodySize
+	}
+
+	if w.Pid != nil {
+		snapshot.Pid = *w.Pid
+	}
+
+	return snapshot
+}
+
+// Diagnostics returns recent worker executions and stderr output, available after worker death.
+func (w *Worker) Diagnostics() Diagnostics {
+	return Diagnostics{
+		Execs:  w.execs.records(),
+		Stderr: w.err.Recent(),
+	}
+}
+
+// MemoryUsage returns resident memory of the underlying process in bytes.
+func (w *Worker) MemoryUsage() (uint64, error) {
+	if w.Pid == nil {
+		return 0, fmt.Errorf("process is not started")
+	}
+
+	p, err := process.NewProcess(int32(*w.Pid))
+	if err != nil {
+		return 0, err
+	}
+
+	i, err := p.MemoryInfo()
+	if err != nil {
+		return 0, err
+	}
+
+	return i.RSS, nil
+}
+
+// accountedMemory returns memory accounted to the worker by the kernel: memory.current of the
+// dedicated cgroup v2 of the worker process on Linux, resident memory otherwise.
+func (w *Worker) accountedMemory() (uint64, error) {
+	if w.Pid != nil {
+		if usage, ok := cgroupMemory(*w.Pid); ok {
+			return usage, nil
+		}
+	}
+
+	return w.MemoryUsage()
+}
+
+// CPUTime returns user and system CPU time consumed by the worker process, sampled from the
+// running process on every call on Linux (zero on other platforms while process is running) and
+// exact once process has exited, for example after the recycle. Worker consuming much more CPU
+// per execution than its peers points to the hot code path or spin loop.
+func (w *Worker) CPUTime() time.Duration {
+	select {
+	case <-w.waitDone:
+		if w.endState != nil {
+			return w.endState.UserTime() + w.endState.SystemTime()
+		}
+	default:
+	}
+
+	if w.Pid != nil {
+		if d, err := processCPUTime(*w.Pid); err == nil {
+			atomic.StoreInt64(&w.cpuTime, int64(d))
+		}
+	}
+
+	// last sample is kept once process is gone
+	return time.Duration(atomic.LoadInt64(&w.cpuTime))
+}
+
 // String returns worker description.
 func (w *Worker) String() string {
 	state := w.state.String()
@@ -101,7 +390,8 @@ func (w *Worker) Wait() error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	if w.endState.Success() {
+	// state is unknown when process is not a child of the current process
+	if w.endState != nil && w.endState.Success() {
 		w.state.set(StateStopped)
 		return nil
 	}
@@ -112,15 +402,65 @@ func (w *Worker) Wait() error {
 		w.state.set(StateStopped)
 	}
 
+	return w.waitError()
+}
+
+// WaitTimeout waits up to d for the process completion, exited is false when process is still
+// running. Unlike Wait does not change the worker state and can be called any number of times
+// before or after Wait. Process error is returned once process has exited.
+func (w *Worker) WaitTimeout(d time.Duration) (exited bool, err error) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-w.waitDone:
+	case <-timer.C:
+		return false, nil
+	}
+
+	if w.endState != nil && w.endState.Success() {
+		return true, nil
+	}
+
+	return true, w.waitError()
+}
+
+// ExitCode returns exit code of the worker process, -1 if process is still running or
+// was terminated by a signal.
+func (w *Worker) ExitCode() int {
+	select {
+	case <-w.waitDone:
+	default:
+		return -1
+	}
+
+	if w.endState == nil {
+		return -1
+	}
+
+	return w.endState.ExitCode()
+}
+
+// waitError describes process termination using process state and stderr output.
+func (w *Worker) waitError() WaitError {
+	wErr := WaitError{Code: -1}
+	if w.endState != nil {
+		wErr.Code = w.endState.ExitCode()
+		if ws, ok := w.endState.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			wErr.Signal = ws.Signal()
+		}
+	}
+
 	if w.err.Len() != 0 {
-		return errors.New(w.err.String())
+		wErr.Stderr = []byte(w.err.String())
 	}
 
-	// generic process error
-	return &exec.ExitError{ProcessState: w.endState}
+	return wErr
 }
 
 // Stop sends soft termination command to the worker and waits for process completion.
+// Stop can be called multiple times, concurrent calls wait for the same process completion.
+// Multiplexed worker completes running tasks before receiving the command.
 func (w *Worker) Stop() error {
 	select {
 	case <-w.waitDone:
@@ -129,14 +469,51 @@ func (w *Worker) Stop() error {
 		w.mu.Lock()
 		defer w.mu.Unlock()
 
+		select {
+		case <-w.waitDone:
+			// stopped while waiting for the lock
+			return nil
+		default:
+		}
+
 		w.state.set(StateStopping)
-		err := sendControl(w.rl, &stopCommand{Stop: true})
+
+		var err error
+		switch {
+		case w.mux != nil:
+			// running tasks are completed first
+			w.mux.close()
+			err = w.mux.sendControl(&stopCommand{Stop: true})
+		case !w.Hijacked():
+			// hijacked worker completes once the connection is closed
+			err = sendControl(w.rl, &stopCommand{Stop: true})
+		}
 
 		<-w.waitDone
 		return err
 	}
 }
 
+// StopWithTimeout sends soft termination command to the worker and waits d time for process
+// completion, worker is killed if it did not stop in time.
+func (w *Worker) StopWithTimeout(d time.Duration) error {
+	// buffered to let Stop complete once worker is killed
+	done := make(chan error, 1)
+	go func() {
+		done <- w.Stop()
+	}()
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		return w.Kill()
+	}
+}
+
 // Kill kills underlying process, make sure to call Wait() func to gather
 // error log from the stderr. Does not waits for process completion!
 func (w *Worker) Kill() error {
@@ -152,10 +529,68 @@ func (w *Worker) Kill() error {
 	}
 }
 
+// KillGraceful sends SIGTERM to the underlying process and waits d time for process completion,
+// process is killed once it did not exit in time. Unlike Kill lets the worker run its shutdown
+// handlers, process is killed right away when d is 0 or signal is not supported by the platform.
+func (w *Worker) KillGraceful(d time.Duration) error {
+	if d <= 0 {
+		return w.Kill()
+	}
+
+	select {
+	case <-w.waitDone:
+		return nil
+	default:
+	}
+
+	w.state.set(StateStopping)
+	if err := w.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		return w.Kill()
+	}
+
+	if exited, _ := w.WaitTimeout(d); exited {
+		return nil
+	}
+
+	return w.Kill()
+}
+
+// SetMaxPayloadSize limits size of payload context and body in bytes, 0 for unlimited. Larger
+// payloads are rejected with ErrPayloadTooLarge before being sent. Worker is killed once it
+// responds with larger frame, frame payload is not read.
+func (w *Worker) SetMaxPayloadSize(size int64) {
+	atomic.StoreInt64(&w.maxPayload, size)
+	if w.frames != nil {
+		w.frames.setLimit(size)
+	}
+}
+
+// SetMaxResponseFrames limits number of frames worker may send in response to the task, including
+// frames of the worker calls and frames found right after the response, 0 for unlimited. Worker
+// is killed once it exceeds the limit, rest of the frames is not read and task fails with
+// ErrTooManyFrames. Streamed chunks and multiplexed workers are not limited.
+func (w *Worker) SetMaxResponseFrames(n int64) {
+	atomic.StoreInt64(&w.maxFrames, n)
+}
+
+// SetIdleTimeout limits for how long relay read or write can wait for the data, execution
+// fails once no bytes are transferred within d. Applies to relays of socket factory
+// listeners only, 0 to disable. Keep-alive does not affect the timeout.
+func (w *Worker) SetIdleTimeout(d time.Duration) {
+	if w.conn != nil {
+		w.conn.setIdleTimeout(d)
+	}
+}
+
 // Exec sends payload to worker, executes it and returns result or
 // error. Make sure to handle worker.Wait() to gather worker level
-// errors. Method might return JobError indicating issue with payload.
+// errors. Method might return ErrWorkerError (JobError) indicating that worker has flagged the
+// response as an application error, worker stays ready to execute next task.
 func (w *Worker) Exec(rqs *Payload) (rsp *Payload, err error) {
+	if w.mux != nil {
+		return w.execMux(context.Background(), rqs)
+	}
+
 	w.mu.Lock()
 
 	if rqs == nil {
@@ -163,6 +598,11 @@ func (w *Worker) Exec(rqs *Payload) (rsp *Payload, err error) {
 		return nil, fmt.Errorf("payload can not be empty")
 	}
 
+	if err := w.checkPayload(rqs); err != nil {
+		w.mu.Unlock()
+		return nil, err
+	}
+
 	if w.state.Value() != StateReady {
 		w.mu.Unlock()
 		return nil, fmt.Errorf("worker is not ready (%s)", w.state.String())
@@ -170,7 +610,10 @@ func (w *Worker) Exec(rqs *Payload) (rsp *Payload, err error) {
 
 	w.state.set(StateWorking)
 
+	start := time.Now()
 	rsp, err = w.execPayload(rqs)
+	w.latency.observe(time.Since(start))
+
 	if err != nil {
 		if _, ok := err.(JobError); !ok {
 			w.state.set(StateErrored)
@@ -186,72 +629,872 @@ func (w *Worker) Exec(rqs *Payload) (rsp *Payload, err error) {
 	return rsp, err
 }
 
-func (w *Worker) markInvalid() {
-	w.state.set(StateInvalid)
+// ExecValue encodes rqs using worker codec, executes it and decodes response body into rsp.
+// Response context is returned as is.
+func (w *Worker) ExecValue(rqs interface{}, rsp interface{}) (context []byte, err error) {
+	return execValue(w.Codec(), w.Exec, rqs, rsp)
 }
 
-func (w *Worker) start() error {
-	if err := w.cmd.Start(); err != nil {
-		close(w.waitDone)
-		return err
+// ExecStream executes the task which response body is streamed by the worker in chunks, onChunk
+// is invoked for every chunk as it arrives and response context is returned once worker ends the
+// stream, body is never buffered as a whole. Intended for large responses such as file exports,
+// see streamCommand for the protocol. Worker is killed once onChunk returns an error, the error
+// is returned as is. Not supported by multiplexed workers.
+func (w *Worker) ExecStream(rqs *Payload, onChunk func(chunk []byte) error) (context []byte, err error) {
+	if w.mux != nil {
+		return nil, fmt.Errorf("streaming is not supported by multiplexed workers")
 	}
 
-	w.Pid = &w.cmd.Process.Pid
+	w.mu.Lock()
+	defer w.mu.Unlock()
 
-	// wait for process to complete
-	go func() {
-		w.endState, _ = w.cmd.Process.Wait()
-		if w.waitDone != nil {
-			close(w.waitDone)
-			w.mu.Lock()
-			defer w.mu.Unlock()
+	if rqs == nil {
+		return nil, fmt.Errorf("payload can not be empty")
+	}
 
-			if w.rl != nil {
-				err := w.rl.Close()
-				if err != nil {
-					w.err.lsn(EventWorkerError, WorkerError{Worker: w, Caused: err})
-				}
-			}
+	if err := w.checkPayload(rqs); err != nil {
+		return nil, err
+	}
 
-			err := w.err.Close()
-			if err != nil {
-				w.err.lsn(EventWorkerError, WorkerError{Worker: w, Caused: err})
-			}
+	if w.state.Value() != StateReady {
+		return nil, fmt.Errorf("worker is not ready (%s)", w.state.String())
+	}
+
+	w.state.set(StateWorking)
+
+	start := time.Now()
+	context, err = w.execStream(rqs, onChunk)
+	w.latency.observe(time.Since(start))
+	w.state.registerExec()
+
+	if err != nil {
+		if _, ok := err.(JobError); !ok {
+			w.state.set(StateErrored)
+			return nil, err
 		}
-	}()
+	}
 
-	return nil
+	w.state.set(StateReady)
+	return context, err
 }
 
-func (w *Worker) execPayload(rqs *Payload) (rsp *Payload, err error) {
-	// two things
-	if err := sendControl(w.rl, rqs.Context); err != nil {
-		return nil, errors.Wrap(err, "header error")
+// execStream sends the task preceded by stream command and passes response chunks to onChunk.
+func (w *Worker) execStream(rqs *Payload, onChunk func(chunk []byte) error) ([]byte, error) {
+	if err := sendControl(w.rl, streamCommand{Stream: true}); err != nil {
+		return nil, w.wrapError(err, "header error")
 	}
 
-	if err = w.rl.Send(rqs.Body, 0); err != nil {
-		return nil, errors.Wrap(err, "sender error")
+	if err := w.sendPayload(rqs); err != nil {
+		return nil, err
 	}
 
-	var pr goridge.Prefix
-	rsp = new(Payload)
-
-	if rsp.Context, pr, err = w.rl.Receive(); err != nil {
-		return nil, errors.Wrap(err, "worker error")
+	context, pr, err := w.rl.Receive()
+	if err != nil {
+		return nil, w.receiveError(err)
 	}
 
 	if !pr.HasFlag(goridge.PayloadControl) {
-		return nil, fmt.Errorf("malformed worker response")
+		return nil, w.wrapError(fmt.Errorf("malformed worker response"), "worker error")
 	}
 
 	if pr.HasFlag(goridge.PayloadError) {
-		return nil, JobError(rsp.Context)
+		w.request.Store("")
+		return nil, w.jobError(context)
 	}
 
-	// add streaming support :)
-	if rsp.Body, pr, err = w.rl.Receive(); err != nil {
-		return nil, errors.Wrap(err, "worker error")
+	for {
+		chunk, pr, err := w.rl.Receive()
+		if err != nil {
+			return nil, w.receiveError(err)
+		}
+
+		if pr.HasFlag(goridge.PayloadControl) {
+			if pr.HasFlag(goridge.PayloadError) {
+				w.request.Store("")
+				return nil, w.jobError(chunk)
+			}
+
+			cmd := streamCommand{}
+			if err := json.Unmarshal(chunk, &cmd); err != nil || !cmd.End {
+				return nil, w.wrapError(fmt.Errorf("malformed stream end"), "worker error")
+			}
+
+			if err := w.checkStream(); err != nil {
+				return nil, err
+			}
+
+			w.request.Store("")
+			return context, nil
+		}
+
+		if err := onChunk(chunk); err != nil {
+			// rest of the stream is not read, relay can not be used anymore
+			_ = w.Kill()
+			return nil, err
+		}
+	}
+}
+
+// Codec returns codec used to encode payload bodies, assigned by the factory.
+func (w *Worker) Codec() Codec {
+	if w.codec == nil {
+		return RawCodec{}
 	}
 
-	return rsp, nil
+	return w.codec
+}
+
+// bodyFlags returns flags of the body frames sent to the worker, body frames of the worker
+// without codec carry no flags.
+func (w *Worker) bodyFlags() byte {
+	if w.codec == nil {
+		return 0
+	}
+
+	return w.codec.Flags()
+}
+
+// ExecContext sends payload to worker and returns result or error, execution is canceled once
+// context is done. Workers reporting CapabilityCancel during the handshake receive cancel command
+// and are given CancelTimeout to respond, worker stays ready when it responds in time. Other
+// workers are killed or resynced (see SetResyncTimeout). Context error is returned for the
+// canceled task.
+func (w *Worker) ExecContext(ctx context.Context, rqs *Payload) (rsp *Payload, err error) {
+	if w.mux != nil {
+		return w.execMux(ctx, rqs)
+	}
+
+	w.mu.Lock()
+
+	if rqs == nil {
+		w.mu.Unlock()
+		return nil, fmt.Errorf("payload can not be empty")
+	}
+
+	if err := w.checkPayload(rqs); err != nil {
+		w.mu.Unlock()
+		return nil, err
+	}
+
+	if w.state.Value() != StateReady {
+		w.mu.Unlock()
+		return nil, fmt.Errorf("worker is not ready (%s)", w.state.String())
+	}
+
+	if err := ctx.Err(); err != nil {
+		w.mu.Unlock()
+		return nil, err
+	}
+
+	w.state.set(StateWorking)
+
+	// buffered to let execution complete once worker is killed
+	done := make(chan execResult, 1)
+	sent := make(chan error, 1)
+	go func() {
+		start := time.Now()
+		defer func() {
+			w.latency.observe(time.Since(start))
+		}()
+
+		err := w.sendPayload(rqs)
+		if sent <- err; err != nil {
+			done <- execResult{err: err}
+			return
+		}
+
+		rsp, err := w.receivePayload()
+		done <- execResult{rsp: rsp, err: err}
+	}()
+
+	select {
+	case r := <-done:
+		if r.err != nil {
+			if _, ok := r.err.(JobError); !ok {
+				w.state.set(StateErrored)
+				w.state.registerExec()
+				w.mu.Unlock()
+				return nil, r.err
+			}
+		}
+
+		w.state.set(StateReady)
+		w.state.registerExec()
+		w.mu.Unlock()
+		return r.rsp, r.err
+
+	case <-ctx.Done():
+		canceled := w.cancel(sent, done)
+
+		w.state.registerExec()
+		if canceled {
+			w.state.set(StateReady)
+			w.mu.Unlock()
+			return nil, ctx.Err()
+		}
+
+		if w.resyncAfter(done) {
+			w.mu.Unlock()
+			return nil, ctx.Err()
+		}
+
+		w.state.set(StateErrored)
+		w.mu.Unlock()
+
+		if ctx.Err() == context.DeadlineExceeded {
+			atomic.StoreInt32(&w.timedOut, 1)
+		}
+
+		// relay is closed once process is dead, releasing pending execution
+		if err := w.Kill(); err != nil {
+			return nil, errors.Wrap(err, ctx.Err().Error())
+		}
+
+		return nil, ctx.Err()
+	}
+}
+
+// execResult carries the result of the cancelable execution.
+type execResult struct {
+	rsp *Payload
+	err error
+}
+
+// cancel asks worker to abort running task, returns true if worker responded to the task
+// within the cancel timeout and relay stream is consistent.
+func (w *Worker) cancel(sent chan error, done chan execResult) bool {
+	if w.meta[CapabilityCancel] != "true" {
+		return false
+	}
+
+	timer := time.NewTimer(CancelTimeout)
+	defer timer.Stop()
+
+	// cancel command must not interleave with payload frames
+	select {
+	case err := <-sent:
+		if err != nil {
+			return false
+		}
+	case <-timer.C:
+		return false
+	}
+
+	if err := sendControl(w.rl, &cancelCommand{Cancel: true}); err != nil {
+		return false
+	}
+
+	select {
+	case r := <-done:
+		if r.err == nil {
+			return true
+		}
+
+		_, jobError := r.err.(JobError)
+		return jobError
+	case <-timer.C:
+		return false
+	}
+}
+
+// Hijack detaches relay connection from the goridge framing and passes it to the caller, worker
+// process takes over the raw byte stream (for example to stream server sent events). Worker must
+// be idle and can not execute tasks afterwards. Hijacked worker must not be used in the pool
+// anymore, pool Release replaces it while worker completes once the connection is closed by
+// the caller or the worker. Only relays accepted by socket factory listeners can be hijacked.
+func (w *Worker) Hijack() (net.Conn, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.conn == nil {
+		return nil, fmt.Errorf("worker relay can not be hijacked (%s)", w.Transport)
+	}
+
+	if w.mux != nil {
+		return nil, fmt.Errorf("multiplexed worker relay can not be hijacked")
+	}
+
+	if w.conn.compressed() {
+		return nil, fmt.Errorf("compressed worker relay can not be hijacked")
+	}
+
+	if w.state.Value() != StateReady {
+		return nil, fmt.Errorf("worker is not ready (%s)", w.state.String())
+	}
+
+	w.state.set(StateInvalid)
+	atomic.StoreInt32(&w.hijacked, 1)
+
+	// deadlines are managed by the caller from now on
+	w.conn.setIdleTimeout(0)
+	if err := w.conn.SetDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+
+	return w.conn.Conn, nil
+}
+
+// Hijacked returns true if relay connection has been passed to the caller using Hijack.
+func (w *Worker) Hijacked() bool {
+	return atomic.LoadInt32(&w.hijacked) == 1
+}
+
+// Ping verifies that worker is responsive by passing PID command over the relay,
+// worker is marked as errored when no valid response received within PingTimeout.
+// Multiplexed workers are not pinged, relay reader failure is reported instead.
+func (w *Worker) Ping() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.state.Value() != StateReady {
+		return fmt.Errorf("worker is not ready (%s)", w.state.String())
+	}
+
+	if w.mux != nil {
+		if err := w.mux.error(); err != nil {
+			return errors.Wrap(err, "ping error")
+		}
+
+		return nil
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		pid, err := fetchPID(w.rl)
+		if err == nil && pid != *w.Pid {
+			err = fmt.Errorf("unexpected pid %v", pid)
+		}
+
+		done <- err
+	}()
+
+	timer := time.NewTimer(PingTimeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			w.state.set(StateErrored)
+			return errors.Wrap(err, "ping error")
+		}
+
+		return nil
+	case <-timer.C:
+		// relay is released once process is killed
+		w.state.set(StateErrored)
+		return fmt.Errorf("ping timeout")
+	}
+}
+
+// failStart kills the worker which failed to connect and reaps the process. Returned
+// error includes the tail of worker stderr output (if any).
+func (w *Worker) failStart(err error) error {
+	go func(w *Worker) {
+		err := w.Kill()
+		if err != nil {
+			// there is no logger here, how to handle error in goroutines ?
+			fmt.Println(fmt.Errorf("error killing the worker %v", err))
+		}
+	}(w)
+
+	// exit error already describes the process termination
+	if wErr, ok := w.Wait().(WaitError); ok {
+		if _, exited := err.(ExitError); !exited {
+			err = fmt.Errorf("%s: %s", err, wErr.Status())
+		}
+	}
+
+	stderr := w.err.Tail(StderrTailSize)
+	if len(stderr) == 0 {
+		return errors.Wrap(err, "unable to connect to worker")
+	}
+
+	return WorkerError{
+		Worker: w,
+		Caused: errors.Wrap(fmt.Errorf("%s: worker start failed: %s", err, stderr), "unable to connect to worker"),
+		Stderr: stderr,
+	}
+}
+
+// checkProtocol requests worker protocol version and verifies it against the constraint.
+func (w *Worker) checkProtocol(constraint string) error {
+	version, err := fetchVersion(w.rl, ProtocolTimeout)
+	if err != nil {
+		return err
+	}
+
+	ok, err := matchVersion(constraint, version)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return fmt.Errorf("worker protocol version `%s` does not satisfy `%s`", version, constraint)
+	}
+
+	return nil
+}
+
+// warmup sends payload to the worker which is not ready yet and waits tout time for the
+// response. Warm-up execution is not registered in worker state.
+func (w *Worker) warmup(body []byte, tout time.Duration) error {
+	// buffered to let execution complete once worker is killed
+	done := make(chan error, 1)
+	go func() {
+		_, err := w.execPayload(&Payload{Body: body})
+		done <- err
+	}()
+
+	timer := time.NewTimer(tout)
+	defer timer.Stop()
+
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		// relay is released once process is killed
+		return fmt.Errorf("warmup timeout")
+	}
+}
+
+// ExecWithTimeout sends payload to worker and waits d time for the result. Worker is killed
+// and ErrExecTimeout returned if worker did not respond in time, worker is resynced instead when
+// resync timeout is set (see SetResyncTimeout).
+func (w *Worker) ExecWithTimeout(rqs *Payload, d time.Duration) (rsp *Payload, err error) {
+	if w.mux != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), d)
+		defer cancel()
+
+		if rsp, err = w.execMux(ctx, rqs); err == context.DeadlineExceeded {
+			return nil, ErrExecTimeout
+		}
+
+		return rsp, err
+	}
+
+	w.mu.Lock()
+
+	if rqs == nil {
+		w.mu.Unlock()
+		return nil, fmt.Errorf("payload can not be empty")
+	}
+
+	if err := w.checkPayload(rqs); err != nil {
+		w.mu.Unlock()
+		return nil, err
+	}
+
+	if w.state.Value() != StateReady {
+		w.mu.Unlock()
+		return nil, fmt.Errorf("worker is not ready (%s)", w.state.String())
+	}
+
+	w.state.set(StateWorking)
+
+	// buffered to let execution complete once worker is killed
+	done := make(chan execResult, 1)
+	go func() {
+		start := time.Now()
+		rsp, err := w.execPayload(rqs)
+		w.latency.observe(time.Since(start))
+
+		done <- execResult{rsp: rsp, err: err}
+	}()
+
+	timer := time.NewTimer(d)
+	select {
+	case r := <-done:
+		timer.Stop()
+
+		if r.err != nil {
+			if _, ok := r.err.(JobError); !ok {
+				w.state.set(StateErrored)
+				w.state.registerExec()
+				w.mu.Unlock()
+				return nil, r.err
+			}
+		}
+
+		w.state.set(StateReady)
+		w.state.registerExec()
+		w.mu.Unlock()
+		return r.rsp, r.err
+
+	case <-timer.C:
+		w.state.registerExec()
+		if w.resyncAfter(done) {
+			w.mu.Unlock()
+			return nil, ErrExecTimeout
+		}
+
+		w.state.set(StateErrored)
+		w.mu.Unlock()
+		atomic.StoreInt32(&w.timedOut, 1)
+
+		// relay is closed once process is dead, releasing pending execution
+		if err := w.Kill(); err != nil {
+			return nil, errors.Wrap(err, ErrExecTimeout.Error())
+		}
+
+		return nil, ErrExecTimeout
+	}
+}
+
+func (w *Worker) markInvalid() {
+	w.state.set(StateInvalid)
+}
+
+func (w *Worker) start() error {
+	if c, ok := w.cmd.Stdout.(*stdoutCapture); ok {
+		if err := c.open(w.cmd); err != nil {
+			close(w.waitDone)
+			return err
+		}
+		w.stdout = c
+	}
+
+	spawnLimit.acquire()
+	started := time.Now()
+	err := w.limits.start(w.cmd)
+	spawnLimit.release()
+
+	if w.stdout != nil {
+		w.stdout.started(err == nil)
+	}
+
+	if err != nil {
+		close(w.waitDone)
+		return err
+	}
+	w.startDuration = time.Since(started)
+
+	w.Pid = &w.cmd.Process.Pid
+	w.watch()
+
+	return nil
+}
+
+// detach closes the relay of the worker which failed to be adopted, process is left running.
+func (w *Worker) detach() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.rl != nil {
+		_ = w.rl.Close()
+		w.rl = nil
+	}
+}
+
+// watch waits for process to complete and releases the relay. Every started process is watched
+// right after the start, so process is reaped exactly once even if worker fails to connect or
+// is never claimed by the pool.
+func (w *Worker) watch() {
+	go func() {
+		w.endState, _ = w.cmd.Process.Wait()
+
+		if w.waitDone != nil {
+			close(w.waitDone)
+			w.mu.Lock()
+			defer w.mu.Unlock()
+
+			// hijacked connection is closed by the caller
+			if w.rl != nil && !w.Hijacked() {
+				err := w.rl.Close()
+				if err != nil {
+					w.err.lsn(EventWorkerError, WorkerError{Worker: w, Caused: err})
+				}
+			}
+
+			err := w.err.Close()
+			if err != nil {
+				w.err.lsn(EventWorkerError, WorkerError{Worker: w, Caused: err})
+			}
+		}
+	}()
+}
+
+// checkPayload returns ErrPayloadTooLarge if payload context or body exceeds max payload size.
+func (w *Worker) checkPayload(rqs *Payload) error {
+	return checkPayload(rqs, atomic.LoadInt64(&w.maxPayload))
+}
+
+func (w *Worker) execPayload(rqs *Payload) (rsp *Payload, err error) {
+	if err := w.sendPayload(rqs); err != nil {
+		return nil, err
+	}
+
+	return w.receivePayload()
+}
+
+// sendPayload sends payload context and body frames to the worker.
+func (w *Worker) sendPayload(rqs *Payload) error {
+	w.execs.push(rqs)
+	w.request.Store(rqs.RequestID)
+	w.received = 0
+
+	// two things
+	if err := sendControl(w.rl, rqs.Context); err != nil {
+		return w.wrapError(err, "header error")
+	}
+
+	if err := w.rl.Send(rqs.Body, w.bodyFlags()); err != nil {
+		return w.wrapError(err, "sender error")
+	}
+
+	return nil
+}
+
+// receivePayload receives worker response to the sent payload. Worker responds with the context
+// frame (PayloadControl) followed by the body frame. Application errors are signaled using the
+// PayloadError flag, either on the context frame which then carries the error message and no body
+// frame follows, or on the body frame which then carries the error payload. Both are returned as
+// ErrWorkerError and keep the worker reusable, any other malformed or missing frame is a relay
+// error and the worker must be replaced. Worker calls of the host handlers preceding the response
+// are served in place, see callCommand.
+func (w *Worker) receivePayload() (rsp *Payload, err error) {
+	defer func() { w.countMalformed(err) }()
+
+	var pr goridge.Prefix
+	rsp = new(Payload)
+
+	for {
+		if rsp.Context, pr, err = w.receiveFrame(); err != nil {
+			return nil, w.receiveError(err)
+		}
+
+		cmd, ok := parseCall(rsp.Context, pr)
+		if !ok {
+			break
+		}
+
+		if err := w.serveCall(cmd); err != nil {
+			return nil, err
+		}
+	}
+
+	if !pr.HasFlag(goridge.PayloadControl) {
+		return nil, w.wrapError(fmt.Errorf("malformed worker response"), "worker error")
+	}
+
+	if pr.HasFlag(goridge.PayloadError) {
+		if err := w.checkStream(); err != nil {
+			return nil, err
+		}
+
+		w.request.Store("")
+		return nil, w.jobError(rsp.Context)
+	}
+
+	// add streaming support :)
+	if rsp.Body, pr, err = w.receiveFrame(); err != nil {
+		return nil, w.receiveError(err)
+	}
+
+	if err := w.checkStream(); err != nil {
+		return nil, err
+	}
+
+	w.request.Store("")
+	w.observeStatus(rsp)
+	if pr.HasFlag(goridge.PayloadError) {
+		return nil, w.jobError(rsp.Body)
+	}
+
+	return rsp, nil
+}
+
+// ConsecutiveMalformed returns number of malformed responses (ErrRelayProtocol) worker has sent
+// in a row, well-formed response resets the count.
+func (w *Worker) ConsecutiveMalformed() int64 {
+	return atomic.LoadInt64(&w.malformed)
+}
+
+// countMalformed counts malformed responses in a row, transport failures keep the count.
+func (w *Worker) countMalformed(err error) {
+	if _, jobError := err.(JobError); err == nil || jobError {
+		atomic.StoreInt64(&w.malformed, 0)
+		return
+	}
+
+	if errors.Is(err, ErrRelayProtocol) {
+		atomic.AddInt64(&w.malformed, 1)
+	}
+}
+
+// TaskStarted returns start time of the task executed by the worker, zero time when worker is not
+// working (StateWorking).
+func (w *Worker) TaskStarted() time.Time {
+	return w.state.TaskStarted()
+}
+
+// LastReportedStatus returns last status reported by the worker in the response context, empty
+// when worker has not reported any. See StatusField.
+func (w *Worker) LastReportedStatus() string {
+	status, _ := w.status.Load().(string)
+	return status
+}
+
+// observeStatus stores status reported in the response context, if any.
+func (w *Worker) observeStatus(rsp *Payload) {
+	if status, ok := reportedStatus(rsp); ok {
+		w.status.Store(status)
+	}
+}
+
+// checkStream reads frames worker has already sent after the response, unhealthy command marks
+// worker as unhealthy. ErrUnexpectedFrame is returned for any other data, for example second
+// response to the same request. Data sent later is not detected.
+func (w *Worker) checkStream() error {
+	if w.stream == nil {
+		return nil
+	}
+
+	for {
+		n, ok := unreadBytes(w.stream)
+		if !ok || n == 0 {
+			return nil
+		}
+
+		data, pr, err := w.receiveFrame()
+		if err != nil {
+			return w.receiveError(err)
+		}
+
+		cmd := unhealthyCommand{}
+		if !pr.HasFlag(goridge.PayloadControl) || pr.HasFlag(goridge.PayloadRaw) ||
+			json.Unmarshal(data, &cmd) != nil || !cmd.Unhealthy {
+			return w.wrapError(ErrUnexpectedFrame, fmt.Sprintf("worker error: %v unread bytes", n))
+		}
+
+		atomic.StoreInt32(&w.unhealthy, 1)
+	}
+}
+
+// checkIdle reads frames sent by the idle worker, ErrWorkerUnhealthy is returned once worker
+// reported itself unhealthy. Worker which sent unexpected data is marked as errored. Detection
+// is supported on Linux only, multiplexed and busy workers are not checked.
+func (w *Worker) checkIdle() error {
+	if w.mux != nil || w.Hijacked() {
+		return nil
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.state.Value() != StateReady {
+		return nil
+	}
+
+	if err := w.checkStream(); err != nil {
+		w.state.set(StateErrored)
+		return err
+	}
+
+	if w.Unhealthy() {
+		return ErrWorkerUnhealthy
+	}
+
+	return nil
+}
+
+// Stdout returns recent stdout of the worker when pool is configured with StdoutBuffer mode,
+// nil otherwise.
+func (w *Worker) Stdout() []byte {
+	if w.stdout != nil {
+		return w.stdout.Bytes()
+	}
+
+	return nil
+}
+
+// Unhealthy returns true once worker reported itself unhealthy by sending unhealthy control
+// command ({"unhealthy":true}) while being idle. Pool replaces unhealthy workers.
+func (w *Worker) Unhealthy() bool {
+	return atomic.LoadInt32(&w.unhealthy) == 1
+}
+
+// receiveFrame receives next frame of the task response, ErrTooManyFrames is returned without
+// reading the frame once worker exceeded the response frame limit.
+func (w *Worker) receiveFrame() ([]byte, goridge.Prefix, error) {
+	if max := atomic.LoadInt64(&w.maxFrames); max != 0 {
+		if w.received >= max {
+			return nil, goridge.Prefix{}, errors.Wrapf(ErrTooManyFrames, "more than %v frames", max)
+		}
+		w.received++
+	}
+
+	return w.rl.Receive()
+}
+
+// receiveError kills the worker if relay stream is no longer consistent due to oversized or
+// excess frame.
+func (w *Worker) receiveError(err error) error {
+	if cause := errors.Cause(err); cause == ErrPayloadTooLarge || cause == ErrTooManyFrames {
+		go func() {
+			_ = w.Kill()
+		}()
+	}
+
+	return w.wrapError(err, "worker error")
+}
+
+// wrapError annotates execution error with the request ID of the task, if any.
+func (w *Worker) wrapError(err error, msg string) error {
+	return requestError(err, w.withStatus(msg), w.RequestID())
+}
+
+// withStatus appends last status reported by the worker to the error message, if any.
+func (w *Worker) withStatus(msg string) string {
+	if status := w.LastReportedStatus(); status != "" {
+		return fmt.Sprintf("%s (worker status: %s)", msg, status)
+	}
+
+	return msg
+}
+
+// jobError returns application error sent by the worker, message mentions the last status
+// reported by the worker, if any.
+func (w *Worker) jobError(msg []byte) JobError {
+	if w.LastReportedStatus() == "" {
+		return JobError(msg)
+	}
+
+	return JobError(w.withStatus(string(msg)))
+}
+
+// requestError annotates execution error with the given request ID, if any.
+func requestError(err error, msg string, id string) error {
+	if id != "" {
+		msg = fmt.Sprintf("%s (request %s)", msg, id)
+	}
+
+	return errors.Wrap(relayFailure(err), msg)
+}
+
+// relayFailure puts relay error into ErrRelayIO or ErrRelayProtocol category, transport errors
+// are IO errors and anything else is a protocol error.
+func relayFailure(err error) error {
+	if errors.Is(err, ErrRelayIO) || errors.Is(err, ErrRelayProtocol) {
+		return err
+	}
+
+	cause := errors.Cause(err)
+	switch cause {
+	case io.EOF, io.ErrUnexpectedEOF, io.ErrClosedPipe, os.ErrClosed:
+		return relayError{kind: ErrRelayIO, err: err}
+	}
+
+	switch cause.(type) {
+	case net.Error, *os.PathError, *os.SyscallError, syscall.Errno:
+		return relayError{kind: ErrRelayIO, err: err}
+	}
+
+	return relayError{kind: ErrRelayProtocol, err: err}
+}
+
+// RequestID returns request ID of the task being executed or the task worker failed to
+// complete, empty when worker is idle or task has no ID.
+func (w *Worker) RequestID() string {
+	id, _ := w.request.Load().(string)
+	return id
 }
@@ -0,0 +1,77 @@
+package roadrunner
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+)
+
+// WorkerConfig describes per worker command settings provided by the pool.
+type WorkerConfig struct {
+	// Env defines set of environment variables to be passed to the worker.
+	Env map[string]string
+
+	// Dir defines worker working directory, command directory is used when empty.
+	Dir string
+
+	// Index is worker slot number within the pool, replacement workers inherit
+	// index of the worker they replace. Workers spawned by ExecFresh get FreshWorkerIndex.
+	Index int
+
+	// ID is logical worker identity assigned by the pool per slot, replacement workers inherit
+	// ID of the worker they replace. See Worker.ID.
+	ID string
+}
+
+// DefaultWorkerIDPrefix prefixes slot index in the logical worker IDs.
+const DefaultWorkerIDPrefix = "worker-"
+
+// newWorkerConfig creates worker config for the given pool slot, index and logical ID (prefix
+// followed by the index, DefaultWorkerIDPrefix when prefix is empty) are passed to the worker
+// via RR_WORKER_INDEX and RR_WORKER_ID env variables.
+func newWorkerConfig(index int, prefix string) WorkerConfig {
+	if prefix == "" {
+		prefix = DefaultWorkerIDPrefix
+	}
+
+	id := prefix + strconv.Itoa(index)
+	if index == FreshWorkerIndex {
+		id = prefix + "fresh"
+	}
+
+	return WorkerConfig{
+		Env:   map[string]string{"RR_WORKER_INDEX": strconv.Itoa(index), "RR_WORKER_ID": id},
+		Index: index,
+		ID:    id,
+	}
+}
+
+// Apply merges config settings into the command. Existing command Env is preserved, process
+// environment is used when command Env is empty.
+func (cfg WorkerConfig) Apply(cmd *exec.Cmd) {
+	if cmd == nil {
+		return
+	}
+
+	if len(cfg.Env) != 0 {
+		if cmd.Env == nil {
+			cmd.Env = os.Environ()
+		}
+
+		keys := make([]string, 0, len(cfg.Env))
+		for k := range cfg.Env {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", k, cfg.Env[k]))
+		}
+	}
+
+	if cfg.Dir != "" {
+		cmd.Dir = cfg.Dir
+	}
+}
@@ -0,0 +1,41 @@
+package roadrunner
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os/exec"
+	"testing"
+)
+
+func Test_WorkerConfig_Apply(t *testing.T) {
+	cmd := exec.Command("php", "tests/client.php", "echo", "pipes")
+	cmd.Env = []string{"KEY=value"}
+
+	WorkerConfig{Env: map[string]string{"B": "2", "A": "1"}, Dir: "tests"}.Apply(cmd)
+
+	assert.Equal(t, []string{"KEY=value", "A=1", "B=2"}, cmd.Env)
+	assert.Equal(t, "tests", cmd.Dir)
+}
+
+func Test_WorkerConfig_Apply_Environ(t *testing.T) {
+	cmd := exec.Command("php", "tests/client.php", "echo", "pipes")
+	cmd.Dir = "tests"
+
+	newWorkerConfig(2, "").Apply(cmd)
+
+	assert.Contains(t, cmd.Env, "RR_WORKER_INDEX=2")
+	assert.Contains(t, cmd.Env, "RR_WORKER_ID=worker-2")
+	assert.True(t, len(cmd.Env) > 1)
+	assert.Equal(t, "tests", cmd.Dir)
+}
+
+func Test_WorkerConfig_Apply_Nil(t *testing.T) {
+	assert.NotPanics(t, func() {
+		newWorkerConfig(0, "").Apply(nil)
+	})
+}
+
+func Test_WorkerConfig_ID(t *testing.T) {
+	assert.Equal(t, "worker-0", newWorkerConfig(0, "").ID)
+	assert.Equal(t, "jobs-3", newWorkerConfig(3, "jobs-").ID)
+	assert.Equal(t, "jobs-fresh", newWorkerConfig(FreshWorkerIndex, "jobs-").ID)
+}
@@ -0,0 +1,236 @@
+package roadrunner
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// WorkerEventBuffer defines how many worker events are buffered for every subscriber, events
+// received while buffer is full are dropped.
+const WorkerEventBuffer = 256
+
+// WorkerEvent describes state transition of the pool worker, see ManagedPool.Events.
+type WorkerEvent struct {
+	// WorkerID is logical ID of the worker, see Worker.ID.
+	WorkerID string
+
+	// PID of the worker process.
+	PID int
+
+	// OldState and NewState of the worker, see State* constants.
+	OldState int64
+	NewState int64
+
+	// Timestamp of the transition.
+	Timestamp time.Time
+}
+
+// eventHub fans state transitions of the pool workers out to the subscribers. Subscribers never
+// block the workers, events are dropped once the subscriber buffer is full.
+type eventHub struct {
+	mu   sync.RWMutex
+	subs []chan WorkerEvent
+
+	// number of subscribers, accessed atomically to skip the lock while nobody listens
+	numSubs int32
+
+	// workers which can change the state until their exit is collected by the pool
+	attached map[*Worker]bool
+
+	// closed once last attached worker is detached while hub is closing
+	idle chan interface{}
+
+	// indicates that no new workers are attached, protected by mu
+	closing bool
+
+	// indicates that subscriber channels are closed, protected by mu
+	closed bool
+}
+
+// subscribe returns new channel receiving worker events, channel is closed right away when hub
+// has been closed.
+func (h *eventHub) subscribe() <-chan WorkerEvent {
+	c := make(chan WorkerEvent, WorkerEventBuffer)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		close(c)
+		return c
+	}
+
+	h.subs = append(h.subs, c)
+	atomic.AddInt32(&h.numSubs, 1)
+
+	return c
+}
+
+// unsubscribe removes subscriber and closes its channel, unknown channels are ignored.
+func (h *eventHub) unsubscribe(c <-chan WorkerEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, sub := range h.subs {
+		if sub == c {
+			h.subs = append(h.subs[:i], h.subs[i+1:]...)
+			atomic.AddInt32(&h.numSubs, -1)
+			close(sub)
+			return
+		}
+	}
+}
+
+// attach starts reporting state changes of the worker, transition to StateReady made by the
+// factory before worker joined the pool is reported right away.
+func (h *eventHub) attach(w *Worker) {
+	h.mu.Lock()
+	if h.closing {
+		h.mu.Unlock()
+		return
+	}
+
+	if h.attached == nil {
+		h.attached = make(map[*Worker]bool)
+	}
+	h.attached[w] = true
+	h.mu.Unlock()
+
+	w.state.observe(func(from, to int64) {
+		h.emit(w, from, to)
+	})
+
+	if value := w.state.Value(); value != StateInactive {
+		h.emit(w, StateInactive, value)
+	}
+}
+
+// detach stops waiting for the worker once pool has collected its exit, worker does not change
+// the state afterwards.
+func (h *eventHub) detach(w *Worker) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.attached[w] {
+		return
+	}
+
+	delete(h.attached, w)
+	if len(h.attached) == 0 && h.idle != nil {
+		close(h.idle)
+		h.idle = nil
+	}
+}
+
+// emit passes transition to all subscribers.
+func (h *eventHub) emit(w *Worker, from, to int64) {
+	if atomic.LoadInt32(&h.numSubs) == 0 {
+		return
+	}
+
+	e := WorkerEvent{WorkerID: w.ID, PID: *w.Pid, OldState: from, NewState: to, Timestamp: time.Now()}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for _, sub := range h.subs {
+		select {
+		case sub <- e:
+		default:
+			// slow subscriber
+		}
+	}
+}
+
+// close waits up to timeout for the attached workers to be detached and closes all subscriber
+// channels, so final transitions of the destroyed workers are delivered.
+func (h *eventHub) close(timeout time.Duration) {
+	h.mu.Lock()
+	h.closing = true
+
+	var idle chan interface{}
+	if len(h.attached) != 0 {
+		if h.idle == nil {
+			h.idle = make(chan interface{})
+		}
+		idle = h.idle
+	}
+	h.mu.Unlock()
+
+	if idle != nil {
+		timer := time.NewTimer(timeout)
+		select {
+		case <-idle:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return
+	}
+
+	h.closed = true
+	for _, sub := range h.subs {
+		close(sub)
+	}
+
+	h.subs = nil
+	atomic.StoreInt32(&h.numSubs, 0)
+}
+
+// mergedEvents merges worker events of several pools into the single channel per subscriber.
+type mergedEvents struct {
+	// event channels of the pools by merged channel
+	sources sync.Map
+}
+
+// subscribe returns channel receiving events of all given pools, channel is closed once channels
+// of all pools are closed.
+func (m *mergedEvents) subscribe(pools ...ManagedPool) <-chan WorkerEvent {
+	sources := make([]<-chan WorkerEvent, len(pools))
+	for i, p := range pools {
+		sources[i] = p.Events()
+	}
+
+	merged := make(chan WorkerEvent, WorkerEventBuffer)
+	m.sources.Store((<-chan WorkerEvent)(merged), sources)
+
+	var wg sync.WaitGroup
+	for _, source := range sources {
+		wg.Add(1)
+		go func(source <-chan WorkerEvent) {
+			defer wg.Done()
+
+			for e := range source {
+				select {
+				case merged <- e:
+				default:
+					// slow subscriber
+				}
+			}
+		}(source)
+	}
+
+	go func() {
+		wg.Wait()
+		m.sources.Delete((<-chan WorkerEvent)(merged))
+		close(merged)
+	}()
+
+	return merged
+}
+
+// unsubscribe closes channels of all pools merged into the given channel, pools must be given
+// in the order of subscribe.
+func (m *mergedEvents) unsubscribe(c <-chan WorkerEvent, pools ...ManagedPool) {
+	if sources, ok := m.sources.Load(c); ok {
+		for i, p := range pools {
+			p.StopEvents(sources.([]<-chan WorkerEvent)[i])
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package roadrunner
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+// transitions returns transitions of the worker with given PID received until channel is closed.
+func transitions(t *testing.T, events <-chan WorkerEvent, pid int) (states [][2]int64) {
+	timeout := time.After(time.Second * 5)
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return states
+			}
+
+			if e.PID == pid {
+				states = append(states, [2]int64{e.OldState, e.NewState})
+			}
+		case <-timeout:
+			t.Fatal("event channel is not closed")
+			return states
+		}
+	}
+}
+
+func Test_EventHub_FanOut(t *testing.T) {
+	var h eventHub
+
+	a, b := h.subscribe(), h.subscribe()
+
+	w := syntheticWorker(1001)
+	w.ID = "worker-0"
+	h.attach(w)
+
+	w.state.set(StateReady)
+	w.state.set(StateReady)
+	w.state.set(StateWorking)
+
+	for _, events := range []<-chan WorkerEvent{a, b} {
+		e := <-events
+		assert.Equal(t, "worker-0", e.WorkerID)
+		assert.Equal(t, 1001, e.PID)
+		assert.Equal(t, StateInactive, e.OldState)
+		assert.Equal(t, StateReady, e.NewState)
+		assert.False(t, e.Timestamp.IsZero())
+
+		// repeated state is not reported
+		e = <-events
+		assert.Equal(t, StateReady, e.OldState)
+		assert.Equal(t, StateWorking, e.NewState)
+	}
+
+	h.detach(w)
+	h.close(time.Second)
+
+	_, ok := <-a
+	assert.False(t, ok)
+	_, ok = <-b
+	assert.False(t, ok)
+}
+
+func Test_EventHub_Overflow(t *testing.T) {
+	var h eventHub
+
+	events := h.subscribe()
+
+	w := syntheticWorker(1001)
+	h.attach(w)
+
+	// nobody reads the events
+	for i := 0; i < WorkerEventBuffer*2; i++ {
+		w.state.set(StateWorking)
+		w.state.set(StateReady)
+	}
+
+	assert.Len(t, events, WorkerEventBuffer)
+}
+
+func Test_EventHub_Unsubscribe(t *testing.T) {
+	var h eventHub
+
+	a, b := h.subscribe(), h.subscribe()
+	h.unsubscribe(a)
+
+	_, ok := <-a
+	assert.False(t, ok)
+
+	w := syntheticWorker(1001)
+	h.attach(w)
+	w.state.set(StateReady)
+
+	e := <-b
+	assert.Equal(t, StateReady, e.NewState)
+}
+
+func Test_EventHub_Close(t *testing.T) {
+	var h eventHub
+
+	events := h.subscribe()
+
+	w := syntheticWorker(1001)
+	h.attach(w)
+
+	go func() {
+		time.Sleep(time.Millisecond * 50)
+		w.state.set(StateStopped)
+		h.detach(w)
+	}()
+
+	// final transition is delivered before channel is closed
+	h.close(time.Second)
+	assert.Equal(t, [][2]int64{{StateInactive, StateStopped}}, transitions(t, events, 1001))
+
+	// closed hub
+	_, ok := <-h.subscribe()
+	assert.False(t, ok)
+}
+
+func Test_EventHub_CloseTimeout(t *testing.T) {
+	var h eventHub
+
+	events := h.subscribe()
+	h.attach(syntheticWorker(1001))
+
+	// worker is never detached
+	start := time.Now()
+	h.close(time.Millisecond * 50)
+	assert.True(t, time.Since(start) >= time.Millisecond*50)
+
+	_, ok := <-events
+	assert.False(t, ok)
+}
+
+// hubPool publishes events of the hub.
+type hubPool struct {
+	ManagedPool
+	hub eventHub
+}
+
+func (p *hubPool) Events() <-chan WorkerEvent {
+	return p.hub.subscribe()
+}
+
+func (p *hubPool) StopEvents(c <-chan WorkerEvent) {
+	p.hub.unsubscribe(c)
+}
+
+func Test_MergedEvents(t *testing.T) {
+	var m mergedEvents
+
+	pools := []ManagedPool{&hubPool{}, &hubPool{}, &hubPool{}}
+	events := m.subscribe(pools...)
+
+	for i, pool := range pools {
+		w := syntheticWorker(1001 + i)
+		pool.(*hubPool).hub.attach(w)
+		w.state.set(StateReady)
+	}
+
+	pids := []int{}
+	for range pools {
+		e := <-events
+		assert.Equal(t, StateReady, e.NewState)
+		pids = append(pids, e.PID)
+	}
+	assert.ElementsMatch(t, []int{1001, 1002, 1003}, pids)
+
+	// merged channel is closed once all pool channels are closed
+	m.unsubscribe(events, pools...)
+	_, ok := <-events
+	assert.False(t, ok)
+}
+
+func Test_StaticPool_Events(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "echo", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      1,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	assert.NoError(t, err)
+
+	events := p.Events()
+	old := *p.Workers()[0].Pid
+
+	// spawns new worker
+	assert.NoError(t, p.ReloadWorker())
+
+	pid := 0
+	for _, w := range p.Workers() {
+		if *w.Pid != old {
+			pid = *w.Pid
+		}
+	}
+
+	_, err = p.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+
+	p.Destroy()
+
+	assert.Equal(t, [][2]int64{
+		{StateInactive, StateReady},
+		{StateReady, StateWorking},
+		{StateWorking, StateReady},
+		{StateReady, StateInvalid},
+		{StateInvalid, StateStopping},
+		{StateStopping, StateStopped},
+	}, transitions(t, events, pid))
+}
+
+func Test_StaticPool_StopEvents(t *testing.T) {
+	p, err := NewPool(
+		func() *exec.Cmd { return exec.Command("php", "tests/client.php", "echo", "pipes") },
+		NewPipeFactory(),
+		Config{
+			NumWorkers:      1,
+			AllocateTimeout: time.Second,
+			DestroyTimeout:  time.Second,
+		},
+	)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	events := p.Events()
+	p.StopEvents(events)
+
+	_, err = p.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+
+	_, ok := <-events
+	assert.False(t, ok)
+}
@@ -1,8 +1,13 @@
 package roadrunner
 
 import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/spiral/goridge/v2"
 	"github.com/stretchr/testify/assert"
 	"os/exec"
+	"runtime"
+	"syscall"
 	"testing"
 	"time"
 )
@@ -26,6 +31,16 @@ func Test_GetState(t *testing.T) {
 	}
 }
 
+func Test_PID(t *testing.T) {
+	w, _ := newWorker(exec.Command("sleep", "10"))
+	assert.Equal(t, 0, w.PID())
+
+	assert.NoError(t, w.start())
+	defer w.Kill()
+
+	assert.Equal(t, w.cmd.Process.Pid, w.PID())
+}
+
 func Test_Kill(t *testing.T) {
 	cmd := exec.Command("php", "tests/client.php", "echo", "pipes")
 
@@ -47,6 +62,143 @@ func Test_Kill(t *testing.T) {
 	}()
 }
 
+func Test_StopWithTimeout(t *testing.T) {
+	cmd := exec.Command("php", "tests/client.php", "echo", "pipes")
+
+	w, err := NewPipeFactory().SpawnWorker(cmd)
+	assert.NoError(t, err)
+
+	assert.NoError(t, w.StopWithTimeout(time.Second))
+	assert.NoError(t, w.Wait())
+
+	// repeated stop is no-op
+	assert.NoError(t, w.Stop())
+	assert.NoError(t, w.StopWithTimeout(time.Second))
+}
+
+func Test_StopWithTimeout_Kill(t *testing.T) {
+	cmd := exec.Command("php", "tests/slow-destroy.php", "echo", "pipes")
+
+	w, err := NewPipeFactory().SpawnWorker(cmd)
+	assert.NoError(t, err)
+
+	start := time.Now()
+	assert.NoError(t, w.StopWithTimeout(time.Millisecond*100))
+	assert.True(t, time.Since(start) < time.Second*5)
+
+	if err := w.Wait(); assert.IsType(t, WaitError{}, err) {
+		assert.Equal(t, syscall.SIGKILL, err.(WaitError).Signal)
+	}
+}
+
+func Test_Diagnostics(t *testing.T) {
+	cmd := exec.Command("php", "tests/client.php", "echo", "pipes")
+
+	w, err := NewPipeFactory().SpawnWorker(cmd)
+	assert.NoError(t, err)
+
+	_, err = w.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+
+	_, err = w.Exec(&Payload{Context: []byte("ctx"), Body: []byte("hi")})
+	assert.NoError(t, err)
+
+	assert.NoError(t, w.Kill())
+
+	// available after worker death
+	d := w.Diagnostics()
+	if assert.Len(t, d.Execs, 2) {
+		assert.Equal(t, 5, d.Execs[0].BodySize)
+		assert.Equal(t, 3, d.Execs[1].ContextSize)
+		assert.Equal(t, 2, d.Execs[1].BodySize)
+	}
+}
+
+func Test_Kill_ExitCode(t *testing.T) {
+	cmd := exec.Command("php", "tests/client.php", "echo", "pipes")
+
+	w, err := NewPipeFactory().SpawnWorker(cmd)
+	assert.NoError(t, err)
+	assert.Equal(t, -1, w.ExitCode())
+
+	assert.NoError(t, w.Kill())
+
+	err = w.Wait()
+	if assert.IsType(t, WaitError{}, err) {
+		assert.Equal(t, syscall.SIGKILL, err.(WaitError).Signal)
+	}
+	assert.Equal(t, -1, w.ExitCode())
+}
+
+func Test_KillGraceful(t *testing.T) {
+	w, _ := newWorker(exec.Command("sh", "-c", `trap "exit 3" TERM; sleep 10 & wait`))
+	assert.NoError(t, w.start())
+
+	// to ensure that trap is installed
+	time.Sleep(time.Millisecond * 100)
+
+	assert.NoError(t, w.KillGraceful(time.Second))
+	assert.Equal(t, 3, w.ExitCode())
+	assert.NoError(t, w.KillGraceful(time.Second))
+}
+
+func Test_KillGraceful_Timeout(t *testing.T) {
+	w, _ := newWorker(exec.Command("sh", "-c", `trap "" TERM; sleep 10 & wait; sleep 10`))
+	assert.NoError(t, w.start())
+
+	time.Sleep(time.Millisecond * 100)
+
+	start := time.Now()
+	assert.NoError(t, w.KillGraceful(time.Millisecond*200))
+	assert.True(t, time.Since(start) >= time.Millisecond*200)
+
+	err := w.Wait()
+	if assert.IsType(t, WaitError{}, err) {
+		assert.Equal(t, syscall.SIGKILL, err.(WaitError).Signal)
+	}
+}
+
+func Test_WaitTimeout(t *testing.T) {
+	w, _ := newWorker(exec.Command("sleep", "10"))
+	assert.NoError(t, w.start())
+
+	goroutines := runtime.NumGoroutine()
+	for i := 0; i < 10; i++ {
+		exited, err := w.WaitTimeout(time.Millisecond * 10)
+		assert.False(t, exited)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, goroutines, runtime.NumGoroutine())
+
+	assert.NoError(t, w.cmd.Process.Kill())
+
+	exited, err := w.WaitTimeout(time.Second)
+	assert.True(t, exited)
+	assert.Error(t, err)
+	assert.Equal(t, syscall.SIGKILL, err.(WaitError).Signal)
+
+	// Wait is not affected
+	assert.Error(t, w.Wait())
+	assert.Equal(t, StateErrored, w.State().Value())
+}
+
+func Test_Broken_ExitCode(t *testing.T) {
+	cmd := exec.Command("php", "tests/client.php", "broken", "pipes")
+
+	w, err := NewPipeFactory().SpawnWorker(cmd)
+	assert.NoError(t, err)
+
+	_, err = w.Exec(&Payload{Body: []byte("hello")})
+	assert.Error(t, err)
+
+	err = w.Wait()
+	if assert.IsType(t, WaitError{}, err) {
+		assert.Equal(t, 255, err.(WaitError).Code)
+		assert.Nil(t, err.(WaitError).Signal)
+	}
+	assert.Equal(t, 255, w.ExitCode())
+}
+
 func Test_Echo(t *testing.T) {
 	cmd := exec.Command("php", "tests/client.php", "echo", "pipes")
 
@@ -71,6 +223,189 @@ func Test_Echo(t *testing.T) {
 	assert.Equal(t, "hello", res.String())
 }
 
+func Test_ExecStream(t *testing.T) {
+	cmd := exec.Command("php", "tests/client.php", "stream", "pipes")
+
+	w, _ := NewPipeFactory().SpawnWorker(cmd)
+	go func() {
+		assert.NoError(t, w.Wait())
+	}()
+	defer func() {
+		err := w.Stop()
+		if err != nil {
+			t.Errorf("error stopping the worker: error %v", err)
+		}
+	}()
+
+	var chunks []string
+	_, err := w.ExecStream(&Payload{Body: []byte("hello world")}, func(chunk []byte) error {
+		chunks = append(chunks, string(chunk))
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"hel", "lo ", "wor", "ld"}, chunks)
+	assert.Equal(t, StateReady, w.State().Value())
+}
+
+// streamWorker returns started worker and the connection of its fake relay, task frames sent to
+// the worker are skipped.
+func streamWorker(t *testing.T) (*Worker, goridge.Relay) {
+	w, _ := newWorker(exec.Command("sleep", "10"))
+	assert.NoError(t, w.start())
+
+	conn, wConn := connPair(t)
+	w.rl = goridge.NewSocketRelay(conn)
+	w.state.set(StateReady)
+
+	rl := goridge.NewSocketRelay(wConn)
+	go func() {
+		// stream command, context and body
+		for i := 0; i < 3; i++ {
+			if _, _, err := rl.Receive(); err != nil {
+				return
+			}
+		}
+	}()
+
+	return w, rl
+}
+
+func Test_ExecStream_JobError(t *testing.T) {
+	w, rl := streamWorker(t)
+	defer w.Kill()
+
+	go func() {
+		_ = rl.Send([]byte(`{"type":"export"}`), goridge.PayloadControl)
+		_ = rl.Send([]byte("chunk"), goridge.PayloadRaw)
+		_ = rl.Send([]byte("export failed"), goridge.PayloadControl|goridge.PayloadError)
+	}()
+
+	var chunks []string
+	ctx, err := w.ExecStream(&Payload{Body: []byte("hello")}, func(chunk []byte) error {
+		chunks = append(chunks, string(chunk))
+		return nil
+	})
+
+	assert.Nil(t, ctx)
+	assert.Equal(t, JobError("export failed"), err)
+	assert.Equal(t, []string{"chunk"}, chunks)
+	assert.Equal(t, StateReady, w.State().Value())
+}
+
+func Test_ExecStream_ChunkError(t *testing.T) {
+	w, rl := streamWorker(t)
+
+	go func() {
+		_ = rl.Send(nil, goridge.PayloadControl|goridge.PayloadEmpty)
+		_ = rl.Send([]byte("chunk"), goridge.PayloadRaw)
+		_ = rl.Send([]byte("chunk"), goridge.PayloadRaw)
+	}()
+
+	abort := errors.New("abort")
+	_, err := w.ExecStream(&Payload{Body: []byte("hello")}, func(chunk []byte) error {
+		return abort
+	})
+
+	assert.Equal(t, abort, err)
+	assert.Equal(t, StateErrored, w.State().Value())
+
+	select {
+	case <-w.waitDone:
+	default:
+		t.Fatal("worker is not killed")
+	}
+}
+
+func Test_ExecStream_End(t *testing.T) {
+	w, rl := streamWorker(t)
+	defer w.Kill()
+
+	go func() {
+		_ = rl.Send([]byte(`{"type":"export"}`), goridge.PayloadControl)
+		_ = rl.Send([]byte("a"), goridge.PayloadRaw)
+		_ = rl.Send([]byte("b"), goridge.PayloadRaw)
+		_ = rl.Send([]byte(`{"end":true}`), goridge.PayloadControl)
+	}()
+
+	var chunks []string
+	ctx, err := w.ExecStream(&Payload{Body: []byte("hello")}, func(chunk []byte) error {
+		chunks = append(chunks, string(chunk))
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, `{"type":"export"}`, string(ctx))
+	assert.Equal(t, []string{"a", "b"}, chunks)
+	assert.Equal(t, StateReady, w.State().Value())
+}
+
+func Test_ExecValue(t *testing.T) {
+	cmd := exec.Command("php", "tests/client.php", "echo", "pipes")
+
+	w, err := NewPipeFactory().SpawnWorker(cmd)
+	assert.NoError(t, err)
+	go func() {
+		assert.NoError(t, w.Wait())
+	}()
+	defer w.Stop()
+
+	assert.Equal(t, RawCodec{}, w.Codec())
+
+	var res string
+	_, err = w.ExecValue("hello", &res)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", res)
+}
+
+func Test_ExecValue_JSON(t *testing.T) {
+	cmd := exec.Command("php", "tests/client.php", "json", "pipes")
+
+	f := NewPipeFactory()
+	f.Codec = JSONCodec{}
+
+	w, err := f.SpawnWorker(cmd)
+	assert.NoError(t, err)
+	go func() {
+		assert.NoError(t, w.Wait())
+	}()
+	defer w.Stop()
+
+	type task struct {
+		Name string `json:"name"`
+		Pid  int    `json:"pid"`
+	}
+
+	res := task{}
+	_, err = w.ExecValue(task{Name: "hello"}, &res)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", res.Name)
+	assert.Equal(t, *w.Pid, res.Pid)
+
+	_, err = w.ExecValue(make(chan int), &res)
+	assert.Error(t, err)
+}
+
+func Test_Hijack_Pipes(t *testing.T) {
+	cmd := exec.Command("php", "tests/client.php", "echo", "pipes")
+
+	w, err := NewPipeFactory().SpawnWorker(cmd)
+	assert.NoError(t, err)
+	go func() {
+		assert.NoError(t, w.Wait())
+	}()
+	defer w.Stop()
+
+	conn, err := w.Hijack()
+	assert.Nil(t, conn)
+	assert.Error(t, err)
+	assert.False(t, w.Hijacked())
+
+	res, err := w.Exec(&Payload{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", res.String())
+}
+
 func Test_BadPayload(t *testing.T) {
 	cmd := exec.Command("php", "tests/client.php", "echo", "pipes")
 
@@ -102,6 +437,31 @@ func Test_NotStarted_String(t *testing.T) {
 	assert.Contains(t, w.String(), "numExecs: 0")
 }
 
+func Test_Start_Snapshot(t *testing.T) {
+	w, _ := newWorker(exec.Command("sleep", "10"))
+	assert.NoError(t, w.start())
+	defer w.Kill()
+
+	snapshot := w.Snapshot()
+	assert.True(t, snapshot.StartDuration > 0)
+	assert.Equal(t, time.Duration(0), snapshot.RelayWaitDuration)
+}
+
+func Test_NotStarted_Snapshot(t *testing.T) {
+	cmd := exec.Command("php", "tests/client.php", "echo", "pipes")
+
+	w, _ := newWorker(cmd)
+	snapshot := w.Snapshot()
+
+	assert.Equal(t, 0, snapshot.Pid)
+	assert.Equal(t, "inactive", snapshot.Status)
+	assert.Equal(t, int64(0), snapshot.NumExecs)
+	assert.Equal(t, w.Created, snapshot.Created)
+	assert.True(t, snapshot.LastUsed.IsZero())
+	assert.Equal(t, 0, snapshot.LastPayloadSize)
+	assert.False(t, snapshot.Busy)
+}
+
 func Test_NotStarted_Exec(t *testing.T) {
 	cmd := exec.Command("php", "tests/client.php", "echo", "pipes")
 
@@ -158,6 +518,40 @@ func Test_Echo_Slow(t *testing.T) {
 	assert.Equal(t, "hello", res.String())
 }
 
+func Test_IsBusy(t *testing.T) {
+	cmd := exec.Command("php", "tests/client.php", "delay", "pipes")
+
+	w, _ := NewPipeFactory().SpawnWorker(cmd)
+	go w.Wait()
+	defer w.Stop()
+
+	assert.False(t, w.IsBusy())
+
+	done := make(chan interface{})
+	go func() {
+		defer close(done)
+		_, err := w.Exec(&Payload{Body: []byte("200")})
+		assert.NoError(t, err)
+	}()
+
+	time.Sleep(time.Millisecond * 100)
+	assert.True(t, w.IsBusy())
+	assert.Equal(t, StateWorking, w.State().Value())
+
+	<-done
+	assert.False(t, w.IsBusy())
+	assert.Equal(t, StateReady, w.State().Value())
+}
+
+func Test_IsBusy_NotStarted(t *testing.T) {
+	w, err := newWorker(exec.Command("php", "tests/client.php", "echo", "pipes"))
+	assert.NoError(t, err)
+	assert.False(t, w.IsBusy())
+
+	w.state.set(StateWorking)
+	assert.True(t, w.IsBusy())
+}
+
 func Test_Broken(t *testing.T) {
 	cmd := exec.Command("php", "tests/client.php", "broken", "pipes")
 
@@ -246,3 +640,347 @@ func Test_NumExecs(t *testing.T) {
 	}
 	assert.Equal(t, int64(3), w.State().NumExecs())
 }
+
+func Test_Snapshot(t *testing.T) {
+	cmd := exec.Command("php", "tests/client.php", "echo", "pipes")
+
+	w, _ := NewPipeFactory().SpawnWorker(cmd)
+	go func() {
+		assert.NoError(t, w.Wait())
+	}()
+	defer func() {
+		err := w.Stop()
+		if err != nil {
+			t.Errorf("error stopping the worker: error %v", err)
+		}
+	}()
+
+	_, err := w.Exec(&Payload{Body: []byte("hello")})
+	if err != nil {
+		t.Errorf("fail to execute payload: error %v", err)
+	}
+
+	snapshot := w.Snapshot()
+	assert.Equal(t, *w.Pid, snapshot.Pid)
+	assert.Equal(t, "ready", snapshot.Status)
+	assert.Equal(t, int64(1), snapshot.NumExecs)
+	assert.False(t, snapshot.LastUsed.IsZero())
+	assert.Equal(t, 5, snapshot.LastPayloadSize)
+	assert.False(t, snapshot.Busy)
+	assert.True(t, snapshot.Age > 0)
+	assert.Equal(t, int64(1), snapshot.LatencyBuckets.Total())
+	assert.True(t, snapshot.StartDuration > 0)
+	assert.True(t, snapshot.RelayWaitDuration > 0)
+}
+
+func Test_MemoryUsage(t *testing.T) {
+	w, _ := newWorker(exec.Command("sleep", "10"))
+
+	_, err := w.MemoryUsage()
+	assert.Error(t, err)
+
+	assert.NoError(t, w.start())
+	go func() {
+		assert.Error(t, w.Wait())
+	}()
+
+	rss, err := w.MemoryUsage()
+	assert.NoError(t, err)
+	assert.NotZero(t, rss)
+
+	assert.NoError(t, w.Kill())
+
+	_, err = w.MemoryUsage()
+	assert.Error(t, err)
+}
+
+// silentRelay never responds to the sent frames.
+type silentRelay struct {
+	closed chan interface{}
+}
+
+func (r *silentRelay) Send(data []byte, flags byte) (err error) {
+	return nil
+}
+
+func (r *silentRelay) Receive() (data []byte, p goridge.Prefix, err error) {
+	<-r.closed
+	return nil, p, errors.New("relay closed")
+}
+
+func (r *silentRelay) Close() error {
+	close(r.closed)
+	return nil
+}
+
+func Test_Ping(t *testing.T) {
+	w, _ := newWorker(exec.Command("php", "tests/client.php", "echo", "pipes"))
+
+	pid := 100
+	w.Pid = &pid
+	w.rl = &relayMock{payload: "{\"pid\":100}"}
+	w.state.set(StateReady)
+
+	assert.NoError(t, w.Ping())
+	assert.Equal(t, StateReady, w.State().Value())
+
+	w.rl = &relayMock{payload: "{\"pid\":101}"}
+	assert.Error(t, w.Ping())
+	assert.Equal(t, StateErrored, w.State().Value())
+}
+
+func Test_Ping_Timeout(t *testing.T) {
+	w, _ := newWorker(exec.Command("php", "tests/client.php", "echo", "pipes"))
+
+	pid := 100
+	rl := &silentRelay{closed: make(chan interface{})}
+	defer rl.Close()
+
+	w.Pid = &pid
+	w.rl = rl
+	w.state.set(StateReady)
+
+	err := w.Ping()
+	assert.Error(t, err)
+	assert.Equal(t, "ping timeout", err.Error())
+	assert.Equal(t, StateErrored, w.State().Value())
+}
+
+func Test_ExecWithTimeout(t *testing.T) {
+	w, _ := newWorker(exec.Command("sleep", "10"))
+	assert.NoError(t, w.start())
+
+	w.rl = &silentRelay{closed: make(chan interface{})}
+	w.state.set(StateReady)
+
+	go func() {
+		assert.Error(t, w.Wait())
+	}()
+
+	res, err := w.ExecWithTimeout(&Payload{Body: []byte("hello")}, time.Millisecond*100)
+	assert.Nil(t, res)
+	assert.Equal(t, ErrExecTimeout, err)
+
+	<-w.waitDone
+	assert.Equal(t, int64(1), w.State().NumExecs())
+}
+
+func Test_ExecWithTimeout_Echo(t *testing.T) {
+	cmd := exec.Command("php", "tests/client.php", "echo", "pipes")
+
+	w, _ := NewPipeFactory().SpawnWorker(cmd)
+	go func() {
+		assert.NoError(t, w.Wait())
+	}()
+	defer func() {
+		err := w.Stop()
+		if err != nil {
+			t.Errorf("error stopping the worker: error %v", err)
+		}
+	}()
+
+	res, err := w.ExecWithTimeout(&Payload{Body: []byte("hello")}, time.Second)
+
+	assert.Nil(t, err)
+	assert.NotNil(t, res)
+	assert.Equal(t, "hello", res.String())
+}
+
+// cancelRelay responds with the error frame once cancel command is received.
+type cancelRelay struct {
+	cancel chan interface{}
+}
+
+func (r *cancelRelay) Send(data []byte, flags byte) (err error) {
+	if string(data) == `{"cancel":true}` {
+		close(r.cancel)
+	}
+	return nil
+}
+
+func (r *cancelRelay) Receive() (data []byte, p goridge.Prefix, err error) {
+	<-r.cancel
+	p = p.WithFlags(goridge.PayloadControl | goridge.PayloadRaw | goridge.PayloadError)
+	return []byte("canceled"), p.WithSize(8), nil
+}
+
+func (r *cancelRelay) Close() error {
+	return nil
+}
+
+func Test_Exec_RequestID(t *testing.T) {
+	w, _ := newWorker(exec.Command("sleep", "10"))
+	assert.NoError(t, w.start())
+	defer w.Kill()
+
+	w.rl = &relayMock{error: true}
+	w.state.set(StateReady)
+
+	res, err := w.Exec(&Payload{Body: []byte("hello"), RequestID: "abc"})
+	assert.Nil(t, res)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "request abc")
+
+	// failed task is kept
+	assert.Equal(t, "abc", w.RequestID())
+}
+
+func Test_ExecContext(t *testing.T) {
+	w, _ := newWorker(exec.Command("sleep", "10"))
+	assert.NoError(t, w.start())
+
+	w.rl = &silentRelay{closed: make(chan interface{})}
+	w.state.set(StateReady)
+
+	go func() {
+		assert.Error(t, w.Wait())
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+
+	res, err := w.ExecContext(ctx, &Payload{Body: []byte("hello")})
+	assert.Nil(t, res)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	<-w.waitDone
+	assert.Equal(t, int64(1), w.State().NumExecs())
+}
+
+func Test_ExecContext_Cancel(t *testing.T) {
+	w, _ := newWorker(exec.Command("sleep", "10"))
+	assert.NoError(t, w.start())
+	defer w.Kill()
+
+	w.rl = &cancelRelay{cancel: make(chan interface{})}
+	w.meta = map[string]string{CapabilityCancel: "true"}
+	w.state.set(StateReady)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(time.Millisecond*100, cancel)
+
+	res, err := w.ExecContext(ctx, &Payload{Body: []byte("hello")})
+	assert.Nil(t, res)
+	assert.Equal(t, context.Canceled, err)
+
+	assert.Equal(t, StateReady, w.State().Value())
+	assert.Equal(t, int64(1), w.State().NumExecs())
+}
+
+func Test_ExecContext_Echo(t *testing.T) {
+	cmd := exec.Command("php", "tests/client.php", "echo", "pipes")
+
+	w, _ := NewPipeFactory().SpawnWorker(cmd)
+	go func() {
+		assert.NoError(t, w.Wait())
+	}()
+	defer func() {
+		err := w.Stop()
+		if err != nil {
+			t.Errorf("error stopping the worker: error %v", err)
+		}
+	}()
+
+	res, err := w.ExecContext(context.Background(), &Payload{Body: []byte("hello")})
+
+	assert.Nil(t, err)
+	assert.NotNil(t, res)
+	assert.Equal(t, "hello", res.String())
+}
+
+func Test_MaxPayloadSize(t *testing.T) {
+	w, _ := newWorker(exec.Command("php", "tests/client.php", "echo", "pipes"))
+
+	rl := &silentRelay{closed: make(chan interface{})}
+	defer rl.Close()
+
+	w.rl = rl
+	w.state.set(StateReady)
+	w.SetMaxPayloadSize(4)
+
+	res, err := w.Exec(&Payload{Body: []byte("hello")})
+	assert.Nil(t, res)
+	assert.Equal(t, ErrPayloadTooLarge, err)
+
+	res, err = w.ExecWithTimeout(&Payload{Context: []byte("hello")}, time.Second)
+	assert.Nil(t, res)
+	assert.Equal(t, ErrPayloadTooLarge, err)
+
+	assert.Equal(t, StateReady, w.State().Value())
+	assert.Equal(t, int64(0), w.State().NumExecs())
+}
+
+func Test_MaxPayloadSize_Response(t *testing.T) {
+	cmd := exec.Command("php", "tests/client.php", "pid", "pipes")
+
+	w, err := NewPipeFactory().SpawnWorker(cmd)
+	assert.NoError(t, err)
+
+	w.SetMaxPayloadSize(2)
+
+	res, err := w.Exec(&Payload{Body: []byte("x")})
+	assert.Nil(t, res)
+	assert.Equal(t, ErrPayloadTooLarge, errors.Cause(err))
+
+	// oversized frame leaves relay stream in undefined state
+	assert.Error(t, w.Wait())
+}
+
+func Test_MaxResponseFrames(t *testing.T) {
+	w := relayWorker(t, func(rl goridge.Relay) {
+		// call flood, results are read only once flood is sent
+		for i := 0; i < 100; i++ {
+			if sendControl(rl, callCommand{Call: "noop"}) != nil || rl.Send(nil, goridge.PayloadRaw) != nil {
+				return
+			}
+		}
+
+		for {
+			if _, _, err := rl.Receive(); err != nil {
+				return
+			}
+		}
+	})
+
+	w.handlers = &handlers{}
+	w.handlers.register("noop", func(payload []byte) ([]byte, error) {
+		return nil, nil
+	})
+	w.SetMaxResponseFrames(10)
+
+	res, err := w.Exec(&Payload{Body: []byte("hello")})
+	assert.Nil(t, res)
+	assert.Equal(t, ErrTooManyFrames, errors.Cause(err))
+	assert.True(t, errors.Is(err, ErrRelayProtocol))
+	assert.Equal(t, RecycleProtocolViolation, execReason(err))
+
+	// excess frames leave relay stream in undefined state
+	assert.Error(t, w.Wait())
+}
+
+func Test_MaxResponseFrames_Response(t *testing.T) {
+	w := relayWorker(t, func(rl goridge.Relay) {
+		_ = rl.Send([]byte("{}"), goridge.PayloadControl)
+		_ = rl.Send([]byte("hello"), goridge.PayloadRaw)
+
+		// limit applies to every task separately
+		for i := 0; i < 2; i++ {
+			if _, _, err := rl.Receive(); err != nil {
+				return
+			}
+		}
+
+		_ = rl.Send([]byte("{}"), goridge.PayloadControl)
+		_ = rl.Send([]byte("hello"), goridge.PayloadRaw)
+	})
+	defer w.Kill()
+
+	// context and body
+	w.SetMaxResponseFrames(2)
+
+	for i := 0; i < 2; i++ {
+		res, err := w.Exec(&Payload{Body: []byte("hello")})
+		assert.NoError(t, err)
+		assert.Equal(t, "hello", res.String())
+	}
+}